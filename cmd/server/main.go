@@ -5,18 +5,43 @@ import (
 	"database/sql"
 	"flag"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"time"
 
+	"github.com/garaekz/gonvelope/internal/apikey"
+	"github.com/garaekz/gonvelope/internal/attachment"
+	"github.com/garaekz/gonvelope/internal/audit"
+	"github.com/garaekz/gonvelope/internal/auditlog"
 	"github.com/garaekz/gonvelope/internal/auth"
 	"github.com/garaekz/gonvelope/internal/config"
+	"github.com/garaekz/gonvelope/internal/contact"
+	"github.com/garaekz/gonvelope/internal/dkimkey"
 	"github.com/garaekz/gonvelope/internal/errors"
 	"github.com/garaekz/gonvelope/internal/healthcheck"
+	"github.com/garaekz/gonvelope/internal/inbound"
+	"github.com/garaekz/gonvelope/internal/mailbox"
+	"github.com/garaekz/gonvelope/internal/message"
 	"github.com/garaekz/gonvelope/internal/oauth"
+	"github.com/garaekz/gonvelope/internal/org"
+	"github.com/garaekz/gonvelope/internal/provider"
+	"github.com/garaekz/gonvelope/internal/quota"
+	"github.com/garaekz/gonvelope/internal/ratelimit"
+	"github.com/garaekz/gonvelope/internal/senderdomain"
+	"github.com/garaekz/gonvelope/internal/stats"
+	"github.com/garaekz/gonvelope/internal/suppression"
+	"github.com/garaekz/gonvelope/internal/template"
+	"github.com/garaekz/gonvelope/internal/tracking"
+	"github.com/garaekz/gonvelope/internal/webhook"
 	"github.com/garaekz/gonvelope/pkg/accesslog"
+	"github.com/garaekz/gonvelope/pkg/blob"
+	"github.com/garaekz/gonvelope/pkg/clientip"
 	"github.com/garaekz/gonvelope/pkg/dbcontext"
+	"github.com/garaekz/gonvelope/pkg/dkim"
 	"github.com/garaekz/gonvelope/pkg/log"
+	"github.com/garaekz/gonvelope/pkg/mailer"
+	"github.com/garaekz/gonvelope/pkg/password"
 	routing "github.com/garaekz/ozzo-routing"
 	"github.com/garaekz/ozzo-routing/content"
 	"github.com/garaekz/ozzo-routing/cors"
@@ -56,11 +81,34 @@ func main() {
 		}
 	}()
 
+	// open the storage of the files attached to messages
+	blobStore, err := newBlobStore(cfg.Attachments)
+	if err != nil {
+		logger.Errorf("failed to open the attachment storage: %s", err)
+		os.Exit(-1)
+	}
+
+	// start the worker delivering queued messages, the poller watching mailboxes for bounces and replies,
+	// the collector deleting unused attachments and the dispatcher delivering webhook events
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go buildWorker(logger, dbcontext.New(db), cfg, blobStore).Run(ctx)
+	go buildPoller(logger, dbcontext.New(db), cfg, blobStore).Run(ctx)
+	ttl := time.Duration(cfg.Attachments.TTL) * time.Hour
+	go attachment.NewCollector(newAttachmentService(cfg.Attachments, dbcontext.New(db), blobStore, logger), ttl, logger).Run(ctx)
+	go webhook.NewDispatcher(webhook.NewRepository(dbcontext.New(db), logger), nil, logger).Run(ctx)
+	// accounts linked before their address was recorded can only send from verified domains until it is looked up
+	go func() {
+		if err := newOAuthService(cfg, dbcontext.New(db), logger).BackfillEmails(ctx); err != nil {
+			logger.Errorf("failed to look up the addresses of the linked accounts: %s", err)
+		}
+	}()
+
 	// build HTTP server
 	address := fmt.Sprintf(":%v", cfg.ServerPort)
 	hs := &http.Server{
 		Addr:    address,
-		Handler: buildHandler(logger, dbcontext.New(db), cfg),
+		Handler: buildHandler(logger, dbcontext.New(db), cfg, blobStore),
 	}
 
 	// start the HTTP server with graceful shutdown
@@ -73,12 +121,16 @@ func main() {
 }
 
 // buildHandler sets up the HTTP routing and builds an HTTP handler.
-func buildHandler(logger log.Logger, db *dbcontext.DB, cfg *config.Config) http.Handler {
+func buildHandler(logger log.Logger, db *dbcontext.DB, cfg *config.Config, blobStore blob.Store) http.Handler {
 	router := routing.New()
+	// the trusted proxies were validated along with the configuration
+	resolver, _ := clientip.NewResolver(cfg.TrustedProxies)
 
 	router.Use(
+		clientip.Handler(resolver),
 		accesslog.Handler(logger),
 		errors.Handler(logger),
+		audit.Handler(),
 		content.TypeNegotiator(content.JSON),
 		cors.Handler(cors.AllowAll),
 	)
@@ -87,26 +139,90 @@ func buildHandler(logger log.Logger, db *dbcontext.DB, cfg *config.Config) http.
 
 	rg := router.Group("/api/v1/")
 
-	auth.RegisterHandlers(rg.Group(""),
-		auth.NewService(auth.NewRepository(db, logger), cfg.JWTSigningKey, cfg.JWTExpiration, logger),
+	limiter := newRateLimitStore(cfg.RateLimit, db)
+	// the authentication endpoints are limited per client IP address, the other endpoints per API key or user
+	authLimiter := ratelimit.Handler(limiter, "auth", newRateLimit(cfg.RateLimit.Auth), logger)
+	apiLimiter := ratelimit.Handler(limiter, "api", newRateLimit(cfg.RateLimit.API), logger)
+
+	sender := newMailer(cfg.Mailer, cfg.DKIM.Headers, logger)
+	auditRepo := auditlog.NewRepository(db, logger)
+	auditor := auditlog.NewRecorder(auditRepo, logger)
+	authRepo := auth.NewRepository(db, logger)
+	authJWTHandler := auth.JWTHandler(cfg.JWTSigningKey, authRepo.GetTokenVersion)
+	auth.RegisterHandlers(rg.Group("", authLimiter),
+		auth.NewService(
+			authRepo,
+			db.Transactional,
+			sender,
+			cfg.JWTSigningKey,
+			cfg.JWTExpiration,
+			cfg.AppURL,
+			cfg.PasswordResetURL,
+			cfg.VerificationExpiration,
+			cfg.PasswordResetExpiration,
+			cfg.TOTPIssuer,
+			password.Policy{MinLength: cfg.PasswordPolicy.MinLength, RejectCommon: cfg.PasswordPolicy.RejectCommon},
+			newLockoutPolicy(cfg.LoginThrottle),
+			auditor,
+			logger,
+		),
+		chain(authJWTHandler, apiLimiter),
 		logger,
 	)
 
-	authJWTHandler := auth.JWTHandler(cfg.JWTSigningKey)
-	var providerConfig = oauth.ProviderConfigs{
-		Google:  cfg.GoogleOAuthConfig,
-		Outlook: cfg.OutlookOAuthConfig,
-	}
+	orgRepo := org.NewRepository(db, logger)
+	org.RegisterHandlers(rg.Group(""),
+		org.NewService(orgRepo, db.Transactional, sender, cfg.AppURL, auditor, logger),
+		chain(authJWTHandler, apiLimiter),
+		orgRepo.GetMembership,
+		logger,
+	)
+	// orgHandler makes the organization chosen with the X-Org-ID header the active one
+	orgHandler := org.Handler(orgRepo.GetMembership)
+
+	apiKeyService := apikey.NewService(apikey.NewRepository(db, logger), db.Transactional, auditor, logger)
+	apikey.RegisterHandlers(rg.Group(""), apiKeyService, chain(authJWTHandler, apiLimiter), orgHandler, logger)
+
+	// routes that can be called by backend services accept API keys in addition to JWT tokens
+	authHandler := chain(auth.Handler(authJWTHandler, auth.BearerHandler(apiKeyService.Verify)), apiLimiter)
+
+	template.RegisterHandlers(rg.Group(""), template.NewService(template.NewRepository(db, logger), db.Transactional, auditor, logger), authHandler, orgHandler, logger)
+
+	auditlog.RegisterHandlers(rg.Group(""), auditlog.NewService(auditRepo, logger), chain(authJWTHandler, apiLimiter), orgHandler, logger)
+
+	suppressionService := suppression.NewService(suppression.NewRepository(db, logger), db.Transactional, cfg.JWTSigningKey, cfg.AppURL, auditor, logger)
+	suppression.RegisterHandlers(rg.Group(""), suppressionService, authHandler, orgHandler, logger)
+
+	attachment.RegisterHandlers(rg.Group(""), newAttachmentService(cfg.Attachments, db, blobStore, logger), authHandler, orgHandler, logger)
+
+	contactService := contact.NewService(contact.NewRepository(db, logger), db.Transactional, auditor, logger)
+	contact.RegisterHandlers(rg.Group(""), contactService, authHandler, orgHandler, logger)
+
+	dkimKeyService := newDKIMKeyService(cfg, db, logger)
+	dkimkey.RegisterHandlers(rg.Group(""), dkimKeyService, authHandler, orgHandler, logger)
+
+	senderDomainService := senderdomain.NewService(senderdomain.NewRepository(db, logger), db.Transactional, net.DefaultResolver, dkimKeyService.DomainRecord, auditor, logger)
+	senderdomain.RegisterHandlers(rg.Group(""), senderDomainService, authHandler, orgHandler, logger)
+
+	message.RegisterHandlers(rg.Group(""), message.NewService(message.NewRepository(db, logger), db.Transactional, suppressionService.Suppressed, contactService.Members, senderDomainService.Verified, auditor, logger), authHandler, orgHandler, logger)
+
+	quota.RegisterHandlers(rg.Group(""), newQuotaService(cfg.SendQuotas, db, logger), authHandler, orgHandler, logger)
+
+	stats.RegisterHandlers(rg.Group(""), stats.NewService(stats.NewRepository(db, logger), logger), authHandler, orgHandler, logger)
+
+	webhook.RegisterHandlers(rg.Group(""), newWebhookService(db, logger), authHandler, orgHandler, logger)
+
+	inbound.RegisterHandlers(rg.Group(""), newInboundService(db, blobStore, logger), authHandler, orgHandler, logger)
+
+	// the tracking URLs are requested by the mail clients of the recipients, outside of the API
+	tracking.RegisterHandlers(router.Group("/t/"), newTrackingService(cfg, db, logger), logger)
+
 	store := sessions.NewCookieStore([]byte(cfg.JWTSigningKey))
 	oauth.RegisterHandlers(
 		router.Group("/oauth2/"),
-		oauth.NewService(
-			oauth.NewRepository(db, logger),
-			logger,
-			&providerConfig,
-			cfg.JWTSigningKey,
-		),
-		authJWTHandler,
+		newOAuthService(cfg, db, logger),
+		authHandler,
+		orgHandler,
 		logger,
 		store,
 	)
@@ -114,6 +230,181 @@ func buildHandler(logger log.Logger, db *dbcontext.DB, cfg *config.Config) http.
 	return router
 }
 
+// buildWorker builds the worker delivering queued messages through the linked provider accounts
+// and rolling up the message statistics.
+func buildWorker(logger log.Logger, db *dbcontext.DB, cfg *config.Config, blobStore blob.Store) *message.Worker {
+	providerConfig := newProviderConfigs(cfg)
+	senders := provider.Senders{}
+	if oauthConfig := providerConfig.Config("google"); oauthConfig != nil {
+		senders["google"] = provider.NewGmail(oauthConfig)
+	}
+	if oauthConfig := providerConfig.Config("outlook"); oauthConfig != nil {
+		senders["outlook"] = provider.NewGraph(oauthConfig)
+	}
+	suppressionService := newSuppressionService(cfg, db, logger)
+	attachments := newAttachmentService(cfg.Attachments, db, blobStore, logger).Load
+	track := newTrackingService(cfg, db, logger).Instrument
+	signer := newDKIMKeyService(cfg, db, logger).Signer
+	return message.NewWorker(message.NewRepository(db, logger), newQuotaService(cfg.SendQuotas, db, logger), senders, suppressionService.Suppressed, suppressionService.UnsubscribeURL, attachments, track, signer, logger)
+}
+
+// buildPoller builds the poller reading the linked mailboxes that granted read access for bounces and replies.
+func buildPoller(logger log.Logger, db *dbcontext.DB, cfg *config.Config, blobStore blob.Store) *mailbox.Poller {
+	providerConfig := newProviderConfigs(cfg)
+	readers := provider.Readers{}
+	if oauthConfig := providerConfig.Config("google"); oauthConfig != nil {
+		readers["google"] = provider.NewGmailReader(oauthConfig)
+	}
+	if oauthConfig := providerConfig.Config("outlook"); oauthConfig != nil {
+		readers["outlook"] = provider.NewGraphReader(oauthConfig)
+	}
+	ingest := newInboundService(db, blobStore, logger).Ingest
+	return mailbox.NewPoller(mailbox.NewRepository(db, logger), readers, newSuppressionService(cfg, db, logger).Suppress, ingest, logger)
+}
+
+// newSuppressionService creates the suppression service used by the background jobs.
+func newSuppressionService(cfg *config.Config, db *dbcontext.DB, logger log.Logger) suppression.Service {
+	auditor := auditlog.NewRecorder(auditlog.NewRepository(db, logger), logger)
+	return suppression.NewService(suppression.NewRepository(db, logger), db.Transactional, cfg.JWTSigningKey, cfg.AppURL, auditor, logger)
+}
+
+// newAttachmentService creates the service keeping the files attached to messages in the blob store.
+func newAttachmentService(cfg *config.AttachmentsConfig, db *dbcontext.DB, store blob.Store, logger log.Logger) attachment.Service {
+	auditor := auditlog.NewRecorder(auditlog.NewRepository(db, logger), logger)
+	limits := attachment.Limits{MaxSize: int64(cfg.MaxSize), ContentTypes: cfg.ContentTypes}
+	return attachment.NewService(attachment.NewRepository(db, logger), db.Transactional, store, limits, auditor, logger)
+}
+
+// newWebhookService creates the service managing the webhooks of the organizations and emitting their events.
+func newWebhookService(db *dbcontext.DB, logger log.Logger) webhook.Service {
+	auditor := auditlog.NewRecorder(auditlog.NewRepository(db, logger), logger)
+	return webhook.NewService(webhook.NewRepository(db, logger), db.Transactional, auditor, logger)
+}
+
+// newTrackingService creates the service instrumenting tracked messages and recording their opens and clicks.
+func newTrackingService(cfg *config.Config, db *dbcontext.DB, logger log.Logger) tracking.Service {
+	emit := newWebhookService(db, logger).Emit
+	return tracking.NewService(tracking.NewRepository(db, logger), db.Transactional, emit, cfg.JWTSigningKey, cfg.AppURL, cfg.Tracking.IPAddresses, logger)
+}
+
+// newInboundService creates the service ingesting the messages received by the organizations.
+func newInboundService(db *dbcontext.DB, store blob.Store, logger log.Logger) inbound.Service {
+	auditor := auditlog.NewRecorder(auditlog.NewRepository(db, logger), logger)
+	emit := newWebhookService(db, logger).Emit
+	return inbound.NewService(inbound.NewRepository(db, logger), db.Transactional, store, emit, auditor, logger)
+}
+
+// newDKIMKeyService creates the service managing the keys the messages of the organizations are DKIM-signed with.
+func newDKIMKeyService(cfg *config.Config, db *dbcontext.DB, logger log.Logger) dkimkey.Service {
+	auditor := auditlog.NewRecorder(auditlog.NewRepository(db, logger), logger)
+	return dkimkey.NewService(dkimkey.NewRepository(db, logger), db.Transactional, cfg.DKIM.Headers, auditor, logger)
+}
+
+// newBlobStore returns the blob store of the configured attachment storage.
+func newBlobStore(cfg *config.AttachmentsConfig) (blob.Store, error) {
+	if cfg.Storage == "s3" {
+		return blob.NewS3(cfg.S3.Endpoint, cfg.S3.Region, cfg.S3.Bucket, cfg.S3.AccessKey, cfg.S3.SecretKey)
+	}
+	return blob.NewFileSystem(cfg.Path)
+}
+
+// newProviderConfigs returns the OAuth configurations of the mailbox providers.
+func newProviderConfigs(cfg *config.Config) oauth.ProviderConfigs {
+	return oauth.ProviderConfigs{
+		Google:  cfg.GoogleOAuthConfig,
+		Outlook: cfg.OutlookOAuthConfig,
+	}
+}
+
+// newOAuthService creates the service linking the mailboxes of the provider accounts.
+func newOAuthService(cfg *config.Config, db *dbcontext.DB, logger log.Logger) oauth.Service {
+	auditor := auditlog.NewRecorder(auditlog.NewRepository(db, logger), logger)
+	providerConfig := newProviderConfigs(cfg)
+	return oauth.NewService(oauth.NewRepository(db, logger), db.Transactional, auditor, logger, &providerConfig, cfg.JWTSigningKey)
+}
+
+// newQuotaService creates the service keeping provider accounts within the configured send quotas.
+func newQuotaService(cfg *config.SendQuotasConfig, db *dbcontext.DB, logger log.Logger) quota.Service {
+	policy := quota.Policy{}
+	if cfg.Google != nil {
+		policy["google"] = quota.Limits{Daily: cfg.Google.Daily, PerMinute: cfg.Google.PerMinute}
+	}
+	if cfg.Outlook != nil {
+		policy["outlook"] = quota.Limits{Daily: cfg.Outlook.Daily, PerMinute: cfg.Outlook.PerMinute}
+	}
+	return quota.NewService(quota.NewRepository(db, logger), db.Transactional, policy, logger)
+}
+
+// newRateLimitStore returns the store keeping the rate limiting token buckets of the configured backend.
+func newRateLimitStore(cfg *config.RateLimitConfig, db *dbcontext.DB) ratelimit.Store {
+	if cfg.Backend == "postgres" {
+		return ratelimit.NewPostgresStore(db)
+	}
+	return ratelimit.NewMemoryStore()
+}
+
+// newRateLimit converts a rate limit rule of the configuration into a token bucket limit.
+func newRateLimit(rule *config.RateLimitRule) ratelimit.Limit {
+	if rule == nil {
+		return ratelimit.Limit{}
+	}
+	return ratelimit.Limit{
+		Requests: rule.Requests,
+		Period:   time.Duration(rule.Period) * time.Second,
+		Burst:    rule.Burst,
+	}
+}
+
+// chain returns a handler that calls the given handlers in order and stops at the first error.
+func chain(handlers ...routing.Handler) routing.Handler {
+	return func(c *routing.Context) error {
+		for _, handler := range handlers {
+			if err := handler(c); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// newLockoutPolicy converts the login throttling configuration into a lockout policy.
+func newLockoutPolicy(cfg *config.LoginThrottleConfig) auth.LockoutPolicy {
+	return auth.LockoutPolicy{
+		FreeAttempts:    cfg.FreeAttempts,
+		Delay:           time.Duration(cfg.Delay) * time.Second,
+		MaxDelay:        time.Duration(cfg.MaxDelay) * time.Second,
+		MaxFailures:     cfg.MaxFailures,
+		IPMaxFailures:   cfg.IPMaxFailures,
+		LockoutDuration: time.Duration(cfg.LockoutDuration) * time.Minute,
+		FailureWindow:   time.Duration(cfg.FailureWindow) * time.Hour,
+	}
+}
+
+// newMailer creates the mailer used to send system emails according to the given configuration.
+// The emails sent through SMTP are DKIM-signed, signing the given headers, when a key is configured.
+func newMailer(cfg *config.MailerConfig, headers []string, logger log.Logger) mailer.Mailer {
+	if cfg.Driver == "smtp" {
+		return mailer.NewSMTP(cfg.Host, cfg.Port, cfg.Username, cfg.Password, cfg.From, cfg.FromName, newSystemSigner(cfg, headers, logger))
+	}
+	return mailer.NewSandbox(cfg.From, cfg.FromName, logger)
+}
+
+// newSystemSigner returns the DKIM signer of the system emails, or nil if they are not signed.
+func newSystemSigner(cfg *config.MailerConfig, headers []string, logger log.Logger) *dkim.Signer {
+	if cfg.DKIMPrivateKey == "" {
+		return nil
+	}
+	key, err := dkim.ParsePrivateKey([]byte(cfg.DKIMPrivateKey))
+	if err == nil {
+		var signer *dkim.Signer
+		if signer, err = dkim.NewSigner(cfg.DKIMDomain, cfg.DKIMSelector, key, headers); err == nil {
+			return signer
+		}
+	}
+	logger.Errorf("system emails will not be DKIM-signed: %v", err)
+	return nil
+}
+
 // logDBQuery returns a logging function that can be used to log SQL queries.
 func logDBQuery(logger log.Logger) dbx.QueryLogFunc {
 	return func(ctx context.Context, t time.Duration, sql string, _ *sql.Rows, err error) {
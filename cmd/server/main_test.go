@@ -6,8 +6,13 @@ import (
 	"testing"
 	"time"
 
+	"github.com/garaekz/gonvelope/internal/config"
+	"github.com/garaekz/gonvelope/pkg/blob"
+	"github.com/garaekz/gonvelope/pkg/dkim"
 	"github.com/garaekz/gonvelope/pkg/log"
+	"github.com/garaekz/gonvelope/pkg/mailer"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func Test_logDBQuery(t *testing.T) {
@@ -39,3 +44,39 @@ func Test_logDBExec(t *testing.T) {
 		assert.Equal(t, "DB execution error: test", entries.All()[0].Message)
 	}
 }
+
+func Test_newMailer(t *testing.T) {
+	logger, _ := log.NewForTest()
+	_, ok := newMailer(&config.MailerConfig{Driver: "sandbox"}, dkim.DefaultHeaders, logger).(*mailer.Sandbox)
+	assert.True(t, ok)
+	_, ok = newMailer(&config.MailerConfig{Driver: "smtp", Host: "localhost", Port: 25}, dkim.DefaultHeaders, logger).(*mailer.SMTP)
+	assert.True(t, ok)
+}
+
+func Test_newSystemSigner(t *testing.T) {
+	logger, entries := log.NewForTest()
+	assert.Nil(t, newSystemSigner(&config.MailerConfig{}, dkim.DefaultHeaders, logger))
+	key, err := dkim.GenerateKey(dkim.AlgorithmEd25519)
+	require.Nil(t, err)
+	pem, err := dkim.MarshalPrivateKey(key)
+	require.Nil(t, err)
+	signer := newSystemSigner(&config.MailerConfig{DKIMDomain: "example.com", DKIMSelector: "s1", DKIMPrivateKey: string(pem)}, dkim.DefaultHeaders, logger)
+	if assert.NotNil(t, signer) {
+		assert.Equal(t, "example.com", signer.Domain())
+	}
+	assert.Nil(t, newSystemSigner(&config.MailerConfig{DKIMDomain: "example.com", DKIMPrivateKey: string(pem)}, dkim.DefaultHeaders, logger))
+	assert.Equal(t, 1, entries.Len())
+}
+
+func Test_newBlobStore(t *testing.T) {
+	store, err := newBlobStore(&config.AttachmentsConfig{Storage: "filesystem", Path: t.TempDir()})
+	if assert.Nil(t, err) {
+		_, ok := store.(*blob.FileSystem)
+		assert.True(t, ok)
+	}
+	store, err = newBlobStore(&config.AttachmentsConfig{Storage: "s3", S3: &config.S3Config{Endpoint: "http://localhost:9000", Region: "us-east-1", Bucket: "attachments"}})
+	if assert.Nil(t, err) {
+		_, ok := store.(*blob.S3)
+		assert.True(t, ok)
+	}
+}
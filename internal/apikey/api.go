@@ -0,0 +1,100 @@
+package apikey
+
+import (
+	"net/http"
+
+	"github.com/garaekz/gonvelope/internal/auth"
+	"github.com/garaekz/gonvelope/internal/entity"
+	"github.com/garaekz/gonvelope/internal/errors"
+	"github.com/garaekz/gonvelope/pkg/log"
+	"github.com/garaekz/gonvelope/pkg/pagination"
+	routing "github.com/garaekz/ozzo-routing"
+)
+
+// RegisterHandlers sets up the routing of the HTTP handlers.
+// API keys can only be managed with an interactive session, so authHandler should not accept API keys.
+// Keys are created in the organization made active by orgHandler.
+func RegisterHandlers(r *routing.RouteGroup, service Service, authHandler, orgHandler routing.Handler, logger log.Logger) {
+	res := resource{service, logger}
+
+	r.Use(authHandler, orgHandler)
+
+	r.Get("api-keys", res.query)
+	r.Get("api-keys/<id>", res.get)
+	r.Post("api-keys", auth.Require(auth.PermissionAPIKeysWrite), res.create)
+	r.Put("api-keys/<id>", auth.Require(auth.PermissionAPIKeysWrite), res.update)
+	r.Delete("api-keys/<id>", auth.Require(auth.PermissionAPIKeysWrite), res.delete)
+}
+
+type resource struct {
+	service Service
+	logger  log.Logger
+}
+
+func (r resource) get(c *routing.Context) error {
+	owner := currentOwner(c)
+	key, err := r.service.Get(c.Request.Context(), owner.OrgID, owner.UserID, c.Param("id"))
+	if err != nil {
+		return err
+	}
+	return c.Write(key)
+}
+
+func (r resource) query(c *routing.Context) error {
+	ctx := c.Request.Context()
+	owner := currentOwner(c)
+	count, err := r.service.Count(ctx, owner.OrgID, owner.UserID)
+	if err != nil {
+		return err
+	}
+	pages := pagination.NewFromRequest(c.Request, count)
+	keys, err := r.service.Query(ctx, owner.OrgID, owner.UserID, pages.Offset(), pages.Limit())
+	if err != nil {
+		return err
+	}
+	pages.Items = keys
+	return c.Write(pages)
+}
+
+func (r resource) create(c *routing.Context) error {
+	var input CreateAPIKeyRequest
+	if err := c.Read(&input); err != nil {
+		r.logger.With(c.Request.Context()).Info(err)
+		return errors.BadRequest("")
+	}
+	owner := currentOwner(c)
+	key, err := r.service.Create(c.Request.Context(), owner.OrgID, owner.UserID, input)
+	if err != nil {
+		return err
+	}
+	return c.WriteWithStatus(key, http.StatusCreated)
+}
+
+func (r resource) update(c *routing.Context) error {
+	var input UpdateAPIKeyRequest
+	if err := c.Read(&input); err != nil {
+		r.logger.With(c.Request.Context()).Info(err)
+		return errors.BadRequest("")
+	}
+	owner := currentOwner(c)
+	key, err := r.service.Update(c.Request.Context(), owner.OrgID, owner.UserID, c.Param("id"), input)
+	if err != nil {
+		return err
+	}
+	return c.Write(key)
+}
+
+func (r resource) delete(c *routing.Context) error {
+	owner := currentOwner(c)
+	key, err := r.service.Delete(c.Request.Context(), owner.OrgID, owner.UserID, c.Param("id"))
+	if err != nil {
+		return err
+	}
+	return c.Write(key)
+}
+
+// currentOwner returns the membership of the current user in the active organization.
+func currentOwner(c *routing.Context) entity.Membership {
+	membership, _ := auth.CurrentMembership(c.Request.Context())
+	return membership
+}
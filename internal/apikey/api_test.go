@@ -0,0 +1,79 @@
+package apikey
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/garaekz/gonvelope/internal/audit"
+	"github.com/garaekz/gonvelope/internal/auth"
+	"github.com/garaekz/gonvelope/internal/entity"
+	"github.com/garaekz/gonvelope/internal/org"
+	"github.com/garaekz/gonvelope/internal/test"
+	"github.com/garaekz/gonvelope/pkg/log"
+)
+
+func TestAPI(t *testing.T) {
+	logger, _ := log.NewForTest()
+	router := test.MockRouter(logger)
+	now := time.Now()
+	repo := &mockRepository{items: []entity.APIKey{
+		{ID: "123", OrgID: "100", UserID: "100", Name: "backend", Prefix: "gnv_abc", KeyHash: "hash", Scopes: []string{auth.ScopeMessagesSend}, AllowedIPs: []string{}, CreatedAt: &now, UpdatedAt: &now},
+		{ID: "456", OrgID: "100", UserID: "200", Name: "other", Prefix: "gnv_def", KeyHash: "hash", Scopes: []string{auth.ScopeMessagesSend}, AllowedIPs: []string{}, CreatedAt: &now, UpdatedAt: &now},
+		{ID: "789", OrgID: "org1", UserID: "100", Name: "team", Prefix: "gnv_ghi", KeyHash: "hash", Scopes: []string{auth.ScopeMessagesSend}, AllowedIPs: []string{}, CreatedAt: &now, UpdatedAt: &now},
+	}}
+	RegisterHandlers(router.Group("/"), NewService(repo, mockTransactional, audit.NewLogRecorder(logger), logger), auth.MockAuthHandler, org.MockHandler, logger)
+	header := auth.MockAuthHeader()
+	orgHeader := auth.MockAuthHeader()
+	orgHeader.Set(org.HeaderOrgID, "org1")
+
+	tests := []test.APITestCase{
+		{Name: "get all", Method: "GET", URL: "/api-keys", Body: "", Header: header, WantStatus: http.StatusOK, WantResponse: `*"total_count":1*`},
+		{Name: "get 123", Method: "GET", URL: "/api-keys/123", Body: "", Header: header, WantStatus: http.StatusOK, WantResponse: `*"prefix":"gnv_abc"*`},
+		{Name: "get other org", Method: "GET", URL: "/api-keys/789", Body: "", Header: header, WantStatus: http.StatusNotFound, WantResponse: ""},
+		{Name: "get org1", Method: "GET", URL: "/api-keys/789", Body: "", Header: orgHeader, WantStatus: http.StatusOK, WantResponse: `*"org_id":"org1"*`},
+		{Name: "get other user", Method: "GET", URL: "/api-keys/456", Body: "", Header: header, WantStatus: http.StatusNotFound, WantResponse: ""},
+		{Name: "create ok", Method: "POST", URL: "/api-keys", Body: `{"name":"test","scopes":["messages:send"]}`, Header: header, WantStatus: http.StatusCreated, WantResponse: `*"key":"gnv_*`},
+		{Name: "create verify", Method: "GET", URL: "/api-keys", Body: "", Header: header, WantStatus: http.StatusOK, WantResponse: `*"total_count":2*`},
+		{Name: "create input error", Method: "POST", URL: "/api-keys", Body: `"name":"test"}`, Header: header, WantStatus: http.StatusBadRequest, WantResponse: ""},
+		{Name: "create invalid scope", Method: "POST", URL: "/api-keys", Body: `{"name":"test","scopes":["admin"]}`, Header: header, WantStatus: http.StatusBadRequest, WantResponse: `*scopes*`},
+		{Name: "update ok", Method: "PUT", URL: "/api-keys/123", Body: `{"name":"renamed","scopes":["templates:read"]}`, Header: header, WantStatus: http.StatusOK, WantResponse: `*"name":"renamed"*`},
+		{Name: "update hides hash", Method: "GET", URL: "/api-keys/123", Body: "", Header: header, WantStatus: http.StatusOK, WantResponse: `*"scopes":["templates:read"]*`},
+		{Name: "delete ok", Method: "DELETE", URL: "/api-keys/123", Body: ``, Header: header, WantStatus: http.StatusOK, WantResponse: `*renamed*`},
+		{Name: "delete verify", Method: "DELETE", URL: "/api-keys/123", Body: ``, Header: header, WantStatus: http.StatusNotFound, WantResponse: ""},
+		{Name: "unauthorized", Method: "GET", URL: "/api-keys", Body: "", Header: nil, WantStatus: http.StatusUnauthorized, WantResponse: ""},
+	}
+	for _, tc := range tests {
+		test.Endpoint(t, router, tc)
+	}
+}
+
+func TestAPI_roles(t *testing.T) {
+	logger, _ := log.NewForTest()
+	router := test.MockRouter(logger)
+	RegisterHandlers(router.Group("/"), NewService(&mockRepository{}, mockTransactional, audit.NewLogRecorder(logger), logger), auth.MockAuthHandler, org.MockHandler, logger)
+	body := `{"name":"test","scopes":["templates:read"]}`
+
+	// updating and deleting an unknown key tells whether the permission check passed
+	tests := []struct {
+		role                         string
+		list, create, update, delete int
+	}{
+		{entity.RoleOwner, http.StatusOK, http.StatusCreated, http.StatusNotFound, http.StatusNotFound},
+		{entity.RoleAdmin, http.StatusOK, http.StatusCreated, http.StatusNotFound, http.StatusNotFound},
+		{entity.RoleEditor, http.StatusOK, http.StatusCreated, http.StatusNotFound, http.StatusNotFound},
+		{entity.RoleSender, http.StatusOK, http.StatusCreated, http.StatusNotFound, http.StatusNotFound},
+		{entity.RoleViewer, http.StatusOK, http.StatusForbidden, http.StatusForbidden, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		header := org.MockHeader(tt.role)
+		for _, tc := range []test.APITestCase{
+			{Name: tt.role + " list", Method: "GET", URL: "/api-keys", Header: header, WantStatus: tt.list},
+			{Name: tt.role + " create", Method: "POST", URL: "/api-keys", Body: body, Header: header, WantStatus: tt.create},
+			{Name: tt.role + " update", Method: "PUT", URL: "/api-keys/unknown", Body: body, Header: header, WantStatus: tt.update},
+			{Name: tt.role + " delete", Method: "DELETE", URL: "/api-keys/unknown", Header: header, WantStatus: tt.delete},
+		} {
+			test.Endpoint(t, router, tc)
+		}
+	}
+}
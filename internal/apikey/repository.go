@@ -0,0 +1,114 @@
+package apikey
+
+import (
+	"context"
+	"time"
+
+	"github.com/garaekz/gonvelope/internal/entity"
+	"github.com/garaekz/gonvelope/pkg/dbcontext"
+	"github.com/garaekz/gonvelope/pkg/log"
+	dbx "github.com/go-ozzo/ozzo-dbx"
+)
+
+// Repository encapsulates the logic to access API keys from the data source.
+type Repository interface {
+	// Get returns the API key with the specified ID owned by the user in the organization.
+	Get(ctx context.Context, orgID, userID, id string) (entity.APIKey, error)
+	// Count returns the number of API keys owned by the user in the organization.
+	Count(ctx context.Context, orgID, userID string) (int, error)
+	// Query returns the list of API keys owned by the user in the organization with the given offset and limit.
+	Query(ctx context.Context, orgID, userID string, offset, limit int) ([]entity.APIKey, error)
+	// Create saves a new API key in the storage.
+	Create(ctx context.Context, key entity.APIKey) error
+	// Update updates the API key with given ID in the storage.
+	Update(ctx context.Context, key entity.APIKey) error
+	// Delete removes the API key with given ID owned by the user in the organization from the storage.
+	Delete(ctx context.Context, orgID, userID, id string) error
+	// GetByPrefix returns the API key with the specified prefix.
+	GetByPrefix(ctx context.Context, prefix string) (entity.APIKey, error)
+	// GetActiveMember returns the active user with the specified ID if the user is a member of the organization.
+	GetActiveMember(ctx context.Context, orgID, userID string) (entity.User, error)
+	// TouchLastUsed records the last time the API key was used.
+	TouchLastUsed(ctx context.Context, id string, at time.Time) error
+}
+
+// repository persists API keys in database
+type repository struct {
+	db     *dbcontext.DB
+	logger log.Logger
+}
+
+// NewRepository creates a new API key repository
+func NewRepository(db *dbcontext.DB, logger log.Logger) Repository {
+	return repository{db, logger}
+}
+
+// Get returns the API key with the specified ID owned by the user in the organization.
+func (r repository) Get(ctx context.Context, orgID, userID, id string) (entity.APIKey, error) {
+	var key entity.APIKey
+	err := r.db.With(ctx).Select().From(key.TableName()).
+		Where(dbx.HashExp{"id": id, "org_id": orgID, "user_id": userID}).One(&key)
+	return key, err
+}
+
+// Count returns the number of API keys owned by the user in the organization.
+func (r repository) Count(ctx context.Context, orgID, userID string) (int, error) {
+	var count int
+	err := r.db.With(ctx).Select("COUNT(*)").From(entity.APIKey{}.TableName()).
+		Where(dbx.HashExp{"org_id": orgID, "user_id": userID}).Row(&count)
+	return count, err
+}
+
+// Query returns the list of API keys owned by the user in the organization with the given offset and limit.
+func (r repository) Query(ctx context.Context, orgID, userID string, offset, limit int) ([]entity.APIKey, error) {
+	var keys []entity.APIKey
+	err := r.db.With(ctx).Select().From(entity.APIKey{}.TableName()).
+		Where(dbx.HashExp{"org_id": orgID, "user_id": userID}).
+		OrderBy("created_at DESC").
+		Offset(int64(offset)).
+		Limit(int64(limit)).
+		All(&keys)
+	return keys, err
+}
+
+// Create saves a new API key in the storage.
+func (r repository) Create(ctx context.Context, key entity.APIKey) error {
+	return r.db.With(ctx).Model(&key).Exclude("LastUsedAt", "CreatedAt", "UpdatedAt").Insert()
+}
+
+// Update updates the API key with given ID in the storage.
+func (r repository) Update(ctx context.Context, key entity.APIKey) error {
+	return r.db.With(ctx).Model(&key).Update("Name", "Scopes", "AllowedIPs", "ExpiresAt", "UpdatedAt")
+}
+
+// Delete removes the API key with given ID owned by the user in the organization from the storage.
+func (r repository) Delete(ctx context.Context, orgID, userID, id string) error {
+	key, err := r.Get(ctx, orgID, userID, id)
+	if err != nil {
+		return err
+	}
+	return r.db.With(ctx).Model(&key).Delete()
+}
+
+// GetByPrefix returns the API key with the specified prefix.
+func (r repository) GetByPrefix(ctx context.Context, prefix string) (entity.APIKey, error) {
+	var key entity.APIKey
+	err := r.db.With(ctx).Select().From(key.TableName()).Where(dbx.HashExp{"prefix": prefix}).One(&key)
+	return key, err
+}
+
+// GetActiveMember returns the active user with the specified ID if the user is a member of the organization.
+func (r repository) GetActiveMember(ctx context.Context, orgID, userID string) (entity.User, error) {
+	var user entity.User
+	err := r.db.With(ctx).Select("u.*").From(user.TableName()+" u").
+		InnerJoin("memberships m", dbx.NewExp("m.user_id = u.id")).
+		Where(dbx.HashExp{"u.id": userID, "u.active": true, "m.org_id": orgID}).
+		One(&user)
+	return user, err
+}
+
+// TouchLastUsed records the last time the API key was used.
+func (r repository) TouchLastUsed(ctx context.Context, id string, at time.Time) error {
+	_, err := r.db.With(ctx).Update(entity.APIKey{}.TableName(), dbx.Params{"last_used_at": at}, dbx.HashExp{"id": id}).Execute()
+	return err
+}
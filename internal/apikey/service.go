@@ -0,0 +1,330 @@
+package apikey
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/garaekz/gonvelope/internal/audit"
+	"github.com/garaekz/gonvelope/internal/auth"
+	"github.com/garaekz/gonvelope/internal/entity"
+	"github.com/garaekz/gonvelope/pkg/dbcontext"
+	"github.com/garaekz/gonvelope/pkg/log"
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+)
+
+// lastUsedResolution is how often the last-used timestamp of an API key is refreshed.
+const lastUsedResolution = time.Minute
+
+// ErrInvalidKey is returned when an API key cannot be verified.
+var ErrInvalidKey = errors.New("invalid API key")
+
+// scopes lists the scopes that can be granted to API keys, as expected by validation.In.
+var scopes = func() []interface{} {
+	s := make([]interface{}, len(auth.Scopes))
+	for i, scope := range auth.Scopes {
+		s[i] = scope
+	}
+	return s
+}()
+
+// Service encapsulates usecase logic for API keys.
+type Service interface {
+	Get(ctx context.Context, orgID, userID, id string) (entity.APIKey, error)
+	Query(ctx context.Context, orgID, userID string, offset, limit int) ([]entity.APIKey, error)
+	Count(ctx context.Context, orgID, userID string) (int, error)
+	Create(ctx context.Context, orgID, userID string, input CreateAPIKeyRequest) (CreatedAPIKey, error)
+	Update(ctx context.Context, orgID, userID, id string, input UpdateAPIKeyRequest) (entity.APIKey, error)
+	Delete(ctx context.Context, orgID, userID, id string) (entity.APIKey, error)
+	// Verify checks an API key presented from the given IP address and returns the identity
+	// of its owner, bound to the organization of the key, along with the scopes granted to the key.
+	Verify(ctx context.Context, key, ip string) (auth.Identity, []string, error)
+}
+
+// Identity is the identity of the owner of an API key. It is bound to the organization the key belongs to.
+type Identity struct {
+	entity.User
+	OrgID string
+	KeyID string
+}
+
+// GetOrgID returns the ID of the organization the API key belongs to.
+func (i Identity) GetOrgID() string {
+	return i.OrgID
+}
+
+// GetKeyID returns the ID of the API key.
+func (i Identity) GetKeyID() string {
+	return i.KeyID
+}
+
+// CreatedAPIKey represents a newly created API key. The plain key is only ever returned once.
+type CreatedAPIKey struct {
+	entity.APIKey
+	Key string `json:"key"`
+}
+
+// CreateAPIKeyRequest represents an API key creation request.
+type CreateAPIKeyRequest struct {
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	AllowedIPs []string   `json:"allowed_ips"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+}
+
+// Validate validates the CreateAPIKeyRequest fields.
+func (m CreateAPIKeyRequest) Validate() error {
+	return validation.ValidateStruct(&m,
+		validation.Field(&m.Name, validation.Required, validation.Length(0, 128)),
+		validation.Field(&m.Scopes, validation.Required, validation.Each(validation.In(scopes...))),
+		validation.Field(&m.AllowedIPs, validation.Each(validation.By(validateIPRange))),
+		validation.Field(&m.ExpiresAt, validation.By(validateFuture)),
+	)
+}
+
+// UpdateAPIKeyRequest represents an API key update request.
+type UpdateAPIKeyRequest struct {
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	AllowedIPs []string   `json:"allowed_ips"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+}
+
+// Validate validates the UpdateAPIKeyRequest fields.
+func (m UpdateAPIKeyRequest) Validate() error {
+	return CreateAPIKeyRequest(m).Validate()
+}
+
+type service struct {
+	repo          Repository
+	transactional dbcontext.TransactionFunc
+	auditor       audit.Recorder
+	logger        log.Logger
+}
+
+// NewService creates a new API key service.
+func NewService(repo Repository, transactional dbcontext.TransactionFunc, auditor audit.Recorder, logger log.Logger) Service {
+	return service{repo, transactional, auditor, logger}
+}
+
+// Get returns the API key with the specified ID owned by the user in the organization.
+func (s service) Get(ctx context.Context, orgID, userID, id string) (entity.APIKey, error) {
+	return s.repo.Get(ctx, orgID, userID, id)
+}
+
+// Query returns the API keys owned by the user in the organization with the specified offset and limit.
+func (s service) Query(ctx context.Context, orgID, userID string, offset, limit int) ([]entity.APIKey, error) {
+	return s.repo.Query(ctx, orgID, userID, offset, limit)
+}
+
+// Count returns the number of API keys owned by the user in the organization.
+func (s service) Count(ctx context.Context, orgID, userID string) (int, error) {
+	return s.repo.Count(ctx, orgID, userID)
+}
+
+// Create creates a new API key for the user in the organization and returns it along with the plain key.
+func (s service) Create(ctx context.Context, orgID, userID string, req CreateAPIKeyRequest) (CreatedAPIKey, error) {
+	if err := req.Validate(); err != nil {
+		return CreatedAPIKey{}, err
+	}
+	prefix, key, err := generateKey()
+	if err != nil {
+		return CreatedAPIKey{}, err
+	}
+	apiKey := entity.APIKey{
+		ID:         entity.GenerateID(),
+		OrgID:      orgID,
+		UserID:     userID,
+		Name:       req.Name,
+		Prefix:     prefix,
+		KeyHash:    hashKey(key),
+		Scopes:     req.Scopes,
+		AllowedIPs: nonNil(req.AllowedIPs),
+		ExpiresAt:  req.ExpiresAt,
+	}
+	err = s.transactional(ctx, func(ctx context.Context) error {
+		if err := s.repo.Create(ctx, apiKey); err != nil {
+			return err
+		}
+		return s.record(ctx, audit.ActionAPIKeyCreated, apiKey, nil, apiKey)
+	})
+	if err != nil {
+		return CreatedAPIKey{}, err
+	}
+	created, err := s.repo.Get(ctx, orgID, userID, apiKey.ID)
+	return CreatedAPIKey{APIKey: created, Key: key}, err
+}
+
+// Update updates the name, scopes, IP allowlist and expiry of the API key.
+func (s service) Update(ctx context.Context, orgID, userID, id string, req UpdateAPIKeyRequest) (entity.APIKey, error) {
+	if err := req.Validate(); err != nil {
+		return entity.APIKey{}, err
+	}
+	key, err := s.repo.Get(ctx, orgID, userID, id)
+	if err != nil {
+		return key, err
+	}
+	before := key
+	now := time.Now()
+	key.Name = req.Name
+	key.Scopes = req.Scopes
+	key.AllowedIPs = nonNil(req.AllowedIPs)
+	key.ExpiresAt = req.ExpiresAt
+	key.UpdatedAt = &now
+	err = s.transactional(ctx, func(ctx context.Context) error {
+		if err := s.repo.Update(ctx, key); err != nil {
+			return err
+		}
+		return s.record(ctx, audit.ActionAPIKeyUpdated, key, before, key)
+	})
+	return key, err
+}
+
+// Delete revokes the API key by deleting it.
+func (s service) Delete(ctx context.Context, orgID, userID, id string) (entity.APIKey, error) {
+	key, err := s.repo.Get(ctx, orgID, userID, id)
+	if err != nil {
+		return key, err
+	}
+	err = s.transactional(ctx, func(ctx context.Context) error {
+		if err := s.repo.Delete(ctx, orgID, userID, id); err != nil {
+			return err
+		}
+		return s.record(ctx, audit.ActionAPIKeyRevoked, key, key, nil)
+	})
+	return key, err
+}
+
+// record records the change made by its owner to the API key in the audit log.
+// The before and after states are nil when the key is created and revoked respectively.
+func (s service) record(ctx context.Context, action string, key entity.APIKey, before, after interface{}) error {
+	return s.auditor.Record(ctx, audit.Event{
+		Action:     action,
+		OrgID:      key.OrgID,
+		ActorID:    key.UserID,
+		TargetType: "api_key",
+		TargetID:   key.ID,
+		Diff:       audit.Diff(before, after),
+	})
+}
+
+// Verify checks an API key presented from the given IP address and returns the identity
+// of its owner, bound to the organization of the key, along with the scopes granted to the key.
+// Keys stop working once their owner leaves the organization.
+func (s service) Verify(ctx context.Context, key, ip string) (auth.Identity, []string, error) {
+	logger := s.logger.With(ctx, "ip", ip)
+	prefix, ok := parseKey(key)
+	if !ok {
+		return nil, nil, ErrInvalidKey
+	}
+	apiKey, err := s.repo.GetByPrefix(ctx, prefix)
+	if err != nil || subtle.ConstantTimeCompare([]byte(apiKey.KeyHash), []byte(hashKey(key))) != 1 {
+		logger.Infof("API key not found: authentication failed")
+		return nil, nil, ErrInvalidKey
+	}
+	now := time.Now()
+	if apiKey.IsExpired(now) {
+		logger.Infof("API key %s expired: authentication failed", apiKey.Prefix)
+		return nil, nil, ErrInvalidKey
+	}
+	if !ipAllowed(apiKey.AllowedIPs, ip) {
+		logger.Infof("API key %s used from a forbidden IP: authentication failed", apiKey.Prefix)
+		return nil, nil, ErrInvalidKey
+	}
+	user, err := s.repo.GetActiveMember(ctx, apiKey.OrgID, apiKey.UserID)
+	if err != nil {
+		logger.Infof("API key %s owner is not an active member: authentication failed", apiKey.Prefix)
+		return nil, nil, ErrInvalidKey
+	}
+
+	if apiKey.LastUsedAt == nil || now.Sub(*apiKey.LastUsedAt) >= lastUsedResolution {
+		if err := s.repo.TouchLastUsed(ctx, apiKey.ID, now); err != nil {
+			logger.Errorf("failed to record API key usage: %v", err)
+		}
+	}
+	return Identity{entity.User{ID: user.ID, Name: user.Name, Email: user.Email}, apiKey.OrgID, apiKey.ID}, apiKey.Scopes, nil
+}
+
+// generateKey generates a new API key and returns its public prefix along with the full key.
+// Keys look like "gnv_<prefix>_<secret>".
+func generateKey() (string, string, error) {
+	b := make([]byte, 38)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	prefix := auth.APIKeyPrefix + hex.EncodeToString(b[:6])
+	return prefix, prefix + "_" + hex.EncodeToString(b[6:]), nil
+}
+
+// parseKey returns the prefix of the given API key.
+func parseKey(key string) (string, bool) {
+	if !strings.HasPrefix(key, auth.APIKeyPrefix) {
+		return "", false
+	}
+	i := strings.LastIndex(key, "_")
+	if i <= len(auth.APIKeyPrefix) {
+		return "", false
+	}
+	return key[:i], true
+}
+
+// hashKey returns the hash under which the API key is stored.
+func hashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// ipAllowed returns whether the IP matches the allowlist. An empty allowlist allows any IP.
+func ipAllowed(allowlist []string, ip string) bool {
+	if len(allowlist) == 0 {
+		return true
+	}
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false
+	}
+	for _, entry := range allowlist {
+		if _, network, err := net.ParseCIDR(entry); err == nil {
+			if network.Contains(addr) {
+				return true
+			}
+		} else if allowed := net.ParseIP(entry); allowed != nil && allowed.Equal(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// validateIPRange checks that the value is either an IP address or a CIDR range.
+func validateIPRange(value interface{}) error {
+	s, _ := value.(string)
+	if net.ParseIP(s) != nil {
+		return nil
+	}
+	if _, _, err := net.ParseCIDR(s); err == nil {
+		return nil
+	}
+	return errors.New("must be an IP address or a CIDR range")
+}
+
+// validateFuture checks that the time, if set, is in the future.
+func validateFuture(value interface{}) error {
+	if t, ok := value.(*time.Time); ok && t != nil && !t.After(time.Now()) {
+		return errors.New("must be in the future")
+	}
+	return nil
+}
+
+// nonNil returns an empty slice instead of nil so that it is stored as an empty array.
+func nonNil(values []string) []string {
+	if values == nil {
+		return []string{}
+	}
+	return values
+}
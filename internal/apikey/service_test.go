@@ -0,0 +1,250 @@
+package apikey
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/garaekz/gonvelope/internal/audit"
+	"github.com/garaekz/gonvelope/internal/auth"
+	"github.com/garaekz/gonvelope/internal/entity"
+	"github.com/garaekz/gonvelope/pkg/log"
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreateAPIKeyRequest_Validate(t *testing.T) {
+	past := time.Now().Add(-time.Hour)
+	tests := []struct {
+		name      string
+		model     CreateAPIKeyRequest
+		wantError bool
+	}{
+		{"success", CreateAPIKeyRequest{Name: "backend", Scopes: []string{auth.ScopeMessagesSend}, AllowedIPs: []string{"10.0.0.1", "192.168.0.0/16"}}, false},
+		{"required", CreateAPIKeyRequest{Name: "", Scopes: []string{auth.ScopeMessagesSend}}, true},
+		{"no scopes", CreateAPIKeyRequest{Name: "backend"}, true},
+		{"unknown scope", CreateAPIKeyRequest{Name: "backend", Scopes: []string{"admin"}}, true},
+		{"invalid ip", CreateAPIKeyRequest{Name: "backend", Scopes: []string{auth.ScopeMessagesSend}, AllowedIPs: []string{"localhost"}}, true},
+		{"expired", CreateAPIKeyRequest{Name: "backend", Scopes: []string{auth.ScopeMessagesSend}, ExpiresAt: &past}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.model.Validate()
+			assert.Equal(t, tt.wantError, err != nil)
+		})
+	}
+}
+
+func Test_service_CRUD(t *testing.T) {
+	logger, entries := log.NewForTest()
+	s := NewService(&mockRepository{}, mockTransactional, audit.NewLogRecorder(logger), logger)
+	ctx := context.Background()
+
+	count, _ := s.Count(ctx, "org1", "100")
+	assert.Equal(t, 0, count)
+
+	// unsuccessful creation
+	_, err := s.Create(ctx, "org1", "100", CreateAPIKeyRequest{Name: "backend"})
+	assert.NotNil(t, err)
+
+	// successful creation
+	created, err := s.Create(ctx, "org1", "100", CreateAPIKeyRequest{Name: "backend", Scopes: []string{auth.ScopeMessagesSend}})
+	require.Nil(t, err)
+	assert.NotEmpty(t, created.ID)
+	assert.Contains(t, created.Key, created.Prefix+"_")
+	assert.NotEqual(t, created.Key, created.KeyHash)
+	assert.Equal(t, "org1", created.OrgID)
+	count, _ = s.Count(ctx, "org1", "100")
+	assert.Equal(t, 1, count)
+
+	// keys are scoped to their owner and organization
+	_, err = s.Get(ctx, "org1", "200", created.ID)
+	assert.NotNil(t, err)
+	_, err = s.Get(ctx, "org2", "100", created.ID)
+	assert.NotNil(t, err)
+
+	// update
+	key, err := s.Update(ctx, "org1", "100", created.ID, UpdateAPIKeyRequest{Name: "renamed", Scopes: []string{auth.ScopeTemplatesRead}})
+	require.Nil(t, err)
+	assert.Equal(t, "renamed", key.Name)
+	key, _ = s.Get(ctx, "org1", "100", created.ID)
+	assert.Equal(t, "renamed", key.Name)
+
+	// query
+	keys, _ := s.Query(ctx, "org1", "100", 0, 0)
+	assert.Len(t, keys, 1)
+
+	// delete
+	_, err = s.Delete(ctx, "org1", "200", created.ID)
+	assert.NotNil(t, err)
+	_, err = s.Delete(ctx, "org1", "100", created.ID)
+	assert.Nil(t, err)
+	count, _ = s.Count(ctx, "org1", "100")
+	assert.Equal(t, 0, count)
+
+	// changes are audited without ever exposing the key hash
+	audited := entries.FilterMessage("audit event").All()
+	if assert.Len(t, audited, 3) {
+		assert.Equal(t, audit.ActionAPIKeyCreated, audited[0].ContextMap()["audit_action"])
+		assert.Equal(t, audit.ActionAPIKeyRevoked, audited[2].ContextMap()["audit_action"])
+		assert.NotContains(t, audited[0].ContextMap()["diff"], "key_hash")
+	}
+}
+
+func Test_service_Verify(t *testing.T) {
+	logger, _ := log.NewForTest()
+	repo := &mockRepository{
+		users:       []entity.User{{ID: "100", Name: "demo", Active: true}},
+		memberships: []entity.Membership{{OrgID: "org1", UserID: "100", Role: entity.RoleEditor}},
+	}
+	s := NewService(repo, mockTransactional, audit.NewLogRecorder(logger), logger)
+	ctx := context.Background()
+
+	created, err := s.Create(ctx, "org1", "100", CreateAPIKeyRequest{
+		Name:       "backend",
+		Scopes:     []string{auth.ScopeMessagesSend},
+		AllowedIPs: []string{"10.0.0.0/8"},
+	})
+	require.Nil(t, err)
+
+	identity, scopes, err := s.Verify(ctx, created.Key, "10.1.2.3")
+	require.Nil(t, err)
+	assert.Equal(t, "100", identity.GetID())
+	assert.Equal(t, "org1", identity.(Identity).GetOrgID())
+	assert.Equal(t, created.ID, identity.(Identity).GetKeyID())
+	assert.Equal(t, []string{auth.ScopeMessagesSend}, scopes)
+	assert.NotNil(t, repo.items[0].LastUsedAt)
+
+	_, _, err = s.Verify(ctx, created.Key, "192.168.0.1")
+	assert.Equal(t, ErrInvalidKey, err)
+	_, _, err = s.Verify(ctx, created.Key+"x", "10.1.2.3")
+	assert.Equal(t, ErrInvalidKey, err)
+	_, _, err = s.Verify(ctx, "invalid", "10.1.2.3")
+	assert.Equal(t, ErrInvalidKey, err)
+
+	past := time.Now().Add(-time.Minute)
+	repo.items[0].ExpiresAt = &past
+	_, _, err = s.Verify(ctx, created.Key, "10.1.2.3")
+	assert.Equal(t, ErrInvalidKey, err)
+
+	repo.items[0].ExpiresAt = nil
+	repo.memberships = nil
+	_, _, err = s.Verify(ctx, created.Key, "10.1.2.3")
+	assert.Equal(t, ErrInvalidKey, err, "owner left the organization")
+
+	repo.memberships = []entity.Membership{{OrgID: "org1", UserID: "100", Role: entity.RoleEditor}}
+	repo.users[0].Active = false
+	_, _, err = s.Verify(ctx, created.Key, "10.1.2.3")
+	assert.Equal(t, ErrInvalidKey, err)
+}
+
+func Test_ipAllowed(t *testing.T) {
+	assert.True(t, ipAllowed(nil, "1.2.3.4"))
+	assert.True(t, ipAllowed([]string{"1.2.3.4"}, "1.2.3.4"))
+	assert.True(t, ipAllowed([]string{"1.2.0.0/16"}, "1.2.3.4"))
+	assert.False(t, ipAllowed([]string{"1.2.0.0/16"}, "1.3.3.4"))
+	assert.False(t, ipAllowed([]string{"1.2.3.4"}, "not an ip"))
+}
+
+func Test_validateIPRange(t *testing.T) {
+	assert.Nil(t, validation.Validate("::1", validation.By(validateIPRange)))
+	assert.NotNil(t, validation.Validate("1.2.3", validation.By(validateIPRange)))
+}
+
+func mockTransactional(ctx context.Context, f func(ctx context.Context) error) error {
+	return f(ctx)
+}
+
+type mockRepository struct {
+	items       []entity.APIKey
+	users       []entity.User
+	memberships []entity.Membership
+}
+
+func (m *mockRepository) Get(_ context.Context, orgID, userID, id string) (entity.APIKey, error) {
+	for _, item := range m.items {
+		if item.ID == id && item.OrgID == orgID && item.UserID == userID {
+			return item, nil
+		}
+	}
+	return entity.APIKey{}, sql.ErrNoRows
+}
+
+func (m *mockRepository) Count(_ context.Context, orgID, userID string) (int, error) {
+	count := 0
+	for _, item := range m.items {
+		if item.OrgID == orgID && item.UserID == userID {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (m *mockRepository) Query(_ context.Context, orgID, userID string, _, _ int) ([]entity.APIKey, error) {
+	var items []entity.APIKey
+	for _, item := range m.items {
+		if item.OrgID == orgID && item.UserID == userID {
+			items = append(items, item)
+		}
+	}
+	return items, nil
+}
+
+func (m *mockRepository) Create(_ context.Context, key entity.APIKey) error {
+	m.items = append(m.items, key)
+	return nil
+}
+
+func (m *mockRepository) Update(_ context.Context, key entity.APIKey) error {
+	for i, item := range m.items {
+		if item.ID == key.ID {
+			m.items[i] = key
+		}
+	}
+	return nil
+}
+
+func (m *mockRepository) Delete(_ context.Context, orgID, userID, id string) error {
+	for i, item := range m.items {
+		if item.ID == id && item.OrgID == orgID && item.UserID == userID {
+			m.items[i] = m.items[len(m.items)-1]
+			m.items = m.items[:len(m.items)-1]
+			return nil
+		}
+	}
+	return sql.ErrNoRows
+}
+
+func (m *mockRepository) GetByPrefix(_ context.Context, prefix string) (entity.APIKey, error) {
+	for _, item := range m.items {
+		if item.Prefix == prefix {
+			return item, nil
+		}
+	}
+	return entity.APIKey{}, sql.ErrNoRows
+}
+
+func (m *mockRepository) GetActiveMember(_ context.Context, orgID, userID string) (entity.User, error) {
+	for _, membership := range m.memberships {
+		if membership.OrgID != orgID || membership.UserID != userID {
+			continue
+		}
+		for _, user := range m.users {
+			if user.ID == userID && user.Active {
+				return user, nil
+			}
+		}
+	}
+	return entity.User{}, sql.ErrNoRows
+}
+
+func (m *mockRepository) TouchLastUsed(_ context.Context, id string, at time.Time) error {
+	for i, item := range m.items {
+		if item.ID == id {
+			m.items[i].LastUsedAt = &at
+		}
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package attachment
+
+import (
+	"net/http"
+
+	"github.com/garaekz/gonvelope/internal/auth"
+	"github.com/garaekz/gonvelope/internal/entity"
+	"github.com/garaekz/gonvelope/pkg/log"
+	routing "github.com/garaekz/ozzo-routing"
+)
+
+// RegisterHandlers sets up the routing of the HTTP handlers.
+// Attachments belong to the organization made active by orgHandler.
+func RegisterHandlers(r *routing.RouteGroup, service Service, authHandler, orgHandler routing.Handler, logger log.Logger) {
+	res := resource{service, logger}
+
+	r.Use(authHandler, orgHandler)
+
+	r.Get("attachments/<id>", auth.Require(auth.ScopeMessagesRead), res.get)
+	r.Post("attachments", auth.Require(auth.ScopeMessagesSend), res.upload)
+}
+
+type resource struct {
+	service Service
+	logger  log.Logger
+}
+
+func (r resource) get(c *routing.Context) error {
+	attachment, err := r.service.Get(c.Request.Context(), currentMembership(c).OrgID, c.Param("id"))
+	if err != nil {
+		return err
+	}
+	return c.Write(attachment)
+}
+
+// upload stores the request body as an attachment. The filename and the optional SHA-256 checksum are given
+// in the query string, and the content type of the file is the one of the request.
+func (r resource) upload(c *routing.Context) error {
+	input := UploadRequest{
+		Filename:    c.Query("filename"),
+		ContentType: c.Request.Header.Get("Content-Type"),
+		Checksum:    c.Query("checksum"),
+	}
+	membership := currentMembership(c)
+	attachment, err := r.service.Upload(c.Request.Context(), membership.OrgID, membership.UserID, input, c.Request.Body)
+	if err != nil {
+		return err
+	}
+	return c.WriteWithStatus(attachment, http.StatusCreated)
+}
+
+// currentMembership returns the membership of the current user in the active organization.
+func currentMembership(c *routing.Context) entity.Membership {
+	membership, _ := auth.CurrentMembership(c.Request.Context())
+	return membership
+}
@@ -0,0 +1,50 @@
+package attachment
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/garaekz/gonvelope/internal/auth"
+	"github.com/garaekz/gonvelope/internal/entity"
+	"github.com/garaekz/gonvelope/internal/org"
+	"github.com/garaekz/gonvelope/internal/test"
+	"github.com/garaekz/gonvelope/pkg/blob"
+	"github.com/garaekz/gonvelope/pkg/log"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAPI(t *testing.T) {
+	logger, _ := log.NewForTest()
+	router := test.MockRouter(logger)
+	store, err := blob.NewFileSystem(t.TempDir())
+	require.Nil(t, err)
+	repo := &mockRepository{items: []entity.Attachment{
+		{ID: "a1", OrgID: "100", Filename: "invoice.pdf", ContentType: "application/pdf", Size: 8, CreatedAt: time.Now()},
+		{ID: "a2", OrgID: "org1", Filename: "other.pdf", ContentType: "application/pdf", Size: 8, CreatedAt: time.Now()},
+	}}
+	RegisterHandlers(router.Group("/"), NewService(repo, mockTransactional, store, testLimits, &mockRecorder{}, logger), auth.MockAuthHandler, org.MockHandler, logger)
+	header := auth.MockAuthHeader()
+	pdf := auth.MockAuthHeader()
+	pdf.Set("Content-Type", "application/pdf")
+	sender := org.MockHeader(entity.RoleSender)
+	sender.Set("Content-Type", "application/pdf")
+	viewer := org.MockHeader(entity.RoleViewer)
+	viewer.Set("Content-Type", "application/pdf")
+
+	tests := []test.APITestCase{
+		{Name: "get", Method: "GET", URL: "/attachments/a1", Header: header, WantStatus: http.StatusOK, WantResponse: `*"filename":"invoice.pdf"*`},
+		{Name: "get other org", Method: "GET", URL: "/attachments/a2", Header: header, WantStatus: http.StatusNotFound},
+		{Name: "upload", Method: "POST", URL: "/attachments?filename=report.pdf", Body: "%PDF-1.7", Header: pdf, WantStatus: http.StatusCreated, WantResponse: `*"checksum":"*`},
+		{Name: "upload too large", Method: "POST", URL: "/attachments?filename=report.pdf", Body: "%PDF-1.7 and much more", Header: pdf, WantStatus: http.StatusRequestEntityTooLarge},
+		{Name: "upload bad checksum", Method: "POST", URL: "/attachments?filename=report.pdf&checksum=" + "0000000000000000000000000000000000000000000000000000000000000000", Body: "%PDF-1.7", Header: pdf, WantStatus: http.StatusBadRequest},
+		{Name: "upload disallowed type", Method: "POST", URL: "/attachments?filename=report.txt", Body: "report", Header: header, WantStatus: http.StatusBadRequest},
+		{Name: "upload without filename", Method: "POST", URL: "/attachments", Body: "%PDF-1.7", Header: pdf, WantStatus: http.StatusBadRequest},
+		{Name: "sender upload", Method: "POST", URL: "/attachments?filename=report.pdf", Body: "%PDF-1.7", Header: sender, WantStatus: http.StatusCreated},
+		{Name: "viewer upload", Method: "POST", URL: "/attachments?filename=report.pdf", Body: "%PDF-1.7", Header: viewer, WantStatus: http.StatusForbidden},
+		{Name: "unauthorized", Method: "GET", URL: "/attachments/a1", WantStatus: http.StatusUnauthorized},
+	}
+	for _, tc := range tests {
+		test.Endpoint(t, router, tc)
+	}
+}
@@ -0,0 +1,43 @@
+package attachment
+
+import (
+	"context"
+	"time"
+
+	"github.com/garaekz/gonvelope/pkg/log"
+)
+
+// collectInterval is how often the collector looks for attachments to delete.
+const collectInterval = time.Hour
+
+// Collector deletes the attachments that no message referred to within a TTL of their upload.
+type Collector struct {
+	service Service
+	ttl     time.Duration
+	logger  log.Logger
+}
+
+// NewCollector creates a new collector deleting the attachments no message referred to within the TTL.
+func NewCollector(service Service, ttl time.Duration, logger log.Logger) *Collector {
+	return &Collector{service, ttl, logger}
+}
+
+// Run collects the attachments every collectInterval until the context is cancelled.
+func (c *Collector) Run(ctx context.Context) {
+	ticker := time.NewTicker(collectInterval)
+	defer ticker.Stop()
+	for {
+		deleted, err := c.service.Collect(ctx, time.Now().Add(-c.ttl))
+		if err != nil {
+			c.logger.With(ctx).Errorf("failed to collect attachments: %v", err)
+		}
+		if deleted > 0 {
+			c.logger.With(ctx).Infof("deleted %d unreferenced attachments", deleted)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package attachment
+
+import (
+	"context"
+	"time"
+
+	"github.com/garaekz/gonvelope/internal/entity"
+	"github.com/garaekz/gonvelope/pkg/dbcontext"
+	"github.com/garaekz/gonvelope/pkg/log"
+	dbx "github.com/go-ozzo/ozzo-dbx"
+)
+
+// unreferenced selects the attachments no message refers to.
+const unreferenced = "NOT EXISTS (SELECT 1 FROM messages WHERE messages.attachment_ids @> ARRAY[attachments.id])"
+
+// Repository encapsulates the logic to access attachments from the data source.
+type Repository interface {
+	// Get returns the attachment with the specified ID owned by the organization.
+	Get(ctx context.Context, orgID, id string) (entity.Attachment, error)
+	// Create saves a new attachment in the storage.
+	Create(ctx context.Context, attachment entity.Attachment) error
+	// QueryUnreferenced returns up to limit attachments created before the given time that no message refers to,
+	// oldest first.
+	QueryUnreferenced(ctx context.Context, before time.Time, limit int) ([]entity.Attachment, error)
+	// DeleteUnreferenced deletes the attachment with the specified ID unless a message refers to it,
+	// and returns whether it was deleted.
+	DeleteUnreferenced(ctx context.Context, id string) (bool, error)
+}
+
+// repository persists attachments in database
+type repository struct {
+	db     *dbcontext.DB
+	logger log.Logger
+}
+
+// NewRepository creates a new attachment repository
+func NewRepository(db *dbcontext.DB, logger log.Logger) Repository {
+	return repository{db, logger}
+}
+
+// Get returns the attachment with the specified ID owned by the organization.
+func (r repository) Get(ctx context.Context, orgID, id string) (entity.Attachment, error) {
+	var attachment entity.Attachment
+	err := r.db.With(ctx).Select().From(attachment.TableName()).
+		Where(dbx.HashExp{"id": id, "org_id": orgID}).One(&attachment)
+	return attachment, err
+}
+
+// Create saves a new attachment in the storage.
+func (r repository) Create(ctx context.Context, attachment entity.Attachment) error {
+	return r.db.With(ctx).Model(&attachment).Insert()
+}
+
+// QueryUnreferenced returns up to limit attachments created before the given time that no message refers to,
+// oldest first.
+func (r repository) QueryUnreferenced(ctx context.Context, before time.Time, limit int) ([]entity.Attachment, error) {
+	var attachments []entity.Attachment
+	err := r.db.With(ctx).Select().From(entity.Attachment{}.TableName()).
+		Where(dbx.And(dbx.NewExp("created_at < {:before}", dbx.Params{"before": before}), dbx.NewExp(unreferenced))).
+		OrderBy("created_at").
+		Limit(int64(limit)).
+		All(&attachments)
+	return attachments, err
+}
+
+// DeleteUnreferenced deletes the attachment with the specified ID unless a message refers to it,
+// and returns whether it was deleted.
+func (r repository) DeleteUnreferenced(ctx context.Context, id string) (bool, error) {
+	result, err := r.db.With(ctx).Delete(entity.Attachment{}.TableName(),
+		dbx.And(dbx.HashExp{"id": id}, dbx.NewExp(unreferenced))).Execute()
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	return rows > 0, err
+}
@@ -0,0 +1,251 @@
+// Package attachment stores the files organizations attach to the messages they send.
+package attachment
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/garaekz/gonvelope/internal/audit"
+	"github.com/garaekz/gonvelope/internal/entity"
+	"github.com/garaekz/gonvelope/internal/errors"
+	"github.com/garaekz/gonvelope/pkg/blob"
+	"github.com/garaekz/gonvelope/pkg/dbcontext"
+	"github.com/garaekz/gonvelope/pkg/log"
+	"github.com/garaekz/gonvelope/pkg/mailer"
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/go-ozzo/ozzo-validation/v4/is"
+)
+
+// collectBatchSize is the number of attachments the collector looks at at once.
+const collectBatchSize = 100
+
+// errTooLarge is returned by the reader of an upload once the file exceeds the maximum size.
+var errTooLarge = fmt.Errorf("file too large")
+
+// Service encapsulates usecase logic for attachments.
+type Service interface {
+	Get(ctx context.Context, orgID, id string) (entity.Attachment, error)
+	// Upload stores the file read from r as an attachment of the organization.
+	Upload(ctx context.Context, orgID, userID string, input UploadRequest, r io.Reader) (entity.Attachment, error)
+	// Load returns the attachments of the organization with the given IDs along with their content.
+	Load(ctx context.Context, orgID string, ids []string) ([]mailer.Attachment, error)
+	// Collect deletes the attachments created before the given time that no message refers to,
+	// and returns how many were deleted.
+	Collect(ctx context.Context, before time.Time) (int, error)
+}
+
+// Limits restricts the files that can be uploaded as attachments.
+type Limits struct {
+	// MaxSize is the maximum size of a file in bytes.
+	MaxSize int64
+	// ContentTypes lists the content types files may have. A trailing "*" matches any suffix,
+	// such as "image/*" or "application/vnd.oasis.opendocument.*".
+	ContentTypes []string
+}
+
+// allows returns whether files of the content type may be uploaded.
+func (l Limits) allows(contentType string) bool {
+	for _, allowed := range l.ContentTypes {
+		if prefix, ok := strings.CutSuffix(allowed, "*"); ok && strings.HasPrefix(contentType, prefix) || allowed == contentType {
+			return true
+		}
+	}
+	return false
+}
+
+// UploadRequest describes a file uploaded as an attachment.
+type UploadRequest struct {
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"`
+	// Checksum is the hex-encoded SHA-256 hash of the file. When given, the upload is rejected unless it matches.
+	Checksum string `json:"checksum"`
+}
+
+// Validate validates the UploadRequest fields.
+func (m UploadRequest) Validate() error {
+	return validation.ValidateStruct(&m,
+		validation.Field(&m.Filename, validation.Required, validation.Length(0, 255), validation.By(validateFilename)),
+		validation.Field(&m.ContentType, validation.Required, validation.Length(0, 255)),
+		validation.Field(&m.Checksum, validation.Length(64, 64), is.Hexadecimal),
+	)
+}
+
+// validateFilename checks that the filename has no path separators or control characters.
+func validateFilename(value interface{}) error {
+	filename, _ := value.(string)
+	if strings.ContainsAny(filename, `/\`) || strings.IndexFunc(filename, unicode.IsControl) >= 0 {
+		return validation.NewError("validation_filename_invalid", "must not contain slashes or control characters")
+	}
+	return nil
+}
+
+type service struct {
+	repo          Repository
+	transactional dbcontext.TransactionFunc
+	store         blob.Store
+	limits        Limits
+	auditor       audit.Recorder
+	logger        log.Logger
+}
+
+// NewService creates a new attachment service keeping the content of the files in the blob store.
+func NewService(repo Repository, transactional dbcontext.TransactionFunc, store blob.Store, limits Limits, auditor audit.Recorder, logger log.Logger) Service {
+	return service{repo, transactional, store, limits, auditor, logger}
+}
+
+// Get returns the attachment with the specified ID owned by the organization.
+func (s service) Get(ctx context.Context, orgID, id string) (entity.Attachment, error) {
+	return s.repo.Get(ctx, orgID, id)
+}
+
+// Upload streams the file read from r to the blob store while computing its size and checksum,
+// and saves it as an attachment of the organization on behalf of the user.
+func (s service) Upload(ctx context.Context, orgID, userID string, req UploadRequest, r io.Reader) (entity.Attachment, error) {
+	if mediaType, _, err := mime.ParseMediaType(req.ContentType); err == nil {
+		req.ContentType = mediaType
+	}
+	req.Checksum = strings.ToLower(req.Checksum)
+	if err := req.Validate(); err != nil {
+		return entity.Attachment{}, err
+	}
+	if !s.limits.allows(req.ContentType) {
+		return entity.Attachment{}, errors.InvalidInput(validation.Errors{
+			"content_type": validation.NewError("validation_content_type_not_allowed", "is not an allowed content type"),
+		})
+	}
+
+	attachment := entity.Attachment{
+		ID:          entity.GenerateID(),
+		OrgID:       orgID,
+		UserID:      userID,
+		Filename:    req.Filename,
+		ContentType: req.ContentType,
+		CreatedAt:   time.Now(),
+	}
+	key := blobKey(attachment)
+	hash := sha256.New()
+	body := &limitedReader{r: r, max: s.limits.MaxSize}
+	if err := s.store.Put(ctx, key, io.TeeReader(body, hash)); err != nil {
+		if err == errTooLarge {
+			return entity.Attachment{}, errors.RequestEntityTooLarge(fmt.Sprintf("The file must not exceed %d bytes.", s.limits.MaxSize))
+		}
+		return entity.Attachment{}, err
+	}
+	attachment.Size = body.n
+	attachment.Checksum = hex.EncodeToString(hash.Sum(nil))
+
+	var invalid validation.Errors
+	switch {
+	case attachment.Size == 0:
+		invalid = validation.Errors{"file": validation.NewError("validation_file_empty", "must not be empty")}
+	case req.Checksum != "" && req.Checksum != attachment.Checksum:
+		invalid = validation.Errors{"checksum": validation.NewError("validation_checksum_mismatch", "does not match the uploaded file")}
+	}
+	if invalid != nil {
+		s.deleteBlob(ctx, key)
+		return entity.Attachment{}, errors.InvalidInput(invalid)
+	}
+
+	err := s.transactional(ctx, func(ctx context.Context) error {
+		if err := s.repo.Create(ctx, attachment); err != nil {
+			return err
+		}
+		return s.auditor.Record(ctx, audit.Event{
+			Action:     audit.ActionAttachmentUploaded,
+			OrgID:      orgID,
+			ActorID:    userID,
+			TargetType: "attachment",
+			TargetID:   attachment.ID,
+			Data:       map[string]interface{}{"filename": attachment.Filename, "size": attachment.Size, "checksum": attachment.Checksum},
+		})
+	})
+	if err != nil {
+		s.deleteBlob(ctx, key)
+		return entity.Attachment{}, err
+	}
+	return attachment, nil
+}
+
+// Load returns the attachments of the organization with the given IDs along with their content, in the same order.
+func (s service) Load(ctx context.Context, orgID string, ids []string) ([]mailer.Attachment, error) {
+	attachments := make([]mailer.Attachment, len(ids))
+	for i, id := range ids {
+		attachment, err := s.repo.Get(ctx, orgID, id)
+		if err != nil {
+			return nil, fmt.Errorf("attachment %s: %w", id, err)
+		}
+		r, err := s.store.Get(ctx, blobKey(attachment))
+		if err != nil {
+			return nil, fmt.Errorf("attachment %s: %w", id, err)
+		}
+		data, err := io.ReadAll(r)
+		_ = r.Close()
+		if err != nil {
+			return nil, fmt.Errorf("attachment %s: %w", id, err)
+		}
+		attachments[i] = mailer.Attachment{Filename: attachment.Filename, ContentType: attachment.ContentType, Data: data}
+	}
+	return attachments, nil
+}
+
+// Collect deletes the attachments created before the given time that no message refers to, along with their files,
+// and returns how many were deleted. Files that cannot be deleted are logged and left in the blob store.
+func (s service) Collect(ctx context.Context, before time.Time) (int, error) {
+	deleted := 0
+	for {
+		attachments, err := s.repo.QueryUnreferenced(ctx, before, collectBatchSize)
+		if err != nil {
+			return deleted, err
+		}
+		for _, attachment := range attachments {
+			// a message may have referred to the attachment since it was queried
+			ok, err := s.repo.DeleteUnreferenced(ctx, attachment.ID)
+			if err != nil {
+				return deleted, err
+			}
+			if ok {
+				s.deleteBlob(ctx, blobKey(attachment))
+				deleted++
+			}
+		}
+		if len(attachments) < collectBatchSize {
+			return deleted, nil
+		}
+	}
+}
+
+// deleteBlob deletes the file stored under the key, logging any failure.
+func (s service) deleteBlob(ctx context.Context, key string) {
+	if err := s.store.Delete(ctx, key); err != nil {
+		s.logger.With(ctx, "key", key).Errorf("failed to delete attachment file: %v", err)
+	}
+}
+
+// blobKey returns the key the file of the attachment is stored under.
+func blobKey(attachment entity.Attachment) string {
+	return attachment.OrgID + "/" + attachment.ID
+}
+
+// limitedReader reads from r and counts the bytes read, failing with errTooLarge once more than max bytes are read.
+type limitedReader struct {
+	r   io.Reader
+	max int64
+	n   int64
+}
+
+// Read implements io.Reader.
+func (l *limitedReader) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	l.n += int64(n)
+	if l.n > l.max {
+		return n, errTooLarge
+	}
+	return n, err
+}
@@ -0,0 +1,177 @@
+package attachment
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/garaekz/gonvelope/internal/audit"
+	"github.com/garaekz/gonvelope/internal/entity"
+	"github.com/garaekz/gonvelope/internal/errors"
+	"github.com/garaekz/gonvelope/pkg/blob"
+	"github.com/garaekz/gonvelope/pkg/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testLimits = Limits{MaxSize: 16, ContentTypes: []string{"application/pdf", "image/*"}}
+
+func TestLimits_allows(t *testing.T) {
+	assert.True(t, testLimits.allows("application/pdf"))
+	assert.True(t, testLimits.allows("image/png"))
+	assert.False(t, testLimits.allows("application/pdfx"))
+	assert.False(t, testLimits.allows("application/x-msdownload"))
+	assert.True(t, Limits{ContentTypes: []string{"*"}}.allows("application/x-msdownload"))
+}
+
+func TestUploadRequest_Validate(t *testing.T) {
+	tests := []struct {
+		name      string
+		model     UploadRequest
+		wantError bool
+	}{
+		{"success", UploadRequest{Filename: "invoice.pdf", ContentType: "application/pdf"}, false},
+		{"with checksum", UploadRequest{Filename: "invoice.pdf", ContentType: "application/pdf", Checksum: strings.Repeat("a", 64)}, false},
+		{"missing filename", UploadRequest{ContentType: "application/pdf"}, true},
+		{"filename with path", UploadRequest{Filename: "../invoice.pdf", ContentType: "application/pdf"}, true},
+		{"filename with newline", UploadRequest{Filename: "invoice\r\n.pdf", ContentType: "application/pdf"}, true},
+		{"missing content type", UploadRequest{Filename: "invoice.pdf"}, true},
+		{"invalid checksum", UploadRequest{Filename: "invoice.pdf", ContentType: "application/pdf", Checksum: "abc"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.wantError, tt.model.Validate() != nil)
+		})
+	}
+}
+
+func Test_service_Upload(t *testing.T) {
+	logger, _ := log.NewForTest()
+	store, err := blob.NewFileSystem(t.TempDir())
+	require.Nil(t, err)
+	repo := &mockRepository{}
+	auditor := &mockRecorder{}
+	s := NewService(repo, mockTransactional, store, testLimits, auditor, logger)
+	ctx := context.Background()
+	sum := sha256.Sum256([]byte("%PDF-1.7"))
+	checksum := hex.EncodeToString(sum[:])
+
+	attachment, err := s.Upload(ctx, "org1", "100", UploadRequest{Filename: "invoice.pdf", ContentType: "application/pdf; name=invoice.pdf", Checksum: strings.ToUpper(checksum)}, strings.NewReader("%PDF-1.7"))
+	require.Nil(t, err)
+	assert.Equal(t, "application/pdf", attachment.ContentType)
+	assert.Equal(t, int64(8), attachment.Size)
+	assert.Equal(t, checksum, attachment.Checksum)
+	if assert.Len(t, auditor.events, 1) {
+		assert.Equal(t, audit.ActionAttachmentUploaded, auditor.events[0].Action)
+	}
+
+	loaded, err := s.Load(ctx, "org1", []string{attachment.ID})
+	require.Nil(t, err)
+	assert.Equal(t, "invoice.pdf", loaded[0].Filename)
+	assert.Equal(t, "%PDF-1.7", string(loaded[0].Data))
+	_, err = s.Load(ctx, "org2", []string{attachment.ID})
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+
+	_, err = s.Upload(ctx, "org1", "100", UploadRequest{Filename: "setup.exe", ContentType: "application/x-msdownload"}, strings.NewReader("MZ"))
+	assert.Equal(t, http.StatusBadRequest, err.(errors.ErrorResponse).StatusCode())
+	_, err = s.Upload(ctx, "org1", "100", UploadRequest{Filename: "big.pdf", ContentType: "application/pdf"}, strings.NewReader(strings.Repeat("a", 17)))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, err.(errors.ErrorResponse).StatusCode())
+	_, err = s.Upload(ctx, "org1", "100", UploadRequest{Filename: "empty.pdf", ContentType: "application/pdf"}, strings.NewReader(""))
+	assert.Equal(t, http.StatusBadRequest, err.(errors.ErrorResponse).StatusCode())
+	_, err = s.Upload(ctx, "org1", "100", UploadRequest{Filename: "invoice.pdf", ContentType: "application/pdf", Checksum: checksum}, strings.NewReader("%PDF-1.6"))
+	assert.Contains(t, fmt.Sprint(err.(errors.ErrorResponse).Details), "checksum")
+	// rejected uploads leave nothing behind
+	assert.Len(t, repo.items, 1)
+}
+
+func Test_service_Collect(t *testing.T) {
+	logger, _ := log.NewForTest()
+	store, err := blob.NewFileSystem(t.TempDir())
+	require.Nil(t, err)
+	repo := &mockRepository{referenced: map[string]bool{}}
+	s := NewService(repo, mockTransactional, store, testLimits, &mockRecorder{}, logger)
+	ctx := context.Background()
+
+	var ids []string
+	for i := 0; i < 3; i++ {
+		attachment, err := s.Upload(ctx, "org1", "100", UploadRequest{Filename: "invoice.pdf", ContentType: "application/pdf"}, strings.NewReader("%PDF-1.7"))
+		require.Nil(t, err)
+		ids = append(ids, attachment.ID)
+	}
+	repo.items[2].CreatedAt = time.Now().Add(time.Hour)
+	repo.referenced[ids[1]] = true
+
+	deleted, err := s.Collect(ctx, time.Now().Add(time.Minute))
+	require.Nil(t, err)
+	assert.Equal(t, 1, deleted)
+	_, err = s.Get(ctx, "org1", ids[0])
+	assert.Equal(t, sql.ErrNoRows, err)
+	_, err = store.Get(ctx, "org1/"+ids[0])
+	assert.Equal(t, blob.ErrNotFound, err)
+	_, err = s.Load(ctx, "org1", ids[1:])
+	assert.Nil(t, err)
+}
+
+func mockTransactional(ctx context.Context, f func(ctx context.Context) error) error {
+	return f(ctx)
+}
+
+type mockRecorder struct {
+	events []audit.Event
+}
+
+func (m *mockRecorder) Record(_ context.Context, event audit.Event) error {
+	m.events = append(m.events, event)
+	return nil
+}
+
+type mockRepository struct {
+	items []entity.Attachment
+	// referenced holds the IDs of the attachments messages refer to.
+	referenced map[string]bool
+}
+
+func (m *mockRepository) Get(_ context.Context, orgID, id string) (entity.Attachment, error) {
+	for _, item := range m.items {
+		if item.ID == id && item.OrgID == orgID {
+			return item, nil
+		}
+	}
+	return entity.Attachment{}, sql.ErrNoRows
+}
+
+func (m *mockRepository) Create(_ context.Context, attachment entity.Attachment) error {
+	m.items = append(m.items, attachment)
+	return nil
+}
+
+func (m *mockRepository) QueryUnreferenced(_ context.Context, before time.Time, limit int) ([]entity.Attachment, error) {
+	var items []entity.Attachment
+	for _, item := range m.items {
+		if item.CreatedAt.Before(before) && !m.referenced[item.ID] {
+			items = append(items, item)
+		}
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].CreatedAt.Before(items[j].CreatedAt) })
+	if limit < len(items) {
+		items = items[:limit]
+	}
+	return items, nil
+}
+
+func (m *mockRepository) DeleteUnreferenced(_ context.Context, id string) (bool, error) {
+	for i, item := range m.items {
+		if item.ID == id && !m.referenced[id] {
+			m.items = append(m.items[:i], m.items[i+1:]...)
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,192 @@
+// Package audit records security-relevant events.
+package audit
+
+import (
+	"context"
+
+	"github.com/garaekz/gonvelope/pkg/clientip"
+	"github.com/garaekz/gonvelope/pkg/log"
+	routing "github.com/garaekz/ozzo-routing"
+)
+
+// Actions recorded by the application.
+const (
+	// ActionLoginLocked is recorded when too many failed login attempts lock out an email or an IP address.
+	ActionLoginLocked = "auth.login_locked"
+	// ActionLoginUnlocked is recorded when an administrator unlocks an account.
+	ActionLoginUnlocked = "auth.login_unlocked"
+	// ActionAccountLinked is recorded when a provider mailbox is linked to an organization.
+	ActionAccountLinked = "account.linked"
+	// ActionTemplateCreated is recorded when a template is created.
+	ActionTemplateCreated = "template.created"
+	// ActionTemplateUpdated is recorded when a template is updated.
+	ActionTemplateUpdated = "template.updated"
+	// ActionTemplateDeleted is recorded when a template is deleted.
+	ActionTemplateDeleted = "template.deleted"
+	// ActionAPIKeyCreated is recorded when an API key is created.
+	ActionAPIKeyCreated = "api_key.created"
+	// ActionAPIKeyUpdated is recorded when an API key is updated.
+	ActionAPIKeyUpdated = "api_key.updated"
+	// ActionAPIKeyRevoked is recorded when an API key is revoked.
+	ActionAPIKeyRevoked = "api_key.revoked"
+	// ActionOrgCreated is recorded when an organization is created.
+	ActionOrgCreated = "org.created"
+	// ActionOrgUpdated is recorded when an organization is renamed.
+	ActionOrgUpdated = "org.updated"
+	// ActionOrgDeleted is recorded when an organization is deleted.
+	ActionOrgDeleted = "org.deleted"
+	// ActionMemberRemoved is recorded when a member leaves or is removed from an organization.
+	ActionMemberRemoved = "member.removed"
+	// ActionInvitationSent is recorded when someone is invited to join an organization.
+	ActionInvitationSent = "invitation.sent"
+	// ActionInvitationRevoked is recorded when an invitation is revoked.
+	ActionInvitationRevoked = "invitation.revoked"
+	// ActionInvitationAccepted is recorded when an invitation is accepted.
+	ActionInvitationAccepted = "invitation.accepted"
+	// ActionInvitationDeclined is recorded when an invitation is declined.
+	ActionInvitationDeclined = "invitation.declined"
+	// ActionMessageQueued is recorded when a message is queued for sending.
+	ActionMessageQueued = "message.queued"
+	// ActionBatchQueued is recorded when the messages of a batch are queued for sending.
+	ActionBatchQueued = "message.batch_queued"
+	// ActionInboundReceived is recorded when a received message is posted as raw MIME.
+	ActionInboundReceived = "message.inbound_received"
+	// ActionSuppressionAdded is recorded when an address is added to the suppression list.
+	ActionSuppressionAdded = "suppression.added"
+	// ActionSuppressionRemoved is recorded when an address is removed from the suppression list.
+	ActionSuppressionRemoved = "suppression.removed"
+	// ActionSuppressionsImported is recorded when addresses are imported into the suppression list.
+	ActionSuppressionsImported = "suppression.imported"
+	// ActionContactCreated is recorded when a contact is created.
+	ActionContactCreated = "contact.created"
+	// ActionContactUpdated is recorded when a contact is updated.
+	ActionContactUpdated = "contact.updated"
+	// ActionContactDeleted is recorded when a contact is deleted.
+	ActionContactDeleted = "contact.deleted"
+	// ActionListCreated is recorded when a contact list is created.
+	ActionListCreated = "list.created"
+	// ActionListUpdated is recorded when a contact list is updated.
+	ActionListUpdated = "list.updated"
+	// ActionListDeleted is recorded when a contact list is deleted.
+	ActionListDeleted = "list.deleted"
+	// ActionListMembersAdded is recorded when contacts are added to a list.
+	ActionListMembersAdded = "list.members_added"
+	// ActionListMemberRemoved is recorded when a contact is removed from a list.
+	ActionListMemberRemoved = "list.member_removed"
+	// ActionAttachmentUploaded is recorded when a file is uploaded to be attached to messages.
+	ActionAttachmentUploaded = "attachment.uploaded"
+	// ActionWebhookCreated is recorded when a webhook is created.
+	ActionWebhookCreated = "webhook.created"
+	// ActionWebhookUpdated is recorded when a webhook is updated.
+	ActionWebhookUpdated = "webhook.updated"
+	// ActionWebhookDeleted is recorded when a webhook is deleted.
+	ActionWebhookDeleted = "webhook.deleted"
+	// ActionDKIMKeyCreated is recorded when a DKIM key is generated or imported.
+	ActionDKIMKeyCreated = "dkim_key.created"
+	// ActionDKIMKeyDeleted is recorded when a DKIM key is deleted.
+	ActionDKIMKeyDeleted = "dkim_key.deleted"
+	// ActionSenderDomainCreated is recorded when a sender domain is added.
+	ActionSenderDomainCreated = "sender_domain.created"
+	// ActionSenderDomainVerified is recorded when the ownership of a sender domain is verified.
+	ActionSenderDomainVerified = "sender_domain.verified"
+	// ActionSenderDomainDeleted is recorded when a sender domain is deleted.
+	ActionSenderDomainDeleted = "sender_domain.deleted"
+)
+
+// Event represents an audited action.
+type Event struct {
+	// Action is the name of the action, such as "auth.login_locked".
+	Action string
+	// OrgID is the ID of the organization the action was performed in. Empty for actions outside organizations.
+	OrgID string
+	// ActorID is the ID of the user who performed the action. Empty for system actions.
+	ActorID string
+	// TargetType is the kind of object the action applies to, such as "user" or "ip".
+	TargetType string
+	// TargetID identifies the object the action applies to.
+	TargetID string
+	// IP is the IP address the action was performed from. It defaults to the one stored by Handler.
+	IP string
+	// UserAgent is the user agent of the client. It defaults to the one stored by Handler.
+	UserAgent string
+	// RequestID is the ID of the request the action was performed in. It defaults to the one stored by log.WithRequest.
+	RequestID string
+	// Diff holds the changes made to the target, as built by Diff.
+	Diff map[string]Change
+	// Data holds additional details about the action.
+	Data map[string]interface{}
+}
+
+// Recorder records audit events.
+type Recorder interface {
+	// Record records the event. When the context carries a transaction, the event is
+	// recorded in it so that it is only kept if the audited action is.
+	Record(ctx context.Context, event Event) error
+}
+
+type contextKey int
+
+const clientKey contextKey = iota
+
+// client describes the client a request was sent from.
+type client struct {
+	ip        string
+	userAgent string
+}
+
+// WithClient returns a context that knows the IP address and user agent of the client.
+func WithClient(ctx context.Context, ip, userAgent string) context.Context {
+	return context.WithValue(ctx, clientKey, client{ip, userAgent})
+}
+
+// Handler returns a middleware that stores the IP address and user agent of the client
+// in the request context so that they are recorded along with audit events.
+func Handler() routing.Handler {
+	return func(c *routing.Context) error {
+		ctx := WithClient(c.Request.Context(), clientip.Get(c.Request), c.Request.UserAgent())
+		c.Request = c.Request.WithContext(ctx)
+		return nil
+	}
+}
+
+// Complete fills the client and request details the event lacks from the context.
+func Complete(ctx context.Context, event Event) Event {
+	if c, ok := ctx.Value(clientKey).(client); ok {
+		if event.IP == "" {
+			event.IP = c.ip
+		}
+		if event.UserAgent == "" {
+			event.UserAgent = c.userAgent
+		}
+	}
+	if event.RequestID == "" {
+		event.RequestID = log.RequestID(ctx)
+	}
+	return event
+}
+
+type logRecorder struct {
+	logger log.Logger
+}
+
+// NewLogRecorder creates a Recorder that writes audit events to the application log.
+func NewLogRecorder(logger log.Logger) Recorder {
+	return logRecorder{logger}
+}
+
+// Record writes the event to the log.
+func (r logRecorder) Record(ctx context.Context, event Event) error {
+	event = Complete(ctx, event)
+	r.logger.With(ctx,
+		"audit_action", event.Action,
+		"org_id", event.OrgID,
+		"actor_id", event.ActorID,
+		"target_type", event.TargetType,
+		"target_id", event.TargetID,
+		"ip", event.IP,
+		"user_agent", event.UserAgent,
+		"diff", event.Diff,
+		"data", event.Data,
+	).Info("audit event")
+	return nil
+}
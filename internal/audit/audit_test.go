@@ -0,0 +1,58 @@
+package audit
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/garaekz/gonvelope/internal/test"
+	"github.com/garaekz/gonvelope/pkg/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLogRecorder(t *testing.T) {
+	logger, entries := log.NewForTest()
+	r := NewLogRecorder(logger)
+	err := r.Record(context.Background(), Event{Action: ActionLoginLocked, TargetType: "ip", TargetID: "10.0.0.1"})
+	assert.Nil(t, err)
+	if assert.Equal(t, 1, entries.Len()) {
+		fields := entries.All()[0].ContextMap()
+		assert.Equal(t, ActionLoginLocked, fields["audit_action"])
+		assert.Equal(t, "10.0.0.1", fields["target_id"])
+	}
+}
+
+func TestHandler(t *testing.T) {
+	req, _ := http.NewRequest("GET", "http://example.com", nil)
+	req.RemoteAddr = "10.0.0.1:52000"
+	// forwarding headers are not trusted without a trusted proxy
+	req.Header.Set("X-Real-IP", "10.0.0.9")
+	req.Header.Set("User-Agent", "curl/8.0")
+	req.Header.Set("X-Request-ID", "req1")
+	c, _ := test.MockRoutingContext(req.WithContext(log.WithRequest(context.Background(), req)))
+	assert.Nil(t, Handler()(c))
+
+	event := Complete(c.Request.Context(), Event{Action: ActionTemplateDeleted})
+	assert.Equal(t, "10.0.0.1", event.IP)
+	assert.Equal(t, "curl/8.0", event.UserAgent)
+	assert.Equal(t, "req1", event.RequestID)
+
+	event = Complete(c.Request.Context(), Event{IP: "10.0.0.2"})
+	assert.Equal(t, "10.0.0.2", event.IP)
+	assert.Empty(t, Complete(context.Background(), Event{}).IP)
+}
+
+func TestDiff(t *testing.T) {
+	type item struct {
+		Name      string `json:"name"`
+		Subject   string `json:"subject"`
+		Secret    string `json:"-"`
+		UpdatedAt string `json:"updated_at"`
+	}
+	before := item{Name: "a", Subject: "s", Secret: "x", UpdatedAt: "1"}
+	after := item{Name: "b", Subject: "s", Secret: "y", UpdatedAt: "2"}
+	assert.Equal(t, map[string]Change{"name": {Old: "a", New: "b"}}, Diff(before, after))
+	assert.Equal(t, map[string]Change{"name": {New: "a"}, "subject": {New: "s"}}, Diff(nil, before))
+	assert.Equal(t, map[string]Change{"name": {Old: "a"}, "subject": {Old: "s"}}, Diff(before, nil))
+	assert.Empty(t, Diff(before, before))
+}
@@ -0,0 +1,49 @@
+package audit
+
+import (
+	"encoding/json"
+	"reflect"
+)
+
+// Change represents the change of a single field.
+type Change struct {
+	Old interface{} `json:"old,omitempty"`
+	New interface{} `json:"new,omitempty"`
+}
+
+// ignoredFields lists the fields left out of diffs because they change with every write.
+var ignoredFields = map[string]bool{"created_at": true, "updated_at": true, "last_used_at": true}
+
+// Diff returns the fields that differ between the JSON representations of before and after.
+// Either value can be nil to describe a creation or a deletion. Fields hidden from JSON,
+// such as secrets, never appear in the diff.
+func Diff(before, after interface{}) map[string]Change {
+	prev, next := fields(before), fields(after)
+	diff := map[string]Change{}
+	for name, value := range prev {
+		if ignoredFields[name] {
+			continue
+		}
+		if !reflect.DeepEqual(value, next[name]) {
+			diff[name] = Change{Old: value, New: next[name]}
+		}
+	}
+	for name, value := range next {
+		if _, ok := prev[name]; !ok && !ignoredFields[name] {
+			diff[name] = Change{New: value}
+		}
+	}
+	return diff
+}
+
+// fields returns the fields of the JSON representation of the value.
+func fields(v interface{}) map[string]interface{} {
+	m := map[string]interface{}{}
+	if v == nil {
+		return m
+	}
+	if b, err := json.Marshal(v); err == nil {
+		_ = json.Unmarshal(b, &m)
+	}
+	return m
+}
@@ -0,0 +1,90 @@
+package auditlog
+
+import (
+	"time"
+
+	"github.com/garaekz/gonvelope/internal/auth"
+	"github.com/garaekz/gonvelope/internal/errors"
+	"github.com/garaekz/gonvelope/pkg/log"
+	"github.com/garaekz/gonvelope/pkg/pagination"
+	routing "github.com/garaekz/ozzo-routing"
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+)
+
+// RegisterHandlers sets up the routing of the HTTP handlers.
+// The audit log of the organization made active by orgHandler is only available to its administrators.
+func RegisterHandlers(r *routing.RouteGroup, service Service, authHandler, orgHandler routing.Handler, logger log.Logger) {
+	res := resource{service, logger}
+
+	r.Use(authHandler, orgHandler)
+
+	r.Get("audit-events", auth.Require(auth.PermissionAuditRead), res.query)
+	r.Get("audit-events/export", auth.Require(auth.PermissionAuditRead), res.export)
+}
+
+type resource struct {
+	service Service
+	logger  log.Logger
+}
+
+func (r resource) query(c *routing.Context) error {
+	filter, err := filterFromRequest(c)
+	if err != nil {
+		return err
+	}
+	ctx := c.Request.Context()
+	count, err := r.service.Count(ctx, filter)
+	if err != nil {
+		return err
+	}
+	pages := pagination.NewFromRequest(c.Request, count)
+	events, err := r.service.Query(ctx, filter, pages.Offset(), pages.Limit())
+	if err != nil {
+		return err
+	}
+	pages.Items = events
+	return c.Write(pages)
+}
+
+func (r resource) export(c *routing.Context) error {
+	filter, err := filterFromRequest(c)
+	if err != nil {
+		return err
+	}
+	c.Response.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	c.Response.Header().Set("Content-Disposition", `attachment; filename="audit-events.csv"`)
+	if err := r.service.Export(c.Request.Context(), filter, c.Response); err != nil {
+		// the response has already started, so the error can only be logged
+		r.logger.With(c.Request.Context()).Errorf("failed to export audit events: %v", err)
+	}
+	return nil
+}
+
+// filterFromRequest builds the filter selected by the query parameters of the request,
+// restricted to the active organization.
+func filterFromRequest(c *routing.Context) (Filter, error) {
+	membership, _ := auth.CurrentMembership(c.Request.Context())
+	query := c.Request.URL.Query()
+	filter := Filter{
+		OrgID:      membership.OrgID,
+		ActorID:    query.Get("actor_id"),
+		Action:     query.Get("action"),
+		TargetType: query.Get("target_type"),
+		TargetID:   query.Get("target_id"),
+	}
+	errs := validation.Errors{}
+	for name, t := range map[string]**time.Time{"since": &filter.Since, "until": &filter.Until} {
+		if value := query.Get(name); value != "" {
+			parsed, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				errs[name] = validation.NewError("validation_is_rfc3339", "must be a valid RFC 3339 date")
+				continue
+			}
+			*t = &parsed
+		}
+	}
+	if len(errs) > 0 {
+		return filter, errors.InvalidInput(errs)
+	}
+	return filter, nil
+}
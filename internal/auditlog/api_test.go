@@ -0,0 +1,45 @@
+package auditlog
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/garaekz/gonvelope/internal/audit"
+	"github.com/garaekz/gonvelope/internal/auth"
+	"github.com/garaekz/gonvelope/internal/entity"
+	"github.com/garaekz/gonvelope/internal/org"
+	"github.com/garaekz/gonvelope/internal/test"
+	"github.com/garaekz/gonvelope/pkg/log"
+)
+
+func TestAPI(t *testing.T) {
+	logger, _ := log.NewForTest()
+	router := test.MockRouter(logger)
+	now := time.Now()
+	repo := &mockRepository{items: []entity.AuditEvent{
+		{ID: "1", OrgID: "100", ActorID: "100", Action: audit.ActionAccountLinked, TargetType: "provider_account", TargetID: "a1", CreatedAt: now.Add(-2 * time.Hour)},
+		{ID: "2", OrgID: "100", ActorID: "200", Action: audit.ActionTemplateDeleted, TargetType: "template", TargetID: "t1", Diff: entity.JSON(`{"name":{"old":"welcome"}}`), CreatedAt: now.Add(-time.Hour)},
+		{ID: "3", OrgID: "org1", ActorID: "100", Action: audit.ActionTemplateCreated, TargetType: "template", TargetID: "t2", CreatedAt: now},
+	}}
+	RegisterHandlers(router.Group("/"), NewService(repo, logger), auth.MockAuthHandler, org.MockHandler, logger)
+	header := auth.MockAuthHeader()
+	since := now.Add(-90 * time.Minute).UTC().Format(time.RFC3339)
+
+	tests := []test.APITestCase{
+		{Name: "get all", Method: "GET", URL: "/audit-events", Header: header, WantStatus: http.StatusOK, WantResponse: `*"total_count":2*`},
+		{Name: "newest first", Method: "GET", URL: "/audit-events?per_page=1", Header: header, WantStatus: http.StatusOK, WantResponse: `*"diff":{"name":{"old":"welcome"}}*`},
+		{Name: "filter actor", Method: "GET", URL: "/audit-events?actor_id=100", Header: header, WantStatus: http.StatusOK, WantResponse: `*"action":"account.linked"*`},
+		{Name: "filter action", Method: "GET", URL: "/audit-events?action=template.deleted", Header: header, WantStatus: http.StatusOK, WantResponse: `*"total_count":1*`},
+		{Name: "filter since", Method: "GET", URL: "/audit-events?since=" + since, Header: header, WantStatus: http.StatusOK, WantResponse: `*"total_count":1*`},
+		{Name: "invalid since", Method: "GET", URL: "/audit-events?since=yesterday", Header: header, WantStatus: http.StatusBadRequest, WantResponse: `*since*`},
+		{Name: "export", Method: "GET", URL: "/audit-events/export?target_type=template", Header: header, WantStatus: http.StatusOK, WantResponse: `*,100,200,template.deleted,template,t1,*`},
+		{Name: "viewer", Method: "GET", URL: "/audit-events", Header: org.MockHeader(entity.RoleViewer), WantStatus: http.StatusForbidden},
+		{Name: "editor export", Method: "GET", URL: "/audit-events/export", Header: org.MockHeader(entity.RoleEditor), WantStatus: http.StatusForbidden},
+		{Name: "admin", Method: "GET", URL: "/audit-events", Header: org.MockHeader(entity.RoleAdmin), WantStatus: http.StatusOK},
+		{Name: "unauthorized", Method: "GET", URL: "/audit-events", Header: nil, WantStatus: http.StatusUnauthorized},
+	}
+	for _, tc := range tests {
+		test.Endpoint(t, router, tc)
+	}
+}
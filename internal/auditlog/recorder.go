@@ -0,0 +1,54 @@
+package auditlog
+
+import (
+	"context"
+	"time"
+
+	"github.com/garaekz/gonvelope/internal/audit"
+	"github.com/garaekz/gonvelope/internal/entity"
+	"github.com/garaekz/gonvelope/pkg/log"
+)
+
+type recorder struct {
+	repo   Repository
+	logger log.Logger
+}
+
+// NewRecorder creates an audit.Recorder that appends events to the audit log. Events are saved
+// in the transaction carried by the context, if any, so they are only kept if the audited action is.
+func NewRecorder(repo Repository, logger log.Logger) audit.Recorder {
+	return recorder{repo, logger}
+}
+
+// Record appends the event to the audit log.
+func (r recorder) Record(ctx context.Context, event audit.Event) error {
+	event = audit.Complete(ctx, event)
+	e := entity.AuditEvent{
+		ID:         entity.GenerateID(),
+		OrgID:      event.OrgID,
+		ActorID:    event.ActorID,
+		Action:     event.Action,
+		TargetType: event.TargetType,
+		TargetID:   event.TargetID,
+		IP:         event.IP,
+		UserAgent:  event.UserAgent,
+		RequestID:  event.RequestID,
+		CreatedAt:  time.Now(),
+	}
+	var err error
+	if len(event.Diff) > 0 {
+		if e.Diff, err = entity.NewJSON(event.Diff); err != nil {
+			return err
+		}
+	}
+	if len(event.Data) > 0 {
+		if e.Data, err = entity.NewJSON(event.Data); err != nil {
+			return err
+		}
+	}
+	if err := r.repo.Create(ctx, e); err != nil {
+		r.logger.With(ctx, "audit_action", event.Action).Errorf("failed to record audit event: %v", err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package auditlog
+
+import (
+	"context"
+
+	"github.com/garaekz/gonvelope/internal/entity"
+	"github.com/garaekz/gonvelope/pkg/dbcontext"
+	"github.com/garaekz/gonvelope/pkg/log"
+	dbx "github.com/go-ozzo/ozzo-dbx"
+)
+
+// Repository encapsulates the logic to access audit events from the data source.
+type Repository interface {
+	// Create saves a new audit event in the storage, within the transaction of the context if any.
+	Create(ctx context.Context, event entity.AuditEvent) error
+	// Count returns the number of audit events matching the filter.
+	Count(ctx context.Context, filter Filter) (int, error)
+	// Query returns the audit events matching the filter, newest first, with the given offset and limit.
+	Query(ctx context.Context, filter Filter, offset, limit int) ([]entity.AuditEvent, error)
+}
+
+// repository persists audit events in database
+type repository struct {
+	db     *dbcontext.DB
+	logger log.Logger
+}
+
+// NewRepository creates a new audit event repository
+func NewRepository(db *dbcontext.DB, logger log.Logger) Repository {
+	return repository{db, logger}
+}
+
+// Create saves a new audit event in the storage, within the transaction of the context if any.
+func (r repository) Create(ctx context.Context, event entity.AuditEvent) error {
+	return r.db.With(ctx).Model(&event).Insert()
+}
+
+// Count returns the number of audit events matching the filter.
+func (r repository) Count(ctx context.Context, filter Filter) (int, error) {
+	var count int
+	err := r.db.With(ctx).Select("COUNT(*)").From(entity.AuditEvent{}.TableName()).
+		Where(filter.expression()).Row(&count)
+	return count, err
+}
+
+// Query returns the audit events matching the filter, newest first, with the given offset and limit.
+func (r repository) Query(ctx context.Context, filter Filter, offset, limit int) ([]entity.AuditEvent, error) {
+	var events []entity.AuditEvent
+	err := r.db.With(ctx).Select().From(entity.AuditEvent{}.TableName()).
+		Where(filter.expression()).
+		OrderBy("created_at DESC", "id DESC").
+		Offset(int64(offset)).
+		Limit(int64(limit)).
+		All(&events)
+	return events, err
+}
+
+// expression builds the condition matching the audit events selected by the filter.
+func (f Filter) expression() dbx.Expression {
+	conditions := []dbx.Expression{dbx.HashExp{"org_id": f.OrgID}}
+	for column, value := range map[string]string{
+		"actor_id":    f.ActorID,
+		"action":      f.Action,
+		"target_type": f.TargetType,
+		"target_id":   f.TargetID,
+	} {
+		if value != "" {
+			conditions = append(conditions, dbx.HashExp{column: value})
+		}
+	}
+	if f.Since != nil {
+		conditions = append(conditions, dbx.NewExp("created_at >= {:since}", dbx.Params{"since": *f.Since}))
+	}
+	if f.Until != nil {
+		conditions = append(conditions, dbx.NewExp("created_at < {:until}", dbx.Params{"until": *f.Until}))
+	}
+	return dbx.And(conditions...)
+}
@@ -0,0 +1,126 @@
+package auditlog
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"net/http"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/garaekz/gonvelope/internal/audit"
+	"github.com/garaekz/gonvelope/internal/entity"
+	"github.com/garaekz/gonvelope/pkg/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecorder_Record(t *testing.T) {
+	logger, _ := log.NewForTest()
+	repo := &mockRepository{}
+	r := NewRecorder(repo, logger)
+	req, _ := http.NewRequest("GET", "http://example.com", nil)
+	req.Header.Set("X-Request-ID", "req1")
+	ctx := audit.WithClient(log.WithRequest(context.Background(), req), "10.0.0.1", "curl/8.0")
+
+	err := r.Record(ctx, audit.Event{
+		Action:     audit.ActionTemplateUpdated,
+		OrgID:      "org1",
+		ActorID:    "100",
+		TargetType: "template",
+		TargetID:   "t1",
+		Diff:       audit.Diff(map[string]string{"name": "a"}, map[string]string{"name": "b"}),
+	})
+	require.Nil(t, err)
+	require.Len(t, repo.items, 1)
+	e := repo.items[0]
+	assert.NotEmpty(t, e.ID)
+	assert.Equal(t, "org1", e.OrgID)
+	assert.Equal(t, "10.0.0.1", e.IP)
+	assert.Equal(t, "curl/8.0", e.UserAgent)
+	assert.Equal(t, "req1", e.RequestID)
+	assert.JSONEq(t, `{"name":{"old":"a","new":"b"}}`, string(e.Diff))
+	assert.Nil(t, e.Data)
+
+	repo.err = errors.New("failed")
+	assert.NotNil(t, r.Record(ctx, audit.Event{Action: audit.ActionTemplateDeleted}))
+}
+
+func Test_service_Export(t *testing.T) {
+	logger, _ := log.NewForTest()
+	repo := &mockRepository{}
+	now := time.Now()
+	for i := 0; i < exportBatchSize+2; i++ {
+		repo.items = append(repo.items, entity.AuditEvent{ID: entity.GenerateID(), OrgID: "org1", Action: audit.ActionTemplateCreated, CreatedAt: now.Add(-time.Duration(i) * time.Second)})
+	}
+	repo.items = append(repo.items,
+		entity.AuditEvent{ID: "other", OrgID: "org2", Action: audit.ActionTemplateCreated, CreatedAt: now},
+		entity.AuditEvent{ID: "diff", OrgID: "org1", Action: audit.ActionTemplateDeleted, Diff: entity.JSON(`{"name":{"old":"a"}}`), CreatedAt: now.Add(-time.Hour)},
+	)
+	s := NewService(repo, logger)
+
+	var buf bytes.Buffer
+	require.Nil(t, s.Export(context.Background(), Filter{OrgID: "org1"}, &buf))
+	records, err := csv.NewReader(&buf).ReadAll()
+	require.Nil(t, err)
+	assert.Equal(t, csvHeader, records[0])
+	assert.Len(t, records, exportBatchSize+4)
+	last := records[len(records)-1]
+	assert.Equal(t, "diff", last[0])
+	assert.Equal(t, `{"name":{"old":"a"}}`, last[10])
+
+	buf.Reset()
+	require.Nil(t, s.Export(context.Background(), Filter{OrgID: "org1", Action: audit.ActionTemplateDeleted}, &buf))
+	records, _ = csv.NewReader(&buf).ReadAll()
+	assert.Len(t, records, 2)
+}
+
+type mockRepository struct {
+	items []entity.AuditEvent
+	err   error
+}
+
+func (m *mockRepository) Create(_ context.Context, event entity.AuditEvent) error {
+	if m.err != nil {
+		return m.err
+	}
+	m.items = append(m.items, event)
+	return nil
+}
+
+func (m *mockRepository) Count(ctx context.Context, filter Filter) (int, error) {
+	return len(m.filter(filter)), nil
+}
+
+func (m *mockRepository) Query(_ context.Context, filter Filter, offset, limit int) ([]entity.AuditEvent, error) {
+	items := m.filter(filter)
+	if offset > len(items) {
+		offset = len(items)
+	}
+	items = items[offset:]
+	if limit > 0 && limit < len(items) {
+		items = items[:limit]
+	}
+	return items, nil
+}
+
+// filter returns the events matching the filter, newest first.
+func (m *mockRepository) filter(f Filter) []entity.AuditEvent {
+	var items []entity.AuditEvent
+	for _, e := range m.items {
+		if e.OrgID != f.OrgID ||
+			f.ActorID != "" && e.ActorID != f.ActorID ||
+			f.Action != "" && e.Action != f.Action ||
+			f.TargetType != "" && e.TargetType != f.TargetType ||
+			f.TargetID != "" && e.TargetID != f.TargetID ||
+			f.Since != nil && e.CreatedAt.Before(*f.Since) ||
+			f.Until != nil && !e.CreatedAt.Before(*f.Until) {
+			continue
+		}
+		items = append(items, e)
+	}
+	sort.SliceStable(items, func(i, j int) bool { return items[i].CreatedAt.After(items[j].CreatedAt) })
+	return items
+}
@@ -1,15 +1,37 @@
 package auth
 
 import (
+	"net/http"
+
 	"github.com/garaekz/gonvelope/internal/errors"
+	"github.com/garaekz/gonvelope/pkg/clientip"
 	"github.com/garaekz/gonvelope/pkg/log"
 	routing "github.com/garaekz/ozzo-routing"
 )
 
 // RegisterHandlers registers handlers for different HTTP requests.
-func RegisterHandlers(rg *routing.RouteGroup, service Service, logger log.Logger) {
+func RegisterHandlers(rg *routing.RouteGroup, service Service, authHandler routing.Handler, logger log.Logger) {
 	rg.Post("login", login(service, logger))
+	rg.Post("login/2fa", loginTwoFactor(service, logger))
 	rg.Post("register", register(service, logger))
+	rg.Get("verify", verify(service))
+	rg.Post("verify/resend", resendVerification(service, logger))
+	rg.Post("password/forgot", forgotPassword(service, logger))
+	rg.Get("password/reset", checkResetToken(service))
+	rg.Post("password/reset", resetPassword(service, logger))
+	rg.Get("verify/email-change", confirmEmailChange(service))
+
+	rg.Use(authHandler)
+	rg.Post("2fa/enroll", enrollTOTP(service))
+	rg.Post("2fa/confirm", confirmTOTP(service, logger))
+	rg.Post("2fa/disable", disableTOTP(service, logger))
+	rg.Post("2fa/recovery-codes", regenerateRecoveryCodes(service, logger))
+	rg.Get("me", getProfile(service))
+	rg.Patch("me", updateProfile(service, logger))
+	rg.Post("me/email", changeEmail(service, logger))
+	rg.Post("me/password", changePassword(service, logger))
+	rg.Post("me/deactivate", deactivate(service, logger))
+	rg.Post("admin/users/<id>/unlock", unlockAccount(service))
 }
 
 // login returns a handler that handles user login request.
@@ -25,7 +47,28 @@ func login(service Service, logger log.Logger) routing.Handler {
 			return errors.BadRequest("")
 		}
 
-		token, err := service.Login(c.Request.Context(), req.Email, req.Password)
+		result, err := service.Login(c.Request.Context(), req.Email, req.Password, clientip.Get(c.Request))
+		if err != nil {
+			return err
+		}
+		return c.Write(result)
+	}
+}
+
+// loginTwoFactor returns a handler that exchanges a two-factor challenge token and code for a JWT token.
+func loginTwoFactor(service Service, logger log.Logger) routing.Handler {
+	return func(c *routing.Context) error {
+		var req struct {
+			ChallengeToken string `json:"challenge_token"`
+			Code           string `json:"code"`
+		}
+
+		if err := c.Read(&req); err != nil {
+			logger.With(c.Request.Context()).Errorf("invalid request: %v", err)
+			return errors.BadRequest("")
+		}
+
+		token, err := service.VerifyTwoFactor(c.Request.Context(), req.ChallengeToken, req.Code)
 		if err != nil {
 			return err
 		}
@@ -38,18 +81,13 @@ func login(service Service, logger log.Logger) routing.Handler {
 // register returns a handler that handles user registration request.
 func register(service Service, logger log.Logger) routing.Handler {
 	return func(c *routing.Context) error {
-		var req struct {
-			Name     string `json:"name"`
-			Email    string `json:"email"`
-			Password string `json:"password"`
-		}
-
+		var req RegisterRequest
 		if err := c.Read(&req); err != nil {
 			logger.With(c.Request.Context()).Errorf("invalid request: %v", err)
 			return errors.BadRequest("")
 		}
 
-		if err := service.Register(c.Request.Context(), req.Name, req.Email, req.Password); err != nil {
+		if err := service.Register(c.Request.Context(), req); err != nil {
 			return err
 		}
 		return c.WriteWithStatus(struct {
@@ -57,7 +95,290 @@ func register(service Service, logger log.Logger) routing.Handler {
 			Message string `json:"message"`
 		}{
 			Status:  201,
-			Message: "User was created successfully",
+			Message: "User was created successfully, check your email to verify your account",
 		}, 201)
 	}
 }
+
+// verify returns a handler that handles email verification links.
+func verify(service Service) routing.Handler {
+	return func(c *routing.Context) error {
+		if err := service.VerifyEmail(c.Request.Context(), c.Query("token")); err != nil {
+			return err
+		}
+		return c.Write(struct {
+			Message string `json:"message"`
+		}{"Your email was verified successfully"})
+	}
+}
+
+// resendVerification returns a handler that sends a new email verification link.
+func resendVerification(service Service, logger log.Logger) routing.Handler {
+	return func(c *routing.Context) error {
+		var req struct {
+			Email string `json:"email"`
+		}
+
+		if err := c.Read(&req); err != nil {
+			logger.With(c.Request.Context()).Errorf("invalid request: %v", err)
+			return errors.BadRequest("")
+		}
+
+		if err := service.ResendVerification(c.Request.Context(), req.Email); err != nil {
+			return err
+		}
+		return c.WriteWithStatus(struct {
+			Message string `json:"message"`
+		}{"If the account is pending verification, a new verification email has been sent"}, http.StatusAccepted)
+	}
+}
+
+// forgotPassword returns a handler that sends a password reset link.
+// The response is the same whether or not the account exists.
+func forgotPassword(service Service, logger log.Logger) routing.Handler {
+	return func(c *routing.Context) error {
+		var req struct {
+			Email string `json:"email"`
+		}
+
+		if err := c.Read(&req); err != nil {
+			logger.With(c.Request.Context()).Errorf("invalid request: %v", err)
+			return errors.BadRequest("")
+		}
+
+		if err := service.ForgotPassword(c.Request.Context(), req.Email); err != nil {
+			return err
+		}
+		return c.WriteWithStatus(struct {
+			Message string `json:"message"`
+		}{"If an account exists for this email, a password reset link has been sent"}, http.StatusAccepted)
+	}
+}
+
+// checkResetToken returns a handler that handles password reset links by checking their token.
+// The new password is then sent along with the token to the POST endpoint.
+func checkResetToken(service Service) routing.Handler {
+	return func(c *routing.Context) error {
+		if err := service.CheckResetToken(c.Request.Context(), c.Query("token")); err != nil {
+			return err
+		}
+		return c.Write(struct {
+			Message string `json:"message"`
+		}{"The password reset link is valid, send the token along with your new password to reset it"})
+	}
+}
+
+// resetPassword returns a handler that sets a new password using a password reset token.
+func resetPassword(service Service, logger log.Logger) routing.Handler {
+	return func(c *routing.Context) error {
+		var req struct {
+			Token    string `json:"token"`
+			Password string `json:"password"`
+		}
+
+		if err := c.Read(&req); err != nil {
+			logger.With(c.Request.Context()).Errorf("invalid request: %v", err)
+			return errors.BadRequest("")
+		}
+
+		if err := service.ResetPassword(c.Request.Context(), req.Token, req.Password); err != nil {
+			return err
+		}
+		return c.Write(struct {
+			Message string `json:"message"`
+		}{"Your password was reset successfully"})
+	}
+}
+
+// twoFactorCodeRequest represents a request carrying a two-factor code.
+type twoFactorCodeRequest struct {
+	Code string `json:"code"`
+}
+
+// recoveryCodesResponse represents a response carrying freshly generated recovery codes.
+type recoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// enrollTOTP returns a handler that starts a TOTP enrollment for the current user.
+func enrollTOTP(service Service) routing.Handler {
+	return func(c *routing.Context) error {
+		identity := CurrentUser(c.Request.Context())
+		enrollment, err := service.EnrollTOTP(c.Request.Context(), identity.GetID())
+		if err != nil {
+			return err
+		}
+		return c.Write(enrollment)
+	}
+}
+
+// confirmTOTP returns a handler that enables two-factor authentication for the current user.
+func confirmTOTP(service Service, logger log.Logger) routing.Handler {
+	return func(c *routing.Context) error {
+		var req twoFactorCodeRequest
+		if err := c.Read(&req); err != nil {
+			logger.With(c.Request.Context()).Errorf("invalid request: %v", err)
+			return errors.BadRequest("")
+		}
+
+		identity := CurrentUser(c.Request.Context())
+		codes, err := service.ConfirmTOTP(c.Request.Context(), identity.GetID(), req.Code)
+		if err != nil {
+			return err
+		}
+		return c.Write(recoveryCodesResponse{codes})
+	}
+}
+
+// disableTOTP returns a handler that disables two-factor authentication for the current user.
+func disableTOTP(service Service, logger log.Logger) routing.Handler {
+	return func(c *routing.Context) error {
+		var req twoFactorCodeRequest
+		if err := c.Read(&req); err != nil {
+			logger.With(c.Request.Context()).Errorf("invalid request: %v", err)
+			return errors.BadRequest("")
+		}
+
+		identity := CurrentUser(c.Request.Context())
+		if err := service.DisableTOTP(c.Request.Context(), identity.GetID(), req.Code); err != nil {
+			return err
+		}
+		return c.Write(struct {
+			Message string `json:"message"`
+		}{"Two-factor authentication was disabled"})
+	}
+}
+
+// regenerateRecoveryCodes returns a handler that replaces the recovery codes of the current user.
+func regenerateRecoveryCodes(service Service, logger log.Logger) routing.Handler {
+	return func(c *routing.Context) error {
+		var req twoFactorCodeRequest
+		if err := c.Read(&req); err != nil {
+			logger.With(c.Request.Context()).Errorf("invalid request: %v", err)
+			return errors.BadRequest("")
+		}
+
+		identity := CurrentUser(c.Request.Context())
+		codes, err := service.RegenerateRecoveryCodes(c.Request.Context(), identity.GetID(), req.Code)
+		if err != nil {
+			return err
+		}
+		return c.Write(recoveryCodesResponse{codes})
+	}
+}
+
+// confirmEmailChange returns a handler that handles email change confirmation links.
+func confirmEmailChange(service Service) routing.Handler {
+	return func(c *routing.Context) error {
+		if err := service.ConfirmEmailChange(c.Request.Context(), c.Query("token")); err != nil {
+			return err
+		}
+		return c.Write(struct {
+			Message string `json:"message"`
+		}{"Your email address was changed successfully"})
+	}
+}
+
+// getProfile returns a handler that returns the profile of the current user.
+func getProfile(service Service) routing.Handler {
+	return func(c *routing.Context) error {
+		identity := CurrentUser(c.Request.Context())
+		profile, err := service.GetProfile(c.Request.Context(), identity.GetID())
+		if err != nil {
+			return err
+		}
+		return c.Write(profile)
+	}
+}
+
+// updateProfile returns a handler that updates the profile of the current user.
+func updateProfile(service Service, logger log.Logger) routing.Handler {
+	return func(c *routing.Context) error {
+		var req UpdateProfileRequest
+		if err := c.Read(&req); err != nil {
+			logger.With(c.Request.Context()).Errorf("invalid request: %v", err)
+			return errors.BadRequest("")
+		}
+
+		identity := CurrentUser(c.Request.Context())
+		profile, err := service.UpdateProfile(c.Request.Context(), identity.GetID(), req)
+		if err != nil {
+			return err
+		}
+		return c.Write(profile)
+	}
+}
+
+// changeEmail returns a handler that starts an email address change for the current user.
+func changeEmail(service Service, logger log.Logger) routing.Handler {
+	return func(c *routing.Context) error {
+		var req ChangeEmailRequest
+		if err := c.Read(&req); err != nil {
+			logger.With(c.Request.Context()).Errorf("invalid request: %v", err)
+			return errors.BadRequest("")
+		}
+
+		identity := CurrentUser(c.Request.Context())
+		if err := service.RequestEmailChange(c.Request.Context(), identity.GetID(), req); err != nil {
+			return err
+		}
+		return c.WriteWithStatus(struct {
+			Message string `json:"message"`
+		}{"Check your new email address to confirm the change"}, http.StatusAccepted)
+	}
+}
+
+// changePassword returns a handler that changes the password of the current user.
+// The response carries a new token since the previous ones are revoked.
+func changePassword(service Service, logger log.Logger) routing.Handler {
+	return func(c *routing.Context) error {
+		var req ChangePasswordRequest
+		if err := c.Read(&req); err != nil {
+			logger.With(c.Request.Context()).Errorf("invalid request: %v", err)
+			return errors.BadRequest("")
+		}
+
+		identity := CurrentUser(c.Request.Context())
+		token, err := service.ChangePassword(c.Request.Context(), identity.GetID(), req)
+		if err != nil {
+			return err
+		}
+		return c.Write(struct {
+			Token string `json:"token"`
+		}{token})
+	}
+}
+
+// deactivate returns a handler that deactivates the account of the current user.
+func deactivate(service Service, logger log.Logger) routing.Handler {
+	return func(c *routing.Context) error {
+		var req struct {
+			Password string `json:"password"`
+		}
+		if err := c.Read(&req); err != nil {
+			logger.With(c.Request.Context()).Errorf("invalid request: %v", err)
+			return errors.BadRequest("")
+		}
+
+		identity := CurrentUser(c.Request.Context())
+		if err := service.Deactivate(c.Request.Context(), identity.GetID(), req.Password); err != nil {
+			return err
+		}
+		return c.Write(struct {
+			Message string `json:"message"`
+		}{"Your account was deactivated"})
+	}
+}
+
+// unlockAccount returns a handler that lets an administrator unlock an account locked out after failed logins.
+func unlockAccount(service Service) routing.Handler {
+	return func(c *routing.Context) error {
+		identity := CurrentUser(c.Request.Context())
+		if err := service.UnlockAccount(c.Request.Context(), identity.GetID(), c.Param("id"), clientip.Get(c.Request)); err != nil {
+			return err
+		}
+		return c.Write(struct {
+			Message string `json:"message"`
+		}{"The account was unlocked"})
+	}
+}
@@ -12,22 +12,176 @@ import (
 
 type mockService struct{}
 
-func (mockService) Login(_ context.Context, username, password string) (string, error) {
-	if username == "test" && password == "pass" {
+func (mockService) Login(_ context.Context, email, password, _ string) (LoginResult, error) {
+	if email == "test" && password == "pass" {
+		return LoginResult{Token: "token-100"}, nil
+	}
+	if email == "2fa" && password == "pass" {
+		return LoginResult{ChallengeToken: "challenge-100", TwoFactorRequired: true}, nil
+	}
+	return LoginResult{}, errors.Unauthorized("")
+}
+
+func (mockService) Register(_ context.Context, req RegisterRequest) error {
+	if req.Email == "test" {
+		return errors.Conflict("email already exists")
+	}
+	return nil
+}
+
+func (mockService) VerifyEmail(_ context.Context, token string) error {
+	if token == "valid" {
+		return nil
+	}
+	return errors.BadRequest("")
+}
+
+func (mockService) ResendVerification(_ context.Context, _ string) error {
+	return nil
+}
+
+func (mockService) ForgotPassword(_ context.Context, _ string) error {
+	return nil
+}
+
+func (mockService) CheckResetToken(_ context.Context, token string) error {
+	if token == "valid" {
+		return nil
+	}
+	return errors.BadRequest("")
+}
+
+func (mockService) ResetPassword(_ context.Context, token, _ string) error {
+	if token == "valid" {
+		return nil
+	}
+	return errors.BadRequest("")
+}
+
+func (mockService) EnrollTOTP(_ context.Context, _ string) (TOTPEnrollment, error) {
+	return TOTPEnrollment{Secret: "ABC", URI: "otpauth://totp/test"}, nil
+}
+
+func (mockService) ConfirmTOTP(_ context.Context, _, code string) ([]string, error) {
+	if code == "123456" {
+		return []string{"aaaaa-bbbbb"}, nil
+	}
+	return nil, errors.Unauthorized("")
+}
+
+func (mockService) DisableTOTP(_ context.Context, _, code string) error {
+	if code == "123456" {
+		return nil
+	}
+	return errors.Unauthorized("")
+}
+
+func (mockService) RegenerateRecoveryCodes(_ context.Context, _, code string) ([]string, error) {
+	if code == "123456" {
+		return []string{"ccccc-ddddd"}, nil
+	}
+	return nil, errors.Unauthorized("")
+}
+
+func (mockService) VerifyTwoFactor(_ context.Context, challengeToken, code string) (string, error) {
+	if challengeToken == "challenge-100" && code == "123456" {
 		return "token-100", nil
 	}
 	return "", errors.Unauthorized("")
 }
 
+func (mockService) GetProfile(_ context.Context, userID string) (Profile, error) {
+	return Profile{ID: userID, Name: "Tester", Email: "test@example.com", Accounts: []AccountSummary{}}, nil
+}
+
+func (mockService) UpdateProfile(_ context.Context, userID string, req UpdateProfileRequest) (Profile, error) {
+	if err := req.Validate(); err != nil {
+		return Profile{}, invalidInput(err)
+	}
+	return Profile{ID: userID, Name: req.Name, Email: "test@example.com", Accounts: []AccountSummary{}}, nil
+}
+
+func (mockService) RequestEmailChange(_ context.Context, _ string, req ChangeEmailRequest) error {
+	if req.Email == "taken@example.com" {
+		return errors.Conflict("")
+	}
+	return nil
+}
+
+func (mockService) ConfirmEmailChange(_ context.Context, token string) error {
+	if token == "valid" {
+		return nil
+	}
+	return errors.BadRequest("")
+}
+
+func (mockService) ChangePassword(_ context.Context, _ string, req ChangePasswordRequest) (string, error) {
+	if req.CurrentPassword == "pass" {
+		return "token-101", nil
+	}
+	return "", errors.BadRequest("")
+}
+
+func (mockService) Deactivate(_ context.Context, _, password string) error {
+	if password == "pass" {
+		return nil
+	}
+	return errors.BadRequest("")
+}
+
+func (mockService) UnlockAccount(_ context.Context, adminID, userID, _ string) error {
+	if adminID != "100" {
+		return errors.Forbidden("")
+	}
+	if userID != "200" {
+		return errors.NotFound("")
+	}
+	return nil
+}
+
 func TestAPI(t *testing.T) {
 	logger, _ := log.NewForTest()
 	router := test.MockRouter(logger)
-	RegisterHandlers(router.Group(""), mockService{}, logger)
+	RegisterHandlers(router.Group("/"), mockService{}, MockAuthHandler, logger)
 
 	tests := []test.APITestCase{
-		{Name: "success", Method: "POST", URL: "/login", Body: `{"username":"test","password":"pass"}`, Header: nil, WantStatus: http.StatusOK, WantResponse: `{"token":"token-100"}`},
-		{Name: "bad credential", Method: "POST", URL: "/login", Body: `{"username":"test","password":"wrong pass"}`, Header: nil, WantStatus: http.StatusUnauthorized, WantResponse: ""},
-		{Name: "bad json", Method: "POST", URL: "/login", Body: `"username":"test","password":"wrong pass"}`, Header: nil, WantStatus: http.StatusBadRequest, WantResponse: ""},
+		{Name: "success", Method: "POST", URL: "/login", Body: `{"email":"test","password":"pass"}`, Header: nil, WantStatus: http.StatusOK, WantResponse: `{"token":"token-100"}`},
+		{Name: "bad credential", Method: "POST", URL: "/login", Body: `{"email":"test","password":"wrong pass"}`, Header: nil, WantStatus: http.StatusUnauthorized, WantResponse: ""},
+		{Name: "bad json", Method: "POST", URL: "/login", Body: `"email":"test","password":"wrong pass"}`, Header: nil, WantStatus: http.StatusBadRequest, WantResponse: ""},
+		{Name: "register", Method: "POST", URL: "/register", Body: `{"name":"new","email":"new","password":"pass"}`, Header: nil, WantStatus: http.StatusCreated, WantResponse: "*check your email*"},
+		{Name: "register existing", Method: "POST", URL: "/register", Body: `{"name":"test","email":"test","password":"pass"}`, Header: nil, WantStatus: http.StatusConflict, WantResponse: ""},
+		{Name: "verify", Method: "GET", URL: "/verify?token=valid", Body: "", Header: nil, WantStatus: http.StatusOK, WantResponse: ""},
+		{Name: "verify invalid", Method: "GET", URL: "/verify?token=invalid", Body: "", Header: nil, WantStatus: http.StatusBadRequest, WantResponse: ""},
+		{Name: "resend", Method: "POST", URL: "/verify/resend", Body: `{"email":"new"}`, Header: nil, WantStatus: http.StatusAccepted, WantResponse: ""},
+		{Name: "forgot password", Method: "POST", URL: "/password/forgot", Body: `{"email":"unknown"}`, Header: nil, WantStatus: http.StatusAccepted, WantResponse: ""},
+		{Name: "check reset link", Method: "GET", URL: "/password/reset?token=valid", Body: "", Header: nil, WantStatus: http.StatusOK, WantResponse: ""},
+		{Name: "check reset link invalid", Method: "GET", URL: "/password/reset?token=invalid", Body: "", Header: nil, WantStatus: http.StatusBadRequest, WantResponse: ""},
+		{Name: "reset password", Method: "POST", URL: "/password/reset", Body: `{"token":"valid","password":"new"}`, Header: nil, WantStatus: http.StatusOK, WantResponse: ""},
+		{Name: "reset password invalid", Method: "POST", URL: "/password/reset", Body: `{"token":"invalid","password":"new"}`, Header: nil, WantStatus: http.StatusBadRequest, WantResponse: ""},
+		{Name: "login 2fa challenge", Method: "POST", URL: "/login", Body: `{"email":"2fa","password":"pass"}`, Header: nil, WantStatus: http.StatusOK, WantResponse: `{"challenge_token":"challenge-100","two_factor_required":true}`},
+		{Name: "login 2fa", Method: "POST", URL: "/login/2fa", Body: `{"challenge_token":"challenge-100","code":"123456"}`, Header: nil, WantStatus: http.StatusOK, WantResponse: `{"token":"token-100"}`},
+		{Name: "login 2fa invalid", Method: "POST", URL: "/login/2fa", Body: `{"challenge_token":"challenge-100","code":"000000"}`, Header: nil, WantStatus: http.StatusUnauthorized, WantResponse: ""},
+		{Name: "enroll unauthorized", Method: "POST", URL: "/2fa/enroll", Body: "", Header: nil, WantStatus: http.StatusUnauthorized, WantResponse: ""},
+		{Name: "enroll", Method: "POST", URL: "/2fa/enroll", Body: "", Header: MockAuthHeader(), WantStatus: http.StatusOK, WantResponse: `{"secret":"ABC","uri":"otpauth://totp/test"}`},
+		{Name: "confirm", Method: "POST", URL: "/2fa/confirm", Body: `{"code":"123456"}`, Header: MockAuthHeader(), WantStatus: http.StatusOK, WantResponse: `{"recovery_codes":["aaaaa-bbbbb"]}`},
+		{Name: "confirm invalid", Method: "POST", URL: "/2fa/confirm", Body: `{"code":"000000"}`, Header: MockAuthHeader(), WantStatus: http.StatusUnauthorized, WantResponse: ""},
+		{Name: "disable", Method: "POST", URL: "/2fa/disable", Body: `{"code":"123456"}`, Header: MockAuthHeader(), WantStatus: http.StatusOK, WantResponse: ""},
+		{Name: "confirm email change", Method: "GET", URL: "/verify/email-change?token=valid", Body: "", Header: nil, WantStatus: http.StatusOK, WantResponse: ""},
+		{Name: "confirm email change invalid", Method: "GET", URL: "/verify/email-change?token=invalid", Body: "", Header: nil, WantStatus: http.StatusBadRequest, WantResponse: ""},
+		{Name: "get profile unauthorized", Method: "GET", URL: "/me", Body: "", Header: nil, WantStatus: http.StatusUnauthorized, WantResponse: ""},
+		{Name: "get profile", Method: "GET", URL: "/me", Body: "", Header: MockAuthHeader(), WantStatus: http.StatusOK, WantResponse: `*"id":"100"*`},
+		{Name: "update profile", Method: "PATCH", URL: "/me", Body: `{"name":"New name"}`, Header: MockAuthHeader(), WantStatus: http.StatusOK, WantResponse: `*"name":"New name"*`},
+		{Name: "update profile invalid", Method: "PATCH", URL: "/me", Body: `{"name":""}`, Header: MockAuthHeader(), WantStatus: http.StatusBadRequest, WantResponse: `*"field":"name"*`},
+		{Name: "change email", Method: "POST", URL: "/me/email", Body: `{"email":"new@example.com","password":"pass"}`, Header: MockAuthHeader(), WantStatus: http.StatusAccepted, WantResponse: ""},
+		{Name: "change email taken", Method: "POST", URL: "/me/email", Body: `{"email":"taken@example.com","password":"pass"}`, Header: MockAuthHeader(), WantStatus: http.StatusConflict, WantResponse: ""},
+		{Name: "change password", Method: "POST", URL: "/me/password", Body: `{"current_password":"pass","new_password":"new-passphrase"}`, Header: MockAuthHeader(), WantStatus: http.StatusOK, WantResponse: `{"token":"token-101"}`},
+		{Name: "change password wrong", Method: "POST", URL: "/me/password", Body: `{"current_password":"wrong","new_password":"new-passphrase"}`, Header: MockAuthHeader(), WantStatus: http.StatusBadRequest, WantResponse: ""},
+		{Name: "deactivate", Method: "POST", URL: "/me/deactivate", Body: `{"password":"pass"}`, Header: MockAuthHeader(), WantStatus: http.StatusOK, WantResponse: ""},
+		{Name: "deactivate wrong password", Method: "POST", URL: "/me/deactivate", Body: `{"password":"wrong"}`, Header: MockAuthHeader(), WantStatus: http.StatusBadRequest, WantResponse: ""},
+		{Name: "unlock unauthorized", Method: "POST", URL: "/admin/users/200/unlock", Body: "", Header: nil, WantStatus: http.StatusUnauthorized, WantResponse: ""},
+		{Name: "unlock", Method: "POST", URL: "/admin/users/200/unlock", Body: "", Header: MockAuthHeader(), WantStatus: http.StatusOK, WantResponse: `{"message":"The account was unlocked"}`},
+		{Name: "unlock unknown", Method: "POST", URL: "/admin/users/300/unlock", Body: "", Header: MockAuthHeader(), WantStatus: http.StatusNotFound, WantResponse: ""},
+		{Name: "regenerate recovery codes", Method: "POST", URL: "/2fa/recovery-codes", Body: `{"code":"123456"}`, Header: MockAuthHeader(), WantStatus: http.StatusOK, WantResponse: `{"recovery_codes":["ccccc-ddddd"]}`},
 	}
 	for _, tc := range tests {
 		test.Endpoint(t, router, tc)
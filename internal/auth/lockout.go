@@ -0,0 +1,140 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/garaekz/gonvelope/internal/audit"
+	"github.com/garaekz/gonvelope/internal/errors"
+)
+
+// LockoutPolicy represents the policy applied to failed login attempts.
+// Failures are counted separately for the email used to log in and for the client IP address.
+type LockoutPolicy struct {
+	// FreeAttempts is the number of consecutive failures allowed before attempts are delayed.
+	FreeAttempts int
+	// Delay is the delay imposed after the first throttled failure. It doubles on every further failure.
+	Delay time.Duration
+	// MaxDelay caps the delay between two attempts.
+	MaxDelay time.Duration
+	// MaxFailures is the number of failures that locks out an email.
+	MaxFailures int
+	// IPMaxFailures is the number of failures that locks out an IP address.
+	IPMaxFailures int
+	// LockoutDuration is how long a locked out email or IP address stays locked.
+	LockoutDuration time.Duration
+	// FailureWindow is the time without failures after which the failure count is reset.
+	FailureWindow time.Duration
+}
+
+// delay returns the time a client must wait after the given number of consecutive failures.
+func (p LockoutPolicy) delay(failures int) time.Duration {
+	if failures <= p.FreeAttempts {
+		return 0
+	}
+	d := float64(p.Delay) * math.Pow(2, float64(failures-p.FreeAttempts-1))
+	if d > float64(p.MaxDelay) {
+		return p.MaxDelay
+	}
+	return time.Duration(d)
+}
+
+// loginKey identifies a login failure counter.
+type loginKey struct {
+	key         string
+	targetType  string
+	targetID    string
+	maxFailures int
+}
+
+// emailLoginKey returns the failure counter key of the given email.
+func emailLoginKey(email string) string {
+	return "email:" + normalizeEmail(email)
+}
+
+// loginKeys returns the failure counters a login attempt for the email from the IP address is subject to.
+func (s service) loginKeys(email, ip string) []loginKey {
+	keys := []loginKey{{emailLoginKey(email), "email", email, s.lockout.MaxFailures}}
+	if ip != "" {
+		keys = append(keys, loginKey{"ip:" + ip, "ip", ip, s.lockout.IPMaxFailures})
+	}
+	return keys
+}
+
+// checkThrottle returns an error if any of the keys is locked out or must wait before trying again.
+func (s service) checkThrottle(ctx context.Context, keys []loginKey, now time.Time) error {
+	for _, k := range keys {
+		failure, err := s.repo.GetLoginFailure(ctx, k.key)
+		if err == sql.ErrNoRows {
+			continue
+		} else if err != nil {
+			return err
+		}
+		if failure.IsLocked(now) {
+			return errors.TooManyRequests("Too many failed login attempts, try again later")
+		}
+		if now.Sub(failure.LastFailedAt) > s.lockout.FailureWindow {
+			continue
+		}
+		if wait := failure.LastFailedAt.Add(s.lockout.delay(failure.Failures)).Sub(now); wait > 0 {
+			return errors.TooManyRequests(fmt.Sprintf("Too many failed login attempts, try again in %d seconds", int(math.Ceil(wait.Seconds()))))
+		}
+	}
+	return nil
+}
+
+// recordLoginFailure counts a failed login attempt against the keys and locks out those reaching their limit.
+func (s service) recordLoginFailure(ctx context.Context, keys []loginKey, ip string, now time.Time) {
+	logger := s.logger.With(ctx, "ip", ip)
+	for _, k := range keys {
+		failures, err := s.repo.RecordLoginFailure(ctx, k.key, now, now.Add(-s.lockout.FailureWindow))
+		if err != nil {
+			logger.Errorf("failed to record login failure: %v", err)
+			continue
+		}
+		if failures < k.maxFailures {
+			continue
+		}
+		until := now.Add(s.lockout.LockoutDuration)
+		if err := s.repo.LockLogin(ctx, k.key, until); err != nil {
+			logger.Errorf("failed to lock out %s: %v", k.key, err)
+			continue
+		}
+		logger.Infof("%s locked out until %s after %d failed login attempts", k.key, until.Format(time.RFC3339), failures)
+		err = s.auditor.Record(ctx, audit.Event{
+			Action:     audit.ActionLoginLocked,
+			TargetType: k.targetType,
+			TargetID:   k.targetID,
+			IP:         ip,
+			Data:       map[string]interface{}{"failures": failures, "locked_until": until},
+		})
+		if err != nil {
+			logger.Errorf("failed to record audit event: %v", err)
+		}
+	}
+}
+
+// UnlockAccount clears the failed login attempts of a user. Only administrators may unlock accounts.
+func (s service) UnlockAccount(ctx context.Context, adminID, userID, ip string) error {
+	admin, err := s.repo.GetUserByID(ctx, adminID)
+	if err != nil || !admin.IsAdmin {
+		return errors.Forbidden("")
+	}
+	user, err := s.repo.GetUserByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if err := s.repo.ResetLoginFailures(ctx, emailLoginKey(user.Email)); err != nil {
+		return err
+	}
+	return s.auditor.Record(ctx, audit.Event{
+		Action:     audit.ActionLoginUnlocked,
+		ActorID:    adminID,
+		TargetType: "user",
+		TargetID:   userID,
+		IP:         ip,
+	})
+}
@@ -0,0 +1,176 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/garaekz/gonvelope/internal/audit"
+	"github.com/garaekz/gonvelope/internal/entity"
+	"github.com/garaekz/gonvelope/internal/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testLockoutPolicy = LockoutPolicy{
+	FreeAttempts:    2,
+	Delay:           time.Second,
+	MaxDelay:        4 * time.Second,
+	MaxFailures:     4,
+	IPMaxFailures:   6,
+	LockoutDuration: 15 * time.Minute,
+	FailureWindow:   time.Hour,
+}
+
+func TestLockoutPolicy_delay(t *testing.T) {
+	p := testLockoutPolicy
+	assert.Equal(t, time.Duration(0), p.delay(0))
+	assert.Equal(t, time.Duration(0), p.delay(2))
+	assert.Equal(t, time.Second, p.delay(3))
+	assert.Equal(t, 2*time.Second, p.delay(4))
+	assert.Equal(t, 4*time.Second, p.delay(5))
+	assert.Equal(t, 4*time.Second, p.delay(10))
+}
+
+func Test_service_Login_throttle(t *testing.T) {
+	s, repo, _ := newTestService(t)
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		_, err := s.Login(ctx, "demo", "bad", "10.0.0.1")
+		assert.Equal(t, http.StatusUnauthorized, statusCode(err))
+	}
+	// the third failure imposes a delay, even for the right password
+	_, err := s.Login(ctx, "demo", "pass", "10.0.0.1")
+	assert.Equal(t, http.StatusTooManyRequests, statusCode(err))
+
+	repo.rewindLoginFailures(testLockoutPolicy.MaxDelay)
+	result, err := s.Login(ctx, "demo", "pass", "10.0.0.1")
+	require.Nil(t, err)
+	assert.NotEmpty(t, result.Token)
+	// a successful login resets the email but not the IP address
+	assert.NotContains(t, repo.loginFailures, "email:demo")
+	assert.Equal(t, 3, repo.loginFailures["ip:10.0.0.1"].Failures)
+
+	// failures are forgotten after the failure window
+	repo.rewindLoginFailures(testLockoutPolicy.FailureWindow + time.Minute)
+	_, err = s.Login(ctx, "other", "bad", "10.0.0.1")
+	assert.Equal(t, http.StatusUnauthorized, statusCode(err))
+	assert.Equal(t, 1, repo.loginFailures["ip:10.0.0.1"].Failures)
+}
+
+func Test_service_Login_lockout(t *testing.T) {
+	s, repo, _ := newTestService(t)
+	ctx := context.Background()
+
+	for i := 0; i < testLockoutPolicy.MaxFailures; i++ {
+		repo.rewindLoginFailures(testLockoutPolicy.MaxDelay)
+		_, err := s.Login(ctx, "demo", "bad", fmt.Sprintf("10.0.0.%d", i))
+		assert.Equal(t, http.StatusUnauthorized, statusCode(err))
+	}
+	assert.True(t, repo.loginFailures["email:demo"].IsLocked(time.Now()))
+
+	repo.rewindLoginFailures(testLockoutPolicy.MaxDelay)
+	_, err := s.Login(ctx, "demo", "pass", "10.0.1.1")
+	assert.Equal(t, http.StatusTooManyRequests, statusCode(err))
+
+	// only administrators can unlock accounts
+	assert.Equal(t, http.StatusForbidden, statusCode(s.UnlockAccount(ctx, "100", "100", "10.0.1.1")))
+	repo.users = append(repo.users, entity.User{ID: "200", Name: "admin", Email: "admin", Active: true, IsAdmin: true})
+	assert.NotNil(t, s.UnlockAccount(ctx, "200", "999", "10.0.1.1"))
+	require.Nil(t, s.UnlockAccount(ctx, "200", "100", "10.0.1.1"))
+
+	_, err = s.Login(ctx, "demo", "pass", "10.0.1.1")
+	assert.Nil(t, err)
+}
+
+func Test_service_Login_ipLockout(t *testing.T) {
+	s, repo, _ := newTestService(t)
+	ctx := context.Background()
+
+	for i := 0; i < testLockoutPolicy.IPMaxFailures; i++ {
+		repo.rewindLoginFailures(testLockoutPolicy.MaxDelay)
+		_, err := s.Login(ctx, fmt.Sprintf("user%d", i), "bad", "10.0.0.1")
+		assert.Equal(t, http.StatusUnauthorized, statusCode(err))
+	}
+	_, err := s.Login(ctx, "demo", "pass", "10.0.0.1")
+	assert.Equal(t, http.StatusTooManyRequests, statusCode(err))
+	_, err = s.Login(ctx, "demo", "pass", "10.0.0.2")
+	assert.Nil(t, err)
+}
+
+func Test_service_recordLoginFailure_audit(t *testing.T) {
+	s, _, _ := newTestService(t)
+	recorder := &mockRecorder{}
+	s.auditor = recorder
+	keys := s.loginKeys("demo", "10.0.0.1")
+	now := time.Now()
+	for i := 0; i < testLockoutPolicy.MaxFailures; i++ {
+		s.recordLoginFailure(context.Background(), keys, "10.0.0.1", now)
+	}
+	if assert.Len(t, recorder.events, 1) {
+		assert.Equal(t, audit.ActionLoginLocked, recorder.events[0].Action)
+		assert.Equal(t, "email", recorder.events[0].TargetType)
+		assert.Equal(t, "demo", recorder.events[0].TargetID)
+	}
+}
+
+func statusCode(err error) int {
+	if e, ok := err.(errors.ErrorResponse); ok {
+		return e.StatusCode()
+	}
+	return 0
+}
+
+type mockRecorder struct {
+	events []audit.Event
+}
+
+func (m *mockRecorder) Record(_ context.Context, event audit.Event) error {
+	m.events = append(m.events, event)
+	return nil
+}
+
+// rewindLoginFailures moves the last failure of every key back in time.
+func (m *mockRepository) rewindLoginFailures(d time.Duration) {
+	for key, failure := range m.loginFailures {
+		failure.LastFailedAt = failure.LastFailedAt.Add(-d)
+		m.loginFailures[key] = failure
+	}
+}
+
+func (m *mockRepository) GetLoginFailure(_ context.Context, key string) (entity.LoginFailure, error) {
+	if failure, ok := m.loginFailures[key]; ok {
+		return failure, nil
+	}
+	return entity.LoginFailure{}, sql.ErrNoRows
+}
+
+func (m *mockRepository) RecordLoginFailure(_ context.Context, key string, at, resetBefore time.Time) (int, error) {
+	if m.loginFailures == nil {
+		m.loginFailures = map[string]entity.LoginFailure{}
+	}
+	failure, ok := m.loginFailures[key]
+	if !ok || failure.LastFailedAt.Before(resetBefore) {
+		failure = entity.LoginFailure{Key: key, LockedUntil: failure.LockedUntil}
+	}
+	failure.Failures++
+	failure.LastFailedAt = at
+	m.loginFailures[key] = failure
+	return failure.Failures, nil
+}
+
+func (m *mockRepository) LockLogin(_ context.Context, key string, until time.Time) error {
+	failure := m.loginFailures[key]
+	failure.LockedUntil = &until
+	m.loginFailures[key] = failure
+	return nil
+}
+
+func (m *mockRepository) ResetLoginFailures(_ context.Context, key string) error {
+	delete(m.loginFailures, key)
+	return nil
+}
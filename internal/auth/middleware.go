@@ -2,26 +2,100 @@ package auth
 
 import (
 	"context"
+	"fmt"
 	"net/http"
+	"strings"
 
 	routing "github.com/garaekz/ozzo-routing"
 	"github.com/garaekz/ozzo-routing/auth"
 
 	"github.com/garaekz/gonvelope/internal/entity"
 	"github.com/garaekz/gonvelope/internal/errors"
+	"github.com/garaekz/gonvelope/pkg/clientip"
 	"github.com/golang-jwt/jwt/v5"
 )
 
+// TokenVersionFunc returns the current access token version of the user with the given ID.
+type TokenVersionFunc func(ctx context.Context, userID string) (int, error)
+
 // JWTHandler returns a JWT-based authentication middleware.
-func JWTHandler(verificationKey string) routing.Handler {
-	return auth.JWT(verificationKey, auth.JWTOptions{TokenHandler: handleJWTToken})
+// Tokens carrying a version older than the one returned by tokenVersion are considered revoked.
+func JWTHandler(verificationKey string, tokenVersion TokenVersionFunc) routing.Handler {
+	return auth.JWT(verificationKey, auth.JWTOptions{TokenHandler: func(c *routing.Context, token *jwt.Token) error {
+		if err := checkTokenVersion(c, token, tokenVersion); err != nil {
+			return err
+		}
+		return handleJWTToken(c, token)
+	}})
+}
+
+// APIKeyVerifier verifies an API key presented from the given client IP address.
+// It returns the identity of the key owner along with the scopes granted to the key.
+type APIKeyVerifier func(ctx context.Context, key, ip string) (Identity, []string, error)
+
+// BearerHandler returns an API key-based authentication middleware.
+// The key is read from the "Authorization: Bearer" header and the scopes it grants are stored in the request context.
+func BearerHandler(verify APIKeyVerifier) routing.Handler {
+	return func(c *routing.Context) error {
+		identity, scopes, err := handleBearerToken(c, bearerToken(c.Request), verify)
+		if err != nil {
+			c.Response.Header().Set("WWW-Authenticate", `Bearer realm="API"`)
+			return err
+		}
+		ctx := WithScopes(WithUser(c.Request.Context(), identity.GetID(), identity.GetName()), scopes)
+		if bound, ok := identity.(interface{ GetOrgID() string }); ok {
+			ctx = context.WithValue(ctx, keyOrgKey, bound.GetOrgID())
+		}
+		if key, ok := identity.(interface{ GetKeyID() string }); ok {
+			ctx = context.WithValue(ctx, keyIDKey, key.GetKeyID())
+		}
+		c.Request = c.Request.WithContext(ctx)
+		return nil
+	}
+}
+
+// Handler returns an authentication middleware that accepts both JWT tokens and API keys.
+// Bearer tokens starting with APIKeyPrefix are handled by bearerHandler, anything else by jwtHandler.
+func Handler(jwtHandler, bearerHandler routing.Handler) routing.Handler {
+	return func(c *routing.Context) error {
+		if strings.HasPrefix(bearerToken(c.Request), APIKeyPrefix) {
+			return bearerHandler(c)
+		}
+		return jwtHandler(c)
+	}
 }
 
-// BearerHandler returns a Bearer token-based authentication middleware.
-func BearerHandler(verificationKey string) routing.Handler {
-	return auth.Bearer(func(c *routing.Context, _ string) (auth.Identity, error) {
-		return handleBearerToken(c, verificationKey)
-	})
+// Require returns a middleware that only lets the request through if the current identity was granted
+// all the given permissions. Requests authenticated with a JWT token are granted every scope, while
+// requests authenticated with an API key are restricted to the scopes of the key. The role of the current
+// user in the active organization must also grant the permissions, so an API key never does more than its
+// owner could. Permissions are denied when no organization is active.
+func Require(permissions ...string) routing.Handler {
+	return func(c *routing.Context) error {
+		ctx := c.Request.Context()
+		if CurrentUser(ctx) == nil {
+			return errors.Unauthorized("")
+		}
+		membership, ok := CurrentMembership(ctx)
+		if !ok && len(permissions) > 0 {
+			return errors.Forbidden("No organization is active")
+		}
+		for _, permission := range permissions {
+			if !RoleAllows(membership.Role, permission) {
+				return errors.Forbidden(fmt.Sprintf("The %s role is missing the %q permission", membership.Role, permission))
+			}
+		}
+		granted, restricted := ctx.Value(scopesKey).([]string)
+		if !restricted {
+			return nil
+		}
+		for _, permission := range permissions {
+			if !hasScope(granted, permission) {
+				return errors.Forbidden(fmt.Sprintf("The API key is missing the %q scope", permission))
+			}
+		}
+		return nil
+	}
 }
 
 // handleJWTToken stores the user identity in the request context so that it can be accessed elsewhere.
@@ -35,18 +109,61 @@ func handleJWTToken(c *routing.Context, token *jwt.Token) error {
 	return nil
 }
 
-// handleBearerToken validates the bearer token and returns the user identity if the token is valid.
-func handleBearerToken(_ *routing.Context, token string) (Identity, error) {
-	if token == "secret" {
-		return entity.User{}, nil
+// checkTokenVersion rejects tokens that were revoked by bumping the user's token version.
+func checkTokenVersion(c *routing.Context, token *jwt.Token, tokenVersion TokenVersionFunc) error {
+	claims := token.Claims.(jwt.MapClaims)
+	id, _ := claims["id"].(string)
+	current, err := tokenVersion(c.Request.Context(), id)
+	if err != nil {
+		return errors.Unauthorized("invalid token")
+	}
+	version, _ := claims["ver"].(float64)
+	if int(version) < current {
+		return errors.Unauthorized("token has been revoked")
+	}
+	return nil
+}
+
+// handleBearerToken validates the API key and returns the identity of its owner and its scopes if the key is valid.
+// The IP allowlist of the key is checked against the client IP address, which only trusted proxies can forward.
+func handleBearerToken(c *routing.Context, key string, verify APIKeyVerifier) (Identity, []string, error) {
+	if !strings.HasPrefix(key, APIKeyPrefix) {
+		return nil, nil, errors.Unauthorized("invalid API key")
+	}
+	identity, scopes, err := verify(c.Request.Context(), key, clientip.Get(c.Request))
+	if err != nil || identity == nil {
+		return nil, nil, errors.Unauthorized("invalid API key")
+	}
+	return identity, scopes, nil
+}
+
+// bearerToken returns the token of the "Authorization: Bearer" header, if any.
+func bearerToken(req *http.Request) string {
+	header := req.Header.Get("Authorization")
+	if strings.HasPrefix(header, "Bearer ") {
+		return strings.TrimSpace(header[7:])
+	}
+	return ""
+}
+
+// hasScope returns whether the scope is part of the granted scopes.
+func hasScope(granted []string, scope string) bool {
+	for _, s := range granted {
+		if s == scope {
+			return true
+		}
 	}
-	return nil, errors.Unauthorized("invalid token")
+	return false
 }
 
 type contextKey int
 
 const (
 	userKey contextKey = iota
+	scopesKey
+	keyOrgKey
+	keyIDKey
+	membershipKey
 )
 
 // WithUser returns a context that contains the user identity from the given JWT.
@@ -54,6 +171,14 @@ func WithUser(ctx context.Context, id, name string) context.Context {
 	return context.WithValue(ctx, userKey, entity.User{ID: id, Name: name})
 }
 
+// WithScopes returns a context that restricts the current identity to the given scopes.
+func WithScopes(ctx context.Context, scopes []string) context.Context {
+	if scopes == nil {
+		scopes = []string{}
+	}
+	return context.WithValue(ctx, scopesKey, scopes)
+}
+
 // CurrentUser returns the user identity from the given context.
 // Nil is returned if no user identity is found in the context.
 func CurrentUser(ctx context.Context) Identity {
@@ -63,6 +188,32 @@ func CurrentUser(ctx context.Context) Identity {
 	return nil
 }
 
+// KeyOrg returns the ID of the organization the API key used to authenticate the request is bound to.
+// An empty string is returned if the request was not authenticated with such an API key.
+func KeyOrg(ctx context.Context) string {
+	orgID, _ := ctx.Value(keyOrgKey).(string)
+	return orgID
+}
+
+// CurrentAPIKey returns the ID of the API key used to authenticate the request.
+// An empty string is returned if the request was not authenticated with an API key.
+func CurrentAPIKey(ctx context.Context) string {
+	id, _ := ctx.Value(keyIDKey).(string)
+	return id
+}
+
+// WithMembership returns a context that makes the given organization membership the active one.
+func WithMembership(ctx context.Context, membership entity.Membership) context.Context {
+	return context.WithValue(ctx, membershipKey, membership)
+}
+
+// CurrentMembership returns the membership of the current user in the active organization.
+// The second return value is false if no organization is active.
+func CurrentMembership(ctx context.Context) (entity.Membership, bool) {
+	membership, ok := ctx.Value(membershipKey).(entity.Membership)
+	return membership, ok
+}
+
 // MockAuthHandler creates a mock authentication middleware for testing purpose.
 // If the request contains an Authorization header whose value is "TEST", then
 // it considers the user is authenticated as "Tester" whose ID is "100".
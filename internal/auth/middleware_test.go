@@ -2,10 +2,14 @@ package auth
 
 import (
 	"context"
+	"database/sql"
 	"net/http"
 	"testing"
 
+	"github.com/garaekz/gonvelope/internal/entity"
 	"github.com/garaekz/gonvelope/internal/test"
+	"github.com/garaekz/gonvelope/pkg/log"
+	routing "github.com/garaekz/ozzo-routing"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/stretchr/testify/assert"
 )
@@ -22,7 +26,24 @@ func TestCurrentUser(t *testing.T) {
 }
 
 func TestHandler(t *testing.T) {
-	assert.NotNil(t, JWTHandler("test"))
+	assert.NotNil(t, JWTHandler("test", mockTokenVersion))
+}
+
+func mockTokenVersion(_ context.Context, userID string) (int, error) {
+	if userID == "100" {
+		return 1, nil
+	}
+	return 0, sql.ErrNoRows
+}
+
+func Test_checkTokenVersion(t *testing.T) {
+	req, _ := http.NewRequest("GET", "http://example.com", nil)
+	ctx, _ := test.MockRoutingContext(req)
+
+	assert.Nil(t, checkTokenVersion(ctx, &jwt.Token{Claims: jwt.MapClaims{"id": "100", "ver": float64(1)}}, mockTokenVersion))
+	assert.NotNil(t, checkTokenVersion(ctx, &jwt.Token{Claims: jwt.MapClaims{"id": "100", "ver": float64(0)}}, mockTokenVersion))
+	assert.NotNil(t, checkTokenVersion(ctx, &jwt.Token{Claims: jwt.MapClaims{"id": "100"}}, mockTokenVersion))
+	assert.NotNil(t, checkTokenVersion(ctx, &jwt.Token{Claims: jwt.MapClaims{"id": "200", "ver": float64(1)}}, mockTokenVersion))
 }
 
 func Test_handleToken(t *testing.T) {
@@ -54,18 +75,112 @@ func TestMocks(t *testing.T) {
 	assert.NotNil(t, CurrentUser(ctx.Request.Context()))
 }
 
+func mockAPIKeyVerifier(_ context.Context, key, ip string) (Identity, []string, error) {
+	if key == APIKeyPrefix+"valid" && ip == "10.0.0.1" {
+		return entity.User{ID: "100", Name: "test"}, []string{ScopeTemplatesRead}, nil
+	}
+	return nil, nil, sql.ErrNoRows
+}
+
+// mockRemoteAddr makes the requests come from 10.0.0.1, the address valid API keys are allowed from.
+func mockRemoteAddr(c *routing.Context) error {
+	c.Request.RemoteAddr = "10.0.0.1:52000"
+	return nil
+}
+
 func Test_handleBearerToken(t *testing.T) {
 	req, _ := http.NewRequest("GET", "http://example.com", nil)
+	req.RemoteAddr = "10.0.0.1:52000"
 	ctx, _ := test.MockRoutingContext(req)
-	assert.Nil(t, CurrentUser(ctx.Request.Context()))
 
-	// Test valid token
-	identity, err := handleBearerToken(ctx, "secret")
+	// Test valid key
+	identity, scopes, err := handleBearerToken(ctx, APIKeyPrefix+"valid", mockAPIKeyVerifier)
 	assert.Nil(t, err)
 	assert.NotNil(t, identity)
+	assert.Equal(t, []string{ScopeTemplatesRead}, scopes)
+
+	// the allowlist cannot be passed by forging forwarding headers
+	spoofed, _ := http.NewRequest("GET", "http://example.com", nil)
+	spoofed.RemoteAddr = "203.0.113.7:52000"
+	spoofed.Header.Set("X-Real-IP", "10.0.0.1")
+	spoofed.Header.Set("X-Forwarded-For", "10.0.0.1")
+	spoofedCtx, _ := test.MockRoutingContext(spoofed)
+	_, _, err = handleBearerToken(spoofedCtx, APIKeyPrefix+"valid", mockAPIKeyVerifier)
+	assert.NotNil(t, err)
 
-	// Test invalid token
-	identity, err = handleBearerToken(ctx, "invalid")
+	// Test invalid keys
+	identity, _, err = handleBearerToken(ctx, APIKeyPrefix+"invalid", mockAPIKeyVerifier)
+	assert.NotNil(t, err)
+	assert.Nil(t, identity)
+	identity, _, err = handleBearerToken(ctx, "secret", mockAPIKeyVerifier)
 	assert.NotNil(t, err)
 	assert.Nil(t, identity)
 }
+
+func TestRequire(t *testing.T) {
+	logger, _ := log.NewForTest()
+	router := test.MockRouter(logger)
+	rg := router.Group("")
+	rg.Use(mockRemoteAddr, Handler(MockAuthHandler, BearerHandler(mockAPIKeyVerifier)), func(c *routing.Context) error {
+		ctx := WithMembership(c.Request.Context(), entity.Membership{OrgID: "org1", UserID: "100", Role: entity.RoleOwner})
+		c.Request = c.Request.WithContext(ctx)
+		return nil
+	})
+	rg.Get("/templates", Require(ScopeTemplatesRead), func(c *routing.Context) error { return c.Write("ok") })
+	rg.Post("/templates", Require(ScopeTemplatesWrite), func(c *routing.Context) error { return c.Write("ok") })
+
+	keyHeader := func(key string) http.Header {
+		header := http.Header{}
+		header.Set("Authorization", "Bearer "+key)
+		return header
+	}
+	tests := []test.APITestCase{
+		{Name: "jwt read", Method: "GET", URL: "/templates", Header: MockAuthHeader(), WantStatus: http.StatusOK},
+		{Name: "jwt write", Method: "POST", URL: "/templates", Header: MockAuthHeader(), WantStatus: http.StatusOK},
+		{Name: "api key read", Method: "GET", URL: "/templates", Header: keyHeader(APIKeyPrefix + "valid"), WantStatus: http.StatusOK},
+		{Name: "api key missing scope", Method: "POST", URL: "/templates", Header: keyHeader(APIKeyPrefix + "valid"), WantStatus: http.StatusForbidden, WantResponse: "*templates:write*"},
+		{Name: "invalid api key", Method: "GET", URL: "/templates", Header: keyHeader(APIKeyPrefix + "invalid"), WantStatus: http.StatusUnauthorized},
+		{Name: "anonymous", Method: "GET", URL: "/templates", WantStatus: http.StatusUnauthorized},
+	}
+	for _, tc := range tests {
+		test.Endpoint(t, router, tc)
+	}
+}
+
+type boundIdentity struct {
+	entity.User
+	orgID string
+}
+
+func (b boundIdentity) GetOrgID() string {
+	return b.orgID
+}
+
+func (b boundIdentity) GetKeyID() string {
+	return "key1"
+}
+
+func TestBearerHandler_keyOrg(t *testing.T) {
+	verify := func(_ context.Context, key, _ string) (Identity, []string, error) {
+		return boundIdentity{entity.User{ID: "100", Name: "test"}, "org1"}, []string{ScopeTemplatesRead}, nil
+	}
+	req, _ := http.NewRequest("GET", "http://example.com", nil)
+	req.Header.Set("Authorization", "Bearer "+APIKeyPrefix+"valid")
+	ctx, _ := test.MockRoutingContext(req)
+	assert.Nil(t, BearerHandler(verify)(ctx))
+	assert.Equal(t, "org1", KeyOrg(ctx.Request.Context()))
+	assert.Equal(t, "100", CurrentUser(ctx.Request.Context()).GetID())
+	assert.Equal(t, "key1", CurrentAPIKey(ctx.Request.Context()))
+
+	assert.Empty(t, KeyOrg(context.Background()))
+	assert.Empty(t, CurrentAPIKey(context.Background()))
+}
+
+func TestCurrentMembership(t *testing.T) {
+	_, ok := CurrentMembership(context.Background())
+	assert.False(t, ok)
+	ctx := WithMembership(context.Background(), entity.Membership{OrgID: "org1", UserID: "100", Role: entity.RoleOwner})
+	membership, ok := CurrentMembership(ctx)
+	assert.True(t, ok)
+	assert.Equal(t, "org1", membership.OrgID)
+}
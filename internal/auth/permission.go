@@ -0,0 +1,56 @@
+package auth
+
+import "github.com/garaekz/gonvelope/internal/entity"
+
+// Permissions that are not granted to API keys. Routes declare the permissions they need with Require,
+// just like scopes, which double as the permissions over the matching resources.
+const (
+	// PermissionOrgManage allows renaming the organization.
+	PermissionOrgManage = "org:manage"
+	// PermissionOrgDelete allows deleting the organization.
+	PermissionOrgDelete = "org:delete"
+	// PermissionMembersManage allows inviting and removing members.
+	PermissionMembersManage = "members:manage"
+	// PermissionAPIKeysWrite allows creating, updating and revoking API keys.
+	PermissionAPIKeysWrite = "api_keys:write"
+	// PermissionAuditRead allows browsing and exporting the audit log.
+	PermissionAuditRead = "audit:read"
+)
+
+// rolePermissions maps each membership role to the permissions it grants.
+var rolePermissions = map[string][]string{
+	entity.RoleOwner: {
+		PermissionOrgManage, PermissionOrgDelete, PermissionMembersManage, PermissionAPIKeysWrite, PermissionAuditRead,
+		ScopeMessagesSend, ScopeMessagesRead, ScopeTemplatesRead, ScopeTemplatesWrite, ScopeAccountsRead, ScopeAccountsWrite,
+		ScopeSuppressionsRead, ScopeSuppressionsWrite, ScopeContactsRead, ScopeContactsWrite,
+		ScopeWebhooksRead, ScopeWebhooksWrite, ScopeStatsRead, ScopeInboundRead, ScopeInboundWrite,
+		ScopeDomainsRead, ScopeDomainsWrite,
+	},
+	entity.RoleAdmin: {
+		PermissionOrgManage, PermissionMembersManage, PermissionAPIKeysWrite, PermissionAuditRead,
+		ScopeMessagesSend, ScopeMessagesRead, ScopeTemplatesRead, ScopeTemplatesWrite, ScopeAccountsRead, ScopeAccountsWrite,
+		ScopeSuppressionsRead, ScopeSuppressionsWrite, ScopeContactsRead, ScopeContactsWrite,
+		ScopeWebhooksRead, ScopeWebhooksWrite, ScopeStatsRead, ScopeInboundRead, ScopeInboundWrite,
+		ScopeDomainsRead, ScopeDomainsWrite,
+	},
+	entity.RoleEditor: {
+		PermissionAPIKeysWrite,
+		ScopeMessagesSend, ScopeMessagesRead, ScopeTemplatesRead, ScopeTemplatesWrite, ScopeAccountsRead,
+		ScopeSuppressionsRead, ScopeSuppressionsWrite, ScopeContactsRead, ScopeContactsWrite,
+		ScopeWebhooksRead, ScopeStatsRead, ScopeInboundRead, ScopeInboundWrite, ScopeDomainsRead,
+	},
+	entity.RoleSender: {
+		PermissionAPIKeysWrite,
+		ScopeMessagesSend, ScopeMessagesRead, ScopeTemplatesRead, ScopeAccountsRead, ScopeSuppressionsRead, ScopeContactsRead,
+		ScopeStatsRead,
+	},
+	entity.RoleViewer: {
+		ScopeMessagesRead, ScopeTemplatesRead, ScopeAccountsRead, ScopeSuppressionsRead, ScopeContactsRead,
+		ScopeStatsRead,
+	},
+}
+
+// RoleAllows returns whether the membership role grants the permission.
+func RoleAllows(role, permission string) bool {
+	return hasScope(rolePermissions[role], permission)
+}
@@ -0,0 +1,94 @@
+package auth
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/garaekz/gonvelope/internal/entity"
+	"github.com/garaekz/gonvelope/internal/test"
+	"github.com/garaekz/gonvelope/pkg/log"
+	routing "github.com/garaekz/ozzo-routing"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRoleAllows(t *testing.T) {
+	for _, role := range entity.Roles {
+		assert.True(t, RoleAllows(role, ScopeTemplatesRead), role)
+	}
+	assert.True(t, RoleAllows(entity.RoleOwner, PermissionOrgDelete))
+	assert.False(t, RoleAllows(entity.RoleAdmin, PermissionOrgDelete))
+	assert.False(t, RoleAllows("unknown", ScopeTemplatesRead))
+}
+
+// mockMembershipHandler makes the current user a member of the organization "org1"
+// with the role given by the X-Role header, if any.
+func mockMembershipHandler(c *routing.Context) error {
+	if role := c.Request.Header.Get("X-Role"); role != "" {
+		ctx := WithMembership(c.Request.Context(), entity.Membership{OrgID: "org1", UserID: "100", Role: role})
+		c.Request = c.Request.WithContext(ctx)
+	}
+	return nil
+}
+
+func TestRequire_roles(t *testing.T) {
+	logger, _ := log.NewForTest()
+	router := test.MockRouter(logger)
+	rg := router.Group("")
+	rg.Use(mockRemoteAddr, Handler(MockAuthHandler, BearerHandler(mockAPIKeyVerifier)), mockMembershipHandler)
+
+	routes := []struct {
+		method, url, permission string
+		// allowed lists the roles granted the permission
+		allowed []string
+	}{
+		{"GET", "/templates", ScopeTemplatesRead, entity.Roles},
+		{"POST", "/templates", ScopeTemplatesWrite, []string{entity.RoleOwner, entity.RoleAdmin, entity.RoleEditor}},
+		{"GET", "/messages", ScopeMessagesRead, entity.Roles},
+		{"POST", "/messages", ScopeMessagesSend, []string{entity.RoleOwner, entity.RoleAdmin, entity.RoleEditor, entity.RoleSender}},
+		{"GET", "/suppressions", ScopeSuppressionsRead, entity.Roles},
+		{"POST", "/suppressions", ScopeSuppressionsWrite, []string{entity.RoleOwner, entity.RoleAdmin, entity.RoleEditor}},
+		{"GET", "/contacts", ScopeContactsRead, entity.Roles},
+		{"POST", "/contacts", ScopeContactsWrite, []string{entity.RoleOwner, entity.RoleAdmin, entity.RoleEditor}},
+		{"GET", "/webhooks", ScopeWebhooksRead, []string{entity.RoleOwner, entity.RoleAdmin, entity.RoleEditor}},
+		{"POST", "/webhooks", ScopeWebhooksWrite, []string{entity.RoleOwner, entity.RoleAdmin}},
+		{"GET", "/stats", ScopeStatsRead, entity.Roles},
+		{"GET", "/inbound", ScopeInboundRead, []string{entity.RoleOwner, entity.RoleAdmin, entity.RoleEditor}},
+		{"POST", "/inbound", ScopeInboundWrite, []string{entity.RoleOwner, entity.RoleAdmin, entity.RoleEditor}},
+		{"GET", "/dkim-keys", ScopeDomainsRead, []string{entity.RoleOwner, entity.RoleAdmin, entity.RoleEditor}},
+		{"POST", "/dkim-keys", ScopeDomainsWrite, []string{entity.RoleOwner, entity.RoleAdmin}},
+		{"GET", "/domains", ScopeDomainsRead, []string{entity.RoleOwner, entity.RoleAdmin, entity.RoleEditor}},
+		{"POST", "/domains", ScopeDomainsWrite, []string{entity.RoleOwner, entity.RoleAdmin}},
+		{"POST", "/accounts", ScopeAccountsWrite, []string{entity.RoleOwner, entity.RoleAdmin}},
+		{"POST", "/api-keys", PermissionAPIKeysWrite, []string{entity.RoleOwner, entity.RoleAdmin, entity.RoleEditor, entity.RoleSender}},
+		{"POST", "/invitations", PermissionMembersManage, []string{entity.RoleOwner, entity.RoleAdmin}},
+		{"PUT", "/org", PermissionOrgManage, []string{entity.RoleOwner, entity.RoleAdmin}},
+		{"DELETE", "/org", PermissionOrgDelete, []string{entity.RoleOwner}},
+		{"GET", "/audit-events", PermissionAuditRead, []string{entity.RoleOwner, entity.RoleAdmin}},
+	}
+	var tests []test.APITestCase
+	for _, route := range routes {
+		rg.To(route.method, route.url, Require(route.permission), func(c *routing.Context) error { return c.Write("ok") })
+		for _, role := range entity.Roles {
+			header := MockAuthHeader()
+			header.Set("X-Role", role)
+			status := http.StatusForbidden
+			if hasScope(route.allowed, role) {
+				status = http.StatusOK
+			}
+			tests = append(tests, test.APITestCase{Name: role + " " + route.method + " " + route.url, Method: route.method, URL: route.url, Header: header, WantStatus: status})
+		}
+	}
+
+	// an API key never does more than the role of its owner allows
+	keyHeader := http.Header{}
+	keyHeader.Set("Authorization", "Bearer "+APIKeyPrefix+"valid")
+	keyHeader.Set("X-Role", entity.RoleViewer)
+	tests = append(tests,
+		test.APITestCase{Name: "api key viewer read", Method: "GET", URL: "/templates", Header: keyHeader, WantStatus: http.StatusOK},
+		test.APITestCase{Name: "api key viewer send", Method: "POST", URL: "/messages", Header: keyHeader, WantStatus: http.StatusForbidden, WantResponse: `*viewer*`},
+		test.APITestCase{Name: "no active organization", Method: "DELETE", URL: "/org", Header: MockAuthHeader(), WantStatus: http.StatusForbidden},
+	)
+	for _, tc := range tests {
+		test.Endpoint(t, router, tc)
+	}
+}
@@ -0,0 +1,231 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/garaekz/gonvelope/internal/entity"
+	"github.com/garaekz/gonvelope/internal/errors"
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/go-ozzo/ozzo-validation/v4/is"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// errIncorrectPassword is reported when the current password supplied to confirm a sensitive change is wrong.
+var errIncorrectPassword = validation.NewError("validation_password_incorrect", "is incorrect")
+
+// Profile represents the account of the current user.
+type Profile struct {
+	ID               string           `json:"id"`
+	Name             string           `json:"name"`
+	Email            string           `json:"email"`
+	EmailVerified    bool             `json:"email_verified"`
+	PendingEmail     string           `json:"pending_email,omitempty"`
+	TwoFactorEnabled bool             `json:"two_factor_enabled"`
+	CreatedAt        *time.Time       `json:"created_at"`
+	Accounts         []AccountSummary `json:"accounts"`
+}
+
+// AccountSummary represents a provider account linked to a user, without its credentials.
+type AccountSummary struct {
+	ID        string     `json:"id" db:"id"`
+	Provider  string     `json:"provider" db:"provider"`
+	Name      string     `json:"name" db:"name"`
+	IsDefault bool       `json:"is_default" db:"is_default"`
+	CreatedAt *time.Time `json:"created_at" db:"created_at"`
+}
+
+// UpdateProfileRequest represents a profile update request.
+type UpdateProfileRequest struct {
+	Name string `json:"name"`
+}
+
+// Validate validates the UpdateProfileRequest fields.
+func (m UpdateProfileRequest) Validate() error {
+	return validation.ValidateStruct(&m,
+		validation.Field(&m.Name, validation.Required, validation.Length(0, maxNameLength)),
+	)
+}
+
+// ChangeEmailRequest represents a request to change the email address of the current user.
+type ChangeEmailRequest struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+// Validate validates the ChangeEmailRequest fields.
+func (m ChangeEmailRequest) Validate() error {
+	return validation.ValidateStruct(&m,
+		validation.Field(&m.Email, validation.Required, validation.Length(0, maxEmailLength), is.EmailFormat),
+		validation.Field(&m.Password, validation.Required),
+	)
+}
+
+// ChangePasswordRequest represents a request to change the password of the current user.
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+// Validate validates the ChangePasswordRequest fields.
+func (m ChangePasswordRequest) Validate() error {
+	return validation.ValidateStruct(&m,
+		validation.Field(&m.CurrentPassword, validation.Required),
+		validation.Field(&m.NewPassword, validation.Required),
+	)
+}
+
+// GetProfile returns the profile of the user along with the provider accounts they linked.
+func (s service) GetProfile(ctx context.Context, userID string) (Profile, error) {
+	user, err := s.repo.GetUserByID(ctx, userID)
+	if err != nil {
+		return Profile{}, err
+	}
+	accounts, err := s.repo.GetAccountSummaries(ctx, userID)
+	if err != nil {
+		return Profile{}, err
+	}
+	if accounts == nil {
+		accounts = []AccountSummary{}
+	}
+	return Profile{
+		ID:               user.ID,
+		Name:             user.Name,
+		Email:            user.Email,
+		EmailVerified:    user.EmailVerifiedAt != nil,
+		PendingEmail:     user.PendingEmail,
+		TwoFactorEnabled: user.TwoFactorEnabled(),
+		CreatedAt:        user.CreatedAt,
+		Accounts:         accounts,
+	}, nil
+}
+
+// UpdateProfile updates the name of the user.
+func (s service) UpdateProfile(ctx context.Context, userID string, req UpdateProfileRequest) (Profile, error) {
+	req.Name = strings.TrimSpace(req.Name)
+	if err := req.Validate(); err != nil {
+		return Profile{}, invalidInput(err)
+	}
+	if err := s.repo.UpdateUserName(ctx, userID, req.Name); err != nil {
+		return Profile{}, err
+	}
+	return s.GetProfile(ctx, userID)
+}
+
+// RequestEmailChange sends a confirmation link to the new email address of the user.
+// The email address is only changed once the link is visited.
+func (s service) RequestEmailChange(ctx context.Context, userID string, req ChangeEmailRequest) error {
+	req.Email = normalizeEmail(req.Email)
+	if err := req.Validate(); err != nil {
+		return invalidInput(err)
+	}
+	user, err := s.repo.GetUserByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if err := checkCurrentPassword(user, req.Password, "password"); err != nil {
+		return err
+	}
+	if req.Email == normalizeEmail(user.Email) {
+		return errors.InvalidInput(validation.Errors{"email": validation.NewError("validation_email_unchanged", "is your current email address")})
+	}
+	if _, err := s.repo.GetUserByEmail(ctx, req.Email); err == nil {
+		return errors.Conflict(emailExistsMessage)
+	}
+
+	var token string
+	err = s.transactional(ctx, func(ctx context.Context) error {
+		if err := s.repo.SetPendingEmail(ctx, userID, req.Email); err != nil {
+			return err
+		}
+		if err := s.repo.RevokeUserTokens(ctx, userID, entity.TokenPurposeEmailChange); err != nil {
+			return err
+		}
+		token, err = s.issueToken(ctx, user, entity.TokenPurposeEmailChange, time.Duration(s.verificationExpiration)*time.Hour)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	link := fmt.Sprintf("%s/api/v1/verify/email-change?token=%s", s.appURL, token)
+	s.sendEmail(ctx, entity.User{ID: user.ID, Name: user.Name, Email: req.Email}, "Confirm your new email address",
+		fmt.Sprintf("Hi %s,\n\nPlease confirm your new email address by visiting the link below:\n\n%s\n\n"+
+			"The link expires in %d hours. If you did not request this change, you can ignore this email.\n",
+			user.Name, link, s.verificationExpiration))
+	s.sendEmail(ctx, user, "Your email address is being changed", fmt.Sprintf("Hi %s,\n\n"+
+		"A request was made to change the email address of your account to %s. "+
+		"If you did not make this request, please reset your password immediately.\n", user.Name, req.Email))
+	return nil
+}
+
+// ConfirmEmailChange consumes an email change token and replaces the email address of the user with the new one.
+func (s service) ConfirmEmailChange(ctx context.Context, token string) error {
+	invalid := errors.BadRequest("The confirmation link is invalid or has expired")
+	if token == "" {
+		return invalid
+	}
+	userToken, err := s.repo.GetUserToken(ctx, entity.TokenPurposeEmailChange, hashToken(s.signingKey, token))
+	if err != nil || !userToken.IsUsable(time.Now()) {
+		return invalid
+	}
+	return s.transactional(ctx, func(ctx context.Context) error {
+		if err := s.repo.UseUserToken(ctx, userToken.ID); err != nil {
+			return invalid
+		}
+		return s.repo.ConfirmPendingEmail(ctx, userToken.UserID)
+	})
+}
+
+// ChangePassword sets a new password after checking the current one. All the access tokens issued to the user
+// are revoked, so a new one is returned.
+func (s service) ChangePassword(ctx context.Context, userID string, req ChangePasswordRequest) (string, error) {
+	if err := req.Validate(); err != nil {
+		return "", invalidInput(err)
+	}
+	user, err := s.repo.GetUserByID(ctx, userID)
+	if err != nil {
+		return "", err
+	}
+	if err := checkCurrentPassword(user, req.CurrentPassword, "current_password"); err != nil {
+		return "", err
+	}
+	if err := s.checkPassword(req.NewPassword, user.Email); err != nil {
+		return "", err
+	}
+	pass, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return "", errors.InternalServerError("Failed to hash password")
+	}
+	if err := s.repo.ChangePassword(ctx, userID, string(pass)); err != nil {
+		return "", err
+	}
+	user.TokenVersion++
+	return s.generateJWT(user)
+}
+
+// Deactivate deactivates the account of the user after checking their password and revokes their access tokens.
+func (s service) Deactivate(ctx context.Context, userID, password string) error {
+	user, err := s.repo.GetUserByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if err := checkCurrentPassword(user, password, "password"); err != nil {
+		return err
+	}
+	return s.repo.DeactivateUser(ctx, userID)
+}
+
+// checkCurrentPassword checks the password a user supplied to confirm a sensitive change.
+// The error is reported against the given request field.
+func checkCurrentPassword(user entity.User, password, field string) error {
+	if password == "" {
+		return errors.InvalidInput(validation.Errors{field: validation.ErrRequired})
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)) != nil {
+		return errors.InvalidInput(validation.Errors{field: errIncorrectPassword})
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/garaekz/gonvelope/internal/entity"
+	"github.com/garaekz/gonvelope/internal/errors"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_service_Profile(t *testing.T) {
+	s, repo, _ := newTestService(t)
+	ctx := context.Background()
+	now := time.Now()
+	repo.accounts = map[string][]AccountSummary{"100": {{ID: "a1", Provider: "google", Name: "Work", IsDefault: true, CreatedAt: &now}}}
+
+	profile, err := s.GetProfile(ctx, "100")
+	require.Nil(t, err)
+	assert.Equal(t, "demo", profile.Name)
+	assert.False(t, profile.TwoFactorEnabled)
+	if assert.Len(t, profile.Accounts, 1) {
+		assert.Equal(t, "google", profile.Accounts[0].Provider)
+	}
+
+	_, err = s.UpdateProfile(ctx, "100", UpdateProfileRequest{Name: "  "})
+	assert.Equal(t, http.StatusBadRequest, statusCode(err))
+	profile, err = s.UpdateProfile(ctx, "100", UpdateProfileRequest{Name: " Demo User "})
+	require.Nil(t, err)
+	assert.Equal(t, "Demo User", profile.Name)
+
+	_, err = s.GetProfile(ctx, "999")
+	assert.Equal(t, sql.ErrNoRows, err)
+}
+
+func Test_service_EmailChange(t *testing.T) {
+	s, repo, sandbox := newTestService(t)
+	ctx := context.Background()
+	repo.users = append(repo.users, entity.User{ID: "200", Name: "other", Email: "taken@example.com", Active: true})
+
+	err := s.RequestEmailChange(ctx, "100", ChangeEmailRequest{Email: "new@example.com", Password: "wrong"})
+	assert.Equal(t, http.StatusBadRequest, statusCode(err))
+	err = s.RequestEmailChange(ctx, "100", ChangeEmailRequest{Email: "invalid", Password: "pass"})
+	assert.Equal(t, http.StatusBadRequest, statusCode(err))
+	err = s.RequestEmailChange(ctx, "100", ChangeEmailRequest{Email: "Taken@example.com", Password: "pass"})
+	assert.Equal(t, http.StatusConflict, statusCode(err))
+
+	require.Nil(t, s.RequestEmailChange(ctx, "100", ChangeEmailRequest{Email: " New@Example.com", Password: "pass"}))
+	assert.Equal(t, "new@example.com", repo.users[0].PendingEmail)
+	messages := sandbox.Messages()
+	require.Len(t, messages, 2)
+	assert.Equal(t, []string{"new@example.com"}, messages[0].To)
+	assert.Equal(t, []string{"demo"}, messages[1].To)
+
+	// the email address only changes once the new address is confirmed
+	assert.Equal(t, "demo", repo.users[0].Email)
+	assert.NotNil(t, s.ConfirmEmailChange(ctx, "invalid"))
+	token := tokenFromText(t, messages[0].Text)
+	require.Nil(t, s.ConfirmEmailChange(ctx, token))
+	assert.Equal(t, "new@example.com", repo.users[0].Email)
+	assert.Empty(t, repo.users[0].PendingEmail)
+	assert.NotNil(t, repo.users[0].EmailVerifiedAt)
+
+	// tokens are single-use
+	assert.NotNil(t, s.ConfirmEmailChange(ctx, token))
+}
+
+func Test_service_ChangePassword(t *testing.T) {
+	s, repo, _ := newTestService(t)
+	ctx := context.Background()
+
+	_, err := s.ChangePassword(ctx, "100", ChangePasswordRequest{CurrentPassword: "wrong", NewPassword: "new-passphrase"})
+	assert.Equal(t, http.StatusBadRequest, statusCode(err))
+	_, err = s.ChangePassword(ctx, "100", ChangePasswordRequest{CurrentPassword: "pass", NewPassword: "short"})
+	assert.Equal(t, http.StatusBadRequest, statusCode(err))
+
+	token, err := s.ChangePassword(ctx, "100", ChangePasswordRequest{CurrentPassword: "pass", NewPassword: "new-passphrase"})
+	require.Nil(t, err)
+	assert.NotEmpty(t, token)
+	assert.Equal(t, 1, repo.users[0].TokenVersion)
+	assert.Nil(t, s.authenticate(ctx, "demo", "pass"))
+	assert.NotNil(t, s.authenticate(ctx, "demo", "new-passphrase"))
+}
+
+func Test_service_Deactivate(t *testing.T) {
+	s, repo, _ := newTestService(t)
+	ctx := context.Background()
+
+	assert.Equal(t, http.StatusBadRequest, statusCode(s.Deactivate(ctx, "100", "")))
+	assert.Equal(t, http.StatusBadRequest, statusCode(s.Deactivate(ctx, "100", "wrong")))
+	require.Nil(t, s.Deactivate(ctx, "100", "pass"))
+	assert.False(t, repo.users[0].Active)
+	assert.Equal(t, 1, repo.users[0].TokenVersion)
+
+	_, err := s.Login(ctx, "demo", "pass", "127.0.0.1")
+	assert.Equal(t, http.StatusUnauthorized, statusCode(err))
+}
+
+func (m *mockRepository) UpdateUserName(_ context.Context, id, name string) error {
+	for i, user := range m.users {
+		if user.ID == id {
+			m.users[i].Name = name
+		}
+	}
+	return nil
+}
+
+func (m *mockRepository) SetPendingEmail(_ context.Context, id, email string) error {
+	for i, user := range m.users {
+		if user.ID == id {
+			m.users[i].PendingEmail = email
+		}
+	}
+	return nil
+}
+
+func (m *mockRepository) ConfirmPendingEmail(_ context.Context, id string) error {
+	for i, user := range m.users {
+		if user.ID != id || user.PendingEmail == "" {
+			continue
+		}
+		for _, other := range m.users {
+			if strings.EqualFold(other.Email, user.PendingEmail) {
+				return errors.MapConflict(&pq.Error{Code: "23505"}, emailExistsMessage)
+			}
+		}
+		now := time.Now()
+		m.users[i].Email = user.PendingEmail
+		m.users[i].PendingEmail = ""
+		m.users[i].EmailVerifiedAt = &now
+		return nil
+	}
+	return sql.ErrNoRows
+}
+
+func (m *mockRepository) DeactivateUser(_ context.Context, id string) error {
+	for i, user := range m.users {
+		if user.ID == id {
+			m.users[i].Active = false
+			m.users[i].TokenVersion++
+		}
+	}
+	return nil
+}
+
+func (m *mockRepository) GetAccountSummaries(_ context.Context, userID string) ([]AccountSummary, error) {
+	return m.accounts[userID], nil
+}
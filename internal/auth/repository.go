@@ -2,8 +2,11 @@ package auth
 
 import (
 	"context"
+	"database/sql"
+	"time"
 
 	"github.com/garaekz/gonvelope/internal/entity"
+	"github.com/garaekz/gonvelope/internal/errors"
 	"github.com/garaekz/gonvelope/pkg/dbcontext"
 	"github.com/garaekz/gonvelope/pkg/log"
 	dbx "github.com/go-ozzo/ozzo-dbx"
@@ -11,12 +14,66 @@ import (
 
 // Repository encapsulates the logic to access info from the data source.
 type Repository interface {
+	// GetUserByID returns the user with the given ID
+	GetUserByID(ctx context.Context, id string) (entity.User, error)
 	// GetUserByEmail passes the email to the database and returns the user
 	GetUserByEmail(ctx context.Context, email string) (entity.User, error)
 	// GetActiveUserByEmail passes the email to the database and returns the active user
 	GetActiveUserByEmail(ctx context.Context, email string) (entity.User, error)
 	// CreateUser stores a new user in the database
 	CreateUser(ctx context.Context, user entity.User) error
+	// CreatePersonalOrganization creates the personal organization of the user, which shares the user ID
+	CreatePersonalOrganization(ctx context.Context, user entity.User) error
+	// ActivateUser marks the user email as verified and activates the account
+	ActivateUser(ctx context.Context, id string) error
+	// ChangePassword updates the user password and revokes all the access tokens issued to the user
+	ChangePassword(ctx context.Context, id, password string) error
+	// GetTokenVersion returns the current access token version of the user
+	GetTokenVersion(ctx context.Context, id string) (int, error)
+	// SetTOTPSecret stores a pending TOTP secret for the user, disabling two-factor authentication until it is confirmed
+	SetTOTPSecret(ctx context.Context, id, secret string) error
+	// EnableTOTP enables two-factor authentication using the pending TOTP secret of the user
+	EnableTOTP(ctx context.Context, id string) error
+	// DisableTOTP disables two-factor authentication for the user and removes the recovery codes
+	DisableTOTP(ctx context.Context, id string) error
+	// UseTOTPStep records the time step of the last accepted TOTP code.
+	// It returns sql.ErrNoRows if a code of the same or a later step was already accepted.
+	UseTOTPStep(ctx context.Context, id string, step int64) error
+	// ReplaceRecoveryCodes replaces the recovery codes of the user with the given hashes
+	ReplaceRecoveryCodes(ctx context.Context, userID string, hashes []string) error
+	// UseRecoveryCode marks the recovery code with the given hash as used.
+	// It returns sql.ErrNoRows if there is no such unused code.
+	UseRecoveryCode(ctx context.Context, userID, hash string) error
+	// CreateUserToken stores a new user token in the database
+	CreateUserToken(ctx context.Context, token entity.UserToken) error
+	// GetUserToken returns the user token with the given purpose and hash
+	GetUserToken(ctx context.Context, purpose, hash string) (entity.UserToken, error)
+	// UseUserToken marks the user token as used. It returns sql.ErrNoRows if the token was already used.
+	UseUserToken(ctx context.Context, id string) error
+	// RevokeUserTokens marks all the unused tokens with the given purpose issued to the user as used
+	RevokeUserTokens(ctx context.Context, userID, purpose string) error
+	// CountUserTokensSince returns the number of tokens with the given purpose issued to the user since the given time
+	CountUserTokensSince(ctx context.Context, userID, purpose string, since time.Time) (int, error)
+	// UpdateUserName updates the name of the user
+	UpdateUserName(ctx context.Context, id, name string) error
+	// SetPendingEmail stores the email address the user asked to switch to until it is confirmed
+	SetPendingEmail(ctx context.Context, id, email string) error
+	// ConfirmPendingEmail replaces the email address of the user with the pending one.
+	// It returns sql.ErrNoRows if the user has no pending email address.
+	ConfirmPendingEmail(ctx context.Context, id string) error
+	// DeactivateUser deactivates the user and revokes all the access tokens issued to the user
+	DeactivateUser(ctx context.Context, id string) error
+	// GetAccountSummaries returns the provider accounts linked to the user
+	GetAccountSummaries(ctx context.Context, userID string) ([]AccountSummary, error)
+	// GetLoginFailure returns the failed login attempts tracked under the given key
+	GetLoginFailure(ctx context.Context, key string) (entity.LoginFailure, error)
+	// RecordLoginFailure counts a failed login attempt under the given key and returns the number of consecutive failures.
+	// The count starts over if the previous failure happened before resetBefore.
+	RecordLoginFailure(ctx context.Context, key string, at, resetBefore time.Time) (int, error)
+	// LockLogin locks out the given key until the given time
+	LockLogin(ctx context.Context, key string, until time.Time) error
+	// ResetLoginFailures clears the failed login attempts and lockout tracked under the given key
+	ResetLoginFailures(ctx context.Context, key string) error
 }
 
 // repository persists users in database
@@ -30,10 +87,17 @@ func NewRepository(db *dbcontext.DB, logger log.Logger) Repository {
 	return repository{db, logger}
 }
 
+// GetUserByID returns the user with the given ID
+func (r repository) GetUserByID(ctx context.Context, id string) (entity.User, error) {
+	var user entity.User
+	err := r.db.With(ctx).Select().Model(id, &user)
+	return user, err
+}
+
 // GetUserByEmail passes the email to the database and returns the user even if it's not active
 func (r repository) GetUserByEmail(ctx context.Context, email string) (entity.User, error) {
 	var user entity.User
-	err := r.db.With(ctx).Select().From("users").Where(dbx.HashExp{"email": email}).One(&user)
+	err := r.db.With(ctx).Select().From("users").Where(emailEquals(email)).One(&user)
 	if err != nil {
 		return user, err
 	}
@@ -44,7 +108,7 @@ func (r repository) GetUserByEmail(ctx context.Context, email string) (entity.Us
 // GetActiveUserByEmail passes the email to the database and returns the active user
 func (r repository) GetActiveUserByEmail(ctx context.Context, email string) (entity.User, error) {
 	var user entity.User
-	err := r.db.With(ctx).Select().From("users").Where(dbx.HashExp{"email": email, "active": true}).One(&user)
+	err := r.db.With(ctx).Select().From("users").Where(dbx.And(emailEquals(email), dbx.HashExp{"active": true})).One(&user)
 	if err != nil {
 		return user, err
 	}
@@ -54,5 +118,246 @@ func (r repository) GetActiveUserByEmail(ctx context.Context, email string) (ent
 
 // CreateUser stores a new user in the database
 func (r repository) CreateUser(ctx context.Context, user entity.User) error {
-	return r.db.With(ctx).Model(&user).Exclude("CreatedAt", "UpdatedAt", "Active").Insert()
+	err := r.db.With(ctx).Model(&user).Exclude("EmailVerifiedAt", "TOTPEnabledAt", "CreatedAt", "UpdatedAt").Insert()
+	return errors.MapConflict(err, emailExistsMessage)
+}
+
+// CreatePersonalOrganization creates the personal organization of the user, which shares the user ID
+func (r repository) CreatePersonalOrganization(ctx context.Context, user entity.User) error {
+	org := entity.Organization{ID: user.ID, Name: user.Name}
+	if err := r.db.With(ctx).Model(&org).Exclude("CreatedAt", "UpdatedAt").Insert(); err != nil {
+		return err
+	}
+	membership := entity.Membership{OrgID: org.ID, UserID: user.ID, Role: entity.RoleOwner}
+	return r.db.With(ctx).Model(&membership).Exclude("CreatedAt").Insert()
+}
+
+// ActivateUser marks the user email as verified and activates the account
+func (r repository) ActivateUser(ctx context.Context, id string) error {
+	_, err := r.db.With(ctx).Update("users", dbx.Params{
+		"active":            true,
+		"email_verified_at": time.Now(),
+		"updated_at":        time.Now(),
+	}, dbx.HashExp{"id": id}).Execute()
+	return err
+}
+
+// ChangePassword updates the user password and revokes all the access tokens issued to the user
+func (r repository) ChangePassword(ctx context.Context, id, password string) error {
+	_, err := r.db.With(ctx).Update("users", dbx.Params{
+		"password":      password,
+		"token_version": dbx.NewExp("token_version + 1"),
+		"updated_at":    time.Now(),
+	}, dbx.HashExp{"id": id}).Execute()
+	return err
+}
+
+// GetTokenVersion returns the current access token version of the user
+func (r repository) GetTokenVersion(ctx context.Context, id string) (int, error) {
+	var version int
+	err := r.db.With(ctx).Select("token_version").From("users").Where(dbx.HashExp{"id": id, "active": true}).Row(&version)
+	return version, err
+}
+
+// SetTOTPSecret stores a pending TOTP secret for the user, disabling two-factor authentication until it is confirmed
+func (r repository) SetTOTPSecret(ctx context.Context, id, secret string) error {
+	_, err := r.db.With(ctx).Update("users", dbx.Params{
+		"totp_secret":     secret,
+		"totp_enabled_at": nil,
+		"totp_last_step":  0,
+		"updated_at":      time.Now(),
+	}, dbx.HashExp{"id": id}).Execute()
+	return err
+}
+
+// EnableTOTP enables two-factor authentication using the pending TOTP secret of the user
+func (r repository) EnableTOTP(ctx context.Context, id string) error {
+	_, err := r.db.With(ctx).Update("users", dbx.Params{
+		"totp_enabled_at": time.Now(),
+		"updated_at":      time.Now(),
+	}, dbx.HashExp{"id": id}).Execute()
+	return err
+}
+
+// DisableTOTP disables two-factor authentication for the user and removes the recovery codes
+func (r repository) DisableTOTP(ctx context.Context, id string) error {
+	if err := r.SetTOTPSecret(ctx, id, ""); err != nil {
+		return err
+	}
+	return r.ReplaceRecoveryCodes(ctx, id, nil)
+}
+
+// UseTOTPStep records the time step of the last accepted TOTP code.
+// It returns sql.ErrNoRows if a code of the same or a later step was already accepted.
+func (r repository) UseTOTPStep(ctx context.Context, id string, step int64) error {
+	res, err := r.db.With(ctx).Update("users", dbx.Params{"totp_last_step": step}, dbx.And(
+		dbx.HashExp{"id": id},
+		dbx.NewExp("totp_last_step < {:step}", dbx.Params{"step": step}),
+	)).Execute()
+	return requireAffected(res, err)
+}
+
+// ReplaceRecoveryCodes replaces the recovery codes of the user with the given hashes
+func (r repository) ReplaceRecoveryCodes(ctx context.Context, userID string, hashes []string) error {
+	if _, err := r.db.With(ctx).Delete("user_recovery_codes", dbx.HashExp{"user_id": userID}).Execute(); err != nil {
+		return err
+	}
+	for _, hash := range hashes {
+		code := entity.RecoveryCode{ID: entity.GenerateID(), UserID: userID, CodeHash: hash}
+		if err := r.db.With(ctx).Model(&code).Exclude("UsedAt", "CreatedAt").Insert(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UseRecoveryCode marks the recovery code with the given hash as used.
+// It returns sql.ErrNoRows if there is no such unused code.
+func (r repository) UseRecoveryCode(ctx context.Context, userID, hash string) error {
+	res, err := r.db.With(ctx).Update("user_recovery_codes", dbx.Params{"used_at": time.Now()},
+		dbx.HashExp{"user_id": userID, "code_hash": hash, "used_at": nil}).Execute()
+	return requireAffected(res, err)
+}
+
+// CreateUserToken stores a new user token in the database
+func (r repository) CreateUserToken(ctx context.Context, token entity.UserToken) error {
+	return r.db.With(ctx).Model(&token).Exclude("UsedAt", "CreatedAt").Insert()
+}
+
+// GetUserToken returns the user token with the given purpose and hash
+func (r repository) GetUserToken(ctx context.Context, purpose, hash string) (entity.UserToken, error) {
+	var token entity.UserToken
+	err := r.db.With(ctx).Select().From("user_tokens").
+		Where(dbx.HashExp{"purpose": purpose, "token_hash": hash}).One(&token)
+	return token, err
+}
+
+// UseUserToken marks the user token as used. It returns sql.ErrNoRows if the token was already used.
+func (r repository) UseUserToken(ctx context.Context, id string) error {
+	res, err := r.db.With(ctx).Update("user_tokens", dbx.Params{"used_at": time.Now()},
+		dbx.HashExp{"id": id, "used_at": nil}).Execute()
+	return requireAffected(res, err)
+}
+
+// RevokeUserTokens marks all the unused tokens with the given purpose issued to the user as used
+func (r repository) RevokeUserTokens(ctx context.Context, userID, purpose string) error {
+	_, err := r.db.With(ctx).Update("user_tokens", dbx.Params{"used_at": time.Now()},
+		dbx.HashExp{"user_id": userID, "purpose": purpose, "used_at": nil}).Execute()
+	return err
+}
+
+// CountUserTokensSince returns the number of tokens with the given purpose issued to the user since the given time
+func (r repository) CountUserTokensSince(ctx context.Context, userID, purpose string, since time.Time) (int, error) {
+	var count int
+	err := r.db.With(ctx).Select("COUNT(*)").From("user_tokens").
+		Where(dbx.HashExp{"user_id": userID, "purpose": purpose}).
+		AndWhere(dbx.NewExp("created_at >= {:since}", dbx.Params{"since": since})).
+		Row(&count)
+	return count, err
+}
+
+// UpdateUserName updates the name of the user
+func (r repository) UpdateUserName(ctx context.Context, id, name string) error {
+	_, err := r.db.With(ctx).Update("users", dbx.Params{
+		"name":       name,
+		"updated_at": time.Now(),
+	}, dbx.HashExp{"id": id}).Execute()
+	return err
+}
+
+// SetPendingEmail stores the email address the user asked to switch to until it is confirmed
+func (r repository) SetPendingEmail(ctx context.Context, id, email string) error {
+	_, err := r.db.With(ctx).Update("users", dbx.Params{
+		"pending_email": email,
+		"updated_at":    time.Now(),
+	}, dbx.HashExp{"id": id}).Execute()
+	return err
+}
+
+// ConfirmPendingEmail replaces the email address of the user with the pending one.
+// It returns sql.ErrNoRows if the user has no pending email address.
+func (r repository) ConfirmPendingEmail(ctx context.Context, id string) error {
+	res, err := r.db.With(ctx).Update("users", dbx.Params{
+		"email":             dbx.NewExp("pending_email"),
+		"pending_email":     "",
+		"email_verified_at": time.Now(),
+		"updated_at":        time.Now(),
+	}, dbx.And(dbx.HashExp{"id": id}, dbx.NewExp("pending_email <> ''"))).Execute()
+	return requireAffected(res, errors.MapConflict(err, emailExistsMessage))
+}
+
+// DeactivateUser deactivates the user and revokes all the access tokens issued to the user
+func (r repository) DeactivateUser(ctx context.Context, id string) error {
+	_, err := r.db.With(ctx).Update("users", dbx.Params{
+		"active":        false,
+		"token_version": dbx.NewExp("token_version + 1"),
+		"updated_at":    time.Now(),
+	}, dbx.HashExp{"id": id}).Execute()
+	return err
+}
+
+// GetAccountSummaries returns the provider accounts linked to the user
+func (r repository) GetAccountSummaries(ctx context.Context, userID string) ([]AccountSummary, error) {
+	var accounts []AccountSummary
+	err := r.db.With(ctx).
+		Select("a.id", "p.name AS provider", "a.name", "a.is_default", "a.created_at").
+		From("user_provider_accounts a").
+		InnerJoin("providers p", dbx.NewExp("p.id = a.provider_id")).
+		Where(dbx.HashExp{"a.user_id": userID}).
+		OrderBy("a.created_at").
+		All(&accounts)
+	return accounts, err
+}
+
+// GetLoginFailure returns the failed login attempts tracked under the given key
+func (r repository) GetLoginFailure(ctx context.Context, key string) (entity.LoginFailure, error) {
+	var failure entity.LoginFailure
+	err := r.db.With(ctx).Select().From("login_failures").Where(dbx.HashExp{"key": key}).One(&failure)
+	return failure, err
+}
+
+// RecordLoginFailure counts a failed login attempt under the given key and returns the number of consecutive failures.
+// The count starts over if the previous failure happened before resetBefore.
+func (r repository) RecordLoginFailure(ctx context.Context, key string, at, resetBefore time.Time) (int, error) {
+	var failures int
+	err := r.db.With(ctx).NewQuery(`
+		INSERT INTO login_failures (key, failures, last_failed_at) VALUES ({:key}, 1, {:at})
+		ON CONFLICT (key) DO UPDATE SET
+			failures = CASE WHEN login_failures.last_failed_at < {:reset} THEN 1 ELSE login_failures.failures + 1 END,
+			last_failed_at = EXCLUDED.last_failed_at
+		RETURNING failures`).
+		Bind(dbx.Params{"key": key, "at": at, "reset": resetBefore}).Row(&failures)
+	return failures, err
+}
+
+// LockLogin locks out the given key until the given time
+func (r repository) LockLogin(ctx context.Context, key string, until time.Time) error {
+	_, err := r.db.With(ctx).Update("login_failures", dbx.Params{"locked_until": until}, dbx.HashExp{"key": key}).Execute()
+	return err
+}
+
+// ResetLoginFailures clears the failed login attempts and lockout tracked under the given key
+func (r repository) ResetLoginFailures(ctx context.Context, key string) error {
+	_, err := r.db.With(ctx).Delete("login_failures", dbx.HashExp{"key": key}).Execute()
+	return err
+}
+
+// emailEquals builds a case-insensitive email match that can use the unique index on LOWER(email).
+func emailEquals(email string) dbx.Expression {
+	return dbx.NewExp("LOWER(email) = LOWER({:email})", dbx.Params{"email": email})
+}
+
+// requireAffected returns sql.ErrNoRows if the execution of a statement did not affect any row.
+func requireAffected(res sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
 }
@@ -0,0 +1,63 @@
+package auth
+
+// APIKeyPrefix is the prefix of every API key. It tells API keys apart from JWT tokens.
+const APIKeyPrefix = "gnv_"
+
+// Scopes that can be granted to API keys. Routes declare the scopes they need with Require.
+const (
+	// ScopeMessagesSend allows sending messages.
+	ScopeMessagesSend = "messages:send"
+	// ScopeMessagesRead allows reading sent messages along with their events, batches and imports.
+	ScopeMessagesRead = "messages:read"
+	// ScopeTemplatesRead allows reading templates.
+	ScopeTemplatesRead = "templates:read"
+	// ScopeTemplatesWrite allows creating, updating and deleting templates.
+	ScopeTemplatesWrite = "templates:write"
+	// ScopeAccountsRead allows reading linked provider accounts.
+	ScopeAccountsRead = "accounts:read"
+	// ScopeAccountsWrite allows linking provider accounts.
+	ScopeAccountsWrite = "accounts:write"
+	// ScopeSuppressionsRead allows reading and exporting the suppression list.
+	ScopeSuppressionsRead = "suppressions:read"
+	// ScopeSuppressionsWrite allows adding, importing and removing suppressed addresses.
+	ScopeSuppressionsWrite = "suppressions:write"
+	// ScopeContactsRead allows reading contacts and lists.
+	ScopeContactsRead = "contacts:read"
+	// ScopeContactsWrite allows creating, updating and deleting contacts and lists, and managing list members.
+	ScopeContactsWrite = "contacts:write"
+	// ScopeWebhooksRead allows reading webhooks and their deliveries.
+	ScopeWebhooksRead = "webhooks:read"
+	// ScopeWebhooksWrite allows creating, updating and deleting webhooks.
+	ScopeWebhooksWrite = "webhooks:write"
+	// ScopeStatsRead allows reading message statistics.
+	ScopeStatsRead = "stats:read"
+	// ScopeInboundRead allows reading received messages.
+	ScopeInboundRead = "inbound:read"
+	// ScopeInboundWrite allows posting received messages as raw MIME.
+	ScopeInboundWrite = "inbound:write"
+	// ScopeDomainsRead allows reading the sender domains, their DKIM keys and the DNS records to publish for them.
+	ScopeDomainsRead = "domains:read"
+	// ScopeDomainsWrite allows adding, verifying and deleting sender domains, and managing their DKIM keys.
+	ScopeDomainsWrite = "domains:write"
+)
+
+// Scopes lists all the scopes that can be granted to API keys.
+var Scopes = []string{
+	ScopeMessagesSend,
+	ScopeMessagesRead,
+	ScopeTemplatesRead,
+	ScopeTemplatesWrite,
+	ScopeAccountsRead,
+	ScopeAccountsWrite,
+	ScopeSuppressionsRead,
+	ScopeSuppressionsWrite,
+	ScopeContactsRead,
+	ScopeContactsWrite,
+	ScopeWebhooksRead,
+	ScopeWebhooksWrite,
+	ScopeStatsRead,
+	ScopeInboundRead,
+	ScopeInboundWrite,
+	ScopeDomainsRead,
+	ScopeDomainsWrite,
+}
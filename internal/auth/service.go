@@ -2,24 +2,119 @@ package auth
 
 import (
 	"context"
+	"fmt"
+	"strings"
 	"time"
 
+	"github.com/garaekz/gonvelope/internal/audit"
 	"github.com/garaekz/gonvelope/internal/entity"
 	"github.com/garaekz/gonvelope/internal/errors"
+	"github.com/garaekz/gonvelope/pkg/dbcontext"
 	"github.com/garaekz/gonvelope/pkg/log"
+	"github.com/garaekz/gonvelope/pkg/mailer"
+	"github.com/garaekz/gonvelope/pkg/password"
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/go-ozzo/ozzo-validation/v4/is"
 	"github.com/golang-jwt/jwt/v5"
 	"golang.org/x/crypto/bcrypt"
 )
 
+const (
+	// verificationResendCooldown is the minimum time between two verification emails sent to the same user.
+	verificationResendCooldown = time.Minute
+	// verificationResendLimit is the maximum number of verification emails sent to the same user per hour.
+	verificationResendLimit = 5
+	// passwordResetCooldown is the minimum time between two password reset emails sent to the same user.
+	passwordResetCooldown = time.Minute
+	// maxNameLength is the maximum length of a user name.
+	maxNameLength = 128
+	// maxEmailLength is the maximum length of an email address as allowed by RFC 5321.
+	maxEmailLength = 254
+	// maxPasswordLength is the maximum length of a password in bytes, as bcrypt rejects longer passwords.
+	maxPasswordLength = 72
+	// emailExistsMessage is the error message returned when registering an email that is already taken.
+	emailExistsMessage = "email already exists"
+)
+
 // Service encapsulates the authentication logic.
 type Service interface {
-	// authenticate authenticates a user using email and password.
-	// It returns a JWT token if authentication succeeds. Otherwise, an error is returned.
-	Login(ctx context.Context, email, password string) (string, error)
-	// Register registers a new user.
-	Register(ctx context.Context, name, email, password string) error
+	// Login authenticates a user using email and password.
+	// It returns a JWT token if authentication succeeds, or a challenge token if the user must also
+	// provide a two-factor code. Otherwise, an error is returned.
+	// Failed attempts are throttled per email and per IP address according to the lockout policy.
+	Login(ctx context.Context, email, password, ip string) (LoginResult, error)
+	// Register registers a new inactive user and sends them an email verification link.
+	Register(ctx context.Context, req RegisterRequest) error
+	// VerifyEmail consumes an email verification token and activates the corresponding user.
+	VerifyEmail(ctx context.Context, token string) error
+	// ResendVerification sends a new email verification link to a user pending verification.
+	ResendVerification(ctx context.Context, email string) error
+	// ForgotPassword sends a password reset link to the user with the given email, if any.
+	ForgotPassword(ctx context.Context, email string) error
+	// CheckResetToken checks that a password reset token can still be used.
+	CheckResetToken(ctx context.Context, token string) error
+	// ResetPassword consumes a password reset token and sets a new password for the corresponding user.
+	ResetPassword(ctx context.Context, token, password string) error
+	// EnrollTOTP generates a new pending TOTP secret for the user.
+	EnrollTOTP(ctx context.Context, userID string) (TOTPEnrollment, error)
+	// ConfirmTOTP enables two-factor authentication and returns the recovery codes.
+	ConfirmTOTP(ctx context.Context, userID, code string) ([]string, error)
+	// DisableTOTP disables two-factor authentication.
+	DisableTOTP(ctx context.Context, userID, code string) error
+	// RegenerateRecoveryCodes replaces the recovery codes of the user.
+	RegenerateRecoveryCodes(ctx context.Context, userID, code string) ([]string, error)
+	// VerifyTwoFactor exchanges a challenge token and a two-factor code for a JWT token.
+	VerifyTwoFactor(ctx context.Context, challengeToken, code string) (string, error)
+	// GetProfile returns the profile of the user along with the provider accounts they linked.
+	GetProfile(ctx context.Context, userID string) (Profile, error)
+	// UpdateProfile updates the name of the user.
+	UpdateProfile(ctx context.Context, userID string, req UpdateProfileRequest) (Profile, error)
+	// RequestEmailChange sends a confirmation link to the new email address of the user.
+	RequestEmailChange(ctx context.Context, userID string, req ChangeEmailRequest) error
+	// ConfirmEmailChange consumes an email change token and switches the user to the new email address.
+	ConfirmEmailChange(ctx context.Context, token string) error
+	// ChangePassword sets a new password after checking the current one and returns a new JWT token.
+	ChangePassword(ctx context.Context, userID string, req ChangePasswordRequest) (string, error)
+	// Deactivate deactivates the account of the user after checking their password.
+	Deactivate(ctx context.Context, userID, password string) error
+	// UnlockAccount clears the failed login attempts of a user on behalf of an administrator.
+	UnlockAccount(ctx context.Context, adminID, userID, ip string) error
+}
+
+// LoginResult represents the outcome of a successful password authentication.
+// When the user has enabled two-factor authentication, only ChallengeToken is set and it must be
+// exchanged together with a two-factor code for the actual JWT token.
+type LoginResult struct {
+	Token             string `json:"token,omitempty"`
+	ChallengeToken    string `json:"challenge_token,omitempty"`
+	TwoFactorRequired bool   `json:"two_factor_required,omitempty"`
+}
+
+// RegisterRequest represents a user registration request.
+type RegisterRequest struct {
+	Name     string `json:"name"`
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+// Validate validates the RegisterRequest fields.
+func (m RegisterRequest) Validate() error {
+	return validation.ValidateStruct(&m,
+		validation.Field(&m.Name, validation.Required, validation.Length(0, maxNameLength)),
+		validation.Field(&m.Email, validation.Required, validation.Length(0, maxEmailLength), is.EmailFormat),
+		validation.Field(&m.Password, validation.Required, passwordLength),
+	)
 }
 
+// passwordLength checks that a password fits in maxPasswordLength bytes. Unlike validation.Length, which
+// counts characters, it lets no multibyte password through that bcrypt would then reject.
+var passwordLength = validation.By(func(value interface{}) error {
+	if password, _ := value.(string); len(password) > maxPasswordLength {
+		return validation.NewError("validation_password_too_long", fmt.Sprintf("must be no more than %d bytes long", maxPasswordLength))
+	}
+	return nil
+})
+
 // Identity represents an authenticated user identity.
 type Identity interface {
 	// GetID returns the user ID.
@@ -31,44 +126,323 @@ type Identity interface {
 }
 
 type service struct {
-	repo            Repository
-	signingKey      string
-	tokenExpiration int
-	logger          log.Logger
+	repo                   Repository
+	transactional          dbcontext.TransactionFunc
+	sender                 mailer.Mailer
+	signingKey             string
+	tokenExpiration        int
+	appURL                 string
+	resetURL               string
+	verificationExpiration int
+	resetExpiration        int
+	totpIssuer             string
+	passwordPolicy         password.Policy
+	lockout                LockoutPolicy
+	auditor                audit.Recorder
+	logger                 log.Logger
 }
 
-// NewService creates a new authentication service.
-func NewService(repo Repository, signingKey string, tokenExpiration int, logger log.Logger) Service {
-	return service{repo, signingKey, tokenExpiration, logger}
+// NewService creates a new authentication service. Password reset emails link to resetURL with the token
+// appended as the token query parameter, or to the password reset endpoint of the API if resetURL is empty.
+func NewService(repo Repository, transactional dbcontext.TransactionFunc, sender mailer.Mailer, signingKey string, tokenExpiration int, appURL, resetURL string, verificationExpiration, resetExpiration int, totpIssuer string, passwordPolicy password.Policy, lockout LockoutPolicy, auditor audit.Recorder, logger log.Logger) Service {
+	return service{repo, transactional, sender, signingKey, tokenExpiration, appURL, resetURL, verificationExpiration, resetExpiration, totpIssuer, passwordPolicy, lockout, auditor, logger}
 }
 
 // Login authenticates a user and generates a JWT token if authentication succeeds.
 // Otherwise, an error is returned.
-func (s service) Login(ctx context.Context, email, password string) (string, error) {
-	if identity := s.authenticate(ctx, email, password); identity != nil {
-		return s.generateJWT(identity)
+func (s service) Login(ctx context.Context, email, password, ip string) (LoginResult, error) {
+	email = normalizeEmail(email)
+	err := validation.Errors{
+		"email":    validation.Validate(email, validation.Required, validation.Length(0, maxEmailLength)),
+		"password": validation.Validate(password, validation.Required, passwordLength),
+	}.Filter()
+	if err != nil {
+		return LoginResult{}, invalidInput(err)
+	}
+
+	now := time.Now()
+	keys := s.loginKeys(email, ip)
+	if err := s.checkThrottle(ctx, keys, now); err != nil {
+		return LoginResult{}, err
+	}
+	identity := s.authenticate(ctx, email, password)
+	if identity == nil {
+		s.recordLoginFailure(ctx, keys, ip, now)
+		return LoginResult{}, errors.Unauthorized("Authentication failed, check your email and password and try again")
 	}
-	return "", errors.Unauthorized("Authentication failed, check your email and password and try again")
+	if user, ok := identity.(entity.User); ok && user.TwoFactorEnabled() {
+		// the failures of the email are only reset once the two-factor code is verified too
+		challenge, err := s.generateChallengeToken(user)
+		return LoginResult{ChallengeToken: challenge, TwoFactorRequired: true}, err
+	}
+	if err := s.repo.ResetLoginFailures(ctx, emailLoginKey(email)); err != nil {
+		return LoginResult{}, err
+	}
+	token, err := s.generateJWT(identity)
+	return LoginResult{Token: token}, err
 }
 
-// Register registers a new user.
-func (s service) Register(ctx context.Context, name, email, password string) error {
-	if _, err := s.repo.GetUserByEmail(ctx, email); err == nil {
-		return errors.BadRequest("email already exists")
+// Register registers a new inactive user along with their personal organization and sends them an email verification link.
+func (s service) Register(ctx context.Context, req RegisterRequest) error {
+	req.Name = strings.TrimSpace(req.Name)
+	req.Email = normalizeEmail(req.Email)
+	if err := req.Validate(); err != nil {
+		return invalidInput(err)
 	}
-	pass, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err := s.checkPassword(req.Password, req.Email); err != nil {
+		return err
+	}
+	// the unique index on users.email catches concurrent registrations that pass this check
+	if _, err := s.repo.GetUserByEmail(ctx, req.Email); err == nil {
+		return errors.Conflict(emailExistsMessage)
+	}
+	pass, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
 	if err != nil {
 		return errors.InternalServerError("Failed to hash password")
 	}
 
 	user := entity.User{
 		ID:       entity.GenerateID(),
-		Name:     name,
-		Email:    email,
+		Name:     req.Name,
+		Email:    req.Email,
 		Password: string(pass),
+		Active:   false,
+	}
+
+	var token string
+	err = s.transactional(ctx, func(ctx context.Context) error {
+		if err := s.repo.CreateUser(ctx, user); err != nil {
+			return err
+		}
+		if err := s.repo.CreatePersonalOrganization(ctx, user); err != nil {
+			return err
+		}
+		token, err = s.issueVerificationToken(ctx, user)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	s.sendVerificationEmail(ctx, user, token)
+	return nil
+}
+
+// VerifyEmail consumes an email verification token and activates the corresponding user.
+func (s service) VerifyEmail(ctx context.Context, token string) error {
+	invalid := errors.BadRequest("The verification link is invalid or has expired")
+	if token == "" {
+		return invalid
+	}
+	userToken, err := s.repo.GetUserToken(ctx, entity.TokenPurposeEmailVerification, hashToken(s.signingKey, token))
+	if err != nil || !userToken.IsUsable(time.Now()) {
+		return invalid
 	}
 
-	return s.repo.CreateUser(ctx, user)
+	return s.transactional(ctx, func(ctx context.Context) error {
+		if err := s.repo.UseUserToken(ctx, userToken.ID); err != nil {
+			return invalid
+		}
+		return s.repo.ActivateUser(ctx, userToken.UserID)
+	})
+}
+
+// ResendVerification sends a new email verification link to a user pending verification.
+// To avoid leaking which emails are registered, it succeeds silently for unknown or already verified accounts,
+// as well as when a verification email was sent too recently.
+func (s service) ResendVerification(ctx context.Context, email string) error {
+	email = normalizeEmail(email)
+	logger := s.logger.With(ctx, "email", email)
+	user, err := s.repo.GetUserByEmail(ctx, email)
+	if err != nil || user.EmailVerifiedAt != nil {
+		logger.Infof("User not found or already verified: verification email skipped")
+		return nil
+	}
+
+	now := time.Now()
+	recent, err := s.repo.CountUserTokensSince(ctx, user.ID, entity.TokenPurposeEmailVerification, now.Add(-verificationResendCooldown))
+	if err != nil {
+		logger.Errorf("failed to count verification tokens: %v", err)
+		return nil
+	}
+	hourly, err := s.repo.CountUserTokensSince(ctx, user.ID, entity.TokenPurposeEmailVerification, now.Add(-time.Hour))
+	if err != nil {
+		logger.Errorf("failed to count verification tokens: %v", err)
+		return nil
+	}
+	if recent > 0 || hourly >= verificationResendLimit {
+		logger.Infof("Verification requested too often: verification email skipped")
+		return nil
+	}
+
+	token, err := s.issueVerificationToken(ctx, user)
+	if err != nil {
+		logger.Errorf("failed to issue verification token: %v", err)
+		return nil
+	}
+	s.sendVerificationEmail(ctx, user, token)
+	return nil
+}
+
+// ForgotPassword sends a password reset link to the user with the given email, if any.
+// It behaves the same whether or not the account exists so that it cannot be used to discover registered emails.
+func (s service) ForgotPassword(ctx context.Context, email string) error {
+	email = normalizeEmail(email)
+	logger := s.logger.With(ctx, "email", email)
+	user, err := s.repo.GetActiveUserByEmail(ctx, email)
+	if err != nil {
+		logger.Infof("User not found: password reset skipped")
+		return nil
+	}
+
+	recent, err := s.repo.CountUserTokensSince(ctx, user.ID, entity.TokenPurposePasswordReset, time.Now().Add(-passwordResetCooldown))
+	if err != nil || recent > 0 {
+		logger.Infof("Password reset requested too often: password reset skipped")
+		return nil
+	}
+
+	token, err := s.issueToken(ctx, user, entity.TokenPurposePasswordReset, time.Duration(s.resetExpiration)*time.Minute)
+	if err != nil {
+		logger.Errorf("failed to issue password reset token: %v", err)
+		return nil
+	}
+	link := s.resetLink(token)
+	s.sendEmail(ctx, user, "Reset your password", fmt.Sprintf("Hi %s,\n\nWe received a request to reset your password. "+
+		"You can choose a new password by visiting the link below:\n\n%s\n\n"+
+		"The link expires in %d minutes. If you did not request a password reset, you can ignore this email.\n",
+		user.Name, link, s.resetExpiration))
+	return nil
+}
+
+// resetLink returns the link of the password reset email carrying the token.
+func (s service) resetLink(token string) string {
+	if s.resetURL == "" {
+		return fmt.Sprintf("%s/api/v1/password/reset?token=%s", s.appURL, token)
+	}
+	sep := "?"
+	if strings.Contains(s.resetURL, "?") {
+		sep = "&"
+	}
+	return s.resetURL + sep + "token=" + token
+}
+
+// CheckResetToken checks that a password reset token can still be used, so that a client can tell
+// the user before they choose a new password.
+func (s service) CheckResetToken(ctx context.Context, token string) error {
+	_, err := s.getResetToken(ctx, token)
+	return err
+}
+
+// getResetToken returns the usable password reset token matching the plain token.
+func (s service) getResetToken(ctx context.Context, token string) (entity.UserToken, error) {
+	invalid := errors.BadRequest("The password reset link is invalid or has expired")
+	if token == "" {
+		return entity.UserToken{}, invalid
+	}
+	userToken, err := s.repo.GetUserToken(ctx, entity.TokenPurposePasswordReset, hashToken(s.signingKey, token))
+	if err != nil || !userToken.IsUsable(time.Now()) {
+		return entity.UserToken{}, invalid
+	}
+	return userToken, nil
+}
+
+// ResetPassword consumes a password reset token and sets a new password for the corresponding user.
+// All the access tokens and pending reset tokens of the user are revoked.
+func (s service) ResetPassword(ctx context.Context, token, password string) error {
+	invalid := errors.BadRequest("The password reset link is invalid or has expired")
+	userToken, err := s.getResetToken(ctx, token)
+	if err != nil {
+		return err
+	}
+	user, err := s.repo.GetUserByID(ctx, userToken.UserID)
+	if err != nil {
+		return invalid
+	}
+	if err := s.checkPassword(password, user.Email); err != nil {
+		return err
+	}
+	pass, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return errors.InternalServerError("Failed to hash password")
+	}
+
+	return s.transactional(ctx, func(ctx context.Context) error {
+		if err := s.repo.UseUserToken(ctx, userToken.ID); err != nil {
+			return invalid
+		}
+		if err := s.repo.RevokeUserTokens(ctx, userToken.UserID, entity.TokenPurposePasswordReset); err != nil {
+			return err
+		}
+		return s.repo.ChangePassword(ctx, userToken.UserID, string(pass))
+	})
+}
+
+// checkPassword checks that the password a user chose meets the password policy.
+func (s service) checkPassword(password, email string) error {
+	err := validation.Errors{
+		"password": validation.Validate(password, validation.Required, passwordLength,
+			validation.By(func(interface{}) error { return s.passwordPolicy.Check(password, email) })),
+	}.Filter()
+	if err != nil {
+		return invalidInput(err)
+	}
+	return nil
+}
+
+// normalizeEmail trims and lowercases an email address so that it can be looked up regardless of how it was typed.
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// invalidInput converts validation errors into an error response.
+func invalidInput(err error) error {
+	if errs, ok := err.(validation.Errors); ok {
+		return errors.InvalidInput(errs)
+	}
+	return err
+}
+
+// issueVerificationToken creates and stores a new email verification token for the given user.
+func (s service) issueVerificationToken(ctx context.Context, user entity.User) (string, error) {
+	return s.issueToken(ctx, user, entity.TokenPurposeEmailVerification, time.Duration(s.verificationExpiration)*time.Hour)
+}
+
+// issueToken creates and stores a new single-use token with the given purpose and lifetime for the user.
+// The plain token is returned so that it can be sent to the user; only its hash is stored.
+func (s service) issueToken(ctx context.Context, user entity.User, purpose string, ttl time.Duration) (string, error) {
+	token, err := generateToken()
+	if err != nil {
+		return "", err
+	}
+	err = s.repo.CreateUserToken(ctx, entity.UserToken{
+		ID:        entity.GenerateID(),
+		UserID:    user.ID,
+		Purpose:   purpose,
+		TokenHash: hashToken(s.signingKey, token),
+		ExpiresAt: time.Now().Add(ttl),
+	})
+	return token, err
+}
+
+// sendVerificationEmail sends the email verification link to the user.
+func (s service) sendVerificationEmail(ctx context.Context, user entity.User, token string) {
+	link := fmt.Sprintf("%s/api/v1/verify?token=%s", s.appURL, token)
+	s.sendEmail(ctx, user, "Verify your email address", fmt.Sprintf("Hi %s,\n\nPlease confirm your email address by visiting the link below:\n\n%s\n\n"+
+		"The link expires in %d hours. If you did not create an account, you can ignore this email.\n",
+		user.Name, link, s.verificationExpiration))
+}
+
+// sendEmail sends a system email to the user.
+// Failures are only logged since the user can always request a new email.
+func (s service) sendEmail(ctx context.Context, user entity.User, subject, text string) {
+	err := s.sender.Send(ctx, mailer.Message{
+		To:      []string{user.Email},
+		Subject: subject,
+		Text:    text,
+	})
+	if err != nil {
+		s.logger.With(ctx, "user_id", user.ID).Errorf("failed to send email %q: %v", subject, err)
+	}
 }
 
 // authenticate authenticates a user using email and password.
@@ -87,15 +461,26 @@ func (s service) authenticate(ctx context.Context, email, password string) Ident
 		return nil
 	}
 
-	return entity.User{ID: user.GetID(), Name: user.GetName(), Email: user.GetEmail()}
+	return entity.User{
+		ID:            user.GetID(),
+		Name:          user.GetName(),
+		Email:         user.GetEmail(),
+		TokenVersion:  user.GetTokenVersion(),
+		TOTPEnabledAt: user.TOTPEnabledAt,
+	}
 }
 
 // generateJWT generates a JWT that encodes an identity.
+// The token carries the identity's token version, if any, so that it can be revoked later.
 func (s service) generateJWT(identity Identity) (string, error) {
-	return jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+	claims := jwt.MapClaims{
 		"id":    identity.GetID(),
 		"name":  identity.GetName(),
 		"email": identity.GetEmail(),
 		"exp":   time.Now().Add(time.Duration(s.tokenExpiration) * time.Hour).Unix(),
-	}).SignedString([]byte(s.signingKey))
+	}
+	if v, ok := identity.(interface{ GetTokenVersion() int }); ok {
+		claims["ver"] = v.GetTokenVersion()
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(s.signingKey))
 }
@@ -2,34 +2,77 @@ package auth
 
 import (
 	"context"
+	"database/sql"
+	"net/http"
+	"net/url"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/garaekz/gonvelope/internal/audit"
 	"github.com/garaekz/gonvelope/internal/entity"
 	"github.com/garaekz/gonvelope/internal/errors"
 	"github.com/garaekz/gonvelope/pkg/log"
+	"github.com/garaekz/gonvelope/pkg/mailer"
+	"github.com/garaekz/gonvelope/pkg/password"
+	"github.com/lib/pq"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
 )
 
-func Test_service_Authenticate(t *testing.T) {
+func newTestService(t *testing.T) (service, *mockRepository, *mailer.Sandbox) {
 	logger, _ := log.NewForTest()
-	s := NewService("test", 100, logger)
-	_, err := s.Login(context.Background(), "unknown", "bad")
-	assert.Equal(t, errors.Unauthorized(""), err)
-	token, err := s.Login(context.Background(), "demo", "pass")
+	pass, err := bcrypt.GenerateFromPassword([]byte("pass"), bcrypt.MinCost)
+	require.Nil(t, err)
+	repo := &mockRepository{users: []entity.User{
+		{ID: "100", Name: "demo", Email: "demo", Password: string(pass), Active: true},
+	}}
+	sandbox := mailer.NewSandbox("noreply@example.com", "Gonvelope", logger)
+	s := NewService(repo, mockTransactional, sandbox, "test", 100, "http://localhost", "", 24, 60, "Gonvelope", password.Policy{MinLength: 8, RejectCommon: true}, testLockoutPolicy, audit.NewLogRecorder(logger), logger).(service)
+	return s, repo, sandbox
+}
+
+func mockTransactional(ctx context.Context, f func(ctx context.Context) error) error {
+	return f(ctx)
+}
+
+// tokenFromMail extracts the token query parameter from the link in the last sent email.
+func tokenFromMail(t *testing.T, sandbox *mailer.Sandbox) string {
+	messages := sandbox.Messages()
+	require.NotEmpty(t, messages)
+	return tokenFromText(t, messages[len(messages)-1].Text)
+}
+
+// tokenFromText extracts the token query parameter from the link in an email body.
+func tokenFromText(t *testing.T, text string) string {
+	for _, field := range strings.Fields(text) {
+		if u, err := url.Parse(field); err == nil && u.Query().Get("token") != "" {
+			return u.Query().Get("token")
+		}
+	}
+	t.Fatal("no token found in email")
+	return ""
+}
+
+func Test_service_Authenticate(t *testing.T) {
+	s, _, _ := newTestService(t)
+	_, err := s.Login(context.Background(), "unknown", "bad", "127.0.0.1")
+	assert.Equal(t, http.StatusUnauthorized, err.(errors.ErrorResponse).StatusCode())
+	result, err := s.Login(context.Background(), "demo", "pass", "127.0.0.1")
 	assert.Nil(t, err)
-	assert.NotEmpty(t, token)
+	assert.NotEmpty(t, result.Token)
+	assert.False(t, result.TwoFactorRequired)
 }
 
 func Test_service_authenticate_function(t *testing.T) {
-	logger, _ := log.NewForTest()
-	s := service{"test", 100, logger}
+	s, _, _ := newTestService(t)
 	assert.Nil(t, s.authenticate(context.Background(), "unknown", "bad"))
 	assert.NotNil(t, s.authenticate(context.Background(), "demo", "pass"))
 }
 
 func Test_service_GenerateJWT(t *testing.T) {
-	logger, _ := log.NewForTest()
-	s := service{"test", 100, logger}
+	s, _, _ := newTestService(t)
 	token, err := s.generateJWT(entity.User{
 		ID:   "100",
 		Name: "demo",
@@ -38,3 +81,292 @@ func Test_service_GenerateJWT(t *testing.T) {
 		assert.NotEmpty(t, token)
 	}
 }
+
+func Test_service_RegisterAndVerify(t *testing.T) {
+	s, repo, sandbox := newTestService(t)
+	ctx := context.Background()
+
+	assert.NotNil(t, s.Register(ctx, RegisterRequest{Name: "demo", Email: "demo", Password: "correct-horse-42"}))
+
+	require.Nil(t, s.Register(ctx, RegisterRequest{Name: "new", Email: "New@Example.com ", Password: "correct-horse-42"}))
+	user, err := repo.GetUserByEmail(ctx, "new@example.com")
+	require.Nil(t, err)
+	assert.False(t, user.Active)
+	assert.Equal(t, []string{"new@example.com"}, sandbox.Messages()[0].To)
+	if assert.Len(t, repo.organizations, 1) {
+		assert.Equal(t, user.ID, repo.organizations[0].ID)
+	}
+
+	// unverified users cannot log in
+	_, err = s.Login(ctx, "new@example.com", "correct-horse-42", "127.0.0.1")
+	assert.NotNil(t, err)
+
+	assert.NotNil(t, s.VerifyEmail(ctx, ""))
+	assert.NotNil(t, s.VerifyEmail(ctx, "invalid"))
+
+	token := tokenFromMail(t, sandbox)
+	assert.Nil(t, s.VerifyEmail(ctx, token))
+	_, err = s.Login(ctx, "new@example.com", "correct-horse-42", "127.0.0.1")
+	assert.Nil(t, err)
+
+	// tokens are single-use
+	assert.NotNil(t, s.VerifyEmail(ctx, token))
+}
+
+func Test_service_Register_validation(t *testing.T) {
+	s, repo, _ := newTestService(t)
+	ctx := context.Background()
+	tests := []struct {
+		name string
+		req  RegisterRequest
+	}{
+		{"empty", RegisterRequest{}},
+		{"missing name", RegisterRequest{Email: "new@example.com", Password: "correct-horse-42"}},
+		{"invalid email", RegisterRequest{Name: "new", Email: "not-an-email", Password: "correct-horse-42"}},
+		{"empty password", RegisterRequest{Name: "new", Email: "new@example.com"}},
+		{"short password", RegisterRequest{Name: "new", Email: "new@example.com", Password: "Xk9#mQ2"}},
+		{"long password", RegisterRequest{Name: "new", Email: "new@example.com", Password: strings.Repeat("x", maxPasswordLength+1)}},
+		// 72 characters, but 144 bytes
+		{"long multibyte password", RegisterRequest{Name: "new", Email: "new@example.com", Password: strings.Repeat("ñ", maxPasswordLength)}},
+		{"common password", RegisterRequest{Name: "new", Email: "new@example.com", Password: "password1234"}},
+		{"password contains email", RegisterRequest{Name: "new", Email: "jane.doe@example.com", Password: "jane.doe-secret"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := s.Register(ctx, tt.req)
+			assert.Equal(t, http.StatusBadRequest, statusCode(err))
+			assert.NotNil(t, err.(errors.ErrorResponse).Details)
+		})
+	}
+	assert.Len(t, repo.users, 1)
+}
+
+func Test_service_Register_conflict(t *testing.T) {
+	s, repo, _ := newTestService(t)
+	ctx := context.Background()
+	req := RegisterRequest{Name: "new", Email: "new@example.com", Password: "correct-horse-42"}
+	require.Nil(t, s.Register(ctx, req))
+
+	req.Email = "NEW@example.com"
+	assert.Equal(t, http.StatusConflict, statusCode(s.Register(ctx, req)))
+
+	// a concurrent registration passing the lookup is rejected by the unique index
+	s.repo = staleRepository{repo}
+	assert.Equal(t, http.StatusConflict, statusCode(s.Register(ctx, req)))
+	assert.Len(t, repo.users, 2)
+}
+
+// staleRepository simulates a lookup racing with a concurrent registration.
+type staleRepository struct {
+	*mockRepository
+}
+
+func (staleRepository) GetUserByEmail(context.Context, string) (entity.User, error) {
+	return entity.User{}, sql.ErrNoRows
+}
+
+func Test_service_VerifyEmail_expired(t *testing.T) {
+	s, repo, sandbox := newTestService(t)
+	ctx := context.Background()
+	require.Nil(t, s.Register(ctx, RegisterRequest{Name: "new", Email: "New@Example.com ", Password: "correct-horse-42"}))
+	repo.tokens[0].ExpiresAt = time.Now().Add(-time.Minute)
+	assert.NotNil(t, s.VerifyEmail(ctx, tokenFromMail(t, sandbox)))
+}
+
+func Test_service_ResendVerification(t *testing.T) {
+	s, repo, sandbox := newTestService(t)
+	ctx := context.Background()
+
+	// unknown and verified users are silently ignored
+	assert.Nil(t, s.ResendVerification(ctx, "unknown@example.com"))
+	assert.Empty(t, sandbox.Messages())
+
+	require.Nil(t, s.Register(ctx, RegisterRequest{Name: "new", Email: "New@Example.com ", Password: "correct-horse-42"}))
+	// resends within the cooldown are skipped without telling the account exists
+	assert.Nil(t, s.ResendVerification(ctx, "new@example.com"))
+	assert.Len(t, sandbox.Messages(), 1)
+
+	created := time.Now().Add(-2 * verificationResendCooldown)
+	repo.tokens[0].CreatedAt = &created
+	assert.Nil(t, s.ResendVerification(ctx, "new@example.com"))
+	assert.Len(t, sandbox.Messages(), 2)
+}
+
+func Test_service_PasswordReset(t *testing.T) {
+	s, repo, sandbox := newTestService(t)
+	ctx := context.Background()
+
+	// unknown emails behave the same as known ones
+	assert.Nil(t, s.ForgotPassword(ctx, "unknown@example.com"))
+	assert.Empty(t, sandbox.Messages())
+
+	assert.Nil(t, s.ForgotPassword(ctx, "demo"))
+	require.Len(t, sandbox.Messages(), 1)
+	token := tokenFromMail(t, sandbox)
+	assert.NotContains(t, repo.tokens[0].TokenHash, token)
+	// the link points at the password reset endpoint
+	assert.Contains(t, sandbox.Messages()[0].Text, "http://localhost/api/v1/password/reset?token="+token+"\n")
+	assert.Nil(t, s.CheckResetToken(ctx, token))
+	assert.NotNil(t, s.CheckResetToken(ctx, "invalid"))
+
+	// a second request within the cooldown does not send another email
+	assert.Nil(t, s.ForgotPassword(ctx, "demo"))
+	assert.Len(t, sandbox.Messages(), 1)
+
+	assert.NotNil(t, s.ResetPassword(ctx, "invalid", "new-passphrase"))
+	assert.NotNil(t, s.ResetPassword(ctx, token, ""))
+	// the new password must meet the password policy
+	err := s.ResetPassword(ctx, token, "password123")
+	assert.Equal(t, http.StatusBadRequest, statusCode(err))
+	assert.Nil(t, s.ResetPassword(ctx, token, "new-passphrase"))
+	assert.Equal(t, 1, repo.users[0].TokenVersion)
+	assert.Nil(t, s.authenticate(ctx, "demo", "pass"))
+	assert.NotNil(t, s.authenticate(ctx, "demo", "new-passphrase"))
+
+	// tokens are single-use
+	assert.NotNil(t, s.ResetPassword(ctx, token, "another-passphrase"))
+}
+
+func Test_service_resetLink(t *testing.T) {
+	s, _, _ := newTestService(t)
+	assert.Equal(t, "http://localhost/api/v1/password/reset?token=abc", s.resetLink("abc"))
+	s.resetURL = "https://app.example.com/reset"
+	assert.Equal(t, "https://app.example.com/reset?token=abc", s.resetLink("abc"))
+	s.resetURL = "https://app.example.com/#/reset?lang=es"
+	assert.Equal(t, "https://app.example.com/#/reset?lang=es&token=abc", s.resetLink("abc"))
+}
+
+func Test_service_ResetPassword_expired(t *testing.T) {
+	s, repo, sandbox := newTestService(t)
+	ctx := context.Background()
+	require.Nil(t, s.ForgotPassword(ctx, "demo"))
+	repo.tokens[0].ExpiresAt = time.Now().Add(-time.Minute)
+	assert.NotNil(t, s.ResetPassword(ctx, tokenFromMail(t, sandbox), "newpass"))
+}
+
+type mockRepository struct {
+	users         []entity.User
+	tokens        []entity.UserToken
+	recoveryCodes []entity.RecoveryCode
+	loginFailures map[string]entity.LoginFailure
+	accounts      map[string][]AccountSummary
+	organizations []entity.Organization
+}
+
+func (m *mockRepository) GetUserByID(_ context.Context, id string) (entity.User, error) {
+	for _, user := range m.users {
+		if user.ID == id {
+			return user, nil
+		}
+	}
+	return entity.User{}, sql.ErrNoRows
+}
+
+func (m *mockRepository) GetUserByEmail(_ context.Context, email string) (entity.User, error) {
+	for _, user := range m.users {
+		if strings.EqualFold(user.Email, email) {
+			return user, nil
+		}
+	}
+	return entity.User{}, sql.ErrNoRows
+}
+
+func (m *mockRepository) GetActiveUserByEmail(ctx context.Context, email string) (entity.User, error) {
+	user, err := m.GetUserByEmail(ctx, email)
+	if err != nil || !user.Active {
+		return entity.User{}, sql.ErrNoRows
+	}
+	return user, nil
+}
+
+func (m *mockRepository) CreateUser(_ context.Context, user entity.User) error {
+	for _, u := range m.users {
+		if strings.EqualFold(u.Email, user.Email) {
+			return errors.MapConflict(&pq.Error{Code: "23505"}, emailExistsMessage)
+		}
+	}
+	m.users = append(m.users, user)
+	return nil
+}
+
+func (m *mockRepository) CreatePersonalOrganization(_ context.Context, user entity.User) error {
+	m.organizations = append(m.organizations, entity.Organization{ID: user.ID, Name: user.Name})
+	return nil
+}
+
+func (m *mockRepository) ActivateUser(_ context.Context, id string) error {
+	now := time.Now()
+	for i, user := range m.users {
+		if user.ID == id {
+			m.users[i].Active = true
+			m.users[i].EmailVerifiedAt = &now
+		}
+	}
+	return nil
+}
+
+func (m *mockRepository) ChangePassword(_ context.Context, id, password string) error {
+	for i, user := range m.users {
+		if user.ID == id {
+			m.users[i].Password = password
+			m.users[i].TokenVersion++
+		}
+	}
+	return nil
+}
+
+func (m *mockRepository) GetTokenVersion(_ context.Context, id string) (int, error) {
+	for _, user := range m.users {
+		if user.ID == id {
+			return user.TokenVersion, nil
+		}
+	}
+	return 0, sql.ErrNoRows
+}
+
+func (m *mockRepository) CreateUserToken(_ context.Context, token entity.UserToken) error {
+	now := time.Now()
+	token.CreatedAt = &now
+	m.tokens = append(m.tokens, token)
+	return nil
+}
+
+func (m *mockRepository) GetUserToken(_ context.Context, purpose, hash string) (entity.UserToken, error) {
+	for _, token := range m.tokens {
+		if token.Purpose == purpose && token.TokenHash == hash {
+			return token, nil
+		}
+	}
+	return entity.UserToken{}, sql.ErrNoRows
+}
+
+func (m *mockRepository) UseUserToken(_ context.Context, id string) error {
+	for i, token := range m.tokens {
+		if token.ID == id && token.UsedAt == nil {
+			now := time.Now()
+			m.tokens[i].UsedAt = &now
+			return nil
+		}
+	}
+	return sql.ErrNoRows
+}
+
+func (m *mockRepository) RevokeUserTokens(_ context.Context, userID, purpose string) error {
+	now := time.Now()
+	for i, token := range m.tokens {
+		if token.UserID == userID && token.Purpose == purpose && token.UsedAt == nil {
+			m.tokens[i].UsedAt = &now
+		}
+	}
+	return nil
+}
+
+func (m *mockRepository) CountUserTokensSince(_ context.Context, userID, purpose string, since time.Time) (int, error) {
+	count := 0
+	for _, token := range m.tokens {
+		if token.UserID == userID && token.Purpose == purpose && !token.CreatedAt.Before(since) {
+			count++
+		}
+	}
+	return count, nil
+}
@@ -0,0 +1,26 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+)
+
+// generateToken generates a random URL-safe token.
+func generateToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashToken signs the given token with the signing key. Only the resulting hash is persisted,
+// so a leaked database cannot be used to forge or replay tokens.
+func hashToken(signingKey, token string) string {
+	mac := hmac.New(sha256.New, []byte(signingKey))
+	mac.Write([]byte(token))
+	return hex.EncodeToString(mac.Sum(nil))
+}
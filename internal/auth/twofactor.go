@@ -0,0 +1,219 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"strings"
+	"time"
+
+	"github.com/garaekz/gonvelope/internal/entity"
+	"github.com/garaekz/gonvelope/internal/errors"
+	"github.com/garaekz/gonvelope/pkg/totp"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	// challengeExpiration is the lifetime of the challenge token returned by Login when two-factor authentication is enabled.
+	challengeExpiration = 5 * time.Minute
+	// totpSkew is the number of time steps before and after the current one for which TOTP codes are accepted.
+	totpSkew = 1
+	// recoveryCodeCount is the number of recovery codes generated for a user.
+	recoveryCodeCount = 10
+	// recoveryCodeAlphabet is the set of characters recovery codes are made of.
+	recoveryCodeAlphabet = "abcdefghijklmnopqrstuvwxyz234567"
+)
+
+// TOTPEnrollment represents a pending TOTP enrollment.
+type TOTPEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+// EnrollTOTP generates a new TOTP secret for the user. Two-factor authentication is not
+// enabled until the secret is confirmed with a valid code.
+func (s service) EnrollTOTP(ctx context.Context, userID string) (TOTPEnrollment, error) {
+	user, err := s.repo.GetUserByID(ctx, userID)
+	if err != nil {
+		return TOTPEnrollment{}, err
+	}
+	if user.TwoFactorEnabled() {
+		return TOTPEnrollment{}, errors.BadRequest("Two-factor authentication is already enabled")
+	}
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return TOTPEnrollment{}, err
+	}
+	if err := s.repo.SetTOTPSecret(ctx, userID, secret); err != nil {
+		return TOTPEnrollment{}, err
+	}
+	return TOTPEnrollment{Secret: secret, URI: totp.URI(s.totpIssuer, user.Email, secret)}, nil
+}
+
+// ConfirmTOTP enables two-factor authentication once the user proves they can generate codes
+// for the pending secret. It returns a new set of recovery codes.
+func (s service) ConfirmTOTP(ctx context.Context, userID, code string) ([]string, error) {
+	user, err := s.repo.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.TwoFactorEnabled() {
+		return nil, errors.BadRequest("Two-factor authentication is already enabled")
+	}
+	if user.TOTPSecret == "" {
+		return nil, errors.BadRequest("There is no pending two-factor enrollment")
+	}
+	if err := s.verifyTOTP(ctx, user, code); err != nil {
+		return nil, err
+	}
+
+	var codes []string
+	err = s.transactional(ctx, func(ctx context.Context) error {
+		if err := s.repo.EnableTOTP(ctx, userID); err != nil {
+			return err
+		}
+		codes, err = s.replaceRecoveryCodes(ctx, userID)
+		return err
+	})
+	return codes, err
+}
+
+// DisableTOTP disables two-factor authentication after checking a TOTP or recovery code.
+func (s service) DisableTOTP(ctx context.Context, userID, code string) error {
+	user, err := s.repo.GetUserByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if !user.TwoFactorEnabled() {
+		return errors.BadRequest("Two-factor authentication is not enabled")
+	}
+	return s.transactional(ctx, func(ctx context.Context) error {
+		if err := s.verifySecondFactor(ctx, user, code); err != nil {
+			return err
+		}
+		return s.repo.DisableTOTP(ctx, userID)
+	})
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes of the user after checking a TOTP code.
+func (s service) RegenerateRecoveryCodes(ctx context.Context, userID, code string) ([]string, error) {
+	user, err := s.repo.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !user.TwoFactorEnabled() {
+		return nil, errors.BadRequest("Two-factor authentication is not enabled")
+	}
+	if err := s.verifyTOTP(ctx, user, code); err != nil {
+		return nil, err
+	}
+	return s.replaceRecoveryCodes(ctx, userID)
+}
+
+// VerifyTwoFactor exchanges a challenge token returned by Login and a TOTP or recovery code for a JWT.
+// Invalid codes count as failed logins of the user, so that guessing codes is throttled and eventually
+// locks out the account like guessing passwords does.
+func (s service) VerifyTwoFactor(ctx context.Context, challengeToken, code string) (string, error) {
+	invalid := errors.Unauthorized("The two-factor challenge is invalid or has expired")
+	token, err := jwt.Parse(challengeToken, func(*jwt.Token) (interface{}, error) {
+		return []byte(s.challengeKey()), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+	if err != nil || !token.Valid {
+		return "", invalid
+	}
+	claims := token.Claims.(jwt.MapClaims)
+	id, _ := claims["id"].(string)
+	version, _ := claims["ver"].(float64)
+
+	user, err := s.repo.GetUserByID(ctx, id)
+	if err != nil || !user.Active || !user.TwoFactorEnabled() || user.TokenVersion != int(version) {
+		return "", invalid
+	}
+	now := time.Now()
+	keys := s.loginKeys(user.Email, "")
+	if err := s.checkThrottle(ctx, keys, now); err != nil {
+		return "", err
+	}
+	if err := s.verifySecondFactor(ctx, user, code); err != nil {
+		s.recordLoginFailure(ctx, keys, "", now)
+		return "", err
+	}
+	if err := s.repo.ResetLoginFailures(ctx, emailLoginKey(user.Email)); err != nil {
+		return "", err
+	}
+	return s.generateJWT(user)
+}
+
+// generateChallengeToken generates a short-lived token proving the user passed the password check.
+// It is signed with a dedicated key so that it can never be used as an access token.
+func (s service) generateChallengeToken(user entity.User) (string, error) {
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"id":  user.ID,
+		"ver": user.TokenVersion,
+		"exp": time.Now().Add(challengeExpiration).Unix(),
+	}).SignedString([]byte(s.challengeKey()))
+}
+
+// challengeKey returns the key used to sign two-factor challenge tokens.
+func (s service) challengeKey() string {
+	return s.signingKey + ":2fa-challenge"
+}
+
+// verifySecondFactor accepts either a TOTP code or an unused recovery code.
+func (s service) verifySecondFactor(ctx context.Context, user entity.User, code string) error {
+	if err := s.verifyTOTP(ctx, user, code); err == nil {
+		return nil
+	}
+	if err := s.repo.UseRecoveryCode(ctx, user.ID, hashToken(s.signingKey, normalizeRecoveryCode(code))); err != nil {
+		s.logger.With(ctx, "user_id", user.ID).Infof("Two-factor verification failed")
+		return errors.Unauthorized("The two-factor code is invalid")
+	}
+	return nil
+}
+
+// verifyTOTP checks a TOTP code and makes sure it cannot be replayed.
+func (s service) verifyTOTP(ctx context.Context, user entity.User, code string) error {
+	invalid := errors.Unauthorized("The two-factor code is invalid")
+	step, ok := totp.Validate(user.TOTPSecret, code, time.Now(), totpSkew)
+	if !ok || step <= user.TOTPLastStep {
+		return invalid
+	}
+	if err := s.repo.UseTOTPStep(ctx, user.ID, step); err != nil {
+		return invalid
+	}
+	return nil
+}
+
+// replaceRecoveryCodes generates and stores a new set of recovery codes, returning them in plain text.
+func (s service) replaceRecoveryCodes(ctx context.Context, userID string) ([]string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		code, err := generateRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		codes[i] = code
+		hashes[i] = hashToken(s.signingKey, normalizeRecoveryCode(code))
+	}
+	if err := s.repo.ReplaceRecoveryCodes(ctx, userID, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// generateRecoveryCode generates a random recovery code formatted as "xxxxx-xxxxx".
+func generateRecoveryCode() (string, error) {
+	b := make([]byte, 10)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	for i := range b {
+		b[i] = recoveryCodeAlphabet[b[i]%byte(len(recoveryCodeAlphabet))]
+	}
+	return string(b[:5]) + "-" + string(b[5:]), nil
+}
+
+// normalizeRecoveryCode makes recovery codes insensitive to case, dashes and spaces.
+func normalizeRecoveryCode(code string) string {
+	return strings.NewReplacer("-", "", " ", "").Replace(strings.ToLower(code))
+}
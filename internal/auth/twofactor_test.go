@@ -0,0 +1,190 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/garaekz/gonvelope/internal/entity"
+	"github.com/garaekz/gonvelope/internal/errors"
+	"github.com/garaekz/gonvelope/pkg/totp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// currentCode returns the TOTP code of the demo user for the given time.
+func currentCode(t *testing.T, repo *mockRepository, at time.Time) string {
+	code, err := totp.Code(repo.users[0].TOTPSecret, at)
+	require.Nil(t, err)
+	return code
+}
+
+func Test_service_TwoFactorFlow(t *testing.T) {
+	s, repo, _ := newTestService(t)
+	ctx := context.Background()
+	now := time.Now()
+
+	_, err := s.ConfirmTOTP(ctx, "100", "123456")
+	assert.NotNil(t, err)
+
+	enrollment, err := s.EnrollTOTP(ctx, "100")
+	require.Nil(t, err)
+	assert.NotEmpty(t, enrollment.Secret)
+	assert.Contains(t, enrollment.URI, "otpauth://totp/Gonvelope:demo")
+
+	// enrollment is pending until confirmed
+	result, err := s.Login(ctx, "demo", "pass", "127.0.0.1")
+	require.Nil(t, err)
+	assert.NotEmpty(t, result.Token)
+
+	_, err = s.ConfirmTOTP(ctx, "100", "000000")
+	assert.NotNil(t, err)
+	codes, err := s.ConfirmTOTP(ctx, "100", currentCode(t, repo, now.Add(-totp.Period*time.Second)))
+	require.Nil(t, err)
+	assert.Len(t, codes, recoveryCodeCount)
+	assert.Len(t, repo.recoveryCodes, recoveryCodeCount)
+
+	_, err = s.EnrollTOTP(ctx, "100")
+	assert.NotNil(t, err)
+
+	result, err = s.Login(ctx, "demo", "pass", "127.0.0.1")
+	require.Nil(t, err)
+	assert.Empty(t, result.Token)
+	assert.True(t, result.TwoFactorRequired)
+
+	// the challenge token cannot be used as an access token and requires a valid code
+	_, err = s.VerifyTwoFactor(ctx, result.ChallengeToken, "000000")
+	assert.NotNil(t, err)
+	_, err = s.VerifyTwoFactor(ctx, "invalid", currentCode(t, repo, now))
+	assert.NotNil(t, err)
+	token, err := s.VerifyTwoFactor(ctx, result.ChallengeToken, currentCode(t, repo, now))
+	assert.Nil(t, err)
+	assert.NotEmpty(t, token)
+
+	// codes cannot be replayed
+	_, err = s.VerifyTwoFactor(ctx, result.ChallengeToken, currentCode(t, repo, now))
+	assert.NotNil(t, err)
+
+	// recovery codes are single-use and insensitive to formatting
+	token, err = s.VerifyTwoFactor(ctx, result.ChallengeToken, "  "+codes[0]+" ")
+	assert.Nil(t, err)
+	assert.NotEmpty(t, token)
+	_, err = s.VerifyTwoFactor(ctx, result.ChallengeToken, codes[0])
+	assert.NotNil(t, err)
+
+	newCodes, err := s.RegenerateRecoveryCodes(ctx, "100", currentCode(t, repo, now.Add(totp.Period*time.Second)))
+	require.Nil(t, err)
+	assert.NotEqual(t, codes, newCodes)
+	_, err = s.VerifyTwoFactor(ctx, result.ChallengeToken, codes[1])
+	assert.NotNil(t, err)
+
+	err = s.DisableTOTP(ctx, "100", "000000")
+	assert.Equal(t, http.StatusUnauthorized, err.(errors.ErrorResponse).StatusCode())
+	assert.Nil(t, s.DisableTOTP(ctx, "100", newCodes[0]))
+	assert.False(t, repo.users[0].TwoFactorEnabled())
+	assert.Empty(t, repo.recoveryCodes)
+}
+
+func Test_service_VerifyTwoFactor_throttle(t *testing.T) {
+	s, repo, _ := newTestService(t)
+	ctx := context.Background()
+	now := time.Now()
+	_, err := s.EnrollTOTP(ctx, "100")
+	require.Nil(t, err)
+	_, err = s.ConfirmTOTP(ctx, "100", currentCode(t, repo, now.Add(-totp.Period*time.Second)))
+	require.Nil(t, err)
+	result, err := s.Login(ctx, "demo", "pass", "127.0.0.1")
+	require.Nil(t, err)
+
+	// invalid codes count as failed logins and are throttled like them, even for the right code
+	for i := 0; i < 3; i++ {
+		_, err = s.VerifyTwoFactor(ctx, result.ChallengeToken, "000000")
+		assert.Equal(t, http.StatusUnauthorized, statusCode(err))
+	}
+	_, err = s.VerifyTwoFactor(ctx, result.ChallengeToken, currentCode(t, repo, now))
+	assert.Equal(t, http.StatusTooManyRequests, statusCode(err))
+	// logging in again with the password does not reset the failures
+	_, err = s.Login(ctx, "demo", "pass", "127.0.0.1")
+	assert.Equal(t, http.StatusTooManyRequests, statusCode(err))
+
+	// the account is locked out once the failures reach the limit
+	repo.rewindLoginFailures(testLockoutPolicy.MaxDelay)
+	_, err = s.VerifyTwoFactor(ctx, result.ChallengeToken, "000000")
+	assert.Equal(t, http.StatusUnauthorized, statusCode(err))
+	assert.True(t, repo.loginFailures["email:demo"].IsLocked(time.Now()))
+
+	repo.loginFailures = nil
+	token, err := s.VerifyTwoFactor(ctx, result.ChallengeToken, currentCode(t, repo, now))
+	require.Nil(t, err)
+	assert.NotEmpty(t, token)
+}
+
+func Test_normalizeRecoveryCode(t *testing.T) {
+	code, err := generateRecoveryCode()
+	require.Nil(t, err)
+	assert.Len(t, code, 11)
+	assert.Equal(t, "abcdefghij", normalizeRecoveryCode(" ABCDE-fghij "))
+}
+
+func (m *mockRepository) SetTOTPSecret(_ context.Context, id, secret string) error {
+	for i, user := range m.users {
+		if user.ID == id {
+			m.users[i].TOTPSecret = secret
+			m.users[i].TOTPEnabledAt = nil
+			m.users[i].TOTPLastStep = 0
+		}
+	}
+	return nil
+}
+
+func (m *mockRepository) EnableTOTP(_ context.Context, id string) error {
+	now := time.Now()
+	for i, user := range m.users {
+		if user.ID == id {
+			m.users[i].TOTPEnabledAt = &now
+		}
+	}
+	return nil
+}
+
+func (m *mockRepository) DisableTOTP(ctx context.Context, id string) error {
+	_ = m.SetTOTPSecret(ctx, id, "")
+	return m.ReplaceRecoveryCodes(ctx, id, nil)
+}
+
+func (m *mockRepository) UseTOTPStep(_ context.Context, id string, step int64) error {
+	for i, user := range m.users {
+		if user.ID == id && user.TOTPLastStep < step {
+			m.users[i].TOTPLastStep = step
+			return nil
+		}
+	}
+	return sql.ErrNoRows
+}
+
+func (m *mockRepository) ReplaceRecoveryCodes(_ context.Context, userID string, hashes []string) error {
+	var codes []entity.RecoveryCode
+	for _, code := range m.recoveryCodes {
+		if code.UserID != userID {
+			codes = append(codes, code)
+		}
+	}
+	for _, hash := range hashes {
+		codes = append(codes, entity.RecoveryCode{ID: entity.GenerateID(), UserID: userID, CodeHash: hash})
+	}
+	m.recoveryCodes = codes
+	return nil
+}
+
+func (m *mockRepository) UseRecoveryCode(_ context.Context, userID, hash string) error {
+	for i, code := range m.recoveryCodes {
+		if code.UserID == userID && code.CodeHash == hash && code.UsedAt == nil {
+			now := time.Now()
+			m.recoveryCodes[i].UsedAt = &now
+			return nil
+		}
+	}
+	return sql.ErrNoRows
+}
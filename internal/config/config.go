@@ -3,18 +3,70 @@ package config
 import (
 	"fmt"
 	"os"
+	"regexp"
 
 	"github.com/garaekz/go-env"
+	"github.com/garaekz/gonvelope/pkg/clientip"
+	"github.com/garaekz/gonvelope/pkg/dkim"
 	"github.com/garaekz/gonvelope/pkg/log"
 	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/go-ozzo/ozzo-validation/v4/is"
 	"gopkg.in/yaml.v2"
 )
 
 const (
 	defaultServerPort         = 8080
 	defaultJWTExpirationHours = 72
+	defaultAppURL             = "http://localhost:8080"
+	defaultMailerDriver       = "sandbox"
+	defaultMailerPort         = 587
+	defaultVerificationHours  = 24
+	defaultResetMinutes       = 60
+	defaultTOTPIssuer         = "Gonvelope"
+	defaultFreeAttempts       = 3
+	defaultDelaySeconds       = 1
+	defaultMaxDelaySeconds    = 60
+	defaultMaxFailures        = 10
+	defaultIPMaxFailures      = 50
+	defaultLockoutMinutes     = 15
+	defaultFailureWindowHours = 24
+	defaultPasswordMinLength  = 8
+	defaultRateLimitBackend   = "memory"
+	defaultAuthRateLimit      = 20
+	defaultAPIRateLimit       = 600
+	defaultRateLimitSeconds   = 60
+	defaultGoogleDailySends   = 500
+	defaultGoogleMinuteSends  = 60
+	defaultOutlookDailySends  = 10000
+	defaultOutlookMinuteSends = 30
+	defaultAttachmentMaxSize  = 10 << 20
+	defaultAttachmentTTLHours = 24
+	defaultAttachmentStorage  = "filesystem"
+	defaultAttachmentPath     = "./data/attachments"
+	defaultTrackingIPs        = "full"
 )
 
+// defaultAttachmentContentTypes are the content types attachments may have by default.
+var defaultAttachmentContentTypes = []string{
+	"application/pdf",
+	"application/zip",
+	"application/msword",
+	"application/vnd.openxmlformats-officedocument.*",
+	"application/vnd.ms-excel",
+	"application/vnd.oasis.opendocument.*",
+	"application/json",
+	"text/plain",
+	"text/csv",
+	"text/calendar",
+	"image/png",
+	"image/jpeg",
+	"image/gif",
+	"image/webp",
+}
+
+// headerNamePattern matches the name of a message header.
+var headerNamePattern = regexp.MustCompile(`^[!-9;-~]+$`)
+
 // OSFileSystem represents a real OS file system.
 type OSFileSystem struct{}
 
@@ -33,6 +85,36 @@ type Config struct {
 	JWTSigningKey string `yaml:"jwt_signing_key" env:"JWT_SIGNING_KEY,secret"`
 	// JWT expiration in hours. Defaults to 72 hours (3 days)
 	JWTExpiration int `yaml:"jwt_expiration" env:"JWT_EXPIRATION"`
+	// the public base URL of the application, used to build links sent by email. Defaults to http://localhost:8080
+	AppURL string `yaml:"app_url" env:"APP_URL"`
+	// the IP addresses or CIDR ranges of the reverse proxies whose X-Forwarded-For and X-Real-IP headers are
+	// trusted to carry the client IP address. The headers are ignored by default
+	TrustedProxies []string `yaml:"trusted_proxies" env:"TRUSTED_PROXIES"`
+	// the page of the frontend where users choose a new password, which password reset emails link to with the
+	// token as the token query parameter. Defaults to the password reset endpoint of the API
+	PasswordResetURL string `yaml:"password_reset_url" env:"PASSWORD_RESET_URL"`
+	// Email verification link expiration in hours. Defaults to 24 hours
+	VerificationExpiration int `yaml:"verification_expiration" env:"VERIFICATION_EXPIRATION"`
+	// Password reset link expiration in minutes. Defaults to 60 minutes
+	PasswordResetExpiration int `yaml:"password_reset_expiration" env:"PASSWORD_RESET_EXPIRATION"`
+	// the issuer name displayed by authenticator apps. Defaults to "Gonvelope"
+	TOTPIssuer string `yaml:"totp_issuer" env:"TOTP_ISSUER"`
+	// Login throttling and lockout policy
+	LoginThrottle *LoginThrottleConfig `yaml:"login_throttle" prefix:"LOGIN_THROTTLE_"`
+	// Password policy applied when users choose a password
+	PasswordPolicy *PasswordPolicyConfig `yaml:"password_policy" prefix:"PASSWORD_POLICY_"`
+	// Rate limiting of API requests
+	RateLimit *RateLimitConfig `yaml:"rate_limit" prefix:"RATE_LIMIT_"`
+	// Send quotas of the linked provider accounts
+	SendQuotas *SendQuotasConfig `yaml:"send_quotas" prefix:"SEND_QUOTAS_"`
+	// Limits and storage of the files attached to messages
+	Attachments *AttachmentsConfig `yaml:"attachments" prefix:"ATTACHMENTS_"`
+	// Privacy settings of open and click tracking
+	Tracking *TrackingConfig `yaml:"tracking" prefix:"TRACKING_"`
+	// DKIM signing of outgoing messages
+	DKIM *DKIMConfig `yaml:"dkim" prefix:"DKIM_"`
+	// Mailer configuration for system emails
+	Mailer *MailerConfig `yaml:"mailer" prefix:"MAILER_"`
 	// Google OAuth configuration
 	GoogleOAuthConfig *OAuthConfig `yaml:"google_oauth" prefix:"GOOGLE_OAUTH_"`
 	// Outlook OAuth configuration
@@ -47,11 +129,264 @@ type OAuthConfig struct {
 	Scopes       []string `yaml:"scopes" env:"SCOPES"`
 }
 
+// MailerConfig represents the configuration of the transport used to send system emails.
+type MailerConfig struct {
+	// the mailer driver, either "sandbox" or "smtp". Defaults to "sandbox"
+	Driver   string `yaml:"driver" env:"DRIVER"`
+	Host     string `yaml:"host" env:"HOST"`
+	Port     int    `yaml:"port" env:"PORT"`
+	Username string `yaml:"username" env:"USERNAME"`
+	Password string `yaml:"password" env:"PASSWORD,secret"`
+	From     string `yaml:"from" env:"FROM"`
+	FromName string `yaml:"from_name" env:"FROM_NAME"`
+	// the domain, selector and PEM encoded private key the system emails sent through SMTP are DKIM-signed with.
+	// The emails are not signed when no key is given
+	DKIMDomain     string `yaml:"dkim_domain" env:"DKIM_DOMAIN"`
+	DKIMSelector   string `yaml:"dkim_selector" env:"DKIM_SELECTOR"`
+	DKIMPrivateKey string `yaml:"dkim_private_key" env:"DKIM_PRIVATE_KEY,secret"`
+}
+
+// Validate validates the mailer configuration.
+func (m MailerConfig) Validate() error {
+	return validation.ValidateStruct(&m,
+		validation.Field(&m.Driver, validation.In("sandbox", "smtp")),
+		validation.Field(&m.Host, validation.When(m.Driver == "smtp", validation.Required)),
+		validation.Field(&m.From, validation.When(m.Driver == "smtp", validation.Required)),
+		validation.Field(&m.DKIMDomain, validation.When(m.DKIMPrivateKey != "", validation.Required, is.Domain)),
+		validation.Field(&m.DKIMSelector, validation.When(m.DKIMPrivateKey != "", validation.Required)),
+		validation.Field(&m.DKIMPrivateKey, validation.By(validatePrivateKey)),
+	)
+}
+
+// validatePrivateKey checks that a DKIM private key, if any, can be parsed.
+func validatePrivateKey(value interface{}) error {
+	if key := value.(string); key != "" {
+		if _, err := dkim.ParsePrivateKey([]byte(key)); err != nil {
+			return validation.NewError("validation_dkim_key", "must be a PEM encoded RSA or Ed25519 private key")
+		}
+	}
+	return nil
+}
+
+// validateProxy checks that a trusted proxy is an IP address or a CIDR range.
+func validateProxy(value interface{}) error {
+	if _, err := clientip.ParseProxy(value.(string)); err != nil {
+		return validation.NewError("validation_proxy", "must be an IP address or a CIDR range")
+	}
+	return nil
+}
+
+// LoginThrottleConfig represents the policy applied to failed login attempts.
+// Failures are counted both per email and per IP address.
+type LoginThrottleConfig struct {
+	// the number of consecutive failures allowed before attempts are delayed. Defaults to 3
+	FreeAttempts int `yaml:"free_attempts" env:"FREE_ATTEMPTS"`
+	// the delay in seconds imposed after the first throttled failure, doubled on every further failure. Defaults to 1
+	Delay int `yaml:"delay" env:"DELAY"`
+	// the maximum delay in seconds between two attempts. Defaults to 60
+	MaxDelay int `yaml:"max_delay" env:"MAX_DELAY"`
+	// the number of failures for an email that locks it out. Defaults to 10
+	MaxFailures int `yaml:"max_failures" env:"MAX_FAILURES"`
+	// the number of failures from an IP address that locks it out. Defaults to 50
+	IPMaxFailures int `yaml:"ip_max_failures" env:"IP_MAX_FAILURES"`
+	// the lockout duration in minutes. Defaults to 15
+	LockoutDuration int `yaml:"lockout_duration" env:"LOCKOUT_DURATION"`
+	// the number of hours without failures after which the failure count is reset. Defaults to 24
+	FailureWindow int `yaml:"failure_window" env:"FAILURE_WINDOW"`
+}
+
+// Validate validates the login throttling policy.
+func (t LoginThrottleConfig) Validate() error {
+	return validation.ValidateStruct(&t,
+		validation.Field(&t.FreeAttempts, validation.Min(0)),
+		validation.Field(&t.Delay, validation.Min(0)),
+		validation.Field(&t.MaxDelay, validation.Min(t.Delay)),
+		validation.Field(&t.MaxFailures, validation.Required, validation.Min(t.FreeAttempts+1)),
+		validation.Field(&t.IPMaxFailures, validation.Required, validation.Min(t.FreeAttempts+1)),
+		validation.Field(&t.LockoutDuration, validation.Required, validation.Min(1)),
+		validation.Field(&t.FailureWindow, validation.Required, validation.Min(1)),
+	)
+}
+
+// PasswordPolicyConfig represents the requirements user passwords must meet.
+type PasswordPolicyConfig struct {
+	// the minimum password length. Defaults to 8
+	MinLength int `yaml:"min_length" env:"MIN_LENGTH"`
+	// whether to reject passwords found in the bundled list of common passwords. Defaults to true
+	RejectCommon bool `yaml:"reject_common" env:"REJECT_COMMON"`
+}
+
+// Validate validates the password policy.
+func (p PasswordPolicyConfig) Validate() error {
+	return validation.ValidateStruct(&p,
+		validation.Field(&p.MinLength, validation.Required, validation.Min(1), validation.Max(72)),
+	)
+}
+
+// RateLimitConfig represents the rate limits applied to each group of routes.
+type RateLimitConfig struct {
+	// the backend keeping the token buckets, either "memory" or "postgres". Use "postgres" when running
+	// several instances so that they share the limits. Defaults to "memory"
+	Backend string `yaml:"backend" env:"BACKEND"`
+	// the limit of the authentication endpoints, per client IP address. Defaults to 20 requests per minute
+	Auth *RateLimitRule `yaml:"auth" prefix:"AUTH_"`
+	// the limit of the authenticated API endpoints, per API key or user. Defaults to 600 requests per minute
+	API *RateLimitRule `yaml:"api" prefix:"API_"`
+}
+
+// Validate validates the rate limiting configuration.
+func (r RateLimitConfig) Validate() error {
+	return validation.ValidateStruct(&r,
+		validation.Field(&r.Backend, validation.In("memory", "postgres")),
+		validation.Field(&r.Auth),
+		validation.Field(&r.API),
+	)
+}
+
+// RateLimitRule represents a token bucket limit.
+type RateLimitRule struct {
+	// the number of requests allowed per period. Zero disables the limit
+	Requests int `yaml:"requests" env:"REQUESTS"`
+	// the period in seconds
+	Period int `yaml:"period" env:"PERIOD"`
+	// the number of requests that can be sent at once. Defaults to the number of requests per period
+	Burst int `yaml:"burst" env:"BURST"`
+}
+
+// Validate validates the rate limit.
+func (r RateLimitRule) Validate() error {
+	return validation.ValidateStruct(&r,
+		validation.Field(&r.Requests, validation.Min(0)),
+		validation.Field(&r.Period, validation.When(r.Requests > 0, validation.Required, validation.Min(1))),
+		validation.Field(&r.Burst, validation.Min(0)),
+	)
+}
+
+// SendQuotasConfig represents the number of messages the accounts of each provider may send.
+type SendQuotasConfig struct {
+	// the quota of Google accounts. Defaults to 500 messages per day and 60 per minute
+	Google *SendQuota `yaml:"google" prefix:"GOOGLE_"`
+	// the quota of Outlook accounts. Defaults to 10000 messages per day and 30 per minute
+	Outlook *SendQuota `yaml:"outlook" prefix:"OUTLOOK_"`
+}
+
+// Validate validates the send quotas.
+func (q SendQuotasConfig) Validate() error {
+	return validation.ValidateStruct(&q,
+		validation.Field(&q.Google),
+		validation.Field(&q.Outlook),
+	)
+}
+
+// SendQuota represents the number of messages an account may send over rolling windows.
+type SendQuota struct {
+	// the number of messages allowed over the last 24 hours. Zero means unlimited
+	Daily int `yaml:"daily" env:"DAILY"`
+	// the number of messages allowed over the last minute. Zero means unlimited
+	PerMinute int `yaml:"per_minute" env:"PER_MINUTE"`
+}
+
+// Validate validates the send quota.
+func (q SendQuota) Validate() error {
+	return validation.ValidateStruct(&q,
+		validation.Field(&q.Daily, validation.Min(0)),
+		validation.Field(&q.PerMinute, validation.Min(0)),
+	)
+}
+
+// AttachmentsConfig represents the limits of the files attached to messages and where they are stored.
+type AttachmentsConfig struct {
+	// the maximum size of an attachment in bytes. Defaults to 10 MiB
+	MaxSize int `yaml:"max_size" env:"MAX_SIZE"`
+	// the content types attachments may have, where "type/*" or a trailing "*" allows any subtype.
+	// Defaults to common document, image and archive types
+	ContentTypes []string `yaml:"content_types" env:"CONTENT_TYPES"`
+	// the number of hours after which attachments no message refers to are deleted. Defaults to 24
+	TTL int `yaml:"ttl" env:"TTL"`
+	// the storage of the attachments, either "filesystem" or "s3". Defaults to "filesystem"
+	Storage string `yaml:"storage" env:"STORAGE"`
+	// the directory of the filesystem storage. Defaults to "./data/attachments"
+	Path string `yaml:"path" env:"PATH"`
+	// the bucket of the s3 storage
+	S3 *S3Config `yaml:"s3" prefix:"S3_"`
+}
+
+// Validate validates the attachments configuration.
+func (a AttachmentsConfig) Validate() error {
+	return validation.ValidateStruct(&a,
+		validation.Field(&a.MaxSize, validation.Required, validation.Min(1)),
+		validation.Field(&a.ContentTypes, validation.Required),
+		validation.Field(&a.TTL, validation.Required, validation.Min(1)),
+		validation.Field(&a.Storage, validation.In("filesystem", "s3")),
+		validation.Field(&a.Path, validation.When(a.Storage != "s3", validation.Required)),
+		validation.Field(&a.S3, validation.Skip.When(a.Storage != "s3"), validation.Required),
+	)
+}
+
+// S3Config represents a bucket of an S3-compatible service, such as Amazon S3 or MinIO.
+type S3Config struct {
+	// the URL of the service, such as https://s3.us-east-1.amazonaws.com or http://localhost:9000
+	Endpoint  string `yaml:"endpoint" env:"ENDPOINT"`
+	Region    string `yaml:"region" env:"REGION"`
+	Bucket    string `yaml:"bucket" env:"BUCKET"`
+	AccessKey string `yaml:"access_key" env:"ACCESS_KEY,secret"`
+	SecretKey string `yaml:"secret_key" env:"SECRET_KEY,secret"`
+}
+
+// Validate validates the S3 configuration.
+func (s S3Config) Validate() error {
+	return validation.ValidateStruct(&s,
+		validation.Field(&s.Endpoint, validation.Required),
+		validation.Field(&s.Region, validation.Required),
+		validation.Field(&s.Bucket, validation.Required),
+		validation.Field(&s.AccessKey, validation.Required),
+		validation.Field(&s.SecretKey, validation.Required),
+	)
+}
+
+// TrackingConfig represents the privacy settings of open and click tracking.
+type TrackingConfig struct {
+	// how the IP addresses of recipients are kept: "full" as they are, "hash" replaced with a keyed hash
+	// that still tells recipients apart, or "drop" not at all. Defaults to "full"
+	IPAddresses string `yaml:"ip_addresses" env:"IP_ADDRESSES"`
+}
+
+// Validate validates the tracking configuration.
+func (t TrackingConfig) Validate() error {
+	return validation.ValidateStruct(&t,
+		validation.Field(&t.IPAddresses, validation.Required, validation.In("full", "hash", "drop")),
+	)
+}
+
+// DKIMConfig represents how outgoing messages are DKIM-signed.
+type DKIMConfig struct {
+	// the headers signed when present, besides From which always is. Defaults to the headers identifying
+	// the message, its sender and recipients, its subject and the type of its content
+	Headers []string `yaml:"headers" env:"HEADERS"`
+}
+
+// Validate validates the DKIM configuration.
+func (d DKIMConfig) Validate() error {
+	return validation.ValidateStruct(&d,
+		validation.Field(&d.Headers, validation.Required, validation.Each(validation.Required, validation.Match(headerNamePattern))),
+	)
+}
+
 // Validate validates the application configuration.
 func (c Config) Validate() error {
 	return validation.ValidateStruct(&c,
 		validation.Field(&c.DSN, validation.Required),
 		validation.Field(&c.JWTSigningKey, validation.Required),
+		validation.Field(&c.PasswordResetURL, is.URL),
+		validation.Field(&c.TrustedProxies, validation.Each(validation.By(validateProxy))),
+		validation.Field(&c.Mailer),
+		validation.Field(&c.LoginThrottle),
+		validation.Field(&c.PasswordPolicy),
+		validation.Field(&c.RateLimit),
+		validation.Field(&c.SendQuotas),
+		validation.Field(&c.Attachments),
+		validation.Field(&c.Tracking),
+		validation.Field(&c.DKIM),
 	)
 }
 
@@ -59,8 +394,51 @@ func (c Config) Validate() error {
 func Load(file string, logger log.Logger, fs FileSystem) (*Config, error) {
 	// default config
 	c := Config{
-		ServerPort:    defaultServerPort,
-		JWTExpiration: defaultJWTExpirationHours,
+		ServerPort:              defaultServerPort,
+		JWTExpiration:           defaultJWTExpirationHours,
+		AppURL:                  defaultAppURL,
+		VerificationExpiration:  defaultVerificationHours,
+		PasswordResetExpiration: defaultResetMinutes,
+		TOTPIssuer:              defaultTOTPIssuer,
+		LoginThrottle: &LoginThrottleConfig{
+			FreeAttempts:    defaultFreeAttempts,
+			Delay:           defaultDelaySeconds,
+			MaxDelay:        defaultMaxDelaySeconds,
+			MaxFailures:     defaultMaxFailures,
+			IPMaxFailures:   defaultIPMaxFailures,
+			LockoutDuration: defaultLockoutMinutes,
+			FailureWindow:   defaultFailureWindowHours,
+		},
+		PasswordPolicy: &PasswordPolicyConfig{
+			MinLength:    defaultPasswordMinLength,
+			RejectCommon: true,
+		},
+		RateLimit: &RateLimitConfig{
+			Backend: defaultRateLimitBackend,
+			Auth:    &RateLimitRule{Requests: defaultAuthRateLimit, Period: defaultRateLimitSeconds},
+			API:     &RateLimitRule{Requests: defaultAPIRateLimit, Period: defaultRateLimitSeconds},
+		},
+		SendQuotas: &SendQuotasConfig{
+			Google:  &SendQuota{Daily: defaultGoogleDailySends, PerMinute: defaultGoogleMinuteSends},
+			Outlook: &SendQuota{Daily: defaultOutlookDailySends, PerMinute: defaultOutlookMinuteSends},
+		},
+		Attachments: &AttachmentsConfig{
+			MaxSize:      defaultAttachmentMaxSize,
+			ContentTypes: defaultAttachmentContentTypes,
+			TTL:          defaultAttachmentTTLHours,
+			Storage:      defaultAttachmentStorage,
+			Path:         defaultAttachmentPath,
+		},
+		Tracking: &TrackingConfig{
+			IPAddresses: defaultTrackingIPs,
+		},
+		DKIM: &DKIMConfig{
+			Headers: dkim.DefaultHeaders,
+		},
+		Mailer: &MailerConfig{
+			Driver: defaultMailerDriver,
+			Port:   defaultMailerPort,
+		},
 	}
 
 	// load from YAML config file
@@ -31,6 +31,66 @@ func TestConfigValidate(t *testing.T) {
 			cfg:     Config{DSN: "some-dsn"},
 			wantErr: true,
 		},
+		{
+			name:    "invalid login throttle",
+			cfg:     Config{DSN: "some-dsn", JWTSigningKey: "some-key", LoginThrottle: &LoginThrottleConfig{FreeAttempts: 5, MaxFailures: 3, IPMaxFailures: 10, LockoutDuration: 1, FailureWindow: 1}},
+			wantErr: true,
+		},
+		{
+			name:    "invalid password policy",
+			cfg:     Config{DSN: "some-dsn", JWTSigningKey: "some-key", PasswordPolicy: &PasswordPolicyConfig{MinLength: 100}},
+			wantErr: true,
+		},
+		{
+			name:    "invalid rate limit backend",
+			cfg:     Config{DSN: "some-dsn", JWTSigningKey: "some-key", RateLimit: &RateLimitConfig{Backend: "redis"}},
+			wantErr: true,
+		},
+		{
+			name:    "rate limit without period",
+			cfg:     Config{DSN: "some-dsn", JWTSigningKey: "some-key", RateLimit: &RateLimitConfig{API: &RateLimitRule{Requests: 10}}},
+			wantErr: true,
+		},
+		{
+			name:    "negative send quota",
+			cfg:     Config{DSN: "some-dsn", JWTSigningKey: "some-key", SendQuotas: &SendQuotasConfig{Google: &SendQuota{Daily: -1}}},
+			wantErr: true,
+		},
+		{
+			name:    "invalid attachments storage",
+			cfg:     Config{DSN: "some-dsn", JWTSigningKey: "some-key", Attachments: &AttachmentsConfig{MaxSize: 1, ContentTypes: []string{"*"}, TTL: 1, Storage: "ftp"}},
+			wantErr: true,
+		},
+		{
+			name:    "s3 attachments storage without bucket",
+			cfg:     Config{DSN: "some-dsn", JWTSigningKey: "some-key", Attachments: &AttachmentsConfig{MaxSize: 1, ContentTypes: []string{"*"}, TTL: 1, Storage: "s3", S3: &S3Config{Endpoint: "http://localhost:9000"}}},
+			wantErr: true,
+		},
+		{
+			name:    "invalid tracking IP addresses",
+			cfg:     Config{DSN: "some-dsn", JWTSigningKey: "some-key", Tracking: &TrackingConfig{IPAddresses: "mask"}},
+			wantErr: true,
+		},
+		{
+			name:    "invalid DKIM signed header",
+			cfg:     Config{DSN: "some-dsn", JWTSigningKey: "some-key", DKIM: &DKIMConfig{Headers: []string{"From", "Sub ject"}}},
+			wantErr: true,
+		},
+		{
+			name:    "invalid system DKIM key",
+			cfg:     Config{DSN: "some-dsn", JWTSigningKey: "some-key", Mailer: &MailerConfig{Driver: "smtp", Host: "localhost", From: "a@example.com", DKIMDomain: "example.com", DKIMSelector: "s1", DKIMPrivateKey: "not a key"}},
+			wantErr: true,
+		},
+		{
+			name:    "trusted proxies",
+			cfg:     Config{DSN: "some-dsn", JWTSigningKey: "some-key", TrustedProxies: []string{"10.0.0.0/8", "127.0.0.1"}},
+			wantErr: false,
+		},
+		{
+			name:    "invalid trusted proxy",
+			cfg:     Config{DSN: "some-dsn", JWTSigningKey: "some-key", TrustedProxies: []string{"proxy.internal"}},
+			wantErr: true,
+		},
 		{
 			name:    "both fields missing",
 			cfg:     Config{},
@@ -73,6 +133,8 @@ func TestLoad(t *testing.T) {
 		require.NoError(t, err)
 		assert.Equal(t, 8080, cfg.ServerPort)
 		assert.Equal(t, 24, cfg.JWTExpiration)
+		assert.Equal(t, defaultMaxFailures, cfg.LoginThrottle.MaxFailures)
+		assert.True(t, cfg.PasswordPolicy.RejectCommon)
 	})
 
 	t.Run("invalid config file", func(t *testing.T) {
@@ -0,0 +1,202 @@
+package contact
+
+import (
+	"net/http"
+
+	"github.com/garaekz/gonvelope/internal/auth"
+	"github.com/garaekz/gonvelope/internal/entity"
+	"github.com/garaekz/gonvelope/internal/errors"
+	"github.com/garaekz/gonvelope/pkg/log"
+	"github.com/garaekz/gonvelope/pkg/pagination"
+	routing "github.com/garaekz/ozzo-routing"
+)
+
+// RegisterHandlers sets up the routing of the HTTP handlers.
+// Contacts and lists belong to the organization made active by orgHandler.
+func RegisterHandlers(r *routing.RouteGroup, service Service, authHandler, orgHandler routing.Handler, logger log.Logger) {
+	res := resource{service, logger}
+
+	r.Use(authHandler, orgHandler)
+
+	r.Get("contacts", auth.Require(auth.ScopeContactsRead), res.query)
+	r.Get("contacts/<id>", auth.Require(auth.ScopeContactsRead), res.get)
+	r.Post("contacts", auth.Require(auth.ScopeContactsWrite), res.create)
+	r.Put("contacts/<id>", auth.Require(auth.ScopeContactsWrite), res.update)
+	r.Delete("contacts/<id>", auth.Require(auth.ScopeContactsWrite), res.delete)
+
+	r.Get("lists", auth.Require(auth.ScopeContactsRead), res.queryLists)
+	r.Get("lists/<id>", auth.Require(auth.ScopeContactsRead), res.getList)
+	r.Post("lists", auth.Require(auth.ScopeContactsWrite), res.createList)
+	r.Put("lists/<id>", auth.Require(auth.ScopeContactsWrite), res.updateList)
+	r.Delete("lists/<id>", auth.Require(auth.ScopeContactsWrite), res.deleteList)
+	r.Get("lists/<id>/contacts", auth.Require(auth.ScopeContactsRead), res.queryMembers)
+	r.Post("lists/<id>/contacts", auth.Require(auth.ScopeContactsWrite), res.addMembers)
+	r.Delete("lists/<id>/contacts/<contactID>", auth.Require(auth.ScopeContactsWrite), res.removeMember)
+}
+
+type resource struct {
+	service Service
+	logger  log.Logger
+}
+
+func (r resource) get(c *routing.Context) error {
+	contact, err := r.service.Get(c.Request.Context(), currentMembership(c).OrgID, c.Param("id"))
+	if err != nil {
+		return err
+	}
+	return c.Write(contact)
+}
+
+// query lists the contacts containing the search term and matching the filter given in the query string.
+func (r resource) query(c *routing.Context) error {
+	return r.writeContacts(c, ContactQuery{Search: c.Query("search"), Filter: c.Query("filter"), ListID: c.Query("list_id")})
+}
+
+// queryMembers lists the members of the list containing the search term and matching the filter.
+func (r resource) queryMembers(c *routing.Context) error {
+	return r.writeContacts(c, ContactQuery{Search: c.Query("search"), Filter: c.Query("filter"), ListID: c.Param("id")})
+}
+
+// writeContacts writes the page of contacts selected by the query.
+func (r resource) writeContacts(c *routing.Context, query ContactQuery) error {
+	ctx := c.Request.Context()
+	orgID := currentMembership(c).OrgID
+	count, err := r.service.Count(ctx, orgID, query)
+	if err != nil {
+		return err
+	}
+	pages := pagination.NewFromRequest(c.Request, count)
+	contacts, err := r.service.Query(ctx, orgID, query, pages.Offset(), pages.Limit())
+	if err != nil {
+		return err
+	}
+	pages.Items = contacts
+	return c.Write(pages)
+}
+
+func (r resource) create(c *routing.Context) error {
+	var input ContactRequest
+	if err := c.Read(&input); err != nil {
+		r.logger.With(c.Request.Context()).Info(err)
+		return errors.BadRequest("")
+	}
+	membership := currentMembership(c)
+	contact, err := r.service.Create(c.Request.Context(), membership.OrgID, membership.UserID, input)
+	if err != nil {
+		return err
+	}
+	return c.WriteWithStatus(contact, http.StatusCreated)
+}
+
+func (r resource) update(c *routing.Context) error {
+	var input ContactRequest
+	if err := c.Read(&input); err != nil {
+		r.logger.With(c.Request.Context()).Info(err)
+		return errors.BadRequest("")
+	}
+	membership := currentMembership(c)
+	contact, err := r.service.Update(c.Request.Context(), membership.OrgID, membership.UserID, c.Param("id"), input)
+	if err != nil {
+		return err
+	}
+	return c.Write(contact)
+}
+
+func (r resource) delete(c *routing.Context) error {
+	membership := currentMembership(c)
+	contact, err := r.service.Delete(c.Request.Context(), membership.OrgID, membership.UserID, c.Param("id"))
+	if err != nil {
+		return err
+	}
+	return c.Write(contact)
+}
+
+func (r resource) getList(c *routing.Context) error {
+	list, err := r.service.GetList(c.Request.Context(), currentMembership(c).OrgID, c.Param("id"))
+	if err != nil {
+		return err
+	}
+	return c.Write(list)
+}
+
+func (r resource) queryLists(c *routing.Context) error {
+	ctx := c.Request.Context()
+	orgID := currentMembership(c).OrgID
+	count, err := r.service.CountLists(ctx, orgID)
+	if err != nil {
+		return err
+	}
+	pages := pagination.NewFromRequest(c.Request, count)
+	lists, err := r.service.QueryLists(ctx, orgID, pages.Offset(), pages.Limit())
+	if err != nil {
+		return err
+	}
+	pages.Items = lists
+	return c.Write(pages)
+}
+
+func (r resource) createList(c *routing.Context) error {
+	var input ListRequest
+	if err := c.Read(&input); err != nil {
+		r.logger.With(c.Request.Context()).Info(err)
+		return errors.BadRequest("")
+	}
+	membership := currentMembership(c)
+	list, err := r.service.CreateList(c.Request.Context(), membership.OrgID, membership.UserID, input)
+	if err != nil {
+		return err
+	}
+	return c.WriteWithStatus(list, http.StatusCreated)
+}
+
+func (r resource) updateList(c *routing.Context) error {
+	var input ListRequest
+	if err := c.Read(&input); err != nil {
+		r.logger.With(c.Request.Context()).Info(err)
+		return errors.BadRequest("")
+	}
+	membership := currentMembership(c)
+	list, err := r.service.UpdateList(c.Request.Context(), membership.OrgID, membership.UserID, c.Param("id"), input)
+	if err != nil {
+		return err
+	}
+	return c.Write(list)
+}
+
+func (r resource) deleteList(c *routing.Context) error {
+	membership := currentMembership(c)
+	list, err := r.service.DeleteList(c.Request.Context(), membership.OrgID, membership.UserID, c.Param("id"))
+	if err != nil {
+		return err
+	}
+	return c.Write(list)
+}
+
+func (r resource) addMembers(c *routing.Context) error {
+	var input MembersRequest
+	if err := c.Read(&input); err != nil {
+		r.logger.With(c.Request.Context()).Info(err)
+		return errors.BadRequest("")
+	}
+	membership := currentMembership(c)
+	added, err := r.service.AddMembers(c.Request.Context(), membership.OrgID, membership.UserID, c.Param("id"), input)
+	if err != nil {
+		return err
+	}
+	return c.Write(map[string]int{"added": added})
+}
+
+func (r resource) removeMember(c *routing.Context) error {
+	membership := currentMembership(c)
+	contact, err := r.service.RemoveMember(c.Request.Context(), membership.OrgID, membership.UserID, c.Param("id"), c.Param("contactID"))
+	if err != nil {
+		return err
+	}
+	return c.Write(contact)
+}
+
+// currentMembership returns the membership of the current user in the active organization.
+func currentMembership(c *routing.Context) entity.Membership {
+	membership, _ := auth.CurrentMembership(c.Request.Context())
+	return membership
+}
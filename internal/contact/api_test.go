@@ -0,0 +1,61 @@
+package contact
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/garaekz/gonvelope/internal/auth"
+	"github.com/garaekz/gonvelope/internal/entity"
+	"github.com/garaekz/gonvelope/internal/org"
+	"github.com/garaekz/gonvelope/internal/test"
+	"github.com/garaekz/gonvelope/pkg/log"
+)
+
+func TestAPI(t *testing.T) {
+	logger, _ := log.NewForTest()
+	router := test.MockRouter(logger)
+	repo := &mockRepository{
+		contacts: []entity.Contact{
+			{ID: "c1", OrgID: "100", Email: "jane@example.com", Name: "Jane", Attributes: entity.JSON(`{"plan":"pro"}`)},
+			{ID: "c2", OrgID: "org1", Email: "other@example.com", Attributes: entity.JSON(`{}`)},
+		},
+		lists: []entity.ContactList{{ID: "l1", OrgID: "100", Name: "Customers"}},
+	}
+	RegisterHandlers(router.Group("/"), NewService(repo, mockTransactional, &mockRecorder{}, logger), auth.MockAuthHandler, org.MockHandler, logger)
+	header := auth.MockAuthHeader()
+
+	tests := []test.APITestCase{
+		{Name: "get all", Method: "GET", URL: "/contacts", Header: header, WantStatus: http.StatusOK, WantResponse: `*"total_count":1*`},
+		{Name: "search", Method: "GET", URL: "/contacts?search=john", Header: header, WantStatus: http.StatusOK, WantResponse: `*"total_count":0*`},
+		{Name: "filter", Method: "GET", URL: `/contacts?filter=plan+%3D+%22pro%22`, Header: header, WantStatus: http.StatusOK},
+		{Name: "invalid filter", Method: "GET", URL: `/contacts?filter=plan+%3D`, Header: header, WantStatus: http.StatusBadRequest, WantResponse: `*"field":"filter"*`},
+		{Name: "get", Method: "GET", URL: "/contacts/c1", Header: header, WantStatus: http.StatusOK, WantResponse: `*"attributes":{"plan":"pro"}*`},
+		{Name: "get other org", Method: "GET", URL: "/contacts/c2", Header: header, WantStatus: http.StatusNotFound},
+		{Name: "create", Method: "POST", URL: "/contacts", Body: `{"email":"John@example.com","attributes":{"seats":3}}`, Header: header, WantStatus: http.StatusCreated, WantResponse: `*"email":"john@example.com"*`},
+		{Name: "create duplicate", Method: "POST", URL: "/contacts", Body: `{"email":"jane@example.com"}`, Header: header, WantStatus: http.StatusConflict},
+		{Name: "create invalid", Method: "POST", URL: "/contacts", Body: `{"email":"jane"}`, Header: header, WantStatus: http.StatusBadRequest},
+		{Name: "create input error", Method: "POST", URL: "/contacts", Body: `"email"}`, Header: header, WantStatus: http.StatusBadRequest},
+		{Name: "update", Method: "PUT", URL: "/contacts/c1", Body: `{"email":"jane@example.com","name":"Jane Doe"}`, Header: header, WantStatus: http.StatusOK, WantResponse: `*"name":"Jane Doe"*`},
+		{Name: "list members empty", Method: "GET", URL: "/lists/l1/contacts", Header: header, WantStatus: http.StatusOK, WantResponse: `*"total_count":0*`},
+		{Name: "add members", Method: "POST", URL: "/lists/l1/contacts", Body: `{"contact_ids":["c1","c2"]}`, Header: header, WantStatus: http.StatusOK, WantResponse: `{"added":1}`},
+		{Name: "list members", Method: "GET", URL: "/lists/l1/contacts", Header: header, WantStatus: http.StatusOK, WantResponse: `*"total_count":1*`},
+		{Name: "contacts of list", Method: "GET", URL: "/contacts?list_id=l1", Header: header, WantStatus: http.StatusOK, WantResponse: `*"total_count":1*`},
+		{Name: "remove member", Method: "DELETE", URL: "/lists/l1/contacts/c1", Header: header, WantStatus: http.StatusOK, WantResponse: `*"id":"c1"*`},
+		{Name: "remove non member", Method: "DELETE", URL: "/lists/l1/contacts/c1", Header: header, WantStatus: http.StatusNotFound},
+		{Name: "get lists", Method: "GET", URL: "/lists", Header: header, WantStatus: http.StatusOK, WantResponse: `*"total_count":1*`},
+		{Name: "get list", Method: "GET", URL: "/lists/l1", Header: header, WantStatus: http.StatusOK, WantResponse: `*"name":"Customers"*`},
+		{Name: "create list", Method: "POST", URL: "/lists", Body: `{"name":"Trials"}`, Header: header, WantStatus: http.StatusCreated, WantResponse: `*"name":"Trials"*`},
+		{Name: "create list invalid", Method: "POST", URL: "/lists", Body: `{"name":""}`, Header: header, WantStatus: http.StatusBadRequest},
+		{Name: "update list", Method: "PUT", URL: "/lists/l1", Body: `{"name":"Buyers"}`, Header: header, WantStatus: http.StatusOK, WantResponse: `*"name":"Buyers"*`},
+		{Name: "delete list", Method: "DELETE", URL: "/lists/l1", Header: header, WantStatus: http.StatusOK},
+		{Name: "delete list verify", Method: "GET", URL: "/lists/l1", Header: header, WantStatus: http.StatusNotFound},
+		{Name: "delete", Method: "DELETE", URL: "/contacts/c1", Header: header, WantStatus: http.StatusOK},
+		{Name: "delete verify", Method: "GET", URL: "/contacts/c1", Header: header, WantStatus: http.StatusNotFound},
+		{Name: "viewer read", Method: "GET", URL: "/contacts", Header: org.MockHeader(entity.RoleViewer), WantStatus: http.StatusOK},
+		{Name: "sender write", Method: "POST", URL: "/contacts", Body: `{"email":"ann@example.com"}`, Header: org.MockHeader(entity.RoleSender), WantStatus: http.StatusForbidden},
+		{Name: "unauthorized", Method: "GET", URL: "/contacts", WantStatus: http.StatusUnauthorized},
+	}
+	for _, tc := range tests {
+		test.Endpoint(t, router, tc)
+	}
+}
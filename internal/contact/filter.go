@@ -0,0 +1,411 @@
+package contact
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	dbx "github.com/go-ozzo/ozzo-dbx"
+	"github.com/lib/pq"
+)
+
+// Filters select contacts with a small expression language, such as
+//
+//	plan = "pro" and (seats >= 10 or company.country in ["MX", "ES"]) and not name contains "test"
+//
+// The email, name and locale fields refer to the contact itself, any other field to its custom attributes,
+// with dots addressing nested attributes. Values are double-quoted strings, numbers, true, false and null.
+// Comparisons with attributes a contact lacks, or whose value has another type, do not match.
+// Filters are compiled to ozzo-dbx expressions with every value bound as a parameter.
+
+const (
+	// maxFilterLength is the maximum length of a filter.
+	maxFilterLength = 1000
+	// maxFilterDepth is the maximum nesting of parentheses and negations of a filter.
+	maxFilterDepth = 16
+	// maxFilterValues is the maximum number of values of an in list.
+	maxFilterValues = 100
+)
+
+// columnFields are the fields of a filter that refer to the columns of the contacts table.
+var columnFields = map[string]bool{"email": true, "name": true, "locale": true}
+
+// FilterError describes why a filter is invalid.
+type FilterError struct {
+	// Pos is the byte offset in the filter where the error was found.
+	Pos     int
+	Message string
+}
+
+// Error implements the error interface.
+func (e *FilterError) Error() string {
+	return fmt.Sprintf("%s at position %d", e.Message, e.Pos+1)
+}
+
+// parseFilter compiles the filter into an ozzo-dbx expression selecting the matching contacts.
+func parseFilter(filter string) (dbx.Expression, error) {
+	if len(filter) > maxFilterLength {
+		return nil, &FilterError{maxFilterLength, fmt.Sprintf("filter is longer than %d characters", maxFilterLength)}
+	}
+	tokens, err := tokenize(filter)
+	if err != nil {
+		return nil, err
+	}
+	p := &filterParser{tokens: tokens}
+	exp, err := p.parseOr(0)
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokenEOF {
+		return nil, &FilterError{t.pos, fmt.Sprintf("unexpected %q", t.text)}
+	}
+	return exp, nil
+}
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenString
+	tokenNumber
+	tokenOperator
+	tokenPunct
+)
+
+type token struct {
+	kind tokenKind
+	// text is the token as written, or the unquoted value of a string.
+	text string
+	pos  int
+}
+
+// operators lists the comparison operators, longest first.
+var operators = []string{"<=", ">=", "!=", "=", "<", ">"}
+
+// tokenize splits the filter into tokens.
+func tokenize(filter string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(filter); {
+		c := filter[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '(' || c == ')' || c == '[' || c == ']' || c == ',':
+			tokens = append(tokens, token{tokenPunct, string(c), i})
+			i++
+		case c == '"':
+			end := i + 1
+			for end < len(filter) && filter[end] != '"' {
+				if filter[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(filter) {
+				return nil, &FilterError{i, "unterminated string"}
+			}
+			value, err := strconv.Unquote(filter[i : end+1])
+			if err != nil {
+				return nil, &FilterError{i, "invalid string"}
+			}
+			tokens = append(tokens, token{tokenString, value, i})
+			i = end + 1
+		case c == '-' || isDigit(c):
+			end := i + 1
+			for end < len(filter) && (isDigit(filter[end]) || filter[end] == '.') {
+				end++
+			}
+			if _, err := strconv.ParseFloat(filter[i:end], 64); err != nil {
+				return nil, &FilterError{i, fmt.Sprintf("invalid number %q", filter[i:end])}
+			}
+			tokens = append(tokens, token{tokenNumber, filter[i:end], i})
+			i = end
+		case isLetter(c):
+			end := i + 1
+			for end < len(filter) && (isLetter(filter[end]) || isDigit(filter[end]) || filter[end] == '.') {
+				end++
+			}
+			tokens = append(tokens, token{tokenIdent, filter[i:end], i})
+			i = end
+		default:
+			op := ""
+			for _, candidate := range operators {
+				if strings.HasPrefix(filter[i:], candidate) {
+					op = candidate
+					break
+				}
+			}
+			if op == "" {
+				return nil, &FilterError{i, fmt.Sprintf("unexpected character %q", c)}
+			}
+			tokens = append(tokens, token{tokenOperator, op, i})
+			i += len(op)
+		}
+	}
+	return append(tokens, token{tokenEOF, "end of filter", len(filter)}), nil
+}
+
+// filterParser is a recursive descent parser of filters.
+type filterParser struct {
+	tokens []token
+	pos    int
+	// params counts the parameters bound so far, to name them uniquely.
+	params int
+}
+
+func (p *filterParser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *filterParser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+// keyword consumes the next token if it is the given keyword.
+func (p *filterParser) keyword(word string) bool {
+	if t := p.peek(); t.kind == tokenIdent && strings.EqualFold(t.text, word) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+// punct consumes the next token if it is the given punctuation.
+func (p *filterParser) punct(text string) bool {
+	if t := p.peek(); t.kind == tokenPunct && t.text == text {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+// parseOr parses: and ("or" and)*
+func (p *filterParser) parseOr(depth int) (dbx.Expression, error) {
+	exps, err := p.parseList(depth, "or", p.parseAnd)
+	if err != nil || len(exps) == 1 {
+		return first(exps), err
+	}
+	return dbx.Or(exps...), nil
+}
+
+// parseAnd parses: unary ("and" unary)*
+func (p *filterParser) parseAnd(depth int) (dbx.Expression, error) {
+	exps, err := p.parseList(depth, "and", p.parseUnary)
+	if err != nil || len(exps) == 1 {
+		return first(exps), err
+	}
+	return dbx.And(exps...), nil
+}
+
+// parseList parses operands separated by the keyword.
+func (p *filterParser) parseList(depth int, word string, operand func(int) (dbx.Expression, error)) ([]dbx.Expression, error) {
+	var exps []dbx.Expression
+	for {
+		exp, err := operand(depth)
+		if err != nil {
+			return nil, err
+		}
+		exps = append(exps, exp)
+		if !p.keyword(word) {
+			return exps, nil
+		}
+	}
+}
+
+// parseUnary parses: "not" unary | "(" or ")" | comparison
+func (p *filterParser) parseUnary(depth int) (dbx.Expression, error) {
+	if depth > maxFilterDepth {
+		return nil, &FilterError{p.peek().pos, "filter is nested too deeply"}
+	}
+	if p.keyword("not") {
+		exp, err := p.parseUnary(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		return dbx.Not(exp), nil
+	}
+	if p.punct("(") {
+		exp, err := p.parseOr(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		if !p.punct(")") {
+			t := p.peek()
+			return nil, &FilterError{t.pos, fmt.Sprintf("expected \")\" instead of %q", t.text)}
+		}
+		return exp, nil
+	}
+	return p.parseComparison()
+}
+
+// parseComparison parses: field operator value | field "contains" string | field "in" "[" value ("," value)* "]"
+func (p *filterParser) parseComparison() (dbx.Expression, error) {
+	field := p.next()
+	if field.kind != tokenIdent || isKeyword(field.text) || strings.HasPrefix(field.text, ".") ||
+		strings.HasSuffix(field.text, ".") || strings.Contains(field.text, "..") {
+		return nil, &FilterError{field.pos, fmt.Sprintf("expected a field instead of %q", field.text)}
+	}
+
+	op := p.next()
+	switch {
+	case op.kind == tokenOperator:
+		value, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		return p.compare(field, op.text, value)
+	case op.kind == tokenIdent && strings.EqualFold(op.text, "contains"):
+		value, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		if value.kind != tokenString {
+			return nil, &FilterError{value.pos, "contains expects a string"}
+		}
+		return p.contains(field, value.text), nil
+	case op.kind == tokenIdent && strings.EqualFold(op.text, "in"):
+		if !p.punct("[") {
+			return nil, &FilterError{p.peek().pos, "in expects a list of values such as [\"a\", \"b\"]"}
+		}
+		var exps []dbx.Expression
+		for {
+			value, err := p.parseValue()
+			if err != nil {
+				return nil, err
+			}
+			exp, err := p.compare(field, "=", value)
+			if err != nil {
+				return nil, err
+			}
+			if exps = append(exps, exp); len(exps) > maxFilterValues {
+				return nil, &FilterError{value.pos, fmt.Sprintf("in lists at most %d values", maxFilterValues)}
+			}
+			if p.punct("]") {
+				return dbx.Or(exps...), nil
+			}
+			if !p.punct(",") {
+				t := p.peek()
+				return nil, &FilterError{t.pos, fmt.Sprintf("expected \",\" or \"]\" instead of %q", t.text)}
+			}
+		}
+	}
+	return nil, &FilterError{op.pos, fmt.Sprintf("expected an operator instead of %q", op.text)}
+}
+
+// parseValue parses a string, a number, true, false or null.
+func (p *filterParser) parseValue() (token, error) {
+	t := p.next()
+	switch {
+	case t.kind == tokenString || t.kind == tokenNumber:
+		return t, nil
+	case t.kind == tokenIdent && (strings.EqualFold(t.text, "true") || strings.EqualFold(t.text, "false") || strings.EqualFold(t.text, "null")):
+		t.text = strings.ToLower(t.text)
+		return t, nil
+	}
+	return t, &FilterError{t.pos, fmt.Sprintf("expected a value instead of %q", t.text)}
+}
+
+// compare compiles the comparison of the field with the value.
+func (p *filterParser) compare(field token, op string, value token) (dbx.Expression, error) {
+	sqlOp := op
+	if op == "!=" {
+		sqlOp = "<>"
+	}
+	ordered := op != "=" && op != "!="
+
+	if columnFields[field.text] {
+		if value.kind != tokenString {
+			return nil, &FilterError{value.pos, fmt.Sprintf("%s can only be compared with a string", field.text)}
+		}
+		v := value.text
+		if field.text == "email" {
+			v = strings.ToLower(v)
+		}
+		name := p.param()
+		return dbx.NewExp(fmt.Sprintf("%s %s {:%s}", field.text, sqlOp, name), dbx.Params{name: v}), nil
+	}
+
+	path := p.param()
+	params := dbx.Params{path: pq.StringArray(strings.Split(field.text, "."))}
+	switch value.kind {
+	case tokenString:
+		name := p.param()
+		params[name] = value.text
+		return dbx.NewExp(fmt.Sprintf("attributes #>> {:%s}::text[] %s {:%s}", path, sqlOp, name), params), nil
+	case tokenNumber:
+		name := p.param()
+		params[name] = value.text
+		// the attribute is only cast when it holds a number, as a failing cast would fail the whole query
+		return dbx.NewExp(fmt.Sprintf(
+			"(CASE WHEN jsonb_typeof(attributes #> {:%[1]s}::text[]) = 'number' THEN (attributes #>> {:%[1]s}::text[])::numeric END) %[2]s {:%[3]s}::numeric",
+			path, sqlOp, name), params), nil
+	}
+	if ordered {
+		return nil, &FilterError{value.pos, fmt.Sprintf("%s can only be compared with = or !=", value.text)}
+	}
+	if value.text == "null" {
+		not := ""
+		if op == "!=" {
+			not = "NOT "
+		}
+		// #>> returns SQL NULL for both missing attributes and JSON nulls
+		return dbx.NewExp(fmt.Sprintf("attributes #>> {:%s}::text[] IS %sNULL", path, not), params), nil
+	}
+	// true and false are the only remaining values
+	return dbx.NewExp(fmt.Sprintf("attributes #> {:%s}::text[] %s '%s'::jsonb", path, sqlOp, value.text), params), nil
+}
+
+// contains compiles a case-insensitive substring match of the field.
+func (p *filterParser) contains(field token, value string) dbx.Expression {
+	name := p.param()
+	params := dbx.Params{name: "%" + escapeLike(value) + "%"}
+	if columnFields[field.text] {
+		return dbx.NewExp(fmt.Sprintf("%s ILIKE {:%s}", field.text, name), params)
+	}
+	path := p.param()
+	params[path] = pq.StringArray(strings.Split(field.text, "."))
+	return dbx.NewExp(fmt.Sprintf("attributes #>> {:%s}::text[] ILIKE {:%s}", path, name), params)
+}
+
+// param returns a new unique parameter name.
+func (p *filterParser) param() string {
+	p.params++
+	return fmt.Sprintf("f%d", p.params)
+}
+
+// isKeyword returns whether the word is reserved by the filter language.
+func isKeyword(word string) bool {
+	switch strings.ToLower(word) {
+	case "and", "or", "not", "in", "contains", "true", "false", "null":
+		return true
+	}
+	return false
+}
+
+// isLetter returns whether the byte is an ASCII letter or an underscore.
+func isLetter(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// isDigit returns whether the byte is an ASCII digit.
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// escapeLike escapes the wildcards of a LIKE pattern.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// first returns the first expression of the list, if any.
+func first(exps []dbx.Expression) dbx.Expression {
+	if len(exps) == 0 {
+		return nil
+	}
+	return exps[0]
+}
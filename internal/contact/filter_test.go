@@ -0,0 +1,65 @@
+package contact
+
+import (
+	"strings"
+	"testing"
+
+	dbx "github.com/go-ozzo/ozzo-dbx"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_parseFilter(t *testing.T) {
+	tests := []struct {
+		name       string
+		filter     string
+		wantSQL    string
+		wantParams dbx.Params
+	}{
+		{"string attribute", `plan = "pro"`, `attributes #>> {:f1}::text[] = {:f2}`,
+			dbx.Params{"f1": pq.StringArray{"plan"}, "f2": "pro"}},
+		{"column", `name != "Jane"`, `name <> {:f1}`, dbx.Params{"f1": "Jane"}},
+		{"locale", `locale = "es-MX"`, `locale = {:f1}`, dbx.Params{"f1": "es-MX"}},
+		{"contains", `email contains "50%"`, `email ILIKE {:f1}`, dbx.Params{"f1": `%50\%%`}},
+		{"number and boolean", `seats >= 10 and not active = true`,
+			`((CASE WHEN jsonb_typeof(attributes #> {:f1}::text[]) = 'number' THEN (attributes #>> {:f1}::text[])::numeric END) >= {:f2}::numeric) AND (NOT (attributes #> {:f3}::text[] = 'true'::jsonb))`,
+			dbx.Params{"f1": pq.StringArray{"seats"}, "f2": "10", "f3": pq.StringArray{"active"}}},
+		{"nested in list and null", `company.country in ["MX", "ES"] or x = null`,
+			`((attributes #>> {:f1}::text[] = {:f2}) OR (attributes #>> {:f3}::text[] = {:f4})) OR (attributes #>> {:f5}::text[] IS NULL)`,
+			dbx.Params{"f1": pq.StringArray{"company", "country"}, "f2": "MX", "f3": pq.StringArray{"company", "country"}, "f4": "ES", "f5": pq.StringArray{"x"}}},
+	}
+	db := dbx.NewFromDB(nil, "postgres")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exp, err := parseFilter(tt.filter)
+			if assert.Nil(t, err) {
+				params := dbx.Params{}
+				assert.Equal(t, tt.wantSQL, exp.Build(db, params))
+				assert.Equal(t, tt.wantParams, params)
+			}
+		})
+	}
+}
+
+func Test_parseFilter_errors(t *testing.T) {
+	tests := []struct {
+		filter  string
+		wantErr string
+	}{
+		{`(`, `expected a field instead of "end of filter" at position 2`},
+		{`plan = `, `expected a value instead of "end of filter"`},
+		{`plan == "x"`, `expected a value instead of "="`},
+		{`a in []`, `expected a value instead of "]"`},
+		{`"x" = plan`, `expected a field instead of "x"`},
+		{strings.Repeat("not ", maxFilterDepth+1) + `a = 1`, "nested"},
+		{`a = "` + strings.Repeat("x", maxFilterLength) + `"`, "longer than"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.filter, func(t *testing.T) {
+			_, err := parseFilter(tt.filter)
+			if assert.NotNil(t, err) {
+				assert.Contains(t, err.Error(), tt.wantErr)
+			}
+		})
+	}
+}
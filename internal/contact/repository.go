@@ -0,0 +1,215 @@
+package contact
+
+import (
+	"context"
+	"time"
+
+	"github.com/garaekz/gonvelope/internal/entity"
+	"github.com/garaekz/gonvelope/pkg/dbcontext"
+	"github.com/garaekz/gonvelope/pkg/log"
+	dbx "github.com/go-ozzo/ozzo-dbx"
+	"github.com/lib/pq"
+)
+
+// Criteria selects the contacts of an organization.
+type Criteria struct {
+	// Search selects the contacts whose email or name contains the term.
+	Search string
+	// Filter is a compiled filter expression.
+	Filter dbx.Expression
+	// ListID selects the members of the list.
+	ListID string
+	// After selects the contacts whose email sorts after it, to page through contacts in the order of their addresses.
+	After string
+}
+
+// Repository encapsulates the logic to access contacts and lists from the data source.
+type Repository interface {
+	// Get returns the contact with the specified ID owned by the organization.
+	Get(ctx context.Context, orgID, id string) (entity.Contact, error)
+	// GetByEmail returns the contact of the organization with the specified email.
+	GetByEmail(ctx context.Context, orgID, email string) (entity.Contact, error)
+	// Count returns the number of contacts of the organization matching the criteria.
+	Count(ctx context.Context, orgID string, criteria Criteria) (int, error)
+	// Query returns the contacts of the organization matching the criteria with the given offset and limit,
+	// sorted by email.
+	Query(ctx context.Context, orgID string, criteria Criteria, offset, limit int) ([]entity.Contact, error)
+	// Create saves a new contact in the storage.
+	Create(ctx context.Context, contact entity.Contact) error
+	// Update saves the changes to a contact in the storage.
+	Update(ctx context.Context, contact entity.Contact) error
+	// Delete removes the contact with the specified ID owned by the organization.
+	Delete(ctx context.Context, orgID, id string) error
+
+	// GetList returns the list with the specified ID owned by the organization.
+	GetList(ctx context.Context, orgID, id string) (entity.ContactList, error)
+	// CountLists returns the number of lists of the organization.
+	CountLists(ctx context.Context, orgID string) (int, error)
+	// QueryLists returns the lists of the organization with the given offset and limit, sorted by name.
+	QueryLists(ctx context.Context, orgID string, offset, limit int) ([]entity.ContactList, error)
+	// CreateList saves a new list in the storage.
+	CreateList(ctx context.Context, list entity.ContactList) error
+	// UpdateList saves the changes to a list in the storage.
+	UpdateList(ctx context.Context, list entity.ContactList) error
+	// DeleteList removes the list with the specified ID owned by the organization.
+	DeleteList(ctx context.Context, orgID, id string) error
+	// AddMembers adds the contacts of the organization with the given IDs to the list,
+	// and returns the number of contacts that were not members yet.
+	AddMembers(ctx context.Context, orgID, listID string, contactIDs []string, now time.Time) (int, error)
+	// RemoveMember removes the contact from the list, and returns whether it was a member.
+	RemoveMember(ctx context.Context, listID, contactID string) (bool, error)
+}
+
+// repository persists contacts and lists in database
+type repository struct {
+	db     *dbcontext.DB
+	logger log.Logger
+}
+
+// NewRepository creates a new contact repository
+func NewRepository(db *dbcontext.DB, logger log.Logger) Repository {
+	return repository{db, logger}
+}
+
+// Get returns the contact with the specified ID owned by the organization.
+func (r repository) Get(ctx context.Context, orgID, id string) (entity.Contact, error) {
+	var contact entity.Contact
+	err := r.db.With(ctx).Select().From(contact.TableName()).
+		Where(dbx.HashExp{"id": id, "org_id": orgID}).One(&contact)
+	return contact, err
+}
+
+// GetByEmail returns the contact of the organization with the specified email.
+func (r repository) GetByEmail(ctx context.Context, orgID, email string) (entity.Contact, error) {
+	var contact entity.Contact
+	err := r.db.With(ctx).Select().From(contact.TableName()).
+		Where(dbx.HashExp{"org_id": orgID, "email": email}).One(&contact)
+	return contact, err
+}
+
+// Count returns the number of contacts of the organization matching the criteria.
+func (r repository) Count(ctx context.Context, orgID string, criteria Criteria) (int, error) {
+	var count int
+	err := r.db.With(ctx).Select("COUNT(*)").From(entity.Contact{}.TableName()).
+		Where(criteriaExp(orgID, criteria)).Row(&count)
+	return count, err
+}
+
+// Query returns the contacts of the organization matching the criteria with the given offset and limit,
+// sorted by email.
+func (r repository) Query(ctx context.Context, orgID string, criteria Criteria, offset, limit int) ([]entity.Contact, error) {
+	contacts := []entity.Contact{}
+	err := r.db.With(ctx).Select().From(entity.Contact{}.TableName()).
+		Where(criteriaExp(orgID, criteria)).
+		OrderBy("email").
+		Offset(int64(offset)).
+		Limit(int64(limit)).
+		All(&contacts)
+	return contacts, err
+}
+
+// Create saves a new contact in the storage.
+func (r repository) Create(ctx context.Context, contact entity.Contact) error {
+	return r.db.With(ctx).Model(&contact).Insert()
+}
+
+// Update saves the changes to a contact in the storage.
+func (r repository) Update(ctx context.Context, contact entity.Contact) error {
+	return r.db.With(ctx).Model(&contact).Update("Email", "Name", "Locale", "Attributes", "UpdatedAt")
+}
+
+// Delete removes the contact with the specified ID owned by the organization.
+func (r repository) Delete(ctx context.Context, orgID, id string) error {
+	_, err := r.db.With(ctx).Delete(entity.Contact{}.TableName(), dbx.HashExp{"id": id, "org_id": orgID}).Execute()
+	return err
+}
+
+// GetList returns the list with the specified ID owned by the organization.
+func (r repository) GetList(ctx context.Context, orgID, id string) (entity.ContactList, error) {
+	var list entity.ContactList
+	err := r.db.With(ctx).Select().From(list.TableName()).
+		Where(dbx.HashExp{"id": id, "org_id": orgID}).One(&list)
+	return list, err
+}
+
+// CountLists returns the number of lists of the organization.
+func (r repository) CountLists(ctx context.Context, orgID string) (int, error) {
+	var count int
+	err := r.db.With(ctx).Select("COUNT(*)").From(entity.ContactList{}.TableName()).
+		Where(dbx.HashExp{"org_id": orgID}).Row(&count)
+	return count, err
+}
+
+// QueryLists returns the lists of the organization with the given offset and limit, sorted by name.
+func (r repository) QueryLists(ctx context.Context, orgID string, offset, limit int) ([]entity.ContactList, error) {
+	lists := []entity.ContactList{}
+	err := r.db.With(ctx).Select().From(entity.ContactList{}.TableName()).
+		Where(dbx.HashExp{"org_id": orgID}).
+		OrderBy("name", "id").
+		Offset(int64(offset)).
+		Limit(int64(limit)).
+		All(&lists)
+	return lists, err
+}
+
+// CreateList saves a new list in the storage.
+func (r repository) CreateList(ctx context.Context, list entity.ContactList) error {
+	return r.db.With(ctx).Model(&list).Insert()
+}
+
+// UpdateList saves the changes to a list in the storage.
+func (r repository) UpdateList(ctx context.Context, list entity.ContactList) error {
+	return r.db.With(ctx).Model(&list).Update("Name", "Description", "UpdatedAt")
+}
+
+// DeleteList removes the list with the specified ID owned by the organization.
+func (r repository) DeleteList(ctx context.Context, orgID, id string) error {
+	_, err := r.db.With(ctx).Delete(entity.ContactList{}.TableName(), dbx.HashExp{"id": id, "org_id": orgID}).Execute()
+	return err
+}
+
+// AddMembers adds the contacts of the organization with the given IDs to the list,
+// and returns the number of contacts that were not members yet. IDs of other organizations are ignored.
+func (r repository) AddMembers(ctx context.Context, orgID, listID string, contactIDs []string, now time.Time) (int, error) {
+	if len(contactIDs) == 0 {
+		return 0, nil
+	}
+	result, err := r.db.With(ctx).NewQuery(`
+		INSERT INTO contact_list_members (list_id, contact_id, created_at)
+		SELECT {:list}, id, {:now} FROM contacts WHERE org_id = {:org} AND id = ANY({:ids}::varchar[])
+		ON CONFLICT DO NOTHING`).
+		Bind(dbx.Params{"list": listID, "now": now, "org": orgID, "ids": pq.StringArray(contactIDs)}).Execute()
+	if err != nil {
+		return 0, err
+	}
+	rows, err := result.RowsAffected()
+	return int(rows), err
+}
+
+// RemoveMember removes the contact from the list, and returns whether it was a member.
+func (r repository) RemoveMember(ctx context.Context, listID, contactID string) (bool, error) {
+	result, err := r.db.With(ctx).Delete("contact_list_members", dbx.HashExp{"list_id": listID, "contact_id": contactID}).Execute()
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	return rows > 0, err
+}
+
+// criteriaExp selects the contacts of the organization matching the criteria.
+func criteriaExp(orgID string, criteria Criteria) dbx.Expression {
+	exps := []dbx.Expression{dbx.HashExp{"org_id": orgID}}
+	if criteria.Search != "" {
+		exps = append(exps, dbx.Or(dbx.Like("email", criteria.Search), dbx.Like("name", criteria.Search)))
+	}
+	if criteria.ListID != "" {
+		exps = append(exps, dbx.NewExp("id IN (SELECT contact_id FROM contact_list_members WHERE list_id = {:list})", dbx.Params{"list": criteria.ListID}))
+	}
+	if criteria.Filter != nil {
+		exps = append(exps, criteria.Filter)
+	}
+	if criteria.After != "" {
+		exps = append(exps, dbx.NewExp("email > {:after}", dbx.Params{"after": criteria.After}))
+	}
+	return dbx.And(exps...)
+}
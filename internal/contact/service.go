@@ -0,0 +1,454 @@
+// Package contact manages the contacts of organizations and the lists they group them into.
+package contact
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/garaekz/gonvelope/internal/audit"
+	"github.com/garaekz/gonvelope/internal/entity"
+	"github.com/garaekz/gonvelope/internal/errors"
+	"github.com/garaekz/gonvelope/pkg/dbcontext"
+	"github.com/garaekz/gonvelope/pkg/locale"
+	"github.com/garaekz/gonvelope/pkg/log"
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/go-ozzo/ozzo-validation/v4/is"
+)
+
+const (
+	// maxAttributesSize is the maximum size of the attributes of a contact, encoded as JSON.
+	maxAttributesSize = 16 * 1024
+	// maxMembersPerRequest is the maximum number of contacts added to a list at once.
+	maxMembersPerRequest = 1000
+)
+
+// attributePattern matches the names of the attributes that filters can address.
+var attributePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Service encapsulates usecase logic for contacts and lists.
+type Service interface {
+	Get(ctx context.Context, orgID, id string) (entity.Contact, error)
+	Query(ctx context.Context, orgID string, query ContactQuery, offset, limit int) ([]entity.Contact, error)
+	Count(ctx context.Context, orgID string, query ContactQuery) (int, error)
+	Create(ctx context.Context, orgID, userID string, input ContactRequest) (entity.Contact, error)
+	Update(ctx context.Context, orgID, userID, id string, input ContactRequest) (entity.Contact, error)
+	Delete(ctx context.Context, orgID, userID, id string) (entity.Contact, error)
+
+	GetList(ctx context.Context, orgID, id string) (entity.ContactList, error)
+	QueryLists(ctx context.Context, orgID string, offset, limit int) ([]entity.ContactList, error)
+	CountLists(ctx context.Context, orgID string) (int, error)
+	CreateList(ctx context.Context, orgID, userID string, input ListRequest) (entity.ContactList, error)
+	UpdateList(ctx context.Context, orgID, userID, id string, input ListRequest) (entity.ContactList, error)
+	DeleteList(ctx context.Context, orgID, userID, id string) (entity.ContactList, error)
+	// AddMembers adds contacts to a list and returns the number of contacts that were not members yet.
+	AddMembers(ctx context.Context, orgID, userID, listID string, input MembersRequest) (int, error)
+	// RemoveMember removes a contact from a list and returns the contact.
+	RemoveMember(ctx context.Context, orgID, userID, listID, contactID string) (entity.Contact, error)
+	// Members returns up to limit members of a list matching the filter, if any, starting after the given address.
+	Members(ctx context.Context, orgID, listID, filter, after string, limit int) ([]entity.Contact, error)
+}
+
+// ContactQuery selects contacts. Every non-empty field narrows the selection.
+type ContactQuery struct {
+	// Search selects the contacts whose email or name contains the term.
+	Search string
+	// Filter is a filter expression on the contact fields and attributes.
+	Filter string
+	// ListID selects the members of the list.
+	ListID string
+}
+
+// ContactRequest represents a contact creation or update request.
+type ContactRequest struct {
+	Email string `json:"email"`
+	Name  string `json:"name"`
+	// Locale is the locale the contact is sent templates in, such as es-MX.
+	Locale     string                 `json:"locale"`
+	Attributes map[string]interface{} `json:"attributes"`
+}
+
+// Validate validates the ContactRequest fields.
+func (m ContactRequest) Validate() error {
+	return validation.ValidateStruct(&m,
+		validation.Field(&m.Email, validation.Required, validation.Length(0, 254), is.EmailFormat),
+		validation.Field(&m.Name, validation.Length(0, 128)),
+		validation.Field(&m.Locale, validation.Length(0, 35), validation.By(validateLocale)),
+		validation.Field(&m.Attributes, validation.By(validateAttributes)),
+	)
+}
+
+// validateLocale checks that the locale, if any, is a locale such as es or es-MX.
+func validateLocale(value interface{}) error {
+	if tag, _ := value.(string); tag != "" && !locale.Valid(tag) {
+		return validation.NewError("validation_locale", "is not a locale such as es or es-MX")
+	}
+	return nil
+}
+
+// validateAttributes checks that the attributes can be addressed by filters and are not too large.
+func validateAttributes(value interface{}) error {
+	attributes, _ := value.(map[string]interface{})
+	for name := range attributes {
+		if !attributePattern.MatchString(name) {
+			return validation.NewError("validation_attribute_name", fmt.Sprintf("%q must start with a letter or underscore and contain only letters, digits and underscores", name))
+		}
+		if columnFields[name] {
+			return validation.NewError("validation_attribute_reserved", fmt.Sprintf("%q is a contact field", name))
+		}
+	}
+	if b, _ := json.Marshal(attributes); len(b) > maxAttributesSize {
+		return validation.NewError("validation_attributes_size", fmt.Sprintf("must not exceed %d bytes", maxAttributesSize))
+	}
+	return nil
+}
+
+// ListRequest represents a list creation or update request.
+type ListRequest struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// Validate validates the ListRequest fields.
+func (m ListRequest) Validate() error {
+	return validation.ValidateStruct(&m,
+		validation.Field(&m.Name, validation.Required, validation.Length(0, 128)),
+		validation.Field(&m.Description, validation.Length(0, 1000)),
+	)
+}
+
+// MembersRequest represents a request to add contacts to a list.
+type MembersRequest struct {
+	ContactIDs []string `json:"contact_ids"`
+}
+
+// Validate validates the MembersRequest fields.
+func (m MembersRequest) Validate() error {
+	return validation.ValidateStruct(&m,
+		validation.Field(&m.ContactIDs, validation.Required, validation.Length(1, maxMembersPerRequest)),
+	)
+}
+
+type service struct {
+	repo          Repository
+	transactional dbcontext.TransactionFunc
+	auditor       audit.Recorder
+	logger        log.Logger
+}
+
+// NewService creates a new contact service.
+func NewService(repo Repository, transactional dbcontext.TransactionFunc, auditor audit.Recorder, logger log.Logger) Service {
+	return service{repo, transactional, auditor, logger}
+}
+
+// Get returns the contact with the specified ID owned by the organization.
+func (s service) Get(ctx context.Context, orgID, id string) (entity.Contact, error) {
+	return s.repo.Get(ctx, orgID, id)
+}
+
+// Query returns the contacts of the organization selected by the query with the specified offset and limit.
+func (s service) Query(ctx context.Context, orgID string, query ContactQuery, offset, limit int) ([]entity.Contact, error) {
+	criteria, err := s.criteria(ctx, orgID, query)
+	if err != nil {
+		return nil, err
+	}
+	return s.repo.Query(ctx, orgID, criteria, offset, limit)
+}
+
+// Count returns the number of contacts of the organization selected by the query.
+func (s service) Count(ctx context.Context, orgID string, query ContactQuery) (int, error) {
+	criteria, err := s.criteria(ctx, orgID, query)
+	if err != nil {
+		return 0, err
+	}
+	return s.repo.Count(ctx, orgID, criteria)
+}
+
+// criteria compiles the filter of the query and checks that its list belongs to the organization.
+func (s service) criteria(ctx context.Context, orgID string, query ContactQuery) (Criteria, error) {
+	criteria := Criteria{Search: query.Search, ListID: query.ListID}
+	if query.ListID != "" {
+		if _, err := s.repo.GetList(ctx, orgID, query.ListID); err != nil {
+			return Criteria{}, err
+		}
+	}
+	if query.Filter != "" {
+		filter, err := parseFilter(query.Filter)
+		if err != nil {
+			return Criteria{}, errors.InvalidInput(validation.Errors{
+				"filter": validation.NewError("validation_filter_invalid", err.Error()),
+			})
+		}
+		criteria.Filter = filter
+	}
+	return criteria, nil
+}
+
+// Create creates a new contact of the organization on behalf of the user.
+func (s service) Create(ctx context.Context, orgID, userID string, req ContactRequest) (entity.Contact, error) {
+	req.Email = normalize(req.Email)
+	if err := req.Validate(); err != nil {
+		return entity.Contact{}, err
+	}
+	if err := s.checkEmail(ctx, orgID, "", req.Email); err != nil {
+		return entity.Contact{}, err
+	}
+	attributes, err := encodeAttributes(req.Attributes)
+	if err != nil {
+		return entity.Contact{}, err
+	}
+	now := time.Now()
+	contact := entity.Contact{
+		ID:         entity.GenerateID(),
+		OrgID:      orgID,
+		Email:      req.Email,
+		Name:       req.Name,
+		Locale:     locale.Normalize(req.Locale),
+		Attributes: attributes,
+		CreatedAt:  &now,
+		UpdatedAt:  &now,
+	}
+	err = s.transactional(ctx, func(ctx context.Context) error {
+		if err := s.repo.Create(ctx, contact); err != nil {
+			return err
+		}
+		return s.record(ctx, audit.ActionContactCreated, userID, "contact", contact.OrgID, contact.ID, nil, contact)
+	})
+	return contact, err
+}
+
+// Update updates the contact with the specified ID owned by the organization on behalf of the user.
+func (s service) Update(ctx context.Context, orgID, userID, id string, req ContactRequest) (entity.Contact, error) {
+	req.Email = normalize(req.Email)
+	if err := req.Validate(); err != nil {
+		return entity.Contact{}, err
+	}
+	contact, err := s.repo.Get(ctx, orgID, id)
+	if err != nil {
+		return contact, err
+	}
+	if err := s.checkEmail(ctx, orgID, id, req.Email); err != nil {
+		return entity.Contact{}, err
+	}
+	attributes, err := encodeAttributes(req.Attributes)
+	if err != nil {
+		return entity.Contact{}, err
+	}
+	before := contact
+	now := time.Now()
+	contact.Email = req.Email
+	contact.Name = req.Name
+	contact.Locale = locale.Normalize(req.Locale)
+	contact.Attributes = attributes
+	contact.UpdatedAt = &now
+	err = s.transactional(ctx, func(ctx context.Context) error {
+		if err := s.repo.Update(ctx, contact); err != nil {
+			return err
+		}
+		return s.record(ctx, audit.ActionContactUpdated, userID, "contact", contact.OrgID, contact.ID, before, contact)
+	})
+	return contact, err
+}
+
+// Delete deletes the contact with the specified ID owned by the organization on behalf of the user.
+func (s service) Delete(ctx context.Context, orgID, userID, id string) (entity.Contact, error) {
+	contact, err := s.repo.Get(ctx, orgID, id)
+	if err != nil {
+		return contact, err
+	}
+	err = s.transactional(ctx, func(ctx context.Context) error {
+		if err := s.repo.Delete(ctx, orgID, id); err != nil {
+			return err
+		}
+		return s.record(ctx, audit.ActionContactDeleted, userID, "contact", contact.OrgID, contact.ID, contact, nil)
+	})
+	return contact, err
+}
+
+// checkEmail returns a conflict error if another contact of the organization than the one with the given ID
+// has the email.
+func (s service) checkEmail(ctx context.Context, orgID, id, email string) error {
+	existing, err := s.repo.GetByEmail(ctx, orgID, email)
+	if err == sql.ErrNoRows || err == nil && existing.ID == id {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return errors.Conflict("A contact with this email already exists")
+}
+
+// GetList returns the list with the specified ID owned by the organization.
+func (s service) GetList(ctx context.Context, orgID, id string) (entity.ContactList, error) {
+	return s.repo.GetList(ctx, orgID, id)
+}
+
+// QueryLists returns the lists of the organization with the specified offset and limit.
+func (s service) QueryLists(ctx context.Context, orgID string, offset, limit int) ([]entity.ContactList, error) {
+	return s.repo.QueryLists(ctx, orgID, offset, limit)
+}
+
+// CountLists returns the number of lists of the organization.
+func (s service) CountLists(ctx context.Context, orgID string) (int, error) {
+	return s.repo.CountLists(ctx, orgID)
+}
+
+// CreateList creates a new list of the organization on behalf of the user.
+func (s service) CreateList(ctx context.Context, orgID, userID string, req ListRequest) (entity.ContactList, error) {
+	if err := req.Validate(); err != nil {
+		return entity.ContactList{}, err
+	}
+	now := time.Now()
+	list := entity.ContactList{
+		ID:          entity.GenerateID(),
+		OrgID:       orgID,
+		Name:        req.Name,
+		Description: req.Description,
+		CreatedAt:   &now,
+		UpdatedAt:   &now,
+	}
+	err := s.transactional(ctx, func(ctx context.Context) error {
+		if err := s.repo.CreateList(ctx, list); err != nil {
+			return err
+		}
+		return s.record(ctx, audit.ActionListCreated, userID, "list", list.OrgID, list.ID, nil, list)
+	})
+	return list, err
+}
+
+// UpdateList updates the list with the specified ID owned by the organization on behalf of the user.
+func (s service) UpdateList(ctx context.Context, orgID, userID, id string, req ListRequest) (entity.ContactList, error) {
+	if err := req.Validate(); err != nil {
+		return entity.ContactList{}, err
+	}
+	list, err := s.repo.GetList(ctx, orgID, id)
+	if err != nil {
+		return list, err
+	}
+	before := list
+	now := time.Now()
+	list.Name = req.Name
+	list.Description = req.Description
+	list.UpdatedAt = &now
+	err = s.transactional(ctx, func(ctx context.Context) error {
+		if err := s.repo.UpdateList(ctx, list); err != nil {
+			return err
+		}
+		return s.record(ctx, audit.ActionListUpdated, userID, "list", list.OrgID, list.ID, before, list)
+	})
+	return list, err
+}
+
+// DeleteList deletes the list with the specified ID owned by the organization on behalf of the user.
+// Its members are kept as contacts of the organization.
+func (s service) DeleteList(ctx context.Context, orgID, userID, id string) (entity.ContactList, error) {
+	list, err := s.repo.GetList(ctx, orgID, id)
+	if err != nil {
+		return list, err
+	}
+	err = s.transactional(ctx, func(ctx context.Context) error {
+		if err := s.repo.DeleteList(ctx, orgID, id); err != nil {
+			return err
+		}
+		return s.record(ctx, audit.ActionListDeleted, userID, "list", list.OrgID, list.ID, list, nil)
+	})
+	return list, err
+}
+
+// AddMembers adds contacts of the organization to the list on behalf of the user, and returns the number of
+// contacts that were not members yet. IDs that are not contacts of the organization are ignored.
+func (s service) AddMembers(ctx context.Context, orgID, userID, listID string, req MembersRequest) (int, error) {
+	if err := req.Validate(); err != nil {
+		return 0, err
+	}
+	if _, err := s.repo.GetList(ctx, orgID, listID); err != nil {
+		return 0, err
+	}
+	var added int
+	err := s.transactional(ctx, func(ctx context.Context) error {
+		var err error
+		if added, err = s.repo.AddMembers(ctx, orgID, listID, req.ContactIDs, time.Now()); err != nil {
+			return err
+		}
+		return s.auditor.Record(ctx, audit.Event{
+			Action:     audit.ActionListMembersAdded,
+			OrgID:      orgID,
+			ActorID:    userID,
+			TargetType: "list",
+			TargetID:   listID,
+			Data:       map[string]interface{}{"requested": len(req.ContactIDs), "added": added},
+		})
+	})
+	return added, err
+}
+
+// RemoveMember removes the contact from the list of the organization on behalf of the user and returns the contact.
+func (s service) RemoveMember(ctx context.Context, orgID, userID, listID, contactID string) (entity.Contact, error) {
+	if _, err := s.repo.GetList(ctx, orgID, listID); err != nil {
+		return entity.Contact{}, err
+	}
+	contact, err := s.repo.Get(ctx, orgID, contactID)
+	if err != nil {
+		return contact, err
+	}
+	err = s.transactional(ctx, func(ctx context.Context) error {
+		removed, err := s.repo.RemoveMember(ctx, listID, contactID)
+		if err != nil {
+			return err
+		}
+		if !removed {
+			return errors.NotFound("The contact is not a member of the list")
+		}
+		return s.auditor.Record(ctx, audit.Event{
+			Action:     audit.ActionListMemberRemoved,
+			OrgID:      orgID,
+			ActorID:    userID,
+			TargetType: "list",
+			TargetID:   listID,
+			Data:       map[string]interface{}{"contact_id": contactID},
+		})
+	})
+	return contact, err
+}
+
+// Members returns up to limit members of the list of the organization matching the filter, if any, sorted by email
+// and starting after the given address, so that large lists can be paged through while they change.
+// The list is read when called, so that sending to a list reaches its members at that time.
+func (s service) Members(ctx context.Context, orgID, listID, filter, after string, limit int) ([]entity.Contact, error) {
+	criteria, err := s.criteria(ctx, orgID, ContactQuery{Filter: filter, ListID: listID})
+	if err != nil {
+		return nil, err
+	}
+	criteria.After = after
+	return s.repo.Query(ctx, orgID, criteria, 0, limit)
+}
+
+// record records the change made by the user to a contact or a list in the audit log.
+// The before and after states are nil when the target is created and deleted respectively.
+func (s service) record(ctx context.Context, action, userID, targetType, orgID, targetID string, before, after interface{}) error {
+	return s.auditor.Record(ctx, audit.Event{
+		Action:     action,
+		OrgID:      orgID,
+		ActorID:    userID,
+		TargetType: targetType,
+		TargetID:   targetID,
+		Diff:       audit.Diff(before, after),
+	})
+}
+
+// encodeAttributes encodes the attributes of a contact, which default to an empty object.
+func encodeAttributes(attributes map[string]interface{}) (entity.JSON, error) {
+	if attributes == nil {
+		attributes = map[string]interface{}{}
+	}
+	return entity.NewJSON(attributes)
+}
+
+// normalize returns the email address in the form contacts are stored with.
+func normalize(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
@@ -0,0 +1,332 @@
+package contact
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/garaekz/gonvelope/internal/audit"
+	"github.com/garaekz/gonvelope/internal/entity"
+	"github.com/garaekz/gonvelope/internal/errors"
+	"github.com/garaekz/gonvelope/pkg/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestContactRequest_Validate(t *testing.T) {
+	tests := []struct {
+		name      string
+		model     ContactRequest
+		wantError bool
+	}{
+		{"success", ContactRequest{Email: "jane@example.com", Name: "Jane", Attributes: map[string]interface{}{"plan": "pro", "_seats": 3}}, false},
+		{"no attributes", ContactRequest{Email: "jane@example.com"}, false},
+		{"invalid email", ContactRequest{Email: "jane"}, true},
+		{"invalid attribute name", ContactRequest{Email: "jane@example.com", Attributes: map[string]interface{}{"first name": "Jane"}}, true},
+		{"reserved attribute name", ContactRequest{Email: "jane@example.com", Attributes: map[string]interface{}{"email": "jane@example.com"}}, true},
+		{"attributes too large", ContactRequest{Email: "jane@example.com", Attributes: map[string]interface{}{"notes": strings.Repeat("a", maxAttributesSize)}}, true},
+		{"locale", ContactRequest{Email: "jane@example.com", Locale: "es_MX"}, false},
+		{"invalid locale", ContactRequest{Email: "jane@example.com", Locale: "spanish"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.wantError, tt.model.Validate() != nil)
+		})
+	}
+}
+
+func Test_service_CRUD(t *testing.T) {
+	logger, _ := log.NewForTest()
+	auditor := &mockRecorder{}
+	s := NewService(&mockRepository{}, mockTransactional, auditor, logger)
+	ctx := context.Background()
+
+	contact, err := s.Create(ctx, "org1", "100", ContactRequest{Email: " Jane@Example.com ", Name: "Jane", Attributes: map[string]interface{}{"plan": "pro"}})
+	require.Nil(t, err)
+	assert.Equal(t, "jane@example.com", contact.Email)
+	assert.JSONEq(t, `{"plan":"pro"}`, string(contact.Attributes))
+	john, err := s.Create(ctx, "org1", "100", ContactRequest{Email: "john@example.com"})
+	require.Nil(t, err)
+	assert.JSONEq(t, `{}`, string(john.Attributes))
+
+	_, err = s.Create(ctx, "org1", "100", ContactRequest{Email: "JANE@example.com"})
+	assert.Equal(t, http.StatusConflict, err.(errors.ErrorResponse).StatusCode())
+	_, err = s.Update(ctx, "org1", "100", john.ID, ContactRequest{Email: "jane@example.com"})
+	assert.Equal(t, http.StatusConflict, err.(errors.ErrorResponse).StatusCode())
+	// another organization may have the same contact
+	_, err = s.Create(ctx, "org2", "100", ContactRequest{Email: "jane@example.com"})
+	require.Nil(t, err)
+
+	contact, err = s.Update(ctx, "org1", "100", contact.ID, ContactRequest{Email: "jane@example.com", Name: "Jane Doe", Locale: "pt_br"})
+	require.Nil(t, err)
+	assert.Equal(t, "Jane Doe", contact.Name)
+	assert.Equal(t, "pt-BR", contact.Locale)
+	_, err = s.Update(ctx, "org2", "100", contact.ID, ContactRequest{Email: "jane@example.com"})
+	assert.Equal(t, sql.ErrNoRows, err)
+
+	count, _ := s.Count(ctx, "org1", ContactQuery{Search: "jane"})
+	assert.Equal(t, 1, count)
+	contacts, _ := s.Query(ctx, "org1", ContactQuery{}, 0, 10)
+	assert.Len(t, contacts, 2)
+	_, err = s.Query(ctx, "org1", ContactQuery{Filter: "plan ="}, 0, 10)
+	assert.Equal(t, http.StatusBadRequest, err.(errors.ErrorResponse).StatusCode())
+	_, err = s.Count(ctx, "org1", ContactQuery{ListID: "unknown"})
+	assert.Equal(t, sql.ErrNoRows, err)
+
+	_, err = s.Delete(ctx, "org2", "100", john.ID)
+	assert.Equal(t, sql.ErrNoRows, err)
+	_, err = s.Delete(ctx, "org1", "100", john.ID)
+	require.Nil(t, err)
+	_, err = s.Get(ctx, "org1", john.ID)
+	assert.Equal(t, sql.ErrNoRows, err)
+
+	if assert.Len(t, auditor.events, 5) {
+		assert.Equal(t, audit.ActionContactCreated, auditor.events[0].Action)
+		assert.Equal(t, audit.ActionContactUpdated, auditor.events[3].Action)
+		assert.Equal(t, audit.ActionContactDeleted, auditor.events[4].Action)
+	}
+}
+
+func Test_service_Lists(t *testing.T) {
+	logger, _ := log.NewForTest()
+	auditor := &mockRecorder{}
+	repo := &mockRepository{contacts: []entity.Contact{
+		{ID: "c1", OrgID: "org1", Email: "jane@example.com"},
+		{ID: "c2", OrgID: "org1", Email: "john@example.com"},
+		{ID: "c3", OrgID: "org2", Email: "other@example.com"},
+	}}
+	s := NewService(repo, mockTransactional, auditor, logger)
+	ctx := context.Background()
+
+	list, err := s.CreateList(ctx, "org1", "100", ListRequest{Name: "Customers"})
+	require.Nil(t, err)
+	_, err = s.CreateList(ctx, "org1", "100", ListRequest{})
+	assert.NotNil(t, err)
+	list, err = s.UpdateList(ctx, "org1", "100", list.ID, ListRequest{Name: "Customers", Description: "Paying customers"})
+	require.Nil(t, err)
+	assert.Equal(t, "Paying customers", list.Description)
+	count, _ := s.CountLists(ctx, "org1")
+	assert.Equal(t, 1, count)
+
+	// contacts of other organizations are ignored
+	added, err := s.AddMembers(ctx, "org1", "100", list.ID, MembersRequest{ContactIDs: []string{"c1", "c2", "c3"}})
+	require.Nil(t, err)
+	assert.Equal(t, 2, added)
+	added, _ = s.AddMembers(ctx, "org1", "100", list.ID, MembersRequest{ContactIDs: []string{"c1"}})
+	assert.Equal(t, 0, added)
+	_, err = s.AddMembers(ctx, "org2", "100", list.ID, MembersRequest{ContactIDs: []string{"c3"}})
+	assert.Equal(t, sql.ErrNoRows, err)
+	_, err = s.AddMembers(ctx, "org1", "100", list.ID, MembersRequest{})
+	assert.NotNil(t, err)
+
+	members, err := s.Members(ctx, "org1", list.ID, "", "", 10)
+	require.Nil(t, err)
+	assert.Len(t, members, 2)
+	members, _ = s.Members(ctx, "org1", list.ID, "", members[0].Email, 10)
+	assert.Len(t, members, 1)
+	count, _ = s.Count(ctx, "org1", ContactQuery{ListID: list.ID, Search: "john"})
+	assert.Equal(t, 1, count)
+	_, err = s.Members(ctx, "org2", list.ID, "", "", 10)
+	assert.Equal(t, sql.ErrNoRows, err)
+	_, err = s.Members(ctx, "org1", list.ID, "plan = ", "", 10)
+	assert.Equal(t, http.StatusBadRequest, err.(errors.ErrorResponse).StatusCode())
+
+	contact, err := s.RemoveMember(ctx, "org1", "100", list.ID, "c1")
+	require.Nil(t, err)
+	assert.Equal(t, "jane@example.com", contact.Email)
+	_, err = s.RemoveMember(ctx, "org1", "100", list.ID, "c1")
+	assert.Equal(t, http.StatusNotFound, err.(errors.ErrorResponse).StatusCode())
+	members, _ = s.Members(ctx, "org1", list.ID, "", "", 10)
+	assert.Len(t, members, 1)
+
+	_, err = s.DeleteList(ctx, "org1", "100", list.ID)
+	require.Nil(t, err)
+	_, err = s.GetList(ctx, "org1", list.ID)
+	assert.Equal(t, sql.ErrNoRows, err)
+	_, err = s.Get(ctx, "org1", "c2")
+	assert.Nil(t, err)
+
+	if assert.Len(t, auditor.events, 6) {
+		assert.Equal(t, audit.ActionListMembersAdded, auditor.events[2].Action)
+		assert.Equal(t, audit.ActionListMemberRemoved, auditor.events[4].Action)
+		assert.Equal(t, audit.ActionListDeleted, auditor.events[5].Action)
+	}
+}
+
+func mockTransactional(ctx context.Context, f func(ctx context.Context) error) error {
+	return f(ctx)
+}
+
+type mockRecorder struct {
+	events []audit.Event
+}
+
+func (m *mockRecorder) Record(_ context.Context, event audit.Event) error {
+	m.events = append(m.events, event)
+	return nil
+}
+
+// mockRepository keeps contacts and lists in memory. Compiled filters are ignored.
+type mockRepository struct {
+	contacts []entity.Contact
+	lists    []entity.ContactList
+	// members maps the IDs of lists to the IDs of their members.
+	members map[string]map[string]bool
+}
+
+func (m *mockRepository) Get(_ context.Context, orgID, id string) (entity.Contact, error) {
+	for _, contact := range m.contacts {
+		if contact.ID == id && contact.OrgID == orgID {
+			return contact, nil
+		}
+	}
+	return entity.Contact{}, sql.ErrNoRows
+}
+
+func (m *mockRepository) GetByEmail(_ context.Context, orgID, email string) (entity.Contact, error) {
+	for _, contact := range m.contacts {
+		if contact.OrgID == orgID && contact.Email == email {
+			return contact, nil
+		}
+	}
+	return entity.Contact{}, sql.ErrNoRows
+}
+
+func (m *mockRepository) Count(ctx context.Context, orgID string, criteria Criteria) (int, error) {
+	contacts, err := m.Query(ctx, orgID, criteria, 0, len(m.contacts))
+	return len(contacts), err
+}
+
+func (m *mockRepository) Query(_ context.Context, orgID string, criteria Criteria, offset, limit int) ([]entity.Contact, error) {
+	contacts := []entity.Contact{}
+	for _, contact := range m.contacts {
+		if contact.OrgID != orgID ||
+			criteria.ListID != "" && !m.members[criteria.ListID][contact.ID] ||
+			!strings.Contains(contact.Email, criteria.Search) && !strings.Contains(contact.Name, criteria.Search) ||
+			criteria.After != "" && contact.Email <= criteria.After {
+			continue
+		}
+		contacts = append(contacts, contact)
+	}
+	sort.Slice(contacts, func(i, j int) bool { return contacts[i].Email < contacts[j].Email })
+	if offset > len(contacts) {
+		return nil, nil
+	}
+	contacts = contacts[offset:]
+	if limit < len(contacts) {
+		contacts = contacts[:limit]
+	}
+	return contacts, nil
+}
+
+func (m *mockRepository) Create(_ context.Context, contact entity.Contact) error {
+	m.contacts = append(m.contacts, contact)
+	return nil
+}
+
+func (m *mockRepository) Update(_ context.Context, contact entity.Contact) error {
+	for i, item := range m.contacts {
+		if item.ID == contact.ID {
+			m.contacts[i] = contact
+		}
+	}
+	return nil
+}
+
+func (m *mockRepository) Delete(_ context.Context, orgID, id string) error {
+	for i, contact := range m.contacts {
+		if contact.ID == id && contact.OrgID == orgID {
+			m.contacts = append(m.contacts[:i], m.contacts[i+1:]...)
+			break
+		}
+	}
+	for _, members := range m.members {
+		delete(members, id)
+	}
+	return nil
+}
+
+func (m *mockRepository) GetList(_ context.Context, orgID, id string) (entity.ContactList, error) {
+	for _, list := range m.lists {
+		if list.ID == id && list.OrgID == orgID {
+			return list, nil
+		}
+	}
+	return entity.ContactList{}, sql.ErrNoRows
+}
+
+func (m *mockRepository) CountLists(ctx context.Context, orgID string) (int, error) {
+	lists, err := m.QueryLists(ctx, orgID, 0, len(m.lists))
+	return len(lists), err
+}
+
+func (m *mockRepository) QueryLists(_ context.Context, orgID string, offset, limit int) ([]entity.ContactList, error) {
+	lists := []entity.ContactList{}
+	for _, list := range m.lists {
+		if list.OrgID == orgID {
+			lists = append(lists, list)
+		}
+	}
+	if offset > len(lists) {
+		return nil, nil
+	}
+	lists = lists[offset:]
+	if limit < len(lists) {
+		lists = lists[:limit]
+	}
+	return lists, nil
+}
+
+func (m *mockRepository) CreateList(_ context.Context, list entity.ContactList) error {
+	m.lists = append(m.lists, list)
+	return nil
+}
+
+func (m *mockRepository) UpdateList(_ context.Context, list entity.ContactList) error {
+	for i, item := range m.lists {
+		if item.ID == list.ID {
+			m.lists[i] = list
+		}
+	}
+	return nil
+}
+
+func (m *mockRepository) DeleteList(_ context.Context, orgID, id string) error {
+	for i, list := range m.lists {
+		if list.ID == id && list.OrgID == orgID {
+			m.lists = append(m.lists[:i], m.lists[i+1:]...)
+			delete(m.members, id)
+			break
+		}
+	}
+	return nil
+}
+
+func (m *mockRepository) AddMembers(ctx context.Context, orgID, listID string, contactIDs []string, _ time.Time) (int, error) {
+	if m.members == nil {
+		m.members = map[string]map[string]bool{}
+	}
+	if m.members[listID] == nil {
+		m.members[listID] = map[string]bool{}
+	}
+	added := 0
+	for _, id := range contactIDs {
+		if _, err := m.Get(ctx, orgID, id); err == nil && !m.members[listID][id] {
+			m.members[listID][id] = true
+			added++
+		}
+	}
+	return added, nil
+}
+
+func (m *mockRepository) RemoveMember(_ context.Context, listID, contactID string) (bool, error) {
+	if !m.members[listID][contactID] {
+		return false, nil
+	}
+	delete(m.members[listID], contactID)
+	return true, nil
+}
@@ -0,0 +1,93 @@
+package dkimkey
+
+import (
+	"net/http"
+
+	"github.com/garaekz/gonvelope/internal/auth"
+	"github.com/garaekz/gonvelope/internal/entity"
+	"github.com/garaekz/gonvelope/internal/errors"
+	"github.com/garaekz/gonvelope/pkg/log"
+	"github.com/garaekz/gonvelope/pkg/pagination"
+	routing "github.com/garaekz/ozzo-routing"
+)
+
+// RegisterHandlers sets up the routing of the HTTP handlers.
+// DKIM keys belong to the organization made active by orgHandler.
+func RegisterHandlers(r *routing.RouteGroup, service Service, authHandler, orgHandler routing.Handler, logger log.Logger) {
+	res := resource{service, logger}
+
+	r.Use(authHandler, orgHandler)
+
+	r.Get("dkim-keys", auth.Require(auth.ScopeDomainsRead), res.query)
+	r.Get("dkim-keys/<id>", auth.Require(auth.ScopeDomainsRead), res.get)
+	r.Get("dkim-keys/<id>/dns", auth.Require(auth.ScopeDomainsRead), res.dns)
+	r.Post("dkim-keys", auth.Require(auth.ScopeDomainsWrite), res.create)
+	r.Delete("dkim-keys/<id>", auth.Require(auth.ScopeDomainsWrite), res.delete)
+}
+
+type resource struct {
+	service Service
+	logger  log.Logger
+}
+
+func (r resource) get(c *routing.Context) error {
+	key, err := r.service.Get(c.Request.Context(), currentMembership(c).OrgID, c.Param("id"))
+	if err != nil {
+		return err
+	}
+	return c.Write(key)
+}
+
+func (r resource) query(c *routing.Context) error {
+	ctx := c.Request.Context()
+	orgID := currentMembership(c).OrgID
+	count, err := r.service.Count(ctx, orgID)
+	if err != nil {
+		return err
+	}
+	pages := pagination.NewFromRequest(c.Request, count)
+	keys, err := r.service.Query(ctx, orgID, pages.Offset(), pages.Limit())
+	if err != nil {
+		return err
+	}
+	pages.Items = keys
+	return c.Write(pages)
+}
+
+// dns writes the DNS TXT record the organization must publish for the key to be verified.
+func (r resource) dns(c *routing.Context) error {
+	record, err := r.service.DNSRecord(c.Request.Context(), currentMembership(c).OrgID, c.Param("id"))
+	if err != nil {
+		return err
+	}
+	return c.Write(record)
+}
+
+func (r resource) create(c *routing.Context) error {
+	var input KeyRequest
+	if err := c.Read(&input); err != nil {
+		r.logger.With(c.Request.Context()).Info(err)
+		return errors.BadRequest("")
+	}
+	membership := currentMembership(c)
+	key, err := r.service.Create(c.Request.Context(), membership.OrgID, membership.UserID, input)
+	if err != nil {
+		return err
+	}
+	return c.WriteWithStatus(key, http.StatusCreated)
+}
+
+func (r resource) delete(c *routing.Context) error {
+	membership := currentMembership(c)
+	key, err := r.service.Delete(c.Request.Context(), membership.OrgID, membership.UserID, c.Param("id"))
+	if err != nil {
+		return err
+	}
+	return c.Write(key)
+}
+
+// currentMembership returns the membership of the current user in the active organization.
+func currentMembership(c *routing.Context) entity.Membership {
+	membership, _ := auth.CurrentMembership(c.Request.Context())
+	return membership
+}
@@ -0,0 +1,48 @@
+package dkimkey
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/garaekz/gonvelope/internal/auth"
+	"github.com/garaekz/gonvelope/internal/entity"
+	"github.com/garaekz/gonvelope/internal/org"
+	"github.com/garaekz/gonvelope/internal/test"
+	"github.com/garaekz/gonvelope/pkg/dkim"
+	"github.com/garaekz/gonvelope/pkg/log"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAPI(t *testing.T) {
+	logger, _ := log.NewForTest()
+	router := test.MockRouter(logger)
+	key, err := dkim.GenerateKey(dkim.AlgorithmEd25519)
+	require.Nil(t, err)
+	pem, err := dkim.MarshalPrivateKey(key)
+	require.Nil(t, err)
+	repo := &mockRepository{items: []entity.DKIMKey{
+		{ID: "k1", OrgID: "100", Domain: "example.com", Selector: "s1", Algorithm: dkim.AlgorithmEd25519, PrivateKey: string(pem), CreatedAt: time.Now()},
+		{ID: "k2", OrgID: "org1", Domain: "example.org", Selector: "s1", Algorithm: dkim.AlgorithmEd25519, PrivateKey: string(pem), CreatedAt: time.Now()},
+	}}
+	RegisterHandlers(router.Group("/"), NewService(repo, mockTransactional, dkim.DefaultHeaders, &mockRecorder{}, logger), auth.MockAuthHandler, org.MockHandler, logger)
+	header := auth.MockAuthHeader()
+
+	tests := []test.APITestCase{
+		{Name: "get", Method: "GET", URL: "/dkim-keys/k1", Header: header, WantStatus: http.StatusOK, WantResponse: `*"domain":"example.com"*`},
+		{Name: "get other org", Method: "GET", URL: "/dkim-keys/k2", Header: header, WantStatus: http.StatusNotFound},
+		{Name: "query", Method: "GET", URL: "/dkim-keys", Header: header, WantStatus: http.StatusOK, WantResponse: `*"total_count":1*`},
+		{Name: "dns", Method: "GET", URL: "/dkim-keys/k1/dns", Header: header, WantStatus: http.StatusOK, WantResponse: `{"name":"s1._domainkey.example.com","type":"TXT","value":"v=DKIM1; k=ed25519; p=*`},
+		{Name: "create", Method: "POST", URL: "/dkim-keys", Body: `{"domain":"mail.example.com","algorithm":"ed25519-sha256"}`, Header: header, WantStatus: http.StatusCreated, WantResponse: `*"selector":"gonvelope"*`},
+		{Name: "create duplicate", Method: "POST", URL: "/dkim-keys", Body: `{"domain":"example.com"}`, Header: header, WantStatus: http.StatusConflict},
+		{Name: "create invalid", Method: "POST", URL: "/dkim-keys", Body: `{"domain":"example.com","private_key":"nope"}`, Header: header, WantStatus: http.StatusBadRequest},
+		{Name: "create malformed", Method: "POST", URL: "/dkim-keys", Body: `"domain"`, Header: header, WantStatus: http.StatusBadRequest},
+		{Name: "editor create", Method: "POST", URL: "/dkim-keys", Body: `{"domain":"example.net"}`, Header: org.MockHeader(entity.RoleEditor), WantStatus: http.StatusForbidden},
+		{Name: "delete", Method: "DELETE", URL: "/dkim-keys/k1", Header: header, WantStatus: http.StatusOK},
+		{Name: "delete missing", Method: "DELETE", URL: "/dkim-keys/k1", Header: header, WantStatus: http.StatusNotFound},
+		{Name: "unauthorized", Method: "GET", URL: "/dkim-keys/k1", WantStatus: http.StatusUnauthorized},
+	}
+	for _, tc := range tests {
+		test.Endpoint(t, router, tc)
+	}
+}
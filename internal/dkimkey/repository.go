@@ -0,0 +1,84 @@
+package dkimkey
+
+import (
+	"context"
+
+	"github.com/garaekz/gonvelope/internal/entity"
+	"github.com/garaekz/gonvelope/pkg/dbcontext"
+	"github.com/garaekz/gonvelope/pkg/log"
+	dbx "github.com/go-ozzo/ozzo-dbx"
+)
+
+// Repository encapsulates the logic to access DKIM keys from the data source.
+type Repository interface {
+	// Get returns the DKIM key with the specified ID owned by the organization.
+	Get(ctx context.Context, orgID, id string) (entity.DKIMKey, error)
+	// GetByDomain returns the DKIM key of the organization for the domain.
+	GetByDomain(ctx context.Context, orgID, domain string) (entity.DKIMKey, error)
+	// Count returns the number of DKIM keys of the organization.
+	Count(ctx context.Context, orgID string) (int, error)
+	// Query returns the DKIM keys of the organization with the given offset and limit, ordered by domain.
+	Query(ctx context.Context, orgID string, offset, limit int) ([]entity.DKIMKey, error)
+	// Create saves a new DKIM key in the storage.
+	Create(ctx context.Context, key entity.DKIMKey) error
+	// Delete removes the DKIM key with the specified ID owned by the organization.
+	Delete(ctx context.Context, orgID, id string) error
+}
+
+// repository persists DKIM keys in database
+type repository struct {
+	db     *dbcontext.DB
+	logger log.Logger
+}
+
+// NewRepository creates a new DKIM key repository
+func NewRepository(db *dbcontext.DB, logger log.Logger) Repository {
+	return repository{db, logger}
+}
+
+// Get returns the DKIM key with the specified ID owned by the organization.
+func (r repository) Get(ctx context.Context, orgID, id string) (entity.DKIMKey, error) {
+	var key entity.DKIMKey
+	err := r.db.With(ctx).Select().From(key.TableName()).
+		Where(dbx.HashExp{"id": id, "org_id": orgID}).One(&key)
+	return key, err
+}
+
+// GetByDomain returns the DKIM key of the organization for the domain.
+func (r repository) GetByDomain(ctx context.Context, orgID, domain string) (entity.DKIMKey, error) {
+	var key entity.DKIMKey
+	err := r.db.With(ctx).Select().From(key.TableName()).
+		Where(dbx.HashExp{"org_id": orgID, "domain": domain}).One(&key)
+	return key, err
+}
+
+// Count returns the number of DKIM keys of the organization.
+func (r repository) Count(ctx context.Context, orgID string) (int, error) {
+	var count int
+	err := r.db.With(ctx).Select("COUNT(*)").From(entity.DKIMKey{}.TableName()).
+		Where(dbx.HashExp{"org_id": orgID}).Row(&count)
+	return count, err
+}
+
+// Query returns the DKIM keys of the organization with the given offset and limit, ordered by domain.
+func (r repository) Query(ctx context.Context, orgID string, offset, limit int) ([]entity.DKIMKey, error) {
+	keys := []entity.DKIMKey{}
+	err := r.db.With(ctx).Select().From(entity.DKIMKey{}.TableName()).
+		Where(dbx.HashExp{"org_id": orgID}).
+		OrderBy("domain").
+		Offset(int64(offset)).
+		Limit(int64(limit)).
+		All(&keys)
+	return keys, err
+}
+
+// Create saves a new DKIM key in the storage.
+func (r repository) Create(ctx context.Context, key entity.DKIMKey) error {
+	return r.db.With(ctx).Model(&key).Insert()
+}
+
+// Delete removes the DKIM key with the specified ID owned by the organization.
+func (r repository) Delete(ctx context.Context, orgID, id string) error {
+	_, err := r.db.With(ctx).Delete(entity.DKIMKey{}.TableName(), dbx.HashExp{"id": id, "org_id": orgID}).Execute()
+	return err
+}
@@ -0,0 +1,252 @@
+// Package dkimkey manages the keys organizations DKIM-sign the messages sent from their domains with.
+// Keys are either generated for the organization or imported, and their public part is published by
+// the organization in a DNS TXT record.
+package dkimkey
+
+import (
+	"context"
+	"crypto"
+	"database/sql"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/garaekz/gonvelope/internal/audit"
+	"github.com/garaekz/gonvelope/internal/entity"
+	"github.com/garaekz/gonvelope/internal/errors"
+	"github.com/garaekz/gonvelope/pkg/dbcontext"
+	"github.com/garaekz/gonvelope/pkg/dkim"
+	"github.com/garaekz/gonvelope/pkg/log"
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/go-ozzo/ozzo-validation/v4/is"
+)
+
+const (
+	// defaultSelector is the selector of the keys created without one.
+	defaultSelector = "gonvelope"
+	// keyExistsMessage is the error message returned when the organization already has a key for the domain.
+	keyExistsMessage = "The organization already has a DKIM key for this domain."
+)
+
+// selectorPattern matches a selector made of one or more DNS labels.
+var selectorPattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?(\.[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?)*$`)
+
+// Service encapsulates usecase logic for DKIM keys.
+type Service interface {
+	Get(ctx context.Context, orgID, id string) (entity.DKIMKey, error)
+	Query(ctx context.Context, orgID string, offset, limit int) ([]entity.DKIMKey, error)
+	Count(ctx context.Context, orgID string) (int, error)
+	// Create generates or imports the DKIM key of a domain of the organization on behalf of the user.
+	Create(ctx context.Context, orgID, userID string, input KeyRequest) (entity.DKIMKey, error)
+	Delete(ctx context.Context, orgID, userID, id string) (entity.DKIMKey, error)
+	// DNSRecord returns the DNS TXT record the organization must publish for the key to be verified.
+	DNSRecord(ctx context.Context, orgID, id string) (DNSRecord, error)
+	// DomainRecord returns the DNS TXT record publishing the key of the organization for the domain.
+	// It returns sql.ErrNoRows if the organization has no key for the domain.
+	DomainRecord(ctx context.Context, orgID, domain string) (DNSRecord, error)
+	// Signer returns the signer of the messages the organization sends from the address,
+	// or nil if the organization has no key for the domain of the address.
+	Signer(ctx context.Context, orgID, from string) (*dkim.Signer, error)
+}
+
+// KeyRequest represents a DKIM key creation request. The key is generated unless a private key is imported.
+type KeyRequest struct {
+	Domain string `json:"domain"`
+	// Selector defaults to "gonvelope".
+	Selector string `json:"selector"`
+	// Algorithm is the algorithm of the key generated, either rsa-sha256 or ed25519-sha256. Defaults to rsa-sha256.
+	// An imported key must match it, if given.
+	Algorithm string `json:"algorithm"`
+	// PrivateKey is the PEM encoded RSA or Ed25519 private key to import.
+	PrivateKey string `json:"private_key"`
+}
+
+// Validate validates the KeyRequest fields.
+func (m KeyRequest) Validate() error {
+	return validation.ValidateStruct(&m,
+		validation.Field(&m.Domain, validation.Required, validation.Length(0, 253), is.Domain),
+		validation.Field(&m.Selector, validation.Length(0, 253), validation.Match(selectorPattern)),
+		validation.Field(&m.Algorithm, validation.In(dkim.AlgorithmRSA, dkim.AlgorithmEd25519)),
+	)
+}
+
+// DNSRecord represents the DNS record publishing a DKIM public key.
+type DNSRecord struct {
+	Name  string `json:"name"`
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+type service struct {
+	repo          Repository
+	transactional dbcontext.TransactionFunc
+	headers       []string
+	auditor       audit.Recorder
+	logger        log.Logger
+}
+
+// NewService creates a new DKIM key service. The signers it returns sign the given headers.
+func NewService(repo Repository, transactional dbcontext.TransactionFunc, headers []string, auditor audit.Recorder, logger log.Logger) Service {
+	return service{repo, transactional, headers, auditor, logger}
+}
+
+// Get returns the DKIM key with the specified ID owned by the organization.
+func (s service) Get(ctx context.Context, orgID, id string) (entity.DKIMKey, error) {
+	return s.repo.Get(ctx, orgID, id)
+}
+
+// Query returns the DKIM keys of the organization with the specified offset and limit.
+func (s service) Query(ctx context.Context, orgID string, offset, limit int) ([]entity.DKIMKey, error) {
+	return s.repo.Query(ctx, orgID, offset, limit)
+}
+
+// Count returns the number of DKIM keys of the organization.
+func (s service) Count(ctx context.Context, orgID string) (int, error) {
+	return s.repo.Count(ctx, orgID)
+}
+
+// Create generates or imports the DKIM key of a domain of the organization on behalf of the user.
+func (s service) Create(ctx context.Context, orgID, userID string, req KeyRequest) (entity.DKIMKey, error) {
+	req.Domain = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(req.Domain)), ".")
+	req.Selector = strings.ToLower(strings.TrimSpace(req.Selector))
+	if err := req.Validate(); err != nil {
+		return entity.DKIMKey{}, err
+	}
+	if req.Selector == "" {
+		req.Selector = defaultSelector
+	}
+	key, err := newKey(req)
+	if err != nil {
+		return entity.DKIMKey{}, err
+	}
+	algorithm, _ := dkim.KeyAlgorithm(key)
+	pem, err := dkim.MarshalPrivateKey(key)
+	if err != nil {
+		return entity.DKIMKey{}, err
+	}
+	dkimKey := entity.DKIMKey{
+		ID:         entity.GenerateID(),
+		OrgID:      orgID,
+		UserID:     userID,
+		Domain:     req.Domain,
+		Selector:   req.Selector,
+		Algorithm:  algorithm,
+		PrivateKey: string(pem),
+		Imported:   req.PrivateKey != "",
+		CreatedAt:  time.Now(),
+	}
+	err = s.transactional(ctx, func(ctx context.Context) error {
+		if err := s.repo.Create(ctx, dkimKey); err != nil {
+			return errors.MapConflict(err, keyExistsMessage)
+		}
+		return s.record(ctx, audit.ActionDKIMKeyCreated, userID, dkimKey, nil, dkimKey)
+	})
+	if err != nil {
+		return entity.DKIMKey{}, err
+	}
+	return dkimKey, nil
+}
+
+// newKey generates the private key of the request, or parses the one it imports.
+func newKey(req KeyRequest) (crypto.Signer, error) {
+	if req.PrivateKey == "" {
+		algorithm := req.Algorithm
+		if algorithm == "" {
+			algorithm = dkim.AlgorithmRSA
+		}
+		return dkim.GenerateKey(algorithm)
+	}
+	key, err := dkim.ParsePrivateKey([]byte(req.PrivateKey))
+	if err != nil {
+		return nil, errors.InvalidInput(validation.Errors{
+			"private_key": validation.NewError("validation_dkim_key", "must be a PEM encoded RSA or Ed25519 private key of at least 1024 bits"),
+		})
+	}
+	if algorithm, _ := dkim.KeyAlgorithm(key); req.Algorithm != "" && req.Algorithm != algorithm {
+		return nil, errors.InvalidInput(validation.Errors{
+			"algorithm": validation.NewError("validation_dkim_algorithm", "does not match the private key"),
+		})
+	}
+	return key, nil
+}
+
+// Delete deletes the DKIM key on behalf of the user. The messages sent from its domain are no longer signed.
+func (s service) Delete(ctx context.Context, orgID, userID, id string) (entity.DKIMKey, error) {
+	key, err := s.repo.Get(ctx, orgID, id)
+	if err != nil {
+		return key, err
+	}
+	err = s.transactional(ctx, func(ctx context.Context) error {
+		if err := s.repo.Delete(ctx, orgID, id); err != nil {
+			return err
+		}
+		return s.record(ctx, audit.ActionDKIMKeyDeleted, userID, key, key, nil)
+	})
+	return key, err
+}
+
+// DNSRecord returns the DNS TXT record the organization must publish for the key to be verified.
+func (s service) DNSRecord(ctx context.Context, orgID, id string) (DNSRecord, error) {
+	key, err := s.repo.Get(ctx, orgID, id)
+	if err != nil {
+		return DNSRecord{}, err
+	}
+	return dnsRecord(key)
+}
+
+// DomainRecord returns the DNS TXT record publishing the key of the organization for the domain.
+// It returns sql.ErrNoRows if the organization has no key for the domain.
+func (s service) DomainRecord(ctx context.Context, orgID, domain string) (DNSRecord, error) {
+	key, err := s.repo.GetByDomain(ctx, orgID, strings.ToLower(domain))
+	if err != nil {
+		return DNSRecord{}, err
+	}
+	return dnsRecord(key)
+}
+
+// dnsRecord returns the DNS TXT record publishing the public part of the key.
+func dnsRecord(key entity.DKIMKey) (DNSRecord, error) {
+	private, err := dkim.ParsePrivateKey([]byte(key.PrivateKey))
+	if err != nil {
+		return DNSRecord{}, err
+	}
+	value, err := dkim.TXTRecord(private)
+	if err != nil {
+		return DNSRecord{}, err
+	}
+	return DNSRecord{Name: dkim.RecordName(key.Domain, key.Selector), Type: "TXT", Value: value}, nil
+}
+
+// Signer returns the signer of the messages the organization sends from the address,
+// or nil if the organization has no key for the domain of the address.
+func (s service) Signer(ctx context.Context, orgID, from string) (*dkim.Signer, error) {
+	at := strings.LastIndex(from, "@")
+	if at < 0 {
+		return nil, nil
+	}
+	key, err := s.repo.GetByDomain(ctx, orgID, strings.ToLower(from[at+1:]))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	private, err := dkim.ParsePrivateKey([]byte(key.PrivateKey))
+	if err != nil {
+		return nil, err
+	}
+	return dkim.NewSigner(key.Domain, key.Selector, private, s.headers)
+}
+
+// record records the change made by the user to the DKIM key in the audit log.
+// The before and after states are nil when the key is created and deleted respectively.
+func (s service) record(ctx context.Context, action, userID string, key entity.DKIMKey, before, after interface{}) error {
+	return s.auditor.Record(ctx, audit.Event{
+		Action:     action,
+		OrgID:      key.OrgID,
+		ActorID:    userID,
+		TargetType: "dkim_key",
+		TargetID:   key.ID,
+		Diff:       audit.Diff(before, after),
+	})
+}
@@ -0,0 +1,214 @@
+package dkimkey
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/garaekz/gonvelope/internal/audit"
+	"github.com/garaekz/gonvelope/internal/entity"
+	"github.com/garaekz/gonvelope/internal/errors"
+	"github.com/garaekz/gonvelope/pkg/dkim"
+	"github.com/garaekz/gonvelope/pkg/log"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyRequest_Validate(t *testing.T) {
+	tests := []struct {
+		name      string
+		model     KeyRequest
+		wantError bool
+	}{
+		{"success", KeyRequest{Domain: "example.com"}, false},
+		{"with selector and algorithm", KeyRequest{Domain: "mail.example.com", Selector: "s2026.eu", Algorithm: dkim.AlgorithmEd25519}, false},
+		{"missing domain", KeyRequest{}, true},
+		{"invalid domain", KeyRequest{Domain: "example com"}, true},
+		{"invalid selector", KeyRequest{Domain: "example.com", Selector: "-s1"}, true},
+		{"unknown algorithm", KeyRequest{Domain: "example.com", Algorithm: "rsa-sha1"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.wantError, tt.model.Validate() != nil)
+		})
+	}
+}
+
+func Test_service_Create(t *testing.T) {
+	logger, _ := log.NewForTest()
+	auditor := &mockRecorder{}
+	s := NewService(&mockRepository{}, mockTransactional, dkim.DefaultHeaders, auditor, logger)
+	ctx := context.Background()
+
+	key, err := s.Create(ctx, "org1", "100", KeyRequest{Domain: " Example.COM. "})
+	require.Nil(t, err)
+	assert.Equal(t, "example.com", key.Domain)
+	assert.Equal(t, defaultSelector, key.Selector)
+	assert.Equal(t, dkim.AlgorithmRSA, key.Algorithm)
+	assert.False(t, key.Imported)
+	if assert.Len(t, auditor.events, 1) {
+		assert.Equal(t, audit.ActionDKIMKeyCreated, auditor.events[0].Action)
+		// the private key is left out of the audit log
+		assert.NotContains(t, fmt.Sprint(auditor.events[0].Diff), "PRIVATE KEY")
+	}
+
+	_, err = s.Create(ctx, "org1", "100", KeyRequest{Domain: "example.com", Algorithm: dkim.AlgorithmEd25519})
+	assert.Equal(t, http.StatusConflict, err.(errors.ErrorResponse).StatusCode())
+	key, err = s.Create(ctx, "org2", "100", KeyRequest{Domain: "example.com", Selector: "S2026", Algorithm: dkim.AlgorithmEd25519})
+	require.Nil(t, err)
+	assert.Equal(t, "s2026", key.Selector)
+	assert.Equal(t, dkim.AlgorithmEd25519, key.Algorithm)
+
+	imported, err := dkim.GenerateKey(dkim.AlgorithmEd25519)
+	require.Nil(t, err)
+	pem, err := dkim.MarshalPrivateKey(imported)
+	require.Nil(t, err)
+	key, err = s.Create(ctx, "org1", "100", KeyRequest{Domain: "example.org", PrivateKey: string(pem)})
+	require.Nil(t, err)
+	assert.True(t, key.Imported)
+	assert.Equal(t, dkim.AlgorithmEd25519, key.Algorithm)
+	assert.Equal(t, string(pem), key.PrivateKey)
+
+	_, err = s.Create(ctx, "org1", "100", KeyRequest{Domain: "example.net", PrivateKey: string(pem), Algorithm: dkim.AlgorithmRSA})
+	assert.Contains(t, fmt.Sprint(err.(errors.ErrorResponse).Details), "algorithm")
+	_, err = s.Create(ctx, "org1", "100", KeyRequest{Domain: "example.net", PrivateKey: "not a key"})
+	assert.Contains(t, fmt.Sprint(err.(errors.ErrorResponse).Details), "private_key")
+	_, err = s.Create(ctx, "org1", "100", KeyRequest{Domain: "not a domain"})
+	assert.NotNil(t, err)
+}
+
+func Test_service_DNSRecord(t *testing.T) {
+	logger, _ := log.NewForTest()
+	s := NewService(&mockRepository{}, mockTransactional, dkim.DefaultHeaders, &mockRecorder{}, logger)
+	ctx := context.Background()
+	key, err := s.Create(ctx, "org1", "100", KeyRequest{Domain: "example.com", Selector: "s1"})
+	require.Nil(t, err)
+
+	record, err := s.DNSRecord(ctx, "org1", key.ID)
+	require.Nil(t, err)
+	assert.Equal(t, "s1._domainkey.example.com", record.Name)
+	assert.Equal(t, "TXT", record.Type)
+	assert.True(t, strings.HasPrefix(record.Value, "v=DKIM1; k=rsa; p="))
+	_, err = s.DNSRecord(ctx, "org2", key.ID)
+	assert.Equal(t, sql.ErrNoRows, err)
+
+	byDomain, err := s.DomainRecord(ctx, "org1", "Example.com")
+	require.Nil(t, err)
+	assert.Equal(t, record, byDomain)
+	_, err = s.DomainRecord(ctx, "org2", "example.com")
+	assert.Equal(t, sql.ErrNoRows, err)
+}
+
+func Test_service_Signer(t *testing.T) {
+	logger, _ := log.NewForTest()
+	s := NewService(&mockRepository{}, mockTransactional, []string{"Subject"}, &mockRecorder{}, logger)
+	ctx := context.Background()
+	key, err := s.Create(ctx, "org1", "100", KeyRequest{Domain: "example.com", Selector: "s1", Algorithm: dkim.AlgorithmEd25519})
+	require.Nil(t, err)
+
+	signer, err := s.Signer(ctx, "org1", "Sales@Example.com")
+	require.Nil(t, err)
+	require.NotNil(t, signer)
+	signed, err := signer.Sign([]byte("From: sales@example.com\r\nSubject: Hi\r\nTo: a@example.org\r\n\r\nHello\r\n"))
+	require.Nil(t, err)
+	assert.Contains(t, string(signed), "d=example.com; s=s1;")
+	assert.Contains(t, string(signed), "h=from:subject;")
+
+	// messages sent from other domains or by other organizations are not signed
+	signer, err = s.Signer(ctx, "org1", "sales@example.org")
+	assert.Nil(t, err)
+	assert.Nil(t, signer)
+	signer, err = s.Signer(ctx, "org2", "sales@example.com")
+	assert.Nil(t, err)
+	assert.Nil(t, signer)
+
+	_, err = s.Delete(ctx, "org1", "100", key.ID)
+	require.Nil(t, err)
+	signer, err = s.Signer(ctx, "org1", "sales@example.com")
+	assert.Nil(t, err)
+	assert.Nil(t, signer)
+}
+
+func mockTransactional(ctx context.Context, f func(ctx context.Context) error) error {
+	return f(ctx)
+}
+
+type mockRecorder struct {
+	events []audit.Event
+}
+
+func (m *mockRecorder) Record(_ context.Context, event audit.Event) error {
+	m.events = append(m.events, event)
+	return nil
+}
+
+type mockRepository struct {
+	items []entity.DKIMKey
+}
+
+func (m *mockRepository) Get(_ context.Context, orgID, id string) (entity.DKIMKey, error) {
+	for _, item := range m.items {
+		if item.ID == id && item.OrgID == orgID {
+			return item, nil
+		}
+	}
+	return entity.DKIMKey{}, sql.ErrNoRows
+}
+
+func (m *mockRepository) GetByDomain(_ context.Context, orgID, domain string) (entity.DKIMKey, error) {
+	for _, item := range m.items {
+		if item.Domain == domain && item.OrgID == orgID {
+			return item, nil
+		}
+	}
+	return entity.DKIMKey{}, sql.ErrNoRows
+}
+
+func (m *mockRepository) Count(_ context.Context, orgID string) (int, error) {
+	count := 0
+	for _, item := range m.items {
+		if item.OrgID == orgID {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (m *mockRepository) Query(_ context.Context, orgID string, offset, limit int) ([]entity.DKIMKey, error) {
+	var items []entity.DKIMKey
+	for _, item := range m.items {
+		if item.OrgID == orgID {
+			items = append(items, item)
+		}
+	}
+	if offset > len(items) {
+		offset = len(items)
+	}
+	items = items[offset:]
+	if limit >= 0 && limit < len(items) {
+		items = items[:limit]
+	}
+	return items, nil
+}
+
+func (m *mockRepository) Create(_ context.Context, key entity.DKIMKey) error {
+	if _, err := m.GetByDomain(context.Background(), key.OrgID, key.Domain); err == nil {
+		return &pq.Error{Code: "23505"}
+	}
+	m.items = append(m.items, key)
+	return nil
+}
+
+func (m *mockRepository) Delete(_ context.Context, orgID, id string) error {
+	for i, item := range m.items {
+		if item.ID == id && item.OrgID == orgID {
+			m.items = append(m.items[:i], m.items[i+1:]...)
+			return nil
+		}
+	}
+	return sql.ErrNoRows
+}
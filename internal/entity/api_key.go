@@ -0,0 +1,40 @@
+package entity
+
+import (
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// APIKey represents a scoped API key used by backend services to call the API.
+// A key acts on behalf of its owner within the organization it was created in.
+// Only the hash of the key is stored; the prefix is kept in plain text to identify the key.
+type APIKey struct {
+	ID         string         `json:"id" db:"id"`
+	OrgID      string         `json:"org_id" db:"org_id"`
+	UserID     string         `json:"user_id" db:"user_id"`
+	Name       string         `json:"name" db:"name"`
+	Prefix     string         `json:"prefix" db:"prefix"`
+	KeyHash    string         `json:"-" db:"key_hash"`
+	Scopes     pq.StringArray `json:"scopes" db:"scopes"`
+	AllowedIPs pq.StringArray `json:"allowed_ips" db:"allowed_ips"`
+	ExpiresAt  *time.Time     `json:"expires_at" db:"expires_at"`
+	LastUsedAt *time.Time     `json:"last_used_at" db:"last_used_at"`
+	CreatedAt  *time.Time     `json:"created_at" db:"created_at"`
+	UpdatedAt  *time.Time     `json:"updated_at" db:"updated_at"`
+}
+
+// TableName returns the name of the database table for the APIKey entity.
+func (APIKey) TableName() string {
+	return "api_keys"
+}
+
+// GetID returns the API key ID.
+func (k APIKey) GetID() string {
+	return k.ID
+}
+
+// IsExpired returns whether the API key has expired at the given time.
+func (k APIKey) IsExpired(now time.Time) bool {
+	return k.ExpiresAt != nil && !now.Before(*k.ExpiresAt)
+}
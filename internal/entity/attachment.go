@@ -0,0 +1,28 @@
+package entity
+
+import "time"
+
+// Attachment represents a file uploaded by an organization to be attached to the messages it sends.
+// The content of the file is kept in a blob store.
+type Attachment struct {
+	ID          string `json:"id" db:"id"`
+	OrgID       string `json:"org_id" db:"org_id"`
+	UserID      string `json:"user_id" db:"user_id"`
+	Filename    string `json:"filename" db:"filename"`
+	ContentType string `json:"content_type" db:"content_type"`
+	// Size is the size of the file in bytes.
+	Size int64 `json:"size" db:"size"`
+	// Checksum is the hex-encoded SHA-256 hash of the file.
+	Checksum  string    `json:"checksum" db:"checksum"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+// TableName returns the name of the database table for the Attachment entity.
+func (Attachment) TableName() string {
+	return "attachments"
+}
+
+// GetID returns the attachment ID.
+func (a Attachment) GetID() string {
+	return a.ID
+}
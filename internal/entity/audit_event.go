@@ -0,0 +1,29 @@
+package entity
+
+import "time"
+
+// AuditEvent represents a security-relevant action recorded in the append-only audit log.
+type AuditEvent struct {
+	ID         string    `json:"id" db:"id"`
+	OrgID      string    `json:"org_id" db:"org_id"`
+	ActorID    string    `json:"actor_id" db:"actor_id"`
+	Action     string    `json:"action" db:"action"`
+	TargetType string    `json:"target_type" db:"target_type"`
+	TargetID   string    `json:"target_id" db:"target_id"`
+	IP         string    `json:"ip" db:"ip"`
+	UserAgent  string    `json:"user_agent" db:"user_agent"`
+	RequestID  string    `json:"request_id" db:"request_id"`
+	Diff       JSON      `json:"diff" db:"diff"`
+	Data       JSON      `json:"data" db:"data"`
+	CreatedAt  time.Time `json:"created_at" db:"created_at"`
+}
+
+// TableName returns the name of the database table for the AuditEvent entity.
+func (AuditEvent) TableName() string {
+	return "audit_events"
+}
+
+// GetID returns the audit event ID.
+func (e AuditEvent) GetID() string {
+	return e.ID
+}
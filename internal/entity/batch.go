@@ -0,0 +1,29 @@
+package entity
+
+import "time"
+
+// Batch represents a template sent to many recipients with a single request.
+type Batch struct {
+	ID         string `json:"id" db:"id"`
+	OrgID      string `json:"org_id" db:"org_id"`
+	UserID     string `json:"user_id" db:"user_id"`
+	TemplateID string `json:"template_id" db:"template_id"`
+	AccountID  string `json:"account_id" db:"account_id"`
+	// Total is the number of recipients in the request.
+	Total int `json:"total" db:"total"`
+	// Accepted is the number of recipients a message was queued for.
+	Accepted int `json:"accepted" db:"accepted"`
+	// Rejected is the number of recipients that failed validation.
+	Rejected  int       `json:"rejected" db:"rejected"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+// TableName returns the name of the database table for the Batch entity.
+func (Batch) TableName() string {
+	return "batches"
+}
+
+// GetID returns the batch ID.
+func (b Batch) GetID() string {
+	return b.ID
+}
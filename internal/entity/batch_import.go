@@ -0,0 +1,67 @@
+package entity
+
+import "time"
+
+// Import formats.
+const (
+	// ImportCSV is a CSV file with a header line.
+	ImportCSV = "csv"
+	// ImportJSONL is a file with a JSON object per line.
+	ImportJSONL = "jsonl"
+)
+
+// Import statuses.
+const (
+	// ImportProcessing means the file is still being read.
+	ImportProcessing = "processing"
+	// ImportCompleted means every line of the file was read.
+	ImportCompleted = "completed"
+	// ImportFailed means the file could not be read to the end.
+	ImportFailed = "failed"
+)
+
+// BatchImport represents a file of recipients imported into a batch.
+type BatchImport struct {
+	ID     string `json:"id" db:"id"`
+	OrgID  string `json:"org_id" db:"org_id"`
+	UserID string `json:"user_id" db:"user_id"`
+	// BatchID is the ID of the batch the recipients are sent with. Empty for dry runs.
+	BatchID    string `json:"batch_id" db:"batch_id"`
+	TemplateID string `json:"template_id" db:"template_id"`
+	AccountID  string `json:"account_id" db:"account_id"`
+	Format     string `json:"format" db:"format"`
+	// DryRun is true if the file was only validated.
+	DryRun bool   `json:"dry_run" db:"dry_run"`
+	Status string `json:"status" db:"status"`
+	// Lines is the number of recipients read so far.
+	Lines    int `json:"lines" db:"lines"`
+	Accepted int `json:"accepted" db:"accepted"`
+	Rejected int `json:"rejected" db:"rejected"`
+	// Error explains why a failed import stopped.
+	Error     string    `json:"error" db:"error"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// TableName returns the name of the database table for the BatchImport entity.
+func (BatchImport) TableName() string {
+	return "batch_imports"
+}
+
+// GetID returns the import ID.
+func (i BatchImport) GetID() string {
+	return i.ID
+}
+
+// ImportError is a line of an imported file that was rejected.
+type ImportError struct {
+	ImportID string `json:"-" db:"import_id"`
+	Line     int    `json:"line" db:"line"`
+	Email    string `json:"email" db:"email"`
+	Error    string `json:"error" db:"error"`
+}
+
+// TableName returns the name of the database table for the ImportError entity.
+func (ImportError) TableName() string {
+	return "batch_import_errors"
+}
@@ -0,0 +1,47 @@
+package entity
+
+import "time"
+
+// Contact represents a person an organization sends messages to.
+type Contact struct {
+	ID    string `json:"id" db:"id"`
+	OrgID string `json:"org_id" db:"org_id"`
+	Email string `json:"email" db:"email"`
+	Name  string `json:"name" db:"name"`
+	// Locale is the locale the contact is sent templates in, such as es-MX. Empty for the default one.
+	Locale string `json:"locale" db:"locale"`
+	// Attributes holds the custom attributes of the contact as a JSON object.
+	Attributes JSON       `json:"attributes" db:"attributes"`
+	CreatedAt  *time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt  *time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// TableName returns the name of the database table for the Contact entity.
+func (Contact) TableName() string {
+	return "contacts"
+}
+
+// GetID returns the contact ID.
+func (c Contact) GetID() string {
+	return c.ID
+}
+
+// ContactList represents a list of contacts of an organization that messages can be sent to.
+type ContactList struct {
+	ID          string     `json:"id" db:"id"`
+	OrgID       string     `json:"org_id" db:"org_id"`
+	Name        string     `json:"name" db:"name"`
+	Description string     `json:"description" db:"description"`
+	CreatedAt   *time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt   *time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// TableName returns the name of the database table for the ContactList entity.
+func (ContactList) TableName() string {
+	return "contact_lists"
+}
+
+// GetID returns the list ID.
+func (l ContactList) GetID() string {
+	return l.ID
+}
@@ -0,0 +1,30 @@
+package entity
+
+import "time"
+
+// DKIMKey represents the key an organization DKIM-signs the messages sent from a domain with.
+// The public key is published in a DNS TXT record under the selector.
+type DKIMKey struct {
+	ID       string `json:"id" db:"id"`
+	OrgID    string `json:"org_id" db:"org_id"`
+	UserID   string `json:"user_id" db:"user_id"`
+	Domain   string `json:"domain" db:"domain"`
+	Selector string `json:"selector" db:"selector"`
+	// Algorithm is the signature algorithm of the key, either rsa-sha256 or ed25519-sha256.
+	Algorithm string `json:"algorithm" db:"algorithm"`
+	// PrivateKey is the PEM encoded PKCS #8 private key. It is never returned.
+	PrivateKey string `json:"-" db:"private_key"`
+	// Imported tells keys imported by the organization from keys generated for it.
+	Imported  bool      `json:"imported" db:"imported"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+// TableName returns the name of the database table for the DKIMKey entity.
+func (DKIMKey) TableName() string {
+	return "dkim_keys"
+}
+
+// GetID returns the DKIM key ID.
+func (k DKIMKey) GetID() string {
+	return k.ID
+}
@@ -0,0 +1,60 @@
+package entity
+
+import (
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Inbound message sources.
+const (
+	// InboundMailbox means the message was read from the mailbox of a linked provider account.
+	InboundMailbox = "mailbox"
+	// InboundAPI means the message was posted to the API as raw MIME.
+	InboundAPI = "api"
+)
+
+// InboundMessage represents an email message received by an organization, normalized from its raw MIME form.
+// The raw message is kept in a blob store.
+type InboundMessage struct {
+	ID    string `json:"id" db:"id"`
+	OrgID string `json:"org_id" db:"org_id"`
+	// AccountID is the ID of the provider account the message was read from, if any.
+	AccountID string `json:"account_id" db:"account_id"`
+	Source    string `json:"source" db:"source"`
+	// SourceID is the provider ID of the mailbox message, if any.
+	SourceID string `json:"source_id" db:"source_id"`
+	// OutboundID is the ID of the message sent by the organization this message replies to, if any.
+	OutboundID string `json:"outbound_id" db:"outbound_id"`
+	// MessageID, InReplyTo and References are the RFC 5322 threading headers of the message.
+	MessageID  string         `json:"message_id" db:"message_id"`
+	InReplyTo  string         `json:"in_reply_to" db:"in_reply_to"`
+	References pq.StringArray `json:"references" db:"message_references"`
+	FromEmail  string         `json:"from_email" db:"from_email"`
+	FromName   string         `json:"from_name" db:"from_name"`
+	ReplyTo    pq.StringArray `json:"reply_to" db:"reply_to_emails"`
+	To         pq.StringArray `json:"to" db:"to_emails"`
+	Cc         pq.StringArray `json:"cc" db:"cc_emails"`
+	Subject    string         `json:"subject" db:"subject"`
+	Text       string         `json:"text" db:"text_body"`
+	HTML       string         `json:"html" db:"html_body"`
+	// Headers maps the canonical names of the headers to their decoded values.
+	Headers JSON `json:"headers" db:"headers"`
+	// Attachments describes the attachments of the message, whose content is read from the raw message.
+	Attachments JSON `json:"attachments" db:"attachments"`
+	// Size is the size of the raw message in bytes.
+	Size int64 `json:"size" db:"size"`
+	// SentAt is the date of the message, if valid.
+	SentAt    *time.Time `json:"sent_at" db:"sent_at"`
+	CreatedAt time.Time  `json:"created_at" db:"created_at"`
+}
+
+// TableName returns the name of the database table for the InboundMessage entity.
+func (InboundMessage) TableName() string {
+	return "inbound_messages"
+}
+
+// GetID returns the inbound message ID.
+func (m InboundMessage) GetID() string {
+	return m.ID
+}
@@ -0,0 +1,21 @@
+package entity
+
+import "time"
+
+// LoginFailure tracks the failed login attempts made for an email or from an IP address.
+type LoginFailure struct {
+	Key          string     `db:"pk,key"`
+	Failures     int        `db:"failures"`
+	LastFailedAt time.Time  `db:"last_failed_at"`
+	LockedUntil  *time.Time `db:"locked_until"`
+}
+
+// TableName returns the name of the database table for the LoginFailure entity.
+func (LoginFailure) TableName() string {
+	return "login_failures"
+}
+
+// IsLocked returns whether the key is locked out at the given time.
+func (f LoginFailure) IsLocked(now time.Time) bool {
+	return f.LockedUntil != nil && f.LockedUntil.After(now)
+}
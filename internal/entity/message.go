@@ -0,0 +1,63 @@
+package entity
+
+import (
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Message statuses. A message is queued until the worker delivers it or gives up on it.
+const (
+	// MessageQueued means the message waits in the queue to be sent.
+	MessageQueued = "queued"
+	// MessageSending means a worker is delivering the message.
+	MessageSending = "sending"
+	// MessageSent means the provider accepted the message.
+	MessageSent = "sent"
+	// MessageFailed means the message could not be delivered.
+	MessageFailed = "failed"
+	// MessageBounced means the provider accepted the message but a delivery status notification reported a failure.
+	MessageBounced = "bounced"
+)
+
+// Message represents an email message sent through a linked provider account of an organization.
+type Message struct {
+	ID        string `json:"id" db:"id"`
+	OrgID     string `json:"org_id" db:"org_id"`
+	UserID    string `json:"user_id" db:"user_id"`
+	AccountID string `json:"account_id" db:"account_id"`
+	// BatchID is the ID of the batch the message was sent with, if any.
+	BatchID string `json:"batch_id" db:"batch_id"`
+	// TemplateID is the ID of the template the message was rendered from, if any.
+	TemplateID string `json:"template_id" db:"template_id"`
+	// MessageID is the RFC 5322 Message-ID header of the message.
+	MessageID string         `json:"message_id" db:"message_id"`
+	FromEmail string         `json:"from_email" db:"from_email"`
+	FromName  string         `json:"from_name" db:"from_name"`
+	To        pq.StringArray `json:"to" db:"to_emails"`
+	Subject   string         `json:"subject" db:"subject"`
+	Text      string         `json:"text" db:"text_body"`
+	HTML      string         `json:"html" db:"html_body"`
+	// AttachmentIDs are the IDs of the attachments of the message.
+	AttachmentIDs pq.StringArray `json:"attachment_ids" db:"attachment_ids"`
+	// Tracking tells whether opens and clicks of the HTML body are tracked.
+	Tracking          bool       `json:"tracking" db:"tracking"`
+	Status            string     `json:"status" db:"status"`
+	Attempts          int        `json:"attempts" db:"attempts"`
+	NextAttemptAt     time.Time  `json:"next_attempt_at" db:"next_attempt_at"`
+	LastError         string     `json:"last_error" db:"last_error"`
+	ProviderMessageID string     `json:"provider_message_id" db:"provider_message_id"`
+	SentAt            *time.Time `json:"sent_at" db:"sent_at"`
+	CreatedAt         *time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt         *time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// TableName returns the name of the database table for the Message entity.
+func (Message) TableName() string {
+	return "messages"
+}
+
+// GetID returns the message ID.
+func (m Message) GetID() string {
+	return m.ID
+}
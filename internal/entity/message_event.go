@@ -0,0 +1,39 @@
+package entity
+
+import "time"
+
+// Message event types.
+const (
+	// MessageEventBounced means a delivery status notification reported that a recipient did not get the message.
+	MessageEventBounced = "bounced"
+	// MessageEventReplied means a recipient replied to the message.
+	MessageEventReplied = "replied"
+	// MessageEventOpened means a recipient opened the message, as reported by its tracking pixel.
+	MessageEventOpened = "opened"
+	// MessageEventClicked means a recipient followed a tracked link of the message.
+	MessageEventClicked = "clicked"
+)
+
+// MessageEvent represents something that happened to a message after it was sent.
+type MessageEvent struct {
+	ID        string `json:"id" db:"id"`
+	OrgID     string `json:"org_id" db:"org_id"`
+	MessageID string `json:"message_id" db:"message_id"`
+	Type      string `json:"type" db:"type"`
+	// Recipient is the address of the recipient the event is about, if known.
+	Recipient string `json:"recipient" db:"recipient"`
+	// SourceID is the provider ID of the mailbox message the event was detected from, if any.
+	SourceID  string    `json:"source_id" db:"source_id"`
+	Data      JSON      `json:"data" db:"data"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+// TableName returns the name of the database table for the MessageEvent entity.
+func (MessageEvent) TableName() string {
+	return "message_events"
+}
+
+// GetID returns the message event ID.
+func (e MessageEvent) GetID() string {
+	return e.ID
+}
@@ -0,0 +1,23 @@
+package entity
+
+import "time"
+
+// MessageStats represents the number of messages of an organization sent through a provider account from
+// a template during an hour, along with what happened to them. Messages not sent from a template have an
+// empty TemplateID. The rows are rolled up by the worker as messages are delivered and their events recorded.
+type MessageStats struct {
+	OrgID      string    `json:"org_id" db:"org_id"`
+	Period     time.Time `json:"period" db:"period"`
+	TemplateID string    `json:"template_id" db:"template_id"`
+	AccountID  string    `json:"account_id" db:"account_id"`
+	Sent       int       `json:"sent" db:"sent"`
+	Failed     int       `json:"failed" db:"failed"`
+	Bounced    int       `json:"bounced" db:"bounced"`
+	Opened     int       `json:"opened" db:"opened"`
+	Clicked    int       `json:"clicked" db:"clicked"`
+}
+
+// TableName returns the name of the database table for the MessageStats entity.
+func (MessageStats) TableName() string {
+	return "message_stats"
+}
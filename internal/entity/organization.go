@@ -0,0 +1,78 @@
+package entity
+
+import "time"
+
+// Membership roles, from the most to the least privileged. The permissions granted to each role are
+// defined by the auth package.
+const (
+	// RoleOwner can do anything, including deleting the organization.
+	RoleOwner = "owner"
+	// RoleAdmin can manage the organization, its members and its resources.
+	RoleAdmin = "admin"
+	// RoleEditor can edit templates and send messages.
+	RoleEditor = "editor"
+	// RoleSender can send messages using the existing templates.
+	RoleSender = "sender"
+	// RoleViewer has read-only access.
+	RoleViewer = "viewer"
+)
+
+// Roles lists all the membership roles.
+var Roles = []string{RoleOwner, RoleAdmin, RoleEditor, RoleSender, RoleViewer}
+
+// Organization represents a workspace whose members share provider accounts, templates and messages.
+// Every user has a personal organization sharing the user ID.
+type Organization struct {
+	ID        string     `json:"id" db:"id"`
+	Name      string     `json:"name" db:"name"`
+	CreatedAt *time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt *time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// TableName returns the name of the database table for the Organization entity.
+func (Organization) TableName() string {
+	return "organizations"
+}
+
+// GetID returns the organization ID.
+func (o Organization) GetID() string {
+	return o.ID
+}
+
+// Membership represents the membership of a user in an organization.
+type Membership struct {
+	OrgID     string     `json:"org_id" db:"pk,org_id"`
+	UserID    string     `json:"user_id" db:"pk,user_id"`
+	Role      string     `json:"role" db:"role"`
+	CreatedAt *time.Time `json:"created_at" db:"created_at"`
+}
+
+// TableName returns the name of the database table for the Membership entity.
+func (Membership) TableName() string {
+	return "memberships"
+}
+
+// Invitation represents an invitation sent by email to join an organization.
+// Only the hash of the invitation token is stored.
+type Invitation struct {
+	ID         string     `json:"id" db:"id"`
+	OrgID      string     `json:"org_id" db:"org_id"`
+	Email      string     `json:"email" db:"email"`
+	Role       string     `json:"role" db:"role"`
+	TokenHash  string     `json:"-" db:"token_hash"`
+	InvitedBy  string     `json:"invited_by" db:"invited_by"`
+	ExpiresAt  time.Time  `json:"expires_at" db:"expires_at"`
+	AcceptedAt *time.Time `json:"accepted_at" db:"accepted_at"`
+	DeclinedAt *time.Time `json:"declined_at" db:"declined_at"`
+	CreatedAt  *time.Time `json:"created_at" db:"created_at"`
+}
+
+// TableName returns the name of the database table for the Invitation entity.
+func (Invitation) TableName() string {
+	return "invitations"
+}
+
+// IsPending returns whether the invitation was neither answered nor has expired.
+func (i Invitation) IsPending(now time.Time) bool {
+	return i.AcceptedAt == nil && i.DeclinedAt == nil && now.Before(i.ExpiresAt)
+}
@@ -0,0 +1,18 @@
+package entity
+
+import "time"
+
+// RecoveryCode represents a single-use two-factor authentication recovery code.
+// Only the hash of the code is stored.
+type RecoveryCode struct {
+	ID        string     `db:"id"`
+	UserID    string     `db:"user_id"`
+	CodeHash  string     `db:"code_hash"`
+	UsedAt    *time.Time `db:"used_at"`
+	CreatedAt *time.Time `db:"created_at"`
+}
+
+// TableName returns the name of the database table for the RecoveryCode entity.
+func (RecoveryCode) TableName() string {
+	return "user_recovery_codes"
+}
@@ -0,0 +1,48 @@
+package entity
+
+import "time"
+
+// Sender authentication statuses, as reported for the SPF, DKIM and DMARC records of a sender domain.
+const (
+	// AuthPass means the record is published and lets receivers authenticate the messages of the domain.
+	AuthPass = "pass"
+	// AuthFail means the record is published but invalid, or does not match the setup of the organization.
+	AuthFail = "fail"
+	// AuthMissing means the record is not published.
+	AuthMissing = "missing"
+	// AuthUnknown means the record has not been checked yet.
+	AuthUnknown = "unknown"
+)
+
+// SenderDomain represents a domain an organization sends messages from. Messages are only sent from the
+// addresses of a domain once the organization proves it owns it by publishing the token in a DNS TXT record.
+type SenderDomain struct {
+	ID     string `json:"id" db:"id"`
+	OrgID  string `json:"org_id" db:"org_id"`
+	UserID string `json:"user_id" db:"user_id"`
+	Domain string `json:"domain" db:"domain"`
+	// Token is the verification token the organization publishes in a TXT record of the domain.
+	Token      string     `json:"token" db:"token"`
+	VerifiedAt *time.Time `json:"verified_at" db:"verified_at"`
+	// SPF, DKIM and DMARC are the authentication statuses of the domain as of CheckedAt.
+	SPF       string     `json:"spf" db:"spf"`
+	DKIM      string     `json:"dkim" db:"dkim"`
+	DMARC     string     `json:"dmarc" db:"dmarc"`
+	CheckedAt *time.Time `json:"checked_at" db:"checked_at"`
+	CreatedAt time.Time  `json:"created_at" db:"created_at"`
+}
+
+// TableName returns the name of the database table for the SenderDomain entity.
+func (SenderDomain) TableName() string {
+	return "sender_domains"
+}
+
+// GetID returns the sender domain ID.
+func (d SenderDomain) GetID() string {
+	return d.ID
+}
+
+// Verified returns whether the organization proved it owns the domain.
+func (d SenderDomain) Verified() bool {
+	return d.VerifiedAt != nil
+}
@@ -0,0 +1,45 @@
+package entity
+
+import "time"
+
+// Suppression reasons.
+const (
+	// SuppressionUnsubscribed means the recipient unsubscribed.
+	SuppressionUnsubscribed = "unsubscribed"
+	// SuppressionBounced means messages to the address hard-bounced.
+	SuppressionBounced = "bounced"
+	// SuppressionComplained means the recipient reported a message as spam.
+	SuppressionComplained = "complained"
+	// SuppressionManual means a member of the organization suppressed the address.
+	SuppressionManual = "manual"
+)
+
+// SuppressionReasons lists all the suppression reasons.
+var SuppressionReasons = []string{SuppressionUnsubscribed, SuppressionBounced, SuppressionComplained, SuppressionManual}
+
+// Suppression sources.
+const (
+	// SuppressionSourceAPI means the address was added through the API.
+	SuppressionSourceAPI = "api"
+	// SuppressionSourceImport means the address was imported from a file.
+	SuppressionSourceImport = "import"
+	// SuppressionSourceUnsubscribe means the recipient followed an unsubscribe link.
+	SuppressionSourceUnsubscribe = "unsubscribe"
+	// SuppressionSourceBounce means the address was added after a delivery status notification.
+	SuppressionSourceBounce = "bounce"
+)
+
+// Suppression represents an address an organization must not send messages to.
+// Addresses are stored in lower case.
+type Suppression struct {
+	OrgID     string    `json:"org_id" db:"org_id"`
+	Email     string    `json:"email" db:"email"`
+	Reason    string    `json:"reason" db:"reason"`
+	Source    string    `json:"source" db:"source"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+// TableName returns the name of the database table for the Suppression entity.
+func (Suppression) TableName() string {
+	return "suppressions"
+}
@@ -0,0 +1,70 @@
+package entity
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// Template represents an email template owned by an organization.
+type Template struct {
+	ID        string `json:"id" db:"id"`
+	OrgID     string `json:"org_id" db:"org_id"`
+	UserID    string `json:"user_id" db:"user_id"`
+	Name      string `json:"name" db:"name"`
+	Subject   string `json:"subject" db:"subject"`
+	Body      string `json:"body" db:"body"`
+	ToEmail   string `json:"to_email" db:"to_email"`
+	FromEmail string `json:"from_email" db:"from_email"`
+	FromName  string `json:"from_name" db:"from_name"`
+	// Tracking tells whether opens and clicks of the messages sent from the template are tracked.
+	Tracking bool `json:"tracking" db:"tracking"`
+	// Locales holds the variants of the subject and body in other locales. The subject and body above are
+	// the default used for the locales without a variant.
+	Locales   TemplateLocales `json:"locales" db:"locales"`
+	CreatedAt *time.Time      `json:"created_at" db:"created_at"`
+	UpdatedAt *time.Time      `json:"updated_at" db:"updated_at"`
+}
+
+// TableName returns the name of the database table for the Template entity.
+func (Template) TableName() string {
+	return "templates"
+}
+
+// GetID returns the template ID.
+func (t Template) GetID() string {
+	return t.ID
+}
+
+// TemplateVariant is the subject and body of a template in a locale.
+type TemplateVariant struct {
+	Subject string `json:"subject"`
+	Body    string `json:"body"`
+}
+
+// TemplateLocales maps locales such as es-MX to the variants of a template. It is stored in a JSONB column.
+type TemplateLocales map[string]TemplateVariant
+
+// Value implements driver.Valuer. No variants are stored as an empty object.
+func (l TemplateLocales) Value() (driver.Value, error) {
+	if l == nil {
+		return "{}", nil
+	}
+	b, err := json.Marshal(l)
+	return string(b), err
+}
+
+// Scan implements sql.Scanner.
+func (l *TemplateLocales) Scan(src interface{}) error {
+	*l = nil
+	switch v := src.(type) {
+	case nil:
+		return nil
+	case []byte:
+		return json.Unmarshal(v, l)
+	case string:
+		return json.Unmarshal([]byte(v), l)
+	}
+	return errors.New("entity: unsupported TemplateLocales source type")
+}
@@ -4,13 +4,20 @@ import "time"
 
 // User represents a user.
 type User struct {
-	ID        string     `db:"id"`
-	Name      string     `db:"name"`
-	Email     string     `db:"email"`
-	Password  string     `db:"password"`
-	Active    bool       `db:"active"`
-	CreatedAt *time.Time `db:"created_at"`
-	UpdatedAt *time.Time `db:"updated_at"`
+	ID              string     `db:"id"`
+	Name            string     `db:"name"`
+	Email           string     `db:"email"`
+	Password        string     `db:"password"`
+	Active          bool       `db:"active"`
+	IsAdmin         bool       `db:"is_admin"`
+	EmailVerifiedAt *time.Time `db:"email_verified_at"`
+	PendingEmail    string     `db:"pending_email"`
+	TokenVersion    int        `db:"token_version"`
+	TOTPSecret      string     `db:"totp_secret"`
+	TOTPEnabledAt   *time.Time `db:"totp_enabled_at"`
+	TOTPLastStep    int64      `db:"totp_last_step"`
+	CreatedAt       *time.Time `db:"created_at"`
+	UpdatedAt       *time.Time `db:"updated_at"`
 }
 
 // TableName returns the name of the database table for the User entity.
@@ -32,3 +39,14 @@ func (u User) GetName() string {
 func (u User) GetEmail() string {
 	return u.Email
 }
+
+// GetTokenVersion returns the version of the user's access tokens.
+// Bumping the version revokes every token issued before.
+func (u User) GetTokenVersion() int {
+	return u.TokenVersion
+}
+
+// TwoFactorEnabled returns whether the user has enabled TOTP-based two-factor authentication.
+func (u User) TwoFactorEnabled() bool {
+	return u.TOTPEnabledAt != nil
+}
@@ -1,18 +1,32 @@
 package entity
 
-import "time"
+import (
+	"time"
+
+	"github.com/lib/pq"
+)
 
 // UserProviderAccount represents a oauth provider.
 type UserProviderAccount struct {
 	ID           string     `db:"id"`
+	OrgID        string     `db:"org_id"`
 	UserID       string     `db:"user_id"`
+	Name         string     `db:"name"`
 	ProviderID   string     `db:"provider_id"`
 	AccessToken  string     `db:"access_token"`
 	RefreshToken string     `db:"refresh_token"`
 	TokenExpiry  time.Time  `db:"token_expiry"`
 	IsDefault    bool       `db:"is_default"`
+	PausedUntil  *time.Time `db:"paused_until"`
 	CreatedAt    *time.Time `db:"created_at"`
 	UpdatedAt    *time.Time `db:"updated_at"`
+
+	// Scopes lists the OAuth scopes the user granted when linking the account.
+	Scopes pq.StringArray `db:"scopes"`
+	// PolledAt is when the mailbox was last checked for bounces and replies.
+	PolledAt *time.Time `db:"polled_at"`
+	// Email is the address of the mailbox, as reported by the provider when the account was linked.
+	Email string `db:"email"`
 }
 
 // TableName returns the name of the database table for the UserProviderAccount entity.
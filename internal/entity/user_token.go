@@ -0,0 +1,34 @@
+package entity
+
+import "time"
+
+const (
+	// TokenPurposeEmailVerification identifies tokens used to verify a user email address.
+	TokenPurposeEmailVerification = "email_verification"
+	// TokenPurposePasswordReset identifies tokens used to reset a user password.
+	TokenPurposePasswordReset = "password_reset"
+	// TokenPurposeEmailChange identifies tokens used to confirm a new email address.
+	TokenPurposeEmailChange = "email_change"
+)
+
+// UserToken represents a single-use token issued to a user, such as an email verification token.
+// Only the hash of the token is stored.
+type UserToken struct {
+	ID        string     `db:"id"`
+	UserID    string     `db:"user_id"`
+	Purpose   string     `db:"purpose"`
+	TokenHash string     `db:"token_hash"`
+	ExpiresAt time.Time  `db:"expires_at"`
+	UsedAt    *time.Time `db:"used_at"`
+	CreatedAt *time.Time `db:"created_at"`
+}
+
+// TableName returns the name of the database table for the UserToken entity.
+func (UserToken) TableName() string {
+	return "user_tokens"
+}
+
+// IsUsable returns whether the token has not been used yet and has not expired.
+func (t UserToken) IsUsable(now time.Time) bool {
+	return t.UsedAt == nil && now.Before(t.ExpiresAt)
+}
@@ -0,0 +1,85 @@
+package entity
+
+import (
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Events delivered to webhooks.
+const (
+	// WebhookMessageOpened is delivered when a recipient opens a tracked message.
+	WebhookMessageOpened = "message.opened"
+	// WebhookMessageClicked is delivered when a recipient clicks a link of a tracked message.
+	WebhookMessageClicked = "message.clicked"
+	// WebhookMessageInbound is delivered when an organization receives a message.
+	WebhookMessageInbound = "message.inbound"
+)
+
+// WebhookEvents lists the events webhooks can subscribe to.
+var WebhookEvents = []string{WebhookMessageOpened, WebhookMessageClicked, WebhookMessageInbound}
+
+// Webhook delivery statuses.
+const (
+	// DeliveryPending means the delivery waits to be attempted, or retried.
+	DeliveryPending = "pending"
+	// DeliverySending means a dispatcher is attempting the delivery.
+	DeliverySending = "sending"
+	// DeliverySucceeded means the endpoint acknowledged the delivery with a 2xx response.
+	DeliverySucceeded = "succeeded"
+	// DeliveryFailed means the delivery was given up on.
+	DeliveryFailed = "failed"
+)
+
+// Webhook represents an endpoint of an organization notified of the events it subscribes to.
+type Webhook struct {
+	ID     string `json:"id" db:"id"`
+	OrgID  string `json:"org_id" db:"org_id"`
+	UserID string `json:"user_id" db:"user_id"`
+	URL    string `json:"url" db:"url"`
+	// Secret is the key the deliveries are signed with. It is only ever returned when the webhook is created.
+	Secret    string         `json:"-" db:"secret"`
+	Events    pq.StringArray `json:"events" db:"events"`
+	Active    bool           `json:"active" db:"active"`
+	CreatedAt time.Time      `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at" db:"updated_at"`
+}
+
+// TableName returns the name of the database table for the Webhook entity.
+func (Webhook) TableName() string {
+	return "webhooks"
+}
+
+// GetID returns the webhook ID.
+func (w Webhook) GetID() string {
+	return w.ID
+}
+
+// WebhookDelivery represents an event to be delivered, or delivered, to a webhook.
+type WebhookDelivery struct {
+	ID        string `json:"id" db:"id"`
+	OrgID     string `json:"org_id" db:"org_id"`
+	WebhookID string `json:"webhook_id" db:"webhook_id"`
+	Event     string `json:"event" db:"event"`
+	// Payload is the data of the event, sent as the data field of the request body.
+	Payload       JSON      `json:"payload" db:"payload"`
+	Status        string    `json:"status" db:"status"`
+	Attempts      int       `json:"attempts" db:"attempts"`
+	NextAttemptAt time.Time `json:"next_attempt_at" db:"next_attempt_at"`
+	LastError     string    `json:"last_error" db:"last_error"`
+	// ResponseStatus is the HTTP status code of the last response of the endpoint, if any.
+	ResponseStatus int        `json:"response_status" db:"response_status"`
+	DeliveredAt    *time.Time `json:"delivered_at" db:"delivered_at"`
+	CreatedAt      time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at" db:"updated_at"`
+}
+
+// TableName returns the name of the database table for the WebhookDelivery entity.
+func (WebhookDelivery) TableName() string {
+	return "webhook_deliveries"
+}
+
+// GetID returns the webhook delivery ID.
+func (d WebhookDelivery) GetID() string {
+	return d.ID
+}
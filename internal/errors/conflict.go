@@ -0,0 +1,25 @@
+package errors
+
+import (
+	"errors"
+
+	"github.com/lib/pq"
+)
+
+// uniqueViolation is the Postgres error code raised when a unique constraint is violated.
+const uniqueViolation = "23505"
+
+// IsUniqueViolation returns whether the error was caused by a violated unique constraint.
+func IsUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == uniqueViolation
+}
+
+// MapConflict converts a unique constraint violation into a Conflict error response with the given message.
+// Any other error is returned unchanged.
+func MapConflict(err error, msg string) error {
+	if IsUniqueViolation(err) {
+		return Conflict(msg)
+	}
+	return err
+}
@@ -0,0 +1,29 @@
+package errors
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsUniqueViolation(t *testing.T) {
+	assert.True(t, IsUniqueViolation(&pq.Error{Code: "23505"}))
+	assert.True(t, IsUniqueViolation(fmt.Errorf("wrapped: %w", &pq.Error{Code: "23505"})))
+	assert.False(t, IsUniqueViolation(&pq.Error{Code: "23503"}))
+	assert.False(t, IsUniqueViolation(fmt.Errorf("test")))
+	assert.False(t, IsUniqueViolation(nil))
+}
+
+func TestMapConflict(t *testing.T) {
+	err := MapConflict(&pq.Error{Code: "23505"}, "already exists")
+	if assert.IsType(t, ErrorResponse{}, err) {
+		assert.Equal(t, http.StatusConflict, err.(ErrorResponse).StatusCode())
+		assert.Equal(t, "already exists", err.Error())
+	}
+	other := fmt.Errorf("test")
+	assert.Equal(t, other, MapConflict(other, "already exists"))
+	assert.Nil(t, MapConflict(nil, "already exists"))
+}
@@ -65,5 +65,8 @@ func buildErrorResponse(err error) ErrorResponse {
 	if errors.Is(err, sql.ErrNoRows) {
 		return NotFound("")
 	}
+	if IsUniqueViolation(err) {
+		return Conflict("")
+	}
 	return InternalServerError("")
 }
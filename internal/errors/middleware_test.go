@@ -10,6 +10,7 @@ import (
 	"github.com/garaekz/gonvelope/pkg/log"
 	routing "github.com/garaekz/ozzo-routing"
 	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/lib/pq"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -67,6 +68,9 @@ func Test_buildErrorResponse(t *testing.T) {
 	res = buildErrorResponse(sql.ErrNoRows)
 	assert.Equal(t, http.StatusNotFound, res.Status)
 
+	res = buildErrorResponse(fmt.Errorf("insert: %w", &pq.Error{Code: "23505"}))
+	assert.Equal(t, http.StatusConflict, res.Status)
+
 	res = buildErrorResponse(fmt.Errorf("test"))
 	assert.Equal(t, http.StatusInternalServerError, res.Status)
 }
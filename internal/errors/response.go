@@ -78,6 +78,39 @@ func BadRequest(msg string) ErrorResponse {
 	}
 }
 
+// Conflict creates a new error response representing a conflict with the current state of a resource (HTTP 409)
+func Conflict(msg string) ErrorResponse {
+	if msg == "" {
+		msg = "The request conflicts with an existing resource."
+	}
+	return ErrorResponse{
+		Status:  http.StatusConflict,
+		Message: msg,
+	}
+}
+
+// TooManyRequests creates a new error response representing a rate limited request (HTTP 429)
+func TooManyRequests(msg string) ErrorResponse {
+	if msg == "" {
+		msg = "You have made too many requests. Please try again later."
+	}
+	return ErrorResponse{
+		Status:  http.StatusTooManyRequests,
+		Message: msg,
+	}
+}
+
+// RequestEntityTooLarge creates a new error response representing a request body exceeding a size limit (HTTP 413)
+func RequestEntityTooLarge(msg string) ErrorResponse {
+	if msg == "" {
+		msg = "The request is larger than the server is willing to process."
+	}
+	return ErrorResponse{
+		Status:  http.StatusRequestEntityTooLarge,
+		Message: msg,
+	}
+}
+
 type invalidField struct {
 	Field string `json:"field"`
 	Error string `json:"error"`
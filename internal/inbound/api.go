@@ -0,0 +1,113 @@
+package inbound
+
+import (
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+
+	"github.com/garaekz/gonvelope/internal/auth"
+	"github.com/garaekz/gonvelope/internal/entity"
+	"github.com/garaekz/gonvelope/internal/errors"
+	"github.com/garaekz/gonvelope/pkg/log"
+	"github.com/garaekz/gonvelope/pkg/pagination"
+	routing "github.com/garaekz/ozzo-routing"
+)
+
+// RegisterHandlers sets up the routing of the HTTP handlers.
+// Inbound messages belong to the organization made active by orgHandler.
+func RegisterHandlers(r *routing.RouteGroup, service Service, authHandler, orgHandler routing.Handler, logger log.Logger) {
+	res := resource{service, logger}
+
+	r.Use(authHandler, orgHandler)
+
+	r.Get("inbound", auth.Require(auth.ScopeInboundRead), res.query)
+	r.Get("inbound/<id>", auth.Require(auth.ScopeInboundRead), res.get)
+	r.Get("inbound/<id>/raw", auth.Require(auth.ScopeInboundRead), res.raw)
+	r.Get("inbound/<id>/attachments/<index>", auth.Require(auth.ScopeInboundRead), res.attachment)
+	r.Post("inbound", auth.Require(auth.ScopeInboundWrite), res.receive)
+}
+
+type resource struct {
+	service Service
+	logger  log.Logger
+}
+
+func (r resource) get(c *routing.Context) error {
+	message, err := r.service.Get(c.Request.Context(), currentMembership(c).OrgID, c.Param("id"))
+	if err != nil {
+		return err
+	}
+	return c.Write(message)
+}
+
+func (r resource) query(c *routing.Context) error {
+	ctx := c.Request.Context()
+	orgID := currentMembership(c).OrgID
+	count, err := r.service.Count(ctx, orgID)
+	if err != nil {
+		return err
+	}
+	pages := pagination.NewFromRequest(c.Request, count)
+	messages, err := r.service.Query(ctx, orgID, pages.Offset(), pages.Limit())
+	if err != nil {
+		return err
+	}
+	pages.Items = messages
+	return c.Write(pages)
+}
+
+// raw writes the message as it was received.
+func (r resource) raw(c *routing.Context) error {
+	body, err := r.service.Raw(c.Request.Context(), currentMembership(c).OrgID, c.Param("id"))
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	c.Response.Header().Set("Content-Type", "message/rfc822")
+	c.Response.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": c.Param("id") + ".eml"}))
+	if _, err := io.Copy(c.Response, body); err != nil {
+		// the response has already started, so the error can only be logged
+		r.logger.With(c.Request.Context()).Errorf("failed to write inbound message: %v", err)
+	}
+	return nil
+}
+
+// attachment writes the decoded content of an attachment, never rendered inline by browsers.
+func (r resource) attachment(c *routing.Context) error {
+	index, err := strconv.Atoi(c.Param("index"))
+	if err != nil {
+		return errors.NotFound("")
+	}
+	attachment, err := r.service.Attachment(c.Request.Context(), currentMembership(c).OrgID, c.Param("id"), index)
+	if err != nil {
+		return err
+	}
+	header := c.Response.Header()
+	header.Set("Content-Type", attachment.ContentType)
+	header.Set("Content-Length", strconv.Itoa(attachment.Size))
+	header.Set("X-Content-Type-Options", "nosniff")
+	params := map[string]string{}
+	if attachment.Filename != "" {
+		params["filename"] = attachment.Filename
+	}
+	header.Set("Content-Disposition", mime.FormatMediaType("attachment", params))
+	_, err = c.Response.Write(attachment.Content)
+	return err
+}
+
+// receive ingests the raw MIME message sent as the request body.
+func (r resource) receive(c *routing.Context) error {
+	membership := currentMembership(c)
+	message, err := r.service.Receive(c.Request.Context(), membership.OrgID, membership.UserID, c.Request.Body)
+	if err != nil {
+		return err
+	}
+	return c.WriteWithStatus(message, http.StatusCreated)
+}
+
+// currentMembership returns the membership of the current user in the active organization.
+func currentMembership(c *routing.Context) entity.Membership {
+	membership, _ := auth.CurrentMembership(c.Request.Context())
+	return membership
+}
@@ -0,0 +1,52 @@
+package inbound
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/garaekz/gonvelope/internal/auth"
+	"github.com/garaekz/gonvelope/internal/entity"
+	"github.com/garaekz/gonvelope/internal/org"
+	"github.com/garaekz/gonvelope/internal/test"
+	"github.com/garaekz/gonvelope/pkg/blob"
+	"github.com/garaekz/gonvelope/pkg/log"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAPI(t *testing.T) {
+	logger, _ := log.NewForTest()
+	router := test.MockRouter(logger)
+	store, err := blob.NewFileSystem(t.TempDir())
+	require.Nil(t, err)
+	raw, err := os.ReadFile("testdata/reply.eml")
+	require.Nil(t, err)
+	repo := &mockRepository{items: []entity.InboundMessage{
+		{ID: "i1", OrgID: "100", Source: entity.InboundAPI, FromEmail: "jane@example.org", Subject: "Re: Your invoice", CreatedAt: time.Now()},
+		{ID: "i2", OrgID: "org1", Source: entity.InboundAPI, FromEmail: "john@example.org", CreatedAt: time.Now()},
+	}}
+	require.Nil(t, store.Put(context.Background(), "inbound/100/i1", bytes.NewReader(raw)))
+	RegisterHandlers(router.Group("/"), NewService(repo, mockTransactional, store, (&mockEmitter{}).Emit, &mockRecorder{}, logger), auth.MockAuthHandler, org.MockHandler, logger)
+	header := auth.MockAuthHeader()
+
+	tests := []test.APITestCase{
+		{Name: "get", Method: "GET", URL: "/inbound/i1", Header: header, WantStatus: http.StatusOK, WantResponse: `*"from_email":"jane@example.org"*`},
+		{Name: "get other org", Method: "GET", URL: "/inbound/i2", Header: header, WantStatus: http.StatusNotFound},
+		{Name: "query", Method: "GET", URL: "/inbound", Header: header, WantStatus: http.StatusOK, WantResponse: `*"total_count":1*`},
+		{Name: "raw", Method: "GET", URL: "/inbound/i1/raw", Header: header, WantStatus: http.StatusOK, WantResponse: `*Message-ID: <CAF=reply.42@mail.example.org>*`},
+		{Name: "attachment", Method: "GET", URL: "/inbound/i1/attachments/1", Header: header, WantStatus: http.StatusOK, WantResponse: `%PDF-1.4*`},
+		{Name: "attachment out of range", Method: "GET", URL: "/inbound/i1/attachments/5", Header: header, WantStatus: http.StatusNotFound},
+		{Name: "attachment invalid index", Method: "GET", URL: "/inbound/i1/attachments/x", Header: header, WantStatus: http.StatusNotFound},
+		{Name: "receive", Method: "POST", URL: "/inbound", Body: "From: john@example.org\nSubject: Hi\n\nHello", Header: header, WantStatus: http.StatusCreated, WantResponse: `*"text":"Hello"*`},
+		{Name: "receive invalid", Method: "POST", URL: "/inbound", Body: "not a message", Header: header, WantStatus: http.StatusBadRequest},
+		{Name: "viewer get", Method: "GET", URL: "/inbound/i1", Header: org.MockHeader(entity.RoleViewer), WantStatus: http.StatusForbidden},
+		{Name: "sender receive", Method: "POST", URL: "/inbound", Body: "Subject: Hi\n\nHello", Header: org.MockHeader(entity.RoleSender), WantStatus: http.StatusForbidden},
+		{Name: "unauthorized", Method: "GET", URL: "/inbound/i1", WantStatus: http.StatusUnauthorized},
+	}
+	for _, tc := range tests {
+		test.Endpoint(t, router, tc)
+	}
+}
@@ -0,0 +1,233 @@
+package inbound
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/garaekz/gonvelope/internal/entity"
+	"golang.org/x/text/encoding/htmlindex"
+)
+
+// maxDepth is how deeply multipart bodies are walked. Deeper parts are ignored.
+const maxDepth = 10
+
+// messageIDPattern matches a Message-ID, including its angle brackets.
+var messageIDPattern = regexp.MustCompile(`<[^<>\s]+>`)
+
+// wordDecoder decodes the RFC 2047 encoded words of the headers in any charset known to browsers.
+var wordDecoder = &mime.WordDecoder{CharsetReader: charsetReader}
+
+// Attachment describes a file attached to an inbound message.
+type Attachment struct {
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"`
+	// ContentID is the Content-ID of the attachment without angle brackets. The HTML body refers to
+	// inline attachments as cid:ContentID.
+	ContentID string `json:"content_id"`
+	Inline    bool   `json:"inline"`
+	// Size is the size of the decoded content in bytes.
+	Size int `json:"size"`
+	// Content is the decoded content of the attachment.
+	Content []byte `json:"-"`
+}
+
+// parsed is a raw message parsed into the fields of an inbound message, along with the content of its attachments.
+type parsed struct {
+	message     entity.InboundMessage
+	attachments []Attachment
+}
+
+// parse parses a raw MIME message. Parts that cannot be decoded are kept as they are rather than failing
+// the whole message, so only messages whose header cannot be read are rejected.
+func parse(raw []byte) (parsed, error) {
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return parsed{}, err
+	}
+	header := textproto.MIMEHeader(msg.Header)
+
+	message := entity.InboundMessage{
+		MessageID:  firstMessageID(header.Get("Message-ID")),
+		InReplyTo:  firstMessageID(header.Get("In-Reply-To")),
+		References: append([]string{}, messageIDPattern.FindAllString(header.Get("References"), -1)...),
+		Subject:    decodeHeader(header.Get("Subject")),
+		ReplyTo:    addresses(header.Get("Reply-To")),
+		To:         addresses(header.Get("To")),
+		Cc:         addresses(header.Get("Cc")),
+		Size:       int64(len(raw)),
+	}
+	if from, err := parser().Parse(header.Get("From")); err == nil {
+		message.FromEmail = strings.ToLower(from.Address)
+		message.FromName = from.Name
+	}
+	if date, err := msg.Header.Date(); err == nil {
+		message.SentAt = &date
+	}
+	headers := map[string][]string{}
+	for name, values := range header {
+		for _, value := range values {
+			headers[name] = append(headers[name], decodeHeader(value))
+		}
+	}
+	if message.Headers, err = entity.NewJSON(headers); err != nil {
+		return parsed{}, err
+	}
+
+	p := parsed{message: message}
+	p.walk(header, msg.Body, 0)
+	if p.attachments == nil {
+		p.attachments = []Attachment{}
+	}
+	if p.message.Attachments, err = entity.NewJSON(p.attachments); err != nil {
+		return parsed{}, err
+	}
+	return p, nil
+}
+
+// walk collects the bodies and the attachments of a part and of the parts nested in it.
+// The first plain text and HTML parts that are not attachments become the bodies.
+func (p *parsed) walk(header textproto.MIMEHeader, body io.Reader, depth int) {
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		mediaType, params = "text/plain", map[string]string{}
+	}
+	if strings.HasPrefix(mediaType, "multipart/") {
+		if depth >= maxDepth || params["boundary"] == "" {
+			return
+		}
+		parts := multipart.NewReader(body, params["boundary"])
+		for {
+			// raw parts keep their transfer encoding, which is decoded below like that of a whole message
+			part, err := parts.NextRawPart()
+			if err != nil {
+				// a truncated or malformed multipart body keeps the parts read so far
+				return
+			}
+			p.walk(part.Header, part, depth+1)
+		}
+	}
+
+	content, _ := io.ReadAll(decodeTransfer(body, header.Get("Content-Transfer-Encoding")))
+	disposition, dispositionParams, _ := mime.ParseMediaType(header.Get("Content-Disposition"))
+	filename := decodeHeader(dispositionParams["filename"])
+	if filename == "" {
+		filename = decodeHeader(params["name"])
+	}
+	if disposition != "attachment" && filename == "" {
+		switch {
+		case mediaType == "text/plain" && p.message.Text == "":
+			p.message.Text = decodeText(content, params["charset"])
+			return
+		case mediaType == "text/html" && p.message.HTML == "":
+			p.message.HTML = decodeText(content, params["charset"])
+			return
+		}
+	}
+	p.attachments = append(p.attachments, Attachment{
+		Filename:    filename,
+		ContentType: mediaType,
+		ContentID:   strings.Trim(header.Get("Content-ID"), "<> "),
+		Inline:      disposition == "inline",
+		Size:        len(content),
+		Content:     content,
+	})
+}
+
+// decodeTransfer returns a reader decoding the body according to its Content-Transfer-Encoding.
+func decodeTransfer(body io.Reader, encoding string) io.Reader {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "base64":
+		// the decoder skips line breaks, and a body cut short keeps what could be decoded
+		return base64.NewDecoder(base64.StdEncoding, body)
+	case "quoted-printable":
+		return quotedprintable.NewReader(body)
+	default:
+		return body
+	}
+}
+
+// decodeText converts text in the given charset to UTF-8 that can be stored in the database.
+// Text in an unknown charset is kept as it is, with its invalid sequences replaced.
+func decodeText(content []byte, charset string) string {
+	if r, err := charsetReader(charset, bytes.NewReader(content)); err == nil {
+		if decoded, err := io.ReadAll(r); err == nil {
+			content = decoded
+		}
+	}
+	text := string(content)
+	if !utf8.ValidString(text) {
+		text = strings.ToValidUTF8(text, "�")
+	}
+	return strings.ReplaceAll(text, "\x00", "")
+}
+
+// charsetReader returns a reader converting text in the given charset to UTF-8.
+func charsetReader(charset string, input io.Reader) (io.Reader, error) {
+	switch strings.ToLower(charset) {
+	case "", "utf-8", "us-ascii":
+		return input, nil
+	}
+	encoding, err := htmlindex.Get(charset)
+	if err != nil {
+		return nil, fmt.Errorf("unsupported charset %q", charset)
+	}
+	return encoding.NewDecoder().Reader(input), nil
+}
+
+// decodeHeader decodes the RFC 2047 encoded words of a header value, keeping the value as it is if it is invalid.
+func decodeHeader(value string) string {
+	decoded, err := wordDecoder.DecodeHeader(value)
+	if err != nil {
+		return value
+	}
+	return decoded
+}
+
+// parser returns an address parser decoding the encoded words of display names.
+func parser() *mail.AddressParser {
+	return &mail.AddressParser{WordDecoder: wordDecoder}
+}
+
+// addresses returns the lowercase email addresses of an address list header. An invalid list yields none.
+func addresses(value string) []string {
+	emails := []string{}
+	if value == "" {
+		return emails
+	}
+	list, err := parser().ParseList(value)
+	if err != nil {
+		return emails
+	}
+	for _, address := range list {
+		emails = append(emails, strings.ToLower(address.Address))
+	}
+	return emails
+}
+
+// firstMessageID returns the first Message-ID found in a header value, if any.
+func firstMessageID(value string) string {
+	return messageIDPattern.FindString(value)
+}
+
+// threadIDs returns the Message-IDs of the messages the message replies to, the most direct one first.
+func threadIDs(message entity.InboundMessage) []string {
+	var ids []string
+	if message.InReplyTo != "" {
+		ids = append(ids, message.InReplyTo)
+	}
+	// the last reference is the message being replied to
+	for i := len(message.References) - 1; i >= 0; i-- {
+		ids = append(ids, message.References[i])
+	}
+	return ids
+}
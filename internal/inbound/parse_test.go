@@ -0,0 +1,89 @@
+package inbound
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_parse(t *testing.T) {
+	raw, err := os.ReadFile("testdata/reply.eml")
+	require.Nil(t, err)
+
+	p, err := parse(raw)
+	require.Nil(t, err)
+	m := p.message
+	assert.Equal(t, "<CAF=reply.42@mail.example.org>", m.MessageID)
+	assert.Equal(t, "<1760814004.abcdef@example.com>", m.InReplyTo)
+	assert.Equal(t, []string{"<thread-start@example.com>", "<1760814004.abcdef@example.com>"}, []string(m.References))
+	assert.Equal(t, "jane@example.org", m.FromEmail)
+	assert.Equal(t, "Jane Domínguez", m.FromName)
+	assert.Equal(t, []string{"sales@example.com", "ops@example.com"}, []string(m.To))
+	assert.Equal(t, []string{"boss@example.org"}, []string(m.Cc))
+	assert.Equal(t, []string{}, []string(m.ReplyTo))
+	assert.Equal(t, "Re: Your invoice ✓", m.Subject)
+	assert.Equal(t, "Gracias, señor. The invoice looks right.", m.Text)
+	assert.Equal(t, `<p>Gracias, señor. The invoice looks right.</p><img src="cid:logo@example.org">`, m.HTML)
+	require.NotNil(t, m.SentAt)
+	assert.True(t, time.Date(2026, 10, 18, 19, 5, 0, 0, time.UTC).Equal(*m.SentAt))
+	assert.Equal(t, int64(len(raw)), m.Size)
+
+	var headers map[string][]string
+	require.Nil(t, json.Unmarshal(m.Headers, &headers))
+	assert.Equal(t, []string{"Re: Your invoice ✓"}, headers["Subject"])
+	assert.Equal(t, []string{"<jane@example.org>"}, headers["Return-Path"])
+
+	if assert.Len(t, p.attachments, 2) {
+		logo := p.attachments[0]
+		assert.Equal(t, "image/gif", logo.ContentType)
+		assert.Equal(t, "logo@example.org", logo.ContentID)
+		assert.True(t, logo.Inline)
+		assert.Equal(t, "GIF89a", string(logo.Content[:6]))
+
+		invoice := p.attachments[1]
+		assert.Equal(t, "factura nº 42.pdf", invoice.Filename)
+		assert.Equal(t, "application/pdf", invoice.ContentType)
+		assert.False(t, invoice.Inline)
+		assert.Equal(t, "%PDF-1.4", string(invoice.Content[:8]))
+		assert.Equal(t, len(invoice.Content), invoice.Size)
+	}
+	// the content of the attachments is left out of the normalized message
+	assert.JSONEq(t, `[
+		{"filename":"","content_type":"image/gif","content_id":"logo@example.org","inline":true,"size":43},
+		{"filename":"factura nº 42.pdf","content_type":"application/pdf","content_id":"","inline":false,"size":19}
+	]`, string(m.Attachments))
+	assert.Equal(t, []string{"<1760814004.abcdef@example.com>", "<thread-start@example.com>"}, threadIDs(m)[1:])
+}
+
+func Test_parse_lenient(t *testing.T) {
+	tests := []struct {
+		name     string
+		raw      string
+		wantText string
+		wantErr  bool
+	}{
+		{"plain", "From: a@example.com\nSubject: Hi\n\nHello", "Hello", false},
+		{"no content type", "Subject: Hi\nContent-Type: ???\n\nHello", "Hello", false},
+		{"unknown charset", "Content-Type: text/plain; charset=x-unknown\n\nHello \xff", "Hello �", false},
+		{"nul bytes", "Subject: Hi\n\nHel\x00lo", "Hello", false},
+		{"truncated multipart", "Content-Type: multipart/alternative; boundary=b\n\n--b\nContent-Type: text/plain\n\nHello\n--b\nContent-Type: text/html\n\n<p>Hel", "Hello", false},
+		{"truncated base64", "Content-Transfer-Encoding: base64\n\nSGVsbG8gd29y", "Hello wor", false},
+		{"no header", "not a message", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := parse([]byte(tt.raw))
+			if tt.wantErr {
+				assert.NotNil(t, err)
+				return
+			}
+			require.Nil(t, err)
+			assert.Equal(t, tt.wantText, p.message.Text)
+			assert.NotNil(t, p.message.References)
+		})
+	}
+}
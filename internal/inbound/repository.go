@@ -0,0 +1,93 @@
+package inbound
+
+import (
+	"context"
+
+	"github.com/garaekz/gonvelope/internal/entity"
+	"github.com/garaekz/gonvelope/pkg/dbcontext"
+	"github.com/garaekz/gonvelope/pkg/log"
+	dbx "github.com/go-ozzo/ozzo-dbx"
+)
+
+// Repository encapsulates the logic to access inbound messages from the data source.
+type Repository interface {
+	// Get returns the inbound message with the specified ID owned by the organization.
+	Get(ctx context.Context, orgID, id string) (entity.InboundMessage, error)
+	// Count returns the number of inbound messages owned by the organization.
+	Count(ctx context.Context, orgID string) (int, error)
+	// Query returns the inbound messages owned by the organization with the given offset and limit, newest first.
+	Query(ctx context.Context, orgID string, offset, limit int) ([]entity.InboundMessage, error)
+	// Create saves a new inbound message in the storage.
+	Create(ctx context.Context, message entity.InboundMessage) error
+	// HasSource returns whether the mailbox message with the given provider ID was already ingested from the account.
+	HasSource(ctx context.Context, accountID, sourceID string) (bool, error)
+	// FindOutbound returns the message sent by the organization with one of the given Message-IDs.
+	FindOutbound(ctx context.Context, orgID string, messageIDs []string) (entity.Message, error)
+}
+
+// repository persists inbound messages in database
+type repository struct {
+	db     *dbcontext.DB
+	logger log.Logger
+}
+
+// NewRepository creates a new inbound message repository
+func NewRepository(db *dbcontext.DB, logger log.Logger) Repository {
+	return repository{db, logger}
+}
+
+// Get returns the inbound message with the specified ID owned by the organization.
+func (r repository) Get(ctx context.Context, orgID, id string) (entity.InboundMessage, error) {
+	var message entity.InboundMessage
+	err := r.db.With(ctx).Select().From(message.TableName()).
+		Where(dbx.HashExp{"id": id, "org_id": orgID}).One(&message)
+	return message, err
+}
+
+// Count returns the number of inbound messages owned by the organization.
+func (r repository) Count(ctx context.Context, orgID string) (int, error) {
+	var count int
+	err := r.db.With(ctx).Select("COUNT(*)").From(entity.InboundMessage{}.TableName()).
+		Where(dbx.HashExp{"org_id": orgID}).Row(&count)
+	return count, err
+}
+
+// Query returns the inbound messages owned by the organization with the given offset and limit, newest first.
+func (r repository) Query(ctx context.Context, orgID string, offset, limit int) ([]entity.InboundMessage, error) {
+	var messages []entity.InboundMessage
+	err := r.db.With(ctx).Select().From(entity.InboundMessage{}.TableName()).
+		Where(dbx.HashExp{"org_id": orgID}).
+		OrderBy("created_at DESC", "id DESC").
+		Offset(int64(offset)).
+		Limit(int64(limit)).
+		All(&messages)
+	return messages, err
+}
+
+// Create saves a new inbound message in the storage.
+func (r repository) Create(ctx context.Context, message entity.InboundMessage) error {
+	return r.db.With(ctx).Model(&message).Insert()
+}
+
+// HasSource returns whether the mailbox message with the given provider ID was already ingested from the account.
+func (r repository) HasSource(ctx context.Context, accountID, sourceID string) (bool, error) {
+	var count int
+	err := r.db.With(ctx).Select("COUNT(*)").From(entity.InboundMessage{}.TableName()).
+		Where(dbx.HashExp{"account_id": accountID, "source_id": sourceID}).Row(&count)
+	return count > 0, err
+}
+
+// FindOutbound returns the message sent by the organization with one of the given Message-IDs.
+func (r repository) FindOutbound(ctx context.Context, orgID string, messageIDs []string) (entity.Message, error) {
+	var message entity.Message
+	ids := make([]interface{}, len(messageIDs))
+	for i, id := range messageIDs {
+		ids[i] = id
+	}
+	err := r.db.With(ctx).Select().From(message.TableName()).
+		Where(dbx.HashExp{"org_id": orgID, "message_id": ids}).
+		OrderBy("created_at DESC").
+		Limit(1).
+		One(&message)
+	return message, err
+}
@@ -0,0 +1,199 @@
+// Package inbound ingests the messages organizations receive, either read from the mailboxes of their linked
+// provider accounts or posted to the API as raw MIME. Messages are normalized, threaded to the messages
+// they reply to and forwarded to the webhooks of the organization.
+package inbound
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/garaekz/gonvelope/internal/audit"
+	"github.com/garaekz/gonvelope/internal/entity"
+	"github.com/garaekz/gonvelope/internal/errors"
+	"github.com/garaekz/gonvelope/pkg/blob"
+	"github.com/garaekz/gonvelope/pkg/dbcontext"
+	"github.com/garaekz/gonvelope/pkg/log"
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+)
+
+// maxSize is the maximum size in bytes of a raw message posted to the API.
+const maxSize = 25 << 20
+
+// Service encapsulates usecase logic for inbound messages.
+type Service interface {
+	Get(ctx context.Context, orgID, id string) (entity.InboundMessage, error)
+	Query(ctx context.Context, orgID string, offset, limit int) ([]entity.InboundMessage, error)
+	Count(ctx context.Context, orgID string) (int, error)
+	// Raw returns the raw MIME message of an inbound message of the organization. The caller must close it.
+	Raw(ctx context.Context, orgID, id string) (io.ReadCloser, error)
+	// Attachment returns the attachment of an inbound message of the organization at the given index, with its content.
+	Attachment(ctx context.Context, orgID, id string, index int) (Attachment, error)
+	// Receive ingests a raw MIME message posted by a user of the organization.
+	Receive(ctx context.Context, orgID, userID string, r io.Reader) (entity.InboundMessage, error)
+	// Ingest ingests a raw MIME message read from the mailbox of a provider account of the organization.
+	// Mailbox messages already ingested are skipped.
+	Ingest(ctx context.Context, orgID, accountID, sourceID string, raw []byte) error
+}
+
+// EmitFunc queues the delivery of an event of the organization to its webhooks.
+type EmitFunc func(ctx context.Context, orgID, event string, data interface{}) error
+
+type service struct {
+	repo          Repository
+	transactional dbcontext.TransactionFunc
+	store         blob.Store
+	emit          EmitFunc
+	auditor       audit.Recorder
+	logger        log.Logger
+}
+
+// NewService creates a new inbound message service. The raw messages are kept in store.
+func NewService(repo Repository, transactional dbcontext.TransactionFunc, store blob.Store, emit EmitFunc, auditor audit.Recorder, logger log.Logger) Service {
+	return service{repo, transactional, store, emit, auditor, logger}
+}
+
+// Get returns the inbound message with the specified ID owned by the organization.
+func (s service) Get(ctx context.Context, orgID, id string) (entity.InboundMessage, error) {
+	return s.repo.Get(ctx, orgID, id)
+}
+
+// Query returns the inbound messages owned by the organization with the specified offset and limit, newest first.
+func (s service) Query(ctx context.Context, orgID string, offset, limit int) ([]entity.InboundMessage, error) {
+	return s.repo.Query(ctx, orgID, offset, limit)
+}
+
+// Count returns the number of inbound messages owned by the organization.
+func (s service) Count(ctx context.Context, orgID string) (int, error) {
+	return s.repo.Count(ctx, orgID)
+}
+
+// Raw returns the raw MIME message of an inbound message of the organization. The caller must close it.
+func (s service) Raw(ctx context.Context, orgID, id string) (io.ReadCloser, error) {
+	message, err := s.repo.Get(ctx, orgID, id)
+	if err != nil {
+		return nil, err
+	}
+	return s.store.Get(ctx, blobKey(message))
+}
+
+// Attachment returns the attachment of an inbound message of the organization at the given index, with its content.
+// The content is decoded from the raw message again rather than stored twice.
+func (s service) Attachment(ctx context.Context, orgID, id string, index int) (Attachment, error) {
+	r, err := s.Raw(ctx, orgID, id)
+	if err != nil {
+		return Attachment{}, err
+	}
+	raw, err := io.ReadAll(r)
+	_ = r.Close()
+	if err != nil {
+		return Attachment{}, err
+	}
+	p, err := parse(raw)
+	if err != nil {
+		return Attachment{}, err
+	}
+	if index < 0 || index >= len(p.attachments) {
+		return Attachment{}, errors.NotFound("")
+	}
+	return p.attachments[index], nil
+}
+
+// Receive ingests a raw MIME message posted by a user of the organization.
+func (s service) Receive(ctx context.Context, orgID, userID string, r io.Reader) (entity.InboundMessage, error) {
+	raw, err := io.ReadAll(io.LimitReader(r, maxSize+1))
+	if err != nil {
+		return entity.InboundMessage{}, err
+	}
+	if len(raw) > maxSize {
+		return entity.InboundMessage{}, errors.RequestEntityTooLarge(fmt.Sprintf("The message must not exceed %d bytes.", maxSize))
+	}
+	p, err := parse(raw)
+	if err != nil {
+		return entity.InboundMessage{}, errors.InvalidInput(validation.Errors{
+			"message": validation.NewError("validation_message_invalid", "is not a valid MIME message"),
+		})
+	}
+	message, err := s.save(ctx, orgID, "", entity.InboundAPI, "", p, raw, func(ctx context.Context, message entity.InboundMessage) error {
+		return s.auditor.Record(ctx, audit.Event{
+			Action:     audit.ActionInboundReceived,
+			OrgID:      orgID,
+			ActorID:    userID,
+			TargetType: "inbound_message",
+			TargetID:   message.ID,
+			Data:       map[string]interface{}{"from": message.FromEmail, "subject": message.Subject, "size": message.Size},
+		})
+	})
+	return message, err
+}
+
+// Ingest ingests a raw MIME message read from the mailbox of a provider account of the organization.
+// Mailbox messages already ingested are skipped, as are those whose header cannot be read.
+func (s service) Ingest(ctx context.Context, orgID, accountID, sourceID string, raw []byte) error {
+	ok, err := s.repo.HasSource(ctx, accountID, sourceID)
+	if err != nil || ok {
+		return err
+	}
+	p, err := parse(raw)
+	if err != nil {
+		s.logger.With(ctx, "account_id", accountID).Infof("skipping unreadable inbound message %s: %v", sourceID, err)
+		return nil
+	}
+	_, err = s.save(ctx, orgID, accountID, entity.InboundMailbox, sourceID, p, raw, nil)
+	if errors.IsUniqueViolation(err) {
+		// another poller ingested the message in the meantime
+		return nil
+	}
+	return err
+}
+
+// save threads the parsed message, stores the raw message and saves the inbound message, then emits it to
+// the webhooks of the organization in the same transaction as record, if any.
+func (s service) save(ctx context.Context, orgID, accountID, source, sourceID string, p parsed, raw []byte,
+	record func(ctx context.Context, message entity.InboundMessage) error) (entity.InboundMessage, error) {
+	message := p.message
+	message.ID = entity.GenerateID()
+	message.OrgID = orgID
+	message.AccountID = accountID
+	message.Source = source
+	message.SourceID = sourceID
+	message.CreatedAt = time.Now()
+	if ids := threadIDs(message); len(ids) > 0 {
+		outbound, err := s.repo.FindOutbound(ctx, orgID, ids)
+		if err != nil && err != sql.ErrNoRows {
+			return entity.InboundMessage{}, err
+		}
+		message.OutboundID = outbound.ID
+	}
+
+	key := blobKey(message)
+	if err := s.store.Put(ctx, key, bytes.NewReader(raw)); err != nil {
+		return entity.InboundMessage{}, err
+	}
+	err := s.transactional(ctx, func(ctx context.Context) error {
+		if err := s.repo.Create(ctx, message); err != nil {
+			return err
+		}
+		if record != nil {
+			if err := record(ctx, message); err != nil {
+				return err
+			}
+		}
+		return s.emit(ctx, orgID, entity.WebhookMessageInbound, message)
+	})
+	if err != nil {
+		if err := s.store.Delete(ctx, key); err != nil {
+			s.logger.With(ctx, "key", key).Errorf("failed to delete inbound message file: %v", err)
+		}
+		return entity.InboundMessage{}, err
+	}
+	return message, nil
+}
+
+// blobKey returns the key the raw message of the inbound message is stored under.
+func blobKey(message entity.InboundMessage) string {
+	return "inbound/" + message.OrgID + "/" + message.ID
+}
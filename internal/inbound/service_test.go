@@ -0,0 +1,216 @@
+package inbound
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/garaekz/gonvelope/internal/audit"
+	"github.com/garaekz/gonvelope/internal/entity"
+	errs "github.com/garaekz/gonvelope/internal/errors"
+	"github.com/garaekz/gonvelope/pkg/blob"
+	"github.com/garaekz/gonvelope/pkg/log"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_service_Receive(t *testing.T) {
+	logger, _ := log.NewForTest()
+	store, err := blob.NewFileSystem(t.TempDir())
+	require.Nil(t, err)
+	repo := &mockRepository{outbound: []entity.Message{{ID: "m1", OrgID: "org1", MessageID: "<1760814004.abcdef@example.com>"}}}
+	emitter := &mockEmitter{}
+	auditor := &mockRecorder{}
+	s := NewService(repo, mockTransactional, store, emitter.Emit, auditor, logger)
+	ctx := context.Background()
+	raw, err := os.ReadFile("testdata/reply.eml")
+	require.Nil(t, err)
+
+	message, err := s.Receive(ctx, "org1", "100", strings.NewReader(string(raw)))
+	require.Nil(t, err)
+	assert.Equal(t, entity.InboundAPI, message.Source)
+	assert.Equal(t, "m1", message.OutboundID)
+	assert.Equal(t, "jane@example.org", message.FromEmail)
+	if assert.Len(t, auditor.events, 1) {
+		assert.Equal(t, audit.ActionInboundReceived, auditor.events[0].Action)
+		assert.Equal(t, message.ID, auditor.events[0].TargetID)
+	}
+	if assert.Len(t, emitter.events, 1) {
+		assert.Equal(t, entity.WebhookMessageInbound, emitter.events[0])
+	}
+
+	r, err := s.Raw(ctx, "org1", message.ID)
+	require.Nil(t, err)
+	stored, _ := io.ReadAll(r)
+	_ = r.Close()
+	assert.Equal(t, raw, stored)
+	_, err = s.Raw(ctx, "org2", message.ID)
+	assert.Equal(t, sql.ErrNoRows, err)
+
+	attachment, err := s.Attachment(ctx, "org1", message.ID, 1)
+	require.Nil(t, err)
+	assert.Equal(t, "factura nº 42.pdf", attachment.Filename)
+	assert.Equal(t, "%PDF-1.4", string(attachment.Content[:8]))
+	_, err = s.Attachment(ctx, "org1", message.ID, 2)
+	assert.Equal(t, http.StatusNotFound, err.(errs.ErrorResponse).StatusCode())
+
+	// replies to messages of other organizations are not threaded
+	message, err = s.Receive(ctx, "org2", "100", strings.NewReader(string(raw)))
+	require.Nil(t, err)
+	assert.Equal(t, "", message.OutboundID)
+
+	_, err = s.Receive(ctx, "org1", "100", strings.NewReader("not a message"))
+	assert.Equal(t, http.StatusBadRequest, err.(errs.ErrorResponse).StatusCode())
+	_, err = s.Receive(ctx, "org1", "100", strings.NewReader("Subject: big\n\n"+strings.Repeat("a", maxSize)))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, err.(errs.ErrorResponse).StatusCode())
+	assert.Len(t, repo.items, 2)
+}
+
+func Test_service_Receive_rollback(t *testing.T) {
+	logger, _ := log.NewForTest()
+	dir := t.TempDir()
+	store, err := blob.NewFileSystem(dir)
+	require.Nil(t, err)
+	emit := func(context.Context, string, string, interface{}) error { return errors.New("emit failed") }
+	s := NewService(&mockRepository{}, mockTransactional, store, emit, &mockRecorder{}, logger)
+
+	_, err = s.Receive(context.Background(), "org1", "100", strings.NewReader("Subject: Hi\n\nHello"))
+	assert.EqualError(t, err, "emit failed")
+	// the stored raw message is removed when the inbound message is not saved
+	entries, err := os.ReadDir(dir + "/inbound/org1")
+	if err == nil {
+		assert.Empty(t, entries)
+	}
+}
+
+func Test_service_Ingest(t *testing.T) {
+	logger, _ := log.NewForTest()
+	store, err := blob.NewFileSystem(t.TempDir())
+	require.Nil(t, err)
+	repo := &mockRepository{}
+	emitter := &mockEmitter{}
+	auditor := &mockRecorder{}
+	s := NewService(repo, mockTransactional, store, emitter.Emit, auditor, logger)
+	ctx := context.Background()
+	raw, err := os.ReadFile("testdata/reply.eml")
+	require.Nil(t, err)
+
+	assert.Nil(t, s.Ingest(ctx, "org1", "acc1", "uid-1", raw))
+	if assert.Len(t, repo.items, 1) {
+		assert.Equal(t, entity.InboundMailbox, repo.items[0].Source)
+		assert.Equal(t, "acc1", repo.items[0].AccountID)
+		assert.Equal(t, "uid-1", repo.items[0].SourceID)
+		assert.Equal(t, "", repo.items[0].OutboundID)
+	}
+	// messages polled again are skipped
+	assert.Nil(t, s.Ingest(ctx, "org1", "acc1", "uid-1", raw))
+	// as are those another poller saved first
+	repo.unique = true
+	assert.Nil(t, s.Ingest(ctx, "org1", "acc1", "uid-2", raw))
+	repo.unique = false
+	// and those that cannot be read
+	assert.Nil(t, s.Ingest(ctx, "org1", "acc1", "uid-3", []byte("not a message")))
+	assert.Len(t, repo.items, 1)
+	assert.Len(t, emitter.events, 1)
+	// mailbox messages have no actor to audit
+	assert.Empty(t, auditor.events)
+}
+
+func mockTransactional(ctx context.Context, f func(ctx context.Context) error) error {
+	return f(ctx)
+}
+
+type mockEmitter struct {
+	events []string
+}
+
+func (m *mockEmitter) Emit(_ context.Context, _, event string, _ interface{}) error {
+	m.events = append(m.events, event)
+	return nil
+}
+
+type mockRecorder struct {
+	events []audit.Event
+}
+
+func (m *mockRecorder) Record(_ context.Context, event audit.Event) error {
+	m.events = append(m.events, event)
+	return nil
+}
+
+type mockRepository struct {
+	items    []entity.InboundMessage
+	outbound []entity.Message
+	// unique makes Create fail with a unique violation.
+	unique bool
+}
+
+func (m *mockRepository) Get(_ context.Context, orgID, id string) (entity.InboundMessage, error) {
+	for _, item := range m.items {
+		if item.ID == id && item.OrgID == orgID {
+			return item, nil
+		}
+	}
+	return entity.InboundMessage{}, sql.ErrNoRows
+}
+
+func (m *mockRepository) Count(_ context.Context, orgID string) (int, error) {
+	count := 0
+	for _, item := range m.items {
+		if item.OrgID == orgID {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (m *mockRepository) Query(_ context.Context, orgID string, offset, limit int) ([]entity.InboundMessage, error) {
+	var items []entity.InboundMessage
+	for i := len(m.items) - 1; i >= 0; i-- {
+		if m.items[i].OrgID == orgID {
+			items = append(items, m.items[i])
+		}
+	}
+	if offset > len(items) {
+		offset = len(items)
+	}
+	items = items[offset:]
+	if limit >= 0 && limit < len(items) {
+		items = items[:limit]
+	}
+	return items, nil
+}
+
+func (m *mockRepository) Create(_ context.Context, message entity.InboundMessage) error {
+	if m.unique {
+		return &pq.Error{Code: "23505"}
+	}
+	m.items = append(m.items, message)
+	return nil
+}
+
+func (m *mockRepository) HasSource(_ context.Context, accountID, sourceID string) (bool, error) {
+	for _, item := range m.items {
+		if item.AccountID == accountID && item.SourceID == sourceID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m *mockRepository) FindOutbound(_ context.Context, orgID string, messageIDs []string) (entity.Message, error) {
+	for _, id := range messageIDs {
+		for _, message := range m.outbound {
+			if message.OrgID == orgID && message.MessageID == id {
+				return message, nil
+			}
+		}
+	}
+	return entity.Message{}, sql.ErrNoRows
+}
@@ -0,0 +1,148 @@
+package mailbox
+
+import (
+	"bufio"
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"net/textproto"
+	"regexp"
+	"strings"
+)
+
+// Report is a delivery status notification (RFC 3464) reporting on a message sent earlier.
+type Report struct {
+	// MessageID is the Message-ID of the reported message, when the notification includes its headers.
+	MessageID string
+	// Recipients lists the delivery status of each recipient the notification reports on.
+	Recipients []RecipientStatus
+}
+
+// RecipientStatus is the delivery status of a single recipient.
+type RecipientStatus struct {
+	// Email is the address of the recipient.
+	Email string
+	// Action is what the reporting MTA did: failed, delayed, delivered, relayed or expanded.
+	Action string
+	// Status is the RFC 3463 status code, such as 5.1.1.
+	Status string
+	// Diagnostic is the diagnostic reported by the remote MTA, if any.
+	Diagnostic string
+}
+
+// Failed returns whether the message could not be delivered to the recipient.
+func (r RecipientStatus) Failed() bool {
+	return r.Action == "failed"
+}
+
+// Permanent returns whether delivery to the recipient failed for a reason that retrying will not fix.
+func (r RecipientStatus) Permanent() bool {
+	return r.Failed() && strings.HasPrefix(r.Status, "5.")
+}
+
+// parseReport returns the delivery status notification carried by the message,
+// or nil if the message is not a delivery status notification.
+func parseReport(msg *mail.Message) (*Report, error) {
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/report" || !strings.EqualFold(params["report-type"], "delivery-status") {
+		return nil, nil
+	}
+
+	report := &Report{}
+	parts := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		part, err := parts.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		contentType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		body := decodePart(part)
+		switch contentType {
+		case "message/delivery-status", "message/global-delivery-status":
+			recipients, err := parseStatusFields(body)
+			if err != nil {
+				return nil, err
+			}
+			report.Recipients = append(report.Recipients, recipients...)
+		case "message/rfc822", "text/rfc822-headers", "message/global", "message/global-headers":
+			// only the headers of the original message are needed
+			header, err := textproto.NewReader(bufio.NewReader(body)).ReadMIMEHeader()
+			if err != nil && err != io.EOF {
+				continue
+			}
+			report.MessageID = strings.TrimSpace(header.Get("Message-Id"))
+		}
+	}
+	return report, nil
+}
+
+// parseStatusFields parses the body of a message/delivery-status part: a group of per-message fields
+// followed by a group of fields for each recipient, separated by blank lines.
+func parseStatusFields(body io.Reader) ([]RecipientStatus, error) {
+	reader := textproto.NewReader(bufio.NewReader(body))
+	var recipients []RecipientStatus
+	for {
+		fields, err := reader.ReadMIMEHeader()
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		recipient := fields.Get("Final-Recipient")
+		if recipient == "" {
+			recipient = fields.Get("Original-Recipient")
+		}
+		if recipient != "" {
+			recipients = append(recipients, RecipientStatus{
+				Email:      strings.ToLower(typedValue(recipient)),
+				Action:     strings.ToLower(strings.TrimSpace(fields.Get("Action"))),
+				Status:     firstWord(fields.Get("Status")),
+				Diagnostic: typedValue(fields.Get("Diagnostic-Code")),
+			})
+		}
+		if err == io.EOF {
+			return recipients, nil
+		}
+	}
+}
+
+// typedValue returns the value of a field of the form "type; value", such as "rfc822; jane@example.com".
+func typedValue(field string) string {
+	if _, value, ok := strings.Cut(field, ";"); ok {
+		return strings.TrimSpace(value)
+	}
+	return strings.TrimSpace(field)
+}
+
+// firstWord returns the first word of the value, dropping any trailing comment.
+func firstWord(value string) string {
+	if fields := strings.Fields(value); len(fields) > 0 {
+		return fields[0]
+	}
+	return ""
+}
+
+// decodePart returns the body of a multipart part. Quoted-printable parts are decoded by the multipart reader.
+func decodePart(part *multipart.Part) io.Reader {
+	if strings.EqualFold(strings.TrimSpace(part.Header.Get("Content-Transfer-Encoding")), "base64") {
+		return base64.NewDecoder(base64.StdEncoding, part)
+	}
+	return part
+}
+
+// messageIDPattern matches the message IDs in a header such as References.
+var messageIDPattern = regexp.MustCompile(`<[^<>\s]+>`)
+
+// referencedIDs returns the message IDs listed in the In-Reply-To and References headers, most relevant first.
+func referencedIDs(header mail.Header) []string {
+	ids := messageIDPattern.FindAllString(header.Get("In-Reply-To"), -1)
+	references := messageIDPattern.FindAllString(header.Get("References"), -1)
+	// the last reference is the message being replied to
+	for i := len(references) - 1; i >= 0; i-- {
+		ids = append(ids, references[i])
+	}
+	return ids
+}
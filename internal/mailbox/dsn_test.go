@@ -0,0 +1,71 @@
+package mailbox
+
+import (
+	"net/mail"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// readFixture parses a message from the testdata directory.
+func readFixture(t *testing.T, name string) *mail.Message {
+	f, err := os.Open(filepath.Join("testdata", name))
+	require.Nil(t, err)
+	t.Cleanup(func() { _ = f.Close() })
+	msg, err := mail.ReadMessage(f)
+	require.Nil(t, err)
+	return msg
+}
+
+func Test_parseReport(t *testing.T) {
+	tests := []struct {
+		fixture       string
+		wantMessageID string
+		want          []RecipientStatus
+		wantPermanent bool
+	}{
+		{
+			"gmail_bounce.eml", "<1760814004.abcdef@example.com>",
+			[]RecipientStatus{{"nobody@example.org", "failed", "5.1.1", "550 5.1.1 The email account that you tried to reach does not exist."}},
+			true,
+		},
+		{
+			"outlook_ndr.eml", "<1760814600.fedcba@example.com>",
+			[]RecipientStatus{{"mailbox.full@example.net", "failed", "4.2.2", "452 4.2.2 Mailbox full"}},
+			false,
+		},
+		{
+			"delayed.eml", "<1760814004.abcdef@example.com>",
+			[]RecipientStatus{{"slow@example.org", "delayed", "4.4.1", "connect to mx.example.org[203.0.113.20]:25: Connection timed out"}},
+			false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			report, err := parseReport(readFixture(t, tt.fixture))
+			require.Nil(t, err)
+			require.NotNil(t, report)
+			assert.Equal(t, tt.wantMessageID, report.MessageID)
+			assert.Equal(t, tt.want, report.Recipients)
+			assert.Equal(t, tt.wantPermanent, report.Recipients[0].Permanent())
+		})
+	}
+
+	for _, fixture := range []string{"reply.eml", "unrelated.eml"} {
+		report, err := parseReport(readFixture(t, fixture))
+		assert.Nil(t, err)
+		assert.Nil(t, report, fixture)
+	}
+}
+
+func Test_referencedIDs(t *testing.T) {
+	header := mail.Header{
+		"In-Reply-To": {"<b@example.com>"},
+		"References":  {"<a@example.com>\r\n <b@example.com>"},
+	}
+	assert.Equal(t, []string{"<b@example.com>", "<b@example.com>", "<a@example.com>"}, referencedIDs(header))
+	assert.Empty(t, referencedIDs(mail.Header{}))
+}
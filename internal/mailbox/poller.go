@@ -0,0 +1,228 @@
+// Package mailbox polls the mailboxes of the linked provider accounts for the bounces of and the replies to
+// the messages sent from them, and hands the messages they receive over to inbound ingestion. Gmail and
+// Outlook send mail as the user, so delivery status notifications land in the user's inbox rather than
+// reaching a webhook.
+package mailbox
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"mime"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/garaekz/gonvelope/internal/entity"
+	"github.com/garaekz/gonvelope/internal/provider"
+	"github.com/garaekz/gonvelope/pkg/log"
+)
+
+const (
+	// pollInterval is how often the mailboxes are polled.
+	pollInterval = time.Minute
+	// pollOverlap is how far before the previous poll each poll starts, so that messages indexed late
+	// by the provider are not missed. Messages seen twice are only recorded once.
+	pollOverlap = 10 * time.Minute
+	// initialLookback is how far back the first poll of a mailbox looks.
+	initialLookback = 24 * time.Hour
+)
+
+// SuppressFunc adds an address to the suppression list of the organization.
+type SuppressFunc func(ctx context.Context, orgID, email, reason, source string) (bool, error)
+
+// IngestFunc ingests a raw message read from the mailbox of a provider account of the organization.
+// It must skip the mailbox messages it already ingested.
+type IngestFunc func(ctx context.Context, orgID, accountID, sourceID string, raw []byte) error
+
+// Poller reads the recent messages of the mailboxes that granted read access, marks the messages
+// reported by delivery status notifications as bounced, suppresses the addresses that bounced
+// permanently, records the replies to sent messages and ingests every message but the notifications.
+type Poller struct {
+	repo     Repository
+	readers  provider.Readers
+	suppress SuppressFunc
+	ingest   IngestFunc
+	logger   log.Logger
+}
+
+// NewPoller creates a new mailbox poller.
+func NewPoller(repo Repository, readers provider.Readers, suppress SuppressFunc, ingest IngestFunc, logger log.Logger) *Poller {
+	return &Poller{repo, readers, suppress, ingest, logger}
+}
+
+// Run polls the mailboxes every pollInterval until the context is cancelled.
+func (p *Poller) Run(ctx context.Context) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		if err := p.Poll(ctx, time.Now()); err != nil {
+			p.logger.With(ctx).Errorf("failed to poll mailboxes: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Poll reads the messages received by every readable mailbox since it was last polled.
+// A mailbox that cannot be read is logged and skipped so that it does not hold the others back.
+func (p *Poller) Poll(ctx context.Context, now time.Time) error {
+	accounts, err := p.repo.QueryAccounts(ctx)
+	if err != nil {
+		return err
+	}
+	for _, account := range accounts {
+		reader, ok := p.readers[account.Provider]
+		if !ok || !reader.CanRead(account.UserProviderAccount) {
+			continue
+		}
+		if err := p.pollAccount(ctx, reader, account, now); err != nil {
+			p.logger.With(ctx, "account_id", account.ID).Errorf("failed to poll mailbox: %v", err)
+		}
+	}
+	return nil
+}
+
+// pollAccount processes the messages received by the account since it was last polled.
+func (p *Poller) pollAccount(ctx context.Context, reader provider.Reader, account Account, now time.Time) error {
+	since := now.Add(-initialLookback)
+	if account.PolledAt != nil {
+		since = account.PolledAt.Add(-pollOverlap)
+	}
+	received, err := reader.Received(ctx, account.UserProviderAccount, since)
+	if err != nil {
+		return err
+	}
+	for _, item := range received {
+		if err := p.process(ctx, account, item, now); err != nil {
+			return err
+		}
+	}
+	return p.repo.SetPolledAt(ctx, account.ID, now)
+}
+
+// process handles a message received by the account. Messages that are not delivery status notifications
+// are ingested, and those that are neither notifications nor replies to messages sent by the organization
+// are not recorded as message events.
+func (p *Poller) process(ctx context.Context, account Account, item provider.Received, now time.Time) error {
+	msg, err := mail.ReadMessage(bytes.NewReader(item.Raw))
+	if err != nil {
+		p.logger.With(ctx, "account_id", account.ID).Infof("skipping unreadable message %s: %v", item.ID, err)
+		return nil
+	}
+	report, err := parseReport(msg)
+	if err != nil {
+		p.logger.With(ctx, "account_id", account.ID).Infof("skipping invalid delivery status notification %s: %v", item.ID, err)
+		return nil
+	}
+	if report == nil {
+		if err := p.ingest(ctx, account.OrgID, account.ID, item.ID, item.Raw); err != nil {
+			return err
+		}
+	}
+
+	ids := referencedIDs(msg.Header)
+	if report != nil && report.MessageID != "" {
+		ids = append([]string{report.MessageID}, ids...)
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	message, err := p.repo.FindMessage(ctx, account.OrgID, ids)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if report != nil {
+		return p.bounce(ctx, message, report, item.ID, now)
+	}
+	return p.reply(ctx, message, msg.Header, item.ID, now)
+}
+
+// bounce records the failed recipients of a delivery status notification. The message is marked as bounced
+// and the addresses that failed permanently are suppressed. Anyone can send a notification to the mailbox,
+// so the recipients the message was not sent to are ignored.
+func (p *Poller) bounce(ctx context.Context, message entity.Message, report *Report, sourceID string, now time.Time) error {
+	for _, recipient := range report.Recipients {
+		if !recipient.Failed() {
+			continue
+		}
+		if !sentTo(message, recipient.Email) {
+			p.logger.With(ctx, "message_id", message.ID).Infof("ignoring bounce of %s, who the message was not sent to", recipient.Email)
+			continue
+		}
+		if err := p.repo.MarkBounced(ctx, message.ID, now); err != nil {
+			return err
+		}
+		if recipient.Permanent() {
+			if _, err := p.suppress(ctx, message.OrgID, recipient.Email, entity.SuppressionBounced, entity.SuppressionSourceBounce); err != nil {
+				return err
+			}
+		}
+		data, err := entity.NewJSON(map[string]interface{}{
+			"status":     recipient.Status,
+			"diagnostic": recipient.Diagnostic,
+			"permanent":  recipient.Permanent(),
+		})
+		if err != nil {
+			return err
+		}
+		if err := p.record(ctx, message, entity.MessageEventBounced, recipient.Email, sourceID, data, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sentTo returns whether the message was sent to the address.
+func sentTo(message entity.Message, email string) bool {
+	for _, to := range message.To {
+		if strings.EqualFold(to, email) {
+			return true
+		}
+	}
+	return false
+}
+
+// reply records a reply to the message.
+func (p *Poller) reply(ctx context.Context, message entity.Message, header mail.Header, sourceID string, now time.Time) error {
+	var from string
+	if addr, err := mail.ParseAddress(header.Get("From")); err == nil {
+		from = strings.ToLower(addr.Address)
+	}
+	subject, _ := new(mime.WordDecoder).DecodeHeader(header.Get("Subject"))
+	autoSubmitted := header.Get("Auto-Submitted")
+	data, err := entity.NewJSON(map[string]interface{}{
+		"subject":      subject,
+		"message_id":   header.Get("Message-ID"),
+		"auto_replied": autoSubmitted != "" && !strings.EqualFold(autoSubmitted, "no"),
+	})
+	if err != nil {
+		return err
+	}
+	return p.record(ctx, message, entity.MessageEventReplied, from, sourceID, data, now)
+}
+
+// record saves a message event detected from the mailbox message with the given source ID.
+func (p *Poller) record(ctx context.Context, message entity.Message, eventType, recipient, sourceID string, data entity.JSON, now time.Time) error {
+	created, err := p.repo.CreateEvent(ctx, entity.MessageEvent{
+		ID:        entity.GenerateID(),
+		OrgID:     message.OrgID,
+		MessageID: message.ID,
+		Type:      eventType,
+		Recipient: recipient,
+		SourceID:  sourceID,
+		Data:      data,
+		CreatedAt: now,
+	})
+	if err == nil && created {
+		p.logger.With(ctx, "message_id", message.ID).Infof("recorded %s event for %s", eventType, recipient)
+	}
+	return err
+}
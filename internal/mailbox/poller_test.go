@@ -0,0 +1,208 @@
+package mailbox
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/garaekz/gonvelope/internal/entity"
+	"github.com/garaekz/gonvelope/internal/provider"
+	"github.com/garaekz/gonvelope/pkg/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPoller_Poll(t *testing.T) {
+	logger, _ := log.NewForTest()
+	now := time.Now()
+	polledAt := now.Add(-time.Minute)
+	repo := &mockRepository{
+		accounts: []Account{
+			{UserProviderAccount: entity.UserProviderAccount{ID: "gmail1", OrgID: "org1", Scopes: []string{"gmail.readonly"}, PolledAt: &polledAt}, Provider: "google"},
+			{UserProviderAccount: entity.UserProviderAccount{ID: "send-only", OrgID: "org1", Scopes: []string{"gmail.send"}}, Provider: "google"},
+			{UserProviderAccount: entity.UserProviderAccount{ID: "imap1", OrgID: "org1", Scopes: []string{"imap"}}, Provider: "imap"},
+		},
+		messages: []entity.Message{
+			{ID: "invoice", OrgID: "org1", MessageID: "<1760814004.abcdef@example.com>", To: []string{"nobody@example.org"}, Status: entity.MessageSent},
+			{ID: "report", OrgID: "org1", MessageID: "<1760814600.fedcba@example.com>", To: []string{"mailbox.full@example.net"}, Status: entity.MessageSent},
+			{ID: "other org", OrgID: "org2", MessageID: "<digest-42@example.net>", Status: entity.MessageSent},
+		},
+	}
+	reader := &mockReader{received: fixtures(t, "gmail_bounce.eml", "outlook_ndr.eml", "delayed.eml", "reply.eml", "unrelated.eml")}
+	reader.received = append(reader.received, provider.Received{ID: "garbage", Raw: []byte("not a message")})
+	suppressed := map[string]string{}
+	suppress := func(_ context.Context, orgID, email, reason, source string) (bool, error) {
+		suppressed[orgID+"/"+email] = reason + "/" + source
+		return true, nil
+	}
+	var ingested []string
+	ingest := func(_ context.Context, orgID, accountID, sourceID string, raw []byte) error {
+		ingested = append(ingested, orgID+"/"+accountID+"/"+sourceID)
+		return nil
+	}
+	p := NewPoller(repo, provider.Readers{"google": reader}, suppress, ingest, logger)
+
+	require.Nil(t, p.Poll(context.Background(), now))
+
+	// only the account that granted read access is polled, starting a little before its last poll
+	assert.Equal(t, []string{"gmail1"}, reader.polled)
+	assert.Equal(t, polledAt.Add(-pollOverlap), reader.since)
+	assert.Equal(t, now, *repo.accounts[0].PolledAt)
+	assert.Nil(t, repo.accounts[1].PolledAt)
+
+	assert.Equal(t, entity.MessageBounced, repo.messages[0].Status)
+	assert.Equal(t, entity.MessageBounced, repo.messages[1].Status)
+	// only permanent failures are suppressed
+	assert.Equal(t, map[string]string{"org1/nobody@example.org": "bounced/bounce"}, suppressed)
+
+	if assert.Len(t, repo.events, 3) {
+		assert.Equal(t, entity.MessageEventBounced, repo.events[0].Type)
+		assert.Equal(t, "invoice", repo.events[0].MessageID)
+		assert.Equal(t, "nobody@example.org", repo.events[0].Recipient)
+		assert.Equal(t, "gmail_bounce.eml", repo.events[0].SourceID)
+		assert.Contains(t, string(repo.events[0].Data), `"status":"5.1.1"`)
+
+		assert.Equal(t, entity.MessageEventBounced, repo.events[1].Type)
+		assert.Equal(t, "report", repo.events[1].MessageID)
+		assert.Contains(t, string(repo.events[1].Data), `"permanent":false`)
+
+		assert.Equal(t, entity.MessageEventReplied, repo.events[2].Type)
+		assert.Equal(t, "invoice", repo.events[2].MessageID)
+		assert.Equal(t, "jane@example.org", repo.events[2].Recipient)
+		assert.Contains(t, string(repo.events[2].Data), `"subject":"Re: Your invoice ✓"`)
+	}
+
+	// every readable message but the delivery status notifications is ingested
+	assert.Equal(t, []string{"org1/gmail1/reply.eml", "org1/gmail1/unrelated.eml"}, ingested)
+
+	// polling the same messages again does not record them twice
+	require.Nil(t, p.Poll(context.Background(), now.Add(time.Minute)))
+	assert.Len(t, repo.events, 3)
+}
+
+func TestPoller_bounce(t *testing.T) {
+	logger, _ := log.NewForTest()
+	repo := &mockRepository{messages: []entity.Message{
+		{ID: "invoice", OrgID: "org1", To: []string{"jane@example.org"}, Status: entity.MessageSent},
+	}}
+	suppressed := map[string]string{}
+	suppress := func(_ context.Context, orgID, email, reason, source string) (bool, error) {
+		suppressed[orgID+"/"+email] = reason + "/" + source
+		return true, nil
+	}
+	p := NewPoller(repo, nil, suppress, nil, logger)
+
+	// a notification about an address the message was not sent to is ignored
+	report := &Report{Recipients: []RecipientStatus{{Email: "ceo@example.org", Action: "failed", Status: "5.1.1"}}}
+	require.Nil(t, p.bounce(context.Background(), repo.messages[0], report, "forged", time.Now()))
+	assert.Equal(t, entity.MessageSent, repo.messages[0].Status)
+	assert.Empty(t, suppressed)
+	assert.Empty(t, repo.events)
+
+	report = &Report{Recipients: []RecipientStatus{{Email: "Jane@Example.org", Action: "failed", Status: "5.1.1"}}}
+	require.Nil(t, p.bounce(context.Background(), repo.messages[0], report, "bounce", time.Now()))
+	assert.Equal(t, entity.MessageBounced, repo.messages[0].Status)
+	assert.Len(t, suppressed, 1)
+	assert.Len(t, repo.events, 1)
+}
+
+func TestPoller_Poll_error(t *testing.T) {
+	logger, _ := log.NewForTest()
+	repo := &mockRepository{accounts: []Account{
+		{UserProviderAccount: entity.UserProviderAccount{ID: "gmail1", Scopes: []string{"gmail.readonly"}}, Provider: "google"},
+	}}
+	reader := &mockReader{err: errors.New("token revoked")}
+	p := NewPoller(repo, provider.Readers{"google": reader}, nil, nil, logger)
+
+	// a mailbox that cannot be read is skipped and polled again next time
+	now := time.Now()
+	assert.Nil(t, p.Poll(context.Background(), now))
+	assert.Equal(t, now.Add(-initialLookback), reader.since)
+	assert.Nil(t, repo.accounts[0].PolledAt)
+}
+
+// fixtures returns the given testdata files as received messages whose IDs are the file names.
+func fixtures(t *testing.T, names ...string) []provider.Received {
+	var received []provider.Received
+	for _, name := range names {
+		raw, err := os.ReadFile(filepath.Join("testdata", name))
+		require.Nil(t, err)
+		received = append(received, provider.Received{ID: name, Raw: raw})
+	}
+	return received
+}
+
+type mockReader struct {
+	received []provider.Received
+	err      error
+	polled   []string
+	since    time.Time
+}
+
+func (m *mockReader) CanRead(account entity.UserProviderAccount) bool {
+	for _, scope := range account.Scopes {
+		if scope == "gmail.readonly" {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *mockReader) Received(_ context.Context, account entity.UserProviderAccount, since time.Time) ([]provider.Received, error) {
+	m.polled = append(m.polled, account.ID)
+	m.since = since
+	return m.received, m.err
+}
+
+type mockRepository struct {
+	accounts []Account
+	messages []entity.Message
+	events   []entity.MessageEvent
+}
+
+func (m *mockRepository) QueryAccounts(context.Context) ([]Account, error) {
+	return m.accounts, nil
+}
+
+func (m *mockRepository) SetPolledAt(_ context.Context, accountID string, at time.Time) error {
+	for i, account := range m.accounts {
+		if account.ID == accountID {
+			m.accounts[i].PolledAt = &at
+		}
+	}
+	return nil
+}
+
+func (m *mockRepository) FindMessage(_ context.Context, orgID string, messageIDs []string) (entity.Message, error) {
+	for _, id := range messageIDs {
+		for _, message := range m.messages {
+			if message.OrgID == orgID && message.MessageID == id {
+				return message, nil
+			}
+		}
+	}
+	return entity.Message{}, sql.ErrNoRows
+}
+
+func (m *mockRepository) MarkBounced(_ context.Context, id string, _ time.Time) error {
+	for i, message := range m.messages {
+		if message.ID == id {
+			m.messages[i].Status = entity.MessageBounced
+		}
+	}
+	return nil
+}
+
+func (m *mockRepository) CreateEvent(_ context.Context, event entity.MessageEvent) (bool, error) {
+	for _, e := range m.events {
+		if e.MessageID == event.MessageID && e.Type == event.Type && e.Recipient == event.Recipient && e.SourceID == event.SourceID {
+			return false, nil
+		}
+	}
+	m.events = append(m.events, event)
+	return true, nil
+}
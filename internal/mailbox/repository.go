@@ -0,0 +1,107 @@
+package mailbox
+
+import (
+	"context"
+	"time"
+
+	"github.com/garaekz/gonvelope/internal/entity"
+	"github.com/garaekz/gonvelope/pkg/dbcontext"
+	"github.com/garaekz/gonvelope/pkg/log"
+	dbx "github.com/go-ozzo/ozzo-dbx"
+)
+
+// Account is a linked provider account along with the name of its provider.
+type Account struct {
+	entity.UserProviderAccount
+	Provider string `db:"provider"`
+}
+
+// Repository encapsulates the logic to access the mailboxes and the messages sent from them.
+type Repository interface {
+	// QueryAccounts returns the provider accounts whose granted scopes are known, least recently polled first.
+	QueryAccounts(ctx context.Context) ([]Account, error)
+	// SetPolledAt saves when the mailbox of the account was last polled.
+	SetPolledAt(ctx context.Context, accountID string, at time.Time) error
+	// FindMessage returns the message of the organization with one of the given Message-IDs.
+	FindMessage(ctx context.Context, orgID string, messageIDs []string) (entity.Message, error)
+	// MarkBounced marks the message as bounced unless it has bounced already.
+	MarkBounced(ctx context.Context, id string, now time.Time) error
+	// CreateEvent saves a message event unless an event was already recorded for the same mailbox message,
+	// and returns whether it was saved.
+	CreateEvent(ctx context.Context, event entity.MessageEvent) (bool, error)
+}
+
+// repository reads and updates messages in database
+type repository struct {
+	db     *dbcontext.DB
+	logger log.Logger
+}
+
+// NewRepository creates a new mailbox repository
+func NewRepository(db *dbcontext.DB, logger log.Logger) Repository {
+	return repository{db, logger}
+}
+
+// QueryAccounts returns the provider accounts whose granted scopes are known, least recently polled first.
+func (r repository) QueryAccounts(ctx context.Context) ([]Account, error) {
+	var accounts []Account
+	err := r.db.With(ctx).NewQuery(`
+		SELECT a.*, p.name AS provider FROM user_provider_accounts a JOIN providers p ON p.id = a.provider_id
+		WHERE cardinality(a.scopes) > 0
+		ORDER BY a.polled_at NULLS FIRST`).All(&accounts)
+	return accounts, err
+}
+
+// SetPolledAt saves when the mailbox of the account was last polled.
+func (r repository) SetPolledAt(ctx context.Context, accountID string, at time.Time) error {
+	_, err := r.db.With(ctx).Update(entity.UserProviderAccount{}.TableName(), dbx.Params{"polled_at": at}, dbx.HashExp{"id": accountID}).Execute()
+	return err
+}
+
+// FindMessage returns the message of the organization with one of the given Message-IDs.
+func (r repository) FindMessage(ctx context.Context, orgID string, messageIDs []string) (entity.Message, error) {
+	var message entity.Message
+	ids := make([]interface{}, len(messageIDs))
+	for i, id := range messageIDs {
+		ids[i] = id
+	}
+	err := r.db.With(ctx).Select().From(message.TableName()).
+		Where(dbx.HashExp{"org_id": orgID, "message_id": ids}).
+		OrderBy("created_at DESC").
+		Limit(1).
+		One(&message)
+	return message, err
+}
+
+// MarkBounced marks the message as bounced unless it has bounced already.
+func (r repository) MarkBounced(ctx context.Context, id string, now time.Time) error {
+	_, err := r.db.With(ctx).Update(entity.Message{}.TableName(),
+		dbx.Params{"status": entity.MessageBounced, "updated_at": now},
+		dbx.And(dbx.HashExp{"id": id}, dbx.NewExp("status <> {:status}", dbx.Params{"status": entity.MessageBounced})),
+	).Execute()
+	return err
+}
+
+// CreateEvent saves a message event unless an event was already recorded for the same mailbox message,
+// and returns whether it was saved.
+func (r repository) CreateEvent(ctx context.Context, event entity.MessageEvent) (bool, error) {
+	result, err := r.db.With(ctx).NewQuery(`
+		INSERT INTO message_events (id, org_id, message_id, type, recipient, source_id, data, created_at)
+		VALUES ({:id}, {:org}, {:message}, {:type}, {:recipient}, {:source}, {:data}, {:created})
+		ON CONFLICT DO NOTHING`).
+		Bind(dbx.Params{
+			"id":        event.ID,
+			"org":       event.OrgID,
+			"message":   event.MessageID,
+			"type":      event.Type,
+			"recipient": event.Recipient,
+			"source":    event.SourceID,
+			"data":      event.Data,
+			"created":   event.CreatedAt,
+		}).Execute()
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	return rows > 0, err
+}
@@ -0,0 +1,185 @@
+package message
+
+import (
+	"encoding/json"
+	"mime"
+	"net/http"
+	"strconv"
+
+	"github.com/garaekz/gonvelope/internal/auth"
+	"github.com/garaekz/gonvelope/internal/entity"
+	"github.com/garaekz/gonvelope/internal/errors"
+	"github.com/garaekz/gonvelope/pkg/log"
+	"github.com/garaekz/gonvelope/pkg/pagination"
+	routing "github.com/garaekz/ozzo-routing"
+)
+
+// RegisterHandlers sets up the routing of the HTTP handlers.
+// Messages belong to the organization made active by orgHandler.
+func RegisterHandlers(r *routing.RouteGroup, service Service, authHandler, orgHandler routing.Handler, logger log.Logger) {
+	res := resource{service, logger}
+
+	r.Use(authHandler, orgHandler)
+
+	r.Get("messages", auth.Require(auth.ScopeMessagesRead), res.query)
+	r.Get("messages/<id>", auth.Require(auth.ScopeMessagesRead), res.get)
+	r.Get("messages/<id>/events", auth.Require(auth.ScopeMessagesRead), res.queryEvents)
+	r.Post("messages", auth.Require(auth.ScopeMessagesSend), res.send)
+	r.Post("messages/batch", auth.Require(auth.ScopeMessagesSend), res.sendBatch)
+	r.Get("messages/batch/<id>", auth.Require(auth.ScopeMessagesRead), res.getBatch)
+	r.Post("messages/imports", auth.Require(auth.ScopeMessagesSend), res.importBatch)
+	r.Get("messages/imports/<id>", auth.Require(auth.ScopeMessagesRead), res.getImport)
+	r.Get("messages/imports/<id>/errors", auth.Require(auth.ScopeMessagesRead), res.queryImportErrors)
+}
+
+type resource struct {
+	service Service
+	logger  log.Logger
+}
+
+func (r resource) get(c *routing.Context) error {
+	message, err := r.service.Get(c.Request.Context(), currentMembership(c).OrgID, c.Param("id"))
+	if err != nil {
+		return err
+	}
+	return c.Write(message)
+}
+
+func (r resource) queryEvents(c *routing.Context) error {
+	events, err := r.service.QueryEvents(c.Request.Context(), currentMembership(c).OrgID, c.Param("id"))
+	if err != nil {
+		return err
+	}
+	return c.Write(events)
+}
+
+func (r resource) query(c *routing.Context) error {
+	ctx := c.Request.Context()
+	orgID := currentMembership(c).OrgID
+	count, err := r.service.Count(ctx, orgID)
+	if err != nil {
+		return err
+	}
+	pages := pagination.NewFromRequest(c.Request, count)
+	messages, err := r.service.Query(ctx, orgID, pages.Offset(), pages.Limit())
+	if err != nil {
+		return err
+	}
+	pages.Items = messages
+	return c.Write(pages)
+}
+
+func (r resource) send(c *routing.Context) error {
+	var input SendRequest
+	if err := c.Read(&input); err != nil {
+		r.logger.With(c.Request.Context()).Info(err)
+		return errors.BadRequest("")
+	}
+	membership := currentMembership(c)
+	message, err := r.service.Send(c.Request.Context(), membership.OrgID, membership.UserID, input)
+	if err != nil {
+		return err
+	}
+	return c.WriteWithStatus(message, http.StatusAccepted)
+}
+
+func (r resource) sendBatch(c *routing.Context) error {
+	var input BatchRequest
+	if err := c.Read(&input); err != nil {
+		r.logger.With(c.Request.Context()).Info(err)
+		return errors.BadRequest("")
+	}
+	membership := currentMembership(c)
+	result, err := r.service.SendBatch(c.Request.Context(), membership.OrgID, membership.UserID, input)
+	if err != nil {
+		return err
+	}
+	return c.WriteWithStatus(result, http.StatusAccepted)
+}
+
+func (r resource) getBatch(c *routing.Context) error {
+	status, err := r.service.GetBatch(c.Request.Context(), currentMembership(c).OrgID, c.Param("id"))
+	if err != nil {
+		return err
+	}
+	return c.Write(status)
+}
+
+// importBatch imports the file of recipients sent as the request body. The other parameters of the import
+// are read from the query string, with the mapping given as a JSON object.
+func (r resource) importBatch(c *routing.Context) error {
+	query := c.Request.URL.Query()
+	input := ImportRequest{
+		TemplateID: query.Get("template_id"),
+		AccountID:  query.Get("account_id"),
+		FromEmail:  query.Get("from_email"),
+		FromName:   query.Get("from_name"),
+		Format:     query.Get("format"),
+		Locale:     query.Get("locale"),
+	}
+	if input.Format == "" {
+		input.Format = importFormat(c.Request.Header.Get("Content-Type"))
+	}
+	if mapping := query.Get("mapping"); mapping != "" {
+		if err := json.Unmarshal([]byte(mapping), &input.Mapping); err != nil {
+			return errors.BadRequest("The mapping must be a JSON object mapping variables to columns")
+		}
+	}
+	if dryRun := query.Get("dry_run"); dryRun != "" {
+		var err error
+		if input.DryRun, err = strconv.ParseBool(dryRun); err != nil {
+			return errors.BadRequest("dry_run must be true or false")
+		}
+	}
+	membership := currentMembership(c)
+	result, err := r.service.ImportBatch(c.Request.Context(), membership.OrgID, membership.UserID, input, c.Request.Body)
+	if err != nil {
+		return err
+	}
+	if input.DryRun {
+		return c.Write(result)
+	}
+	return c.WriteWithStatus(result, http.StatusAccepted)
+}
+
+// importFormat returns the import format matching the content type, if any.
+func importFormat(contentType string) string {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch mediaType {
+	case "text/csv":
+		return entity.ImportCSV
+	case "application/jsonl", "application/x-ndjson", "application/x-jsonlines":
+		return entity.ImportJSONL
+	}
+	return ""
+}
+
+func (r resource) getImport(c *routing.Context) error {
+	item, err := r.service.GetImport(c.Request.Context(), currentMembership(c).OrgID, c.Param("id"))
+	if err != nil {
+		return err
+	}
+	return c.Write(item)
+}
+
+func (r resource) queryImportErrors(c *routing.Context) error {
+	ctx := c.Request.Context()
+	orgID := currentMembership(c).OrgID
+	count, err := r.service.CountImportErrors(ctx, orgID, c.Param("id"))
+	if err != nil {
+		return err
+	}
+	pages := pagination.NewFromRequest(c.Request, count)
+	items, err := r.service.QueryImportErrors(ctx, orgID, c.Param("id"), pages.Offset(), pages.Limit())
+	if err != nil {
+		return err
+	}
+	pages.Items = items
+	return c.Write(pages)
+}
+
+// currentMembership returns the membership of the current user in the active organization.
+func currentMembership(c *routing.Context) entity.Membership {
+	membership, _ := auth.CurrentMembership(c.Request.Context())
+	return membership
+}
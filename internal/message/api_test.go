@@ -0,0 +1,80 @@
+package message
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/garaekz/gonvelope/internal/auth"
+	"github.com/garaekz/gonvelope/internal/entity"
+	"github.com/garaekz/gonvelope/internal/org"
+	"github.com/garaekz/gonvelope/internal/test"
+	"github.com/garaekz/gonvelope/pkg/log"
+)
+
+func TestAPI(t *testing.T) {
+	logger, _ := log.NewForTest()
+	router := test.MockRouter(logger)
+	now := time.Now()
+	repo := &mockRepository{
+		items: []entity.Message{
+			{ID: "123", OrgID: "100", AccountID: "account1", BatchID: "b1", Subject: "Welcome", Status: entity.MessageSent, CreatedAt: &now},
+			{ID: "456", OrgID: "org1", AccountID: "account2", Subject: "Invoice", Status: entity.MessageQueued, CreatedAt: &now},
+		},
+		accounts: map[string]string{"account1": "100", "account2": "org1"},
+		batches:  []entity.Batch{{ID: "b1", OrgID: "100", Total: 1, Accepted: 1}},
+		imports: []entity.BatchImport{
+			{ID: "i1", OrgID: "100", Format: entity.ImportCSV, Status: entity.ImportProcessing, Lines: 500},
+			{ID: "i2", OrgID: "org1", Format: entity.ImportCSV, Status: entity.ImportCompleted},
+		},
+		importErrors: []entity.ImportError{{ImportID: "i1", Line: 7, Email: "jane", Error: "must be a valid email address"}},
+		templates: []entity.Template{
+			{ID: "t1", OrgID: "100", Subject: "Hi {{name}}", Body: "Hello", FromEmail: "sales@example.com"},
+		},
+		events: []entity.MessageEvent{
+			{ID: "e1", OrgID: "100", MessageID: "123", Type: entity.MessageEventReplied, Recipient: "jane@example.com", CreatedAt: now},
+		},
+	}
+	RegisterHandlers(router.Group("/"), NewService(repo, mockTransactional, mockSuppressed, mockListMembers, mockVerified, &mockRecorder{}, logger), auth.MockAuthHandler, org.MockHandler, logger)
+	header := auth.MockAuthHeader()
+	csvHeader := auth.MockAuthHeader()
+	csvHeader.Set("Content-Type", "text/csv")
+	batch := `{"template_id":"t1","account_id":"account1","variables":{"name":"there"},"recipients":[{"email":"jane@example.com"},{"email":"jane"}]}`
+	body := `{"account_id":"account1","from_email":"sales@example.com","to":["jane@example.com"],"subject":"Hi","text":"Hello"}`
+
+	tests := []test.APITestCase{
+		{Name: "get all", Method: "GET", URL: "/messages", Header: header, WantStatus: http.StatusOK, WantResponse: `*"total_count":1*`},
+		{Name: "get 123", Method: "GET", URL: "/messages/123", Header: header, WantStatus: http.StatusOK, WantResponse: `*"subject":"Welcome"*`},
+		{Name: "get other org", Method: "GET", URL: "/messages/456", Header: header, WantStatus: http.StatusNotFound},
+		{Name: "get events", Method: "GET", URL: "/messages/123/events", Header: header, WantStatus: http.StatusOK, WantResponse: `*"type":"replied"*`},
+		{Name: "get events other org", Method: "GET", URL: "/messages/456/events", Header: header, WantStatus: http.StatusNotFound},
+		{Name: "send ok", Method: "POST", URL: "/messages", Body: body, Header: header, WantStatus: http.StatusAccepted, WantResponse: `*"status":"queued"*`},
+		{Name: "send verify", Method: "GET", URL: "/messages", Header: header, WantStatus: http.StatusOK, WantResponse: `*"total_count":2*`},
+		{Name: "send input error", Method: "POST", URL: "/messages", Body: `"subject":"Hi"}`, Header: header, WantStatus: http.StatusBadRequest},
+		{Name: "send invalid", Method: "POST", URL: "/messages", Body: `{"subject":"Hi"}`, Header: header, WantStatus: http.StatusBadRequest, WantResponse: `*account_id*`},
+		{Name: "send unverified sender", Method: "POST", URL: "/messages", Body: `{"account_id":"account1","from_email":"sales@example.org","to":["jane@example.com"],"subject":"Hi","text":"Hello"}`, Header: header, WantStatus: http.StatusBadRequest, WantResponse: `*"field":"from_email"*`},
+		{Name: "send other org account", Method: "POST", URL: "/messages", Body: `{"account_id":"account2","from_email":"sales@example.com","to":["jane@example.com"],"subject":"Hi","text":"Hello"}`, Header: header, WantStatus: http.StatusBadRequest},
+		{Name: "send batch", Method: "POST", URL: "/messages/batch", Body: batch, Header: header, WantStatus: http.StatusAccepted, WantResponse: `*"accepted":1,"rejected":1*`},
+		{Name: "send batch input error", Method: "POST", URL: "/messages/batch", Body: `"template_id":"t1"}`, Header: header, WantStatus: http.StatusBadRequest},
+		{Name: "send batch unknown template", Method: "POST", URL: "/messages/batch", Body: `{"template_id":"t2","account_id":"account1","recipients":[{"email":"jane@example.com"}]}`, Header: header, WantStatus: http.StatusBadRequest, WantResponse: `*template_id*`},
+		{Name: "send batch viewer", Method: "POST", URL: "/messages/batch", Body: batch, Header: org.MockHeader(entity.RoleViewer), WantStatus: http.StatusForbidden},
+		{Name: "get batch", Method: "GET", URL: "/messages/batch/b1", Header: header, WantStatus: http.StatusOK, WantResponse: `*"messages":{"bounced":0,"failed":0,"queued":0,"sending":0,"sent":1},"completed":true*`},
+		{Name: "get batch unknown", Method: "GET", URL: "/messages/batch/unknown", Header: header, WantStatus: http.StatusNotFound},
+		{Name: "import dry run", Method: "POST", URL: "/messages/imports?template_id=t1&account_id=account1&dry_run=true&mapping=" + url.QueryEscape(`{"email":"Email","name":"Name"}`), Body: "Email,Name\njane@example.com,Jane\njohn\n", Header: csvHeader, WantStatus: http.StatusOK, WantResponse: `*"errors":[{"line":3,"email":"john","error":"must be a valid email address"}]*`},
+		{Name: "import", Method: "POST", URL: "/messages/imports?format=jsonl&template_id=t1&account_id=account1", Body: `{"email":"jane@example.com","name":"Jane"}`, Header: header, WantStatus: http.StatusAccepted, WantResponse: `*"status":"completed","lines":1,"accepted":1,"rejected":0*`},
+		{Name: "import invalid mapping", Method: "POST", URL: "/messages/imports?template_id=t1&account_id=account1&mapping=email", Body: "email\n", Header: csvHeader, WantStatus: http.StatusBadRequest},
+		{Name: "import unknown format", Method: "POST", URL: "/messages/imports?template_id=t1&account_id=account1", Body: "email\n", Header: header, WantStatus: http.StatusBadRequest, WantResponse: `*format*`},
+		{Name: "import viewer", Method: "POST", URL: "/messages/imports?format=csv&template_id=t1&account_id=account1", Body: "email\n", Header: org.MockHeader(entity.RoleViewer), WantStatus: http.StatusForbidden},
+		{Name: "get import", Method: "GET", URL: "/messages/imports/i1", Header: header, WantStatus: http.StatusOK, WantResponse: `*"status":"processing"*`},
+		{Name: "get import errors", Method: "GET", URL: "/messages/imports/i1/errors", Header: header, WantStatus: http.StatusOK, WantResponse: `*"items":[{"line":7,"email":"jane","error":"must be a valid email address"}]*`},
+		{Name: "get import other org", Method: "GET", URL: "/messages/imports/i2/errors", Header: header, WantStatus: http.StatusNotFound},
+		{Name: "sender", Method: "POST", URL: "/messages", Body: body, Header: org.MockHeader(entity.RoleSender), WantStatus: http.StatusAccepted},
+		{Name: "viewer", Method: "POST", URL: "/messages", Body: body, Header: org.MockHeader(entity.RoleViewer), WantStatus: http.StatusForbidden},
+		{Name: "viewer read", Method: "GET", URL: "/messages/123", Header: org.MockHeader(entity.RoleViewer), WantStatus: http.StatusOK},
+		{Name: "unauthorized", Method: "GET", URL: "/messages", WantStatus: http.StatusUnauthorized},
+	}
+	for _, tc := range tests {
+		test.Endpoint(t, router, tc)
+	}
+}
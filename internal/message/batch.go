@@ -0,0 +1,451 @@
+package message
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/garaekz/gonvelope/internal/audit"
+	"github.com/garaekz/gonvelope/internal/entity"
+	"github.com/garaekz/gonvelope/internal/errors"
+	"github.com/garaekz/gonvelope/internal/template"
+	"github.com/garaekz/gonvelope/pkg/locale"
+	"github.com/garaekz/gonvelope/pkg/mailer"
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/go-ozzo/ozzo-validation/v4/is"
+)
+
+const (
+	// maxBatchRecipients is the maximum number of recipients given in a single batch request.
+	maxBatchRecipients = 10000
+	// listChunkSize is the number of contacts read and queued in each transaction of a batch sent to a list.
+	listChunkSize = 500
+)
+
+// Statuses of the recipients of a batch.
+const (
+	// RecipientAccepted means a message was queued for the recipient.
+	RecipientAccepted = "accepted"
+	// RecipientRejected means the recipient failed validation and no message was queued for it.
+	RecipientRejected = "rejected"
+)
+
+// BatchRequest represents a request to send a template to many recipients.
+type BatchRequest struct {
+	TemplateID string `json:"template_id"`
+	AccountID  string `json:"account_id"`
+	// FromEmail and FromName override the sender of the template.
+	FromEmail string `json:"from_email"`
+	FromName  string `json:"from_name"`
+	// Variables holds the values shared by all recipients.
+	Variables  map[string]interface{} `json:"variables"`
+	Recipients []BatchRecipient       `json:"recipients"`
+	// Attachments are the IDs of the attachments of every message of the batch.
+	Attachments []string `json:"attachments"`
+	// ListID sends the batch to the members of a contact list instead of the recipients,
+	// optionally narrowed by a Filter on their attributes.
+	ListID string `json:"list_id"`
+	Filter string `json:"filter"`
+	// Tracking overrides the tracking setting of the template when given.
+	Tracking *bool `json:"tracking"`
+	// Locale selects the variant of the template sent to every recipient, overriding their own locales.
+	Locale string `json:"locale"`
+}
+
+// BatchRecipient is a recipient of a batch along with the values of the variables for it alone.
+// Locale selects the variant of the template sent to the recipient, falling back to less specific
+// locales and then to the default variant.
+type BatchRecipient struct {
+	Email     string                 `json:"email"`
+	Locale    string                 `json:"locale"`
+	Variables map[string]interface{} `json:"variables"`
+}
+
+// Validate validates the BatchRequest fields. The recipients are validated one by one when the batch is sent.
+func (m BatchRequest) Validate() error {
+	return validation.ValidateStruct(&m,
+		validation.Field(&m.TemplateID, validation.Required),
+		validation.Field(&m.AccountID, validation.Required),
+		validation.Field(&m.FromEmail, validation.Length(0, 254), is.EmailFormat),
+		validation.Field(&m.FromName, validation.Length(0, 128)),
+		validation.Field(&m.Recipients,
+			validation.When(m.ListID == "", validation.Required, validation.Length(1, maxBatchRecipients)),
+			validation.When(m.ListID != "", validation.Empty.Error("must be empty when a list is given"))),
+		validation.Field(&m.Filter, validation.When(m.ListID == "", validation.Empty.Error("requires a list"))),
+		validation.Field(&m.Attachments, validation.Length(0, maxAttachments), validation.Each(validation.Required)),
+		validation.Field(&m.Locale, validation.Length(0, 35), validation.By(validateLocale)),
+	)
+}
+
+// validateLocale checks that the locale, if any, is a locale such as es or es-MX.
+func validateLocale(value interface{}) error {
+	if tag, _ := value.(string); tag != "" && !locale.Valid(tag) {
+		return validation.NewError("validation_locale", "is not a locale such as es or es-MX")
+	}
+	return nil
+}
+
+// BatchResult is the outcome of a batch request.
+type BatchResult struct {
+	Batch entity.Batch `json:"batch"`
+	// Recipients lists the outcome for each recipient, in the order of the request.
+	// Batches sent to a list only list their rejected recipients, in the order of the list.
+	Recipients []RecipientResult `json:"recipients"`
+	// Error explains why a batch sent to a list stopped before reaching all its members. The members
+	// counted by the batch were queued, so the request must not be retried as is.
+	Error string `json:"error,omitempty"`
+}
+
+// RecipientResult is the outcome of a batch request for a single recipient.
+type RecipientResult struct {
+	Index     int    `json:"index"`
+	Email     string `json:"email"`
+	Status    string `json:"status"`
+	MessageID string `json:"message_id,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// BatchStatus reports the delivery progress of a batch.
+type BatchStatus struct {
+	entity.Batch
+	// Messages counts the messages of the batch by status.
+	Messages map[string]int `json:"messages"`
+	// Completed is true once no message of the batch is waiting to be sent.
+	Completed bool `json:"completed"`
+}
+
+// SendBatch renders the template for each recipient and queues the resulting messages in a single transaction,
+// or a chunk at a time when the batch is sent to a list. Recipients with an invalid, duplicate or suppressed
+// address, or lacking a value for a template variable, are rejected without failing the batch.
+func (s service) SendBatch(ctx context.Context, orgID, userID string, req BatchRequest) (BatchResult, error) {
+	if err := req.Validate(); err != nil {
+		return BatchResult{}, err
+	}
+	sender, err := s.newBatchSender(ctx, orgID, userID, req.TemplateID, req.AccountID, req.FromEmail, req.FromName, req.Variables)
+	if err != nil {
+		return BatchResult{}, err
+	}
+	if err := s.checkAttachments(ctx, orgID, req.Attachments); err != nil {
+		return BatchResult{}, err
+	}
+	sender.attachmentIDs = req.Attachments
+	sender.locale = req.Locale
+	if req.Tracking != nil {
+		sender.tracking = *req.Tracking
+	}
+	if req.ListID != "" {
+		return s.sendToList(ctx, sender, req.ListID, req.Filter)
+	}
+	results, messages, err := sender.add(ctx, req.Recipients)
+	if err != nil {
+		return BatchResult{}, err
+	}
+	batch := sender.batch
+
+	err = s.transactional(ctx, func(ctx context.Context) error {
+		if err := s.repo.CreateBatch(ctx, batch); err != nil {
+			return err
+		}
+		for _, message := range messages {
+			if err := s.repo.Create(ctx, message); err != nil {
+				return err
+			}
+		}
+		return s.recordBatch(ctx, batch)
+	})
+	return BatchResult{Batch: batch, Recipients: results}, err
+}
+
+// sendToList sends the batch to the members of the list of the organization matching the filter. The list is
+// expanded when the batch is sent, and read and queued a chunk at a time so that lists of any size can be sent to.
+// Only the rejected members are listed in the result. If a chunk fails once the batch is created, the members
+// queued so far are kept and the result reports the error along with the batch, whose progress can be polled.
+func (s service) sendToList(ctx context.Context, sender *batchSender, listID, filter string) (BatchResult, error) {
+	orgID := sender.batch.OrgID
+	recipients, err := s.listRecipients(ctx, orgID, listID, filter, "")
+	if err != nil {
+		return BatchResult{}, err
+	}
+	if len(recipients) == 0 {
+		return BatchResult{}, errors.InvalidInput(validation.Errors{
+			"list_id": validation.NewError("validation_list_empty", "selects no contacts"),
+		})
+	}
+
+	result := BatchResult{Recipients: []RecipientResult{}}
+	for len(recipients) > 0 {
+		if err = s.sendListChunk(ctx, sender, &result, recipients); err != nil {
+			break
+		}
+		if len(recipients) < listChunkSize {
+			break
+		}
+		// members are read in the order of their addresses, which are unique within the organization
+		last := recipients[len(recipients)-1].Email
+		if recipients, err = s.listRecipients(ctx, orgID, listID, filter, last); err != nil {
+			break
+		}
+	}
+	if result.Batch.ID == "" {
+		return BatchResult{}, err
+	}
+	if err != nil {
+		s.logger.With(ctx, "batch_id", result.Batch.ID).Errorf("failed to send batch to list: %v", err)
+		result.Error = "the batch was only partly queued: " + err.Error()
+	}
+	return result, s.recordBatch(ctx, result.Batch)
+}
+
+// sendListChunk queues the messages of a chunk of list members along with the new counts of the batch,
+// creating the batch with the first chunk. The result is only updated once the chunk is saved.
+func (s service) sendListChunk(ctx context.Context, sender *batchSender, result *BatchResult, recipients []BatchRecipient) error {
+	first := result.Batch.ID == ""
+	index := sender.batch.Total
+	results, messages, err := sender.add(ctx, recipients)
+	if err != nil {
+		return err
+	}
+	err = s.transactional(ctx, func(ctx context.Context) error {
+		if first {
+			if err := s.repo.CreateBatch(ctx, sender.batch); err != nil {
+				return err
+			}
+		}
+		for _, message := range messages {
+			if err := s.repo.Create(ctx, message); err != nil {
+				return err
+			}
+		}
+		if first {
+			return nil
+		}
+		return s.repo.UpdateBatch(ctx, sender.batch)
+	})
+	if err != nil {
+		return err
+	}
+	result.Batch = sender.batch
+	for _, recipient := range results {
+		if recipient.Status == RecipientRejected {
+			recipient.Index += index
+			result.Recipients = append(result.Recipients, recipient)
+		}
+	}
+	return nil
+}
+
+// recordBatch records the batch queued by the user in the audit log.
+func (s service) recordBatch(ctx context.Context, batch entity.Batch) error {
+	return s.auditor.Record(ctx, audit.Event{
+		Action:     audit.ActionBatchQueued,
+		OrgID:      batch.OrgID,
+		ActorID:    batch.UserID,
+		TargetType: "batch",
+		TargetID:   batch.ID,
+		Data: map[string]interface{}{
+			"template_id": batch.TemplateID,
+			"account_id":  batch.AccountID,
+			"accepted":    batch.Accepted,
+			"rejected":    batch.Rejected,
+		},
+	})
+}
+
+// listRecipients returns up to listChunkSize members of the list of the organization matching the filter as
+// batch recipients, starting after the given address. The name and attributes of each contact become its variables,
+// and each contact receives the variant of the template for its locale.
+func (s service) listRecipients(ctx context.Context, orgID, listID, filter, after string) ([]BatchRecipient, error) {
+	contacts, err := s.listMembers(ctx, orgID, listID, filter, after, listChunkSize)
+	if err == sql.ErrNoRows {
+		return nil, errors.InvalidInput(validation.Errors{
+			"list_id": validation.NewError("validation_list_unknown", "is not a contact list of the organization"),
+		})
+	}
+	if err != nil {
+		return nil, err
+	}
+	recipients := make([]BatchRecipient, len(contacts))
+	for i, contact := range contacts {
+		vars := map[string]interface{}{}
+		if err := json.Unmarshal(contact.Attributes, &vars); err != nil {
+			return nil, err
+		}
+		vars["name"] = contact.Name
+		recipients[i] = BatchRecipient{Email: contact.Email, Locale: contact.Locale, Variables: vars}
+	}
+	return recipients, nil
+}
+
+// batchSender renders the template of a batch for its recipients, which may be added a chunk at a time.
+type batchSender struct {
+	suppressed          SuppressedFunc
+	batch               entity.Batch
+	template            entity.Template
+	fromEmail, fromName string
+	shared              map[string]interface{}
+	attachmentIDs       []string
+	tracking            bool
+	// locale overrides the locales of the recipients when set.
+	locale string
+	// rejected maps the lowercase addresses that must be rejected to the reason why.
+	rejected map[string]string
+	now      time.Time
+}
+
+// newBatchSender checks that the account and the template belong to the organization and that the batch has a sender
+// the organization may send from. The sender of the template is used unless fromEmail is given.
+func (s service) newBatchSender(ctx context.Context, orgID, userID, templateID, accountID, fromEmail, fromName string, shared map[string]interface{}) (*batchSender, error) {
+	account, err := s.getAccount(ctx, orgID, accountID)
+	if err != nil {
+		return nil, err
+	}
+	tmpl, err := s.repo.GetTemplate(ctx, orgID, templateID)
+	if err == sql.ErrNoRows {
+		return nil, errors.InvalidInput(validation.Errors{
+			"template_id": validation.NewError("validation_template_unknown", "is not a template of the organization"),
+		})
+	}
+	if err != nil {
+		return nil, err
+	}
+	if fromEmail == "" {
+		fromEmail, fromName = tmpl.FromEmail, tmpl.FromName
+	}
+	if fromEmail == "" {
+		return nil, errors.InvalidInput(validation.Errors{
+			"from_email": validation.NewError("validation_required", "is required when the template has no sender"),
+		})
+	}
+	if err := s.checkSender(ctx, account, fromEmail); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	return &batchSender{
+		suppressed: s.suppressed,
+		batch: entity.Batch{
+			ID:         entity.GenerateID(),
+			OrgID:      orgID,
+			UserID:     userID,
+			TemplateID: tmpl.ID,
+			AccountID:  accountID,
+			CreatedAt:  now,
+		},
+		template:  tmpl,
+		fromEmail: fromEmail,
+		fromName:  fromName,
+		shared:    shared,
+		tracking:  tmpl.Tracking,
+		rejected:  map[string]string{},
+		now:       now,
+	}, nil
+}
+
+// add validates the recipients and renders the template for them. It returns the outcome for each recipient
+// and the messages of the accepted ones. Duplicates of the recipients added earlier are rejected.
+func (b *batchSender) add(ctx context.Context, recipients []BatchRecipient) ([]RecipientResult, []entity.Message, error) {
+	emails := make([]string, len(recipients))
+	for i, recipient := range recipients {
+		emails[i] = strings.TrimSpace(recipient.Email)
+	}
+	suppressed, err := b.suppressed(ctx, b.batch.OrgID, emails)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, email := range suppressed {
+		b.rejected[strings.ToLower(email)] = "is suppressed"
+	}
+
+	results := make([]RecipientResult, len(recipients))
+	var messages []entity.Message
+	for i, recipient := range recipients {
+		email := emails[i]
+		results[i] = RecipientResult{Index: i, Email: email, Status: RecipientRejected}
+		b.batch.Total++
+		tag := b.locale
+		if tag == "" {
+			tag = recipient.Locale
+		}
+		content, err := renderRecipient(template.Localize(b.template, tag), email, b.shared, recipient.Variables, b.rejected)
+		if err != nil {
+			results[i].Error = err.Error()
+			b.batch.Rejected++
+			continue
+		}
+		// later occurrences of the same address are duplicates
+		b.rejected[strings.ToLower(email)] = "is a duplicate recipient"
+
+		message := entity.Message{
+			ID:            entity.GenerateID(),
+			OrgID:         b.batch.OrgID,
+			UserID:        b.batch.UserID,
+			AccountID:     b.batch.AccountID,
+			BatchID:       b.batch.ID,
+			TemplateID:    b.batch.TemplateID,
+			MessageID:     mailer.NewMessageID(b.fromEmail),
+			FromEmail:     b.fromEmail,
+			FromName:      b.fromName,
+			To:            []string{email},
+			Subject:       content.Subject,
+			Text:          content.Text,
+			HTML:          content.HTML,
+			AttachmentIDs: b.attachmentIDs,
+			Tracking:      b.tracking,
+			Status:        entity.MessageQueued,
+			NextAttemptAt: b.now,
+			CreatedAt:     &b.now,
+			UpdatedAt:     &b.now,
+		}
+		messages = append(messages, message)
+		results[i].Status = RecipientAccepted
+		results[i].MessageID = message.ID
+		b.batch.Accepted++
+	}
+	return results, messages, nil
+}
+
+// reject counts a recipient that could not even be read as rejected.
+func (b *batchSender) reject() {
+	b.batch.Total++
+	b.batch.Rejected++
+}
+
+// renderRecipient validates the address of a batch recipient and renders the template for it.
+// The values of the recipient override the shared ones, and the email variable defaults to the address.
+// rejected maps the lowercase addresses that must be rejected to the reason why.
+func renderRecipient(t entity.Template, email string, shared, own map[string]interface{}, rejected map[string]string) (template.Content, error) {
+	if err := validation.Validate(email, validation.Required, validation.Length(0, 254), is.EmailFormat); err != nil {
+		return template.Content{}, err
+	}
+	if reason, ok := rejected[strings.ToLower(email)]; ok {
+		return template.Content{}, validation.NewError("validation_recipient_rejected", reason)
+	}
+	vars := map[string]interface{}{"email": email}
+	for name, value := range shared {
+		vars[name] = value
+	}
+	for name, value := range own {
+		vars[name] = value
+	}
+	return template.Render(t, vars)
+}
+
+// GetBatch returns the batch with the specified ID owned by the organization along with the progress of its messages.
+func (s service) GetBatch(ctx context.Context, orgID, id string) (BatchStatus, error) {
+	batch, err := s.repo.GetBatch(ctx, orgID, id)
+	if err != nil {
+		return BatchStatus{}, err
+	}
+	counts, err := s.repo.CountBatchMessages(ctx, id)
+	if err != nil {
+		return BatchStatus{}, err
+	}
+	status := BatchStatus{Batch: batch, Messages: map[string]int{}}
+	for _, name := range []string{entity.MessageQueued, entity.MessageSending, entity.MessageSent, entity.MessageFailed, entity.MessageBounced} {
+		status.Messages[name] = counts[name]
+	}
+	status.Completed = counts[entity.MessageQueued] == 0 && counts[entity.MessageSending] == 0
+	return status, nil
+}
@@ -0,0 +1,382 @@
+package message
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/garaekz/gonvelope/internal/audit"
+	"github.com/garaekz/gonvelope/internal/entity"
+	"github.com/garaekz/gonvelope/internal/errors"
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/go-ozzo/ozzo-validation/v4/is"
+)
+
+const (
+	// importChunkSize is the number of recipients queued in each transaction of an import.
+	// The progress of the import is saved after each chunk.
+	importChunkSize = 500
+	// maxImportErrors is the maximum number of rejected lines recorded for an import.
+	maxImportErrors = 1000
+	// maxImportLineLength is the maximum length of a line of a JSONL file.
+	maxImportLineLength = 1 << 20
+)
+
+// ImportRequest represents a request to send a template to the recipients listed in a file.
+type ImportRequest struct {
+	TemplateID string `json:"template_id"`
+	AccountID  string `json:"account_id"`
+	FromEmail  string `json:"from_email"`
+	FromName   string `json:"from_name"`
+	Format     string `json:"format"`
+	// Mapping maps template variables to the columns of a CSV file or the fields of a JSONL file.
+	// The email variable holds the address of the recipient. Without a mapping, every column is used
+	// as the variable of the same name.
+	Mapping map[string]string `json:"mapping"`
+	// DryRun validates the file without queueing any message.
+	DryRun bool `json:"dry_run"`
+	// Locale selects the variant of the template sent to every recipient. Otherwise the locale
+	// variable of each recipient, if any, selects it.
+	Locale string `json:"locale"`
+}
+
+// Validate validates the ImportRequest fields.
+func (m ImportRequest) Validate() error {
+	return validation.ValidateStruct(&m,
+		validation.Field(&m.TemplateID, validation.Required),
+		validation.Field(&m.AccountID, validation.Required),
+		validation.Field(&m.FromEmail, validation.Length(0, 254), is.EmailFormat),
+		validation.Field(&m.FromName, validation.Length(0, 128)),
+		validation.Field(&m.Format, validation.Required, validation.In(entity.ImportCSV, entity.ImportJSONL)),
+		validation.Field(&m.Locale, validation.Length(0, 35), validation.By(validateLocale)),
+	)
+}
+
+// ImportResult is the outcome of an import along with the first lines it rejected.
+type ImportResult struct {
+	entity.BatchImport
+	Errors []entity.ImportError `json:"errors"`
+}
+
+// ImportBatch reads the recipients of a CSV or JSONL file and queues a message for each valid one.
+// The file is read as a stream and the messages are queued a chunk at a time, saving the progress of
+// the import after each chunk so that it can be followed with GetImport while the file is uploaded.
+// Lines that cannot be read or whose recipient is rejected are recorded with their line number.
+func (s service) ImportBatch(ctx context.Context, orgID, userID string, req ImportRequest, r io.Reader) (ImportResult, error) {
+	if err := req.Validate(); err != nil {
+		return ImportResult{}, err
+	}
+	var reader recipientReader
+	var err error
+	if req.Format == entity.ImportCSV {
+		reader, err = newCSVReader(r, req.Mapping)
+	} else {
+		reader = newJSONLReader(r, req.Mapping)
+	}
+	if err != nil {
+		return ImportResult{}, err
+	}
+	sender, err := s.newBatchSender(ctx, orgID, userID, req.TemplateID, req.AccountID, req.FromEmail, req.FromName, nil)
+	if err != nil {
+		return ImportResult{}, err
+	}
+	sender.locale = req.Locale
+
+	result := ImportResult{
+		BatchImport: entity.BatchImport{
+			ID:         entity.GenerateID(),
+			OrgID:      orgID,
+			UserID:     userID,
+			TemplateID: sender.batch.TemplateID,
+			AccountID:  sender.batch.AccountID,
+			Format:     req.Format,
+			DryRun:     req.DryRun,
+			Status:     entity.ImportProcessing,
+			CreatedAt:  sender.now,
+			UpdatedAt:  sender.now,
+		},
+		Errors: []entity.ImportError{},
+	}
+	if !req.DryRun {
+		result.BatchID = sender.batch.ID
+	}
+	err = s.transactional(ctx, func(ctx context.Context) error {
+		if !req.DryRun {
+			if err := s.repo.CreateBatch(ctx, sender.batch); err != nil {
+				return err
+			}
+		}
+		return s.repo.CreateImport(ctx, result.BatchImport)
+	})
+	if err != nil {
+		return ImportResult{}, err
+	}
+
+	if err := s.importRecipients(ctx, reader, sender, &result); err != nil {
+		// the import is marked as failed even if the request was cancelled
+		ctx = context.WithoutCancel(ctx)
+		result.Status = entity.ImportFailed
+		result.Error = err.Error()
+		result.UpdatedAt = time.Now()
+		if err := s.repo.UpdateImport(ctx, result.BatchImport); err != nil {
+			return ImportResult{}, err
+		}
+		s.logger.With(ctx, "import_id", result.ID).Infof("failed to import recipients: %v", err)
+		return result, nil
+	}
+
+	result.Status = entity.ImportCompleted
+	result.UpdatedAt = time.Now()
+	return result, s.transactional(ctx, func(ctx context.Context) error {
+		if err := s.repo.UpdateImport(ctx, result.BatchImport); err != nil {
+			return err
+		}
+		if req.DryRun {
+			return nil
+		}
+		return s.auditor.Record(ctx, audit.Event{
+			Action:     audit.ActionBatchQueued,
+			OrgID:      orgID,
+			ActorID:    userID,
+			TargetType: "batch",
+			TargetID:   sender.batch.ID,
+			Data: map[string]interface{}{
+				"template_id": sender.batch.TemplateID,
+				"account_id":  sender.batch.AccountID,
+				"import_id":   result.ID,
+				"accepted":    sender.batch.Accepted,
+				"rejected":    sender.batch.Rejected,
+			},
+		})
+	})
+}
+
+// importRecipients reads the recipients of the file and queues their messages a chunk at a time.
+func (s service) importRecipients(ctx context.Context, reader recipientReader, sender *batchSender, result *ImportResult) error {
+	var recipients []BatchRecipient
+	var lines []int
+	var rejected []entity.ImportError
+	for {
+		recipient, line, err := reader.Read()
+		if err == io.EOF {
+			return s.importChunk(ctx, sender, result, recipients, lines, rejected)
+		}
+		if lineErr, ok := err.(*lineError); ok {
+			sender.reject()
+			rejected = append(rejected, entity.ImportError{Line: lineErr.Line, Error: lineErr.Err.Error()})
+		} else if err != nil {
+			return err
+		} else {
+			recipients = append(recipients, recipient)
+			lines = append(lines, line)
+		}
+		if len(recipients)+len(rejected) >= importChunkSize {
+			if err := s.importChunk(ctx, sender, result, recipients, lines, rejected); err != nil {
+				return err
+			}
+			recipients, lines, rejected = nil, nil, nil
+		}
+	}
+}
+
+// importChunk queues the messages of a chunk of recipients read from the given lines, records the lines
+// rejected, and saves the progress of the import.
+func (s service) importChunk(ctx context.Context, sender *batchSender, result *ImportResult, recipients []BatchRecipient, lines []int, rejected []entity.ImportError) error {
+	results, messages, err := sender.add(ctx, recipients)
+	if err != nil {
+		return err
+	}
+	for i, recipient := range results {
+		if recipient.Status == RecipientRejected {
+			rejected = append(rejected, entity.ImportError{Line: lines[i], Email: recipient.Email, Error: recipient.Error})
+		}
+	}
+	result.Lines = sender.batch.Total
+	result.Accepted = sender.batch.Accepted
+	result.Rejected = sender.batch.Rejected
+	result.UpdatedAt = time.Now()
+	// only the first errors are kept, in the order of the file
+	sort.Slice(rejected, func(i, j int) bool { return rejected[i].Line < rejected[j].Line })
+	if room := maxImportErrors - len(result.Errors); len(rejected) > room {
+		rejected = rejected[:room]
+	}
+	for i := range rejected {
+		rejected[i].ImportID = result.ID
+	}
+	result.Errors = append(result.Errors, rejected...)
+
+	return s.transactional(ctx, func(ctx context.Context) error {
+		if !result.DryRun {
+			for _, message := range messages {
+				if err := s.repo.Create(ctx, message); err != nil {
+					return err
+				}
+			}
+			if err := s.repo.UpdateBatch(ctx, sender.batch); err != nil {
+				return err
+			}
+		}
+		for _, item := range rejected {
+			if err := s.repo.CreateImportError(ctx, item); err != nil {
+				return err
+			}
+		}
+		return s.repo.UpdateImport(ctx, result.BatchImport)
+	})
+}
+
+// GetImport returns the import with the specified ID owned by the organization.
+func (s service) GetImport(ctx context.Context, orgID, id string) (entity.BatchImport, error) {
+	return s.repo.GetImport(ctx, orgID, id)
+}
+
+// CountImportErrors returns the number of rejected lines recorded for an import of the organization.
+func (s service) CountImportErrors(ctx context.Context, orgID, id string) (int, error) {
+	if _, err := s.repo.GetImport(ctx, orgID, id); err != nil {
+		return 0, err
+	}
+	return s.repo.CountImportErrors(ctx, id)
+}
+
+// QueryImportErrors returns the rejected lines recorded for an import of the organization, in the order of the file.
+func (s service) QueryImportErrors(ctx context.Context, orgID, id string, offset, limit int) ([]entity.ImportError, error) {
+	if _, err := s.repo.GetImport(ctx, orgID, id); err != nil {
+		return nil, err
+	}
+	return s.repo.QueryImportErrors(ctx, id, offset, limit)
+}
+
+// recipientReader reads the recipients listed in a file one at a time.
+type recipientReader interface {
+	// Read returns the next recipient and the line it starts on, or io.EOF at the end of the file.
+	// A line that cannot be read is reported as a *lineError, after which reading can go on.
+	Read() (BatchRecipient, int, error)
+}
+
+// lineError is an error found on a line of an imported file.
+type lineError struct {
+	Line int
+	Err  error
+}
+
+// Error implements the error interface.
+func (e *lineError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+// csvReader reads the recipients of a CSV file with a header line.
+type csvReader struct {
+	reader *csv.Reader
+	// columns maps the variables to the index of their column.
+	columns map[string]int
+}
+
+// newCSVReader reads the header line of the CSV file and resolves the columns of the mapping.
+func newCSVReader(r io.Reader, mapping map[string]string) (*csvReader, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.ReuseRecord = true
+	header, err := reader.Read()
+	if err != nil {
+		return nil, errors.BadRequest("The file must be a CSV file with a header line")
+	}
+	indexes := map[string]int{}
+	for i, name := range header {
+		// spreadsheet applications may start the file with a byte order mark
+		indexes[strings.TrimSpace(strings.TrimPrefix(name, "\ufeff"))] = i
+	}
+
+	columns := map[string]int{}
+	if len(mapping) == 0 {
+		columns = indexes
+	}
+	for variable, column := range mapping {
+		index, ok := indexes[column]
+		if !ok {
+			return nil, errors.BadRequest(fmt.Sprintf("The file has no %q column to map to %s", column, variable))
+		}
+		columns[variable] = index
+	}
+	if _, ok := columns["email"]; !ok {
+		return nil, errors.BadRequest("The file must have an email column or a mapping for email")
+	}
+	return &csvReader{reader, columns}, nil
+}
+
+// Read returns the next recipient of the file.
+func (r *csvReader) Read() (BatchRecipient, int, error) {
+	record, err := r.reader.Read()
+	if parseErr, ok := err.(*csv.ParseError); ok {
+		return BatchRecipient{}, parseErr.StartLine, &lineError{parseErr.StartLine, parseErr.Err}
+	}
+	if err != nil {
+		return BatchRecipient{}, 0, err
+	}
+	line, _ := r.reader.FieldPos(0)
+	recipient := BatchRecipient{Variables: map[string]interface{}{}}
+	for variable, index := range r.columns {
+		// short lines leave the variables of their missing columns unset
+		if index < len(record) {
+			recipient.Variables[variable] = strings.TrimSpace(record[index])
+		}
+	}
+	recipient.Email, _ = recipient.Variables["email"].(string)
+	recipient.Locale, _ = recipient.Variables["locale"].(string)
+	return recipient, line, nil
+}
+
+// jsonlReader reads the recipients of a file with a JSON object per line.
+type jsonlReader struct {
+	scanner *bufio.Scanner
+	mapping map[string]string
+	line    int
+}
+
+// newJSONLReader creates a reader for the JSONL file.
+func newJSONLReader(r io.Reader, mapping map[string]string) *jsonlReader {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxImportLineLength)
+	return &jsonlReader{scanner: scanner, mapping: mapping}
+}
+
+// Read returns the next recipient of the file. Blank lines are skipped.
+func (r *jsonlReader) Read() (BatchRecipient, int, error) {
+	for r.scanner.Scan() {
+		r.line++
+		data := bytes.TrimSpace(r.scanner.Bytes())
+		if len(data) == 0 {
+			continue
+		}
+		var fields map[string]interface{}
+		if err := json.Unmarshal(data, &fields); err != nil {
+			return BatchRecipient{}, r.line, &lineError{r.line, fmt.Errorf("invalid JSON object: %v", err)}
+		}
+		recipient := BatchRecipient{Variables: fields}
+		if len(r.mapping) > 0 {
+			recipient.Variables = map[string]interface{}{}
+			for variable, field := range r.mapping {
+				if value, ok := fields[field]; ok {
+					recipient.Variables[variable] = value
+				}
+			}
+		}
+		if email, ok := recipient.Variables["email"].(string); ok {
+			recipient.Email = email
+		}
+		recipient.Locale, _ = recipient.Variables["locale"].(string)
+		return recipient, r.line, nil
+	}
+	if err := r.scanner.Err(); err == bufio.ErrTooLong {
+		return BatchRecipient{}, 0, fmt.Errorf("line %d is longer than %d bytes", r.line+1, maxImportLineLength)
+	} else if err != nil {
+		return BatchRecipient{}, 0, err
+	}
+	return BatchRecipient{}, 0, io.EOF
+}
@@ -0,0 +1,176 @@
+package message
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/garaekz/gonvelope/internal/audit"
+	"github.com/garaekz/gonvelope/internal/entity"
+	"github.com/garaekz/gonvelope/internal/errors"
+	"github.com/garaekz/gonvelope/pkg/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// readAll returns the recipients read by the reader along with their lines, and the errors by line.
+func readAll(t *testing.T, reader recipientReader) ([]BatchRecipient, []int, map[int]string) {
+	var recipients []BatchRecipient
+	var lines []int
+	errs := map[int]string{}
+	for {
+		recipient, line, err := reader.Read()
+		if err == io.EOF {
+			return recipients, lines, errs
+		}
+		if lineErr, ok := err.(*lineError); ok {
+			errs[lineErr.Line] = lineErr.Err.Error()
+			continue
+		}
+		require.Nil(t, err)
+		recipients = append(recipients, recipient)
+		lines = append(lines, line)
+	}
+}
+
+func Test_csvReader(t *testing.T) {
+	file := "\ufeffEmail Address,First Name,Plan\n" +
+		"jane@example.com,Jane,pro\n" +
+		"\"john@example.com\",\"John\nJr.\",free\n" +
+		"ann@example.com\n" +
+		"bad,\"quote\n"
+	reader, err := newCSVReader(strings.NewReader(file), map[string]string{"email": "Email Address", "name": "First Name"})
+	require.Nil(t, err)
+	recipients, lines, errs := readAll(t, reader)
+	if assert.Len(t, recipients, 3) {
+		assert.Equal(t, BatchRecipient{Email: "jane@example.com", Variables: map[string]interface{}{"email": "jane@example.com", "name": "Jane"}}, recipients[0])
+		assert.Equal(t, "John\nJr.", recipients[1].Variables["name"])
+		// short lines leave variables unset
+		assert.Equal(t, map[string]interface{}{"email": "ann@example.com"}, recipients[2].Variables)
+	}
+	assert.Equal(t, []int{2, 3, 5}, lines)
+	assert.Contains(t, errs[6], "quote")
+
+	// without a mapping, columns are used by name
+	reader, err = newCSVReader(strings.NewReader("email,name,locale\njane@example.com,Jane,es-MX\n"), nil)
+	require.Nil(t, err)
+	recipients, _, _ = readAll(t, reader)
+	assert.Equal(t, map[string]interface{}{"email": "jane@example.com", "name": "Jane", "locale": "es-MX"}, recipients[0].Variables)
+	// the locale variable selects the variant of the template
+	assert.Equal(t, "es-MX", recipients[0].Locale)
+
+	_, err = newCSVReader(strings.NewReader("Email Address\n"), map[string]string{"email": "Email Address", "name": "Name"})
+	assert.NotNil(t, err)
+	_, err = newCSVReader(strings.NewReader("address,name\n"), nil)
+	assert.NotNil(t, err)
+	_, err = newCSVReader(strings.NewReader(""), nil)
+	assert.NotNil(t, err)
+}
+
+func Test_jsonlReader(t *testing.T) {
+	file := `{"email":"jane@example.com","name":"Jane","company":{"name":"Acme"}}` + "\n" +
+		"\n" +
+		`{"email":"john@example.com",` + "\n" +
+		`{"mail":"ann@example.com","first":"Ann"}` + "\n" +
+		`{"email":42}`
+	recipients, lines, errs := readAll(t, newJSONLReader(strings.NewReader(file), nil))
+	if assert.Len(t, recipients, 3) {
+		assert.Equal(t, "jane@example.com", recipients[0].Email)
+		assert.Equal(t, map[string]interface{}{"name": "Acme"}, recipients[0].Variables["company"])
+		assert.Equal(t, "", recipients[1].Email)
+		assert.Equal(t, "", recipients[2].Email)
+	}
+	assert.Equal(t, []int{1, 4, 5}, lines)
+	assert.Contains(t, errs[3], "invalid JSON object")
+
+	recipients, _, _ = readAll(t, newJSONLReader(strings.NewReader(`{"mail":"ann@example.com","first":"Ann","last":"Lee"}`), map[string]string{"email": "mail", "name": "first"}))
+	assert.Equal(t, BatchRecipient{Email: "ann@example.com", Variables: map[string]interface{}{"email": "ann@example.com", "name": "Ann"}}, recipients[0])
+
+	reader := newJSONLReader(strings.NewReader(`{"email":"`+strings.Repeat("a", maxImportLineLength)+`"}`), nil)
+	_, _, err := reader.Read()
+	assert.Contains(t, err.Error(), "line 1 is longer")
+}
+
+func Test_service_ImportBatch(t *testing.T) {
+	logger, _ := log.NewForTest()
+	repo := &mockRepository{
+		accounts:  map[string]string{"account1": "org1"},
+		templates: []entity.Template{{ID: "t1", OrgID: "org1", Subject: "Hi {{name}}", Body: "Hello", FromEmail: "sales@example.com"}},
+	}
+	auditor := &mockRecorder{}
+	s := NewService(repo, mockTransactional, mockSuppressed, mockListMembers, mockVerified, auditor, logger)
+	ctx := context.Background()
+	req := ImportRequest{TemplateID: "t1", AccountID: "account1", Format: entity.ImportCSV, Mapping: map[string]string{"email": "Email", "name": "Name"}}
+
+	// a file spanning several chunks
+	var file strings.Builder
+	file.WriteString("Email,Name\n")
+	for i := 0; i < importChunkSize+10; i++ {
+		fmt.Fprintf(&file, "user%d@example.com,User %d\n", i, i)
+	}
+	file.WriteString("user1@example.com,Again\nunsubscribed@example.com,Unsubscribed\njane,Jane\njohn@example.com\n")
+
+	req.DryRun = true
+	result, err := s.ImportBatch(ctx, "org1", "100", req, strings.NewReader(file.String()))
+	require.Nil(t, err)
+	assert.Equal(t, entity.ImportCompleted, result.Status)
+	assert.Equal(t, importChunkSize+14, result.Lines)
+	assert.Equal(t, importChunkSize+10, result.Accepted)
+	assert.Equal(t, 4, result.Rejected)
+	assert.Equal(t, "", result.BatchID)
+	lines := make([]int, len(result.Errors))
+	for i, item := range result.Errors {
+		lines[i] = item.Line
+	}
+	assert.Equal(t, []int{importChunkSize + 12, importChunkSize + 13, importChunkSize + 14, importChunkSize + 15}, lines)
+	assert.Equal(t, "is a duplicate recipient", result.Errors[0].Error)
+	assert.Equal(t, "missing variables: name", result.Errors[3].Error)
+	assert.Empty(t, repo.items)
+	assert.Empty(t, repo.batches)
+	assert.Empty(t, auditor.events)
+	count, _ := s.CountImportErrors(ctx, "org1", result.ID)
+	assert.Equal(t, 4, count)
+
+	req.DryRun = false
+	result, err = s.ImportBatch(ctx, "org1", "100", req, strings.NewReader(file.String()))
+	require.Nil(t, err)
+	assert.Equal(t, entity.ImportCompleted, result.Status)
+	assert.Len(t, repo.items, importChunkSize+10)
+	if assert.Len(t, repo.batches, 1) {
+		assert.Equal(t, result.BatchID, repo.batches[0].ID)
+		assert.Equal(t, importChunkSize+14, repo.batches[0].Total)
+		assert.Equal(t, importChunkSize+10, repo.batches[0].Accepted)
+	}
+	assert.Equal(t, result.BatchID, repo.items[0].BatchID)
+	assert.Equal(t, "Hi User 0", repo.items[0].Subject)
+	if assert.Len(t, auditor.events, 1) {
+		assert.Equal(t, audit.ActionBatchQueued, auditor.events[0].Action)
+	}
+	saved, err := s.GetImport(ctx, "org1", result.ID)
+	require.Nil(t, err)
+	assert.Equal(t, result.BatchImport, saved)
+
+	// a file that cannot be read to the end fails the import
+	jsonl := `{"email":"ann@example.com","name":"Ann"}` + "\n" + `{"name":"` + strings.Repeat("a", maxImportLineLength) + `"}`
+	result, err = s.ImportBatch(ctx, "org1", "100", ImportRequest{TemplateID: "t1", AccountID: "account1", Format: entity.ImportJSONL}, strings.NewReader(jsonl))
+	require.Nil(t, err)
+	assert.Equal(t, entity.ImportFailed, result.Status)
+	assert.Contains(t, result.Error, "line 2 is longer")
+
+	// the header is checked before anything is saved
+	_, err = s.ImportBatch(ctx, "org1", "100", req, strings.NewReader("Address\njane@example.com\n"))
+	assert.Equal(t, http.StatusBadRequest, err.(errors.ErrorResponse).StatusCode())
+	_, err = s.ImportBatch(ctx, "org2", "100", req, strings.NewReader(file.String()))
+	assert.Equal(t, http.StatusBadRequest, err.(errors.ErrorResponse).StatusCode())
+	_, err = s.ImportBatch(ctx, "org1", "100", ImportRequest{TemplateID: "t1", AccountID: "account1", Format: "xlsx"}, strings.NewReader(""))
+	assert.NotNil(t, err)
+	assert.Len(t, repo.imports, 3)
+
+	_, err = s.GetImport(ctx, "org2", result.ID)
+	assert.NotNil(t, err)
+	_, err = s.QueryImportErrors(ctx, "org2", result.ID, 0, 10)
+	assert.NotNil(t, err)
+}
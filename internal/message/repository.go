@@ -0,0 +1,313 @@
+package message
+
+import (
+	"context"
+	"time"
+
+	"github.com/garaekz/gonvelope/internal/entity"
+	"github.com/garaekz/gonvelope/pkg/dbcontext"
+	"github.com/garaekz/gonvelope/pkg/log"
+	dbx "github.com/go-ozzo/ozzo-dbx"
+)
+
+// Repository encapsulates the logic to access messages from the data source.
+type Repository interface {
+	// Get returns the message with the specified ID owned by the organization.
+	Get(ctx context.Context, orgID, id string) (entity.Message, error)
+	// Count returns the number of messages owned by the organization.
+	Count(ctx context.Context, orgID string) (int, error)
+	// Query returns the list of messages owned by the organization with the given offset and limit, newest first.
+	Query(ctx context.Context, orgID string, offset, limit int) ([]entity.Message, error)
+	// Create saves a new message in the storage.
+	Create(ctx context.Context, message entity.Message) error
+	// Update saves the delivery state of the message.
+	Update(ctx context.Context, message entity.Message) error
+	// Finish saves the final delivery state of the message and adds the stats to the rollups in a single transaction.
+	Finish(ctx context.Context, message entity.Message, stats entity.MessageStats) error
+	// RollUpEvents adds up to limit message events not counted yet to the rollups and returns how many were counted.
+	RollUpEvents(ctx context.Context, limit int) (int, error)
+	// GetAccount returns the provider account with the specified ID owned by the organization.
+	GetAccount(ctx context.Context, orgID, accountID string) (entity.UserProviderAccount, error)
+	// GetTemplate returns the template with the specified ID owned by the organization.
+	GetTemplate(ctx context.Context, orgID, id string) (entity.Template, error)
+	// GetAttachments returns the attachments with the specified IDs owned by the organization.
+	GetAttachments(ctx context.Context, orgID string, ids []string) ([]entity.Attachment, error)
+	// CreateBatch saves a new batch in the storage.
+	CreateBatch(ctx context.Context, batch entity.Batch) error
+	// UpdateBatch saves the recipient counts of the batch.
+	UpdateBatch(ctx context.Context, batch entity.Batch) error
+	// GetBatch returns the batch with the specified ID owned by the organization.
+	GetBatch(ctx context.Context, orgID, id string) (entity.Batch, error)
+	// CountBatchMessages returns the number of messages of the batch by status.
+	CountBatchMessages(ctx context.Context, batchID string) (map[string]int, error)
+	// CreateImport saves a new import in the storage.
+	CreateImport(ctx context.Context, item entity.BatchImport) error
+	// UpdateImport saves the progress of the import.
+	UpdateImport(ctx context.Context, item entity.BatchImport) error
+	// GetImport returns the import with the specified ID owned by the organization.
+	GetImport(ctx context.Context, orgID, id string) (entity.BatchImport, error)
+	// CreateImportError saves a line rejected by an import.
+	CreateImportError(ctx context.Context, item entity.ImportError) error
+	// CountImportErrors returns the number of lines recorded as rejected by the import.
+	CountImportErrors(ctx context.Context, importID string) (int, error)
+	// QueryImportErrors returns the lines rejected by the import with the given offset and limit, in the order of the file.
+	QueryImportErrors(ctx context.Context, importID string, offset, limit int) ([]entity.ImportError, error)
+	// QueryEvents returns the events of the message, oldest first.
+	QueryEvents(ctx context.Context, id string) ([]entity.MessageEvent, error)
+	// Claim hands the given number of messages due at the given time over to a worker until leaseUntil.
+	// Messages whose lease has expired are claimed again.
+	Claim(ctx context.Context, now, leaseUntil time.Time, limit int) ([]entity.Message, error)
+}
+
+// repository persists messages in database
+type repository struct {
+	db     *dbcontext.DB
+	logger log.Logger
+}
+
+// NewRepository creates a new message repository
+func NewRepository(db *dbcontext.DB, logger log.Logger) Repository {
+	return repository{db, logger}
+}
+
+// Get returns the message with the specified ID owned by the organization.
+func (r repository) Get(ctx context.Context, orgID, id string) (entity.Message, error) {
+	var message entity.Message
+	err := r.db.With(ctx).Select().From(message.TableName()).
+		Where(dbx.HashExp{"id": id, "org_id": orgID}).One(&message)
+	return message, err
+}
+
+// Count returns the number of messages owned by the organization.
+func (r repository) Count(ctx context.Context, orgID string) (int, error) {
+	var count int
+	err := r.db.With(ctx).Select("COUNT(*)").From(entity.Message{}.TableName()).
+		Where(dbx.HashExp{"org_id": orgID}).Row(&count)
+	return count, err
+}
+
+// Query returns the list of messages owned by the organization with the given offset and limit, newest first.
+func (r repository) Query(ctx context.Context, orgID string, offset, limit int) ([]entity.Message, error) {
+	var messages []entity.Message
+	err := r.db.With(ctx).Select().From(entity.Message{}.TableName()).
+		Where(dbx.HashExp{"org_id": orgID}).
+		OrderBy("created_at DESC", "id DESC").
+		Offset(int64(offset)).
+		Limit(int64(limit)).
+		All(&messages)
+	return messages, err
+}
+
+// Create saves a new message in the storage.
+func (r repository) Create(ctx context.Context, message entity.Message) error {
+	return r.db.With(ctx).Model(&message).Exclude("CreatedAt", "UpdatedAt").Insert()
+}
+
+// Update saves the delivery state of the message.
+func (r repository) Update(ctx context.Context, message entity.Message) error {
+	return r.db.With(ctx).Model(&message).Update("Status", "Attempts", "NextAttemptAt", "LastError", "ProviderMessageID", "SentAt", "UpdatedAt")
+}
+
+// Finish saves the final delivery state of the message and adds the stats to the rollups in a single transaction.
+func (r repository) Finish(ctx context.Context, message entity.Message, stats entity.MessageStats) error {
+	return r.db.Transactional(ctx, func(ctx context.Context) error {
+		if err := r.Update(ctx, message); err != nil {
+			return err
+		}
+		_, err := r.db.With(ctx).NewQuery(`
+			INSERT INTO message_stats (org_id, period, template_id, account_id, sent, failed)
+			VALUES ({:org_id}, {:period}, {:template_id}, {:account_id}, {:sent}, {:failed})
+			ON CONFLICT (org_id, period, template_id, account_id) DO UPDATE SET
+				sent = message_stats.sent + EXCLUDED.sent,
+				failed = message_stats.failed + EXCLUDED.failed`).
+			Bind(dbx.Params{
+				"org_id":      stats.OrgID,
+				"period":      stats.Period,
+				"template_id": stats.TemplateID,
+				"account_id":  stats.AccountID,
+				"sent":        stats.Sent,
+				"failed":      stats.Failed,
+			}).Execute()
+		return err
+	})
+}
+
+// RollUpEvents adds up to limit message events not counted yet to the rollups of the hours they happened in,
+// and returns how many were counted. Events are marked as counted by the same statement, and rows locked
+// by another worker are skipped.
+func (r repository) RollUpEvents(ctx context.Context, limit int) (int, error) {
+	var count int
+	err := r.db.With(ctx).NewQuery(`
+		WITH counted AS (
+			UPDATE message_events SET counted = TRUE
+			WHERE id IN (
+				SELECT id FROM message_events
+				WHERE NOT counted
+				ORDER BY created_at
+				LIMIT {:limit}
+				FOR UPDATE SKIP LOCKED
+			)
+			RETURNING message_id, type, created_at
+		), added AS (
+			INSERT INTO message_stats (org_id, period, template_id, account_id, bounced, opened, clicked)
+			SELECT m.org_id, date_trunc('hour', e.created_at), m.template_id, m.account_id,
+				COUNT(*) FILTER (WHERE e.type = {:bounced}),
+				COUNT(*) FILTER (WHERE e.type = {:opened}),
+				COUNT(*) FILTER (WHERE e.type = {:clicked})
+			FROM counted e JOIN messages m ON m.id = e.message_id
+			WHERE e.type IN ({:bounced}, {:opened}, {:clicked})
+			GROUP BY 1, 2, 3, 4
+			ON CONFLICT (org_id, period, template_id, account_id) DO UPDATE SET
+				bounced = message_stats.bounced + EXCLUDED.bounced,
+				opened = message_stats.opened + EXCLUDED.opened,
+				clicked = message_stats.clicked + EXCLUDED.clicked
+		)
+		SELECT COUNT(*) FROM counted`).
+		Bind(dbx.Params{
+			"limit":   limit,
+			"bounced": entity.MessageEventBounced,
+			"opened":  entity.MessageEventOpened,
+			"clicked": entity.MessageEventClicked,
+		}).Row(&count)
+	return count, err
+}
+
+// GetAccount returns the provider account with the specified ID owned by the organization.
+func (r repository) GetAccount(ctx context.Context, orgID, accountID string) (entity.UserProviderAccount, error) {
+	var account entity.UserProviderAccount
+	err := r.db.With(ctx).Select().From(account.TableName()).
+		Where(dbx.HashExp{"id": accountID, "org_id": orgID}).One(&account)
+	return account, err
+}
+
+// GetTemplate returns the template with the specified ID owned by the organization.
+func (r repository) GetTemplate(ctx context.Context, orgID, id string) (entity.Template, error) {
+	var template entity.Template
+	err := r.db.With(ctx).Select().From(template.TableName()).
+		Where(dbx.HashExp{"id": id, "org_id": orgID}).One(&template)
+	return template, err
+}
+
+// GetAttachments returns the attachments with the specified IDs owned by the organization.
+func (r repository) GetAttachments(ctx context.Context, orgID string, ids []string) ([]entity.Attachment, error) {
+	values := make([]interface{}, len(ids))
+	for i, id := range ids {
+		values[i] = id
+	}
+	var attachments []entity.Attachment
+	err := r.db.With(ctx).Select().From(entity.Attachment{}.TableName()).
+		Where(dbx.And(dbx.HashExp{"org_id": orgID}, dbx.In("id", values...))).All(&attachments)
+	return attachments, err
+}
+
+// CreateBatch saves a new batch in the storage.
+func (r repository) CreateBatch(ctx context.Context, batch entity.Batch) error {
+	return r.db.With(ctx).Model(&batch).Insert()
+}
+
+// UpdateBatch saves the recipient counts of the batch.
+func (r repository) UpdateBatch(ctx context.Context, batch entity.Batch) error {
+	return r.db.With(ctx).Model(&batch).Update("Total", "Accepted", "Rejected")
+}
+
+// GetBatch returns the batch with the specified ID owned by the organization.
+func (r repository) GetBatch(ctx context.Context, orgID, id string) (entity.Batch, error) {
+	var batch entity.Batch
+	err := r.db.With(ctx).Select().From(batch.TableName()).
+		Where(dbx.HashExp{"id": id, "org_id": orgID}).One(&batch)
+	return batch, err
+}
+
+// CountBatchMessages returns the number of messages of the batch by status.
+func (r repository) CountBatchMessages(ctx context.Context, batchID string) (map[string]int, error) {
+	var rows []struct {
+		Status string `db:"status"`
+		Count  int    `db:"count"`
+	}
+	err := r.db.With(ctx).Select("status", "COUNT(*) AS count").From(entity.Message{}.TableName()).
+		Where(dbx.HashExp{"batch_id": batchID}).
+		GroupBy("status").
+		All(&rows)
+	counts := map[string]int{}
+	for _, row := range rows {
+		counts[row.Status] = row.Count
+	}
+	return counts, err
+}
+
+// CreateImport saves a new import in the storage.
+func (r repository) CreateImport(ctx context.Context, item entity.BatchImport) error {
+	return r.db.With(ctx).Model(&item).Insert()
+}
+
+// UpdateImport saves the progress of the import.
+func (r repository) UpdateImport(ctx context.Context, item entity.BatchImport) error {
+	return r.db.With(ctx).Model(&item).Update("Status", "Lines", "Accepted", "Rejected", "Error", "UpdatedAt")
+}
+
+// GetImport returns the import with the specified ID owned by the organization.
+func (r repository) GetImport(ctx context.Context, orgID, id string) (entity.BatchImport, error) {
+	var item entity.BatchImport
+	err := r.db.With(ctx).Select().From(item.TableName()).
+		Where(dbx.HashExp{"id": id, "org_id": orgID}).One(&item)
+	return item, err
+}
+
+// CreateImportError saves a line rejected by an import.
+func (r repository) CreateImportError(ctx context.Context, item entity.ImportError) error {
+	_, err := r.db.With(ctx).Insert(item.TableName(), dbx.Params{
+		"import_id": item.ImportID,
+		"line":      item.Line,
+		"email":     item.Email,
+		"error":     item.Error,
+	}).Execute()
+	return err
+}
+
+// CountImportErrors returns the number of lines recorded as rejected by the import.
+func (r repository) CountImportErrors(ctx context.Context, importID string) (int, error) {
+	var count int
+	err := r.db.With(ctx).Select("COUNT(*)").From(entity.ImportError{}.TableName()).
+		Where(dbx.HashExp{"import_id": importID}).Row(&count)
+	return count, err
+}
+
+// QueryImportErrors returns the lines rejected by the import with the given offset and limit, in the order of the file.
+func (r repository) QueryImportErrors(ctx context.Context, importID string, offset, limit int) ([]entity.ImportError, error) {
+	items := []entity.ImportError{}
+	err := r.db.With(ctx).Select().From(entity.ImportError{}.TableName()).
+		Where(dbx.HashExp{"import_id": importID}).
+		OrderBy("line").
+		Offset(int64(offset)).
+		Limit(int64(limit)).
+		All(&items)
+	return items, err
+}
+
+// QueryEvents returns the events of the message, oldest first.
+func (r repository) QueryEvents(ctx context.Context, id string) ([]entity.MessageEvent, error) {
+	events := []entity.MessageEvent{}
+	err := r.db.With(ctx).Select().From(entity.MessageEvent{}.TableName()).
+		Where(dbx.HashExp{"message_id": id}).
+		OrderBy("created_at", "id").
+		All(&events)
+	return events, err
+}
+
+// Claim hands the given number of messages due at the given time over to a worker until leaseUntil.
+// Messages whose lease has expired are claimed again. Rows locked by another worker are skipped.
+func (r repository) Claim(ctx context.Context, now, leaseUntil time.Time, limit int) ([]entity.Message, error) {
+	var messages []entity.Message
+	err := r.db.With(ctx).NewQuery(`
+		UPDATE messages SET status = 'sending', next_attempt_at = {:lease}, updated_at = {:now}
+		WHERE id IN (
+			SELECT id FROM messages
+			WHERE status IN ('queued', 'sending') AND next_attempt_at <= {:now}
+			ORDER BY next_attempt_at
+			LIMIT {:limit}
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING *`).
+		Bind(dbx.Params{"now": now, "lease": leaseUntil, "limit": limit}).All(&messages)
+	return messages, err
+}
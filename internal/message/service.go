@@ -0,0 +1,253 @@
+// Package message queues the messages organizations send through their linked provider accounts
+// and delivers them in the background.
+package message
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/garaekz/gonvelope/internal/audit"
+	"github.com/garaekz/gonvelope/internal/entity"
+	"github.com/garaekz/gonvelope/internal/errors"
+	"github.com/garaekz/gonvelope/pkg/dbcontext"
+	"github.com/garaekz/gonvelope/pkg/log"
+	"github.com/garaekz/gonvelope/pkg/mailer"
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/go-ozzo/ozzo-validation/v4/is"
+)
+
+const (
+	// maxRecipients is the maximum number of recipients of a single message.
+	maxRecipients = 50
+	// maxAttachments is the maximum number of attachments of a single message.
+	maxAttachments = 10
+	// maxAttachmentsSize is the maximum total size in bytes of the attachments of a single message.
+	maxAttachmentsSize = 25 << 20
+)
+
+// Service encapsulates usecase logic for messages.
+type Service interface {
+	Get(ctx context.Context, orgID, id string) (entity.Message, error)
+	Query(ctx context.Context, orgID string, offset, limit int) ([]entity.Message, error)
+	Count(ctx context.Context, orgID string) (int, error)
+	// QueryEvents returns the events of a message of the organization, such as bounces and replies.
+	QueryEvents(ctx context.Context, orgID, id string) ([]entity.MessageEvent, error)
+	// Send queues a message to be sent through a provider account of the organization.
+	Send(ctx context.Context, orgID, userID string, input SendRequest) (entity.Message, error)
+	// SendBatch queues a message rendered from a template for each valid recipient of the request.
+	SendBatch(ctx context.Context, orgID, userID string, input BatchRequest) (BatchResult, error)
+	// GetBatch returns a batch of the organization along with the progress of its messages.
+	GetBatch(ctx context.Context, orgID, id string) (BatchStatus, error)
+	// ImportBatch queues a message rendered from a template for each valid recipient listed in a CSV or JSONL file.
+	ImportBatch(ctx context.Context, orgID, userID string, input ImportRequest, r io.Reader) (ImportResult, error)
+	// GetImport returns an import of the organization.
+	GetImport(ctx context.Context, orgID, id string) (entity.BatchImport, error)
+	CountImportErrors(ctx context.Context, orgID, id string) (int, error)
+	QueryImportErrors(ctx context.Context, orgID, id string, offset, limit int) ([]entity.ImportError, error)
+}
+
+// SendRequest represents a request to send a message.
+type SendRequest struct {
+	AccountID string   `json:"account_id"`
+	FromEmail string   `json:"from_email"`
+	FromName  string   `json:"from_name"`
+	To        []string `json:"to"`
+	Subject   string   `json:"subject"`
+	Text      string   `json:"text"`
+	HTML      string   `json:"html"`
+	// Attachments are the IDs of the attachments of the message.
+	Attachments []string `json:"attachments"`
+	// Tracking enables open and click tracking of the HTML body.
+	Tracking bool `json:"tracking"`
+}
+
+// Validate validates the SendRequest fields.
+func (m SendRequest) Validate() error {
+	return validation.ValidateStruct(&m,
+		validation.Field(&m.AccountID, validation.Required),
+		validation.Field(&m.FromEmail, validation.Required, validation.Length(0, 254), is.EmailFormat),
+		validation.Field(&m.FromName, validation.Length(0, 128)),
+		validation.Field(&m.To, validation.Required, validation.Length(1, maxRecipients), validation.Each(validation.Length(0, 254), is.EmailFormat)),
+		validation.Field(&m.Subject, validation.Required, validation.Length(0, 998)),
+		validation.Field(&m.Text, validation.When(m.HTML == "", validation.Required.Error("is required when html is empty"))),
+		validation.Field(&m.Attachments, validation.Length(0, maxAttachments), validation.Each(validation.Required)),
+	)
+}
+
+// SuppressedFunc returns the given addresses the organization must not send messages to.
+type SuppressedFunc func(ctx context.Context, orgID string, emails []string) ([]string, error)
+
+// VerifiedFunc returns whether the organization verified its ownership of the domain.
+type VerifiedFunc func(ctx context.Context, orgID, domain string) (bool, error)
+
+// ListMembersFunc returns up to limit members of a contact list of the organization matching the filter, if any,
+// sorted by email and starting after the given address. It returns sql.ErrNoRows if the organization has no such list.
+type ListMembersFunc func(ctx context.Context, orgID, listID, filter, after string, limit int) ([]entity.Contact, error)
+
+type service struct {
+	repo          Repository
+	transactional dbcontext.TransactionFunc
+	suppressed    SuppressedFunc
+	listMembers   ListMembersFunc
+	verified      VerifiedFunc
+	auditor       audit.Recorder
+	logger        log.Logger
+}
+
+// NewService creates a new message service. Messages to the addresses reported by suppressed are rejected,
+// and batches sent to a contact list reach the members reported by listMembers. Messages are only sent from
+// the domains reported by verified, or from the address of the provider account they are sent through.
+func NewService(repo Repository, transactional dbcontext.TransactionFunc, suppressed SuppressedFunc, listMembers ListMembersFunc, verified VerifiedFunc, auditor audit.Recorder, logger log.Logger) Service {
+	return service{repo, transactional, suppressed, listMembers, verified, auditor, logger}
+}
+
+// Get returns the message with the specified ID owned by the organization.
+func (s service) Get(ctx context.Context, orgID, id string) (entity.Message, error) {
+	return s.repo.Get(ctx, orgID, id)
+}
+
+// Query returns the messages owned by the organization with the specified offset and limit.
+func (s service) Query(ctx context.Context, orgID string, offset, limit int) ([]entity.Message, error) {
+	return s.repo.Query(ctx, orgID, offset, limit)
+}
+
+// Count returns the number of messages owned by the organization.
+func (s service) Count(ctx context.Context, orgID string) (int, error) {
+	return s.repo.Count(ctx, orgID)
+}
+
+// QueryEvents returns the events of a message of the organization, oldest first.
+func (s service) QueryEvents(ctx context.Context, orgID, id string) ([]entity.MessageEvent, error) {
+	if _, err := s.repo.Get(ctx, orgID, id); err != nil {
+		return nil, err
+	}
+	return s.repo.QueryEvents(ctx, id)
+}
+
+// Send queues a message to be sent through a provider account of the organization.
+// The message is sent by the worker as soon as the quota of the account allows it.
+// Messages to suppressed addresses, and messages from addresses the organization may not send from, are rejected.
+func (s service) Send(ctx context.Context, orgID, userID string, req SendRequest) (entity.Message, error) {
+	if err := req.Validate(); err != nil {
+		return entity.Message{}, err
+	}
+	account, err := s.getAccount(ctx, orgID, req.AccountID)
+	if err != nil {
+		return entity.Message{}, err
+	}
+	if err := s.checkSender(ctx, account, req.FromEmail); err != nil {
+		return entity.Message{}, err
+	}
+	suppressed, err := s.suppressed(ctx, orgID, req.To)
+	if err != nil {
+		return entity.Message{}, err
+	}
+	if len(suppressed) > 0 {
+		return entity.Message{}, errors.InvalidInput(validation.Errors{
+			"to": validation.NewError("validation_recipient_suppressed", "contains suppressed addresses: "+strings.Join(suppressed, ", ")),
+		})
+	}
+	if err := s.checkAttachments(ctx, orgID, req.Attachments); err != nil {
+		return entity.Message{}, err
+	}
+
+	now := time.Now()
+	message := entity.Message{
+		ID:            entity.GenerateID(),
+		OrgID:         orgID,
+		UserID:        userID,
+		AccountID:     req.AccountID,
+		MessageID:     mailer.NewMessageID(req.FromEmail),
+		FromEmail:     req.FromEmail,
+		FromName:      req.FromName,
+		To:            req.To,
+		Subject:       req.Subject,
+		Text:          req.Text,
+		HTML:          req.HTML,
+		AttachmentIDs: req.Attachments,
+		Tracking:      req.Tracking,
+		Status:        entity.MessageQueued,
+		NextAttemptAt: now,
+		CreatedAt:     &now,
+		UpdatedAt:     &now,
+	}
+	err = s.transactional(ctx, func(ctx context.Context) error {
+		if err := s.repo.Create(ctx, message); err != nil {
+			return err
+		}
+		return s.auditor.Record(ctx, audit.Event{
+			Action:     audit.ActionMessageQueued,
+			OrgID:      orgID,
+			ActorID:    userID,
+			TargetType: "message",
+			TargetID:   message.ID,
+			Data:       map[string]interface{}{"account_id": message.AccountID, "to": message.To, "subject": message.Subject},
+		})
+	})
+	return message, err
+}
+
+// getAccount returns the provider account with the specified ID, which must belong to the organization.
+func (s service) getAccount(ctx context.Context, orgID, accountID string) (entity.UserProviderAccount, error) {
+	account, err := s.repo.GetAccount(ctx, orgID, accountID)
+	if err == sql.ErrNoRows {
+		return account, errors.InvalidInput(validation.Errors{
+			"account_id": validation.NewError("validation_account_unknown", "is not a provider account of the organization"),
+		})
+	}
+	return account, err
+}
+
+// checkSender checks that the organization may send from the address through the provider account: the address
+// must be on a domain the organization verified, or be the address of the account itself.
+func (s service) checkSender(ctx context.Context, account entity.UserProviderAccount, from string) error {
+	if account.Email != "" && strings.EqualFold(account.Email, from) {
+		return nil
+	}
+	verified, err := s.verified(ctx, account.OrgID, from[strings.LastIndex(from, "@")+1:])
+	if err != nil {
+		return err
+	}
+	if !verified {
+		return errors.InvalidInput(validation.Errors{
+			"from_email": validation.NewError("validation_sender_unverified", "must be on a verified domain of the organization or be the address of the provider account"),
+		})
+	}
+	return nil
+}
+
+// checkAttachments checks that the attachments with the given IDs belong to the organization
+// and do not exceed maxAttachmentsSize in total.
+func (s service) checkAttachments(ctx context.Context, orgID string, ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	attachments, err := s.repo.GetAttachments(ctx, orgID, ids)
+	if err != nil {
+		return err
+	}
+	sizes := map[string]int64{}
+	for _, attachment := range attachments {
+		sizes[attachment.ID] = attachment.Size
+	}
+	var total int64
+	for _, id := range ids {
+		size, ok := sizes[id]
+		if !ok {
+			return errors.InvalidInput(validation.Errors{
+				"attachments": validation.NewError("validation_attachment_unknown", fmt.Sprintf("%q is not an attachment of the organization", id)),
+			})
+		}
+		total += size
+	}
+	if total > maxAttachmentsSize {
+		return errors.InvalidInput(validation.Errors{
+			"attachments": validation.NewError("validation_attachments_size", fmt.Sprintf("must not exceed %d bytes in total", maxAttachmentsSize)),
+		})
+	}
+	return nil
+}
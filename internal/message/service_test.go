@@ -0,0 +1,662 @@
+package message
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/garaekz/gonvelope/internal/audit"
+	"github.com/garaekz/gonvelope/internal/entity"
+	"github.com/garaekz/gonvelope/internal/errors"
+	"github.com/garaekz/gonvelope/pkg/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSendRequest_Validate(t *testing.T) {
+	valid := SendRequest{AccountID: "account1", FromEmail: "sales@example.com", To: []string{"jane@example.com"}, Subject: "Hi", Text: "Hello"}
+	tests := []struct {
+		name      string
+		update    func(r *SendRequest)
+		wantError bool
+	}{
+		{"success", func(r *SendRequest) {}, false},
+		{"html only", func(r *SendRequest) { r.Text, r.HTML = "", "<p>Hello</p>" }, false},
+		{"no body", func(r *SendRequest) { r.Text = "" }, true},
+		{"no account", func(r *SendRequest) { r.AccountID = "" }, true},
+		{"invalid from", func(r *SendRequest) { r.FromEmail = "sales" }, true},
+		{"no recipients", func(r *SendRequest) { r.To = nil }, true},
+		{"invalid recipient", func(r *SendRequest) { r.To = []string{"jane"} }, true},
+		{"too many recipients", func(r *SendRequest) { r.To = strings.Split(strings.Repeat("a@example.com,", maxRecipients+1), ",") }, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := valid
+			tt.update(&req)
+			assert.Equal(t, tt.wantError, req.Validate() != nil)
+		})
+	}
+}
+
+func Test_service_Send(t *testing.T) {
+	logger, _ := log.NewForTest()
+	repo := &mockRepository{accounts: map[string]string{"account1": "org1"}}
+	auditor := &mockRecorder{}
+	s := NewService(repo, mockTransactional, mockSuppressed, mockListMembers, mockVerified, auditor, logger)
+	ctx := context.Background()
+	req := SendRequest{AccountID: "account1", FromEmail: "sales@example.com", To: []string{"jane@example.com"}, Subject: "Hi", Text: "Hello"}
+
+	message, err := s.Send(ctx, "org1", "100", req)
+	require.Nil(t, err)
+	assert.Equal(t, entity.MessageQueued, message.Status)
+	assert.Equal(t, "org1", message.OrgID)
+	assert.True(t, strings.HasSuffix(message.MessageID, "@example.com>"))
+	count, _ := s.Count(ctx, "org1")
+	assert.Equal(t, 1, count)
+
+	if assert.Len(t, auditor.events, 1) {
+		assert.Equal(t, audit.ActionMessageQueued, auditor.events[0].Action)
+		assert.Equal(t, message.ID, auditor.events[0].TargetID)
+	}
+
+	// the account must belong to the organization
+	_, err = s.Send(ctx, "org2", "100", req)
+	assert.Equal(t, http.StatusBadRequest, err.(errors.ErrorResponse).StatusCode())
+
+	_, err = s.Send(ctx, "org1", "100", SendRequest{})
+	assert.NotNil(t, err)
+	count, _ = s.Count(ctx, "org1")
+	assert.Equal(t, 1, count)
+
+	// suppressed addresses are rejected
+	suppressedReq := req
+	suppressedReq.To = []string{"jane@example.com", "Unsubscribed@example.com"}
+	_, err = s.Send(ctx, "org1", "100", suppressedReq)
+	if assert.NotNil(t, err) {
+		assert.Equal(t, http.StatusBadRequest, err.(errors.ErrorResponse).StatusCode())
+		assert.Contains(t, fmt.Sprint(err.(errors.ErrorResponse).Details), "unsubscribed@example.com")
+	}
+	count, _ = s.Count(ctx, "org1")
+	assert.Equal(t, 1, count)
+
+	// addresses on unverified domains are rejected, unless they are the address of the account
+	spoofed := req
+	spoofed.FromEmail = "ceo@example.org"
+	_, err = s.Send(ctx, "org1", "100", spoofed)
+	if assert.NotNil(t, err) {
+		assert.Contains(t, fmt.Sprint(err.(errors.ErrorResponse).Details), "from_email")
+	}
+	own := req
+	own.FromEmail = "Account1@gmail.com"
+	_, err = s.Send(ctx, "org1", "100", own)
+	assert.Nil(t, err)
+	count, _ = s.Count(ctx, "org1")
+	assert.Equal(t, 2, count)
+
+	_, err = s.Get(ctx, "org2", message.ID)
+	assert.Equal(t, sql.ErrNoRows, err)
+}
+
+func Test_service_Send_attachments(t *testing.T) {
+	logger, _ := log.NewForTest()
+	repo := &mockRepository{
+		accounts: map[string]string{"account1": "org1"},
+		attachments: []entity.Attachment{
+			{ID: "a1", OrgID: "org1", Size: 1 << 20},
+			{ID: "a2", OrgID: "org1", Size: maxAttachmentsSize},
+			{ID: "a3", OrgID: "org2", Size: 1},
+		},
+	}
+	s := NewService(repo, mockTransactional, mockSuppressed, mockListMembers, mockVerified, &mockRecorder{}, logger)
+	ctx := context.Background()
+	req := SendRequest{AccountID: "account1", FromEmail: "sales@example.com", To: []string{"jane@example.com"}, Subject: "Hi", Text: "Hello", Attachments: []string{"a1"}}
+
+	message, err := s.Send(ctx, "org1", "100", req)
+	require.Nil(t, err)
+	assert.Equal(t, []string{"a1"}, []string(message.AttachmentIDs))
+
+	req.Attachments = []string{"a1", "a3"}
+	_, err = s.Send(ctx, "org1", "100", req)
+	assert.Contains(t, fmt.Sprint(err.(errors.ErrorResponse).Details), `"a3" is not an attachment`)
+	req.Attachments = []string{"a1", "a2"}
+	_, err = s.Send(ctx, "org1", "100", req)
+	assert.Contains(t, fmt.Sprint(err.(errors.ErrorResponse).Details), "in total")
+
+	batch := BatchRequest{TemplateID: "t1", AccountID: "account1", Recipients: []BatchRecipient{{Email: "jane@example.com"}}, Attachments: []string{"a3"}}
+	repo.templates = []entity.Template{{ID: "t1", OrgID: "org1", Subject: "Hi", Body: "Hello", FromEmail: "sales@example.com"}}
+	_, err = s.SendBatch(ctx, "org1", "100", batch)
+	assert.Contains(t, fmt.Sprint(err.(errors.ErrorResponse).Details), "attachments")
+	batch.Attachments = []string{"a1"}
+	result, err := s.SendBatch(ctx, "org1", "100", batch)
+	require.Nil(t, err)
+	assert.Equal(t, 1, result.Batch.Accepted)
+	assert.Equal(t, []string{"a1"}, []string(repo.items[len(repo.items)-1].AttachmentIDs))
+}
+
+func Test_service_SendBatch(t *testing.T) {
+	logger, _ := log.NewForTest()
+	repo := &mockRepository{
+		accounts: map[string]string{"account1": "org1"},
+		templates: []entity.Template{
+			{ID: "t1", OrgID: "org1", Subject: "Hi {{name}}", Body: "<p>Your code is {{code}}, {{email}}</p>", FromEmail: "sales@example.com"},
+			{ID: "t2", OrgID: "org1", Subject: "Hi", Body: "Hello"},
+		},
+	}
+	auditor := &mockRecorder{}
+	s := NewService(repo, mockTransactional, mockSuppressed, mockListMembers, mockVerified, auditor, logger)
+	ctx := context.Background()
+	req := BatchRequest{
+		TemplateID: "t1",
+		AccountID:  "account1",
+		Variables:  map[string]interface{}{"code": "A1", "name": "there"},
+		Recipients: []BatchRecipient{
+			{Email: "jane@example.com", Variables: map[string]interface{}{"name": "Jane <3"}},
+			{Email: " john@example.com "},
+			{Email: "jane"},
+			{Email: "JANE@example.com"},
+			{Email: "unsubscribed@example.com"},
+			{Email: "ann@example.com", Variables: map[string]interface{}{"code": nil}},
+		},
+	}
+
+	result, err := s.SendBatch(ctx, "org1", "100", req)
+	require.Nil(t, err)
+	assert.Equal(t, 6, result.Batch.Total)
+	assert.Equal(t, 3, result.Batch.Accepted)
+	assert.Equal(t, 3, result.Batch.Rejected)
+	statuses := make([]string, len(result.Recipients))
+	for i, recipient := range result.Recipients {
+		statuses[i] = recipient.Status
+	}
+	assert.Equal(t, []string{RecipientAccepted, RecipientAccepted, RecipientRejected, RecipientRejected, RecipientRejected, RecipientAccepted}, statuses)
+	assert.Equal(t, "john@example.com", result.Recipients[1].Email)
+	assert.Equal(t, "is a duplicate recipient", result.Recipients[3].Error)
+	assert.Equal(t, "is suppressed", result.Recipients[4].Error)
+
+	if assert.Len(t, repo.items, 3) {
+		jane := repo.items[0]
+		assert.Equal(t, result.Batch.ID, jane.BatchID)
+		assert.Equal(t, "t1", jane.TemplateID)
+		assert.Equal(t, result.Recipients[0].MessageID, jane.ID)
+		assert.Equal(t, "Hi Jane <3", jane.Subject)
+		assert.Equal(t, "<p>Your code is A1, jane@example.com</p>", jane.HTML)
+		assert.Equal(t, "sales@example.com", jane.FromEmail)
+		assert.Equal(t, "<p>Your code is , ann@example.com</p>", repo.items[2].HTML)
+	}
+	if assert.Len(t, auditor.events, 1) {
+		assert.Equal(t, audit.ActionBatchQueued, auditor.events[0].Action)
+		assert.Equal(t, result.Batch.ID, auditor.events[0].TargetID)
+	}
+
+	// missing variables reject the recipient
+	missing := req
+	missing.Variables = nil
+	result, err = s.SendBatch(ctx, "org1", "100", missing)
+	require.Nil(t, err)
+	assert.Equal(t, "missing variables: code", result.Recipients[0].Error)
+
+	// the template and the account must belong to the organization
+	_, err = s.SendBatch(ctx, "org2", "100", req)
+	assert.Equal(t, http.StatusBadRequest, err.(errors.ErrorResponse).StatusCode())
+	unknown := req
+	unknown.TemplateID = "t3"
+	_, err = s.SendBatch(ctx, "org1", "100", unknown)
+	assert.Contains(t, fmt.Sprint(err.(errors.ErrorResponse).Details), "template_id")
+
+	// the sender is required when the template has none
+	noSender := req
+	noSender.TemplateID = "t2"
+	_, err = s.SendBatch(ctx, "org1", "100", noSender)
+	assert.Contains(t, fmt.Sprint(err.(errors.ErrorResponse).Details), "from_email")
+	noSender.FromEmail = "info@example.com"
+	_, err = s.SendBatch(ctx, "org1", "100", noSender)
+	assert.Nil(t, err)
+
+	// the sender must be on a verified domain, be it given or taken from the template
+	noSender.FromEmail = "info@example.org"
+	_, err = s.SendBatch(ctx, "org1", "100", noSender)
+	assert.Contains(t, fmt.Sprint(err.(errors.ErrorResponse).Details), "from_email")
+
+	_, err = s.SendBatch(ctx, "org1", "100", BatchRequest{TemplateID: "t1", AccountID: "account1"})
+	assert.NotNil(t, err)
+}
+
+func Test_service_SendBatch_list(t *testing.T) {
+	logger, _ := log.NewForTest()
+	repo := &mockRepository{
+		accounts:  map[string]string{"account1": "org1"},
+		templates: []entity.Template{{ID: "t1", OrgID: "org1", Subject: "Hi {{name}}", Body: "Your plan is {{plan}}", FromEmail: "sales@example.com"}},
+	}
+	s := NewService(repo, mockTransactional, mockSuppressed, mockListMembers, mockVerified, &mockRecorder{}, logger)
+	ctx := context.Background()
+	req := BatchRequest{TemplateID: "t1", AccountID: "account1", ListID: "l1"}
+
+	result, err := s.SendBatch(ctx, "org1", "100", req)
+	require.Nil(t, err)
+	assert.Equal(t, 3, result.Batch.Total)
+	assert.Equal(t, 2, result.Batch.Accepted)
+	// only the rejected members are listed
+	if assert.Len(t, result.Recipients, 1) {
+		assert.Equal(t, 2, result.Recipients[0].Index)
+		assert.Equal(t, "is suppressed", result.Recipients[0].Error)
+	}
+	if assert.Len(t, repo.items, 2) {
+		assert.Equal(t, "Hi Jane", repo.items[0].Subject)
+		assert.Equal(t, "Your plan is pro", repo.items[0].Text)
+		assert.Equal(t, "john@example.com", repo.items[1].To[0])
+	}
+
+	empty := req
+	empty.ListID = "empty"
+	_, err = s.SendBatch(ctx, "org1", "100", empty)
+	assert.Contains(t, fmt.Sprint(err.(errors.ErrorResponse).Details), "list_id")
+	_, err = s.SendBatch(ctx, "org2", "100", BatchRequest{TemplateID: "t1", AccountID: "account1", ListID: "l1"})
+	assert.Equal(t, http.StatusBadRequest, err.(errors.ErrorResponse).StatusCode())
+
+	// lists larger than a chunk are sent to in full
+	repo.items = nil
+	result, err = s.SendBatch(ctx, "org1", "100", BatchRequest{TemplateID: "t1", AccountID: "account1", ListID: "large"})
+	require.Nil(t, err)
+	assert.Equal(t, 2*listChunkSize+1, result.Batch.Accepted)
+	assert.Len(t, repo.items, 2*listChunkSize+1)
+	assert.Equal(t, "user1000@example.com", repo.items[2*listChunkSize].To[0])
+	assert.Empty(t, result.Recipients)
+	assert.Equal(t, result.Batch, repo.batches[len(repo.batches)-1])
+
+	// a list that fails part way reports the batch queued so far instead of an error
+	repo.items = nil
+	result, err = s.SendBatch(ctx, "org1", "100", BatchRequest{TemplateID: "t1", AccountID: "account1", ListID: "broken"})
+	require.Nil(t, err)
+	assert.NotEmpty(t, result.Error)
+	assert.Equal(t, listChunkSize, result.Batch.Accepted)
+	assert.Len(t, repo.items, listChunkSize)
+	assert.Equal(t, result.Batch, repo.batches[len(repo.batches)-1])
+
+	// the list replaces the recipients, and filters apply to lists only
+	both := req
+	both.Recipients = []BatchRecipient{{Email: "ann@example.com"}}
+	_, err = s.SendBatch(ctx, "org1", "100", both)
+	assert.Contains(t, fmt.Sprint(err), "recipients")
+	_, err = s.SendBatch(ctx, "org1", "100", BatchRequest{TemplateID: "t1", AccountID: "account1", Filter: `plan = "pro"`, Recipients: both.Recipients})
+	assert.Contains(t, fmt.Sprint(err), "filter")
+}
+
+func Test_service_SendBatch_locales(t *testing.T) {
+	logger, _ := log.NewForTest()
+	repo := &mockRepository{
+		accounts: map[string]string{"account1": "org1"},
+		templates: []entity.Template{{
+			ID: "t1", OrgID: "org1", Subject: "Hi {{name}}", Body: "Your plan is {{plan}}", FromEmail: "sales@example.com",
+			Locales: entity.TemplateLocales{
+				"es":    {Subject: "Hola {{name}}", Body: "Tu plan es {{plan}}"},
+				"pt-BR": {Subject: "Olá {{name}}", Body: "Seu plano é {{plan}}"},
+			},
+		}},
+	}
+	s := NewService(repo, mockTransactional, mockSuppressed, mockListMembers, mockVerified, &mockRecorder{}, logger)
+	ctx := context.Background()
+	vars := map[string]interface{}{"name": "Ann", "plan": "pro"}
+	req := BatchRequest{
+		TemplateID: "t1",
+		AccountID:  "account1",
+		Recipients: []BatchRecipient{
+			{Email: "ann@example.com", Locale: "es-MX", Variables: vars},
+			{Email: "bob@example.com", Locale: "pt_br", Variables: vars},
+			{Email: "eve@example.com", Locale: "pt", Variables: vars},
+			{Email: "joe@example.com", Variables: vars},
+		},
+	}
+
+	// es-MX falls back to es, and locales without a variant to the default
+	_, err := s.SendBatch(ctx, "org1", "100", req)
+	require.Nil(t, err)
+	if assert.Len(t, repo.items, 4) {
+		assert.Equal(t, "Hola Ann", repo.items[0].Subject)
+		assert.Equal(t, "Tu plan es pro", repo.items[0].Text)
+		assert.Equal(t, "Olá Ann", repo.items[1].Subject)
+		assert.Equal(t, "Hi Ann", repo.items[2].Subject)
+		assert.Equal(t, "Hi Ann", repo.items[3].Subject)
+	}
+
+	// the locale of the request overrides those of the recipients
+	repo.items = nil
+	req.Locale = "pt-BR"
+	_, err = s.SendBatch(ctx, "org1", "100", req)
+	require.Nil(t, err)
+	for _, item := range repo.items {
+		assert.Equal(t, "Olá Ann", item.Subject)
+	}
+	req.Locale = "portuguese"
+	_, err = s.SendBatch(ctx, "org1", "100", req)
+	assert.Contains(t, fmt.Sprint(err), "locale")
+
+	// contacts receive the variant for their own locale
+	repo.items = nil
+	_, err = s.SendBatch(ctx, "org1", "100", BatchRequest{TemplateID: "t1", AccountID: "account1", ListID: "l1"})
+	require.Nil(t, err)
+	if assert.Len(t, repo.items, 2) {
+		assert.Equal(t, "Hi Jane", repo.items[0].Subject)
+		assert.Equal(t, "Hola John", repo.items[1].Subject)
+	}
+}
+
+func Test_service_GetBatch(t *testing.T) {
+	logger, _ := log.NewForTest()
+	repo := &mockRepository{
+		items: []entity.Message{
+			{ID: "m1", OrgID: "org1", BatchID: "b1", Status: entity.MessageSent},
+			{ID: "m2", OrgID: "org1", BatchID: "b1", Status: entity.MessageQueued},
+			{ID: "m3", OrgID: "org1", BatchID: "b1", Status: entity.MessageFailed},
+			{ID: "m4", OrgID: "org1", Status: entity.MessageQueued},
+		},
+		batches: []entity.Batch{{ID: "b1", OrgID: "org1", Total: 4, Accepted: 3, Rejected: 1}},
+	}
+	s := NewService(repo, mockTransactional, mockSuppressed, mockListMembers, mockVerified, &mockRecorder{}, logger)
+	ctx := context.Background()
+
+	status, err := s.GetBatch(ctx, "org1", "b1")
+	require.Nil(t, err)
+	assert.Equal(t, map[string]int{"queued": 1, "sending": 0, "sent": 1, "failed": 1, "bounced": 0}, status.Messages)
+	assert.False(t, status.Completed)
+
+	repo.items[1].Status = entity.MessageSent
+	status, _ = s.GetBatch(ctx, "org1", "b1")
+	assert.True(t, status.Completed)
+
+	_, err = s.GetBatch(ctx, "org2", "b1")
+	assert.Equal(t, sql.ErrNoRows, err)
+}
+
+func mockTransactional(ctx context.Context, f func(ctx context.Context) error) error {
+	return f(ctx)
+}
+
+// mockSuppressed suppresses the address unsubscribed@example.com.
+func mockSuppressed(_ context.Context, _ string, emails []string) ([]string, error) {
+	var suppressed []string
+	for _, email := range emails {
+		if strings.EqualFold(email, "unsubscribed@example.com") {
+			suppressed = append(suppressed, strings.ToLower(email))
+		}
+	}
+	return suppressed, nil
+}
+
+// mockVerified reports example.com as verified by every organization but org2.
+func mockVerified(_ context.Context, orgID, domain string) (bool, error) {
+	return orgID != "org2" && domain == "example.com", nil
+}
+
+// mockListMembers reports the members of list l1 of org1, one of them suppressed, a list larger than
+// a chunk, a list that cannot be read past its first chunk and an empty list.
+func mockListMembers(_ context.Context, orgID, listID, _, after string, limit int) ([]entity.Contact, error) {
+	if orgID != "org1" {
+		return nil, sql.ErrNoRows
+	}
+	var contacts []entity.Contact
+	switch listID {
+	case "l1":
+		contacts = []entity.Contact{
+			{Email: "jane@example.com", Name: "Jane", Attributes: entity.JSON(`{"plan":"pro"}`)},
+			{Email: "john@example.com", Name: "John", Locale: "es-MX", Attributes: entity.JSON(`{"plan":"free"}`)},
+			{Email: "unsubscribed@example.com", Attributes: entity.JSON(`{"plan":"free"}`)},
+		}
+	case "large", "broken":
+		if listID == "broken" && after != "" {
+			return nil, sql.ErrConnDone
+		}
+		for i := 0; i < 2*listChunkSize+1; i++ {
+			contacts = append(contacts, entity.Contact{Email: fmt.Sprintf("user%04d@example.com", i), Attributes: entity.JSON(`{"plan":"free"}`)})
+		}
+	case "empty":
+		return []entity.Contact{}, nil
+	default:
+		return nil, sql.ErrNoRows
+	}
+	for len(contacts) > 0 && after != "" && contacts[0].Email <= after {
+		contacts = contacts[1:]
+	}
+	if limit < len(contacts) {
+		contacts = contacts[:limit]
+	}
+	return contacts, nil
+}
+
+type mockRecorder struct {
+	events []audit.Event
+}
+
+func (m *mockRecorder) Record(_ context.Context, event audit.Event) error {
+	m.events = append(m.events, event)
+	return nil
+}
+
+type mockRepository struct {
+	items  []entity.Message
+	events []entity.MessageEvent
+	// accounts maps account IDs to the ID of their organization
+	accounts     map[string]string
+	templates    []entity.Template
+	attachments  []entity.Attachment
+	batches      []entity.Batch
+	imports      []entity.BatchImport
+	importErrors []entity.ImportError
+	stats        []entity.MessageStats
+	// counted is the number of events rolled up so far
+	counted int
+}
+
+func (m *mockRepository) Get(_ context.Context, orgID, id string) (entity.Message, error) {
+	for _, item := range m.items {
+		if item.ID == id && item.OrgID == orgID {
+			return item, nil
+		}
+	}
+	return entity.Message{}, sql.ErrNoRows
+}
+
+func (m *mockRepository) Count(_ context.Context, orgID string) (int, error) {
+	count := 0
+	for _, item := range m.items {
+		if item.OrgID == orgID {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (m *mockRepository) Query(_ context.Context, orgID string, _, _ int) ([]entity.Message, error) {
+	var items []entity.Message
+	for _, item := range m.items {
+		if item.OrgID == orgID {
+			items = append(items, item)
+		}
+	}
+	return items, nil
+}
+
+func (m *mockRepository) Create(_ context.Context, message entity.Message) error {
+	m.items = append(m.items, message)
+	return nil
+}
+
+func (m *mockRepository) Update(_ context.Context, message entity.Message) error {
+	for i, item := range m.items {
+		if item.ID == message.ID {
+			m.items[i] = message
+		}
+	}
+	return nil
+}
+
+func (m *mockRepository) Finish(ctx context.Context, message entity.Message, stats entity.MessageStats) error {
+	m.stats = append(m.stats, stats)
+	return m.Update(ctx, message)
+}
+
+func (m *mockRepository) RollUpEvents(_ context.Context, limit int) (int, error) {
+	count := 0
+	for ; m.counted < len(m.events) && count < limit; m.counted++ {
+		event := m.events[m.counted]
+		count++
+		for _, item := range m.items {
+			if item.ID != event.MessageID {
+				continue
+			}
+			stats := entity.MessageStats{OrgID: item.OrgID, Period: event.CreatedAt.Truncate(time.Hour), TemplateID: item.TemplateID, AccountID: item.AccountID}
+			switch event.Type {
+			case entity.MessageEventBounced:
+				stats.Bounced = 1
+			case entity.MessageEventOpened:
+				stats.Opened = 1
+			case entity.MessageEventClicked:
+				stats.Clicked = 1
+			default:
+				continue
+			}
+			m.stats = append(m.stats, stats)
+		}
+	}
+	return count, nil
+}
+
+// GetAccount returns the account with the given ID, whose address is the ID at gmail.com.
+func (m *mockRepository) GetAccount(_ context.Context, orgID, accountID string) (entity.UserProviderAccount, error) {
+	if m.accounts[accountID] != orgID {
+		return entity.UserProviderAccount{}, sql.ErrNoRows
+	}
+	return entity.UserProviderAccount{ID: accountID, OrgID: orgID, Email: accountID + "@gmail.com"}, nil
+}
+
+func (m *mockRepository) GetTemplate(_ context.Context, orgID, id string) (entity.Template, error) {
+	for _, item := range m.templates {
+		if item.ID == id && item.OrgID == orgID {
+			return item, nil
+		}
+	}
+	return entity.Template{}, sql.ErrNoRows
+}
+
+func (m *mockRepository) GetAttachments(_ context.Context, orgID string, ids []string) ([]entity.Attachment, error) {
+	var attachments []entity.Attachment
+	for _, item := range m.attachments {
+		for _, id := range ids {
+			if item.ID == id && item.OrgID == orgID {
+				attachments = append(attachments, item)
+				break
+			}
+		}
+	}
+	return attachments, nil
+}
+
+func (m *mockRepository) CreateBatch(_ context.Context, batch entity.Batch) error {
+	m.batches = append(m.batches, batch)
+	return nil
+}
+
+func (m *mockRepository) UpdateBatch(_ context.Context, batch entity.Batch) error {
+	for i, item := range m.batches {
+		if item.ID == batch.ID {
+			m.batches[i] = batch
+		}
+	}
+	return nil
+}
+
+func (m *mockRepository) GetBatch(_ context.Context, orgID, id string) (entity.Batch, error) {
+	for _, item := range m.batches {
+		if item.ID == id && item.OrgID == orgID {
+			return item, nil
+		}
+	}
+	return entity.Batch{}, sql.ErrNoRows
+}
+
+func (m *mockRepository) CountBatchMessages(_ context.Context, batchID string) (map[string]int, error) {
+	counts := map[string]int{}
+	for _, item := range m.items {
+		if item.BatchID == batchID {
+			counts[item.Status]++
+		}
+	}
+	return counts, nil
+}
+
+func (m *mockRepository) CreateImport(_ context.Context, item entity.BatchImport) error {
+	m.imports = append(m.imports, item)
+	return nil
+}
+
+func (m *mockRepository) UpdateImport(_ context.Context, item entity.BatchImport) error {
+	for i, existing := range m.imports {
+		if existing.ID == item.ID {
+			m.imports[i] = item
+		}
+	}
+	return nil
+}
+
+func (m *mockRepository) GetImport(_ context.Context, orgID, id string) (entity.BatchImport, error) {
+	for _, item := range m.imports {
+		if item.ID == id && item.OrgID == orgID {
+			return item, nil
+		}
+	}
+	return entity.BatchImport{}, sql.ErrNoRows
+}
+
+func (m *mockRepository) CreateImportError(_ context.Context, item entity.ImportError) error {
+	m.importErrors = append(m.importErrors, item)
+	return nil
+}
+
+func (m *mockRepository) CountImportErrors(_ context.Context, importID string) (int, error) {
+	count := 0
+	for _, item := range m.importErrors {
+		if item.ImportID == importID {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (m *mockRepository) QueryImportErrors(_ context.Context, importID string, _, _ int) ([]entity.ImportError, error) {
+	items := []entity.ImportError{}
+	for _, item := range m.importErrors {
+		if item.ImportID == importID {
+			items = append(items, item)
+		}
+	}
+	return items, nil
+}
+
+func (m *mockRepository) QueryEvents(_ context.Context, id string) ([]entity.MessageEvent, error) {
+	events := []entity.MessageEvent{}
+	for _, event := range m.events {
+		if event.MessageID == id {
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
+
+func (m *mockRepository) Claim(_ context.Context, now, leaseUntil time.Time, limit int) ([]entity.Message, error) {
+	var claimed []entity.Message
+	for i, item := range m.items {
+		if len(claimed) == limit {
+			break
+		}
+		due := item.Status == entity.MessageQueued || item.Status == entity.MessageSending
+		if due && !item.NextAttemptAt.After(now) {
+			m.items[i].Status = entity.MessageSending
+			m.items[i].NextAttemptAt = leaseUntil
+			claimed = append(claimed, m.items[i])
+		}
+	}
+	return claimed, nil
+}
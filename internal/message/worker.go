@@ -0,0 +1,268 @@
+package message
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/garaekz/gonvelope/internal/entity"
+	"github.com/garaekz/gonvelope/internal/provider"
+	"github.com/garaekz/gonvelope/internal/quota"
+	"github.com/garaekz/gonvelope/pkg/dkim"
+	"github.com/garaekz/gonvelope/pkg/log"
+	"github.com/garaekz/gonvelope/pkg/mailer"
+)
+
+const (
+	// pollInterval is how often the worker looks for messages to send.
+	pollInterval = 5 * time.Second
+	// batchSize is the maximum number of messages claimed at once.
+	batchSize = 50
+	// leaseDuration is how long a claimed message is reserved for the worker that claimed it.
+	leaseDuration = 5 * time.Minute
+	// maxAttempts is the number of failed attempts after which a message is given up on.
+	maxAttempts = 5
+	// retryDelay is the delay before the first retry of a failed message. It doubles on every attempt.
+	retryDelay = time.Minute
+	// rollUpSize is the maximum number of message events rolled up at once.
+	rollUpSize = 1000
+)
+
+// Worker delivers the queued messages through the provider accounts they were sent from. Messages are
+// deferred while their account is paused or out of quota, and an account throttled by its provider is
+// paused for as long as the provider asks. Messages to addresses suppressed since they were queued are failed
+// instead of being sent. Messages sent from a domain the organization has a DKIM key for are
+// signed with it. The worker also maintains the hourly message statistics.
+type Worker struct {
+	repo           Repository
+	quotas         quota.Service
+	senders        provider.Senders
+	suppressed     SuppressedFunc
+	unsubscribeURL UnsubscribeURLFunc
+	attachments    AttachmentsFunc
+	track          TrackFunc
+	signer         SignerFunc
+	logger         log.Logger
+}
+
+// UnsubscribeURLFunc returns the link the recipient can use to unsubscribe from the messages of the organization.
+type UnsubscribeURLFunc func(orgID, email string) string
+
+// AttachmentsFunc returns the attachments of the organization with the given IDs along with their content.
+type AttachmentsFunc func(ctx context.Context, orgID string, ids []string) ([]mailer.Attachment, error)
+
+// TrackFunc returns the HTML body of the message instrumented for open and click tracking.
+type TrackFunc func(message entity.Message) string
+
+// SignerFunc returns the DKIM signer of the messages the organization sends from the address, or nil if they are not signed.
+type SignerFunc func(ctx context.Context, orgID, from string) (*dkim.Signer, error)
+
+// NewWorker creates a new message worker.
+func NewWorker(repo Repository, quotas quota.Service, senders provider.Senders, suppressed SuppressedFunc, unsubscribeURL UnsubscribeURLFunc, attachments AttachmentsFunc, track TrackFunc, signer SignerFunc, logger log.Logger) *Worker {
+	return &Worker{repo, quotas, senders, suppressed, unsubscribeURL, attachments, track, signer, logger}
+}
+
+// Run processes the queue every pollInterval until the context is cancelled.
+func (w *Worker) Run(ctx context.Context) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		now := time.Now()
+		if _, err := w.Process(ctx, now); err != nil {
+			w.logger.With(ctx).Errorf("failed to process the message queue: %v", err)
+		}
+		if err := w.quotas.Prune(ctx, now); err != nil {
+			w.logger.With(ctx).Errorf("failed to prune provider sends: %v", err)
+		}
+		if _, err := w.RollUp(ctx); err != nil {
+			w.logger.With(ctx).Errorf("failed to roll up message events: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Process sends the messages due at the given time and returns how many of them were claimed.
+func (w *Worker) Process(ctx context.Context, now time.Time) (int, error) {
+	messages, err := w.repo.Claim(ctx, now, now.Add(leaseDuration), batchSize)
+	if err != nil {
+		return 0, err
+	}
+	for _, message := range messages {
+		w.deliver(ctx, message, now)
+	}
+	return len(messages), nil
+}
+
+// RollUp adds the message events recorded since the last run to the statistics and returns how many were counted.
+func (w *Worker) RollUp(ctx context.Context) (int, error) {
+	total := 0
+	for {
+		count, err := w.repo.RollUpEvents(ctx, rollUpSize)
+		total += count
+		if err != nil || count < rollUpSize {
+			return total, err
+		}
+	}
+}
+
+// deliver sends a claimed message and saves the outcome.
+func (w *Worker) deliver(ctx context.Context, message entity.Message, now time.Time) {
+	// recipients may have unsubscribed or bounced while the message was waiting in the queue
+	suppressed, err := w.suppressed(ctx, message.OrgID, message.To)
+	if err != nil {
+		w.retry(ctx, message, now, err)
+		return
+	}
+	if len(suppressed) > 0 {
+		w.fail(ctx, message, now, fmt.Errorf("suppressed recipients: %s", strings.Join(suppressed, ", ")))
+		return
+	}
+
+	account, sendID, availableAt, err := w.quotas.Acquire(ctx, message.AccountID, now)
+	if err != nil {
+		w.retry(ctx, message, now, err)
+		return
+	}
+	if !availableAt.IsZero() {
+		w.deferUntil(ctx, message, now, availableAt)
+		return
+	}
+	// the reserved send only counts against the quota if the provider accepted the message
+	sent := false
+	defer func() {
+		if !sent {
+			w.release(ctx, account.ID, sendID)
+		}
+	}()
+
+	sender, ok := w.senders[account.Provider]
+	if !ok {
+		w.fail(ctx, message, now, fmt.Errorf("unsupported provider %q", account.Provider))
+		return
+	}
+	msg := mailer.Message{
+		From:     message.FromEmail,
+		FromName: message.FromName,
+		To:       message.To,
+		Subject:  message.Subject,
+		Text:     message.Text,
+		HTML:     message.HTML,
+		Headers:  map[string]string{"Message-ID": message.MessageID},
+	}
+	// an unsubscribe link identifies a single recipient, so messages to several recipients do not get one
+	if len(message.To) == 1 {
+		msg.Unsubscribe = w.unsubscribeURL(message.OrgID, message.To[0])
+	}
+	if message.Tracking && message.HTML != "" {
+		msg.HTML = w.track(message)
+	}
+	if len(message.AttachmentIDs) > 0 {
+		if msg.Attachments, err = w.attachments(ctx, message.OrgID, message.AttachmentIDs); err != nil {
+			w.retry(ctx, message, now, err)
+			return
+		}
+	}
+	if msg.DKIM, err = w.signer(ctx, message.OrgID, message.FromEmail); err != nil {
+		w.retry(ctx, message, now, err)
+		return
+	}
+	raw, err := mailer.Build(msg)
+	if err != nil {
+		w.fail(ctx, message, now, err)
+		return
+	}
+
+	providerMessageID, err := sender.Send(ctx, account.UserProviderAccount, raw)
+	var throttled provider.RetryAfterError
+	if errors.As(err, &throttled) {
+		until := now.Add(throttled.RetryAfter)
+		if err := w.quotas.Pause(ctx, account.ID, until); err != nil {
+			w.logger.With(ctx, "account_id", account.ID).Errorf("failed to pause provider account: %v", err)
+		}
+		w.deferUntil(ctx, message, now, until)
+		return
+	}
+	if err != nil {
+		w.retry(ctx, message, now, err)
+		return
+	}
+
+	sent = true
+	message.Status = entity.MessageSent
+	message.Attempts++
+	message.LastError = ""
+	message.ProviderMessageID = providerMessageID
+	message.SentAt = &now
+	w.finish(ctx, message, now)
+}
+
+// release gives back the send reserved through the account for a message that was not sent.
+func (w *Worker) release(ctx context.Context, accountID, sendID string) {
+	if err := w.quotas.Release(ctx, sendID); err != nil {
+		w.logger.With(ctx, "account_id", accountID).Errorf("failed to release provider send: %v", err)
+	}
+}
+
+// deferUntil puts the message back in the queue until the given time without counting an attempt.
+func (w *Worker) deferUntil(ctx context.Context, message entity.Message, now, until time.Time) {
+	w.logger.With(ctx, "message_id", message.ID, "account_id", message.AccountID).
+		Infof("message deferred until %s", until.Format(time.RFC3339))
+	message.Status = entity.MessageQueued
+	message.NextAttemptAt = until
+	w.save(ctx, message, now)
+}
+
+// retry puts the message back in the queue after a failed attempt, or gives up on it after maxAttempts.
+func (w *Worker) retry(ctx context.Context, message entity.Message, now time.Time, cause error) {
+	message.Attempts++
+	if message.Attempts >= maxAttempts {
+		w.fail(ctx, message, now, cause)
+		return
+	}
+	w.logger.With(ctx, "message_id", message.ID).Errorf("failed to send message, attempt %d: %v", message.Attempts, cause)
+	message.Status = entity.MessageQueued
+	message.NextAttemptAt = now.Add(retryDelay << (message.Attempts - 1))
+	message.LastError = cause.Error()
+	w.save(ctx, message, now)
+}
+
+// fail gives up on the message.
+func (w *Worker) fail(ctx context.Context, message entity.Message, now time.Time, cause error) {
+	w.logger.With(ctx, "message_id", message.ID).Errorf("failed to send message: %v", cause)
+	message.Status = entity.MessageFailed
+	message.LastError = cause.Error()
+	w.finish(ctx, message, now)
+}
+
+// save persists the delivery state of the message.
+func (w *Worker) save(ctx context.Context, message entity.Message, now time.Time) {
+	message.UpdatedAt = &now
+	if err := w.repo.Update(ctx, message); err != nil {
+		w.logger.With(ctx, "message_id", message.ID).Errorf("failed to save message: %v", err)
+	}
+}
+
+// finish persists the final delivery state of the message and counts it in the statistics of the current hour.
+func (w *Worker) finish(ctx context.Context, message entity.Message, now time.Time) {
+	message.UpdatedAt = &now
+	stats := entity.MessageStats{
+		OrgID:      message.OrgID,
+		Period:     now.Truncate(time.Hour),
+		TemplateID: message.TemplateID,
+		AccountID:  message.AccountID,
+	}
+	if message.Status == entity.MessageSent {
+		stats.Sent = 1
+	} else {
+		stats.Failed = 1
+	}
+	if err := w.repo.Finish(ctx, message, stats); err != nil {
+		w.logger.With(ctx, "message_id", message.ID).Errorf("failed to save message: %v", err)
+	}
+}
@@ -0,0 +1,282 @@
+package message
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/garaekz/gonvelope/internal/entity"
+	"github.com/garaekz/gonvelope/internal/provider"
+	"github.com/garaekz/gonvelope/internal/quota"
+	"github.com/garaekz/gonvelope/pkg/dkim"
+	"github.com/garaekz/gonvelope/pkg/log"
+	"github.com/garaekz/gonvelope/pkg/mailer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWorker_Process(t *testing.T) {
+	logger, _ := log.NewForTest()
+	now := time.Now()
+	repo := &mockRepository{items: []entity.Message{
+		{ID: "sent", OrgID: "org1", AccountID: "gmail1", MessageID: "<1@example.com>", FromEmail: "a@example.com", To: []string{"b@example.com"}, Subject: "Hi", Text: "Hello", Status: entity.MessageQueued, NextAttemptAt: now},
+		{ID: "later", AccountID: "gmail1", Status: entity.MessageQueued, NextAttemptAt: now.Add(time.Hour)},
+		{ID: "over quota", AccountID: "exhausted", Status: entity.MessageQueued, NextAttemptAt: now},
+		{ID: "throttled", AccountID: "throttled", FromEmail: "a@example.com", To: []string{"b@example.com"}, Subject: "Hi", Text: "Hello", Status: entity.MessageQueued, NextAttemptAt: now},
+		{ID: "error", AccountID: "broken", FromEmail: "a@example.com", To: []string{"b@example.com"}, Subject: "Hi", Text: "Hello", Status: entity.MessageQueued, NextAttemptAt: now},
+		{ID: "unsupported", AccountID: "imap", Status: entity.MessageQueued, NextAttemptAt: now},
+	}}
+	quotas := &mockQuotas{paused: map[string]time.Time{"exhausted": now.Add(time.Minute)}}
+	sender := &mockSender{}
+	w := NewWorker(repo, quotas, provider.Senders{"google": sender}, mockSuppressed, mockUnsubscribeURL, mockAttachments, mockTrack, mockSigner, logger)
+
+	count, err := w.Process(context.Background(), now)
+	require.Nil(t, err)
+	assert.Equal(t, 5, count)
+
+	messages := map[string]entity.Message{}
+	for _, item := range repo.items {
+		messages[item.ID] = item
+	}
+
+	assert.Equal(t, entity.MessageSent, messages["sent"].Status)
+	assert.Equal(t, "provider-id", messages["sent"].ProviderMessageID)
+	assert.Contains(t, sender.raw[0], "Message-ID: <1@example.com>")
+	assert.Contains(t, sender.raw[0], "List-Unsubscribe: <https://example.com/unsubscribe/org1/b@example.com>")
+
+	assert.Equal(t, entity.MessageQueued, messages["later"].Status)
+
+	// messages are deferred without counting an attempt while the account is out of quota
+	assert.Equal(t, entity.MessageQueued, messages["over quota"].Status)
+	assert.Equal(t, now.Add(time.Minute), messages["over quota"].NextAttemptAt)
+	assert.Equal(t, 0, messages["over quota"].Attempts)
+
+	// Retry-After pauses the account
+	assert.Equal(t, now.Add(2*time.Minute), quotas.paused["throttled"])
+	assert.Equal(t, now.Add(2*time.Minute), messages["throttled"].NextAttemptAt)
+	assert.Equal(t, 0, messages["throttled"].Attempts)
+
+	assert.Equal(t, entity.MessageQueued, messages["error"].Status)
+	assert.Equal(t, 1, messages["error"].Attempts)
+	assert.Equal(t, now.Add(retryDelay), messages["error"].NextAttemptAt)
+	assert.Equal(t, "connection reset", messages["error"].LastError)
+
+	assert.Equal(t, entity.MessageFailed, messages["unsupported"].Status)
+
+	// only the sends that went through count against the quota
+	assert.ElementsMatch(t, []string{"send-throttled", "send-broken", "send-imap"}, quotas.released)
+}
+
+func TestWorker_retry(t *testing.T) {
+	logger, _ := log.NewForTest()
+	now := time.Now()
+	repo := &mockRepository{items: []entity.Message{
+		{ID: "error", AccountID: "broken", FromEmail: "a@example.com", To: []string{"b@example.com"}, Subject: "Hi", Text: "Hello", Status: entity.MessageQueued, NextAttemptAt: now},
+	}}
+	w := NewWorker(repo, &mockQuotas{}, provider.Senders{"google": &mockSender{}}, mockSuppressed, mockUnsubscribeURL, mockAttachments, mockTrack, mockSigner, logger)
+
+	for i := 0; i < maxAttempts; i++ {
+		_, err := w.Process(context.Background(), repo.items[0].NextAttemptAt)
+		require.Nil(t, err)
+	}
+	assert.Equal(t, entity.MessageFailed, repo.items[0].Status)
+	assert.Equal(t, maxAttempts, repo.items[0].Attempts)
+}
+
+func TestWorker_suppressed(t *testing.T) {
+	logger, _ := log.NewForTest()
+	now := time.Now()
+	repo := &mockRepository{items: []entity.Message{
+		{ID: "unsubscribed", OrgID: "org1", AccountID: "gmail1", FromEmail: "a@example.com", To: []string{"b@example.com", "Unsubscribed@example.com"}, Subject: "Hi", Text: "Hello", Status: entity.MessageQueued, NextAttemptAt: now},
+	}}
+	sender := &mockSender{}
+	quotas := &mockQuotas{}
+	w := NewWorker(repo, quotas, provider.Senders{"google": sender}, mockSuppressed, mockUnsubscribeURL, mockAttachments, mockTrack, mockSigner, logger)
+
+	// an address suppressed after the message was queued is not sent to
+	_, err := w.Process(context.Background(), now)
+	require.Nil(t, err)
+	assert.Equal(t, entity.MessageFailed, repo.items[0].Status)
+	assert.Equal(t, "suppressed recipients: unsubscribed@example.com", repo.items[0].LastError)
+	assert.Empty(t, sender.raw)
+	assert.Empty(t, quotas.released)
+}
+
+func TestWorker_attachments(t *testing.T) {
+	logger, _ := log.NewForTest()
+	now := time.Now()
+	repo := &mockRepository{items: []entity.Message{
+		{ID: "attached", OrgID: "org1", AccountID: "gmail1", FromEmail: "a@example.com", To: []string{"b@example.com"}, Subject: "Hi", Text: "Hello", AttachmentIDs: []string{"a1"}, Status: entity.MessageQueued, NextAttemptAt: now},
+		{ID: "missing", OrgID: "org1", AccountID: "gmail1", FromEmail: "a@example.com", To: []string{"b@example.com"}, Subject: "Hi", Text: "Hello", AttachmentIDs: []string{"gone"}, Status: entity.MessageQueued, NextAttemptAt: now},
+	}}
+	sender := &mockSender{}
+	w := NewWorker(repo, &mockQuotas{}, provider.Senders{"google": sender}, mockSuppressed, mockUnsubscribeURL, mockAttachments, mockTrack, mockSigner, logger)
+
+	_, err := w.Process(context.Background(), now)
+	require.Nil(t, err)
+	assert.Equal(t, entity.MessageSent, repo.items[0].Status)
+	if assert.Len(t, sender.raw, 1) {
+		assert.Contains(t, sender.raw[0], "multipart/mixed")
+		assert.Contains(t, sender.raw[0], `filename=invoice.pdf`)
+	}
+	// attachments that cannot be loaded are retried
+	assert.Equal(t, entity.MessageQueued, repo.items[1].Status)
+	assert.Equal(t, 1, repo.items[1].Attempts)
+}
+
+func TestWorker_tracking(t *testing.T) {
+	logger, _ := log.NewForTest()
+	now := time.Now()
+	repo := &mockRepository{items: []entity.Message{
+		{ID: "tracked", OrgID: "org1", AccountID: "gmail1", FromEmail: "a@example.com", To: []string{"b@example.com"}, Subject: "Hi", HTML: "<p>Hello</p>", Tracking: true, Status: entity.MessageQueued, NextAttemptAt: now},
+		{ID: "untracked", OrgID: "org1", AccountID: "gmail1", FromEmail: "a@example.com", To: []string{"b@example.com"}, Subject: "Hi", HTML: "<p>Hello</p>", Status: entity.MessageQueued, NextAttemptAt: now},
+	}}
+	sender := &mockSender{}
+	w := NewWorker(repo, &mockQuotas{}, provider.Senders{"google": sender}, mockSuppressed, mockUnsubscribeURL, mockAttachments, mockTrack, mockSigner, logger)
+
+	_, err := w.Process(context.Background(), now)
+	require.Nil(t, err)
+	if assert.Len(t, sender.raw, 2) {
+		assert.Contains(t, sender.raw[0], "<p>Hello</p><!-- tracked -->")
+		assert.NotContains(t, sender.raw[1], "tracked")
+	}
+}
+
+func TestWorker_dkim(t *testing.T) {
+	logger, _ := log.NewForTest()
+	now := time.Now()
+	repo := &mockRepository{items: []entity.Message{
+		{ID: "signed", OrgID: "org1", AccountID: "gmail1", FromEmail: "a@signed.example.com", To: []string{"b@example.com"}, Subject: "Hi", Text: "Hello", Status: entity.MessageQueued, NextAttemptAt: now},
+		{ID: "unsigned", OrgID: "org1", AccountID: "gmail1", FromEmail: "a@example.com", To: []string{"b@example.com"}, Subject: "Hi", Text: "Hello", Status: entity.MessageQueued, NextAttemptAt: now},
+		{ID: "broken key", OrgID: "org1", AccountID: "gmail1", FromEmail: "a@broken.example.com", To: []string{"b@example.com"}, Subject: "Hi", Text: "Hello", Status: entity.MessageQueued, NextAttemptAt: now},
+	}}
+	sender := &mockSender{}
+	w := NewWorker(repo, &mockQuotas{}, provider.Senders{"google": sender}, mockSuppressed, mockUnsubscribeURL, mockAttachments, mockTrack, mockSigner, logger)
+
+	_, err := w.Process(context.Background(), now)
+	require.Nil(t, err)
+	if assert.Len(t, sender.raw, 2) {
+		assert.True(t, strings.HasPrefix(sender.raw[0], "DKIM-Signature: v=1; a=ed25519-sha256; c=relaxed/relaxed; d=signed.example.com; s=s1;"))
+		assert.NotContains(t, sender.raw[1], "DKIM-Signature")
+	}
+	// the message is retried when its signer cannot be loaded
+	assert.Equal(t, entity.MessageQueued, repo.items[2].Status)
+	assert.Equal(t, "key not loaded", repo.items[2].LastError)
+}
+
+func TestWorker_stats(t *testing.T) {
+	logger, _ := log.NewForTest()
+	now := time.Date(2026, 10, 18, 14, 35, 0, 0, time.UTC)
+	hour := time.Date(2026, 10, 18, 14, 0, 0, 0, time.UTC)
+	repo := &mockRepository{
+		items: []entity.Message{
+			{ID: "sent", OrgID: "org1", TemplateID: "t1", AccountID: "gmail1", FromEmail: "a@example.com", To: []string{"b@example.com"}, Subject: "Hi", Text: "Hello", Status: entity.MessageQueued, NextAttemptAt: now},
+			{ID: "unsupported", OrgID: "org1", AccountID: "imap", Status: entity.MessageQueued, NextAttemptAt: now},
+			{ID: "error", OrgID: "org1", AccountID: "broken", FromEmail: "a@example.com", To: []string{"b@example.com"}, Subject: "Hi", Text: "Hello", Status: entity.MessageQueued, NextAttemptAt: now},
+		},
+		events: []entity.MessageEvent{
+			{MessageID: "sent", Type: entity.MessageEventOpened, CreatedAt: now.Add(time.Hour)},
+			{MessageID: "sent", Type: entity.MessageEventReplied, CreatedAt: now.Add(time.Hour)},
+		},
+	}
+	w := NewWorker(repo, &mockQuotas{}, provider.Senders{"google": &mockSender{}}, mockSuppressed, mockUnsubscribeURL, mockAttachments, mockTrack, mockSigner, logger)
+
+	_, err := w.Process(context.Background(), now)
+	require.Nil(t, err)
+	// only the final outcomes are counted, not the attempts to be retried
+	assert.Equal(t, []entity.MessageStats{
+		{OrgID: "org1", Period: hour, TemplateID: "t1", AccountID: "gmail1", Sent: 1},
+		{OrgID: "org1", Period: hour, AccountID: "imap", Failed: 1},
+	}, repo.stats)
+
+	count, err := w.RollUp(context.Background())
+	require.Nil(t, err)
+	assert.Equal(t, 2, count)
+	if assert.Len(t, repo.stats, 3) {
+		assert.Equal(t, entity.MessageStats{OrgID: "org1", Period: hour.Add(time.Hour), TemplateID: "t1", AccountID: "gmail1", Opened: 1}, repo.stats[2])
+	}
+}
+
+func mockUnsubscribeURL(orgID, email string) string {
+	return "https://example.com/unsubscribe/" + orgID + "/" + email
+}
+
+// mockAttachments returns a PDF for attachment a1.
+func mockAttachments(_ context.Context, _ string, ids []string) ([]mailer.Attachment, error) {
+	attachments := make([]mailer.Attachment, len(ids))
+	for i, id := range ids {
+		if id != "a1" {
+			return nil, errors.New("attachment not found")
+		}
+		attachments[i] = mailer.Attachment{Filename: "invoice.pdf", ContentType: "application/pdf", Data: []byte("%PDF-1.7")}
+	}
+	return attachments, nil
+}
+
+// mockTrack marks the HTML body as tracked.
+func mockTrack(message entity.Message) string {
+	return message.HTML + "<!-- tracked -->"
+}
+
+// mockSigner signs the messages sent from signed.example.com and fails for broken.example.com.
+func mockSigner(_ context.Context, _, from string) (*dkim.Signer, error) {
+	switch {
+	case strings.HasSuffix(from, "@signed.example.com"):
+		key, err := dkim.GenerateKey(dkim.AlgorithmEd25519)
+		if err != nil {
+			return nil, err
+		}
+		return dkim.NewSigner("signed.example.com", "s1", key, dkim.DefaultHeaders)
+	case strings.HasSuffix(from, "@broken.example.com"):
+		return nil, errors.New("key not loaded")
+	}
+	return nil, nil
+}
+
+type mockQuotas struct {
+	quota.Service
+	paused   map[string]time.Time
+	released []string
+}
+
+// Acquire reserves sends whose IDs are the IDs of their accounts prefixed with "send-".
+func (m *mockQuotas) Acquire(_ context.Context, accountID string, now time.Time) (quota.Account, string, time.Time, error) {
+	account := quota.Account{UserProviderAccount: entity.UserProviderAccount{ID: accountID}, Provider: "google"}
+	if accountID == "imap" {
+		account.Provider = "imap"
+	}
+	if until, ok := m.paused[accountID]; ok && now.Before(until) {
+		return account, "", until, nil
+	}
+	return account, "send-" + accountID, time.Time{}, nil
+}
+
+func (m *mockQuotas) Release(_ context.Context, sendID string) error {
+	m.released = append(m.released, sendID)
+	return nil
+}
+
+func (m *mockQuotas) Pause(_ context.Context, accountID string, until time.Time) error {
+	if m.paused == nil {
+		m.paused = map[string]time.Time{}
+	}
+	m.paused[accountID] = until
+	return nil
+}
+
+type mockSender struct {
+	raw []string
+}
+
+func (m *mockSender) Send(_ context.Context, account entity.UserProviderAccount, raw []byte) (string, error) {
+	switch account.ID {
+	case "throttled":
+		return "", provider.RetryAfterError{Provider: "gmail", RetryAfter: 2 * time.Minute}
+	case "broken":
+		return "", errors.New("connection reset")
+	}
+	m.raw = append(m.raw, string(raw))
+	return "provider-id", nil
+}
@@ -1,21 +1,26 @@
 package oauth
 
 import (
+	"crypto/subtle"
+	"strings"
+
 	"github.com/garaekz/gonvelope/internal/auth"
 	"github.com/garaekz/gonvelope/internal/entity"
 	"github.com/garaekz/gonvelope/internal/errors"
 	"github.com/garaekz/gonvelope/pkg/log"
 	routing "github.com/garaekz/ozzo-routing"
 	"github.com/gorilla/sessions"
+	"golang.org/x/oauth2"
 )
 
 // RegisterHandlers sets up the routing of the HTTP handlers.
-func RegisterHandlers(r *routing.RouteGroup, service Service, authHandler routing.Handler, logger log.Logger, store *sessions.CookieStore) {
+// Linked accounts belong to the organization made active by orgHandler.
+func RegisterHandlers(r *routing.RouteGroup, service Service, authHandler, orgHandler routing.Handler, logger log.Logger, store *sessions.CookieStore) {
 	res := resource{service, logger, store}
-	r.Get("google/callback", res.googleCallback)
-	r.Use(authHandler)
-	r.Get("google/login", res.googleLogin)
-	r.Post("google/token", res.googleCallback)
+	r.Use(authHandler, orgHandler)
+	r.Get("google/login", auth.Require(auth.ScopeAccountsWrite), res.googleLogin)
+	r.Get("google/callback", auth.Require(auth.ScopeAccountsWrite), res.googleCallback)
+	r.Post("google/token", auth.Require(auth.ScopeAccountsWrite), res.googleCallback)
 	// r.Get("/outlook/login", res.outlookLogin)
 	// r.Get("/outlook/callback", res.outlookCallback)
 }
@@ -47,28 +52,44 @@ func (r resource) googleLogin(c *routing.Context) error {
 
 // TODO: This will be renamed and request will be have more fields
 func (r resource) googleCallback(c *routing.Context) error {
-	identity := auth.CurrentUser(c.Request.Context())
-	userID := identity.GetID()
+	membership, ok := auth.CurrentMembership(c.Request.Context())
+	if !ok {
+		return errors.Unauthorized("")
+	}
 
 	var req struct {
-		Code string `json:"code"`
-		Name string `json:"name"`
+		Code  string `json:"code" form:"code"`
+		State string `json:"state" form:"state"`
+		Name  string `json:"name" form:"name"`
 	}
 
 	if err := c.Read(&req); err != nil {
 		// logger.With(c.Request.Context()).Errorf("invalid request: %v", err)
 		return errors.BadRequest("")
 	}
+	if err := r.checkState(c, req.State); err != nil {
+		return err
+	}
 	token, err := r.service.HandleCallback("google", req.Code)
 	if err != nil {
 		return errors.InternalServerError("Failed to get token with given code")
 	}
+	// the address lets the account send from itself without a verified sender domain
+	email, err := r.service.AccountEmail(c.Request.Context(), "google", token)
+	if err != nil {
+		r.logger.With(c.Request.Context()).Errorf("failed to get the address of the linked account: %v", err)
+		return errors.InternalServerError("Failed to get the address of the linked account")
+	}
 
 	account := entity.UserProviderAccount{
-		UserID:       userID,
+		OrgID:        membership.OrgID,
+		UserID:       membership.UserID,
+		Name:         req.Name,
+		Email:        email,
 		AccessToken:  token.AccessToken,
 		RefreshToken: token.RefreshToken,
 		TokenExpiry:  token.Expiry,
+		Scopes:       grantedScopes(token),
 	}
 
 	err = r.service.StoreAccount(c.Request.Context(), account, "google")
@@ -82,3 +103,28 @@ func (r resource) googleCallback(c *routing.Context) error {
 		Message: "Successfully linked Google account",
 	})
 }
+
+// checkState checks that the state of the callback is the one googleLogin saved in the session of the user,
+// so that an account cannot be linked from a consent the user did not start. A state can only be used once.
+func (r resource) checkState(c *routing.Context, state string) error {
+	session, err := r.store.Get(c.Request, "oauth")
+	if err != nil {
+		return errors.BadRequest("Invalid OAuth state")
+	}
+	expected, _ := session.Values["state"].(string)
+	if expected == "" || subtle.ConstantTimeCompare([]byte(expected), []byte(state)) != 1 {
+		return errors.BadRequest("Invalid OAuth state")
+	}
+	delete(session.Values, "state")
+	if err := session.Save(c.Request, c.Response); err != nil {
+		return errors.InternalServerError("Failed to save session")
+	}
+	return nil
+}
+
+// grantedScopes returns the scopes the user granted, as reported by the token response.
+// Users may grant only some of the requested scopes, such as sending but not reading mail.
+func grantedScopes(token *oauth2.Token) []string {
+	scope, _ := token.Extra("scope").(string)
+	return strings.Fields(scope)
+}
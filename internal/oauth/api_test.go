@@ -0,0 +1,94 @@
+package oauth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/garaekz/gonvelope/internal/auth"
+	"github.com/garaekz/gonvelope/internal/entity"
+	"github.com/garaekz/gonvelope/internal/org"
+	"github.com/garaekz/gonvelope/internal/test"
+	"github.com/garaekz/gonvelope/pkg/log"
+	"github.com/gorilla/sessions"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+func TestAPI(t *testing.T) {
+	logger, _ := log.NewForTest()
+	router := test.MockRouter(logger)
+	service := &mockService{}
+	store := sessions.NewCookieStore([]byte("secret"))
+	RegisterHandlers(router.Group("/oauth2/"), service, auth.MockAuthHandler, org.MockHandler, logger, store)
+	header := auth.MockAuthHeader()
+
+	tests := []test.APITestCase{
+		{Name: "login", Method: "GET", URL: "/oauth2/google/login", Header: header, WantStatus: http.StatusOK, WantResponse: `*"auth_url":"https://accounts.example.com/auth?state=*`},
+		{Name: "link", Method: "POST", URL: "/oauth2/google/token", Body: `{"code":"c1","state":"s1","name":"Sales"}`, Header: stateHeader(t, store, "s1"), WantStatus: http.StatusOK},
+		{Name: "link from the redirect", Method: "GET", URL: "/oauth2/google/callback?code=c1&state=s2", Header: stateHeader(t, store, "s2"), WantStatus: http.StatusOK},
+		{Name: "link with another state", Method: "POST", URL: "/oauth2/google/token", Body: `{"code":"c1","state":"forged"}`, Header: stateHeader(t, store, "s1"), WantStatus: http.StatusBadRequest},
+		{Name: "link without a login", Method: "POST", URL: "/oauth2/google/token", Body: `{"code":"c1","state":""}`, Header: header, WantStatus: http.StatusBadRequest},
+		{Name: "viewer login", Method: "GET", URL: "/oauth2/google/login", Header: org.MockHeader(entity.RoleViewer), WantStatus: http.StatusForbidden},
+		{Name: "viewer link", Method: "POST", URL: "/oauth2/google/token", Body: `{"code":"c1","state":"s1"}`, Header: org.MockHeader(entity.RoleViewer), WantStatus: http.StatusForbidden},
+		{Name: "viewer callback", Method: "GET", URL: "/oauth2/google/callback?code=c1&state=s1", Header: org.MockHeader(entity.RoleViewer), WantStatus: http.StatusForbidden},
+		{Name: "unauthorized login", Method: "GET", URL: "/oauth2/google/login", WantStatus: http.StatusUnauthorized},
+		{Name: "unauthorized link", Method: "POST", URL: "/oauth2/google/token", Body: `{"code":"c1","state":"s1"}`, WantStatus: http.StatusUnauthorized},
+		{Name: "unauthorized callback", Method: "GET", URL: "/oauth2/google/callback?code=c1&state=s1", WantStatus: http.StatusUnauthorized},
+	}
+	for _, tc := range tests {
+		test.Endpoint(t, router, tc)
+	}
+
+	// only the requests with the state of the session linked an account, to the organization of the user
+	if assert.Len(t, service.accounts, 2) {
+		assert.Equal(t, "100", service.accounts[0].OrgID)
+		assert.Equal(t, "100", service.accounts[0].UserID)
+		assert.Equal(t, "Sales", service.accounts[0].Name)
+		assert.Equal(t, "sales@example.com", service.accounts[0].Email)
+	}
+}
+
+// stateHeader returns an authenticated header carrying the session cookie googleLogin sets for the state.
+func stateHeader(t *testing.T, store *sessions.CookieStore, state string) http.Header {
+	req, _ := http.NewRequest("GET", "/", nil)
+	res := httptest.NewRecorder()
+	session, err := store.Get(req, "oauth")
+	require.Nil(t, err)
+	session.Values["state"] = state
+	require.Nil(t, session.Save(req, res))
+
+	header := auth.MockAuthHeader()
+	withCookies := &http.Request{Header: header}
+	for _, cookie := range res.Result().Cookies() {
+		withCookies.AddCookie(cookie)
+	}
+	return header
+}
+
+type mockService struct {
+	accounts []entity.UserProviderAccount
+}
+
+func (m *mockService) GetAuthURL(_ string, state string) string {
+	return "https://accounts.example.com/auth?state=" + state
+}
+
+func (m *mockService) HandleCallback(_ string, code string) (*oauth2.Token, error) {
+	return &oauth2.Token{AccessToken: "token-" + code}, nil
+}
+
+func (m *mockService) AccountEmail(_ context.Context, _ string, _ *oauth2.Token) (string, error) {
+	return "sales@example.com", nil
+}
+
+func (m *mockService) StoreAccount(_ context.Context, account entity.UserProviderAccount, _ string) error {
+	m.accounts = append(m.accounts, account)
+	return nil
+}
+
+func (m *mockService) BackfillEmails(_ context.Context) error {
+	return nil
+}
@@ -15,6 +15,16 @@ type Repository interface {
 	GetProviderByName(ctx context.Context, name string) (entity.Provider, error)
 	// StoreUserProviderAccount stores the user provider account
 	StoreUserProviderAccount(ctx context.Context, userProviderAccount entity.UserProviderAccount) error
+	// QueryAccountsWithoutEmail returns the provider accounts whose address is unknown.
+	QueryAccountsWithoutEmail(ctx context.Context) ([]Account, error)
+	// SetEmail sets the address of the provider account.
+	SetEmail(ctx context.Context, id, email string) error
+}
+
+// Account represents a linked provider account along with the name of its provider.
+type Account struct {
+	entity.UserProviderAccount
+	Provider string `db:"provider"`
 }
 
 // repository persists data in database
@@ -31,14 +41,14 @@ func NewRepository(db *dbcontext.DB, logger log.Logger) Repository {
 // GetProviderByName returns the oauth provider by name
 func (r repository) GetProviderByName(ctx context.Context, name string) (entity.Provider, error) {
 	var provider entity.Provider
-	err := r.db.With(ctx).Select().From("users").Where(dbx.HashExp{"name": name}).One(&provider)
+	err := r.db.With(ctx).Select().From(provider.TableName()).Where(dbx.HashExp{"name": name}).One(&provider)
 	return provider, err
 }
 
-// GetUserProviderAccountByUserIDAndProviderID returns the user provider account by user id and provider id
-func (r repository) GetUserProviderAccountByUserIDAndProviderID(ctx context.Context, userID string, providerID string) (entity.UserProviderAccount, error) {
+// GetUserProviderAccountByUserIDAndProviderID returns the user provider account by organization id, user id and provider id
+func (r repository) GetUserProviderAccountByUserIDAndProviderID(ctx context.Context, orgID, userID string, providerID string) (entity.UserProviderAccount, error) {
 	var userProviderAccount entity.UserProviderAccount
-	err := r.db.With(ctx).Select().From("user_provider_accounts").Where(dbx.HashExp{"user_id": userID, "provider_id": providerID}).One(&userProviderAccount)
+	err := r.db.With(ctx).Select().From("user_provider_accounts").Where(dbx.HashExp{"org_id": orgID, "user_id": userID, "provider_id": providerID}).One(&userProviderAccount)
 	return userProviderAccount, err
 }
 
@@ -47,3 +57,17 @@ func (r repository) StoreUserProviderAccount(ctx context.Context, userProviderAc
 	err := r.db.With(ctx).Model(&userProviderAccount).Exclude("CreatedAt", "UpdatedAt").Insert()
 	return err
 }
+
+// QueryAccountsWithoutEmail returns the provider accounts whose address is unknown.
+func (r repository) QueryAccountsWithoutEmail(ctx context.Context) ([]Account, error) {
+	var accounts []Account
+	err := r.db.With(ctx).NewQuery(`SELECT a.*, p.name AS provider FROM user_provider_accounts a
+		JOIN providers p ON p.id = a.provider_id WHERE a.email = ''`).All(&accounts)
+	return accounts, err
+}
+
+// SetEmail sets the address of the provider account.
+func (r repository) SetEmail(ctx context.Context, id, email string) error {
+	_, err := r.db.With(ctx).Update("user_provider_accounts", dbx.Params{"email": email}, dbx.HashExp{"id": id}).Execute()
+	return err
+}
@@ -2,9 +2,15 @@ package oauth
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
 
+	"github.com/garaekz/gonvelope/internal/audit"
 	"github.com/garaekz/gonvelope/internal/config"
 	"github.com/garaekz/gonvelope/internal/entity"
+	"github.com/garaekz/gonvelope/pkg/dbcontext"
 	"github.com/garaekz/gonvelope/pkg/log"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
@@ -17,20 +23,26 @@ type Service interface {
 	GetAuthURL(provider string, state string) string
 	// HandleCallback Exchanges the OAuth code for a token.
 	HandleCallback(provider string, code string) (*oauth2.Token, error)
+	// AccountEmail returns the address of the mailbox the token gives access to.
+	AccountEmail(ctx context.Context, provider string, token *oauth2.Token) (string, error)
 	// StoreAccount stores the user provider account
 	StoreAccount(ctx context.Context, account entity.UserProviderAccount, provider string) error
+	// BackfillEmails looks up the addresses of the provider accounts linked before they were recorded.
+	BackfillEmails(ctx context.Context) error
 }
 
 type service struct {
-	repo       Repository
-	logger     log.Logger
-	configs    *ProviderConfigs
-	signingKey string
+	repo          Repository
+	transactional dbcontext.TransactionFunc
+	auditor       audit.Recorder
+	logger        log.Logger
+	configs       *ProviderConfigs
+	signingKey    string
 }
 
 // NewService creates a new oauth service.
-func NewService(repo Repository, logger log.Logger, configs *ProviderConfigs, signingKey string) Service {
-	return service{repo, logger, configs, signingKey}
+func NewService(repo Repository, transactional dbcontext.TransactionFunc, auditor audit.Recorder, logger log.Logger, configs *ProviderConfigs, signingKey string) Service {
+	return service{repo, transactional, auditor, logger, configs, signingKey}
 }
 
 // ProviderConfigs represents the OAuth configurations for different providers.
@@ -39,25 +51,31 @@ type ProviderConfigs struct {
 	Outlook *config.OAuthConfig
 }
 
-// newOAuthConfig returns a new OAuth configuration for the given provider.
-func (s service) newOAuthConfig(provider string) *oauth2.Config {
+// Config returns the OAuth configuration of the given provider, or nil if the provider is unknown.
+func (c ProviderConfigs) Config(provider string) *oauth2.Config {
 	var oauthConfig *oauth2.Config
 
 	switch provider {
 	case "google":
+		if c.Google == nil {
+			return nil
+		}
 		oauthConfig = &oauth2.Config{
-			ClientID:     s.configs.Google.ClientID,
-			ClientSecret: s.configs.Google.ClientSecret,
-			RedirectURL:  s.configs.Google.RedirectURL,
-			Scopes:       s.configs.Google.Scopes,
+			ClientID:     c.Google.ClientID,
+			ClientSecret: c.Google.ClientSecret,
+			RedirectURL:  c.Google.RedirectURL,
+			Scopes:       c.Google.Scopes,
 			Endpoint:     google.Endpoint,
 		}
 	case "outlook":
+		if c.Outlook == nil {
+			return nil
+		}
 		oauthConfig = &oauth2.Config{
-			ClientID:     s.configs.Outlook.ClientID,
-			ClientSecret: s.configs.Outlook.ClientSecret,
-			RedirectURL:  s.configs.Outlook.RedirectURL,
-			Scopes:       s.configs.Outlook.Scopes,
+			ClientID:     c.Outlook.ClientID,
+			ClientSecret: c.Outlook.ClientSecret,
+			RedirectURL:  c.Outlook.RedirectURL,
+			Scopes:       c.Outlook.Scopes,
 			Endpoint:     microsoft.AzureADEndpoint("common"),
 		}
 	}
@@ -65,6 +83,11 @@ func (s service) newOAuthConfig(provider string) *oauth2.Config {
 	return oauthConfig
 }
 
+// newOAuthConfig returns a new OAuth configuration for the given provider.
+func (s service) newOAuthConfig(provider string) *oauth2.Config {
+	return s.configs.Config(provider)
+}
+
 // GetAuthURL Generates the URL for the OAuth provider's consent page.
 func (s service) GetAuthURL(provider string, state string) string {
 	config := s.newOAuthConfig(provider)
@@ -77,13 +100,91 @@ func (s service) HandleCallback(provider string, code string) (*oauth2.Token, er
 	return config.Exchange(context.Background(), code)
 }
 
-// StoreAccount stores the user provider account
+// userinfoURLs maps provider names to the endpoint returning the profile of the owner of a token.
+var userinfoURLs = map[string]string{
+	"google":  "https://www.googleapis.com/oauth2/v2/userinfo",
+	"outlook": "https://graph.microsoft.com/v1.0/me",
+}
+
+// AccountEmail returns the address of the mailbox the token gives access to, as reported by the profile
+// of its owner. Google reports it as email, and Microsoft Graph as mail or, failing that, as the user principal name.
+func (s service) AccountEmail(ctx context.Context, provider string, token *oauth2.Token) (string, error) {
+	url, ok := userinfoURLs[provider]
+	config := s.newOAuthConfig(provider)
+	if !ok || config == nil {
+		return "", fmt.Errorf("unknown provider %q", provider)
+	}
+	res, err := config.Client(ctx, token).Get(url)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s userinfo returned status %d", provider, res.StatusCode)
+	}
+	var profile struct {
+		Email             string `json:"email"`
+		Mail              string `json:"mail"`
+		UserPrincipalName string `json:"userPrincipalName"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&profile); err != nil {
+		return "", err
+	}
+	for _, email := range []string{profile.Email, profile.Mail, profile.UserPrincipalName} {
+		if strings.Contains(email, "@") {
+			return strings.ToLower(email), nil
+		}
+	}
+	return "", fmt.Errorf("%s userinfo has no email address", provider)
+}
+
+// BackfillEmails looks up the addresses of the provider accounts linked before they were recorded.
+// An account whose profile cannot be read is logged and skipped, and looked up again on the next run.
+func (s service) BackfillEmails(ctx context.Context) error {
+	accounts, err := s.repo.QueryAccountsWithoutEmail(ctx)
+	if err != nil {
+		return err
+	}
+	for _, account := range accounts {
+		token := &oauth2.Token{
+			AccessToken:  account.AccessToken,
+			RefreshToken: account.RefreshToken,
+			Expiry:       account.TokenExpiry,
+		}
+		email, err := s.AccountEmail(ctx, account.Provider, token)
+		if err != nil {
+			s.logger.With(ctx, "account_id", account.ID).Errorf("failed to get the address of the linked account: %v", err)
+			continue
+		}
+		if err := s.repo.SetEmail(ctx, account.ID, email); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// StoreAccount stores the user provider account and records who linked it in the audit log.
 func (s service) StoreAccount(ctx context.Context, account entity.UserProviderAccount, name string) error {
 	provider, err := s.repo.GetProviderByName(ctx, name)
 	if err != nil {
 		return err
 	}
 	account.ProviderID = provider.GetID()
+	if account.ID == "" {
+		account.ID = entity.GenerateID()
+	}
 
-	return s.repo.StoreUserProviderAccount(ctx, account)
+	return s.transactional(ctx, func(ctx context.Context) error {
+		if err := s.repo.StoreUserProviderAccount(ctx, account); err != nil {
+			return err
+		}
+		return s.auditor.Record(ctx, audit.Event{
+			Action:     audit.ActionAccountLinked,
+			OrgID:      account.OrgID,
+			ActorID:    account.UserID,
+			TargetType: "provider_account",
+			TargetID:   account.ID,
+			Data:       map[string]interface{}{"provider": name, "name": account.Name},
+		})
+	})
 }
@@ -0,0 +1,182 @@
+package org
+
+import (
+	"net/http"
+
+	"github.com/garaekz/gonvelope/internal/auth"
+	"github.com/garaekz/gonvelope/internal/entity"
+	"github.com/garaekz/gonvelope/internal/errors"
+	"github.com/garaekz/gonvelope/pkg/log"
+	routing "github.com/garaekz/ozzo-routing"
+)
+
+// RegisterHandlers sets up the routing of the HTTP handlers.
+// Organizations can only be managed with an interactive session, so authHandler should not accept API keys.
+// The organization routes take the organization ID from the path, which membership is looked up with.
+func RegisterHandlers(r *routing.RouteGroup, service Service, authHandler routing.Handler, membership MembershipFunc, logger log.Logger) {
+	res := resource{service, membership, logger}
+
+	r.Use(authHandler)
+
+	r.Get("orgs", res.query)
+	r.Post("orgs", res.create)
+	r.Post("invitations/accept", res.accept)
+	r.Post("invitations/decline", res.decline)
+
+	r.Get("orgs/<id>", res.member, res.get)
+	r.Put("orgs/<id>", res.member, auth.Require(auth.PermissionOrgManage), res.update)
+	r.Delete("orgs/<id>", res.member, auth.Require(auth.PermissionOrgDelete), res.delete)
+	r.Get("orgs/<id>/members", res.member, res.queryMembers)
+	// members can leave an organization on their own, so the permission is checked by the service
+	r.Delete("orgs/<id>/members/<userID>", res.member, res.removeMember)
+	r.Get("orgs/<id>/invitations", res.member, auth.Require(auth.PermissionMembersManage), res.queryInvitations)
+	r.Post("orgs/<id>/invitations", res.member, auth.Require(auth.PermissionMembersManage), res.invite)
+	r.Delete("orgs/<id>/invitations/<invitationID>", res.member, auth.Require(auth.PermissionMembersManage), res.revokeInvitation)
+}
+
+type resource struct {
+	service    Service
+	membership MembershipFunc
+	logger     log.Logger
+}
+
+// member makes the organization of the path the active one if the current user is a member of it.
+func (r resource) member(c *routing.Context) error {
+	ctx := c.Request.Context()
+	m, err := r.membership(ctx, c.Param("id"), auth.CurrentUser(ctx).GetID())
+	if err != nil {
+		return errors.NotFound("")
+	}
+	c.Request = c.Request.WithContext(auth.WithMembership(ctx, m))
+	return nil
+}
+
+func (r resource) query(c *routing.Context) error {
+	orgs, err := r.service.Query(c.Request.Context(), auth.CurrentUser(c.Request.Context()).GetID())
+	if err != nil {
+		return err
+	}
+	return c.Write(orgs)
+}
+
+func (r resource) get(c *routing.Context) error {
+	org, err := r.service.Get(c.Request.Context(), currentMembership(c))
+	if err != nil {
+		return err
+	}
+	return c.Write(org)
+}
+
+func (r resource) create(c *routing.Context) error {
+	var input OrganizationRequest
+	if err := c.Read(&input); err != nil {
+		r.logger.With(c.Request.Context()).Info(err)
+		return errors.BadRequest("")
+	}
+	org, err := r.service.Create(c.Request.Context(), auth.CurrentUser(c.Request.Context()).GetID(), input)
+	if err != nil {
+		return err
+	}
+	return c.WriteWithStatus(org, http.StatusCreated)
+}
+
+func (r resource) update(c *routing.Context) error {
+	var input OrganizationRequest
+	if err := c.Read(&input); err != nil {
+		r.logger.With(c.Request.Context()).Info(err)
+		return errors.BadRequest("")
+	}
+	org, err := r.service.Update(c.Request.Context(), currentMembership(c), input)
+	if err != nil {
+		return err
+	}
+	return c.Write(org)
+}
+
+func (r resource) delete(c *routing.Context) error {
+	org, err := r.service.Delete(c.Request.Context(), currentMembership(c))
+	if err != nil {
+		return err
+	}
+	return c.Write(org)
+}
+
+func (r resource) queryMembers(c *routing.Context) error {
+	members, err := r.service.QueryMembers(c.Request.Context(), currentMembership(c))
+	if err != nil {
+		return err
+	}
+	return c.Write(members)
+}
+
+func (r resource) removeMember(c *routing.Context) error {
+	if err := r.service.RemoveMember(c.Request.Context(), currentMembership(c), c.Param("userID")); err != nil {
+		return err
+	}
+	return c.Write(struct {
+		Message string `json:"message"`
+	}{"The member was removed from the organization"})
+}
+
+func (r resource) queryInvitations(c *routing.Context) error {
+	invitations, err := r.service.QueryInvitations(c.Request.Context(), currentMembership(c))
+	if err != nil {
+		return err
+	}
+	return c.Write(invitations)
+}
+
+func (r resource) invite(c *routing.Context) error {
+	var input InviteRequest
+	if err := c.Read(&input); err != nil {
+		r.logger.With(c.Request.Context()).Info(err)
+		return errors.BadRequest("")
+	}
+	invitation, err := r.service.Invite(c.Request.Context(), currentMembership(c), input)
+	if err != nil {
+		return err
+	}
+	return c.WriteWithStatus(invitation, http.StatusCreated)
+}
+
+func (r resource) revokeInvitation(c *routing.Context) error {
+	if err := r.service.RevokeInvitation(c.Request.Context(), currentMembership(c), c.Param("invitationID")); err != nil {
+		return err
+	}
+	return c.Write(struct {
+		Message string `json:"message"`
+	}{"The invitation was revoked"})
+}
+
+func (r resource) accept(c *routing.Context) error {
+	var input InvitationTokenRequest
+	if err := c.Read(&input); err != nil {
+		r.logger.With(c.Request.Context()).Info(err)
+		return errors.BadRequest("")
+	}
+	membership, err := r.service.AcceptInvitation(c.Request.Context(), auth.CurrentUser(c.Request.Context()).GetID(), input.Token)
+	if err != nil {
+		return err
+	}
+	return c.Write(membership)
+}
+
+func (r resource) decline(c *routing.Context) error {
+	var input InvitationTokenRequest
+	if err := c.Read(&input); err != nil {
+		r.logger.With(c.Request.Context()).Info(err)
+		return errors.BadRequest("")
+	}
+	if err := r.service.DeclineInvitation(c.Request.Context(), auth.CurrentUser(c.Request.Context()).GetID(), input.Token); err != nil {
+		return err
+	}
+	return c.Write(struct {
+		Message string `json:"message"`
+	}{"The invitation was declined"})
+}
+
+// currentMembership returns the membership made active by the member middleware.
+func currentMembership(c *routing.Context) entity.Membership {
+	m, _ := auth.CurrentMembership(c.Request.Context())
+	return m
+}
@@ -0,0 +1,77 @@
+package org
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/garaekz/gonvelope/internal/auth"
+	"github.com/garaekz/gonvelope/internal/entity"
+	"github.com/garaekz/gonvelope/internal/test"
+	"github.com/garaekz/gonvelope/pkg/log"
+)
+
+func TestAPI(t *testing.T) {
+	logger, _ := log.NewForTest()
+	router := test.MockRouter(logger)
+	repo := newTestRepository()
+	s, _ := newTestService(repo)
+	RegisterHandlers(router.Group("/"), s, auth.MockAuthHandler, repo.GetMembership, logger)
+	header := auth.MockAuthHeader()
+
+	tests := []test.APITestCase{
+		{Name: "get all", Method: "GET", URL: "/orgs", Body: "", Header: header, WantStatus: http.StatusOK, WantResponse: `*"name":"Acme","role":"owner"*`},
+		{Name: "get org1", Method: "GET", URL: "/orgs/org1", Body: "", Header: header, WantStatus: http.StatusOK, WantResponse: `*"name":"Acme"*`},
+		{Name: "get unknown", Method: "GET", URL: "/orgs/unknown", Body: "", Header: header, WantStatus: http.StatusNotFound, WantResponse: ""},
+		{Name: "create ok", Method: "POST", URL: "/orgs", Body: `{"name":"Team"}`, Header: header, WantStatus: http.StatusCreated, WantResponse: `*"name":"Team"*`},
+		{Name: "create input error", Method: "POST", URL: "/orgs", Body: `{"name":""}`, Header: header, WantStatus: http.StatusBadRequest, WantResponse: ""},
+		{Name: "update ok", Method: "PUT", URL: "/orgs/org1", Body: `{"name":"Acme Inc"}`, Header: header, WantStatus: http.StatusOK, WantResponse: `*"name":"Acme Inc"*`},
+		{Name: "members", Method: "GET", URL: "/orgs/org1/members", Body: "", Header: header, WantStatus: http.StatusOK, WantResponse: `*"email":"member@example.com"*`},
+		{Name: "invite", Method: "POST", URL: "/orgs/org1/invitations", Body: `{"email":"guest@example.com","role":"editor"}`, Header: header, WantStatus: http.StatusCreated, WantResponse: `*"email":"guest@example.com"*`},
+		{Name: "invitations", Method: "GET", URL: "/orgs/org1/invitations", Body: "", Header: header, WantStatus: http.StatusOK, WantResponse: `*guest@example.com*`},
+		{Name: "remove member", Method: "DELETE", URL: "/orgs/org1/members/300", Body: "", Header: header, WantStatus: http.StatusOK, WantResponse: ""},
+		{Name: "remove last owner", Method: "DELETE", URL: "/orgs/org1/members/100", Body: "", Header: header, WantStatus: http.StatusConflict, WantResponse: ""},
+		{Name: "accept invalid", Method: "POST", URL: "/invitations/accept", Body: `{"token":"invalid"}`, Header: header, WantStatus: http.StatusBadRequest, WantResponse: ""},
+		{Name: "delete personal", Method: "DELETE", URL: "/orgs/100", Body: "", Header: header, WantStatus: http.StatusForbidden, WantResponse: ""},
+		{Name: "delete ok", Method: "DELETE", URL: "/orgs/org1", Body: "", Header: header, WantStatus: http.StatusOK, WantResponse: `*Acme Inc*`},
+		{Name: "unauthorized", Method: "GET", URL: "/orgs", Body: "", Header: nil, WantStatus: http.StatusUnauthorized, WantResponse: ""},
+	}
+	for _, tc := range tests {
+		test.Endpoint(t, router, tc)
+	}
+}
+
+func TestAPI_roles(t *testing.T) {
+	logger, _ := log.NewForTest()
+	router := test.MockRouter(logger)
+	repo := newTestRepository()
+	s, _ := newTestService(repo)
+	RegisterHandlers(router.Group("/"), s, auth.MockAuthHandler, repo.GetMembership, logger)
+	header := auth.MockAuthHeader()
+
+	// revoking an unknown invitation and removing an unknown member tell whether the permission check passed,
+	// and the owner comes last since it actually deletes the organization
+	tests := []struct {
+		role                                        string
+		rename, invitations, revoke, remove, delete int
+	}{
+		{entity.RoleViewer, http.StatusForbidden, http.StatusForbidden, http.StatusForbidden, http.StatusForbidden, http.StatusForbidden},
+		{entity.RoleSender, http.StatusForbidden, http.StatusForbidden, http.StatusForbidden, http.StatusForbidden, http.StatusForbidden},
+		{entity.RoleEditor, http.StatusForbidden, http.StatusForbidden, http.StatusForbidden, http.StatusForbidden, http.StatusForbidden},
+		{entity.RoleAdmin, http.StatusOK, http.StatusOK, http.StatusNotFound, http.StatusNotFound, http.StatusForbidden},
+		{entity.RoleOwner, http.StatusOK, http.StatusOK, http.StatusNotFound, http.StatusNotFound, http.StatusOK},
+	}
+	for _, tt := range tests {
+		repo.memberships[1].Role = tt.role
+		for _, tc := range []test.APITestCase{
+			{Name: tt.role + " get", Method: "GET", URL: "/orgs/org1", Header: header, WantStatus: http.StatusOK},
+			{Name: tt.role + " members", Method: "GET", URL: "/orgs/org1/members", Header: header, WantStatus: http.StatusOK},
+			{Name: tt.role + " rename", Method: "PUT", URL: "/orgs/org1", Body: `{"name":"Acme"}`, Header: header, WantStatus: tt.rename},
+			{Name: tt.role + " invitations", Method: "GET", URL: "/orgs/org1/invitations", Header: header, WantStatus: tt.invitations},
+			{Name: tt.role + " revoke", Method: "DELETE", URL: "/orgs/org1/invitations/unknown", Header: header, WantStatus: tt.revoke},
+			{Name: tt.role + " remove", Method: "DELETE", URL: "/orgs/org1/members/unknown", Header: header, WantStatus: tt.remove},
+			{Name: tt.role + " delete", Method: "DELETE", URL: "/orgs/org1", Header: header, WantStatus: tt.delete},
+		} {
+			test.Endpoint(t, router, tc)
+		}
+	}
+}
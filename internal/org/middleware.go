@@ -0,0 +1,81 @@
+package org
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/garaekz/gonvelope/internal/auth"
+	"github.com/garaekz/gonvelope/internal/entity"
+	"github.com/garaekz/gonvelope/internal/errors"
+	routing "github.com/garaekz/ozzo-routing"
+)
+
+// HeaderOrgID is the request header used to choose the organization a request acts on.
+const HeaderOrgID = "X-Org-ID"
+
+// MembershipFunc returns the membership of the user in the organization.
+type MembershipFunc func(ctx context.Context, orgID, userID string) (entity.Membership, error)
+
+// Handler returns a middleware that makes the organization chosen with the X-Org-ID header the active one.
+// Without the header, the personal organization of the current user is used. Requests authenticated with
+// an API key always act on the organization the key belongs to. The middleware must run after authentication.
+func Handler(membership MembershipFunc) routing.Handler {
+	return func(c *routing.Context) error {
+		ctx := c.Request.Context()
+		identity := auth.CurrentUser(ctx)
+		if identity == nil {
+			return errors.Unauthorized("")
+		}
+
+		orgID := c.Request.Header.Get(HeaderOrgID)
+		if keyOrg := auth.KeyOrg(ctx); keyOrg != "" {
+			if orgID != "" && orgID != keyOrg {
+				return errors.Forbidden("The API key belongs to another organization")
+			}
+			orgID = keyOrg
+		}
+		if orgID == "" {
+			orgID = identity.GetID()
+		}
+
+		m, err := membership(ctx, orgID, identity.GetID())
+		if err != nil {
+			return errors.Forbidden("You are not a member of this organization")
+		}
+		c.Request = c.Request.WithContext(auth.WithMembership(ctx, m))
+		return nil
+	}
+}
+
+// mockRoleHeader is the request header MockHandler reads the role of the current user from.
+const mockRoleHeader = "X-Mock-Role"
+
+// MockHandler creates a mock organization middleware for testing purpose.
+// It makes the current user a member of the organization chosen with the X-Org-ID header,
+// or of the "100" organization if the header is absent. The user is an owner unless
+// the request was built with MockHeader.
+func MockHandler(c *routing.Context) error {
+	identity := auth.CurrentUser(c.Request.Context())
+	if identity == nil {
+		return errors.Unauthorized("")
+	}
+	orgID := c.Request.Header.Get(HeaderOrgID)
+	if orgID == "" {
+		orgID = "100"
+	}
+	role := c.Request.Header.Get(mockRoleHeader)
+	if role == "" {
+		role = entity.RoleOwner
+	}
+	ctx := auth.WithMembership(c.Request.Context(), entity.Membership{OrgID: orgID, UserID: identity.GetID(), Role: role})
+	c.Request = c.Request.WithContext(ctx)
+	return nil
+}
+
+// MockHeader returns an HTTP header that passes the authentication check by auth.MockAuthHandler
+// and makes MockHandler give the current user the specified role.
+func MockHeader(role string) http.Header {
+	header := auth.MockAuthHeader()
+	header.Set(mockRoleHeader, role)
+	return header
+}
@@ -0,0 +1,89 @@
+package org
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/garaekz/gonvelope/internal/auth"
+	"github.com/garaekz/gonvelope/internal/entity"
+	"github.com/garaekz/gonvelope/internal/test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandler(t *testing.T) {
+	repo := newTestRepository()
+	handler := Handler(repo.GetMembership)
+
+	tests := []struct {
+		name     string
+		orgID    string
+		keyOrg   string
+		wantOrg  string
+		wantCode int
+	}{
+		{"personal organization", "", "", "100", 0},
+		{"chosen organization", "org1", "", "org1", 0},
+		{"not a member", "other", "", "", http.StatusForbidden},
+		{"api key organization", "", "org1", "org1", 0},
+		{"api key matching header", "org1", "org1", "org1", 0},
+		{"api key other organization", "100", "org1", "", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest("GET", "http://example.com", nil)
+			if tt.orgID != "" {
+				req.Header.Set(HeaderOrgID, tt.orgID)
+			}
+			ctx := auth.WithUser(context.Background(), "100", "owner")
+			if tt.keyOrg != "" {
+				ctx = withKeyOrg(t, ctx, tt.keyOrg)
+			}
+			c, _ := test.MockRoutingContext(req.WithContext(ctx))
+			err := handler(c)
+			assert.Equal(t, tt.wantCode, statusCode(err))
+			if tt.wantCode == 0 {
+				m, ok := auth.CurrentMembership(c.Request.Context())
+				assert.True(t, ok)
+				assert.Equal(t, tt.wantOrg, m.OrgID)
+			}
+		})
+	}
+
+	req, _ := http.NewRequest("GET", "http://example.com", nil)
+	c, _ := test.MockRoutingContext(req)
+	assert.Equal(t, http.StatusUnauthorized, statusCode(handler(c)))
+}
+
+type boundIdentity struct {
+	entity.User
+	orgID string
+}
+
+func (b boundIdentity) GetOrgID() string {
+	return b.orgID
+}
+
+// withKeyOrg returns a context as set up by auth.BearerHandler for an API key bound to the organization.
+func withKeyOrg(t *testing.T, ctx context.Context, orgID string) context.Context {
+	verify := func(context.Context, string, string) (auth.Identity, []string, error) {
+		return boundIdentity{entity.User{ID: "100"}, orgID}, nil, nil
+	}
+	req, _ := http.NewRequest("GET", "http://example.com", nil)
+	req.Header.Set("Authorization", "Bearer "+auth.APIKeyPrefix+"key")
+	c, _ := test.MockRoutingContext(req.WithContext(ctx))
+	assert.Nil(t, auth.BearerHandler(verify)(c))
+	return c.Request.Context()
+}
+
+func TestMockHandler(t *testing.T) {
+	req, _ := http.NewRequest("GET", "http://example.com", nil)
+	c, _ := test.MockRoutingContext(req)
+	assert.NotNil(t, MockHandler(c))
+
+	req = req.WithContext(auth.WithUser(context.Background(), "100", "Tester"))
+	c, _ = test.MockRoutingContext(req)
+	assert.Nil(t, MockHandler(c))
+	m, _ := auth.CurrentMembership(c.Request.Context())
+	assert.Equal(t, "100", m.OrgID)
+}
@@ -0,0 +1,230 @@
+package org
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/garaekz/gonvelope/internal/entity"
+	"github.com/garaekz/gonvelope/internal/errors"
+	"github.com/garaekz/gonvelope/pkg/dbcontext"
+	"github.com/garaekz/gonvelope/pkg/log"
+	dbx "github.com/go-ozzo/ozzo-dbx"
+)
+
+// Repository encapsulates the logic to access organizations, memberships and invitations from the data source.
+type Repository interface {
+	// GetUser returns the user with the specified ID.
+	GetUser(ctx context.Context, id string) (entity.User, error)
+	// Get returns the organization with the specified ID.
+	Get(ctx context.Context, id string) (entity.Organization, error)
+	// QueryByUser returns the organizations the user is a member of.
+	QueryByUser(ctx context.Context, userID string) ([]Summary, error)
+	// Create saves a new organization in the storage.
+	Create(ctx context.Context, org entity.Organization) error
+	// Update updates the organization with given ID in the storage.
+	Update(ctx context.Context, org entity.Organization) error
+	// Delete removes the organization with given ID from the storage.
+	Delete(ctx context.Context, id string) error
+	// GetMembership returns the membership of the user in the organization.
+	GetMembership(ctx context.Context, orgID, userID string) (entity.Membership, error)
+	// CreateMembership saves a new membership in the storage.
+	CreateMembership(ctx context.Context, membership entity.Membership) error
+	// DeleteMembership removes the membership of the user in the organization from the storage.
+	DeleteMembership(ctx context.Context, orgID, userID string) error
+	// QueryMembers returns the members of the organization.
+	QueryMembers(ctx context.Context, orgID string) ([]Member, error)
+	// CountOwners returns the number of owners of the organization.
+	CountOwners(ctx context.Context, orgID string) (int, error)
+	// GetInvitation returns the invitation with the specified ID sent for the organization.
+	GetInvitation(ctx context.Context, orgID, id string) (entity.Invitation, error)
+	// GetInvitationByHash returns the invitation with the specified token hash.
+	GetInvitationByHash(ctx context.Context, hash string) (entity.Invitation, error)
+	// QueryPendingInvitations returns the invitations of the organization that are still pending at the given time.
+	QueryPendingInvitations(ctx context.Context, orgID string, now time.Time) ([]entity.Invitation, error)
+	// CountPendingInvitations returns the number of invitations of the organization still pending for the email.
+	CountPendingInvitations(ctx context.Context, orgID, email string, now time.Time) (int, error)
+	// CreateInvitation saves a new invitation in the storage.
+	CreateInvitation(ctx context.Context, invitation entity.Invitation) error
+	// AnswerInvitation records the answer to the invitation. The accepted flag tells whether it was accepted
+	// or declined. It returns sql.ErrNoRows if the invitation was already answered.
+	AnswerInvitation(ctx context.Context, id string, accepted bool, at time.Time) error
+	// DeleteInvitation removes the invitation with the specified ID sent for the organization from the storage.
+	DeleteInvitation(ctx context.Context, orgID, id string) error
+}
+
+// repository persists organizations in database
+type repository struct {
+	db     *dbcontext.DB
+	logger log.Logger
+}
+
+// NewRepository creates a new organization repository
+func NewRepository(db *dbcontext.DB, logger log.Logger) Repository {
+	return repository{db, logger}
+}
+
+// GetUser returns the user with the specified ID.
+func (r repository) GetUser(ctx context.Context, id string) (entity.User, error) {
+	var user entity.User
+	err := r.db.With(ctx).Select().Model(id, &user)
+	return user, err
+}
+
+// Get returns the organization with the specified ID.
+func (r repository) Get(ctx context.Context, id string) (entity.Organization, error) {
+	var org entity.Organization
+	err := r.db.With(ctx).Select().Model(id, &org)
+	return org, err
+}
+
+// QueryByUser returns the organizations the user is a member of.
+func (r repository) QueryByUser(ctx context.Context, userID string) ([]Summary, error) {
+	var orgs []Summary
+	err := r.db.With(ctx).
+		Select("o.id", "o.name", "m.role", "o.created_at").
+		From("organizations o").
+		InnerJoin("memberships m", dbx.NewExp("m.org_id = o.id")).
+		Where(dbx.HashExp{"m.user_id": userID}).
+		OrderBy("o.created_at").
+		All(&orgs)
+	return orgs, err
+}
+
+// Create saves a new organization in the storage.
+func (r repository) Create(ctx context.Context, org entity.Organization) error {
+	return r.db.With(ctx).Model(&org).Exclude("CreatedAt", "UpdatedAt").Insert()
+}
+
+// Update updates the organization with given ID in the storage.
+func (r repository) Update(ctx context.Context, org entity.Organization) error {
+	return r.db.With(ctx).Model(&org).Update("Name", "UpdatedAt")
+}
+
+// Delete removes the organization with given ID from the storage.
+func (r repository) Delete(ctx context.Context, id string) error {
+	res, err := r.db.With(ctx).Delete("organizations", dbx.HashExp{"id": id}).Execute()
+	return requireAffected(res, err)
+}
+
+// GetMembership returns the membership of the user in the organization.
+func (r repository) GetMembership(ctx context.Context, orgID, userID string) (entity.Membership, error) {
+	var membership entity.Membership
+	err := r.db.With(ctx).Select().From("memberships").
+		Where(dbx.HashExp{"org_id": orgID, "user_id": userID}).One(&membership)
+	return membership, err
+}
+
+// CreateMembership saves a new membership in the storage.
+func (r repository) CreateMembership(ctx context.Context, membership entity.Membership) error {
+	err := r.db.With(ctx).Model(&membership).Exclude("CreatedAt").Insert()
+	return errors.MapConflict(err, "The user is already a member of the organization")
+}
+
+// DeleteMembership removes the membership of the user in the organization from the storage.
+func (r repository) DeleteMembership(ctx context.Context, orgID, userID string) error {
+	res, err := r.db.With(ctx).Delete("memberships", dbx.HashExp{"org_id": orgID, "user_id": userID}).Execute()
+	return requireAffected(res, err)
+}
+
+// QueryMembers returns the members of the organization.
+func (r repository) QueryMembers(ctx context.Context, orgID string) ([]Member, error) {
+	var members []Member
+	err := r.db.With(ctx).
+		Select("u.id AS user_id", "u.name", "u.email", "m.role", "m.created_at").
+		From("memberships m").
+		InnerJoin("users u", dbx.NewExp("u.id = m.user_id")).
+		Where(dbx.HashExp{"m.org_id": orgID}).
+		OrderBy("m.created_at").
+		All(&members)
+	return members, err
+}
+
+// CountOwners returns the number of owners of the organization.
+func (r repository) CountOwners(ctx context.Context, orgID string) (int, error) {
+	var count int
+	err := r.db.With(ctx).Select("COUNT(*)").From("memberships").
+		Where(dbx.HashExp{"org_id": orgID, "role": entity.RoleOwner}).Row(&count)
+	return count, err
+}
+
+// GetInvitation returns the invitation with the specified ID sent for the organization.
+func (r repository) GetInvitation(ctx context.Context, orgID, id string) (entity.Invitation, error) {
+	var invitation entity.Invitation
+	err := r.db.With(ctx).Select().From("invitations").
+		Where(dbx.HashExp{"id": id, "org_id": orgID}).One(&invitation)
+	return invitation, err
+}
+
+// GetInvitationByHash returns the invitation with the specified token hash.
+func (r repository) GetInvitationByHash(ctx context.Context, hash string) (entity.Invitation, error) {
+	var invitation entity.Invitation
+	err := r.db.With(ctx).Select().From("invitations").Where(dbx.HashExp{"token_hash": hash}).One(&invitation)
+	return invitation, err
+}
+
+// QueryPendingInvitations returns the invitations of the organization that are still pending at the given time.
+func (r repository) QueryPendingInvitations(ctx context.Context, orgID string, now time.Time) ([]entity.Invitation, error) {
+	var invitations []entity.Invitation
+	err := r.db.With(ctx).Select().From("invitations").
+		Where(pending(orgID, now)).
+		OrderBy("created_at DESC").
+		All(&invitations)
+	return invitations, err
+}
+
+// CountPendingInvitations returns the number of invitations of the organization still pending for the email.
+func (r repository) CountPendingInvitations(ctx context.Context, orgID, email string, now time.Time) (int, error) {
+	var count int
+	err := r.db.With(ctx).Select("COUNT(*)").From("invitations").
+		Where(dbx.And(pending(orgID, now), dbx.NewExp("LOWER(email) = {:email}", dbx.Params{"email": strings.ToLower(email)}))).
+		Row(&count)
+	return count, err
+}
+
+// CreateInvitation saves a new invitation in the storage.
+func (r repository) CreateInvitation(ctx context.Context, invitation entity.Invitation) error {
+	return r.db.With(ctx).Model(&invitation).Exclude("AcceptedAt", "DeclinedAt", "CreatedAt").Insert()
+}
+
+// AnswerInvitation records the answer to the invitation. The accepted flag tells whether it was accepted
+// or declined. It returns sql.ErrNoRows if the invitation was already answered.
+func (r repository) AnswerInvitation(ctx context.Context, id string, accepted bool, at time.Time) error {
+	column := "declined_at"
+	if accepted {
+		column = "accepted_at"
+	}
+	res, err := r.db.With(ctx).Update("invitations", dbx.Params{column: at},
+		dbx.HashExp{"id": id, "accepted_at": nil, "declined_at": nil}).Execute()
+	return requireAffected(res, err)
+}
+
+// DeleteInvitation removes the invitation with the specified ID sent for the organization from the storage.
+func (r repository) DeleteInvitation(ctx context.Context, orgID, id string) error {
+	res, err := r.db.With(ctx).Delete("invitations", dbx.HashExp{"id": id, "org_id": orgID}).Execute()
+	return requireAffected(res, err)
+}
+
+// pending builds the condition matching the pending invitations of the organization.
+func pending(orgID string, now time.Time) dbx.Expression {
+	return dbx.And(
+		dbx.HashExp{"org_id": orgID, "accepted_at": nil, "declined_at": nil},
+		dbx.NewExp("expires_at > {:now}", dbx.Params{"now": now}),
+	)
+}
+
+// requireAffected returns sql.ErrNoRows if the execution of a statement did not affect any row.
+func requireAffected(res sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
@@ -0,0 +1,398 @@
+package org
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/garaekz/gonvelope/internal/audit"
+	"github.com/garaekz/gonvelope/internal/auth"
+	"github.com/garaekz/gonvelope/internal/entity"
+	"github.com/garaekz/gonvelope/internal/errors"
+	"github.com/garaekz/gonvelope/pkg/dbcontext"
+	"github.com/garaekz/gonvelope/pkg/log"
+	"github.com/garaekz/gonvelope/pkg/mailer"
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/go-ozzo/ozzo-validation/v4/is"
+)
+
+// invitationExpiration is how long an invitation can be answered.
+const invitationExpiration = 7 * 24 * time.Hour
+
+// Service encapsulates usecase logic for organizations.
+type Service interface {
+	Query(ctx context.Context, userID string) ([]Summary, error)
+	Get(ctx context.Context, membership entity.Membership) (entity.Organization, error)
+	Create(ctx context.Context, userID string, req OrganizationRequest) (entity.Organization, error)
+	Update(ctx context.Context, membership entity.Membership, req OrganizationRequest) (entity.Organization, error)
+	Delete(ctx context.Context, membership entity.Membership) (entity.Organization, error)
+	QueryMembers(ctx context.Context, membership entity.Membership) ([]Member, error)
+	RemoveMember(ctx context.Context, membership entity.Membership, userID string) error
+	QueryInvitations(ctx context.Context, membership entity.Membership) ([]entity.Invitation, error)
+	Invite(ctx context.Context, membership entity.Membership, req InviteRequest) (entity.Invitation, error)
+	RevokeInvitation(ctx context.Context, membership entity.Membership, id string) error
+	// AcceptInvitation makes the user a member of the organization the invitation was sent for.
+	AcceptInvitation(ctx context.Context, userID, token string) (entity.Membership, error)
+	DeclineInvitation(ctx context.Context, userID, token string) error
+}
+
+// Summary represents an organization along with the role of a member.
+type Summary struct {
+	ID        string     `json:"id" db:"id"`
+	Name      string     `json:"name" db:"name"`
+	Role      string     `json:"role" db:"role"`
+	CreatedAt *time.Time `json:"created_at" db:"created_at"`
+}
+
+// Member represents a user who is a member of an organization.
+type Member struct {
+	UserID    string     `json:"user_id" db:"user_id"`
+	Name      string     `json:"name" db:"name"`
+	Email     string     `json:"email" db:"email"`
+	Role      string     `json:"role" db:"role"`
+	CreatedAt *time.Time `json:"created_at" db:"created_at"`
+}
+
+// OrganizationRequest represents an organization creation or update request.
+type OrganizationRequest struct {
+	Name string `json:"name"`
+}
+
+// Validate validates the OrganizationRequest fields.
+func (m OrganizationRequest) Validate() error {
+	return validation.ValidateStruct(&m,
+		validation.Field(&m.Name, validation.Required, validation.Length(0, 128)),
+	)
+}
+
+// InviteRequest represents a request to invite someone to an organization.
+type InviteRequest struct {
+	Email string `json:"email"`
+	Role  string `json:"role"`
+}
+
+// Validate validates the InviteRequest fields.
+func (m InviteRequest) Validate() error {
+	return validation.ValidateStruct(&m,
+		validation.Field(&m.Email, validation.Required, validation.Length(0, 254), is.EmailFormat),
+		validation.Field(&m.Role, validation.Required, validation.In(entity.RoleAdmin, entity.RoleEditor, entity.RoleSender, entity.RoleViewer)),
+	)
+}
+
+// InvitationTokenRequest represents a request to answer an invitation.
+type InvitationTokenRequest struct {
+	Token string `json:"token"`
+}
+
+type service struct {
+	repo          Repository
+	transactional dbcontext.TransactionFunc
+	sender        mailer.Mailer
+	appURL        string
+	auditor       audit.Recorder
+	logger        log.Logger
+}
+
+// NewService creates a new organization service.
+func NewService(repo Repository, transactional dbcontext.TransactionFunc, sender mailer.Mailer, appURL string, auditor audit.Recorder, logger log.Logger) Service {
+	return service{repo, transactional, sender, appURL, auditor, logger}
+}
+
+// Query returns the organizations the user is a member of.
+func (s service) Query(ctx context.Context, userID string) ([]Summary, error) {
+	return s.repo.QueryByUser(ctx, userID)
+}
+
+// Get returns the organization of the membership.
+func (s service) Get(ctx context.Context, membership entity.Membership) (entity.Organization, error) {
+	return s.repo.Get(ctx, membership.OrgID)
+}
+
+// Create creates a new organization owned by the user.
+func (s service) Create(ctx context.Context, userID string, req OrganizationRequest) (entity.Organization, error) {
+	if err := req.Validate(); err != nil {
+		return entity.Organization{}, err
+	}
+	org := entity.Organization{ID: entity.GenerateID(), Name: req.Name}
+	err := s.transactional(ctx, func(ctx context.Context) error {
+		if err := s.repo.Create(ctx, org); err != nil {
+			return err
+		}
+		if err := s.repo.CreateMembership(ctx, entity.Membership{OrgID: org.ID, UserID: userID, Role: entity.RoleOwner}); err != nil {
+			return err
+		}
+		return s.record(ctx, audit.ActionOrgCreated, org.ID, userID, "organization", org.ID, audit.Diff(nil, org))
+	})
+	if err != nil {
+		return entity.Organization{}, err
+	}
+	return s.repo.Get(ctx, org.ID)
+}
+
+// Update renames the organization of the membership.
+func (s service) Update(ctx context.Context, membership entity.Membership, req OrganizationRequest) (entity.Organization, error) {
+	if err := require(membership, auth.PermissionOrgManage); err != nil {
+		return entity.Organization{}, err
+	}
+	if err := req.Validate(); err != nil {
+		return entity.Organization{}, err
+	}
+	org, err := s.repo.Get(ctx, membership.OrgID)
+	if err != nil {
+		return org, err
+	}
+	before := org
+	now := time.Now()
+	org.Name = req.Name
+	org.UpdatedAt = &now
+	err = s.transactional(ctx, func(ctx context.Context) error {
+		if err := s.repo.Update(ctx, org); err != nil {
+			return err
+		}
+		return s.record(ctx, audit.ActionOrgUpdated, org.ID, membership.UserID, "organization", org.ID, audit.Diff(before, org))
+	})
+	return org, err
+}
+
+// Delete deletes the organization of the membership along with everything it owns.
+// Only owners can delete an organization, and personal organizations cannot be deleted.
+func (s service) Delete(ctx context.Context, membership entity.Membership) (entity.Organization, error) {
+	if err := require(membership, auth.PermissionOrgDelete); err != nil {
+		return entity.Organization{}, err
+	}
+	if membership.OrgID == membership.UserID {
+		return entity.Organization{}, errors.Forbidden("Personal organizations cannot be deleted")
+	}
+	org, err := s.repo.Get(ctx, membership.OrgID)
+	if err != nil {
+		return org, err
+	}
+	return org, s.transactional(ctx, func(ctx context.Context) error {
+		if err := s.repo.Delete(ctx, org.ID); err != nil {
+			return err
+		}
+		return s.record(ctx, audit.ActionOrgDeleted, org.ID, membership.UserID, "organization", org.ID, audit.Diff(org, nil))
+	})
+}
+
+// QueryMembers returns the members of the organization of the membership.
+func (s service) QueryMembers(ctx context.Context, membership entity.Membership) ([]Member, error) {
+	return s.repo.QueryMembers(ctx, membership.OrgID)
+}
+
+// RemoveMember removes the user from the organization of the membership. Members can remove themselves,
+// while removing someone else requires the permission to manage members. The last owner cannot be removed.
+func (s service) RemoveMember(ctx context.Context, membership entity.Membership, userID string) error {
+	if userID != membership.UserID {
+		if err := require(membership, auth.PermissionMembersManage); err != nil {
+			return err
+		}
+	}
+	return s.transactional(ctx, func(ctx context.Context) error {
+		target, err := s.repo.GetMembership(ctx, membership.OrgID, userID)
+		if err != nil {
+			return err
+		}
+		if target.Role == entity.RoleOwner {
+			if membership.Role != entity.RoleOwner {
+				return errors.Forbidden("Only owners can remove an owner")
+			}
+			owners, err := s.repo.CountOwners(ctx, membership.OrgID)
+			if err != nil {
+				return err
+			}
+			if owners <= 1 {
+				return errors.Conflict("The last owner cannot be removed from the organization")
+			}
+		}
+		if err := s.repo.DeleteMembership(ctx, membership.OrgID, userID); err != nil {
+			return err
+		}
+		return s.record(ctx, audit.ActionMemberRemoved, membership.OrgID, membership.UserID, "user", userID, audit.Diff(target, nil))
+	})
+}
+
+// QueryInvitations returns the pending invitations of the organization of the membership.
+func (s service) QueryInvitations(ctx context.Context, membership entity.Membership) ([]entity.Invitation, error) {
+	if err := require(membership, auth.PermissionMembersManage); err != nil {
+		return nil, err
+	}
+	return s.repo.QueryPendingInvitations(ctx, membership.OrgID, time.Now())
+}
+
+// Invite sends an email inviting someone to join the organization of the membership.
+func (s service) Invite(ctx context.Context, membership entity.Membership, req InviteRequest) (entity.Invitation, error) {
+	if err := require(membership, auth.PermissionMembersManage); err != nil {
+		return entity.Invitation{}, err
+	}
+	req.Email = strings.ToLower(strings.TrimSpace(req.Email))
+	if err := req.Validate(); err != nil {
+		return entity.Invitation{}, err
+	}
+	now := time.Now()
+	pending, err := s.repo.CountPendingInvitations(ctx, membership.OrgID, req.Email, now)
+	if err != nil {
+		return entity.Invitation{}, err
+	}
+	if pending > 0 {
+		return entity.Invitation{}, errors.Conflict("An invitation was already sent to this email address")
+	}
+	members, err := s.repo.QueryMembers(ctx, membership.OrgID)
+	if err != nil {
+		return entity.Invitation{}, err
+	}
+	for _, member := range members {
+		if strings.EqualFold(member.Email, req.Email) {
+			return entity.Invitation{}, errors.Conflict("The user is already a member of the organization")
+		}
+	}
+	org, err := s.repo.Get(ctx, membership.OrgID)
+	if err != nil {
+		return entity.Invitation{}, err
+	}
+
+	token, err := generateToken()
+	if err != nil {
+		return entity.Invitation{}, err
+	}
+	invitation := entity.Invitation{
+		ID:        entity.GenerateID(),
+		OrgID:     membership.OrgID,
+		Email:     req.Email,
+		Role:      req.Role,
+		TokenHash: hashToken(token),
+		InvitedBy: membership.UserID,
+		ExpiresAt: now.Add(invitationExpiration),
+	}
+	err = s.transactional(ctx, func(ctx context.Context) error {
+		if err := s.repo.CreateInvitation(ctx, invitation); err != nil {
+			return err
+		}
+		return s.record(ctx, audit.ActionInvitationSent, invitation.OrgID, membership.UserID, "invitation", invitation.ID, audit.Diff(nil, invitation))
+	})
+	if err != nil {
+		return entity.Invitation{}, err
+	}
+	s.sendInvitationEmail(ctx, org, invitation, token)
+	return invitation, nil
+}
+
+// RevokeInvitation deletes an invitation sent for the organization of the membership.
+func (s service) RevokeInvitation(ctx context.Context, membership entity.Membership, id string) error {
+	if err := require(membership, auth.PermissionMembersManage); err != nil {
+		return err
+	}
+	return s.transactional(ctx, func(ctx context.Context) error {
+		if err := s.repo.DeleteInvitation(ctx, membership.OrgID, id); err != nil {
+			return err
+		}
+		return s.record(ctx, audit.ActionInvitationRevoked, membership.OrgID, membership.UserID, "invitation", id, nil)
+	})
+}
+
+// AcceptInvitation makes the user a member of the organization the invitation was sent for.
+// The invitation must have been sent to the email address of the user.
+func (s service) AcceptInvitation(ctx context.Context, userID, token string) (entity.Membership, error) {
+	invitation, err := s.findInvitation(ctx, userID, token)
+	if err != nil {
+		return entity.Membership{}, err
+	}
+	membership := entity.Membership{OrgID: invitation.OrgID, UserID: userID, Role: invitation.Role}
+	err = s.transactional(ctx, func(ctx context.Context) error {
+		if err := s.repo.AnswerInvitation(ctx, invitation.ID, true, time.Now()); err != nil {
+			return err
+		}
+		if err := s.repo.CreateMembership(ctx, membership); err != nil {
+			return err
+		}
+		return s.record(ctx, audit.ActionInvitationAccepted, invitation.OrgID, userID, "invitation", invitation.ID, audit.Diff(nil, membership))
+	})
+	if err != nil {
+		return entity.Membership{}, err
+	}
+	return membership, nil
+}
+
+// DeclineInvitation declines an invitation sent to the email address of the user.
+func (s service) DeclineInvitation(ctx context.Context, userID, token string) error {
+	invitation, err := s.findInvitation(ctx, userID, token)
+	if err != nil {
+		return err
+	}
+	return s.transactional(ctx, func(ctx context.Context) error {
+		if err := s.repo.AnswerInvitation(ctx, invitation.ID, false, time.Now()); err != nil {
+			return err
+		}
+		return s.record(ctx, audit.ActionInvitationDeclined, invitation.OrgID, userID, "invitation", invitation.ID, nil)
+	})
+}
+
+// record records an action performed by the user in the organization in the audit log.
+func (s service) record(ctx context.Context, action, orgID, userID, targetType, targetID string, diff map[string]audit.Change) error {
+	return s.auditor.Record(ctx, audit.Event{
+		Action:     action,
+		OrgID:      orgID,
+		ActorID:    userID,
+		TargetType: targetType,
+		TargetID:   targetID,
+		Diff:       diff,
+	})
+}
+
+// findInvitation returns the pending invitation matching the token sent to the email address of the user.
+func (s service) findInvitation(ctx context.Context, userID, token string) (entity.Invitation, error) {
+	invitation, err := s.repo.GetInvitationByHash(ctx, hashToken(token))
+	if err != nil || !invitation.IsPending(time.Now()) {
+		return entity.Invitation{}, errors.BadRequest("The invitation is invalid or has expired")
+	}
+	user, err := s.repo.GetUser(ctx, userID)
+	if err != nil {
+		return entity.Invitation{}, err
+	}
+	if !strings.EqualFold(user.Email, invitation.Email) {
+		return entity.Invitation{}, errors.Forbidden("The invitation was sent to another email address")
+	}
+	return invitation, nil
+}
+
+// sendInvitationEmail sends the invitation link. Failures are logged since the invitation can be sent again.
+func (s service) sendInvitationEmail(ctx context.Context, org entity.Organization, invitation entity.Invitation, token string) {
+	link := fmt.Sprintf("%s/invitations?token=%s", s.appURL, token)
+	err := s.sender.Send(ctx, mailer.Message{
+		To:      []string{invitation.Email},
+		Subject: fmt.Sprintf("You have been invited to join %s", org.Name),
+		Text: fmt.Sprintf("Hi,\n\nYou have been invited to join %s as %s. Visit the link below to accept or decline the invitation:\n\n%s\n\n"+
+			"The invitation expires in %d days.\n", org.Name, invitation.Role, link, int(invitationExpiration.Hours()/24)),
+	})
+	if err != nil {
+		s.logger.With(ctx, "org", org.ID).Errorf("failed to send invitation email: %v", err)
+	}
+}
+
+// require returns a forbidden error if the role of the member does not grant the permission.
+func require(membership entity.Membership, permission string) error {
+	if !auth.RoleAllows(membership.Role, permission) {
+		return errors.Forbidden(fmt.Sprintf("The %s role is missing the %q permission", membership.Role, permission))
+	}
+	return nil
+}
+
+// generateToken generates a random URL-safe invitation token.
+func generateToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashToken returns the SHA-256 hash of the invitation token as stored in the database.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
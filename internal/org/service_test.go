@@ -0,0 +1,451 @@
+package org
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/garaekz/gonvelope/internal/audit"
+	"github.com/garaekz/gonvelope/internal/entity"
+	"github.com/garaekz/gonvelope/internal/errors"
+	"github.com/garaekz/gonvelope/pkg/log"
+	"github.com/garaekz/gonvelope/pkg/mailer"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestService(repo Repository) (service, *mailer.Sandbox) {
+	logger, _ := log.NewForTest()
+	sandbox := mailer.NewSandbox("noreply@example.com", "Gonvelope", logger)
+	return NewService(repo, mockTransactional, sandbox, "http://localhost", audit.NewLogRecorder(logger), logger).(service), sandbox
+}
+
+func mockTransactional(ctx context.Context, f func(ctx context.Context) error) error {
+	return f(ctx)
+}
+
+// newTestRepository returns a repository with an organization "org1" owned by user "100"
+// where user "200" is an admin and user "300" a member.
+func newTestRepository() *mockRepository {
+	return &mockRepository{
+		users: []entity.User{
+			{ID: "100", Name: "owner", Email: "owner@example.com"},
+			{ID: "200", Name: "admin", Email: "admin@example.com"},
+			{ID: "300", Name: "member", Email: "member@example.com"},
+			{ID: "400", Name: "guest", Email: "guest@example.com"},
+		},
+		orgs: []entity.Organization{{ID: "100", Name: "personal"}, {ID: "org1", Name: "Acme"}},
+		memberships: []entity.Membership{
+			{OrgID: "100", UserID: "100", Role: entity.RoleOwner},
+			{OrgID: "org1", UserID: "100", Role: entity.RoleOwner},
+			{OrgID: "org1", UserID: "200", Role: entity.RoleAdmin},
+			{OrgID: "org1", UserID: "300", Role: entity.RoleEditor},
+		},
+	}
+}
+
+// tokenFromMail extracts the token query parameter from the link in the last sent email.
+func tokenFromMail(t *testing.T, sandbox *mailer.Sandbox) string {
+	messages := sandbox.Messages()
+	if !assert.NotEmpty(t, messages) {
+		return ""
+	}
+	text := messages[len(messages)-1].Text
+	start := strings.Index(text, "http://")
+	if !assert.True(t, start >= 0) {
+		return ""
+	}
+	u, err := url.Parse(strings.Fields(text[start:])[0])
+	assert.Nil(t, err)
+	return u.Query().Get("token")
+}
+
+func statusCode(err error) int {
+	if res, ok := err.(errors.ErrorResponse); ok {
+		return res.Status
+	}
+	return 0
+}
+
+func TestInviteRequest_Validate(t *testing.T) {
+	tests := []struct {
+		name      string
+		model     InviteRequest
+		wantError bool
+	}{
+		{"success", InviteRequest{Email: "a@example.com", Role: entity.RoleEditor}, false},
+		{"invalid email", InviteRequest{Email: "test", Role: entity.RoleEditor}, true},
+		{"owner role", InviteRequest{Email: "a@example.com", Role: entity.RoleOwner}, true},
+		{"missing role", InviteRequest{Email: "a@example.com"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.model.Validate()
+			assert.Equal(t, tt.wantError, err != nil)
+		})
+	}
+}
+
+func Test_service_Create(t *testing.T) {
+	repo := newTestRepository()
+	s, _ := newTestService(repo)
+	ctx := context.Background()
+
+	org, err := s.Create(ctx, "400", OrganizationRequest{Name: "Guests"})
+	assert.Nil(t, err)
+	assert.Equal(t, "Guests", org.Name)
+	m, err := repo.GetMembership(ctx, org.ID, "400")
+	assert.Nil(t, err)
+	assert.Equal(t, entity.RoleOwner, m.Role)
+
+	_, err = s.Create(ctx, "400", OrganizationRequest{})
+	assert.NotNil(t, err)
+}
+
+func Test_service_Update(t *testing.T) {
+	repo := newTestRepository()
+	s, _ := newTestService(repo)
+	ctx := context.Background()
+
+	org, err := s.Update(ctx, repo.memberships[2], OrganizationRequest{Name: "Acme Inc"})
+	assert.Nil(t, err)
+	assert.Equal(t, "Acme Inc", org.Name)
+
+	_, err = s.Update(ctx, repo.memberships[3], OrganizationRequest{Name: "Mine"})
+	assert.Equal(t, http.StatusForbidden, statusCode(err))
+}
+
+func Test_service_Delete(t *testing.T) {
+	repo := newTestRepository()
+	s, _ := newTestService(repo)
+	ctx := context.Background()
+
+	_, err := s.Delete(ctx, repo.memberships[2])
+	assert.Equal(t, http.StatusForbidden, statusCode(err), "admins cannot delete")
+	_, err = s.Delete(ctx, repo.memberships[0])
+	assert.Equal(t, http.StatusForbidden, statusCode(err), "personal organization")
+
+	org, err := s.Delete(ctx, repo.memberships[1])
+	assert.Nil(t, err)
+	assert.Equal(t, "org1", org.ID)
+	_, err = repo.Get(ctx, "org1")
+	assert.Equal(t, sql.ErrNoRows, err)
+}
+
+func Test_service_RemoveMember(t *testing.T) {
+	repo := newTestRepository()
+	s, _ := newTestService(repo)
+	ctx := context.Background()
+	owner, admin, member := repo.memberships[1], repo.memberships[2], repo.memberships[3]
+
+	assert.Equal(t, http.StatusForbidden, statusCode(s.RemoveMember(ctx, member, "200")), "members cannot remove others")
+	assert.Equal(t, http.StatusForbidden, statusCode(s.RemoveMember(ctx, admin, "100")), "admins cannot remove owners")
+	assert.Equal(t, http.StatusConflict, statusCode(s.RemoveMember(ctx, owner, "100")), "last owner")
+	assert.Equal(t, sql.ErrNoRows, s.RemoveMember(ctx, owner, "400"))
+
+	assert.Nil(t, s.RemoveMember(ctx, member, "300"), "members can leave")
+	assert.Nil(t, s.RemoveMember(ctx, admin, "200"))
+	members, _ := s.QueryMembers(ctx, owner)
+	assert.Len(t, members, 1)
+}
+
+func Test_service_Invite(t *testing.T) {
+	repo := newTestRepository()
+	s, sandbox := newTestService(repo)
+	ctx := context.Background()
+	admin, member := repo.memberships[2], repo.memberships[3]
+
+	_, err := s.Invite(ctx, member, InviteRequest{Email: "guest@example.com", Role: entity.RoleEditor})
+	assert.Equal(t, http.StatusForbidden, statusCode(err))
+	_, err = s.Invite(ctx, admin, InviteRequest{Email: "member@example.com", Role: entity.RoleEditor})
+	assert.Equal(t, http.StatusConflict, statusCode(err), "already a member")
+
+	invitation, err := s.Invite(ctx, admin, InviteRequest{Email: " Guest@Example.com", Role: entity.RoleEditor})
+	assert.Nil(t, err)
+	assert.Equal(t, "guest@example.com", invitation.Email)
+	assert.Equal(t, "200", invitation.InvitedBy)
+	assert.Contains(t, sandbox.Messages()[0].Subject, "Acme")
+	token := tokenFromMail(t, sandbox)
+	assert.NotEmpty(t, token)
+	assert.Equal(t, hashToken(token), invitation.TokenHash)
+
+	_, err = s.Invite(ctx, admin, InviteRequest{Email: "guest@example.com", Role: entity.RoleAdmin})
+	assert.Equal(t, http.StatusConflict, statusCode(err), "already invited")
+
+	invitations, err := s.QueryInvitations(ctx, admin)
+	assert.Nil(t, err)
+	assert.Len(t, invitations, 1)
+	assert.Nil(t, s.RevokeInvitation(ctx, admin, invitation.ID))
+	invitations, _ = s.QueryInvitations(ctx, admin)
+	assert.Empty(t, invitations)
+}
+
+func Test_service_AcceptInvitation(t *testing.T) {
+	repo := newTestRepository()
+	s, sandbox := newTestService(repo)
+	ctx := context.Background()
+
+	_, err := s.Invite(ctx, repo.memberships[1], InviteRequest{Email: "guest@example.com", Role: entity.RoleAdmin})
+	assert.Nil(t, err)
+	token := tokenFromMail(t, sandbox)
+
+	_, err = s.AcceptInvitation(ctx, "400", "invalid")
+	assert.Equal(t, http.StatusBadRequest, statusCode(err))
+	_, err = s.AcceptInvitation(ctx, "300", token)
+	assert.Equal(t, http.StatusForbidden, statusCode(err), "sent to another address")
+
+	m, err := s.AcceptInvitation(ctx, "400", token)
+	assert.Nil(t, err)
+	assert.Equal(t, entity.RoleAdmin, m.Role)
+	_, err = repo.GetMembership(ctx, "org1", "400")
+	assert.Nil(t, err)
+
+	_, err = s.AcceptInvitation(ctx, "400", token)
+	assert.Equal(t, http.StatusBadRequest, statusCode(err), "already accepted")
+}
+
+func Test_service_DeclineInvitation(t *testing.T) {
+	repo := newTestRepository()
+	s, sandbox := newTestService(repo)
+	ctx := context.Background()
+
+	_, err := s.Invite(ctx, repo.memberships[1], InviteRequest{Email: "guest@example.com", Role: entity.RoleEditor})
+	assert.Nil(t, err)
+	token := tokenFromMail(t, sandbox)
+
+	assert.Nil(t, s.DeclineInvitation(ctx, "400", token))
+	_, err = s.AcceptInvitation(ctx, "400", token)
+	assert.Equal(t, http.StatusBadRequest, statusCode(err))
+	_, err = repo.GetMembership(ctx, "org1", "400")
+	assert.Equal(t, sql.ErrNoRows, err)
+}
+
+func Test_service_expiredInvitation(t *testing.T) {
+	repo := newTestRepository()
+	s, _ := newTestService(repo)
+	repo.invitations = append(repo.invitations, entity.Invitation{
+		ID: "inv", OrgID: "org1", Email: "guest@example.com", Role: entity.RoleEditor,
+		TokenHash: hashToken("expired"), ExpiresAt: time.Now().Add(-time.Minute),
+	})
+	_, err := s.AcceptInvitation(context.Background(), "400", "expired")
+	assert.Equal(t, http.StatusBadRequest, statusCode(err))
+}
+
+type mockRepository struct {
+	users       []entity.User
+	orgs        []entity.Organization
+	memberships []entity.Membership
+	invitations []entity.Invitation
+}
+
+func (m *mockRepository) GetUser(_ context.Context, id string) (entity.User, error) {
+	for _, user := range m.users {
+		if user.ID == id {
+			return user, nil
+		}
+	}
+	return entity.User{}, sql.ErrNoRows
+}
+
+func (m *mockRepository) Get(_ context.Context, id string) (entity.Organization, error) {
+	for _, org := range m.orgs {
+		if org.ID == id {
+			return org, nil
+		}
+	}
+	return entity.Organization{}, sql.ErrNoRows
+}
+
+func (m *mockRepository) QueryByUser(ctx context.Context, userID string) ([]Summary, error) {
+	var orgs []Summary
+	for _, membership := range m.memberships {
+		if membership.UserID == userID {
+			org, _ := m.Get(ctx, membership.OrgID)
+			orgs = append(orgs, Summary{ID: org.ID, Name: org.Name, Role: membership.Role})
+		}
+	}
+	return orgs, nil
+}
+
+func (m *mockRepository) Create(_ context.Context, org entity.Organization) error {
+	m.orgs = append(m.orgs, org)
+	return nil
+}
+
+func (m *mockRepository) Update(_ context.Context, org entity.Organization) error {
+	for i, item := range m.orgs {
+		if item.ID == org.ID {
+			m.orgs[i] = org
+			return nil
+		}
+	}
+	return sql.ErrNoRows
+}
+
+func (m *mockRepository) Delete(_ context.Context, id string) error {
+	for i, org := range m.orgs {
+		if org.ID == id {
+			m.orgs = append(m.orgs[:i], m.orgs[i+1:]...)
+			return nil
+		}
+	}
+	return sql.ErrNoRows
+}
+
+func (m *mockRepository) GetMembership(_ context.Context, orgID, userID string) (entity.Membership, error) {
+	for _, membership := range m.memberships {
+		if membership.OrgID == orgID && membership.UserID == userID {
+			return membership, nil
+		}
+	}
+	return entity.Membership{}, sql.ErrNoRows
+}
+
+func (m *mockRepository) CreateMembership(ctx context.Context, membership entity.Membership) error {
+	if _, err := m.GetMembership(ctx, membership.OrgID, membership.UserID); err == nil {
+		return errors.Conflict("The user is already a member of the organization")
+	}
+	m.memberships = append(m.memberships, membership)
+	return nil
+}
+
+func (m *mockRepository) DeleteMembership(_ context.Context, orgID, userID string) error {
+	for i, membership := range m.memberships {
+		if membership.OrgID == orgID && membership.UserID == userID {
+			m.memberships = append(m.memberships[:i], m.memberships[i+1:]...)
+			return nil
+		}
+	}
+	return sql.ErrNoRows
+}
+
+func (m *mockRepository) QueryMembers(ctx context.Context, orgID string) ([]Member, error) {
+	var members []Member
+	for _, membership := range m.memberships {
+		if membership.OrgID == orgID {
+			user, _ := m.GetUser(ctx, membership.UserID)
+			members = append(members, Member{UserID: user.ID, Name: user.Name, Email: user.Email, Role: membership.Role})
+		}
+	}
+	return members, nil
+}
+
+func (m *mockRepository) CountOwners(_ context.Context, orgID string) (int, error) {
+	count := 0
+	for _, membership := range m.memberships {
+		if membership.OrgID == orgID && membership.Role == entity.RoleOwner {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (m *mockRepository) GetInvitation(_ context.Context, orgID, id string) (entity.Invitation, error) {
+	for _, invitation := range m.invitations {
+		if invitation.OrgID == orgID && invitation.ID == id {
+			return invitation, nil
+		}
+	}
+	return entity.Invitation{}, sql.ErrNoRows
+}
+
+func (m *mockRepository) GetInvitationByHash(_ context.Context, hash string) (entity.Invitation, error) {
+	for _, invitation := range m.invitations {
+		if invitation.TokenHash == hash {
+			return invitation, nil
+		}
+	}
+	return entity.Invitation{}, sql.ErrNoRows
+}
+
+func (m *mockRepository) QueryPendingInvitations(_ context.Context, orgID string, now time.Time) ([]entity.Invitation, error) {
+	var invitations []entity.Invitation
+	for _, invitation := range m.invitations {
+		if invitation.OrgID == orgID && invitation.IsPending(now) {
+			invitations = append(invitations, invitation)
+		}
+	}
+	return invitations, nil
+}
+
+func (m *mockRepository) CountPendingInvitations(ctx context.Context, orgID, email string, now time.Time) (int, error) {
+	invitations, _ := m.QueryPendingInvitations(ctx, orgID, now)
+	count := 0
+	for _, invitation := range invitations {
+		if strings.EqualFold(invitation.Email, email) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (m *mockRepository) CreateInvitation(_ context.Context, invitation entity.Invitation) error {
+	m.invitations = append(m.invitations, invitation)
+	return nil
+}
+
+func (m *mockRepository) AnswerInvitation(_ context.Context, id string, accepted bool, at time.Time) error {
+	for i, invitation := range m.invitations {
+		if invitation.ID == id && invitation.AcceptedAt == nil && invitation.DeclinedAt == nil {
+			if accepted {
+				m.invitations[i].AcceptedAt = &at
+			} else {
+				m.invitations[i].DeclinedAt = &at
+			}
+			return nil
+		}
+	}
+	return sql.ErrNoRows
+}
+
+func (m *mockRepository) DeleteInvitation(_ context.Context, orgID, id string) error {
+	for i, invitation := range m.invitations {
+		if invitation.OrgID == orgID && invitation.ID == id {
+			m.invitations = append(m.invitations[:i], m.invitations[i+1:]...)
+			return nil
+		}
+	}
+	return sql.ErrNoRows
+}
+
+func Test_service_audit(t *testing.T) {
+	repo := newTestRepository()
+	logger, _ := log.NewForTest()
+	auditor := &mockRecorder{}
+	s := NewService(repo, mockTransactional, mailer.NewSandbox("noreply@example.com", "Gonvelope", logger), "http://localhost", auditor, logger)
+	ctx := context.Background()
+
+	_, err := s.Invite(ctx, repo.memberships[2], InviteRequest{Email: "guest@example.com", Role: entity.RoleViewer})
+	assert.Nil(t, err)
+	assert.Nil(t, s.RemoveMember(ctx, repo.memberships[1], "300"))
+	if assert.Len(t, auditor.events, 2) {
+		assert.Equal(t, audit.ActionInvitationSent, auditor.events[0].Action)
+		assert.Equal(t, "200", auditor.events[0].ActorID)
+		assert.NotContains(t, auditor.events[0].Diff, "token_hash")
+		assert.Equal(t, audit.Event{
+			Action: audit.ActionMemberRemoved, OrgID: "org1", ActorID: "100", TargetType: "user", TargetID: "300",
+			Diff: audit.Diff(entity.Membership{OrgID: "org1", UserID: "300", Role: entity.RoleEditor}, nil),
+		}, auditor.events[1])
+	}
+
+	// the action is rolled back when it cannot be audited
+	auditor.err = sql.ErrConnDone
+	_, err = s.Create(ctx, "400", OrganizationRequest{Name: "Guests"})
+	assert.NotNil(t, err)
+}
+
+type mockRecorder struct {
+	events []audit.Event
+	err    error
+}
+
+func (m *mockRecorder) Record(_ context.Context, event audit.Event) error {
+	if m.err != nil {
+		return m.err
+	}
+	m.events = append(m.events, event)
+	return nil
+}
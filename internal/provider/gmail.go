@@ -0,0 +1,139 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/garaekz/gonvelope/internal/entity"
+	"golang.org/x/oauth2"
+)
+
+// gmailEndpoint is the base URL of the Gmail API.
+const gmailEndpoint = "https://gmail.googleapis.com/gmail/v1/users/me"
+
+// gmailReadScopes lists the scopes that allow reading a Gmail mailbox.
+var gmailReadScopes = []string{
+	"https://www.googleapis.com/auth/gmail.readonly",
+	"https://www.googleapis.com/auth/gmail.modify",
+	"https://mail.google.com/",
+}
+
+// gmail sends and reads messages through the Gmail API.
+type gmail struct {
+	config   *oauth2.Config
+	endpoint string
+}
+
+// NewGmail creates a sender that delivers messages through the Gmail API.
+func NewGmail(config *oauth2.Config) Sender {
+	return gmail{config, gmailEndpoint}
+}
+
+// NewGmailReader creates a reader that reads mailboxes through the Gmail API.
+func NewGmailReader(config *oauth2.Config) Reader {
+	return gmail{config, gmailEndpoint}
+}
+
+// Send delivers the message with the messages.send method of the Gmail API.
+func (g gmail) Send(ctx context.Context, account entity.UserProviderAccount, raw []byte) (string, error) {
+	body, err := json.Marshal(map[string]string{"raw": base64.URLEncoding.EncodeToString(raw)})
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, g.endpoint+"/messages/send", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := client(ctx, g.config, account).Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	if err := checkResponse("gmail", res, time.Now()); err != nil {
+		return "", err
+	}
+
+	var sent struct {
+		ID string `json:"id"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&sent); err != nil {
+		return "", err
+	}
+	return sent.ID, nil
+}
+
+// CanRead returns whether the user granted one of the Gmail scopes allowing to read the mailbox.
+func (g gmail) CanRead(account entity.UserProviderAccount) bool {
+	return hasAnyScope(account, gmailReadScopes...)
+}
+
+// Received lists the messages received in the inbox since the given time with the messages.list method
+// of the Gmail API, then fetches each of them in raw format.
+func (g gmail) Received(ctx context.Context, account entity.UserProviderAccount, since time.Time) ([]Received, error) {
+	httpClient := client(ctx, g.config, account)
+	query := url.Values{
+		"q":          {fmt.Sprintf("in:inbox after:%d", since.Unix())},
+		"maxResults": {"100"},
+	}
+	var ids []string
+	for {
+		var list struct {
+			Messages []struct {
+				ID string `json:"id"`
+			} `json:"messages"`
+			NextPageToken string `json:"nextPageToken"`
+		}
+		if err := g.get(ctx, httpClient, g.endpoint+"/messages?"+query.Encode(), &list); err != nil {
+			return nil, err
+		}
+		for _, message := range list.Messages {
+			ids = append(ids, message.ID)
+		}
+		if list.NextPageToken == "" {
+			break
+		}
+		query.Set("pageToken", list.NextPageToken)
+	}
+
+	received := make([]Received, 0, len(ids))
+	for _, id := range ids {
+		var message struct {
+			Raw string `json:"raw"`
+		}
+		if err := g.get(ctx, httpClient, g.endpoint+"/messages/"+url.PathEscape(id)+"?format=raw", &message); err != nil {
+			return nil, err
+		}
+		raw, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(message.Raw, "="))
+		if err != nil {
+			return nil, err
+		}
+		received = append(received, Received{ID: id, Raw: raw})
+	}
+	return received, nil
+}
+
+// get sends a GET request to the Gmail API and decodes the JSON response into v.
+func (g gmail) get(ctx context.Context, httpClient *http.Client, target string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return err
+	}
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if err := checkResponse("gmail", res, time.Now()); err != nil {
+		return err
+	}
+	return json.NewDecoder(res.Body).Decode(v)
+}
@@ -0,0 +1,124 @@
+package provider
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/garaekz/gonvelope/internal/entity"
+	"golang.org/x/oauth2"
+)
+
+// graphEndpoint is the base URL of the Microsoft Graph API.
+const graphEndpoint = "https://graph.microsoft.com/v1.0/me"
+
+// graphReadScopes lists the scopes that allow reading an Outlook mailbox.
+var graphReadScopes = []string{
+	"Mail.Read",
+	"Mail.ReadWrite",
+	"https://graph.microsoft.com/Mail.Read",
+	"https://graph.microsoft.com/Mail.ReadWrite",
+}
+
+// graph sends and reads messages through the Microsoft Graph API.
+type graph struct {
+	config   *oauth2.Config
+	endpoint string
+}
+
+// NewGraph creates a sender that delivers messages through the Microsoft Graph API.
+func NewGraph(config *oauth2.Config) Sender {
+	return graph{config, graphEndpoint}
+}
+
+// NewGraphReader creates a reader that reads mailboxes through the Microsoft Graph API.
+func NewGraphReader(config *oauth2.Config) Reader {
+	return graph{config, graphEndpoint}
+}
+
+// Send delivers the message in MIME format with the sendMail action of the Graph API.
+// Graph does not return the ID of the sent message, so the returned ID is always empty.
+func (g graph) Send(ctx context.Context, account entity.UserProviderAccount, raw []byte) (string, error) {
+	body := base64.StdEncoding.EncodeToString(raw)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, g.endpoint+"/sendMail", strings.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "text/plain")
+
+	res, err := client(ctx, g.config, account).Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	return "", checkResponse("graph", res, time.Now())
+}
+
+// CanRead returns whether the user granted one of the Graph scopes allowing to read the mailbox.
+func (g graph) CanRead(account entity.UserProviderAccount) bool {
+	return hasAnyScope(account, graphReadScopes...)
+}
+
+// Received lists the messages received in the inbox since the given time, then fetches the MIME content
+// of each of them.
+func (g graph) Received(ctx context.Context, account entity.UserProviderAccount, since time.Time) ([]Received, error) {
+	httpClient := client(ctx, g.config, account)
+	query := url.Values{
+		"$filter": {"receivedDateTime ge " + since.UTC().Format(time.RFC3339)},
+		"$select": {"id"},
+		"$top":    {"100"},
+	}
+	var ids []string
+	next := g.endpoint + "/mailFolders/inbox/messages?" + query.Encode()
+	for next != "" {
+		var list struct {
+			Value []struct {
+				ID string `json:"id"`
+			} `json:"value"`
+			NextLink string `json:"@odata.nextLink"`
+		}
+		body, err := g.get(ctx, httpClient, next)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(body, &list); err != nil {
+			return nil, err
+		}
+		for _, message := range list.Value {
+			ids = append(ids, message.ID)
+		}
+		next = list.NextLink
+	}
+
+	received := make([]Received, 0, len(ids))
+	for _, id := range ids {
+		raw, err := g.get(ctx, httpClient, g.endpoint+"/messages/"+url.PathEscape(id)+"/$value")
+		if err != nil {
+			return nil, err
+		}
+		received = append(received, Received{ID: id, Raw: raw})
+	}
+	return received, nil
+}
+
+// get sends a GET request to the Graph API and returns the response body.
+func (g graph) get(ctx context.Context, httpClient *http.Client, target string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if err := checkResponse("graph", res, time.Now()); err != nil {
+		return nil, err
+	}
+	return io.ReadAll(res.Body)
+}
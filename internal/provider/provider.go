@@ -0,0 +1,106 @@
+// Package provider delivers and reads messages through the APIs of the mailbox providers users link with OAuth.
+package provider
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/garaekz/gonvelope/internal/entity"
+	"golang.org/x/oauth2"
+)
+
+// Sender sends raw RFC 5322 messages as the owner of a linked provider account.
+type Sender interface {
+	// Send delivers the message and returns the ID the provider assigned to it, if any.
+	// A RetryAfterError is returned when the provider throttles the account.
+	Send(ctx context.Context, account entity.UserProviderAccount, raw []byte) (string, error)
+}
+
+// Senders maps provider names to the sender delivering messages through them.
+type Senders map[string]Sender
+
+// Reader reads the messages received by linked provider accounts.
+type Reader interface {
+	// CanRead returns whether the user granted the scopes needed to read the mailbox of the account.
+	CanRead(account entity.UserProviderAccount) bool
+	// Received returns all the messages received in the inbox of the account since the given time.
+	// The listing is paged through until it is exhausted so that no message is skipped.
+	Received(ctx context.Context, account entity.UserProviderAccount, since time.Time) ([]Received, error)
+}
+
+// Readers maps provider names to the reader reading mailboxes through them.
+type Readers map[string]Reader
+
+// Received is a message found in the mailbox of a linked provider account.
+type Received struct {
+	// ID is the ID the provider assigned to the message.
+	ID string
+	// Raw is the RFC 5322 representation of the message.
+	Raw []byte
+}
+
+// RetryAfterError is returned when a provider throttles an account and asks to wait before sending again.
+type RetryAfterError struct {
+	Provider   string
+	RetryAfter time.Duration
+}
+
+// Error implements the error interface.
+func (e RetryAfterError) Error() string {
+	return fmt.Sprintf("%s throttled the account, retry after %s", e.Provider, e.RetryAfter)
+}
+
+// defaultRetryAfter is how long an account is paused when a provider throttles it without a Retry-After header.
+const defaultRetryAfter = time.Minute
+
+// client returns an HTTP client authenticated with the OAuth token of the account.
+// The token is refreshed with config when it has expired.
+func client(ctx context.Context, config *oauth2.Config, account entity.UserProviderAccount) *http.Client {
+	token := &oauth2.Token{
+		AccessToken:  account.AccessToken,
+		RefreshToken: account.RefreshToken,
+		Expiry:       account.TokenExpiry,
+	}
+	return config.Client(ctx, token)
+}
+
+// checkResponse returns an error if the response does not have a 2xx status code.
+// Throttling responses are turned into a RetryAfterError.
+func checkResponse(provider string, res *http.Response, now time.Time) error {
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		return nil
+	}
+	if res.StatusCode == http.StatusTooManyRequests || res.StatusCode == http.StatusServiceUnavailable {
+		return RetryAfterError{provider, parseRetryAfter(res.Header.Get("Retry-After"), now)}
+	}
+	body, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+	return fmt.Errorf("%s responded with status %d: %s", provider, res.StatusCode, strings.TrimSpace(string(body)))
+}
+
+// hasAnyScope returns whether the account was granted any of the given scopes.
+func hasAnyScope(account entity.UserProviderAccount, scopes ...string) bool {
+	for _, granted := range account.Scopes {
+		for _, scope := range scopes {
+			if strings.EqualFold(granted, scope) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// parseRetryAfter parses a Retry-After header holding either a number of seconds or an HTTP date.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if seconds, err := strconv.Atoi(strings.TrimSpace(value)); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil && at.After(now) {
+		return at.Sub(now)
+	}
+	return defaultRetryAfter
+}
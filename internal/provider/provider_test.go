@@ -0,0 +1,161 @@
+package provider
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/garaekz/gonvelope/internal/entity"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+var testAccount = entity.UserProviderAccount{
+	ID:          "account1",
+	AccessToken: "access",
+	TokenExpiry: time.Now().Add(time.Hour),
+}
+
+func Test_gmail_Send(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/messages/send", r.URL.Path)
+		assert.Equal(t, "Bearer access", r.Header.Get("Authorization"))
+		var body struct {
+			Raw string `json:"raw"`
+		}
+		require.Nil(t, json.NewDecoder(r.Body).Decode(&body))
+		raw, err := base64.URLEncoding.DecodeString(body.Raw)
+		require.Nil(t, err)
+		if string(raw) == "throttled" {
+			w.Header().Set("Retry-After", "120")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		_, _ = w.Write([]byte(`{"id":"gmail-id"}`))
+	}))
+	defer server.Close()
+
+	sender := gmail{&oauth2.Config{}, server.URL}
+	id, err := sender.Send(context.Background(), testAccount, []byte("message"))
+	assert.Nil(t, err)
+	assert.Equal(t, "gmail-id", id)
+
+	_, err = sender.Send(context.Background(), testAccount, []byte("throttled"))
+	assert.Equal(t, RetryAfterError{"gmail", 2 * time.Minute}, err)
+}
+
+func Test_graph_Send(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/sendMail", r.URL.Path)
+		assert.Equal(t, "text/plain", r.Header.Get("Content-Type"))
+		body, _ := io.ReadAll(r.Body)
+		raw, err := base64.StdEncoding.DecodeString(string(body))
+		require.Nil(t, err)
+		switch string(raw) {
+		case "throttled":
+			w.WriteHeader(http.StatusTooManyRequests)
+		case "invalid":
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error":{"code":"ErrorMimeContentInvalid"}}`))
+		default:
+			w.WriteHeader(http.StatusAccepted)
+		}
+	}))
+	defer server.Close()
+
+	sender := graph{&oauth2.Config{}, server.URL}
+	_, err := sender.Send(context.Background(), testAccount, []byte("message"))
+	assert.Nil(t, err)
+
+	_, err = sender.Send(context.Background(), testAccount, []byte("throttled"))
+	assert.Equal(t, RetryAfterError{"graph", defaultRetryAfter}, err)
+
+	_, err = sender.Send(context.Background(), testAccount, []byte("invalid"))
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "status 400")
+	}
+}
+
+func Test_gmail_Received(t *testing.T) {
+	since := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/messages":
+			assert.Equal(t, fmt.Sprintf("in:inbox after:%d", since.Unix()), r.URL.Query().Get("q"))
+			if r.URL.Query().Get("pageToken") == "" {
+				_, _ = w.Write([]byte(`{"messages":[{"id":"m1"}],"nextPageToken":"p2"}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"messages":[{"id":"m2"}]}`))
+		case "/messages/m1", "/messages/m2":
+			assert.Equal(t, "raw", r.URL.Query().Get("format"))
+			raw := base64.URLEncoding.EncodeToString([]byte("Subject: " + r.URL.Path[len("/messages/"):] + "\r\n\r\nbody"))
+			_, _ = w.Write([]byte(`{"raw":"` + raw + `"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	reader := gmail{&oauth2.Config{}, server.URL}
+	received, err := reader.Received(context.Background(), testAccount, since)
+	require.Nil(t, err)
+	if assert.Len(t, received, 2) {
+		assert.Equal(t, "m1", received[0].ID)
+		assert.Equal(t, "Subject: m1\r\n\r\nbody", string(received[0].Raw))
+		assert.Equal(t, "m2", received[1].ID)
+	}
+
+	assert.False(t, reader.CanRead(testAccount))
+	account := testAccount
+	account.Scopes = []string{"https://www.googleapis.com/auth/gmail.send", "https://www.googleapis.com/auth/gmail.readonly"}
+	assert.True(t, reader.CanRead(account))
+}
+
+func Test_graph_Received(t *testing.T) {
+	since := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/mailFolders/inbox/messages":
+			if r.URL.Query().Get("page") == "" {
+				assert.Equal(t, "receivedDateTime ge 2026-10-18T12:00:00Z", r.URL.Query().Get("$filter"))
+				_, _ = w.Write([]byte(`{"value":[{"id":"m1"}],"@odata.nextLink":"` + server.URL + `/mailFolders/inbox/messages?page=2"}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"value":[{"id":"m2"}]}`))
+		case "/messages/m1/$value", "/messages/m2/$value":
+			_, _ = w.Write([]byte("Subject: hi\r\n\r\nbody"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	reader := graph{&oauth2.Config{}, server.URL}
+	received, err := reader.Received(context.Background(), testAccount, since)
+	require.Nil(t, err)
+	if assert.Len(t, received, 2) {
+		assert.Equal(t, "m2", received[1].ID)
+		assert.Equal(t, "Subject: hi\r\n\r\nbody", string(received[1].Raw))
+	}
+
+	account := testAccount
+	account.Scopes = []string{"https://graph.microsoft.com/Mail.Read"}
+	assert.True(t, reader.CanRead(account))
+}
+
+func Test_parseRetryAfter(t *testing.T) {
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	assert.Equal(t, 30*time.Second, parseRetryAfter("30", now))
+	assert.Equal(t, time.Hour, parseRetryAfter(now.Add(time.Hour).Format(http.TimeFormat), now))
+	assert.Equal(t, defaultRetryAfter, parseRetryAfter("", now))
+	assert.Equal(t, defaultRetryAfter, parseRetryAfter("soon", now))
+}
@@ -0,0 +1,41 @@
+package quota
+
+import (
+	"github.com/garaekz/gonvelope/internal/auth"
+	"github.com/garaekz/gonvelope/pkg/log"
+	routing "github.com/garaekz/ozzo-routing"
+)
+
+// RegisterHandlers sets up the routing of the HTTP handlers.
+// Quotas are reported for the provider accounts of the organization made active by orgHandler.
+func RegisterHandlers(r *routing.RouteGroup, service Service, authHandler, orgHandler routing.Handler, logger log.Logger) {
+	res := resource{service, logger}
+
+	r.Use(authHandler, orgHandler)
+
+	r.Get("accounts/quotas", auth.Require(auth.ScopeAccountsRead), res.query)
+	r.Get("accounts/<id>/quota", auth.Require(auth.ScopeAccountsRead), res.get)
+}
+
+type resource struct {
+	service Service
+	logger  log.Logger
+}
+
+func (r resource) get(c *routing.Context) error {
+	membership, _ := auth.CurrentMembership(c.Request.Context())
+	quota, err := r.service.Get(c.Request.Context(), membership.OrgID, c.Param("id"))
+	if err != nil {
+		return err
+	}
+	return c.Write(quota)
+}
+
+func (r resource) query(c *routing.Context) error {
+	membership, _ := auth.CurrentMembership(c.Request.Context())
+	quotas, err := r.service.Query(c.Request.Context(), membership.OrgID)
+	if err != nil {
+		return err
+	}
+	return c.Write(quotas)
+}
@@ -0,0 +1,31 @@
+package quota
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/garaekz/gonvelope/internal/auth"
+	"github.com/garaekz/gonvelope/internal/entity"
+	"github.com/garaekz/gonvelope/internal/org"
+	"github.com/garaekz/gonvelope/internal/test"
+	"github.com/garaekz/gonvelope/pkg/log"
+)
+
+func TestAPI(t *testing.T) {
+	logger, _ := log.NewForTest()
+	router := test.MockRouter(logger)
+	RegisterHandlers(router.Group("/"), NewService(newMockRepository(), mockTransactional, testPolicy, logger), auth.MockAuthHandler, org.MockHandler, logger)
+	header := auth.MockAuthHeader()
+	header.Set(org.HeaderOrgID, "org1")
+
+	tests := []test.APITestCase{
+		{Name: "get all", Method: "GET", URL: "/accounts/quotas", Header: header, WantStatus: http.StatusOK, WantResponse: `*"account_id":"outlook1"*`},
+		{Name: "get", Method: "GET", URL: "/accounts/gmail1/quota", Header: header, WantStatus: http.StatusOK, WantResponse: `*"daily_remaining":3*`},
+		{Name: "get other org", Method: "GET", URL: "/accounts/gmail1/quota", Header: auth.MockAuthHeader(), WantStatus: http.StatusNotFound},
+		{Name: "viewer", Method: "GET", URL: "/accounts/quotas", Header: org.MockHeader(entity.RoleViewer), WantStatus: http.StatusOK, WantResponse: "[]"},
+		{Name: "unauthorized", Method: "GET", URL: "/accounts/quotas", WantStatus: http.StatusUnauthorized},
+	}
+	for _, tc := range tests {
+		test.Endpoint(t, router, tc)
+	}
+}
@@ -0,0 +1,119 @@
+package quota
+
+import (
+	"context"
+	"time"
+
+	"github.com/garaekz/gonvelope/pkg/dbcontext"
+	"github.com/garaekz/gonvelope/pkg/log"
+	dbx "github.com/go-ozzo/ozzo-dbx"
+)
+
+// Repository encapsulates the logic to access provider accounts and their sends from the data source.
+type Repository interface {
+	// GetAccount returns the provider account with the specified ID owned by the organization.
+	GetAccount(ctx context.Context, orgID, id string) (Account, error)
+	// QueryAccounts returns the provider accounts owned by the organization.
+	QueryAccounts(ctx context.Context, orgID string) ([]Account, error)
+	// LockAccount returns the provider account with the specified ID and locks it until the end of the transaction.
+	LockAccount(ctx context.Context, id string) (Account, error)
+	// Pause pauses the provider account until the given time.
+	Pause(ctx context.Context, id string, until time.Time) error
+	// CountSends returns the number of sends through the account since the given time.
+	CountSends(ctx context.Context, accountID string, since time.Time) (int, error)
+	// GetSendTime returns the time of the send at the given offset among the sends through the account
+	// since the given time, from the oldest to the newest.
+	GetSendTime(ctx context.Context, accountID string, since time.Time, offset int) (time.Time, error)
+	// RecordSend records a send with the given ID through the account.
+	RecordSend(ctx context.Context, id, accountID string, at time.Time) error
+	// DeleteSend removes the send with the specified ID.
+	DeleteSend(ctx context.Context, id string) error
+	// DeleteSends removes the sends older than the given time.
+	DeleteSends(ctx context.Context, before time.Time) error
+}
+
+// repository persists provider account sends in database
+type repository struct {
+	db     *dbcontext.DB
+	logger log.Logger
+}
+
+// NewRepository creates a new quota repository
+func NewRepository(db *dbcontext.DB, logger log.Logger) Repository {
+	return repository{db, logger}
+}
+
+// selectAccounts selects provider accounts along with the name of their provider.
+const selectAccounts = `SELECT a.*, p.name AS provider FROM user_provider_accounts a JOIN providers p ON p.id = a.provider_id`
+
+// GetAccount returns the provider account with the specified ID owned by the organization.
+func (r repository) GetAccount(ctx context.Context, orgID, id string) (Account, error) {
+	var account Account
+	err := r.db.With(ctx).NewQuery(selectAccounts + ` WHERE a.id = {:id} AND a.org_id = {:org}`).
+		Bind(dbx.Params{"id": id, "org": orgID}).One(&account)
+	return account, err
+}
+
+// QueryAccounts returns the provider accounts owned by the organization.
+func (r repository) QueryAccounts(ctx context.Context, orgID string) ([]Account, error) {
+	var accounts []Account
+	err := r.db.With(ctx).NewQuery(selectAccounts + ` WHERE a.org_id = {:org} ORDER BY a.name`).
+		Bind(dbx.Params{"org": orgID}).All(&accounts)
+	return accounts, err
+}
+
+// LockAccount returns the provider account with the specified ID and locks it until the end of the transaction.
+func (r repository) LockAccount(ctx context.Context, id string) (Account, error) {
+	var account Account
+	err := r.db.With(ctx).NewQuery(selectAccounts + ` WHERE a.id = {:id} FOR UPDATE OF a`).
+		Bind(dbx.Params{"id": id}).One(&account)
+	return account, err
+}
+
+// Pause pauses the provider account until the given time.
+func (r repository) Pause(ctx context.Context, id string, until time.Time) error {
+	_, err := r.db.With(ctx).Update("user_provider_accounts", dbx.Params{"paused_until": until}, dbx.HashExp{"id": id}).Execute()
+	return err
+}
+
+// CountSends returns the number of sends through the account since the given time.
+func (r repository) CountSends(ctx context.Context, accountID string, since time.Time) (int, error) {
+	var count int
+	err := r.db.With(ctx).Select("COUNT(*)").From("provider_sends").
+		Where(dbx.HashExp{"account_id": accountID}).
+		AndWhere(dbx.NewExp("sent_at > {:since}", dbx.Params{"since": since})).
+		Row(&count)
+	return count, err
+}
+
+// GetSendTime returns the time of the send at the given offset among the sends through the account
+// since the given time, from the oldest to the newest.
+func (r repository) GetSendTime(ctx context.Context, accountID string, since time.Time, offset int) (time.Time, error) {
+	var at time.Time
+	err := r.db.With(ctx).Select("sent_at").From("provider_sends").
+		Where(dbx.HashExp{"account_id": accountID}).
+		AndWhere(dbx.NewExp("sent_at > {:since}", dbx.Params{"since": since})).
+		OrderBy("sent_at").
+		Offset(int64(offset)).
+		Limit(1).
+		Row(&at)
+	return at, err
+}
+
+// RecordSend records a send with the given ID through the account.
+func (r repository) RecordSend(ctx context.Context, id, accountID string, at time.Time) error {
+	_, err := r.db.With(ctx).Insert("provider_sends", dbx.Params{"id": id, "account_id": accountID, "sent_at": at}).Execute()
+	return err
+}
+
+// DeleteSend removes the send with the specified ID.
+func (r repository) DeleteSend(ctx context.Context, id string) error {
+	_, err := r.db.With(ctx).Delete("provider_sends", dbx.HashExp{"id": id}).Execute()
+	return err
+}
+
+// DeleteSends removes the sends older than the given time.
+func (r repository) DeleteSends(ctx context.Context, before time.Time) error {
+	_, err := r.db.With(ctx).Delete("provider_sends", dbx.NewExp("sent_at <= {:before}", dbx.Params{"before": before})).Execute()
+	return err
+}
@@ -0,0 +1,232 @@
+// Package quota tracks the messages sent through each provider account over rolling windows so that
+// accounts stay within the sending limits of their provider.
+package quota
+
+import (
+	"context"
+	"time"
+
+	"github.com/garaekz/gonvelope/internal/entity"
+	"github.com/garaekz/gonvelope/pkg/dbcontext"
+	"github.com/garaekz/gonvelope/pkg/log"
+)
+
+// Limits represents the number of messages an account may send over rolling windows.
+type Limits struct {
+	// Daily is the number of messages allowed over the last 24 hours. Zero means unlimited.
+	Daily int
+	// PerMinute is the number of messages allowed over the last minute. Zero means unlimited.
+	PerMinute int
+}
+
+// Policy maps provider names to the limits applied to their accounts.
+// Accounts of providers missing from the policy are not limited.
+type Policy map[string]Limits
+
+// Rolling window lengths.
+const (
+	day    = 24 * time.Hour
+	minute = time.Minute
+)
+
+// Account represents a linked provider account along with the name of its provider.
+type Account struct {
+	entity.UserProviderAccount
+	Provider string `db:"provider"`
+}
+
+// Quota represents the sending limits of a provider account and how much of them is left.
+// Remaining counts are null for unlimited windows.
+type Quota struct {
+	AccountID          string     `json:"account_id"`
+	Name               string     `json:"name"`
+	Provider           string     `json:"provider"`
+	DailyLimit         int        `json:"daily_limit"`
+	DailyRemaining     *int       `json:"daily_remaining"`
+	PerMinuteLimit     int        `json:"per_minute_limit"`
+	PerMinuteRemaining *int       `json:"per_minute_remaining"`
+	PausedUntil        *time.Time `json:"paused_until"`
+	// AvailableAt is when the account may send again, or null if it may send now.
+	AvailableAt *time.Time `json:"available_at"`
+}
+
+// Service encapsulates usecase logic for send quotas.
+type Service interface {
+	// Get returns the quota of the provider account owned by the organization.
+	Get(ctx context.Context, orgID, accountID string) (Quota, error)
+	// Query returns the quotas of the provider accounts owned by the organization.
+	Query(ctx context.Context, orgID string) ([]Quota, error)
+	// Acquire reserves a send through the provider account and returns its ID. If the account is paused or has run
+	// out of quota, nothing is reserved and the time at which the account may send again is returned instead.
+	Acquire(ctx context.Context, accountID string, now time.Time) (Account, string, time.Time, error)
+	// Release gives back the send with the given ID reserved by Acquire, which did not go through.
+	Release(ctx context.Context, sendID string) error
+	// Pause stops sending through the provider account until the given time.
+	Pause(ctx context.Context, accountID string, until time.Time) error
+	// Prune removes the sends that no longer count against any quota.
+	Prune(ctx context.Context, now time.Time) error
+}
+
+type service struct {
+	repo          Repository
+	transactional dbcontext.TransactionFunc
+	policy        Policy
+	logger        log.Logger
+}
+
+// NewService creates a new quota service.
+func NewService(repo Repository, transactional dbcontext.TransactionFunc, policy Policy, logger log.Logger) Service {
+	return service{repo, transactional, policy, logger}
+}
+
+// window represents a rolling window and the number of sends it allows.
+type window struct {
+	length time.Duration
+	limit  int
+}
+
+// windows returns the limited rolling windows of the provider.
+func (s service) windows(provider string) []window {
+	limits := s.policy[provider]
+	var windows []window
+	if limits.Daily > 0 {
+		windows = append(windows, window{day, limits.Daily})
+	}
+	if limits.PerMinute > 0 {
+		windows = append(windows, window{minute, limits.PerMinute})
+	}
+	return windows
+}
+
+// Get returns the quota of the provider account owned by the organization.
+func (s service) Get(ctx context.Context, orgID, accountID string) (Quota, error) {
+	account, err := s.repo.GetAccount(ctx, orgID, accountID)
+	if err != nil {
+		return Quota{}, err
+	}
+	return s.quota(ctx, account, time.Now())
+}
+
+// Query returns the quotas of the provider accounts owned by the organization.
+func (s service) Query(ctx context.Context, orgID string) ([]Quota, error) {
+	accounts, err := s.repo.QueryAccounts(ctx, orgID)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	quotas := []Quota{}
+	for _, account := range accounts {
+		quota, err := s.quota(ctx, account, now)
+		if err != nil {
+			return nil, err
+		}
+		quotas = append(quotas, quota)
+	}
+	return quotas, nil
+}
+
+// quota computes the quota of the account at the given time.
+func (s service) quota(ctx context.Context, account Account, now time.Time) (Quota, error) {
+	limits := s.policy[account.Provider]
+	quota := Quota{
+		AccountID:      account.ID,
+		Name:           account.Name,
+		Provider:       account.Provider,
+		DailyLimit:     limits.Daily,
+		PerMinuteLimit: limits.PerMinute,
+	}
+	for _, w := range s.windows(account.Provider) {
+		count, err := s.repo.CountSends(ctx, account.ID, now.Add(-w.length))
+		if err != nil {
+			return Quota{}, err
+		}
+		remaining := w.limit - count
+		if remaining < 0 {
+			remaining = 0
+		}
+		if w.length == day {
+			quota.DailyRemaining = &remaining
+		} else {
+			quota.PerMinuteRemaining = &remaining
+		}
+	}
+	if account.PausedUntil != nil && now.Before(*account.PausedUntil) {
+		quota.PausedUntil = account.PausedUntil
+	}
+	availableAt, err := s.availableAt(ctx, account, now)
+	if err != nil {
+		return Quota{}, err
+	}
+	if !availableAt.IsZero() {
+		quota.AvailableAt = &availableAt
+	}
+	return quota, nil
+}
+
+// availableAt returns the time at which the account may send again,
+// or the zero time if it may send at the given time.
+func (s service) availableAt(ctx context.Context, account Account, now time.Time) (time.Time, error) {
+	var at time.Time
+	if account.PausedUntil != nil && now.Before(*account.PausedUntil) {
+		at = *account.PausedUntil
+	}
+	for _, w := range s.windows(account.Provider) {
+		since := now.Add(-w.length)
+		count, err := s.repo.CountSends(ctx, account.ID, since)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if count < w.limit {
+			continue
+		}
+		// the window frees up when the send that pushed the count to the limit leaves it
+		sentAt, err := s.repo.GetSendTime(ctx, account.ID, since, count-w.limit)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if free := sentAt.Add(w.length); free.After(at) {
+			at = free
+		}
+	}
+	return at, nil
+}
+
+// Acquire reserves a send through the provider account and returns its ID. If the account is paused or has run
+// out of quota, nothing is reserved and the time at which the account may send again is returned instead.
+// The account is locked while its quota is checked so that concurrent workers never overshoot it.
+func (s service) Acquire(ctx context.Context, accountID string, now time.Time) (Account, string, time.Time, error) {
+	var account Account
+	var sendID string
+	var availableAt time.Time
+	err := s.transactional(ctx, func(ctx context.Context) error {
+		var err error
+		if account, err = s.repo.LockAccount(ctx, accountID); err != nil {
+			return err
+		}
+		if availableAt, err = s.availableAt(ctx, account, now); err != nil || !availableAt.IsZero() {
+			return err
+		}
+		sendID = entity.GenerateID()
+		return s.repo.RecordSend(ctx, sendID, accountID, now)
+	})
+	if err != nil || !availableAt.IsZero() {
+		sendID = ""
+	}
+	return account, sendID, availableAt, err
+}
+
+// Release gives back the send with the given ID reserved by Acquire, which did not go through.
+func (s service) Release(ctx context.Context, sendID string) error {
+	return s.repo.DeleteSend(ctx, sendID)
+}
+
+// Pause stops sending through the provider account until the given time.
+func (s service) Pause(ctx context.Context, accountID string, until time.Time) error {
+	s.logger.With(ctx, "account_id", accountID).Infof("provider account paused until %s", until.Format(time.RFC3339))
+	return s.repo.Pause(ctx, accountID, until)
+}
+
+// Prune removes the sends that no longer count against any quota.
+func (s service) Prune(ctx context.Context, now time.Time) error {
+	return s.repo.DeleteSends(ctx, now.Add(-day))
+}
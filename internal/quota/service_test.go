@@ -0,0 +1,257 @@
+package quota
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/garaekz/gonvelope/internal/entity"
+	"github.com/garaekz/gonvelope/pkg/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testPolicy = Policy{"google": {Daily: 3, PerMinute: 2}}
+
+func Test_service_Acquire(t *testing.T) {
+	logger, _ := log.NewForTest()
+	repo := newMockRepository()
+	s := NewService(repo, mockTransactional, testPolicy, logger)
+	ctx := context.Background()
+	now := time.Now()
+
+	// the per-minute quota is reached after two sends
+	for i := 0; i < 2; i++ {
+		account, _, availableAt, err := s.Acquire(ctx, "gmail1", now)
+		require.Nil(t, err)
+		assert.True(t, availableAt.IsZero())
+		assert.Equal(t, "google", account.Provider)
+	}
+	_, _, availableAt, err := s.Acquire(ctx, "gmail1", now.Add(10*time.Second))
+	require.Nil(t, err)
+	assert.Equal(t, now.Add(time.Minute), availableAt)
+	assert.Len(t, repo.sends["gmail1"], 2, "nothing is reserved when the quota is reached")
+
+	// then the daily one after three
+	_, _, availableAt, _ = s.Acquire(ctx, "gmail1", now.Add(time.Minute))
+	assert.True(t, availableAt.IsZero())
+	_, _, availableAt, _ = s.Acquire(ctx, "gmail1", now.Add(2*time.Minute))
+	assert.Equal(t, now.Add(24*time.Hour), availableAt)
+
+	// accounts of providers without limits are never throttled
+	for i := 0; i < 5; i++ {
+		_, _, availableAt, _ = s.Acquire(ctx, "outlook1", now)
+		assert.True(t, availableAt.IsZero())
+	}
+
+	_, _, _, err = s.Acquire(ctx, "unknown", now)
+	assert.Equal(t, sql.ErrNoRows, err)
+}
+
+func Test_service_Release(t *testing.T) {
+	logger, _ := log.NewForTest()
+	repo := newMockRepository()
+	s := NewService(repo, mockTransactional, testPolicy, logger)
+	ctx := context.Background()
+	now := time.Now()
+
+	_, _, _, _ = s.Acquire(ctx, "gmail1", now)
+	_, sendID, _, err := s.Acquire(ctx, "gmail1", now)
+	require.Nil(t, err)
+	assert.NotEmpty(t, sendID)
+	require.Nil(t, s.Release(ctx, sendID))
+	assert.Len(t, repo.sends["gmail1"], 1)
+	assert.NotContains(t, repo.ids, sendID)
+
+	// the released send no longer counts against the quota
+	_, _, availableAt, err := s.Acquire(ctx, "gmail1", now)
+	require.Nil(t, err)
+	assert.True(t, availableAt.IsZero())
+
+	// nothing is reserved, so there is nothing to release, once the quota is reached
+	_, sendID, availableAt, _ = s.Acquire(ctx, "gmail1", now)
+	assert.False(t, availableAt.IsZero())
+	assert.Empty(t, sendID)
+}
+
+func Test_service_Pause(t *testing.T) {
+	logger, _ := log.NewForTest()
+	repo := newMockRepository()
+	s := NewService(repo, mockTransactional, testPolicy, logger)
+	ctx := context.Background()
+	now := time.Now()
+
+	until := now.Add(5 * time.Minute)
+	require.Nil(t, s.Pause(ctx, "outlook1", until))
+	_, _, availableAt, err := s.Acquire(ctx, "outlook1", now)
+	require.Nil(t, err)
+	assert.Equal(t, until, availableAt)
+	_, _, availableAt, _ = s.Acquire(ctx, "outlook1", until)
+	assert.True(t, availableAt.IsZero())
+}
+
+func Test_service_Get(t *testing.T) {
+	logger, _ := log.NewForTest()
+	repo := newMockRepository()
+	s := NewService(repo, mockTransactional, testPolicy, logger)
+	ctx := context.Background()
+
+	_, _, _, _ = s.Acquire(ctx, "gmail1", time.Now())
+	quota, err := s.Get(ctx, "org1", "gmail1")
+	require.Nil(t, err)
+	assert.Equal(t, 3, quota.DailyLimit)
+	assert.Equal(t, 2, *quota.DailyRemaining)
+	assert.Equal(t, 1, *quota.PerMinuteRemaining)
+	assert.Nil(t, quota.AvailableAt)
+
+	_, _, _, _ = s.Acquire(ctx, "gmail1", time.Now())
+	quota, _ = s.Get(ctx, "org1", "gmail1")
+	assert.Equal(t, 0, *quota.PerMinuteRemaining)
+	assert.NotNil(t, quota.AvailableAt)
+
+	quota, _ = s.Get(ctx, "org1", "outlook1")
+	assert.Nil(t, quota.DailyRemaining)
+
+	_, err = s.Get(ctx, "org2", "gmail1")
+	assert.Equal(t, sql.ErrNoRows, err)
+
+	quotas, err := s.Query(ctx, "org1")
+	require.Nil(t, err)
+	assert.Len(t, quotas, 2)
+}
+
+func Test_service_Prune(t *testing.T) {
+	logger, _ := log.NewForTest()
+	repo := newMockRepository()
+	s := NewService(repo, mockTransactional, testPolicy, logger)
+	ctx := context.Background()
+	now := time.Now()
+
+	_, _, _, _ = s.Acquire(ctx, "outlook1", now.Add(-25*time.Hour))
+	_, _, _, _ = s.Acquire(ctx, "outlook1", now)
+	require.Nil(t, s.Prune(ctx, now))
+	assert.Len(t, repo.sends["outlook1"], 1)
+}
+
+func mockTransactional(ctx context.Context, f func(ctx context.Context) error) error {
+	return f(ctx)
+}
+
+type mockRepository struct {
+	accounts []Account
+	sends    map[string][]time.Time
+	// ids maps the IDs of the sends to their accounts and times
+	ids map[string]mockSend
+}
+
+type mockSend struct {
+	accountID string
+	at        time.Time
+}
+
+func newMockRepository() *mockRepository {
+	return &mockRepository{
+		accounts: []Account{
+			{entity.UserProviderAccount{ID: "gmail1", OrgID: "org1", Name: "Sales"}, "google"},
+			{entity.UserProviderAccount{ID: "outlook1", OrgID: "org1", Name: "Support"}, "outlook"},
+		},
+		sends: map[string][]time.Time{},
+		ids:   map[string]mockSend{},
+	}
+}
+
+func (m *mockRepository) GetAccount(_ context.Context, orgID, id string) (Account, error) {
+	for _, account := range m.accounts {
+		if account.ID == id && account.OrgID == orgID {
+			return account, nil
+		}
+	}
+	return Account{}, sql.ErrNoRows
+}
+
+func (m *mockRepository) QueryAccounts(_ context.Context, orgID string) ([]Account, error) {
+	var accounts []Account
+	for _, account := range m.accounts {
+		if account.OrgID == orgID {
+			accounts = append(accounts, account)
+		}
+	}
+	return accounts, nil
+}
+
+func (m *mockRepository) LockAccount(_ context.Context, id string) (Account, error) {
+	for _, account := range m.accounts {
+		if account.ID == id {
+			return account, nil
+		}
+	}
+	return Account{}, sql.ErrNoRows
+}
+
+func (m *mockRepository) Pause(_ context.Context, id string, until time.Time) error {
+	for i, account := range m.accounts {
+		if account.ID == id {
+			m.accounts[i].PausedUntil = &until
+		}
+	}
+	return nil
+}
+
+func (m *mockRepository) since(accountID string, since time.Time) []time.Time {
+	var times []time.Time
+	for _, at := range m.sends[accountID] {
+		if at.After(since) {
+			times = append(times, at)
+		}
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+	return times
+}
+
+func (m *mockRepository) CountSends(_ context.Context, accountID string, since time.Time) (int, error) {
+	return len(m.since(accountID, since)), nil
+}
+
+func (m *mockRepository) GetSendTime(_ context.Context, accountID string, since time.Time, offset int) (time.Time, error) {
+	times := m.since(accountID, since)
+	if offset >= len(times) {
+		return time.Time{}, sql.ErrNoRows
+	}
+	return times[offset], nil
+}
+
+func (m *mockRepository) RecordSend(_ context.Context, id, accountID string, at time.Time) error {
+	m.sends[accountID] = append(m.sends[accountID], at)
+	m.ids[id] = mockSend{accountID, at}
+	return nil
+}
+
+func (m *mockRepository) DeleteSend(_ context.Context, id string) error {
+	send, ok := m.ids[id]
+	if !ok {
+		return nil
+	}
+	delete(m.ids, id)
+	for i, at := range m.sends[send.accountID] {
+		if at.Equal(send.at) {
+			m.sends[send.accountID] = append(m.sends[send.accountID][:i], m.sends[send.accountID][i+1:]...)
+			return nil
+		}
+	}
+	return nil
+}
+
+func (m *mockRepository) DeleteSends(_ context.Context, before time.Time) error {
+	for id, times := range m.sends {
+		var kept []time.Time
+		for _, at := range times {
+			if at.After(before) {
+				kept = append(kept, at)
+			}
+		}
+		m.sends[id] = kept
+	}
+	return nil
+}
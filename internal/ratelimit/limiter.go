@@ -0,0 +1,142 @@
+// Package ratelimit provides a token bucket rate limiting middleware.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/garaekz/gonvelope/internal/auth"
+	"github.com/garaekz/gonvelope/internal/errors"
+	"github.com/garaekz/gonvelope/pkg/clientip"
+	"github.com/garaekz/gonvelope/pkg/log"
+	routing "github.com/garaekz/ozzo-routing"
+)
+
+// Limit represents the size and refill rate of a token bucket. A client may send up to Burst requests
+// at once, after which the bucket refills at Requests per Period.
+type Limit struct {
+	// Requests is the number of requests allowed per period. Zero disables the limit.
+	Requests int
+	// Period is the time it takes to refill Requests tokens.
+	Period time.Duration
+	// Burst is the capacity of the bucket. Defaults to Requests.
+	Burst int
+}
+
+// capacity returns the maximum number of tokens the bucket holds.
+func (l Limit) capacity() float64 {
+	if l.Burst > 0 {
+		return float64(l.Burst)
+	}
+	return float64(l.Requests)
+}
+
+// rate returns the number of tokens added to the bucket per second.
+func (l Limit) rate() float64 {
+	return float64(l.Requests) / l.Period.Seconds()
+}
+
+// Result represents the outcome of taking a token from a bucket.
+type Result struct {
+	// Allowed tells whether a token was available.
+	Allowed bool
+	// Remaining is the number of whole tokens left in the bucket.
+	Remaining int
+	// Reset is the time until the bucket is full again.
+	Reset time.Duration
+	// RetryAfter is the time until a token becomes available. It is zero when the request is allowed.
+	RetryAfter time.Duration
+}
+
+// Store keeps the token buckets.
+type Store interface {
+	// Take takes a token from the bucket identified by key, creating a full bucket if there is none.
+	Take(ctx context.Context, key string, limit Limit, now time.Time) (Result, error)
+}
+
+// bucket represents the state of a token bucket at the time it was last updated.
+type bucket struct {
+	tokens    float64
+	updatedAt time.Time
+}
+
+// fullBucket returns a bucket holding as many tokens as the limit allows.
+func fullBucket(limit Limit, now time.Time) bucket {
+	return bucket{limit.capacity(), now}
+}
+
+// take refills the bucket for the time elapsed since its last update and takes a token if one is available.
+func (b bucket) take(limit Limit, now time.Time) (bucket, Result) {
+	capacity, rate := limit.capacity(), limit.rate()
+	tokens := b.tokens
+	if elapsed := now.Sub(b.updatedAt).Seconds(); elapsed > 0 {
+		tokens = math.Min(capacity, tokens+elapsed*rate)
+	}
+
+	result := Result{Allowed: tokens >= 1}
+	if result.Allowed {
+		tokens--
+	} else {
+		result.RetryAfter = seconds((1 - tokens) / rate)
+	}
+	result.Remaining = int(math.Floor(tokens))
+	result.Reset = seconds((capacity - tokens) / rate)
+	return bucket{tokens, now}, result
+}
+
+// seconds converts a number of seconds into a duration.
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// Handler returns a middleware that limits the requests each client sends to the routes of a group.
+// The name identifies the group so that each group has its own buckets. Clients are identified by
+// API key, then by authenticated user and finally by IP address, so the middleware should come after
+// the authentication middleware of the group. The RateLimit-* headers describe the state of the bucket
+// of the client. Requests are let through if the store fails, and a zero limit disables the middleware.
+func Handler(store Store, name string, limit Limit, logger log.Logger) routing.Handler {
+	policy := fmt.Sprintf("%d;w=%d", int(limit.capacity()), int(limit.Period.Seconds()))
+	return func(c *routing.Context) error {
+		if limit.Requests <= 0 {
+			return nil
+		}
+		ctx := c.Request.Context()
+		result, err := store.Take(ctx, name+":"+clientKey(c.Request), limit, time.Now())
+		if err != nil {
+			logger.With(ctx).Errorf("failed to apply rate limit: %v", err)
+			return nil
+		}
+
+		header := c.Response.Header()
+		header.Set("RateLimit-Policy", policy)
+		header.Set("RateLimit-Limit", strconv.Itoa(int(limit.capacity())))
+		header.Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		header.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.Reset)))
+		if !result.Allowed {
+			header.Set("Retry-After", strconv.Itoa(ceilSeconds(result.RetryAfter)))
+			return errors.TooManyRequests("")
+		}
+		return nil
+	}
+}
+
+// clientKey returns the key identifying the client that sent the request.
+func clientKey(req *http.Request) string {
+	ctx := req.Context()
+	if id := auth.CurrentAPIKey(ctx); id != "" {
+		return "key:" + id
+	}
+	if identity := auth.CurrentUser(ctx); identity != nil {
+		return "user:" + identity.GetID()
+	}
+	return "ip:" + clientip.Get(req)
+}
+
+// ceilSeconds returns the duration as a whole number of seconds, rounded up.
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,132 @@
+package ratelimit
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/garaekz/gonvelope/internal/auth"
+	"github.com/garaekz/gonvelope/internal/errors"
+	"github.com/garaekz/gonvelope/internal/test"
+	"github.com/garaekz/gonvelope/pkg/log"
+	routing "github.com/garaekz/ozzo-routing"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_bucket_take(t *testing.T) {
+	limit := Limit{Requests: 2, Period: 2 * time.Second, Burst: 3}
+	now := time.Now()
+	b := fullBucket(limit, now)
+
+	var result Result
+	for i := 2; i >= 0; i-- {
+		b, result = b.take(limit, now)
+		assert.True(t, result.Allowed)
+		assert.Equal(t, i, result.Remaining)
+	}
+	assert.Equal(t, 3*time.Second, result.Reset)
+
+	b, result = b.take(limit, now)
+	assert.False(t, result.Allowed)
+	assert.Equal(t, 0, result.Remaining)
+	assert.Equal(t, time.Second, result.RetryAfter)
+
+	// the bucket refills at one token per second
+	b, result = b.take(limit, now.Add(1500*time.Millisecond))
+	assert.True(t, result.Allowed)
+	assert.Equal(t, 0, result.Remaining)
+
+	// and never holds more than its capacity
+	_, result = b.take(limit, now.Add(time.Hour))
+	assert.True(t, result.Allowed)
+	assert.Equal(t, 2, result.Remaining)
+}
+
+func Test_memoryStore(t *testing.T) {
+	s := NewMemoryStore()
+	ctx := context.Background()
+	limit := Limit{Requests: 1, Period: time.Minute}
+	now := time.Now()
+
+	result, err := s.Take(ctx, "a", limit, now)
+	assert.Nil(t, err)
+	assert.True(t, result.Allowed)
+	result, _ = s.Take(ctx, "a", limit, now)
+	assert.False(t, result.Allowed)
+	assert.Equal(t, time.Minute, result.RetryAfter)
+
+	// buckets are independent
+	result, _ = s.Take(ctx, "b", limit, now)
+	assert.True(t, result.Allowed)
+
+	// full buckets are dropped
+	result, _ = s.Take(ctx, "a", limit, now.Add(2*time.Minute))
+	assert.True(t, result.Allowed)
+	assert.Len(t, s.(*memoryStore).buckets, 1)
+}
+
+func TestHandler(t *testing.T) {
+	logger, _ := log.NewForTest()
+	router := test.MockRouter(logger)
+	store := NewMemoryStore()
+	limit := Limit{Requests: 2, Period: time.Minute}
+
+	ok := func(c *routing.Context) error { return c.Write("ok") }
+	router.Get("/public", Handler(store, "public", limit, logger), ok)
+	router.Get("/private", auth.MockAuthHandler, Handler(store, "private", limit, logger), ok)
+	router.Get("/unlimited", Handler(store, "unlimited", Limit{}, logger), ok)
+	router.Get("/failing", Handler(failingStore{}, "failing", limit, logger), ok)
+
+	for i := 0; i < 2; i++ {
+		test.Endpoint(t, router, test.APITestCase{Name: "public", Method: "GET", URL: "/public", WantStatus: http.StatusOK})
+	}
+	test.Endpoint(t, router, test.APITestCase{Name: "public limited", Method: "GET", URL: "/public", WantStatus: http.StatusTooManyRequests, WantResponse: "*too many requests*"})
+
+	// the user has its own bucket
+	test.Endpoint(t, router, test.APITestCase{Name: "private", Method: "GET", URL: "/private", Header: auth.MockAuthHeader(), WantStatus: http.StatusOK})
+	test.Endpoint(t, router, test.APITestCase{Name: "unauthenticated", Method: "GET", URL: "/private", WantStatus: http.StatusUnauthorized})
+
+	for i := 0; i < 3; i++ {
+		test.Endpoint(t, router, test.APITestCase{Name: "unlimited", Method: "GET", URL: "/unlimited", WantStatus: http.StatusOK})
+		test.Endpoint(t, router, test.APITestCase{Name: "store failure", Method: "GET", URL: "/failing", WantStatus: http.StatusOK})
+	}
+}
+
+func TestHandler_headers(t *testing.T) {
+	logger, _ := log.NewForTest()
+	handler := Handler(NewMemoryStore(), "api", Limit{Requests: 1, Period: time.Minute}, logger)
+
+	req, _ := http.NewRequest("GET", "http://example.com", nil)
+	ctx, res := test.MockRoutingContext(req)
+	assert.Nil(t, handler(ctx))
+	assert.Equal(t, "1;w=60", res.Header().Get("RateLimit-Policy"))
+	assert.Equal(t, "1", res.Header().Get("RateLimit-Limit"))
+	assert.Equal(t, "0", res.Header().Get("RateLimit-Remaining"))
+	assert.Equal(t, "60", res.Header().Get("RateLimit-Reset"))
+	assert.Empty(t, res.Header().Get("Retry-After"))
+
+	ctx, res = test.MockRoutingContext(req)
+	err := handler(ctx)
+	assert.Equal(t, http.StatusTooManyRequests, err.(errors.ErrorResponse).StatusCode())
+	assert.Equal(t, "60", res.Header().Get("Retry-After"))
+}
+
+func Test_clientKey(t *testing.T) {
+	req, _ := http.NewRequest("GET", "http://example.com", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	assert.Equal(t, "ip:10.0.0.1", clientKey(req))
+	// rotating forwarding headers does not make a new client
+	req.Header.Set("X-Forwarded-For", "198.51.100.1")
+	assert.Equal(t, "ip:10.0.0.1", clientKey(req))
+
+	ctx := auth.WithUser(req.Context(), "100", "test")
+	assert.Equal(t, "user:100", clientKey(req.WithContext(ctx)))
+}
+
+type failingStore struct{}
+
+func (failingStore) Take(context.Context, string, Limit, time.Time) (Result, error) {
+	return Result{}, sql.ErrConnDone
+}
@@ -0,0 +1,52 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often stores drop the buckets that are full again.
+const sweepInterval = time.Minute
+
+// memoryBucket is a bucket kept in memory along with the time it becomes full again.
+type memoryBucket struct {
+	bucket
+	fullAt time.Time
+}
+
+// memoryStore keeps the token buckets in the memory of the current process.
+type memoryStore struct {
+	mu        sync.Mutex
+	buckets   map[string]memoryBucket
+	lastSweep time.Time
+}
+
+// NewMemoryStore creates a store that keeps the token buckets in memory.
+// Limits are not shared between instances, use NewPostgresStore when running several of them.
+func NewMemoryStore() Store {
+	return &memoryStore{buckets: map[string]memoryBucket{}}
+}
+
+// Take takes a token from the bucket identified by key, creating a full bucket if there is none.
+func (s *memoryStore) Take(_ context.Context, key string, limit Limit, now time.Time) (Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b, ok := s.buckets[key]
+	if !ok || !now.Before(b.fullAt) {
+		b.bucket = fullBucket(limit, now)
+	}
+	next, result := b.take(limit, now)
+	s.buckets[key] = memoryBucket{next, now.Add(result.Reset)}
+
+	if now.Sub(s.lastSweep) >= sweepInterval {
+		for k, b := range s.buckets {
+			if !now.Before(b.fullAt) {
+				delete(s.buckets, k)
+			}
+		}
+		s.lastSweep = now
+	}
+	return result, nil
+}
@@ -0,0 +1,82 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/garaekz/gonvelope/pkg/dbcontext"
+	dbx "github.com/go-ozzo/ozzo-dbx"
+)
+
+// postgresStore keeps the token buckets in the rate_limit_buckets table so that every instance shares them.
+type postgresStore struct {
+	db        *dbcontext.DB
+	mu        sync.Mutex
+	lastSweep time.Time
+}
+
+// NewPostgresStore creates a store that keeps the token buckets in the database.
+func NewPostgresStore(db *dbcontext.DB) Store {
+	return &postgresStore{db: db}
+}
+
+// Take takes a token from the bucket identified by key, creating a full bucket if there is none.
+// The row of the bucket is locked for the duration of the update so that concurrent requests
+// from several instances never take the same token.
+func (s *postgresStore) Take(ctx context.Context, key string, limit Limit, now time.Time) (Result, error) {
+	now = now.UTC()
+	var result Result
+	err := s.db.Transactional(ctx, func(ctx context.Context) error {
+		full := fullBucket(limit, now)
+		_, err := s.db.With(ctx).NewQuery(`
+			INSERT INTO rate_limit_buckets (key, tokens, updated_at, full_at) VALUES ({:key}, {:tokens}, {:now}, {:now})
+			ON CONFLICT (key) DO NOTHING`).
+			Bind(dbx.Params{"key": key, "tokens": full.tokens, "now": now}).Execute()
+		if err != nil {
+			return err
+		}
+
+		var row struct {
+			Tokens    float64
+			UpdatedAt time.Time
+			FullAt    time.Time
+		}
+		err = s.db.With(ctx).NewQuery(`SELECT tokens, updated_at, full_at FROM rate_limit_buckets WHERE key = {:key} FOR UPDATE`).
+			Bind(dbx.Params{"key": key}).One(&row)
+		if err != nil {
+			return err
+		}
+
+		b := bucket{row.Tokens, row.UpdatedAt}
+		if !now.Before(row.FullAt) {
+			b = full
+		}
+		next, r := b.take(limit, now)
+		result = r
+		_, err = s.db.With(ctx).Update("rate_limit_buckets", dbx.Params{
+			"tokens":     next.tokens,
+			"updated_at": next.updatedAt,
+			"full_at":    now.Add(r.Reset),
+		}, dbx.HashExp{"key": key}).Execute()
+		return err
+	})
+	if err != nil {
+		return Result{}, err
+	}
+	return result, s.sweep(ctx, now)
+}
+
+// sweep deletes the buckets that are full again, at most once per sweepInterval.
+func (s *postgresStore) sweep(ctx context.Context, now time.Time) error {
+	s.mu.Lock()
+	if now.Sub(s.lastSweep) < sweepInterval {
+		s.mu.Unlock()
+		return nil
+	}
+	s.lastSweep = now
+	s.mu.Unlock()
+
+	_, err := s.db.With(ctx).Delete("rate_limit_buckets", dbx.NewExp("full_at <= {:now}", dbx.Params{"now": now})).Execute()
+	return err
+}
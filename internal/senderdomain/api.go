@@ -0,0 +1,104 @@
+package senderdomain
+
+import (
+	"net/http"
+
+	"github.com/garaekz/gonvelope/internal/auth"
+	"github.com/garaekz/gonvelope/internal/entity"
+	"github.com/garaekz/gonvelope/internal/errors"
+	"github.com/garaekz/gonvelope/pkg/log"
+	"github.com/garaekz/gonvelope/pkg/pagination"
+	routing "github.com/garaekz/ozzo-routing"
+)
+
+// RegisterHandlers sets up the routing of the HTTP handlers.
+// Sender domains belong to the organization made active by orgHandler.
+func RegisterHandlers(r *routing.RouteGroup, service Service, authHandler, orgHandler routing.Handler, logger log.Logger) {
+	res := resource{service, logger}
+
+	r.Use(authHandler, orgHandler)
+
+	r.Get("domains", auth.Require(auth.ScopeDomainsRead), res.query)
+	r.Get("domains/<id>", auth.Require(auth.ScopeDomainsRead), res.get)
+	r.Get("domains/<id>/dns", auth.Require(auth.ScopeDomainsRead), res.dns)
+	r.Post("domains", auth.Require(auth.ScopeDomainsWrite), res.create)
+	r.Post("domains/<id>/verify", auth.Require(auth.ScopeDomainsWrite), res.verify)
+	r.Delete("domains/<id>", auth.Require(auth.ScopeDomainsWrite), res.delete)
+}
+
+type resource struct {
+	service Service
+	logger  log.Logger
+}
+
+func (r resource) get(c *routing.Context) error {
+	domain, err := r.service.Get(c.Request.Context(), currentMembership(c).OrgID, c.Param("id"))
+	if err != nil {
+		return err
+	}
+	return c.Write(domain)
+}
+
+func (r resource) query(c *routing.Context) error {
+	ctx := c.Request.Context()
+	orgID := currentMembership(c).OrgID
+	count, err := r.service.Count(ctx, orgID)
+	if err != nil {
+		return err
+	}
+	pages := pagination.NewFromRequest(c.Request, count)
+	domains, err := r.service.Query(ctx, orgID, pages.Offset(), pages.Limit())
+	if err != nil {
+		return err
+	}
+	pages.Items = domains
+	return c.Write(pages)
+}
+
+// dns writes the DNS TXT record the organization must publish to prove it owns the domain.
+func (r resource) dns(c *routing.Context) error {
+	record, err := r.service.DNSRecord(c.Request.Context(), currentMembership(c).OrgID, c.Param("id"))
+	if err != nil {
+		return err
+	}
+	return c.Write(record)
+}
+
+func (r resource) create(c *routing.Context) error {
+	var input DomainRequest
+	if err := c.Read(&input); err != nil {
+		r.logger.With(c.Request.Context()).Info(err)
+		return errors.BadRequest("")
+	}
+	membership := currentMembership(c)
+	domain, err := r.service.Create(c.Request.Context(), membership.OrgID, membership.UserID, input)
+	if err != nil {
+		return err
+	}
+	return c.WriteWithStatus(domain, http.StatusCreated)
+}
+
+// verify checks the DNS records of the domain and writes the outcome of each check.
+func (r resource) verify(c *routing.Context) error {
+	membership := currentMembership(c)
+	report, err := r.service.Verify(c.Request.Context(), membership.OrgID, membership.UserID, c.Param("id"))
+	if err != nil {
+		return err
+	}
+	return c.Write(report)
+}
+
+func (r resource) delete(c *routing.Context) error {
+	membership := currentMembership(c)
+	domain, err := r.service.Delete(c.Request.Context(), membership.OrgID, membership.UserID, c.Param("id"))
+	if err != nil {
+		return err
+	}
+	return c.Write(domain)
+}
+
+// currentMembership returns the membership of the current user in the active organization.
+func currentMembership(c *routing.Context) entity.Membership {
+	membership, _ := auth.CurrentMembership(c.Request.Context())
+	return membership
+}
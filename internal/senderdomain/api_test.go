@@ -0,0 +1,45 @@
+package senderdomain
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/garaekz/gonvelope/internal/auth"
+	"github.com/garaekz/gonvelope/internal/entity"
+	"github.com/garaekz/gonvelope/internal/org"
+	"github.com/garaekz/gonvelope/internal/test"
+	"github.com/garaekz/gonvelope/pkg/log"
+)
+
+func TestAPI(t *testing.T) {
+	logger, _ := log.NewForTest()
+	router := test.MockRouter(logger)
+	repo := &mockRepository{items: []entity.SenderDomain{
+		{ID: "d1", OrgID: "100", Domain: "example.com", Token: "t1", SPF: entity.AuthUnknown, DKIM: entity.AuthUnknown, DMARC: entity.AuthUnknown, CreatedAt: time.Now()},
+		{ID: "d2", OrgID: "org1", Domain: "example.org", Token: "t2", CreatedAt: time.Now()},
+	}}
+	resolver := mockResolver{"example.com": {"gonvelope-verification=t1", "v=spf1 -all"}}
+	RegisterHandlers(router.Group("/"), NewService(repo, mockTransactional, resolver, mockKeyRecord, &mockRecorder{}, logger), auth.MockAuthHandler, org.MockHandler, logger)
+	header := auth.MockAuthHeader()
+
+	tests := []test.APITestCase{
+		{Name: "get", Method: "GET", URL: "/domains/d1", Header: header, WantStatus: http.StatusOK, WantResponse: `*"domain":"example.com"*`},
+		{Name: "get other org", Method: "GET", URL: "/domains/d2", Header: header, WantStatus: http.StatusNotFound},
+		{Name: "query", Method: "GET", URL: "/domains", Header: header, WantStatus: http.StatusOK, WantResponse: `*"total_count":1*`},
+		{Name: "dns", Method: "GET", URL: "/domains/d1/dns", Header: header, WantStatus: http.StatusOK, WantResponse: `{"name":"example.com","type":"TXT","value":"gonvelope-verification=t1"}`},
+		{Name: "verify", Method: "POST", URL: "/domains/d1/verify", Header: header, WantStatus: http.StatusOK, WantResponse: `*"ownership":{"status":"pass","record":"gonvelope-verification=t1"},"spf":{"status":"pass","record":"v=spf1 -all"}*`},
+		{Name: "editor verify", Method: "POST", URL: "/domains/d1/verify", Header: org.MockHeader(entity.RoleEditor), WantStatus: http.StatusForbidden},
+		{Name: "verify other org", Method: "POST", URL: "/domains/d2/verify", Header: header, WantStatus: http.StatusNotFound},
+		{Name: "create", Method: "POST", URL: "/domains", Body: `{"domain":"Mail.Example.com"}`, Header: header, WantStatus: http.StatusCreated, WantResponse: `*"domain":"mail.example.com"*`},
+		{Name: "create duplicate", Method: "POST", URL: "/domains", Body: `{"domain":"example.com"}`, Header: header, WantStatus: http.StatusConflict},
+		{Name: "create invalid", Method: "POST", URL: "/domains", Body: `{"domain":"example"}`, Header: header, WantStatus: http.StatusBadRequest},
+		{Name: "create malformed", Method: "POST", URL: "/domains", Body: `"domain"`, Header: header, WantStatus: http.StatusBadRequest},
+		{Name: "delete", Method: "DELETE", URL: "/domains/d1", Header: header, WantStatus: http.StatusOK},
+		{Name: "delete missing", Method: "DELETE", URL: "/domains/d1", Header: header, WantStatus: http.StatusNotFound},
+		{Name: "unauthorized", Method: "GET", URL: "/domains/d1", WantStatus: http.StatusUnauthorized},
+	}
+	for _, tc := range tests {
+		test.Endpoint(t, router, tc)
+	}
+}
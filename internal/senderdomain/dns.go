@@ -0,0 +1,142 @@
+package senderdomain
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+
+	"github.com/garaekz/gonvelope/internal/dkimkey"
+	"github.com/garaekz/gonvelope/internal/entity"
+)
+
+// verificationPrefix prefixes the token in the TXT record proving the ownership of a domain.
+const verificationPrefix = "gonvelope-verification="
+
+// Resolver looks up DNS TXT records. *net.Resolver implements it.
+type Resolver interface {
+	LookupTXT(ctx context.Context, name string) ([]string, error)
+}
+
+// Check is the outcome of checking one of the DNS records of a sender domain.
+type Check struct {
+	// Status is either pass, fail or missing.
+	Status string `json:"status"`
+	// Record is the record found, if any.
+	Record string `json:"record,omitempty"`
+	// Reason explains why the check did not pass.
+	Reason string `json:"reason,omitempty"`
+}
+
+// lookupTXT returns the TXT records of the name, or none if the name does not exist.
+func lookupTXT(ctx context.Context, resolver Resolver, name string) ([]string, error) {
+	records, err := resolver.LookupTXT(ctx, name)
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+		return nil, nil
+	}
+	return records, err
+}
+
+// checkOwnership checks that the records of the domain include the verification token.
+func checkOwnership(records []string, token string) Check {
+	want := verificationPrefix + token
+	for _, record := range records {
+		if strings.TrimSpace(record) == want {
+			return Check{Status: entity.AuthPass, Record: want}
+		}
+	}
+	return Check{Status: entity.AuthMissing, Reason: "no TXT record of the domain holds the verification token"}
+}
+
+// checkSPF checks that the records of the domain include exactly one SPF policy, and that it does not
+// authorize every server to send on behalf of the domain.
+func checkSPF(records []string) Check {
+	spf := filterRecords(records, "v=spf1")
+	switch {
+	case len(spf) == 0:
+		return Check{Status: entity.AuthMissing, Reason: "the domain publishes no SPF record"}
+	case len(spf) > 1:
+		return Check{Status: entity.AuthFail, Record: strings.Join(spf, "\n"), Reason: "the domain publishes several SPF records, which receivers treat as an error"}
+	}
+	for _, mechanism := range strings.Fields(spf[0]) {
+		if mechanism == "+all" || mechanism == "all" {
+			return Check{Status: entity.AuthFail, Record: spf[0], Reason: "the SPF record authorizes any server to send on behalf of the domain"}
+		}
+	}
+	return Check{Status: entity.AuthPass, Record: spf[0]}
+}
+
+// checkDKIM checks that the TXT records published under the name of the DKIM key of the organization
+// hold its public key. Messages are signed with the domain they are sent from, so a published key makes
+// their signature aligned.
+func checkDKIM(records []string, key dkimkey.DNSRecord) Check {
+	want := recordTags(key.Value)["p"]
+	for _, record := range records {
+		if recordTags(record)["p"] == want {
+			return Check{Status: entity.AuthPass, Record: record}
+		}
+	}
+	if len(records) == 0 {
+		return Check{Status: entity.AuthMissing, Reason: "the DKIM key is not published at " + key.Name}
+	}
+	return Check{Status: entity.AuthFail, Record: strings.Join(records, "\n"), Reason: "the record published at " + key.Name + " does not hold the DKIM key"}
+}
+
+// checkDMARC checks that the records include exactly one valid DMARC policy and that the messages of the
+// domain pass it. Messages sent through mailbox providers use the envelope sender of the provider, so SPF
+// never aligns with the From domain and only an aligned DKIM signature passes DMARC.
+func checkDMARC(records []string, dkim Check) Check {
+	dmarc := filterRecords(records, "v=DMARC1")
+	switch {
+	case len(dmarc) == 0:
+		return Check{Status: entity.AuthMissing, Reason: "the domain publishes no DMARC record"}
+	case len(dmarc) > 1:
+		return Check{Status: entity.AuthFail, Record: strings.Join(dmarc, "\n"), Reason: "the domain publishes several DMARC records, which receivers ignore"}
+	}
+	switch strings.ToLower(recordTags(dmarc[0])["p"]) {
+	case "none", "quarantine", "reject":
+	default:
+		return Check{Status: entity.AuthFail, Record: dmarc[0], Reason: "the DMARC record has no valid policy"}
+	}
+	if dkim.Status != entity.AuthPass {
+		return Check{Status: entity.AuthFail, Record: dmarc[0], Reason: "the messages of the domain carry no aligned DKIM signature"}
+	}
+	return Check{Status: entity.AuthPass, Record: dmarc[0]}
+}
+
+// dmarcNames returns the names the DMARC policy of the domain may be published at: under the domain itself,
+// then under its parents, the closest one applying to subdomains without a policy of their own.
+func dmarcNames(domain string) []string {
+	var names []string
+	for strings.Contains(domain, ".") {
+		names = append(names, "_dmarc."+domain)
+		domain = domain[strings.Index(domain, ".")+1:]
+	}
+	return names
+}
+
+// filterRecords returns the records of the given version, such as v=spf1.
+func filterRecords(records []string, version string) []string {
+	var filtered []string
+	for _, record := range records {
+		record = strings.TrimSpace(record)
+		if len(record) >= len(version) && strings.EqualFold(record[:len(version)], version) &&
+			(len(record) == len(version) || record[len(version)] == ' ' || record[len(version)] == ';') {
+			filtered = append(filtered, record)
+		}
+	}
+	return filtered
+}
+
+// recordTags returns the tags of a DKIM or DMARC record, whose values have their whitespace removed.
+func recordTags(record string) map[string]string {
+	tags := map[string]string{}
+	for _, part := range strings.Split(record, ";") {
+		name, value, ok := strings.Cut(part, "=")
+		if ok {
+			tags[strings.ToLower(strings.TrimSpace(name))] = strings.Join(strings.Fields(value), "")
+		}
+	}
+	return tags
+}
@@ -0,0 +1,103 @@
+package senderdomain
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/garaekz/gonvelope/internal/dkimkey"
+	"github.com/garaekz/gonvelope/internal/entity"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_lookupTXT(t *testing.T) {
+	resolver := mockResolver{"example.com": {"v=spf1 -all"}}
+	records, err := lookupTXT(context.Background(), resolver, "example.com")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"v=spf1 -all"}, records)
+	// missing names have no records
+	records, err = lookupTXT(context.Background(), resolver, "example.org")
+	assert.Nil(t, err)
+	assert.Empty(t, records)
+	_, err = lookupTXT(context.Background(), resolver, "timeout.example.com")
+	assert.NotNil(t, err)
+}
+
+func Test_checkOwnership(t *testing.T) {
+	assert.Equal(t, entity.AuthPass, checkOwnership([]string{"v=spf1 -all", " gonvelope-verification=t1 "}, "t1").Status)
+	assert.Equal(t, entity.AuthMissing, checkOwnership([]string{"gonvelope-verification=t2"}, "t1").Status)
+	assert.Equal(t, entity.AuthMissing, checkOwnership(nil, "t1").Status)
+}
+
+func Test_checkSPF(t *testing.T) {
+	tests := []struct {
+		name    string
+		records []string
+		want    string
+	}{
+		{"pass", []string{"gonvelope-verification=t1", "v=spf1 include:_spf.google.com ~all"}, entity.AuthPass},
+		{"uppercase", []string{"V=SPF1 mx -all"}, entity.AuthPass},
+		{"missing", []string{"v=spf10 -all", "google-site-verification=x"}, entity.AuthMissing},
+		{"several", []string{"v=spf1 mx -all", "v=spf1 include:_spf.google.com -all"}, entity.AuthFail},
+		{"any server", []string{"v=spf1 +all"}, entity.AuthFail},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, checkSPF(tt.records).Status)
+		})
+	}
+}
+
+func Test_checkDKIM(t *testing.T) {
+	key := dkimkey.DNSRecord{Name: "s1._domainkey.example.com", Type: "TXT", Value: "v=DKIM1; k=ed25519; p=11qYAYKxCrfVS/7TyWQHOg7hcvPapiMlrwIaaPcHURo="}
+	// providers may split long keys, leaving whitespace in the value
+	check := checkDKIM([]string{"v=DKIM1; k=ed25519; p=11qYAYKxCrfVS/7TyWQHOg7h cvPapiMlrwIaaPcHURo="}, key)
+	assert.Equal(t, entity.AuthPass, check.Status)
+	assert.Equal(t, entity.AuthFail, checkDKIM([]string{"v=DKIM1; k=ed25519; p=AAAA"}, key).Status)
+	check = checkDKIM(nil, key)
+	assert.Equal(t, entity.AuthMissing, check.Status)
+	assert.Contains(t, check.Reason, "s1._domainkey.example.com")
+}
+
+func Test_checkDMARC(t *testing.T) {
+	dkim := Check{Status: entity.AuthPass}
+	tests := []struct {
+		name    string
+		records []string
+		dkim    Check
+		want    string
+	}{
+		{"pass", []string{"v=DMARC1; p=reject; rua=mailto:dmarc@example.com"}, dkim, entity.AuthPass},
+		{"monitoring only", []string{"v=DMARC1;p=none"}, dkim, entity.AuthPass},
+		{"missing", []string{"v=spf1 -all"}, dkim, entity.AuthMissing},
+		{"several", []string{"v=DMARC1; p=none", "v=DMARC1; p=reject"}, dkim, entity.AuthFail},
+		{"no policy", []string{"v=DMARC1; rua=mailto:dmarc@example.com"}, dkim, entity.AuthFail},
+		{"invalid policy", []string{"v=DMARC1; p=block"}, dkim, entity.AuthFail},
+		{"unaligned", []string{"v=DMARC1; p=reject"}, Check{Status: entity.AuthMissing}, entity.AuthFail},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, checkDMARC(tt.records, tt.dkim).Status)
+		})
+	}
+}
+
+func Test_dmarcNames(t *testing.T) {
+	assert.Equal(t, []string{"_dmarc.mail.example.com", "_dmarc.example.com"}, dmarcNames("mail.example.com"))
+	assert.Equal(t, []string{"_dmarc.example.com"}, dmarcNames("example.com"))
+}
+
+// mockResolver maps names to their TXT records. Other names do not exist, except for timeout.example.com,
+// whose lookup fails.
+type mockResolver map[string][]string
+
+func (m mockResolver) LookupTXT(_ context.Context, name string) ([]string, error) {
+	if name == "timeout.example.com" {
+		return nil, &net.DNSError{Err: "i/o timeout", Name: name, IsTimeout: true}
+	}
+	records, ok := m[name]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}
+	return records, nil
+}
@@ -0,0 +1,96 @@
+package senderdomain
+
+import (
+	"context"
+
+	"github.com/garaekz/gonvelope/internal/entity"
+	"github.com/garaekz/gonvelope/pkg/dbcontext"
+	"github.com/garaekz/gonvelope/pkg/log"
+	dbx "github.com/go-ozzo/ozzo-dbx"
+)
+
+// Repository encapsulates the logic to access sender domains from the data source.
+type Repository interface {
+	// Get returns the sender domain with the specified ID owned by the organization.
+	Get(ctx context.Context, orgID, id string) (entity.SenderDomain, error)
+	// Count returns the number of sender domains of the organization.
+	Count(ctx context.Context, orgID string) (int, error)
+	// Query returns the sender domains of the organization with the given offset and limit, ordered by domain.
+	Query(ctx context.Context, orgID string, offset, limit int) ([]entity.SenderDomain, error)
+	// CountVerified returns how many of the given domains the organization verified.
+	CountVerified(ctx context.Context, orgID string, domains []string) (int, error)
+	// Create saves a new sender domain in the storage.
+	Create(ctx context.Context, domain entity.SenderDomain) error
+	// Update saves the verification and the authentication statuses of the sender domain.
+	Update(ctx context.Context, domain entity.SenderDomain) error
+	// Delete removes the sender domain with the specified ID owned by the organization.
+	Delete(ctx context.Context, orgID, id string) error
+}
+
+// repository persists sender domains in database
+type repository struct {
+	db     *dbcontext.DB
+	logger log.Logger
+}
+
+// NewRepository creates a new sender domain repository
+func NewRepository(db *dbcontext.DB, logger log.Logger) Repository {
+	return repository{db, logger}
+}
+
+// Get returns the sender domain with the specified ID owned by the organization.
+func (r repository) Get(ctx context.Context, orgID, id string) (entity.SenderDomain, error) {
+	var domain entity.SenderDomain
+	err := r.db.With(ctx).Select().From(domain.TableName()).
+		Where(dbx.HashExp{"id": id, "org_id": orgID}).One(&domain)
+	return domain, err
+}
+
+// Count returns the number of sender domains of the organization.
+func (r repository) Count(ctx context.Context, orgID string) (int, error) {
+	var count int
+	err := r.db.With(ctx).Select("COUNT(*)").From(entity.SenderDomain{}.TableName()).
+		Where(dbx.HashExp{"org_id": orgID}).Row(&count)
+	return count, err
+}
+
+// Query returns the sender domains of the organization with the given offset and limit, ordered by domain.
+func (r repository) Query(ctx context.Context, orgID string, offset, limit int) ([]entity.SenderDomain, error) {
+	domains := []entity.SenderDomain{}
+	err := r.db.With(ctx).Select().From(entity.SenderDomain{}.TableName()).
+		Where(dbx.HashExp{"org_id": orgID}).
+		OrderBy("domain").
+		Offset(int64(offset)).
+		Limit(int64(limit)).
+		All(&domains)
+	return domains, err
+}
+
+// CountVerified returns how many of the given domains the organization verified.
+func (r repository) CountVerified(ctx context.Context, orgID string, domains []string) (int, error) {
+	names := make([]interface{}, len(domains))
+	for i, domain := range domains {
+		names[i] = domain
+	}
+	var count int
+	err := r.db.With(ctx).Select("COUNT(*)").From(entity.SenderDomain{}.TableName()).
+		Where(dbx.And(dbx.HashExp{"org_id": orgID}, dbx.In("domain", names...), dbx.NewExp("verified_at IS NOT NULL"))).
+		Row(&count)
+	return count, err
+}
+
+// Create saves a new sender domain in the storage.
+func (r repository) Create(ctx context.Context, domain entity.SenderDomain) error {
+	return r.db.With(ctx).Model(&domain).Insert()
+}
+
+// Update saves the verification and the authentication statuses of the sender domain.
+func (r repository) Update(ctx context.Context, domain entity.SenderDomain) error {
+	return r.db.With(ctx).Model(&domain).Update("VerifiedAt", "SPF", "DKIM", "DMARC", "CheckedAt")
+}
+
+// Delete removes the sender domain with the specified ID owned by the organization.
+func (r repository) Delete(ctx context.Context, orgID, id string) error {
+	_, err := r.db.With(ctx).Delete(entity.SenderDomain{}.TableName(), dbx.HashExp{"id": id, "org_id": orgID}).Execute()
+	return err
+}
@@ -0,0 +1,263 @@
+// Package senderdomain manages the domains organizations send messages from. An organization proves it owns
+// a domain by publishing a token in a DNS TXT record, and is told whether the SPF, DKIM and DMARC records of
+// the domain let receivers authenticate its messages.
+package senderdomain
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/garaekz/gonvelope/internal/audit"
+	"github.com/garaekz/gonvelope/internal/dkimkey"
+	"github.com/garaekz/gonvelope/internal/entity"
+	"github.com/garaekz/gonvelope/internal/errors"
+	"github.com/garaekz/gonvelope/pkg/dbcontext"
+	"github.com/garaekz/gonvelope/pkg/log"
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/go-ozzo/ozzo-validation/v4/is"
+)
+
+// domainExistsMessage is the error message returned when the organization already added the domain.
+const domainExistsMessage = "The organization already added this domain."
+
+// Service encapsulates usecase logic for sender domains.
+type Service interface {
+	Get(ctx context.Context, orgID, id string) (entity.SenderDomain, error)
+	Query(ctx context.Context, orgID string, offset, limit int) ([]entity.SenderDomain, error)
+	Count(ctx context.Context, orgID string) (int, error)
+	// Create adds a domain to the organization on behalf of the user. The domain must be verified to be sent from.
+	Create(ctx context.Context, orgID, userID string, input DomainRequest) (entity.SenderDomain, error)
+	Delete(ctx context.Context, orgID, userID, id string) (entity.SenderDomain, error)
+	// DNSRecord returns the DNS TXT record the organization must publish to prove it owns the domain.
+	DNSRecord(ctx context.Context, orgID, id string) (dkimkey.DNSRecord, error)
+	// Verify checks the DNS records of the domain, verifying it once the organization published its token.
+	Verify(ctx context.Context, orgID, userID, id string) (Report, error)
+	// Verified returns whether the organization verified the domain or one of its parents.
+	Verified(ctx context.Context, orgID, domain string) (bool, error)
+}
+
+// DomainRequest represents a sender domain creation request.
+type DomainRequest struct {
+	Domain string `json:"domain"`
+}
+
+// Validate validates the DomainRequest fields.
+func (m DomainRequest) Validate() error {
+	return validation.ValidateStruct(&m,
+		validation.Field(&m.Domain, validation.Required, validation.Length(0, 253), is.Domain),
+	)
+}
+
+// Report is the outcome of checking the DNS records of a sender domain.
+type Report struct {
+	Domain entity.SenderDomain `json:"domain"`
+	// Ownership tells whether the verification token is published.
+	Ownership Check `json:"ownership"`
+	SPF       Check `json:"spf"`
+	DKIM      Check `json:"dkim"`
+	DMARC     Check `json:"dmarc"`
+}
+
+// KeyRecordFunc returns the DNS TXT record publishing the DKIM key of the organization for the domain.
+// It returns sql.ErrNoRows if the organization has no key for the domain.
+type KeyRecordFunc func(ctx context.Context, orgID, domain string) (dkimkey.DNSRecord, error)
+
+type service struct {
+	repo          Repository
+	transactional dbcontext.TransactionFunc
+	resolver      Resolver
+	keyRecord     KeyRecordFunc
+	auditor       audit.Recorder
+	logger        log.Logger
+}
+
+// NewService creates a new sender domain service. DNS records are looked up with resolver,
+// and the DKIM keys of the domains are reported by keyRecord.
+func NewService(repo Repository, transactional dbcontext.TransactionFunc, resolver Resolver, keyRecord KeyRecordFunc, auditor audit.Recorder, logger log.Logger) Service {
+	return service{repo, transactional, resolver, keyRecord, auditor, logger}
+}
+
+// Get returns the sender domain with the specified ID owned by the organization.
+func (s service) Get(ctx context.Context, orgID, id string) (entity.SenderDomain, error) {
+	return s.repo.Get(ctx, orgID, id)
+}
+
+// Query returns the sender domains of the organization with the specified offset and limit.
+func (s service) Query(ctx context.Context, orgID string, offset, limit int) ([]entity.SenderDomain, error) {
+	return s.repo.Query(ctx, orgID, offset, limit)
+}
+
+// Count returns the number of sender domains of the organization.
+func (s service) Count(ctx context.Context, orgID string) (int, error) {
+	return s.repo.Count(ctx, orgID)
+}
+
+// Create adds a domain to the organization on behalf of the user, along with the token proving its ownership.
+func (s service) Create(ctx context.Context, orgID, userID string, req DomainRequest) (entity.SenderDomain, error) {
+	req.Domain = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(req.Domain)), ".")
+	if err := req.Validate(); err != nil {
+		return entity.SenderDomain{}, err
+	}
+	token, err := generateToken()
+	if err != nil {
+		return entity.SenderDomain{}, err
+	}
+	domain := entity.SenderDomain{
+		ID:        entity.GenerateID(),
+		OrgID:     orgID,
+		UserID:    userID,
+		Domain:    req.Domain,
+		Token:     token,
+		SPF:       entity.AuthUnknown,
+		DKIM:      entity.AuthUnknown,
+		DMARC:     entity.AuthUnknown,
+		CreatedAt: time.Now(),
+	}
+	err = s.transactional(ctx, func(ctx context.Context) error {
+		if err := s.repo.Create(ctx, domain); err != nil {
+			return errors.MapConflict(err, domainExistsMessage)
+		}
+		return s.record(ctx, audit.ActionSenderDomainCreated, userID, domain, nil, domain)
+	})
+	if err != nil {
+		return entity.SenderDomain{}, err
+	}
+	return domain, nil
+}
+
+// Delete deletes the sender domain on behalf of the user. Its addresses can no longer be sent from.
+func (s service) Delete(ctx context.Context, orgID, userID, id string) (entity.SenderDomain, error) {
+	domain, err := s.repo.Get(ctx, orgID, id)
+	if err != nil {
+		return domain, err
+	}
+	err = s.transactional(ctx, func(ctx context.Context) error {
+		if err := s.repo.Delete(ctx, orgID, id); err != nil {
+			return err
+		}
+		return s.record(ctx, audit.ActionSenderDomainDeleted, userID, domain, domain, nil)
+	})
+	return domain, err
+}
+
+// DNSRecord returns the DNS TXT record the organization must publish to prove it owns the domain.
+func (s service) DNSRecord(ctx context.Context, orgID, id string) (dkimkey.DNSRecord, error) {
+	domain, err := s.repo.Get(ctx, orgID, id)
+	if err != nil {
+		return dkimkey.DNSRecord{}, err
+	}
+	return dkimkey.DNSRecord{Name: domain.Domain, Type: "TXT", Value: verificationPrefix + domain.Token}, nil
+}
+
+// Verify checks the DNS records of the domain on behalf of the user and saves their statuses. The domain is
+// verified the first time its token is found, and stays verified when the token is removed afterwards.
+func (s service) Verify(ctx context.Context, orgID, userID, id string) (Report, error) {
+	domain, err := s.repo.Get(ctx, orgID, id)
+	if err != nil {
+		return Report{}, err
+	}
+	report, err := s.check(ctx, domain)
+	if err != nil {
+		return Report{}, err
+	}
+
+	before := domain
+	now := time.Now()
+	verified := !domain.Verified() && report.Ownership.Status == entity.AuthPass
+	if verified {
+		domain.VerifiedAt = &now
+	}
+	domain.SPF, domain.DKIM, domain.DMARC = report.SPF.Status, report.DKIM.Status, report.DMARC.Status
+	domain.CheckedAt = &now
+	err = s.transactional(ctx, func(ctx context.Context) error {
+		if err := s.repo.Update(ctx, domain); err != nil {
+			return err
+		}
+		if !verified {
+			return nil
+		}
+		return s.record(ctx, audit.ActionSenderDomainVerified, userID, domain, before, domain)
+	})
+	if err != nil {
+		return Report{}, err
+	}
+	report.Domain = domain
+	return report, nil
+}
+
+// check looks up the DNS records of the domain and checks them.
+func (s service) check(ctx context.Context, domain entity.SenderDomain) (Report, error) {
+	records, err := lookupTXT(ctx, s.resolver, domain.Domain)
+	if err != nil {
+		return Report{}, err
+	}
+	report := Report{
+		Ownership: checkOwnership(records, domain.Token),
+		SPF:       checkSPF(records),
+	}
+
+	key, err := s.keyRecord(ctx, domain.OrgID, domain.Domain)
+	switch {
+	case err == sql.ErrNoRows:
+		report.DKIM = Check{Status: entity.AuthMissing, Reason: "the organization has no DKIM key for the domain"}
+	case err != nil:
+		return Report{}, err
+	default:
+		if records, err = lookupTXT(ctx, s.resolver, key.Name); err != nil {
+			return Report{}, err
+		}
+		report.DKIM = checkDKIM(records, key)
+	}
+
+	// the policy of the closest domain publishing one applies
+	records = nil
+	for _, name := range dmarcNames(domain.Domain) {
+		if records, err = lookupTXT(ctx, s.resolver, name); err != nil {
+			return Report{}, err
+		}
+		if len(filterRecords(records, "v=DMARC1")) > 0 {
+			break
+		}
+	}
+	report.DMARC = checkDMARC(records, report.DKIM)
+	return report, nil
+}
+
+// Verified returns whether the organization verified the domain or one of its parents,
+// whose owner controls the subdomains as well.
+func (s service) Verified(ctx context.Context, orgID, domain string) (bool, error) {
+	domain = strings.TrimSuffix(strings.ToLower(domain), ".")
+	domains := []string{domain}
+	for i := strings.Index(domain, "."); i >= 0; i = strings.Index(domain, ".") {
+		domain = domain[i+1:]
+		domains = append(domains, domain)
+	}
+	count, err := s.repo.CountVerified(ctx, orgID, domains)
+	return count > 0, err
+}
+
+// record records the change made by the user to the sender domain in the audit log.
+// The before and after states are nil when the domain is created and deleted respectively.
+func (s service) record(ctx context.Context, action, userID string, domain entity.SenderDomain, before, after interface{}) error {
+	return s.auditor.Record(ctx, audit.Event{
+		Action:     action,
+		OrgID:      domain.OrgID,
+		ActorID:    userID,
+		TargetType: "sender_domain",
+		TargetID:   domain.ID,
+		Diff:       audit.Diff(before, after),
+	})
+}
+
+// generateToken returns a new random verification token.
+func generateToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,274 @@
+package senderdomain
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/garaekz/gonvelope/internal/audit"
+	"github.com/garaekz/gonvelope/internal/dkimkey"
+	"github.com/garaekz/gonvelope/internal/entity"
+	"github.com/garaekz/gonvelope/internal/errors"
+	"github.com/garaekz/gonvelope/pkg/log"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDomainRequest_Validate(t *testing.T) {
+	tests := []struct {
+		name      string
+		model     DomainRequest
+		wantError bool
+	}{
+		{"success", DomainRequest{Domain: "mail.example.com"}, false},
+		{"missing domain", DomainRequest{}, true},
+		{"invalid domain", DomainRequest{Domain: "example com"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.wantError, tt.model.Validate() != nil)
+		})
+	}
+}
+
+func Test_service_Create(t *testing.T) {
+	logger, _ := log.NewForTest()
+	auditor := &mockRecorder{}
+	s := NewService(&mockRepository{}, mockTransactional, mockResolver{}, mockKeyRecord, auditor, logger)
+	ctx := context.Background()
+
+	domain, err := s.Create(ctx, "org1", "100", DomainRequest{Domain: " Example.COM. "})
+	require.Nil(t, err)
+	assert.Equal(t, "example.com", domain.Domain)
+	assert.Len(t, domain.Token, 32)
+	assert.False(t, domain.Verified())
+	assert.Equal(t, entity.AuthUnknown, domain.SPF)
+	if assert.Len(t, auditor.events, 1) {
+		assert.Equal(t, audit.ActionSenderDomainCreated, auditor.events[0].Action)
+	}
+
+	record, err := s.DNSRecord(ctx, "org1", domain.ID)
+	require.Nil(t, err)
+	assert.Equal(t, dkimkey.DNSRecord{Name: "example.com", Type: "TXT", Value: "gonvelope-verification=" + domain.Token}, record)
+	_, err = s.DNSRecord(ctx, "org2", domain.ID)
+	assert.Equal(t, sql.ErrNoRows, err)
+
+	_, err = s.Create(ctx, "org1", "100", DomainRequest{Domain: "example.com"})
+	assert.Equal(t, http.StatusConflict, err.(errors.ErrorResponse).StatusCode())
+	other, err := s.Create(ctx, "org2", "100", DomainRequest{Domain: "example.com"})
+	require.Nil(t, err)
+	assert.NotEqual(t, domain.Token, other.Token)
+	_, err = s.Create(ctx, "org1", "100", DomainRequest{Domain: "not a domain"})
+	assert.NotNil(t, err)
+
+	_, err = s.Delete(ctx, "org1", "100", domain.ID)
+	require.Nil(t, err)
+	assert.Equal(t, audit.ActionSenderDomainDeleted, auditor.events[len(auditor.events)-1].Action)
+	_, err = s.Get(ctx, "org1", domain.ID)
+	assert.Equal(t, sql.ErrNoRows, err)
+}
+
+func Test_service_Verify(t *testing.T) {
+	logger, _ := log.NewForTest()
+	repo := &mockRepository{items: []entity.SenderDomain{
+		{ID: "d1", OrgID: "org1", Domain: "example.com", Token: "t1"},
+		{ID: "d2", OrgID: "org1", Domain: "mail.example.org", Token: "t2"},
+		{ID: "d3", OrgID: "org1", Domain: "timeout.example.com", Token: "t3"},
+	}}
+	resolver := mockResolver{
+		"example.com":               {"v=spf1 include:_spf.google.com ~all"},
+		"s1._domainkey.example.com": {"v=DKIM1; k=ed25519; p=11qYAYKxCrfVS/7TyWQHOg7hcvPapiMlrwIaaPcHURo="},
+		"_dmarc.example.com":        {"v=DMARC1; p=quarantine"},
+		"mail.example.org":          {"gonvelope-verification=t2"},
+		"_dmarc.example.org":        {"v=DMARC1; p=reject"},
+	}
+	auditor := &mockRecorder{}
+	s := NewService(repo, mockTransactional, resolver, mockKeyRecord, auditor, logger)
+	ctx := context.Background()
+
+	// the token is not published yet
+	report, err := s.Verify(ctx, "org1", "100", "d1")
+	require.Nil(t, err)
+	assert.Equal(t, entity.AuthMissing, report.Ownership.Status)
+	assert.False(t, report.Domain.Verified())
+	assert.Equal(t, entity.AuthPass, report.SPF.Status)
+	assert.Equal(t, entity.AuthPass, report.DKIM.Status)
+	assert.Equal(t, entity.AuthPass, report.DMARC.Status)
+	assert.Equal(t, "v=DMARC1; p=quarantine", report.DMARC.Record)
+	assert.NotNil(t, report.Domain.CheckedAt)
+	assert.Empty(t, auditor.events)
+	verified, err := s.Verified(ctx, "org1", "example.com")
+	require.Nil(t, err)
+	assert.False(t, verified)
+
+	resolver["example.com"] = append(resolver["example.com"], "gonvelope-verification=t1")
+	report, err = s.Verify(ctx, "org1", "100", "d1")
+	require.Nil(t, err)
+	assert.Equal(t, entity.AuthPass, report.Ownership.Status)
+	assert.True(t, report.Domain.Verified())
+	if assert.Len(t, auditor.events, 1) {
+		assert.Equal(t, audit.ActionSenderDomainVerified, auditor.events[0].Action)
+	}
+	domain, _ := s.Get(ctx, "org1", "d1")
+	assert.Equal(t, entity.AuthPass, domain.DMARC)
+
+	// a verified domain stays verified, and is only recorded as such once
+	delete(resolver, "example.com")
+	report, err = s.Verify(ctx, "org1", "100", "d1")
+	require.Nil(t, err)
+	assert.True(t, report.Domain.Verified())
+	assert.Equal(t, entity.AuthMissing, report.SPF.Status)
+	assert.Len(t, auditor.events, 1)
+
+	// subdomains fall back on the DMARC policy of their parent, which fails without an aligned DKIM signature
+	report, err = s.Verify(ctx, "org1", "100", "d2")
+	require.Nil(t, err)
+	assert.True(t, report.Domain.Verified())
+	assert.Equal(t, entity.AuthMissing, report.SPF.Status)
+	assert.Equal(t, entity.AuthMissing, report.DKIM.Status)
+	assert.Equal(t, entity.AuthFail, report.DMARC.Status)
+	assert.Equal(t, "v=DMARC1; p=reject", report.DMARC.Record)
+
+	// lookup failures leave the domain unchanged
+	_, err = s.Verify(ctx, "org1", "100", "d3")
+	assert.NotNil(t, err)
+	domain, _ = s.Get(ctx, "org1", "d3")
+	assert.Nil(t, domain.CheckedAt)
+	_, err = s.Verify(ctx, "org2", "100", "d1")
+	assert.Equal(t, sql.ErrNoRows, err)
+}
+
+func Test_service_Verified(t *testing.T) {
+	logger, _ := log.NewForTest()
+	repo := &mockRepository{}
+	s := NewService(repo, mockTransactional, mockResolver{}, mockKeyRecord, &mockRecorder{}, logger)
+	ctx := context.Background()
+	now := time.Now()
+	repo.items = []entity.SenderDomain{
+		{ID: "d1", OrgID: "org1", Domain: "example.com", VerifiedAt: &now},
+		{ID: "d2", OrgID: "org1", Domain: "example.org"},
+	}
+
+	tests := []struct {
+		orgID, domain string
+		want          bool
+	}{
+		{"org1", "example.com", true},
+		{"org1", "Example.COM", true},
+		{"org1", "news.mail.example.com", true},
+		{"org1", "example.org", false},
+		{"org1", "example.net", false},
+		{"org1", "notexample.com", false},
+		{"org2", "example.com", false},
+	}
+	for _, tt := range tests {
+		verified, err := s.Verified(ctx, tt.orgID, tt.domain)
+		assert.Nil(t, err)
+		assert.Equal(t, tt.want, verified, tt.orgID+" "+tt.domain)
+	}
+}
+
+// mockKeyRecord reports a DKIM key for example.com only.
+func mockKeyRecord(_ context.Context, orgID, domain string) (dkimkey.DNSRecord, error) {
+	if orgID == "org1" && domain == "example.com" {
+		return dkimkey.DNSRecord{Name: "s1._domainkey.example.com", Type: "TXT", Value: "v=DKIM1; k=ed25519; p=11qYAYKxCrfVS/7TyWQHOg7hcvPapiMlrwIaaPcHURo="}, nil
+	}
+	return dkimkey.DNSRecord{}, sql.ErrNoRows
+}
+
+func mockTransactional(ctx context.Context, f func(ctx context.Context) error) error {
+	return f(ctx)
+}
+
+type mockRecorder struct {
+	events []audit.Event
+}
+
+func (m *mockRecorder) Record(_ context.Context, event audit.Event) error {
+	m.events = append(m.events, event)
+	return nil
+}
+
+type mockRepository struct {
+	items []entity.SenderDomain
+}
+
+func (m *mockRepository) Get(_ context.Context, orgID, id string) (entity.SenderDomain, error) {
+	for _, item := range m.items {
+		if item.ID == id && item.OrgID == orgID {
+			return item, nil
+		}
+	}
+	return entity.SenderDomain{}, sql.ErrNoRows
+}
+
+func (m *mockRepository) Count(_ context.Context, orgID string) (int, error) {
+	count := 0
+	for _, item := range m.items {
+		if item.OrgID == orgID {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (m *mockRepository) Query(_ context.Context, orgID string, offset, limit int) ([]entity.SenderDomain, error) {
+	var items []entity.SenderDomain
+	for _, item := range m.items {
+		if item.OrgID == orgID {
+			items = append(items, item)
+		}
+	}
+	if offset > len(items) {
+		offset = len(items)
+	}
+	items = items[offset:]
+	if limit >= 0 && limit < len(items) {
+		items = items[:limit]
+	}
+	return items, nil
+}
+
+func (m *mockRepository) CountVerified(_ context.Context, orgID string, domains []string) (int, error) {
+	count := 0
+	for _, item := range m.items {
+		for _, domain := range domains {
+			if item.OrgID == orgID && item.Domain == domain && item.Verified() {
+				count++
+			}
+		}
+	}
+	return count, nil
+}
+
+func (m *mockRepository) Create(_ context.Context, domain entity.SenderDomain) error {
+	for _, item := range m.items {
+		if item.OrgID == domain.OrgID && item.Domain == domain.Domain {
+			return &pq.Error{Code: "23505"}
+		}
+	}
+	m.items = append(m.items, domain)
+	return nil
+}
+
+func (m *mockRepository) Update(_ context.Context, domain entity.SenderDomain) error {
+	for i, item := range m.items {
+		if item.ID == domain.ID {
+			m.items[i] = domain
+		}
+	}
+	return nil
+}
+
+func (m *mockRepository) Delete(_ context.Context, orgID, id string) error {
+	for i, item := range m.items {
+		if item.ID == id && item.OrgID == orgID {
+			m.items = append(m.items[:i], m.items[i+1:]...)
+			return nil
+		}
+	}
+	return sql.ErrNoRows
+}
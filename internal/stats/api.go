@@ -0,0 +1,54 @@
+package stats
+
+import (
+	"strings"
+
+	"github.com/garaekz/gonvelope/internal/auth"
+	"github.com/garaekz/gonvelope/pkg/log"
+	routing "github.com/garaekz/ozzo-routing"
+	"github.com/garaekz/ozzo-routing/content"
+)
+
+// csvType is the MIME type of the reports written as CSV.
+const csvType = "text/csv"
+
+// RegisterHandlers sets up the routing of the HTTP handlers.
+// Statistics cover the messages of the organization made active by orgHandler.
+func RegisterHandlers(r *routing.RouteGroup, service Service, authHandler, orgHandler routing.Handler, logger log.Logger) {
+	res := resource{service, logger}
+
+	r.Use(authHandler, orgHandler)
+
+	r.Get("stats", auth.Require(auth.ScopeStatsRead), res.query)
+}
+
+type resource struct {
+	service Service
+	logger  log.Logger
+}
+
+// query writes the report as JSON, or as CSV if the client accepts it over JSON.
+func (r resource) query(c *routing.Context) error {
+	membership, _ := auth.CurrentMembership(c.Request.Context())
+	query := c.Request.URL.Query()
+	req := QueryRequest{
+		Since:      query.Get("since"),
+		Until:      query.Get("until"),
+		TemplateID: query.Get("template_id"),
+		AccountID:  query.Get("account_id"),
+	}
+	if groupBy := query.Get("group_by"); groupBy != "" {
+		req.GroupBy = strings.Split(groupBy, ",")
+	}
+	report, err := r.service.Query(c.Request.Context(), membership.OrgID, req)
+	if err != nil {
+		return err
+	}
+
+	if content.NegotiateContentType(c.Request, []string{content.JSON, csvType}, content.JSON) != csvType {
+		return c.Write(report)
+	}
+	c.Response.Header().Set("Content-Type", csvType+"; charset=utf-8")
+	c.Response.Header().Set("Content-Disposition", `attachment; filename="stats.csv"`)
+	return report.WriteCSV(c.Response)
+}
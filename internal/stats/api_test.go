@@ -0,0 +1,54 @@
+package stats
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/garaekz/gonvelope/internal/auth"
+	"github.com/garaekz/gonvelope/internal/entity"
+	"github.com/garaekz/gonvelope/internal/org"
+	"github.com/garaekz/gonvelope/internal/test"
+	"github.com/garaekz/gonvelope/pkg/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAPI(t *testing.T) {
+	logger, _ := log.NewForTest()
+	router := test.MockRouter(logger)
+	RegisterHandlers(router.Group("/"), NewService(&mockRepository{}, logger), auth.MockAuthHandler, org.MockHandler, logger)
+	header := auth.MockAuthHeader()
+
+	tests := []test.APITestCase{
+		{Name: "totals", Method: "GET", URL: "/stats", Header: header, WantStatus: http.StatusOK, WantResponse: `*"group_by":[]*`},
+		{Name: "grouped", Method: "GET", URL: "/stats?group_by=day,template&template_id=t1", Header: header, WantStatus: http.StatusOK, WantResponse: `*"template_id":"t1","account_id":"gmail1","sent":3,"failed":0,"bounced":0,"opened":1,"clicked":0*`},
+		{Name: "invalid group", Method: "GET", URL: "/stats?group_by=week", Header: header, WantStatus: http.StatusBadRequest, WantResponse: `*group_by*`},
+		{Name: "invalid since", Method: "GET", URL: "/stats?since=yesterday", Header: header, WantStatus: http.StatusBadRequest, WantResponse: `*since*`},
+		{Name: "viewer", Method: "GET", URL: "/stats", Header: org.MockHeader(entity.RoleViewer), WantStatus: http.StatusOK},
+		{Name: "unauthorized", Method: "GET", URL: "/stats", Header: nil, WantStatus: http.StatusUnauthorized},
+	}
+	for _, tc := range tests {
+		test.Endpoint(t, router, tc)
+	}
+
+	t.Run("csv", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/stats?group_by=account&since=2026-10-01T00:00:00Z&until=2026-10-08T00:00:00Z", nil)
+		req.Header = auth.MockAuthHeader()
+		req.Header.Set("Accept", "text/csv")
+		res := httptest.NewRecorder()
+		router.ServeHTTP(res, req)
+		assert.Equal(t, http.StatusOK, res.Code)
+		assert.Equal(t, "text/csv; charset=utf-8", res.Header().Get("Content-Type"))
+		assert.Equal(t, "account_id,sent,failed,bounced,opened,clicked\ngmail1,3,0,0,1,0\n", res.Body.String())
+	})
+
+	t.Run("json preferred", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/stats", nil)
+		req.Header = auth.MockAuthHeader()
+		req.Header.Set("Accept", "application/json, text/csv;q=0.5")
+		res := httptest.NewRecorder()
+		router.ServeHTTP(res, req)
+		assert.Equal(t, http.StatusOK, res.Code)
+		assert.Contains(t, res.Header().Get("Content-Type"), "application/json")
+	})
+}
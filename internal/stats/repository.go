@@ -0,0 +1,77 @@
+package stats
+
+import (
+	"context"
+
+	"github.com/garaekz/gonvelope/internal/entity"
+	"github.com/garaekz/gonvelope/pkg/dbcontext"
+	"github.com/garaekz/gonvelope/pkg/log"
+	dbx "github.com/go-ozzo/ozzo-dbx"
+)
+
+// Repository encapsulates the logic to access the message statistics from the data source.
+type Repository interface {
+	// Query adds up the rollups selected by the filter by the groups of the filter, in the order of the groups.
+	Query(ctx context.Context, filter Filter) ([]Row, error)
+}
+
+// repository reads the message statistics from the rollups in database
+type repository struct {
+	db     *dbcontext.DB
+	logger log.Logger
+}
+
+// NewRepository creates a new stats repository
+func NewRepository(db *dbcontext.DB, logger log.Logger) Repository {
+	return repository{db, logger}
+}
+
+// groupColumns maps the dimensions to the expressions the rollups are grouped by.
+var groupColumns = map[string]string{
+	GroupDay:      "date_trunc('day', period)",
+	GroupHour:     "period",
+	GroupTemplate: "template_id",
+	GroupAccount:  "account_id",
+}
+
+// groupAliases maps the dimensions to the columns of the rows.
+var groupAliases = map[string]string{
+	GroupDay:      "period",
+	GroupHour:     "period",
+	GroupTemplate: "template_id",
+	GroupAccount:  "account_id",
+}
+
+// Query adds up the rollups selected by the filter by the groups of the filter, in the order of the groups.
+func (r repository) Query(ctx context.Context, filter Filter) ([]Row, error) {
+	var columns, groups []string
+	for _, group := range filter.GroupBy {
+		columns = append(columns, groupColumns[group]+" AS "+groupAliases[group])
+		groups = append(groups, groupColumns[group])
+	}
+	columns = append(columns,
+		"COALESCE(SUM(sent), 0) AS sent",
+		"COALESCE(SUM(failed), 0) AS failed",
+		"COALESCE(SUM(bounced), 0) AS bounced",
+		"COALESCE(SUM(opened), 0) AS opened",
+		"COALESCE(SUM(clicked), 0) AS clicked",
+	)
+	conditions := []dbx.Expression{
+		dbx.HashExp{"org_id": filter.OrgID},
+		dbx.NewExp("period >= {:since} AND period < {:until}", dbx.Params{"since": filter.Since, "until": filter.Until}),
+	}
+	if filter.TemplateID != "" {
+		conditions = append(conditions, dbx.HashExp{"template_id": filter.TemplateID})
+	}
+	if filter.AccountID != "" {
+		conditions = append(conditions, dbx.HashExp{"account_id": filter.AccountID})
+	}
+
+	rows := []Row{}
+	err := r.db.With(ctx).Select(columns...).From(entity.MessageStats{}.TableName()).
+		Where(dbx.And(conditions...)).
+		GroupBy(groups...).
+		OrderBy(groups...).
+		All(&rows)
+	return rows, err
+}
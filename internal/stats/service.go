@@ -0,0 +1,211 @@
+// Package stats reports how many messages organizations sent and what happened to them. The reports are
+// built from the hourly rollups the message worker maintains, so they do not scan the messages.
+package stats
+
+import (
+	"context"
+	"encoding/csv"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/garaekz/gonvelope/internal/errors"
+	"github.com/garaekz/gonvelope/pkg/log"
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+)
+
+// Dimensions the counts can be grouped by. Counts are always broken down by status.
+const (
+	// GroupDay groups the counts by UTC day.
+	GroupDay = "day"
+	// GroupHour groups the counts by hour.
+	GroupHour = "hour"
+	// GroupTemplate groups the counts by template. Messages not sent from a template have an empty template ID.
+	GroupTemplate = "template"
+	// GroupAccount groups the counts by provider account.
+	GroupAccount = "account"
+)
+
+const (
+	// defaultRange is the period reported when the request does not start it.
+	defaultRange = 30 * 24 * time.Hour
+	// maxRange is the longest period that can be reported.
+	maxRange = 366 * 24 * time.Hour
+	// maxHourlyRange is the longest period that can be reported by hour.
+	maxHourlyRange = 31 * 24 * time.Hour
+)
+
+// Service encapsulates usecase logic for message statistics.
+type Service interface {
+	// Query returns the message statistics of the organization selected by the request.
+	Query(ctx context.Context, orgID string, req QueryRequest) (Report, error)
+}
+
+// QueryRequest represents a request for message statistics.
+type QueryRequest struct {
+	// GroupBy lists the dimensions the counts are grouped by, at most one of them by time.
+	GroupBy []string `json:"group_by"`
+	// Since and Until delimit the reported period as RFC 3339 dates. Counts are kept by hour,
+	// so the period effectively starts and ends on the hour.
+	Since      string `json:"since"`
+	Until      string `json:"until"`
+	TemplateID string `json:"template_id"`
+	AccountID  string `json:"account_id"`
+}
+
+// Validate validates the QueryRequest fields.
+func (m QueryRequest) Validate() error {
+	return validation.ValidateStruct(&m,
+		validation.Field(&m.GroupBy, validation.Each(validation.In(GroupDay, GroupHour, GroupTemplate, GroupAccount)), validation.By(singleInterval)),
+		validation.Field(&m.Since, validation.Date(time.RFC3339)),
+		validation.Field(&m.Until, validation.Date(time.RFC3339)),
+	)
+}
+
+// singleInterval checks that the counts are grouped by day or by hour, but not both.
+func singleInterval(value interface{}) error {
+	groups, _ := value.([]string)
+	if hasGroup(groups, GroupDay) && hasGroup(groups, GroupHour) {
+		return validation.NewError("validation_stats_interval", "cannot group by both day and hour")
+	}
+	return nil
+}
+
+// Report represents the message statistics of an organization over a period.
+type Report struct {
+	Since   time.Time `json:"since"`
+	Until   time.Time `json:"until"`
+	GroupBy []string  `json:"group_by"`
+	Rows    []Row     `json:"rows"`
+}
+
+// Row holds the counts of a group of messages. The dimensions the report is not grouped by are left empty.
+type Row struct {
+	Period     *time.Time `json:"period" db:"period"`
+	TemplateID string     `json:"template_id" db:"template_id"`
+	AccountID  string     `json:"account_id" db:"account_id"`
+	Sent       int        `json:"sent" db:"sent"`
+	Failed     int        `json:"failed" db:"failed"`
+	Bounced    int        `json:"bounced" db:"bounced"`
+	Opened     int        `json:"opened" db:"opened"`
+	Clicked    int        `json:"clicked" db:"clicked"`
+}
+
+// Filter selects the rollups of an organization over a period and the way their counts are added up.
+type Filter struct {
+	OrgID      string
+	TemplateID string
+	AccountID  string
+	Since      time.Time
+	Until      time.Time
+	GroupBy    []string
+}
+
+type service struct {
+	repo   Repository
+	logger log.Logger
+}
+
+// NewService creates a new stats service.
+func NewService(repo Repository, logger log.Logger) Service {
+	return service{repo, logger}
+}
+
+// Query returns the message statistics of the organization selected by the request.
+// The period defaults to the last 30 days.
+func (s service) Query(ctx context.Context, orgID string, req QueryRequest) (Report, error) {
+	if err := req.Validate(); err != nil {
+		return Report{}, err
+	}
+	until := time.Now()
+	if req.Until != "" {
+		until, _ = time.Parse(time.RFC3339, req.Until)
+	}
+	since := until.Add(-defaultRange)
+	if req.Since != "" {
+		since, _ = time.Parse(time.RFC3339, req.Since)
+	}
+	if !since.Before(until) {
+		return Report{}, errors.InvalidInput(validation.Errors{
+			"since": validation.NewError("validation_stats_range", "must be before until"),
+		})
+	}
+	limit := maxRange
+	if hasGroup(req.GroupBy, GroupHour) {
+		limit = maxHourlyRange
+	}
+	if until.Sub(since) > limit {
+		return Report{}, errors.InvalidInput(validation.Errors{
+			"since": validation.NewError("validation_stats_range", "must be at most "+strconv.Itoa(int(limit/(24*time.Hour)))+" days before until"),
+		})
+	}
+
+	groupBy := []string{}
+	for _, group := range []string{GroupDay, GroupHour, GroupTemplate, GroupAccount} {
+		if hasGroup(req.GroupBy, group) {
+			groupBy = append(groupBy, group)
+		}
+	}
+	rows, err := s.repo.Query(ctx, Filter{
+		OrgID:      orgID,
+		TemplateID: req.TemplateID,
+		AccountID:  req.AccountID,
+		Since:      since,
+		Until:      until,
+		GroupBy:    groupBy,
+	})
+	if err != nil {
+		return Report{}, err
+	}
+	return Report{Since: since, Until: until, GroupBy: groupBy, Rows: rows}, nil
+}
+
+// WriteCSV writes the rows of the report to w as CSV. Only the dimensions the report is grouped by get a column.
+func (r Report) WriteCSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	var header []string
+	if hasGroup(r.GroupBy, GroupDay) || hasGroup(r.GroupBy, GroupHour) {
+		header = append(header, "period")
+	}
+	if hasGroup(r.GroupBy, GroupTemplate) {
+		header = append(header, "template_id")
+	}
+	if hasGroup(r.GroupBy, GroupAccount) {
+		header = append(header, "account_id")
+	}
+	header = append(header, "sent", "failed", "bounced", "opened", "clicked")
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+	for _, row := range r.Rows {
+		var record []string
+		for _, column := range header {
+			switch column {
+			case "period":
+				record = append(record, row.Period.UTC().Format(time.RFC3339))
+			case "template_id":
+				record = append(record, row.TemplateID)
+			case "account_id":
+				record = append(record, row.AccountID)
+			}
+		}
+		for _, count := range []int{row.Sent, row.Failed, row.Bounced, row.Opened, row.Clicked} {
+			record = append(record, strconv.Itoa(count))
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// hasGroup returns whether the dimension is one of the groups.
+func hasGroup(groups []string, group string) bool {
+	for _, item := range groups {
+		if item == group {
+			return true
+		}
+	}
+	return false
+}
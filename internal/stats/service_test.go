@@ -0,0 +1,109 @@
+package stats
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/garaekz/gonvelope/internal/errors"
+	"github.com/garaekz/gonvelope/pkg/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueryRequest_Validate(t *testing.T) {
+	tests := []struct {
+		name      string
+		model     QueryRequest
+		wantError bool
+	}{
+		{"empty", QueryRequest{}, false},
+		{"success", QueryRequest{GroupBy: []string{GroupDay, GroupTemplate, GroupAccount}, Since: "2026-10-01T00:00:00Z", Until: "2026-10-08T00:00:00Z"}, false},
+		{"unknown group", QueryRequest{GroupBy: []string{"status"}}, true},
+		{"day and hour", QueryRequest{GroupBy: []string{GroupDay, GroupHour}}, true},
+		{"invalid since", QueryRequest{Since: "yesterday"}, true},
+		{"invalid until", QueryRequest{Until: "2026-10-08"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.model.Validate()
+			assert.Equal(t, tt.wantError, err != nil)
+		})
+	}
+}
+
+func Test_service_Query(t *testing.T) {
+	logger, _ := log.NewForTest()
+	repo := &mockRepository{}
+	s := NewService(repo, logger)
+	ctx := context.Background()
+
+	report, err := s.Query(ctx, "org1", QueryRequest{GroupBy: []string{GroupAccount, GroupDay}, TemplateID: "t1"})
+	require.Nil(t, err)
+	// the groups are applied in a fixed order, and the period defaults to the last 30 days
+	assert.Equal(t, []string{GroupDay, GroupAccount}, report.GroupBy)
+	assert.Equal(t, []string{GroupDay, GroupAccount}, repo.filter.GroupBy)
+	assert.Equal(t, "org1", repo.filter.OrgID)
+	assert.Equal(t, "t1", repo.filter.TemplateID)
+	assert.WithinDuration(t, time.Now(), repo.filter.Until, time.Minute)
+	assert.Equal(t, defaultRange, repo.filter.Until.Sub(repo.filter.Since))
+	assert.Equal(t, repo.rows, report.Rows)
+
+	report, err = s.Query(ctx, "org1", QueryRequest{Since: "2026-10-01T00:00:00Z", Until: "2026-10-08T00:00:00Z"})
+	require.Nil(t, err)
+	assert.Equal(t, time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC), report.Since)
+	assert.Equal(t, []string{}, report.GroupBy)
+
+	tests := []struct {
+		name string
+		req  QueryRequest
+	}{
+		{"reversed", QueryRequest{Since: "2026-10-08T00:00:00Z", Until: "2026-10-01T00:00:00Z"}},
+		{"too long", QueryRequest{Since: "2025-01-01T00:00:00Z", Until: "2026-10-01T00:00:00Z"}},
+		{"too long by hour", QueryRequest{GroupBy: []string{GroupHour}, Since: "2026-08-01T00:00:00Z", Until: "2026-10-01T00:00:00Z"}},
+	}
+	for _, tt := range tests {
+		_, err := s.Query(ctx, "org1", tt.req)
+		if assert.NotNil(t, err, tt.name) {
+			assert.Equal(t, http.StatusBadRequest, err.(errors.ErrorResponse).StatusCode(), tt.name)
+		}
+	}
+
+	_, err = s.Query(ctx, "org1", QueryRequest{GroupBy: []string{"week"}})
+	assert.NotNil(t, err)
+}
+
+func TestReport_WriteCSV(t *testing.T) {
+	day := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	report := Report{
+		GroupBy: []string{GroupDay, GroupTemplate},
+		Rows: []Row{
+			{Period: &day, TemplateID: "t1", Sent: 10, Failed: 1, Bounced: 2, Opened: 5, Clicked: 3},
+			{Period: &day, Sent: 4},
+		},
+	}
+	var buf bytes.Buffer
+	require.Nil(t, report.WriteCSV(&buf))
+	assert.Equal(t, "period,template_id,sent,failed,bounced,opened,clicked\n"+
+		"2026-10-01T00:00:00Z,t1,10,1,2,5,3\n"+
+		"2026-10-01T00:00:00Z,,4,0,0,0,0\n", buf.String())
+
+	buf.Reset()
+	require.Nil(t, Report{Rows: []Row{{Sent: 1}}}.WriteCSV(&buf))
+	assert.Equal(t, "sent,failed,bounced,opened,clicked\n1,0,0,0,0\n", buf.String())
+}
+
+type mockRepository struct {
+	rows []Row
+	// filter is the filter of the last query
+	filter Filter
+}
+
+func (m *mockRepository) Query(_ context.Context, filter Filter) ([]Row, error) {
+	m.filter = filter
+	day := filter.Since.Truncate(24 * time.Hour)
+	m.rows = []Row{{Period: &day, TemplateID: filter.TemplateID, AccountID: "gmail1", Sent: 3, Opened: 1}}
+	return m.rows, nil
+}
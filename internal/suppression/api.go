@@ -0,0 +1,138 @@
+package suppression
+
+import (
+	"net/http"
+
+	"github.com/garaekz/gonvelope/internal/auth"
+	"github.com/garaekz/gonvelope/internal/entity"
+	"github.com/garaekz/gonvelope/internal/errors"
+	"github.com/garaekz/gonvelope/pkg/log"
+	"github.com/garaekz/gonvelope/pkg/pagination"
+	routing "github.com/garaekz/ozzo-routing"
+)
+
+// RegisterHandlers sets up the routing of the HTTP handlers.
+// The unsubscribe endpoints are public since recipients only hold the signed token from their message.
+// The suppression list belongs to the organization made active by orgHandler.
+func RegisterHandlers(r *routing.RouteGroup, service Service, authHandler, orgHandler routing.Handler, logger log.Logger) {
+	res := resource{service, logger}
+
+	r.Get("unsubscribe/<token>", res.recipient)
+	r.Post("unsubscribe/<token>", res.unsubscribe)
+
+	r.Use(authHandler, orgHandler)
+
+	r.Get("suppressions", auth.Require(auth.ScopeSuppressionsRead), res.query)
+	r.Get("suppressions/export", auth.Require(auth.ScopeSuppressionsRead), res.export)
+	r.Get("suppressions/<email>", auth.Require(auth.ScopeSuppressionsRead), res.get)
+	r.Post("suppressions", auth.Require(auth.ScopeSuppressionsWrite), res.add)
+	r.Post("suppressions/import", auth.Require(auth.ScopeSuppressionsWrite), res.importCSV)
+	r.Delete("suppressions/<email>", auth.Require(auth.ScopeSuppressionsWrite), res.remove)
+}
+
+type resource struct {
+	service Service
+	logger  log.Logger
+}
+
+// unsubscription describes the recipient of an unsubscribe link.
+type unsubscription struct {
+	Email        string `json:"email"`
+	Unsubscribed bool   `json:"unsubscribed"`
+}
+
+// recipient tells who the unsubscribe link is for without unsubscribing, since GET requests
+// may be issued by link scanners. Unsubscribing takes a POST request, as required by RFC 8058.
+func (r resource) recipient(c *routing.Context) error {
+	email, err := r.service.Recipient(c.Param("token"))
+	if err != nil {
+		return err
+	}
+	return c.Write(unsubscription{Email: email})
+}
+
+func (r resource) unsubscribe(c *routing.Context) error {
+	token := c.Param("token")
+	email, err := r.service.Recipient(token)
+	if err != nil {
+		return err
+	}
+	if err := r.service.Unsubscribe(c.Request.Context(), token); err != nil {
+		return err
+	}
+	return c.Write(unsubscription{Email: email, Unsubscribed: true})
+}
+
+func (r resource) get(c *routing.Context) error {
+	suppression, err := r.service.Get(c.Request.Context(), currentMembership(c).OrgID, c.Param("email"))
+	if err != nil {
+		return err
+	}
+	return c.Write(suppression)
+}
+
+func (r resource) query(c *routing.Context) error {
+	ctx := c.Request.Context()
+	orgID := currentMembership(c).OrgID
+	search := c.Query("search")
+	count, err := r.service.Count(ctx, orgID, search)
+	if err != nil {
+		return err
+	}
+	pages := pagination.NewFromRequest(c.Request, count)
+	suppressions, err := r.service.Query(ctx, orgID, search, pages.Offset(), pages.Limit())
+	if err != nil {
+		return err
+	}
+	pages.Items = suppressions
+	return c.Write(pages)
+}
+
+func (r resource) add(c *routing.Context) error {
+	var input AddRequest
+	if err := c.Read(&input); err != nil {
+		r.logger.With(c.Request.Context()).Info(err)
+		return errors.BadRequest("")
+	}
+	membership := currentMembership(c)
+	suppression, err := r.service.Add(c.Request.Context(), membership.OrgID, membership.UserID, input)
+	if err != nil {
+		return err
+	}
+	return c.WriteWithStatus(suppression, http.StatusCreated)
+}
+
+func (r resource) remove(c *routing.Context) error {
+	membership := currentMembership(c)
+	suppression, err := r.service.Remove(c.Request.Context(), membership.OrgID, membership.UserID, c.Param("email"))
+	if err != nil {
+		return err
+	}
+	return c.Write(suppression)
+}
+
+// importCSV imports the CSV file sent as the request body.
+func (r resource) importCSV(c *routing.Context) error {
+	membership := currentMembership(c)
+	result, err := r.service.Import(c.Request.Context(), membership.OrgID, membership.UserID, c.Request.Body)
+	if err != nil {
+		return err
+	}
+	return c.Write(result)
+}
+
+func (r resource) export(c *routing.Context) error {
+	c.Response.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	c.Response.Header().Set("Content-Disposition", `attachment; filename="suppressions.csv"`)
+	if err := r.service.Export(c.Request.Context(), currentMembership(c).OrgID, c.Response); err != nil {
+		// the response has already started, so the error can only be logged
+		r.logger.With(c.Request.Context()).Errorf("failed to export suppressions: %v", err)
+	}
+	return nil
+}
+
+// currentMembership returns the membership of the current user in the active organization.
+func currentMembership(c *routing.Context) entity.Membership {
+	membership, _ := auth.CurrentMembership(c.Request.Context())
+	return membership
+}
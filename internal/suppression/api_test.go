@@ -0,0 +1,52 @@
+package suppression
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/garaekz/gonvelope/internal/auth"
+	"github.com/garaekz/gonvelope/internal/entity"
+	"github.com/garaekz/gonvelope/internal/org"
+	"github.com/garaekz/gonvelope/internal/test"
+	"github.com/garaekz/gonvelope/pkg/log"
+)
+
+func TestAPI(t *testing.T) {
+	logger, _ := log.NewForTest()
+	router := test.MockRouter(logger)
+	repo := &mockRepository{items: []entity.Suppression{
+		{OrgID: "100", Email: "jane@example.com", Reason: entity.SuppressionBounced, Source: entity.SuppressionSourceBounce, CreatedAt: time.Now()},
+		{OrgID: "org1", Email: "other@example.com", Reason: entity.SuppressionManual, Source: entity.SuppressionSourceAPI, CreatedAt: time.Now()},
+	}}
+	service := NewService(repo, mockTransactional, "key", "https://app.example.com", &mockRecorder{}, logger)
+	RegisterHandlers(router.Group("/"), service, auth.MockAuthHandler, org.MockHandler, logger)
+	header := auth.MockAuthHeader()
+	token := strings.TrimPrefix(service.UnsubscribeURL("100", "john@example.com"), "https://app.example.com/api/v1/unsubscribe/")
+
+	tests := []test.APITestCase{
+		{Name: "get all", Method: "GET", URL: "/suppressions", Header: header, WantStatus: http.StatusOK, WantResponse: `*"total_count":1*`},
+		{Name: "search", Method: "GET", URL: "/suppressions?search=john", Header: header, WantStatus: http.StatusOK, WantResponse: `*"total_count":0*`},
+		{Name: "get", Method: "GET", URL: "/suppressions/jane@example.com", Header: header, WantStatus: http.StatusOK, WantResponse: `*"reason":"bounced"*`},
+		{Name: "get other org", Method: "GET", URL: "/suppressions/other@example.com", Header: header, WantStatus: http.StatusNotFound},
+		{Name: "add", Method: "POST", URL: "/suppressions", Body: `{"email":"Joe@example.com","reason":"complained"}`, Header: header, WantStatus: http.StatusCreated, WantResponse: `*"email":"joe@example.com"*`},
+		{Name: "add duplicate", Method: "POST", URL: "/suppressions", Body: `{"email":"joe@example.com"}`, Header: header, WantStatus: http.StatusConflict},
+		{Name: "add invalid", Method: "POST", URL: "/suppressions", Body: `{"email":"joe"}`, Header: header, WantStatus: http.StatusBadRequest},
+		{Name: "add input error", Method: "POST", URL: "/suppressions", Body: `"email"}`, Header: header, WantStatus: http.StatusBadRequest},
+		{Name: "import", Method: "POST", URL: "/suppressions/import", Body: "email\nann@example.com\njane@example.com\nann\n", Header: header, WantStatus: http.StatusOK, WantResponse: `*"added":1,"skipped":1,"invalid":1*`},
+		{Name: "export", Method: "GET", URL: "/suppressions/export", Header: header, WantStatus: http.StatusOK, WantResponse: "*ann@example.com,manual,import,*"},
+		{Name: "remove", Method: "DELETE", URL: "/suppressions/joe@example.com", Header: header, WantStatus: http.StatusOK},
+		{Name: "remove unknown", Method: "DELETE", URL: "/suppressions/joe@example.com", Header: header, WantStatus: http.StatusNotFound},
+		{Name: "viewer read", Method: "GET", URL: "/suppressions", Header: org.MockHeader(entity.RoleViewer), WantStatus: http.StatusOK},
+		{Name: "sender write", Method: "POST", URL: "/suppressions", Body: `{"email":"joe@example.com"}`, Header: org.MockHeader(entity.RoleSender), WantStatus: http.StatusForbidden},
+		{Name: "unauthorized", Method: "GET", URL: "/suppressions", WantStatus: http.StatusUnauthorized},
+		{Name: "unsubscribe info", Method: "GET", URL: "/unsubscribe/" + token, WantStatus: http.StatusOK, WantResponse: `{"email":"john@example.com","unsubscribed":false}`},
+		{Name: "unsubscribe", Method: "POST", URL: "/unsubscribe/" + token, Body: "List-Unsubscribe=One-Click", WantStatus: http.StatusOK, WantResponse: `{"email":"john@example.com","unsubscribed":true}`},
+		{Name: "unsubscribe verify", Method: "GET", URL: "/suppressions/john@example.com", Header: header, WantStatus: http.StatusOK, WantResponse: `*"source":"unsubscribe"*`},
+		{Name: "unsubscribe invalid", Method: "POST", URL: "/unsubscribe/" + token + "x", WantStatus: http.StatusNotFound},
+	}
+	for _, tc := range tests {
+		test.Endpoint(t, router, tc)
+	}
+}
@@ -0,0 +1,121 @@
+package suppression
+
+import (
+	"context"
+
+	"github.com/garaekz/gonvelope/internal/entity"
+	"github.com/garaekz/gonvelope/pkg/dbcontext"
+	"github.com/garaekz/gonvelope/pkg/log"
+	dbx "github.com/go-ozzo/ozzo-dbx"
+)
+
+// Repository encapsulates the logic to access suppressed addresses from the data source.
+type Repository interface {
+	// Get returns the suppressed address of the organization.
+	Get(ctx context.Context, orgID, email string) (entity.Suppression, error)
+	// Count returns the number of suppressed addresses of the organization containing the search term.
+	Count(ctx context.Context, orgID, search string) (int, error)
+	// Query returns the suppressed addresses of the organization containing the search term
+	// with the given offset and limit, newest first.
+	Query(ctx context.Context, orgID, search string, offset, limit int) ([]entity.Suppression, error)
+	// Create saves a suppressed address unless the organization already suppresses it,
+	// and returns whether it was saved.
+	Create(ctx context.Context, suppression entity.Suppression) (bool, error)
+	// Delete removes the suppressed address of the organization.
+	Delete(ctx context.Context, orgID, email string) error
+	// Find returns the given addresses that the organization suppresses.
+	Find(ctx context.Context, orgID string, emails []string) ([]string, error)
+}
+
+// repository persists suppressed addresses in database
+type repository struct {
+	db     *dbcontext.DB
+	logger log.Logger
+}
+
+// NewRepository creates a new suppression repository
+func NewRepository(db *dbcontext.DB, logger log.Logger) Repository {
+	return repository{db, logger}
+}
+
+// Get returns the suppressed address of the organization.
+func (r repository) Get(ctx context.Context, orgID, email string) (entity.Suppression, error) {
+	var suppression entity.Suppression
+	err := r.db.With(ctx).Select().From(suppression.TableName()).
+		Where(dbx.HashExp{"org_id": orgID, "email": email}).One(&suppression)
+	return suppression, err
+}
+
+// Count returns the number of suppressed addresses of the organization containing the search term.
+func (r repository) Count(ctx context.Context, orgID, search string) (int, error) {
+	var count int
+	err := r.db.With(ctx).Select("COUNT(*)").From(entity.Suppression{}.TableName()).
+		Where(searchExp(orgID, search)).Row(&count)
+	return count, err
+}
+
+// Query returns the suppressed addresses of the organization containing the search term
+// with the given offset and limit, newest first.
+func (r repository) Query(ctx context.Context, orgID, search string, offset, limit int) ([]entity.Suppression, error) {
+	var suppressions []entity.Suppression
+	err := r.db.With(ctx).Select().From(entity.Suppression{}.TableName()).
+		Where(searchExp(orgID, search)).
+		OrderBy("created_at DESC", "email").
+		Offset(int64(offset)).
+		Limit(int64(limit)).
+		All(&suppressions)
+	return suppressions, err
+}
+
+// Create saves a suppressed address unless the organization already suppresses it,
+// and returns whether it was saved.
+func (r repository) Create(ctx context.Context, suppression entity.Suppression) (bool, error) {
+	result, err := r.db.With(ctx).NewQuery(`
+		INSERT INTO suppressions (org_id, email, reason, source, created_at)
+		VALUES ({:org}, {:email}, {:reason}, {:source}, {:created})
+		ON CONFLICT (org_id, email) DO NOTHING`).
+		Bind(dbx.Params{
+			"org":     suppression.OrgID,
+			"email":   suppression.Email,
+			"reason":  suppression.Reason,
+			"source":  suppression.Source,
+			"created": suppression.CreatedAt,
+		}).Execute()
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	return rows > 0, err
+}
+
+// Delete removes the suppressed address of the organization.
+func (r repository) Delete(ctx context.Context, orgID, email string) error {
+	_, err := r.db.With(ctx).Delete(entity.Suppression{}.TableName(), dbx.HashExp{"org_id": orgID, "email": email}).Execute()
+	return err
+}
+
+// Find returns the given addresses that the organization suppresses.
+func (r repository) Find(ctx context.Context, orgID string, emails []string) ([]string, error) {
+	if len(emails) == 0 {
+		return nil, nil
+	}
+	values := make([]interface{}, len(emails))
+	for i, email := range emails {
+		values[i] = email
+	}
+	var found []string
+	err := r.db.With(ctx).Select("email").From(entity.Suppression{}.TableName()).
+		Where(dbx.HashExp{"org_id": orgID}).
+		AndWhere(dbx.In("email", values...)).
+		OrderBy("email").
+		Column(&found)
+	return found, err
+}
+
+// searchExp selects the suppressed addresses of the organization containing the search term, if any.
+func searchExp(orgID, search string) dbx.Expression {
+	if search == "" {
+		return dbx.HashExp{"org_id": orgID}
+	}
+	return dbx.And(dbx.HashExp{"org_id": orgID}, dbx.Like("email", search))
+}
@@ -0,0 +1,363 @@
+// Package suppression keeps track of the addresses each organization must not send messages to,
+// and lets recipients unsubscribe through signed links.
+package suppression
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/garaekz/gonvelope/internal/audit"
+	"github.com/garaekz/gonvelope/internal/entity"
+	"github.com/garaekz/gonvelope/internal/errors"
+	"github.com/garaekz/gonvelope/pkg/dbcontext"
+	"github.com/garaekz/gonvelope/pkg/log"
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/go-ozzo/ozzo-validation/v4/is"
+)
+
+const (
+	// exportBatchSize is the number of suppressed addresses loaded at once while exporting.
+	exportBatchSize = 500
+	// maxImportErrors is the maximum number of invalid lines reported by an import.
+	maxImportErrors = 100
+)
+
+// csvHeader lists the columns of the CSV export. Imports expect the same columns, of which only email is required.
+var csvHeader = []string{"email", "reason", "source", "created_at"}
+
+// Service encapsulates usecase logic for suppression lists.
+type Service interface {
+	Get(ctx context.Context, orgID, email string) (entity.Suppression, error)
+	Query(ctx context.Context, orgID, search string, offset, limit int) ([]entity.Suppression, error)
+	Count(ctx context.Context, orgID, search string) (int, error)
+	// Add suppresses an address on behalf of a member of the organization.
+	Add(ctx context.Context, orgID, userID string, req AddRequest) (entity.Suppression, error)
+	// Remove allows sending messages to a suppressed address again.
+	Remove(ctx context.Context, orgID, userID, email string) (entity.Suppression, error)
+	// Import suppresses the addresses listed in a CSV file.
+	Import(ctx context.Context, orgID, userID string, r io.Reader) (ImportResult, error)
+	// Export writes the suppression list of the organization to w as CSV, newest first.
+	Export(ctx context.Context, orgID string, w io.Writer) error
+	// Suppress adds an address to the suppression list of the organization on behalf of the system,
+	// and returns whether it was not suppressed yet.
+	Suppress(ctx context.Context, orgID, email, reason, source string) (bool, error)
+	// Suppressed returns the given addresses the organization must not send messages to.
+	Suppressed(ctx context.Context, orgID string, emails []string) ([]string, error)
+	// UnsubscribeURL returns the link the recipient can use to unsubscribe from the messages of the organization.
+	UnsubscribeURL(orgID, email string) string
+	// Recipient returns the address identified by an unsubscribe token.
+	Recipient(token string) (string, error)
+	// Unsubscribe suppresses the address identified by an unsubscribe token.
+	Unsubscribe(ctx context.Context, token string) error
+}
+
+// AddRequest represents a request to suppress an address.
+type AddRequest struct {
+	Email  string `json:"email"`
+	Reason string `json:"reason"`
+}
+
+// Validate validates the AddRequest fields.
+func (m AddRequest) Validate() error {
+	return validation.ValidateStruct(&m,
+		validation.Field(&m.Email, validation.Required, validation.Length(0, 254), is.EmailFormat),
+		validation.Field(&m.Reason, validation.In(stringsToInterfaces(entity.SuppressionReasons)...)),
+	)
+}
+
+// ImportResult reports the outcome of an import.
+type ImportResult struct {
+	// Added is the number of addresses added to the suppression list.
+	Added int `json:"added"`
+	// Skipped is the number of addresses that were already suppressed.
+	Skipped int `json:"skipped"`
+	// Invalid is the number of lines that could not be imported.
+	Invalid int `json:"invalid"`
+	// Errors describes the first invalid lines.
+	Errors []ImportError `json:"errors"`
+}
+
+// ImportError describes a line that could not be imported.
+type ImportError struct {
+	Line  int    `json:"line"`
+	Error string `json:"error"`
+}
+
+type service struct {
+	repo          Repository
+	transactional dbcontext.TransactionFunc
+	signingKey    string
+	appURL        string
+	auditor       audit.Recorder
+	logger        log.Logger
+}
+
+// NewService creates a new suppression service. Unsubscribe links are signed with signingKey.
+func NewService(repo Repository, transactional dbcontext.TransactionFunc, signingKey, appURL string, auditor audit.Recorder, logger log.Logger) Service {
+	return service{repo, transactional, signingKey, appURL, auditor, logger}
+}
+
+// Get returns the suppressed address of the organization.
+func (s service) Get(ctx context.Context, orgID, email string) (entity.Suppression, error) {
+	return s.repo.Get(ctx, orgID, normalize(email))
+}
+
+// Query returns the suppressed addresses of the organization containing the search term
+// with the specified offset and limit.
+func (s service) Query(ctx context.Context, orgID, search string, offset, limit int) ([]entity.Suppression, error) {
+	return s.repo.Query(ctx, orgID, normalize(search), offset, limit)
+}
+
+// Count returns the number of suppressed addresses of the organization containing the search term.
+func (s service) Count(ctx context.Context, orgID, search string) (int, error) {
+	return s.repo.Count(ctx, orgID, normalize(search))
+}
+
+// Add suppresses an address on behalf of a member of the organization. The reason defaults to manual.
+func (s service) Add(ctx context.Context, orgID, userID string, req AddRequest) (entity.Suppression, error) {
+	req.Email = normalize(req.Email)
+	if err := req.Validate(); err != nil {
+		return entity.Suppression{}, err
+	}
+	if req.Reason == "" {
+		req.Reason = entity.SuppressionManual
+	}
+	suppression := entity.Suppression{
+		OrgID:     orgID,
+		Email:     req.Email,
+		Reason:    req.Reason,
+		Source:    entity.SuppressionSourceAPI,
+		CreatedAt: time.Now(),
+	}
+	err := s.transactional(ctx, func(ctx context.Context) error {
+		added, err := s.repo.Create(ctx, suppression)
+		if err != nil {
+			return err
+		}
+		if !added {
+			return errors.Conflict("The address is already suppressed")
+		}
+		return s.record(ctx, audit.ActionSuppressionAdded, orgID, userID, suppression)
+	})
+	return suppression, err
+}
+
+// Remove allows sending messages to a suppressed address again.
+func (s service) Remove(ctx context.Context, orgID, userID, email string) (entity.Suppression, error) {
+	suppression, err := s.Get(ctx, orgID, email)
+	if err != nil {
+		return entity.Suppression{}, err
+	}
+	err = s.transactional(ctx, func(ctx context.Context) error {
+		if err := s.repo.Delete(ctx, orgID, suppression.Email); err != nil {
+			return err
+		}
+		return s.record(ctx, audit.ActionSuppressionRemoved, orgID, userID, suppression)
+	})
+	return suppression, err
+}
+
+// Import suppresses the addresses listed in a CSV file whose header names an email column and,
+// optionally, a reason column. Valid lines are imported even when others are invalid.
+func (s service) Import(ctx context.Context, orgID, userID string, r io.Reader) (ImportResult, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err != nil {
+		return ImportResult{}, errors.BadRequest("The file must be a CSV file with a header line")
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		// spreadsheet applications may start the file with a byte order mark
+		columns[strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))] = i
+	}
+	emailColumn, ok := columns["email"]
+	if !ok {
+		return ImportResult{}, errors.BadRequest("The file must have an email column")
+	}
+	reasonColumn, hasReason := columns["reason"]
+
+	result := ImportResult{Errors: []ImportError{}}
+	invalid := func(line int, err error) {
+		result.Invalid++
+		if len(result.Errors) < maxImportErrors {
+			result.Errors = append(result.Errors, ImportError{Line: line, Error: err.Error()})
+		}
+	}
+	now := time.Now()
+	err = s.transactional(ctx, func(ctx context.Context) error {
+		for {
+			record, err := reader.Read()
+			if err == io.EOF {
+				break
+			}
+			if parseErr, ok := err.(*csv.ParseError); ok {
+				invalid(parseErr.Line, parseErr.Err)
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			line, _ := reader.FieldPos(0)
+			req := AddRequest{Email: field(record, emailColumn)}
+			if hasReason {
+				req.Reason = field(record, reasonColumn)
+			}
+			if err := req.Validate(); err != nil {
+				invalid(line, err)
+				continue
+			}
+			if req.Reason == "" {
+				req.Reason = entity.SuppressionManual
+			}
+			added, err := s.repo.Create(ctx, entity.Suppression{
+				OrgID:     orgID,
+				Email:     normalize(req.Email),
+				Reason:    req.Reason,
+				Source:    entity.SuppressionSourceImport,
+				CreatedAt: now,
+			})
+			if err != nil {
+				return err
+			}
+			if added {
+				result.Added++
+			} else {
+				result.Skipped++
+			}
+		}
+		return s.auditor.Record(ctx, audit.Event{
+			Action:     audit.ActionSuppressionsImported,
+			OrgID:      orgID,
+			ActorID:    userID,
+			TargetType: "suppression_list",
+			TargetID:   orgID,
+			Data:       map[string]interface{}{"added": result.Added, "skipped": result.Skipped, "invalid": result.Invalid},
+		})
+	})
+	if err != nil {
+		return ImportResult{}, err
+	}
+	return result, nil
+}
+
+// Export writes the suppression list of the organization to w as CSV, newest first.
+// Addresses are loaded in batches so that large exports do not have to fit in memory.
+func (s service) Export(ctx context.Context, orgID string, w io.Writer) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(csvHeader); err != nil {
+		return err
+	}
+	for offset := 0; ; offset += exportBatchSize {
+		suppressions, err := s.repo.Query(ctx, orgID, "", offset, exportBatchSize)
+		if err != nil {
+			return err
+		}
+		for _, item := range suppressions {
+			record := []string{item.Email, item.Reason, item.Source, item.CreatedAt.UTC().Format(time.RFC3339)}
+			if err := writer.Write(record); err != nil {
+				return err
+			}
+		}
+		writer.Flush()
+		if err := writer.Error(); err != nil {
+			return err
+		}
+		if len(suppressions) < exportBatchSize {
+			return nil
+		}
+	}
+}
+
+// Suppress adds an address to the suppression list of the organization on behalf of the system,
+// and returns whether it was not suppressed yet. An address already suppressed keeps its original reason.
+func (s service) Suppress(ctx context.Context, orgID, email, reason, source string) (bool, error) {
+	suppression := entity.Suppression{
+		OrgID:     orgID,
+		Email:     normalize(email),
+		Reason:    reason,
+		Source:    source,
+		CreatedAt: time.Now(),
+	}
+	var added bool
+	err := s.transactional(ctx, func(ctx context.Context) (err error) {
+		if added, err = s.repo.Create(ctx, suppression); err != nil || !added {
+			return err
+		}
+		return s.record(ctx, audit.ActionSuppressionAdded, orgID, "", suppression)
+	})
+	return added, err
+}
+
+// Suppressed returns the given addresses the organization must not send messages to, in lower case.
+func (s service) Suppressed(ctx context.Context, orgID string, emails []string) ([]string, error) {
+	normalized := make([]string, len(emails))
+	for i, email := range emails {
+		normalized[i] = normalize(email)
+	}
+	return s.repo.Find(ctx, orgID, normalized)
+}
+
+// UnsubscribeURL returns the link the recipient can use to unsubscribe from the messages of the organization.
+func (s service) UnsubscribeURL(orgID, email string) string {
+	return fmt.Sprintf("%s/api/v1/unsubscribe/%s", s.appURL, signToken(s.signingKey, orgID, normalize(email)))
+}
+
+// Recipient returns the address identified by an unsubscribe token.
+func (s service) Recipient(token string) (string, error) {
+	_, email, err := parseToken(s.signingKey, token)
+	if err != nil {
+		return "", errors.NotFound("")
+	}
+	return email, nil
+}
+
+// Unsubscribe suppresses the address identified by an unsubscribe token.
+// Unsubscribing twice is not an error.
+func (s service) Unsubscribe(ctx context.Context, token string) error {
+	orgID, email, err := parseToken(s.signingKey, token)
+	if err != nil {
+		return errors.NotFound("")
+	}
+	_, err = s.Suppress(ctx, orgID, email, entity.SuppressionUnsubscribed, entity.SuppressionSourceUnsubscribe)
+	return err
+}
+
+// record records a change to the suppression list of the organization in the audit log.
+func (s service) record(ctx context.Context, action, orgID, userID string, suppression entity.Suppression) error {
+	return s.auditor.Record(ctx, audit.Event{
+		Action:     action,
+		OrgID:      orgID,
+		ActorID:    userID,
+		TargetType: "suppression",
+		TargetID:   suppression.Email,
+		Data:       map[string]interface{}{"reason": suppression.Reason, "source": suppression.Source},
+	})
+}
+
+// normalize returns the canonical form of an address, in which it is stored.
+func normalize(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// field returns the value of the given column of the record, or an empty string if the record is too short.
+func field(record []string, column int) string {
+	if column < len(record) {
+		return strings.TrimSpace(record[column])
+	}
+	return ""
+}
+
+// stringsToInterfaces converts a list of strings for use with validation.In.
+func stringsToInterfaces(values []string) []interface{} {
+	result := make([]interface{}, len(values))
+	for i, value := range values {
+		result[i] = value
+	}
+	return result
+}
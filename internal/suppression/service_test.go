@@ -0,0 +1,258 @@
+package suppression
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"net/http"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/garaekz/gonvelope/internal/audit"
+	"github.com/garaekz/gonvelope/internal/entity"
+	"github.com/garaekz/gonvelope/internal/errors"
+	"github.com/garaekz/gonvelope/pkg/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAddRequest_Validate(t *testing.T) {
+	tests := []struct {
+		name      string
+		model     AddRequest
+		wantError bool
+	}{
+		{"success", AddRequest{Email: "jane@example.com"}, false},
+		{"with reason", AddRequest{Email: "jane@example.com", Reason: entity.SuppressionComplained}, false},
+		{"invalid email", AddRequest{Email: "jane"}, true},
+		{"invalid reason", AddRequest{Email: "jane@example.com", Reason: "bored"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.wantError, tt.model.Validate() != nil)
+		})
+	}
+}
+
+func TestToken(t *testing.T) {
+	token := signToken("key", "org1", "jane@example.com")
+	orgID, email, err := parseToken("key", token)
+	require.Nil(t, err)
+	assert.Equal(t, "org1", orgID)
+	assert.Equal(t, "jane@example.com", email)
+
+	_, _, err = parseToken("other key", token)
+	assert.Equal(t, errInvalidToken, err)
+	forged := signToken("key", "org2", "jane@example.com")
+	_, _, err = parseToken("key", strings.Split(forged, ".")[0]+"."+strings.Split(token, ".")[1])
+	assert.Equal(t, errInvalidToken, err)
+	_, _, err = parseToken("key", "garbage")
+	assert.Equal(t, errInvalidToken, err)
+}
+
+func Test_service_CRUD(t *testing.T) {
+	logger, _ := log.NewForTest()
+	auditor := &mockRecorder{}
+	s := NewService(&mockRepository{}, mockTransactional, "key", "https://app.example.com", auditor, logger)
+	ctx := context.Background()
+
+	suppression, err := s.Add(ctx, "org1", "100", AddRequest{Email: " Jane@Example.com "})
+	require.Nil(t, err)
+	assert.Equal(t, "jane@example.com", suppression.Email)
+	assert.Equal(t, entity.SuppressionManual, suppression.Reason)
+	assert.Equal(t, entity.SuppressionSourceAPI, suppression.Source)
+
+	_, err = s.Add(ctx, "org1", "100", AddRequest{Email: "jane@example.com"})
+	assert.Equal(t, http.StatusConflict, err.(errors.ErrorResponse).StatusCode())
+	_, err = s.Add(ctx, "org1", "100", AddRequest{Email: "jane"})
+	assert.NotNil(t, err)
+
+	suppressed, err := s.Suppressed(ctx, "org1", []string{"JANE@example.com", "john@example.com"})
+	require.Nil(t, err)
+	assert.Equal(t, []string{"jane@example.com"}, suppressed)
+	suppressed, _ = s.Suppressed(ctx, "org2", []string{"jane@example.com"})
+	assert.Empty(t, suppressed)
+
+	count, _ := s.Count(ctx, "org1", "JANE")
+	assert.Equal(t, 1, count)
+	count, _ = s.Count(ctx, "org1", "john")
+	assert.Equal(t, 0, count)
+
+	_, err = s.Remove(ctx, "org2", "100", "jane@example.com")
+	assert.Equal(t, sql.ErrNoRows, err)
+	_, err = s.Remove(ctx, "org1", "100", "Jane@example.com")
+	require.Nil(t, err)
+	count, _ = s.Count(ctx, "org1", "")
+	assert.Equal(t, 0, count)
+
+	if assert.Len(t, auditor.events, 2) {
+		assert.Equal(t, audit.ActionSuppressionAdded, auditor.events[0].Action)
+		assert.Equal(t, audit.ActionSuppressionRemoved, auditor.events[1].Action)
+		assert.Equal(t, "jane@example.com", auditor.events[1].TargetID)
+	}
+}
+
+func Test_service_Import(t *testing.T) {
+	logger, _ := log.NewForTest()
+	repo := &mockRepository{items: []entity.Suppression{{OrgID: "org1", Email: "known@example.com", Reason: entity.SuppressionBounced}}}
+	auditor := &mockRecorder{}
+	s := NewService(repo, mockTransactional, "key", "https://app.example.com", auditor, logger)
+	ctx := context.Background()
+
+	file := "\ufeffEmail,Reason\n" +
+		"jane@example.com,unsubscribed\n" +
+		"known@example.com,\n" +
+		"invalid,\n" +
+		"john@example.com,bored\n" +
+		"JOE@example.com\n"
+	result, err := s.Import(ctx, "org1", "100", strings.NewReader(file))
+	require.Nil(t, err)
+	assert.Equal(t, 2, result.Added)
+	assert.Equal(t, 1, result.Skipped)
+	assert.Equal(t, 2, result.Invalid)
+	if assert.Len(t, result.Errors, 2) {
+		assert.Equal(t, 4, result.Errors[0].Line)
+		assert.Equal(t, 5, result.Errors[1].Line)
+	}
+
+	jane, err := s.Get(ctx, "org1", "jane@example.com")
+	require.Nil(t, err)
+	assert.Equal(t, entity.SuppressionUnsubscribed, jane.Reason)
+	assert.Equal(t, entity.SuppressionSourceImport, jane.Source)
+	joe, err := s.Get(ctx, "org1", "joe@example.com")
+	require.Nil(t, err)
+	assert.Equal(t, entity.SuppressionManual, joe.Reason)
+
+	if assert.Len(t, auditor.events, 1) {
+		assert.Equal(t, audit.ActionSuppressionsImported, auditor.events[0].Action)
+	}
+
+	_, err = s.Import(ctx, "org1", "100", strings.NewReader("address\njane@example.com\n"))
+	assert.Equal(t, http.StatusBadRequest, err.(errors.ErrorResponse).StatusCode())
+	_, err = s.Import(ctx, "org1", "100", strings.NewReader(""))
+	assert.Equal(t, http.StatusBadRequest, err.(errors.ErrorResponse).StatusCode())
+}
+
+func Test_service_Export(t *testing.T) {
+	logger, _ := log.NewForTest()
+	repo := &mockRepository{}
+	for i := 0; i < exportBatchSize+1; i++ {
+		repo.items = append(repo.items, entity.Suppression{OrgID: "org1", Email: strings.Repeat("a", i+1) + "@example.com", Reason: entity.SuppressionManual})
+	}
+	repo.items = append(repo.items, entity.Suppression{OrgID: "org2", Email: "other@example.com"})
+	s := NewService(repo, mockTransactional, "key", "https://app.example.com", &mockRecorder{}, logger)
+
+	var buf bytes.Buffer
+	require.Nil(t, s.Export(context.Background(), "org1", &buf))
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Equal(t, "email,reason,source,created_at", lines[0])
+	assert.Len(t, lines, exportBatchSize+2)
+	assert.NotContains(t, buf.String(), "other@example.com")
+}
+
+func Test_service_Unsubscribe(t *testing.T) {
+	logger, _ := log.NewForTest()
+	auditor := &mockRecorder{}
+	s := NewService(&mockRepository{}, mockTransactional, "key", "https://app.example.com", auditor, logger)
+	ctx := context.Background()
+
+	link := s.UnsubscribeURL("org1", "Jane@example.com")
+	require.True(t, strings.HasPrefix(link, "https://app.example.com/api/v1/unsubscribe/"))
+	token := strings.TrimPrefix(link, "https://app.example.com/api/v1/unsubscribe/")
+
+	email, err := s.Recipient(token)
+	require.Nil(t, err)
+	assert.Equal(t, "jane@example.com", email)
+
+	require.Nil(t, s.Unsubscribe(ctx, token))
+	// unsubscribing twice is not an error
+	require.Nil(t, s.Unsubscribe(ctx, token))
+	suppression, err := s.Get(ctx, "org1", "jane@example.com")
+	require.Nil(t, err)
+	assert.Equal(t, entity.SuppressionUnsubscribed, suppression.Reason)
+	assert.Equal(t, entity.SuppressionSourceUnsubscribe, suppression.Source)
+	if assert.Len(t, auditor.events, 1) {
+		assert.Empty(t, auditor.events[0].ActorID)
+	}
+
+	err = s.Unsubscribe(ctx, token+"x")
+	assert.Equal(t, http.StatusNotFound, err.(errors.ErrorResponse).StatusCode())
+}
+
+func mockTransactional(ctx context.Context, f func(ctx context.Context) error) error {
+	return f(ctx)
+}
+
+type mockRecorder struct {
+	events []audit.Event
+}
+
+func (m *mockRecorder) Record(_ context.Context, event audit.Event) error {
+	m.events = append(m.events, event)
+	return nil
+}
+
+type mockRepository struct {
+	items []entity.Suppression
+}
+
+func (m *mockRepository) Get(_ context.Context, orgID, email string) (entity.Suppression, error) {
+	for _, item := range m.items {
+		if item.OrgID == orgID && item.Email == email {
+			return item, nil
+		}
+	}
+	return entity.Suppression{}, sql.ErrNoRows
+}
+
+func (m *mockRepository) Count(ctx context.Context, orgID, search string) (int, error) {
+	items, err := m.Query(ctx, orgID, search, 0, len(m.items))
+	return len(items), err
+}
+
+func (m *mockRepository) Query(_ context.Context, orgID, search string, offset, limit int) ([]entity.Suppression, error) {
+	var items []entity.Suppression
+	for _, item := range m.items {
+		if item.OrgID == orgID && strings.Contains(item.Email, search) {
+			items = append(items, item)
+		}
+	}
+	if offset > len(items) {
+		return nil, nil
+	}
+	items = items[offset:]
+	if limit < len(items) {
+		items = items[:limit]
+	}
+	return items, nil
+}
+
+func (m *mockRepository) Create(_ context.Context, suppression entity.Suppression) (bool, error) {
+	if _, err := m.Get(context.Background(), suppression.OrgID, suppression.Email); err == nil {
+		return false, nil
+	}
+	m.items = append(m.items, suppression)
+	return true, nil
+}
+
+func (m *mockRepository) Delete(_ context.Context, orgID, email string) error {
+	for i, item := range m.items {
+		if item.OrgID == orgID && item.Email == email {
+			m.items = append(m.items[:i], m.items[i+1:]...)
+			return nil
+		}
+	}
+	return nil
+}
+
+func (m *mockRepository) Find(_ context.Context, orgID string, emails []string) ([]string, error) {
+	var found []string
+	for _, email := range emails {
+		if _, err := m.Get(context.Background(), orgID, email); err == nil {
+			found = append(found, email)
+		}
+	}
+	sort.Strings(found)
+	return found, nil
+}
@@ -0,0 +1,43 @@
+package suppression
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strings"
+)
+
+// errInvalidToken is returned when an unsubscribe token is malformed or its signature does not match.
+var errInvalidToken = errors.New("invalid unsubscribe token")
+
+// signToken returns an unsubscribe token identifying the recipient within the organization.
+// Tokens do not expire: recipients must be able to unsubscribe from any message they ever received.
+func signToken(signingKey, orgID, email string) string {
+	payload := base64.RawURLEncoding.EncodeToString([]byte(orgID + "\n" + email))
+	return payload + "." + signature(signingKey, payload)
+}
+
+// parseToken verifies the unsubscribe token and returns the organization and the recipient it identifies.
+func parseToken(signingKey, token string) (orgID, email string, err error) {
+	payload, sig, ok := strings.Cut(token, ".")
+	if !ok || !hmac.Equal([]byte(sig), []byte(signature(signingKey, payload))) {
+		return "", "", errInvalidToken
+	}
+	decoded, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return "", "", errInvalidToken
+	}
+	orgID, email, ok = strings.Cut(string(decoded), "\n")
+	if !ok || orgID == "" || email == "" {
+		return "", "", errInvalidToken
+	}
+	return orgID, email, nil
+}
+
+// signature signs the token payload with the signing key.
+func signature(signingKey, payload string) string {
+	mac := hmac.New(sha256.New, []byte(signingKey))
+	mac.Write([]byte("unsubscribe:" + payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
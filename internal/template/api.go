@@ -0,0 +1,98 @@
+package template
+
+import (
+	"net/http"
+
+	"github.com/garaekz/gonvelope/internal/auth"
+	"github.com/garaekz/gonvelope/internal/entity"
+	"github.com/garaekz/gonvelope/internal/errors"
+	"github.com/garaekz/gonvelope/pkg/log"
+	"github.com/garaekz/gonvelope/pkg/pagination"
+	routing "github.com/garaekz/ozzo-routing"
+)
+
+// RegisterHandlers sets up the routing of the HTTP handlers.
+// Templates belong to the organization made active by orgHandler.
+func RegisterHandlers(r *routing.RouteGroup, service Service, authHandler, orgHandler routing.Handler, logger log.Logger) {
+	res := resource{service, logger}
+
+	r.Use(authHandler, orgHandler)
+
+	r.Get("templates", auth.Require(auth.ScopeTemplatesRead), res.query)
+	r.Get("templates/<id>", auth.Require(auth.ScopeTemplatesRead), res.get)
+	r.Post("templates", auth.Require(auth.ScopeTemplatesWrite), res.create)
+	r.Put("templates/<id>", auth.Require(auth.ScopeTemplatesWrite), res.update)
+	r.Delete("templates/<id>", auth.Require(auth.ScopeTemplatesWrite), res.delete)
+}
+
+type resource struct {
+	service Service
+	logger  log.Logger
+}
+
+func (r resource) get(c *routing.Context) error {
+	template, err := r.service.Get(c.Request.Context(), currentMembership(c).OrgID, c.Param("id"))
+	if err != nil {
+		return err
+	}
+	return c.Write(template)
+}
+
+func (r resource) query(c *routing.Context) error {
+	ctx := c.Request.Context()
+	orgID := currentMembership(c).OrgID
+	count, err := r.service.Count(ctx, orgID)
+	if err != nil {
+		return err
+	}
+	pages := pagination.NewFromRequest(c.Request, count)
+	templates, err := r.service.Query(ctx, orgID, pages.Offset(), pages.Limit())
+	if err != nil {
+		return err
+	}
+	pages.Items = templates
+	return c.Write(pages)
+}
+
+func (r resource) create(c *routing.Context) error {
+	var input TemplateRequest
+	if err := c.Read(&input); err != nil {
+		r.logger.With(c.Request.Context()).Info(err)
+		return errors.BadRequest("")
+	}
+	membership := currentMembership(c)
+	template, err := r.service.Create(c.Request.Context(), membership.OrgID, membership.UserID, input)
+	if err != nil {
+		return err
+	}
+	return c.WriteWithStatus(template, http.StatusCreated)
+}
+
+func (r resource) update(c *routing.Context) error {
+	var input TemplateRequest
+	if err := c.Read(&input); err != nil {
+		r.logger.With(c.Request.Context()).Info(err)
+		return errors.BadRequest("")
+	}
+	membership := currentMembership(c)
+	template, err := r.service.Update(c.Request.Context(), membership.OrgID, membership.UserID, c.Param("id"), input)
+	if err != nil {
+		return err
+	}
+	return c.Write(template)
+}
+
+func (r resource) delete(c *routing.Context) error {
+	membership := currentMembership(c)
+	template, err := r.service.Delete(c.Request.Context(), membership.OrgID, membership.UserID, c.Param("id"))
+	if err != nil {
+		return err
+	}
+	return c.Write(template)
+}
+
+// currentMembership returns the membership of the current user in the active organization.
+func currentMembership(c *routing.Context) entity.Membership {
+	membership, _ := auth.CurrentMembership(c.Request.Context())
+	return membership
+}
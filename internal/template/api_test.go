@@ -0,0 +1,82 @@
+package template
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/garaekz/gonvelope/internal/auth"
+	"github.com/garaekz/gonvelope/internal/entity"
+	"github.com/garaekz/gonvelope/internal/org"
+	"github.com/garaekz/gonvelope/internal/test"
+	"github.com/garaekz/gonvelope/pkg/log"
+)
+
+func TestAPI(t *testing.T) {
+	logger, _ := log.NewForTest()
+	router := test.MockRouter(logger)
+	now := time.Now()
+	repo := &mockRepository{items: []entity.Template{
+		{ID: "123", OrgID: "100", UserID: "100", Name: "welcome", Subject: "Welcome", Body: "Hi", FromEmail: "hello@example.com", CreatedAt: &now, UpdatedAt: &now},
+		{ID: "456", OrgID: "org1", UserID: "200", Name: "invoice", Subject: "Invoice", Body: "Due", FromEmail: "billing@example.com", CreatedAt: &now, UpdatedAt: &now},
+	}}
+	RegisterHandlers(router.Group("/"), NewService(repo, mockTransactional, &mockRecorder{}, logger), auth.MockAuthHandler, org.MockHandler, logger)
+	header := auth.MockAuthHeader()
+	orgHeader := auth.MockAuthHeader()
+	orgHeader.Set(org.HeaderOrgID, "org1")
+	body := `{"name":"reset","subject":"Reset","body":"Click","from_email":"hello@example.com"}`
+
+	tests := []test.APITestCase{
+		{Name: "get all", Method: "GET", URL: "/templates", Body: "", Header: header, WantStatus: http.StatusOK, WantResponse: `*"total_count":1*`},
+		{Name: "get 123", Method: "GET", URL: "/templates/123", Body: "", Header: header, WantStatus: http.StatusOK, WantResponse: `*"name":"welcome"*`},
+		{Name: "get other org", Method: "GET", URL: "/templates/456", Body: "", Header: header, WantStatus: http.StatusNotFound, WantResponse: ""},
+		{Name: "get with org header", Method: "GET", URL: "/templates/456", Body: "", Header: orgHeader, WantStatus: http.StatusOK, WantResponse: `*"name":"invoice"*`},
+		{Name: "create ok", Method: "POST", URL: "/templates", Body: body, Header: orgHeader, WantStatus: http.StatusCreated, WantResponse: `*"org_id":"org1"*`},
+		{Name: "create verify", Method: "GET", URL: "/templates", Body: "", Header: orgHeader, WantStatus: http.StatusOK, WantResponse: `*"total_count":2*`},
+		{Name: "create input error", Method: "POST", URL: "/templates", Body: `"name":"test"}`, Header: header, WantStatus: http.StatusBadRequest, WantResponse: ""},
+		{Name: "create invalid", Method: "POST", URL: "/templates", Body: `{"name":"test"}`, Header: header, WantStatus: http.StatusBadRequest, WantResponse: `*subject*`},
+		{Name: "create locale mismatch", Method: "POST", URL: "/templates", Body: `{"name":"reset","subject":"Reset","body":"Click","from_email":"hello@example.com","locales":{"es":{"subject":"Restablecer","body":"Haz clic, {{name}}"}}}`, Header: header, WantStatus: http.StatusBadRequest, WantResponse: `*"field":"locales","error":"es: uses variables the default does not: name.*`},
+		{Name: "update ok", Method: "PUT", URL: "/templates/123", Body: body, Header: header, WantStatus: http.StatusOK, WantResponse: `*"name":"reset"*`},
+		{Name: "update other org", Method: "PUT", URL: "/templates/456", Body: body, Header: header, WantStatus: http.StatusNotFound, WantResponse: ""},
+		{Name: "delete ok", Method: "DELETE", URL: "/templates/123", Body: ``, Header: header, WantStatus: http.StatusOK, WantResponse: `*reset*`},
+		{Name: "delete verify", Method: "DELETE", URL: "/templates/123", Body: ``, Header: header, WantStatus: http.StatusNotFound, WantResponse: ""},
+		{Name: "unauthorized", Method: "GET", URL: "/templates", Body: "", Header: nil, WantStatus: http.StatusUnauthorized, WantResponse: ""},
+	}
+	for _, tc := range tests {
+		test.Endpoint(t, router, tc)
+	}
+}
+
+func TestAPI_roles(t *testing.T) {
+	logger, _ := log.NewForTest()
+	router := test.MockRouter(logger)
+	now := time.Now()
+	repo := &mockRepository{items: []entity.Template{
+		{ID: "123", OrgID: "100", UserID: "100", Name: "welcome", Subject: "Welcome", Body: "Hi", FromEmail: "hello@example.com", CreatedAt: &now, UpdatedAt: &now},
+	}}
+	RegisterHandlers(router.Group("/"), NewService(repo, mockTransactional, &mockRecorder{}, logger), auth.MockAuthHandler, org.MockHandler, logger)
+	body := `{"name":"welcome","subject":"Welcome","body":"Hi","from_email":"hello@example.com"}`
+
+	// deleting an unknown template tells whether the permission check passed without removing anything
+	tests := []struct {
+		role                       string
+		read, create, update, drop int
+	}{
+		{entity.RoleOwner, http.StatusOK, http.StatusCreated, http.StatusOK, http.StatusNotFound},
+		{entity.RoleAdmin, http.StatusOK, http.StatusCreated, http.StatusOK, http.StatusNotFound},
+		{entity.RoleEditor, http.StatusOK, http.StatusCreated, http.StatusOK, http.StatusNotFound},
+		{entity.RoleSender, http.StatusOK, http.StatusForbidden, http.StatusForbidden, http.StatusForbidden},
+		{entity.RoleViewer, http.StatusOK, http.StatusForbidden, http.StatusForbidden, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		header := org.MockHeader(tt.role)
+		for _, tc := range []test.APITestCase{
+			{Name: tt.role + " read", Method: "GET", URL: "/templates/123", Header: header, WantStatus: tt.read},
+			{Name: tt.role + " create", Method: "POST", URL: "/templates", Body: body, Header: header, WantStatus: tt.create},
+			{Name: tt.role + " update", Method: "PUT", URL: "/templates/123", Body: body, Header: header, WantStatus: tt.update},
+			{Name: tt.role + " delete", Method: "DELETE", URL: "/templates/unknown", Header: header, WantStatus: tt.drop},
+		} {
+			test.Endpoint(t, router, tc)
+		}
+	}
+}
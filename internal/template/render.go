@@ -0,0 +1,136 @@
+package template
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/garaekz/gonvelope/internal/entity"
+	"github.com/garaekz/gonvelope/pkg/locale"
+)
+
+// placeholderPattern matches the {{name}} placeholders of templates.
+// Nested values are addressed with dots, as in {{company.name}}.
+var placeholderPattern = regexp.MustCompile(`\{\{\s*([A-Za-z_][A-Za-z0-9_]*(?:\.[A-Za-z_][A-Za-z0-9_]*)*)\s*\}\}`)
+
+// htmlPattern detects bodies written in HTML.
+var htmlPattern = regexp.MustCompile(`<[a-zA-Z][^>]*>`)
+
+// Content is a template rendered for a recipient.
+type Content struct {
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// MissingVariablesError is returned when a template uses variables that were not given a value.
+type MissingVariablesError struct {
+	Names []string
+}
+
+// Error implements the error interface.
+func (e MissingVariablesError) Error() string {
+	return "missing variables: " + strings.Join(e.Names, ", ")
+}
+
+// Render replaces the placeholders of the template subject and body with the values of the variables.
+// A body containing HTML markup is rendered as HTML with the values escaped, any other body as plain text.
+// A MissingVariablesError is returned if a placeholder has no value.
+func Render(t entity.Template, vars map[string]interface{}) (Content, error) {
+	var missing []string
+	replace := func(s string, escape bool) string {
+		return placeholderPattern.ReplaceAllStringFunc(s, func(placeholder string) string {
+			name := placeholderPattern.FindStringSubmatch(placeholder)[1]
+			value, ok := lookup(vars, name)
+			if !ok {
+				missing = append(missing, name)
+				return placeholder
+			}
+			if escape {
+				return html.EscapeString(value)
+			}
+			return value
+		})
+	}
+
+	content := Content{Subject: replace(t.Subject, false)}
+	if htmlPattern.MatchString(t.Body) {
+		content.HTML = replace(t.Body, true)
+	} else {
+		content.Text = replace(t.Body, false)
+	}
+	if len(missing) > 0 {
+		return Content{}, MissingVariablesError{uniqueSorted(missing)}
+	}
+	return content, nil
+}
+
+// Localize returns the template with the subject and body of its variant for the locale. Locales without a
+// variant fall back to less specific ones, and then to the default subject and body: es-MX falls back to es,
+// then to the default.
+func Localize(t entity.Template, tag string) entity.Template {
+	resolved := locale.Resolve(tag, func(l string) bool {
+		_, ok := t.Locales[l]
+		return ok
+	})
+	if resolved != "" {
+		t.Subject, t.Body = t.Locales[resolved].Subject, t.Locales[resolved].Body
+	}
+	return t
+}
+
+// Variables returns the names of the variables used by the template subject and body, sorted.
+func Variables(t entity.Template) []string {
+	var names []string
+	for _, s := range []string{t.Subject, t.Body} {
+		for _, match := range placeholderPattern.FindAllStringSubmatch(s, -1) {
+			names = append(names, match[1])
+		}
+	}
+	return uniqueSorted(names)
+}
+
+// lookup returns the value of the variable with the given dotted name, formatted as text.
+func lookup(vars map[string]interface{}, name string) (string, bool) {
+	var value interface{} = vars
+	for _, key := range strings.Split(name, ".") {
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			return "", false
+		}
+		if value, ok = m[key]; !ok {
+			return "", false
+		}
+	}
+	switch v := value.(type) {
+	case nil:
+		return "", true
+	case string:
+		return v, true
+	case float64:
+		// numbers decoded from JSON are float64, which fmt would print in scientific notation
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	case map[string]interface{}, []interface{}:
+		return "", false
+	default:
+		return fmt.Sprint(v), true
+	}
+}
+
+// uniqueSorted returns the distinct values of the list, sorted.
+func uniqueSorted(values []string) []string {
+	if len(values) == 0 {
+		return nil
+	}
+	sort.Strings(values)
+	unique := values[:1]
+	for _, value := range values[1:] {
+		if value != unique[len(unique)-1] {
+			unique = append(unique, value)
+		}
+	}
+	return unique
+}
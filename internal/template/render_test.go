@@ -0,0 +1,58 @@
+package template
+
+import (
+	"testing"
+
+	"github.com/garaekz/gonvelope/internal/entity"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRender(t *testing.T) {
+	vars := map[string]interface{}{
+		"name":    "Jane <3",
+		"total":   float64(1500000),
+		"company": map[string]interface{}{"name": "Acme"},
+		"empty":   nil,
+	}
+
+	content, err := Render(entity.Template{Subject: "Hi {{ name }}", Body: "Total: {{total}} from {{company.name}}{{empty}}"}, vars)
+	require.Nil(t, err)
+	assert.Equal(t, Content{Subject: "Hi Jane <3", Text: "Total: 1500000 from Acme"}, content)
+
+	content, err = Render(entity.Template{Subject: "Hi", Body: "<p>Hello {{name}}</p>"}, vars)
+	require.Nil(t, err)
+	assert.Equal(t, "<p>Hello Jane &lt;3</p>", content.HTML)
+	assert.Empty(t, content.Text)
+
+	_, err = Render(entity.Template{Subject: "Hi {{name}} {{plan}}", Body: "{{company}} {{company.id}} {{plan}}"}, vars)
+	assert.Equal(t, MissingVariablesError{[]string{"company", "company.id", "plan"}}, err)
+}
+
+func TestLocalize(t *testing.T) {
+	tmpl := entity.Template{Subject: "Hi", Body: "Hello", Locales: entity.TemplateLocales{
+		"es":    {Subject: "Hola", Body: "Hola"},
+		"es-MX": {Subject: "Qué onda", Body: "Qué onda"},
+		"pt-BR": {Subject: "Oi", Body: "Olá"},
+	}}
+	tests := []struct {
+		locale, want string
+	}{
+		{"es-MX", "Qué onda"},
+		{"es_mx", "Qué onda"},
+		{"es-AR", "Hola"},
+		{"es", "Hola"},
+		{"pt", "Hi"},
+		{"en-US", "Hi"},
+		{"", "Hi"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, Localize(tmpl, tt.locale).Subject, tt.locale)
+	}
+	assert.Equal(t, "Hello", Localize(entity.Template{Subject: "Hi", Body: "Hello"}, "es").Body)
+}
+
+func TestVariables(t *testing.T) {
+	assert.Equal(t, []string{"company.name", "name"}, Variables(entity.Template{Subject: "Hi {{name}}", Body: "{{company.name}} {{ name }} {{ not a placeholder }}"}))
+	assert.Nil(t, Variables(entity.Template{Subject: "Hi", Body: "Hello"}))
+}
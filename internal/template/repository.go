@@ -0,0 +1,84 @@
+package template
+
+import (
+	"context"
+
+	"github.com/garaekz/gonvelope/internal/entity"
+	"github.com/garaekz/gonvelope/pkg/dbcontext"
+	"github.com/garaekz/gonvelope/pkg/log"
+	dbx "github.com/go-ozzo/ozzo-dbx"
+)
+
+// Repository encapsulates the logic to access templates from the data source.
+type Repository interface {
+	// Get returns the template with the specified ID owned by the organization.
+	Get(ctx context.Context, orgID, id string) (entity.Template, error)
+	// Count returns the number of templates owned by the organization.
+	Count(ctx context.Context, orgID string) (int, error)
+	// Query returns the list of templates owned by the organization with the given offset and limit.
+	Query(ctx context.Context, orgID string, offset, limit int) ([]entity.Template, error)
+	// Create saves a new template in the storage.
+	Create(ctx context.Context, template entity.Template) error
+	// Update updates the template with given ID in the storage.
+	Update(ctx context.Context, template entity.Template) error
+	// Delete removes the template with given ID owned by the organization from the storage.
+	Delete(ctx context.Context, orgID, id string) error
+}
+
+// repository persists templates in database
+type repository struct {
+	db     *dbcontext.DB
+	logger log.Logger
+}
+
+// NewRepository creates a new template repository
+func NewRepository(db *dbcontext.DB, logger log.Logger) Repository {
+	return repository{db, logger}
+}
+
+// Get returns the template with the specified ID owned by the organization.
+func (r repository) Get(ctx context.Context, orgID, id string) (entity.Template, error) {
+	var template entity.Template
+	err := r.db.With(ctx).Select().From(template.TableName()).
+		Where(dbx.HashExp{"id": id, "org_id": orgID}).One(&template)
+	return template, err
+}
+
+// Count returns the number of templates owned by the organization.
+func (r repository) Count(ctx context.Context, orgID string) (int, error) {
+	var count int
+	err := r.db.With(ctx).Select("COUNT(*)").From(entity.Template{}.TableName()).
+		Where(dbx.HashExp{"org_id": orgID}).Row(&count)
+	return count, err
+}
+
+// Query returns the list of templates owned by the organization with the given offset and limit.
+func (r repository) Query(ctx context.Context, orgID string, offset, limit int) ([]entity.Template, error) {
+	var templates []entity.Template
+	err := r.db.With(ctx).Select().From(entity.Template{}.TableName()).
+		Where(dbx.HashExp{"org_id": orgID}).
+		OrderBy("name").
+		Offset(int64(offset)).
+		Limit(int64(limit)).
+		All(&templates)
+	return templates, err
+}
+
+// Create saves a new template in the storage.
+func (r repository) Create(ctx context.Context, template entity.Template) error {
+	return r.db.With(ctx).Model(&template).Exclude("CreatedAt", "UpdatedAt").Insert()
+}
+
+// Update updates the template with given ID in the storage.
+func (r repository) Update(ctx context.Context, template entity.Template) error {
+	return r.db.With(ctx).Model(&template).Update("Name", "Subject", "Body", "ToEmail", "FromEmail", "FromName", "Tracking", "Locales", "UpdatedAt")
+}
+
+// Delete removes the template with given ID owned by the organization from the storage.
+func (r repository) Delete(ctx context.Context, orgID, id string) error {
+	template, err := r.Get(ctx, orgID, id)
+	if err != nil {
+		return err
+	}
+	return r.db.With(ctx).Model(&template).Delete()
+}
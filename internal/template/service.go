@@ -0,0 +1,252 @@
+package template
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/garaekz/gonvelope/internal/audit"
+	"github.com/garaekz/gonvelope/internal/entity"
+	"github.com/garaekz/gonvelope/pkg/dbcontext"
+	"github.com/garaekz/gonvelope/pkg/locale"
+	"github.com/garaekz/gonvelope/pkg/log"
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/go-ozzo/ozzo-validation/v4/is"
+)
+
+// maxLocales is the maximum number of locale variants of a template.
+const maxLocales = 50
+
+// Service encapsulates usecase logic for templates.
+type Service interface {
+	Get(ctx context.Context, orgID, id string) (entity.Template, error)
+	Query(ctx context.Context, orgID string, offset, limit int) ([]entity.Template, error)
+	Count(ctx context.Context, orgID string) (int, error)
+	Create(ctx context.Context, orgID, userID string, input TemplateRequest) (entity.Template, error)
+	Update(ctx context.Context, orgID, userID, id string, input TemplateRequest) (entity.Template, error)
+	Delete(ctx context.Context, orgID, userID, id string) (entity.Template, error)
+}
+
+// TemplateRequest represents a template creation or update request.
+type TemplateRequest struct {
+	Name      string `json:"name"`
+	Subject   string `json:"subject"`
+	Body      string `json:"body"`
+	ToEmail   string `json:"to_email"`
+	FromEmail string `json:"from_email"`
+	FromName  string `json:"from_name"`
+	// Tracking enables open and click tracking of the messages sent from the template.
+	Tracking bool `json:"tracking"`
+	// Locales holds the variants of the subject and body in other locales, keyed by locale such as es-MX.
+	// They must use the same variables as the default subject and body.
+	Locales map[string]entity.TemplateVariant `json:"locales"`
+}
+
+// Validate validates the TemplateRequest fields.
+func (m TemplateRequest) Validate() error {
+	return validation.ValidateStruct(&m,
+		validation.Field(&m.Name, validation.Required, validation.Length(0, 128)),
+		validation.Field(&m.Subject, validation.Required, validation.Length(0, 998)),
+		validation.Field(&m.Body, validation.Required),
+		validation.Field(&m.ToEmail, validation.Length(0, 254)),
+		validation.Field(&m.FromEmail, validation.Required, validation.Length(0, 254), is.EmailFormat),
+		validation.Field(&m.FromName, validation.Length(0, 128)),
+		validation.Field(&m.Locales, validation.Length(0, maxLocales), validation.By(m.validateLocales)),
+	)
+}
+
+// validateLocales checks that the variants are keyed by distinct locales, and that each of them uses
+// the same variables as the default subject and body.
+func (m TemplateRequest) validateLocales(value interface{}) error {
+	locales, _ := value.(map[string]entity.TemplateVariant)
+	tags := make([]string, 0, len(locales))
+	for tag := range locales {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+
+	want := Variables(entity.Template{Subject: m.Subject, Body: m.Body})
+	errs := validation.Errors{}
+	seen := map[string]string{}
+	for _, tag := range tags {
+		if !locale.Valid(tag) {
+			errs[tag] = validation.NewError("validation_locale", "is not a locale such as es or es-MX")
+			continue
+		}
+		if other, ok := seen[locale.Normalize(tag)]; ok {
+			errs[tag] = validation.NewError("validation_locale_duplicate", fmt.Sprintf("is the same locale as %q", other))
+			continue
+		}
+		seen[locale.Normalize(tag)] = tag
+		variant := locales[tag]
+		err := validation.ValidateStruct(&variant,
+			validation.Field(&variant.Subject, validation.Required, validation.Length(0, 998)),
+			validation.Field(&variant.Body, validation.Required),
+		)
+		if err == nil {
+			err = compareVariables(want, Variables(entity.Template{Subject: variant.Subject, Body: variant.Body}))
+		}
+		if err != nil {
+			errs[tag] = err
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// compareVariables returns an error listing the variables a variant uses that the default does not,
+// and those of the default it does not use. Both lists of names must be sorted.
+func compareVariables(want, got []string) error {
+	var problems []string
+	if extra := difference(got, want); len(extra) > 0 {
+		problems = append(problems, "uses variables the default does not: "+strings.Join(extra, ", "))
+	}
+	if missing := difference(want, got); len(missing) > 0 {
+		problems = append(problems, "lacks variables of the default: "+strings.Join(missing, ", "))
+	}
+	if len(problems) > 0 {
+		return validation.NewError("validation_locale_variables", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// difference returns the names of a that are not in b.
+func difference(a, b []string) []string {
+	var names []string
+	for _, name := range a {
+		if i := sort.SearchStrings(b, name); i == len(b) || b[i] != name {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// normalizeLocales returns the variants keyed by the canonical form of their locale.
+func normalizeLocales(locales map[string]entity.TemplateVariant) entity.TemplateLocales {
+	normalized := entity.TemplateLocales{}
+	for tag, variant := range locales {
+		normalized[locale.Normalize(tag)] = variant
+	}
+	return normalized
+}
+
+type service struct {
+	repo          Repository
+	transactional dbcontext.TransactionFunc
+	auditor       audit.Recorder
+	logger        log.Logger
+}
+
+// NewService creates a new template service.
+func NewService(repo Repository, transactional dbcontext.TransactionFunc, auditor audit.Recorder, logger log.Logger) Service {
+	return service{repo, transactional, auditor, logger}
+}
+
+// Get returns the template with the specified ID owned by the organization.
+func (s service) Get(ctx context.Context, orgID, id string) (entity.Template, error) {
+	return s.repo.Get(ctx, orgID, id)
+}
+
+// Query returns the templates owned by the organization with the specified offset and limit.
+func (s service) Query(ctx context.Context, orgID string, offset, limit int) ([]entity.Template, error) {
+	return s.repo.Query(ctx, orgID, offset, limit)
+}
+
+// Count returns the number of templates owned by the organization.
+func (s service) Count(ctx context.Context, orgID string) (int, error) {
+	return s.repo.Count(ctx, orgID)
+}
+
+// Create creates a new template owned by the organization on behalf of the user.
+func (s service) Create(ctx context.Context, orgID, userID string, req TemplateRequest) (entity.Template, error) {
+	if err := req.Validate(); err != nil {
+		return entity.Template{}, err
+	}
+	now := time.Now()
+	template := entity.Template{
+		ID:        entity.GenerateID(),
+		OrgID:     orgID,
+		UserID:    userID,
+		Name:      req.Name,
+		Subject:   req.Subject,
+		Body:      req.Body,
+		ToEmail:   req.ToEmail,
+		FromEmail: req.FromEmail,
+		FromName:  req.FromName,
+		Tracking:  req.Tracking,
+		Locales:   normalizeLocales(req.Locales),
+		CreatedAt: &now,
+		UpdatedAt: &now,
+	}
+	err := s.transactional(ctx, func(ctx context.Context) error {
+		if err := s.repo.Create(ctx, template); err != nil {
+			return err
+		}
+		return s.record(ctx, audit.ActionTemplateCreated, userID, template, nil, template)
+	})
+	if err != nil {
+		return entity.Template{}, err
+	}
+	return s.repo.Get(ctx, orgID, template.ID)
+}
+
+// Update updates the template with the specified ID owned by the organization on behalf of the user.
+func (s service) Update(ctx context.Context, orgID, userID, id string, req TemplateRequest) (entity.Template, error) {
+	if err := req.Validate(); err != nil {
+		return entity.Template{}, err
+	}
+	template, err := s.repo.Get(ctx, orgID, id)
+	if err != nil {
+		return template, err
+	}
+	before := template
+	now := time.Now()
+	template.Name = req.Name
+	template.Subject = req.Subject
+	template.Body = req.Body
+	template.ToEmail = req.ToEmail
+	template.FromEmail = req.FromEmail
+	template.FromName = req.FromName
+	template.Tracking = req.Tracking
+	template.Locales = normalizeLocales(req.Locales)
+	template.UpdatedAt = &now
+	err = s.transactional(ctx, func(ctx context.Context) error {
+		if err := s.repo.Update(ctx, template); err != nil {
+			return err
+		}
+		return s.record(ctx, audit.ActionTemplateUpdated, userID, template, before, template)
+	})
+	return template, err
+}
+
+// Delete deletes the template with the specified ID owned by the organization on behalf of the user.
+func (s service) Delete(ctx context.Context, orgID, userID, id string) (entity.Template, error) {
+	template, err := s.repo.Get(ctx, orgID, id)
+	if err != nil {
+		return template, err
+	}
+	err = s.transactional(ctx, func(ctx context.Context) error {
+		if err := s.repo.Delete(ctx, orgID, id); err != nil {
+			return err
+		}
+		return s.record(ctx, audit.ActionTemplateDeleted, userID, template, template, nil)
+	})
+	return template, err
+}
+
+// record records the change made by the user to the template in the audit log.
+// The before and after states are nil when the template is created and deleted respectively.
+func (s service) record(ctx context.Context, action, userID string, template entity.Template, before, after interface{}) error {
+	return s.auditor.Record(ctx, audit.Event{
+		Action:     action,
+		OrgID:      template.OrgID,
+		ActorID:    userID,
+		TargetType: "template",
+		TargetID:   template.ID,
+		Diff:       audit.Diff(before, after),
+	})
+}
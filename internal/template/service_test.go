@@ -0,0 +1,227 @@
+package template
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+
+	"github.com/garaekz/gonvelope/internal/audit"
+	"github.com/garaekz/gonvelope/internal/entity"
+	"github.com/garaekz/gonvelope/pkg/log"
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTemplateRequest_Validate(t *testing.T) {
+	valid := TemplateRequest{Name: "welcome", Subject: "Welcome", Body: "Hi {{name}}", FromEmail: "hello@example.com"}
+	tests := []struct {
+		name      string
+		model     func(TemplateRequest) TemplateRequest
+		wantError bool
+	}{
+		{"success", func(m TemplateRequest) TemplateRequest { return m }, false},
+		{"name required", func(m TemplateRequest) TemplateRequest { m.Name = ""; return m }, true},
+		{"subject required", func(m TemplateRequest) TemplateRequest { m.Subject = ""; return m }, true},
+		{"body required", func(m TemplateRequest) TemplateRequest { m.Body = ""; return m }, true},
+		{"from required", func(m TemplateRequest) TemplateRequest { m.FromEmail = ""; return m }, true},
+		{"invalid from", func(m TemplateRequest) TemplateRequest { m.FromEmail = "hello"; return m }, true},
+		{"locales", func(m TemplateRequest) TemplateRequest {
+			m.Locales = map[string]entity.TemplateVariant{"es": {Subject: "Bienvenido", Body: "Hola {{ name }}"}, "pt_BR": {Subject: "Bem-vindo", Body: "Olá {{name}}"}}
+			return m
+		}, false},
+		{"invalid locale", func(m TemplateRequest) TemplateRequest {
+			m.Locales = map[string]entity.TemplateVariant{"spanish": {Subject: "Bienvenido", Body: "Hola {{name}}"}}
+			return m
+		}, true},
+		{"duplicate locale", func(m TemplateRequest) TemplateRequest {
+			m.Locales = map[string]entity.TemplateVariant{"es-MX": {Subject: "Bienvenido", Body: "Hola {{name}}"}, "es_mx": {Subject: "Bienvenido", Body: "Hola {{name}}"}}
+			return m
+		}, true},
+		{"locale body required", func(m TemplateRequest) TemplateRequest {
+			m.Locales = map[string]entity.TemplateVariant{"es": {Subject: "Bienvenido"}}
+			return m
+		}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.model(valid).Validate()
+			assert.Equal(t, tt.wantError, err != nil)
+		})
+	}
+}
+
+func TestTemplateRequest_Validate_variables(t *testing.T) {
+	req := TemplateRequest{Name: "welcome", Subject: "Welcome {{name}}", Body: "Your code is {{code}}", FromEmail: "hello@example.com"}
+	req.Locales = map[string]entity.TemplateVariant{
+		"es":    {Subject: "Bienvenido {{name}}", Body: "Tu código es {{code}}"},
+		"es-MX": {Subject: "Bienvenido {{name}}", Body: "Tu código es {{codigo}}"},
+		"pt":    {Subject: "Bem-vindo", Body: "Seu código é {{code}}"},
+	}
+	err := req.Validate()
+	require.NotNil(t, err)
+	errs := err.(validation.Errors)["locales"].(validation.Errors)
+	assert.Len(t, errs, 2)
+	assert.EqualError(t, errs["es-MX"], "uses variables the default does not: codigo; lacks variables of the default: code")
+	assert.EqualError(t, errs["pt"], "lacks variables of the default: name")
+}
+
+func Test_service_CRUD(t *testing.T) {
+	logger, _ := log.NewForTest()
+	auditor := &mockRecorder{}
+	s := NewService(&mockRepository{}, mockTransactional, auditor, logger)
+	ctx := context.Background()
+
+	// unsuccessful creation
+	_, err := s.Create(ctx, "org1", "100", TemplateRequest{Name: "welcome"})
+	assert.NotNil(t, err)
+
+	// successful creation
+	created, err := s.Create(ctx, "org1", "100", TemplateRequest{Name: "welcome", Subject: "Welcome", Body: "Hi", FromEmail: "hello@example.com"})
+	require.Nil(t, err)
+	assert.Equal(t, "org1", created.OrgID)
+	assert.Equal(t, "100", created.UserID)
+	count, _ := s.Count(ctx, "org1")
+	assert.Equal(t, 1, count)
+
+	// templates are scoped to their organization
+	_, err = s.Get(ctx, "org2", created.ID)
+	assert.Equal(t, sql.ErrNoRows, err)
+	_, err = s.Update(ctx, "org2", "100", created.ID, TemplateRequest{Name: "x", Subject: "x", Body: "x", FromEmail: "x@example.com"})
+	assert.Equal(t, sql.ErrNoRows, err)
+
+	// update
+	template, err := s.Update(ctx, "org1", "100", created.ID, TemplateRequest{Name: "renamed", Subject: "Welcome", Body: "Hi", FromEmail: "hello@example.com"})
+	require.Nil(t, err)
+	assert.Equal(t, "renamed", template.Name)
+	template, _ = s.Get(ctx, "org1", created.ID)
+	assert.Equal(t, "renamed", template.Name)
+
+	// query
+	templates, _ := s.Query(ctx, "org1", 0, 0)
+	assert.Len(t, templates, 1)
+
+	// delete
+	_, err = s.Delete(ctx, "org2", "100", created.ID)
+	assert.NotNil(t, err)
+	_, err = s.Delete(ctx, "org1", "100", created.ID)
+	assert.Nil(t, err)
+	count, _ = s.Count(ctx, "org1")
+	assert.Equal(t, 0, count)
+
+	// every change is audited
+	if assert.Len(t, auditor.events, 3) {
+		assert.Equal(t, audit.ActionTemplateCreated, auditor.events[0].Action)
+		assert.Equal(t, "org1", auditor.events[0].OrgID)
+		assert.Equal(t, audit.Change{Old: "welcome", New: "renamed"}, auditor.events[1].Diff["name"])
+		assert.Len(t, auditor.events[1].Diff, 1)
+		assert.Equal(t, audit.ActionTemplateDeleted, auditor.events[2].Action)
+		assert.Equal(t, "100", auditor.events[2].ActorID)
+		assert.Equal(t, created.ID, auditor.events[2].TargetID)
+	}
+}
+
+func Test_service_locales(t *testing.T) {
+	logger, _ := log.NewForTest()
+	s := NewService(&mockRepository{}, mockTransactional, &mockRecorder{}, logger)
+	ctx := context.Background()
+	req := TemplateRequest{Name: "welcome", Subject: "Welcome {{name}}", Body: "Hi", FromEmail: "hello@example.com",
+		Locales: map[string]entity.TemplateVariant{"ES_mx": {Subject: "Bienvenido {{name}}", Body: "Hola"}}}
+
+	// the locales of the variants are normalized
+	created, err := s.Create(ctx, "org1", "100", req)
+	require.Nil(t, err)
+	assert.Equal(t, entity.TemplateLocales{"es-MX": {Subject: "Bienvenido {{name}}", Body: "Hola"}}, created.Locales)
+
+	req.Locales = map[string]entity.TemplateVariant{"pt": {Subject: "Bem-vindo", Body: "Olá"}}
+	_, err = s.Update(ctx, "org1", "100", created.ID, req)
+	assert.NotNil(t, err)
+	req.Locales = nil
+	updated, err := s.Update(ctx, "org1", "100", created.ID, req)
+	require.Nil(t, err)
+	assert.Empty(t, updated.Locales)
+}
+
+func Test_service_auditFailure(t *testing.T) {
+	logger, _ := log.NewForTest()
+	repo := &mockRepository{}
+	s := NewService(repo, mockTransactional, &mockRecorder{err: errors.New("audit log unavailable")}, logger)
+	_, err := s.Create(context.Background(), "org1", "100", TemplateRequest{Name: "welcome", Subject: "Welcome", Body: "Hi", FromEmail: "hello@example.com"})
+	assert.NotNil(t, err)
+}
+
+func mockTransactional(ctx context.Context, f func(ctx context.Context) error) error {
+	return f(ctx)
+}
+
+type mockRecorder struct {
+	events []audit.Event
+	err    error
+}
+
+func (m *mockRecorder) Record(_ context.Context, event audit.Event) error {
+	if m.err != nil {
+		return m.err
+	}
+	m.events = append(m.events, event)
+	return nil
+}
+
+type mockRepository struct {
+	items []entity.Template
+}
+
+func (m *mockRepository) Get(_ context.Context, orgID, id string) (entity.Template, error) {
+	for _, item := range m.items {
+		if item.ID == id && item.OrgID == orgID {
+			return item, nil
+		}
+	}
+	return entity.Template{}, sql.ErrNoRows
+}
+
+func (m *mockRepository) Count(_ context.Context, orgID string) (int, error) {
+	count := 0
+	for _, item := range m.items {
+		if item.OrgID == orgID {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (m *mockRepository) Query(_ context.Context, orgID string, _, _ int) ([]entity.Template, error) {
+	var items []entity.Template
+	for _, item := range m.items {
+		if item.OrgID == orgID {
+			items = append(items, item)
+		}
+	}
+	return items, nil
+}
+
+func (m *mockRepository) Create(_ context.Context, template entity.Template) error {
+	m.items = append(m.items, template)
+	return nil
+}
+
+func (m *mockRepository) Update(_ context.Context, template entity.Template) error {
+	for i, item := range m.items {
+		if item.ID == template.ID {
+			m.items[i] = template
+		}
+	}
+	return nil
+}
+
+func (m *mockRepository) Delete(_ context.Context, orgID, id string) error {
+	for i, item := range m.items {
+		if item.ID == id && item.OrgID == orgID {
+			m.items[i] = m.items[len(m.items)-1]
+			m.items = m.items[:len(m.items)-1]
+			return nil
+		}
+	}
+	return sql.ErrNoRows
+}
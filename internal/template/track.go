@@ -0,0 +1,37 @@
+package template
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+)
+
+// linkPattern matches the href attribute of the anchors of an HTML body along with everything before it in the tag.
+var linkPattern = regexp.MustCompile(`(?is)(<a\s[^>]*?\bhref\s*=\s*)("[^"]*"|'[^']*')`)
+
+// closingBodyPattern matches the closing body tag of an HTML body.
+var closingBodyPattern = regexp.MustCompile(`(?i)</body\s*>`)
+
+// Track instruments a rendered HTML body for open and click tracking: the http and https links are replaced
+// with the URLs returned by link, and an invisible image loading pixelURL is added before the closing body tag,
+// or at the end of the body if it has none. Other links, such as mailto: links, are left as they are.
+func Track(body, pixelURL string, link func(url string) string) string {
+	body = linkPattern.ReplaceAllStringFunc(body, func(match string) string {
+		parts := linkPattern.FindStringSubmatch(match)
+		target := html.UnescapeString(strings.TrimSpace(parts[2][1 : len(parts[2])-1]))
+		lower := strings.ToLower(target)
+		if !strings.HasPrefix(lower, "http://") && !strings.HasPrefix(lower, "https://") {
+			return match
+		}
+		return parts[1] + `"` + html.EscapeString(link(target)) + `"`
+	})
+
+	pixel := fmt.Sprintf(`<img src="%s" width="1" height="1" alt="" style="display:block;width:1px;height:1px;border:0">`, html.EscapeString(pixelURL))
+	locs := closingBodyPattern.FindAllStringIndex(body, -1)
+	if len(locs) == 0 {
+		return body + pixel
+	}
+	at := locs[len(locs)-1][0]
+	return body[:at] + pixel + body[at:]
+}
@@ -0,0 +1,37 @@
+package template
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTrack(t *testing.T) {
+	link := func(target string) string {
+		return "https://t.example.com/c?u=" + url.QueryEscape(target)
+	}
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{
+			"links and body",
+			`<html><body><a href="https://example.com/a?x=1&amp;y=2">A</a> <A class="b" HREF='http://example.com/b'>B</A></body></html>`,
+			`<html><body><a href="https://t.example.com/c?u=https%3A%2F%2Fexample.com%2Fa%3Fx%3D1%26y%3D2">A</a> <A class="b" HREF="https://t.example.com/c?u=http%3A%2F%2Fexample.com%2Fb">B</A>` +
+				`<img src="https://t.example.com/o" width="1" height="1" alt="" style="display:block;width:1px;height:1px;border:0"></body></html>`,
+		},
+		{
+			"untracked links without body",
+			`<p><a href="mailto:jane@example.com">Mail</a> <a href="#top">Top</a> <a name="x">X</a></p>`,
+			`<p><a href="mailto:jane@example.com">Mail</a> <a href="#top">Top</a> <a name="x">X</a></p>` +
+				`<img src="https://t.example.com/o" width="1" height="1" alt="" style="display:block;width:1px;height:1px;border:0">`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, Track(tt.body, "https://t.example.com/o", link))
+		})
+	}
+}
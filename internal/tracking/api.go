@@ -0,0 +1,68 @@
+package tracking
+
+import (
+	"net/http"
+
+	"github.com/garaekz/gonvelope/internal/errors"
+	"github.com/garaekz/gonvelope/pkg/clientip"
+	"github.com/garaekz/gonvelope/pkg/log"
+	routing "github.com/garaekz/ozzo-routing"
+)
+
+// pixel is a transparent 1x1 GIF image.
+var pixel = []byte{
+	0x47, 0x49, 0x46, 0x38, 0x39, 0x61, 0x01, 0x00, 0x01, 0x00, 0x80, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0xff, 0xff, 0xff, 0x21, 0xf9, 0x04, 0x01, 0x00, 0x00, 0x00, 0x00, 0x2c, 0x00, 0x00, 0x00, 0x00,
+	0x01, 0x00, 0x01, 0x00, 0x00, 0x02, 0x02, 0x44, 0x01, 0x00, 0x3b,
+}
+
+// RegisterHandlers sets up the routing of the HTTP handlers.
+// The endpoints are public since they are requested by the mail clients of the recipients,
+// which only hold the signed tokens of the tracking URLs.
+func RegisterHandlers(r *routing.RouteGroup, service Service, logger log.Logger) {
+	res := resource{service, logger}
+
+	r.Get("o/<token>", res.open)
+	r.Get("c/<token>", res.click)
+}
+
+type resource struct {
+	service Service
+	logger  log.Logger
+}
+
+// open records the open and serves the tracking pixel, even if the open could not be recorded.
+func (r resource) open(c *routing.Context) error {
+	if err := r.service.Open(c.Request.Context(), c.Param("token"), client(c)); err != nil {
+		if _, ok := err.(errors.ErrorResponse); ok {
+			return err
+		}
+		r.logger.With(c.Request.Context()).Errorf("failed to record open: %v", err)
+	}
+	header := c.Response.Header()
+	header.Set("Content-Type", "image/gif")
+	// every open must reach the server, not a cache
+	header.Set("Cache-Control", "no-store, no-cache, must-revalidate, private")
+	header.Set("Pragma", "no-cache")
+	_, err := c.Response.Write(pixel)
+	return err
+}
+
+// click records the click and redirects to the URL of the link, even if the click could not be recorded.
+func (r resource) click(c *routing.Context) error {
+	target, err := r.service.Click(c.Request.Context(), c.Param("token"), client(c))
+	if target == "" {
+		return err
+	}
+	if err != nil {
+		r.logger.With(c.Request.Context()).Errorf("failed to record click: %v", err)
+	}
+	c.Response.Header().Set("Cache-Control", "no-store")
+	http.Redirect(c.Response, c.Request, target, http.StatusFound)
+	return nil
+}
+
+// client returns the client that made the request.
+func client(c *routing.Context) Client {
+	return Client{IP: clientip.Get(c.Request), UserAgent: c.Request.UserAgent()}
+}
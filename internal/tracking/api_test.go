@@ -0,0 +1,59 @@
+package tracking
+
+import (
+	"bytes"
+	"image/gif"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/garaekz/gonvelope/internal/entity"
+	"github.com/garaekz/gonvelope/internal/test"
+	"github.com/garaekz/gonvelope/pkg/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAPI(t *testing.T) {
+	logger, _ := log.NewForTest()
+	router := test.MockRouter(logger)
+	repo := &mockRepository{messages: []entity.Message{{ID: "m1", OrgID: "org1"}}}
+	RegisterHandlers(router.Group("/t/"), NewService(repo, mockTransactional, (&mockEmitter{}).emit, "key", "", IPDrop, logger), logger)
+
+	openToken := signToken("key", kindOpen, "m1", "jane@example.com")
+	clickToken := signToken("key", kindClick, "m1", "jane@example.com", "https://example.com/a?b=c")
+
+	tests := []test.APITestCase{
+		{Name: "open invalid", Method: "GET", URL: "/t/o/invalid", WantStatus: http.StatusNotFound},
+		{Name: "click invalid", Method: "GET", URL: "/t/c/" + openToken, WantStatus: http.StatusNotFound},
+	}
+	for _, tc := range tests {
+		test.Endpoint(t, router, tc)
+	}
+
+	t.Run("open", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/t/o/"+openToken, nil)
+		req.Header.Set("User-Agent", "Mail/1.0")
+		res := httptest.NewRecorder()
+		router.ServeHTTP(res, req)
+		assert.Equal(t, http.StatusOK, res.Code)
+		assert.Equal(t, "image/gif", res.Header().Get("Content-Type"))
+		assert.Contains(t, res.Header().Get("Cache-Control"), "no-store")
+		img, err := gif.Decode(bytes.NewReader(res.Body.Bytes()))
+		require.Nil(t, err)
+		assert.Equal(t, 1, img.Bounds().Dx())
+	})
+
+	t.Run("click", func(t *testing.T) {
+		res := httptest.NewRecorder()
+		router.ServeHTTP(res, httptest.NewRequest("GET", "/t/c/"+clickToken, nil))
+		assert.Equal(t, http.StatusFound, res.Code)
+		assert.Equal(t, "https://example.com/a?b=c", res.Header().Get("Location"))
+	})
+
+	if assert.Len(t, repo.events, 2) {
+		// the IP addresses are dropped
+		assert.JSONEq(t, `{"ip":"","user_agent":"Mail/1.0"}`, string(repo.events[0].Data))
+		assert.Equal(t, entity.MessageEventClicked, repo.events[1].Type)
+	}
+}
@@ -0,0 +1,41 @@
+package tracking
+
+import (
+	"context"
+
+	"github.com/garaekz/gonvelope/internal/entity"
+	"github.com/garaekz/gonvelope/pkg/dbcontext"
+	"github.com/garaekz/gonvelope/pkg/log"
+	dbx "github.com/go-ozzo/ozzo-dbx"
+)
+
+// Repository encapsulates the logic to access tracked messages and their events from the data source.
+type Repository interface {
+	// GetMessage returns the message with the specified ID.
+	GetMessage(ctx context.Context, id string) (entity.Message, error)
+	// CreateEvent saves a new message event in the storage.
+	CreateEvent(ctx context.Context, event entity.MessageEvent) error
+}
+
+// repository persists message events in database
+type repository struct {
+	db     *dbcontext.DB
+	logger log.Logger
+}
+
+// NewRepository creates a new tracking repository
+func NewRepository(db *dbcontext.DB, logger log.Logger) Repository {
+	return repository{db, logger}
+}
+
+// GetMessage returns the message with the specified ID.
+func (r repository) GetMessage(ctx context.Context, id string) (entity.Message, error) {
+	var message entity.Message
+	err := r.db.With(ctx).Select().From(message.TableName()).Where(dbx.HashExp{"id": id}).One(&message)
+	return message, err
+}
+
+// CreateEvent saves a new message event in the storage.
+func (r repository) CreateEvent(ctx context.Context, event entity.MessageEvent) error {
+	return r.db.With(ctx).Model(&event).Insert()
+}
@@ -0,0 +1,157 @@
+// Package tracking records when recipients open tracked messages or follow their links. Tracked messages
+// load a pixel and link through redirects whose URLs carry signed tokens identifying the message.
+package tracking
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/garaekz/gonvelope/internal/entity"
+	"github.com/garaekz/gonvelope/internal/errors"
+	"github.com/garaekz/gonvelope/internal/template"
+	"github.com/garaekz/gonvelope/pkg/dbcontext"
+	"github.com/garaekz/gonvelope/pkg/log"
+)
+
+// How the IP addresses of the recipients are kept.
+const (
+	// IPFull keeps the IP addresses as they are.
+	IPFull = "full"
+	// IPHash replaces the IP addresses with a keyed hash, which still tells recipients apart.
+	IPHash = "hash"
+	// IPDrop does not keep the IP addresses.
+	IPDrop = "drop"
+)
+
+// Service encapsulates usecase logic for open and click tracking.
+type Service interface {
+	// Instrument returns the HTML body of the message with a tracking pixel and its links rewritten to tracked links.
+	Instrument(message entity.Message) string
+	// Open records that the message identified by an open token was opened by the client.
+	Open(ctx context.Context, token string, client Client) error
+	// Click records that the client followed the link identified by a click token, and returns the URL of the link.
+	// The URL is returned even if the click could not be recorded.
+	Click(ctx context.Context, token string, client Client) (string, error)
+}
+
+// Client describes the client that requested a tracking URL.
+type Client struct {
+	IP        string
+	UserAgent string
+}
+
+// EmitFunc queues the delivery of an event of the organization to its webhooks.
+type EmitFunc func(ctx context.Context, orgID, event string, data interface{}) error
+
+type service struct {
+	repo          Repository
+	transactional dbcontext.TransactionFunc
+	emit          EmitFunc
+	signingKey    string
+	appURL        string
+	ipMode        string
+	logger        log.Logger
+}
+
+// NewService creates a new tracking service. Tracking URLs are signed with signingKey, and the IP addresses
+// of the recipients are kept according to ipMode, one of IPFull, IPHash and IPDrop.
+func NewService(repo Repository, transactional dbcontext.TransactionFunc, emit EmitFunc, signingKey, appURL, ipMode string, logger log.Logger) Service {
+	return service{repo, transactional, emit, signingKey, appURL, ipMode, logger}
+}
+
+// Instrument returns the HTML body of the message with a tracking pixel and its links rewritten to tracked links.
+// Tracking URLs identify the recipient only when the message has a single one.
+func (s service) Instrument(message entity.Message) string {
+	var recipient string
+	if len(message.To) == 1 {
+		recipient = strings.ToLower(strings.TrimSpace(message.To[0]))
+	}
+	pixelURL := s.appURL + "/t/o/" + signToken(s.signingKey, kindOpen, message.ID, recipient)
+	return template.Track(message.HTML, pixelURL, func(target string) string {
+		return s.appURL + "/t/c/" + signToken(s.signingKey, kindClick, message.ID, recipient, target)
+	})
+}
+
+// Open records that the message identified by an open token was opened by the client.
+func (s service) Open(ctx context.Context, token string, client Client) error {
+	fields, err := parseToken(s.signingKey, kindOpen, token, 2)
+	if err != nil {
+		return errors.NotFound("")
+	}
+	return s.record(ctx, entity.MessageEventOpened, entity.WebhookMessageOpened, fields[0], fields[1], "", client)
+}
+
+// Click records that the client followed the link identified by a click token, and returns the URL of the link.
+func (s service) Click(ctx context.Context, token string, client Client) (string, error) {
+	fields, err := parseToken(s.signingKey, kindClick, token, 3)
+	if err != nil {
+		return "", errors.NotFound("")
+	}
+	target := fields[2]
+	return target, s.record(ctx, entity.MessageEventClicked, entity.WebhookMessageClicked, fields[0], fields[1], target, client)
+}
+
+// record saves an event of the message and emits it to the webhooks of the organization in a single transaction.
+func (s service) record(ctx context.Context, eventType, webhookEvent, messageID, recipient, target string, client Client) error {
+	message, err := s.repo.GetMessage(ctx, messageID)
+	if err == sql.ErrNoRows {
+		// the message may have been deleted since it was sent
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	data := map[string]interface{}{"user_agent": client.UserAgent, "ip": s.anonymize(client.IP)}
+	if target != "" {
+		data["url"] = target
+	}
+	encoded, err := entity.NewJSON(data)
+	if err != nil {
+		return err
+	}
+	event := entity.MessageEvent{
+		ID:        entity.GenerateID(),
+		OrgID:     message.OrgID,
+		MessageID: message.ID,
+		Type:      eventType,
+		Recipient: recipient,
+		Data:      encoded,
+		CreatedAt: now,
+	}
+	payload := map[string]interface{}{
+		"event_id":    event.ID,
+		"message_id":  message.ID,
+		"recipient":   recipient,
+		"occurred_at": now,
+	}
+	for name, value := range data {
+		payload[name] = value
+	}
+	return s.transactional(ctx, func(ctx context.Context) error {
+		if err := s.repo.CreateEvent(ctx, event); err != nil {
+			return err
+		}
+		return s.emit(ctx, message.OrgID, webhookEvent, payload)
+	})
+}
+
+// anonymize returns the IP address to be kept according to the IP address mode.
+func (s service) anonymize(ip string) string {
+	switch {
+	case ip == "" || s.ipMode == IPDrop:
+		return ""
+	case s.ipMode == IPHash:
+		mac := hmac.New(sha256.New, []byte(s.signingKey))
+		mac.Write([]byte("ip:" + ip))
+		return hex.EncodeToString(mac.Sum(nil))
+	default:
+		return ip
+	}
+}
@@ -0,0 +1,188 @@
+package tracking
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/garaekz/gonvelope/internal/entity"
+	"github.com/garaekz/gonvelope/internal/errors"
+	"github.com/garaekz/gonvelope/pkg/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// tokenPattern extracts the tokens of the tracking URLs of an instrumented body.
+var tokenPattern = regexp.MustCompile(`https://app\.example\.com/t/([oc])/([A-Za-z0-9_.-]+)`)
+
+func Test_service_Instrument(t *testing.T) {
+	logger, _ := log.NewForTest()
+	s := NewService(&mockRepository{}, mockTransactional, (&mockEmitter{}).emit, "key", "https://app.example.com", IPFull, logger)
+
+	html := s.Instrument(entity.Message{ID: "m1", To: []string{"Jane@Example.com"}, HTML: `<p><a href="https://example.com/a">A</a></p>`})
+	tokens := map[string]string{}
+	for _, match := range tokenPattern.FindAllStringSubmatch(html, -1) {
+		tokens[match[1]] = match[2]
+	}
+	require.Len(t, tokens, 2)
+
+	fields, err := parseToken("key", kindOpen, tokens["o"], 2)
+	require.Nil(t, err)
+	assert.Equal(t, []string{"m1", "jane@example.com"}, fields)
+	fields, err = parseToken("key", kindClick, tokens["c"], 3)
+	require.Nil(t, err)
+	assert.Equal(t, []string{"m1", "jane@example.com", "https://example.com/a"}, fields)
+
+	// tokens of one kind are not valid as another, nor with another key
+	_, err = parseToken("key", kindClick, tokens["o"], 2)
+	assert.Equal(t, errInvalidToken, err)
+	_, err = parseToken("other", kindOpen, tokens["o"], 2)
+	assert.Equal(t, errInvalidToken, err)
+
+	// messages to several recipients are tracked without identifying the recipient
+	html = s.Instrument(entity.Message{ID: "m1", To: []string{"a@example.com", "b@example.com"}, HTML: `<p>Hi</p>`})
+	match := tokenPattern.FindStringSubmatch(html)
+	require.NotNil(t, match)
+	fields, err = parseToken("key", kindOpen, match[2], 2)
+	require.Nil(t, err)
+	assert.Equal(t, []string{"m1", ""}, fields)
+}
+
+func Test_service_Open(t *testing.T) {
+	logger, _ := log.NewForTest()
+	repo := &mockRepository{messages: []entity.Message{{ID: "m1", OrgID: "org1"}}}
+	emitter := &mockEmitter{}
+	s := NewService(repo, mockTransactional, emitter.emit, "key", "https://app.example.com", IPFull, logger)
+	ctx := context.Background()
+
+	err := s.Open(ctx, signToken("key", kindOpen, "m1", "jane@example.com"), Client{IP: "192.0.2.1", UserAgent: "Mail/1.0"})
+	require.Nil(t, err)
+	require.Len(t, repo.events, 1)
+	assert.Equal(t, entity.MessageEventOpened, repo.events[0].Type)
+	assert.Equal(t, "org1", repo.events[0].OrgID)
+	assert.Equal(t, "jane@example.com", repo.events[0].Recipient)
+	assert.JSONEq(t, `{"ip":"192.0.2.1","user_agent":"Mail/1.0"}`, string(repo.events[0].Data))
+	require.Len(t, emitter.events, 1)
+	assert.Equal(t, "org1 "+entity.WebhookMessageOpened, emitter.events[0])
+	assert.Equal(t, "m1", emitter.data[0]["message_id"])
+	assert.Equal(t, repo.events[0].ID, emitter.data[0]["event_id"])
+
+	// opens of deleted messages are ignored
+	err = s.Open(ctx, signToken("key", kindOpen, "m2", ""), Client{})
+	require.Nil(t, err)
+	assert.Len(t, repo.events, 1)
+
+	err = s.Open(ctx, "invalid", Client{})
+	assert.Equal(t, http.StatusNotFound, err.(errors.ErrorResponse).StatusCode())
+}
+
+func Test_service_Click(t *testing.T) {
+	logger, _ := log.NewForTest()
+	repo := &mockRepository{messages: []entity.Message{{ID: "m1", OrgID: "org1"}}}
+	emitter := &mockEmitter{}
+	s := NewService(repo, mockTransactional, emitter.emit, "key", "https://app.example.com", IPFull, logger)
+	ctx := context.Background()
+
+	token := signToken("key", kindClick, "m1", "jane@example.com", "https://example.com/a")
+	target, err := s.Click(ctx, token, Client{IP: "192.0.2.1"})
+	require.Nil(t, err)
+	assert.Equal(t, "https://example.com/a", target)
+	require.Len(t, repo.events, 1)
+	assert.Equal(t, entity.MessageEventClicked, repo.events[0].Type)
+	assert.Contains(t, string(repo.events[0].Data), `"url":"https://example.com/a"`)
+	assert.Equal(t, "org1 "+entity.WebhookMessageClicked, emitter.events[0])
+	assert.Equal(t, "https://example.com/a", emitter.data[0]["url"])
+
+	// the URL cannot be changed without invalidating the signature
+	payload, sig, _ := strings.Cut(token, ".")
+	tampered := signToken("other", kindClick, "m1", "jane@example.com", "https://evil.example.com")
+	tamperedPayload, _, _ := strings.Cut(tampered, ".")
+	assert.NotEqual(t, payload, tamperedPayload)
+	_, err = s.Click(ctx, tamperedPayload+"."+sig, Client{})
+	assert.Equal(t, http.StatusNotFound, err.(errors.ErrorResponse).StatusCode())
+
+	// the URL is returned even if the click cannot be recorded
+	repo.err = sql.ErrConnDone
+	target, err = s.Click(ctx, token, Client{})
+	assert.Equal(t, sql.ErrConnDone, err)
+	assert.Equal(t, "https://example.com/a", target)
+}
+
+func Test_service_anonymize(t *testing.T) {
+	logger, _ := log.NewForTest()
+	tests := []struct {
+		mode string
+		want string
+	}{
+		{IPFull, "192.0.2.1"},
+		{IPDrop, ""},
+	}
+	for _, tt := range tests {
+		s := NewService(&mockRepository{}, mockTransactional, nil, "key", "", tt.mode, logger).(service)
+		assert.Equal(t, tt.want, s.anonymize("192.0.2.1"), tt.mode)
+	}
+
+	s := NewService(&mockRepository{}, mockTransactional, nil, "key", "", IPHash, logger).(service)
+	hashed := s.anonymize("192.0.2.1")
+	assert.Len(t, hashed, 64)
+	assert.NotContains(t, hashed, "192")
+	assert.Equal(t, hashed, s.anonymize("192.0.2.1"))
+	assert.NotEqual(t, hashed, s.anonymize("192.0.2.2"))
+	assert.Equal(t, "", s.anonymize(""))
+}
+
+func mockTransactional(ctx context.Context, f func(ctx context.Context) error) error {
+	return f(ctx)
+}
+
+// mockEmitter records the events emitted to webhooks as "orgID event" along with their data.
+type mockEmitter struct {
+	events []string
+	data   []map[string]interface{}
+}
+
+func (m *mockEmitter) emit(_ context.Context, orgID, event string, data interface{}) error {
+	m.events = append(m.events, orgID+" "+event)
+	// the data is sent as JSON, so check it the way endpoints will see it
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	decoded := map[string]interface{}{}
+	if err := json.Unmarshal(b, &decoded); err != nil {
+		return err
+	}
+	m.data = append(m.data, decoded)
+	return nil
+}
+
+type mockRepository struct {
+	messages []entity.Message
+	events   []entity.MessageEvent
+	// err is returned by every method when set
+	err error
+}
+
+func (m *mockRepository) GetMessage(_ context.Context, id string) (entity.Message, error) {
+	if m.err != nil {
+		return entity.Message{}, m.err
+	}
+	for _, message := range m.messages {
+		if message.ID == id {
+			return message, nil
+		}
+	}
+	return entity.Message{}, sql.ErrNoRows
+}
+
+func (m *mockRepository) CreateEvent(_ context.Context, event entity.MessageEvent) error {
+	if m.err != nil {
+		return m.err
+	}
+	m.events = append(m.events, event)
+	return nil
+}
@@ -0,0 +1,50 @@
+package tracking
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strings"
+)
+
+// Kinds of tracking tokens. The kind is signed along with the payload so that a token of one kind
+// cannot be used as a token of another.
+const (
+	kindOpen  = "open"
+	kindClick = "click"
+)
+
+// errInvalidToken is returned when a tracking token is malformed or its signature does not match.
+var errInvalidToken = errors.New("invalid tracking token")
+
+// signToken returns a token of the given kind carrying the fields, which must not contain line breaks.
+// Tokens do not expire: messages may be opened long after they were sent.
+func signToken(signingKey, kind string, fields ...string) string {
+	payload := base64.RawURLEncoding.EncodeToString([]byte(strings.Join(fields, "\n")))
+	return payload + "." + signature(signingKey, kind, payload)
+}
+
+// parseToken verifies a token of the given kind and returns the n fields it carries.
+func parseToken(signingKey, kind, token string, n int) ([]string, error) {
+	payload, sig, ok := strings.Cut(token, ".")
+	if !ok || !hmac.Equal([]byte(sig), []byte(signature(signingKey, kind, payload))) {
+		return nil, errInvalidToken
+	}
+	decoded, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, errInvalidToken
+	}
+	fields := strings.Split(string(decoded), "\n")
+	if len(fields) != n || fields[0] == "" {
+		return nil, errInvalidToken
+	}
+	return fields, nil
+}
+
+// signature signs the token payload of the given kind with the signing key.
+func signature(signingKey, kind, payload string) string {
+	mac := hmac.New(sha256.New, []byte(signingKey))
+	mac.Write([]byte("track-" + kind + ":" + payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
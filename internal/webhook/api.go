@@ -0,0 +1,115 @@
+package webhook
+
+import (
+	"net/http"
+
+	"github.com/garaekz/gonvelope/internal/auth"
+	"github.com/garaekz/gonvelope/internal/entity"
+	"github.com/garaekz/gonvelope/internal/errors"
+	"github.com/garaekz/gonvelope/pkg/log"
+	"github.com/garaekz/gonvelope/pkg/pagination"
+	routing "github.com/garaekz/ozzo-routing"
+)
+
+// RegisterHandlers sets up the routing of the HTTP handlers.
+// Webhooks belong to the organization made active by orgHandler.
+func RegisterHandlers(r *routing.RouteGroup, service Service, authHandler, orgHandler routing.Handler, logger log.Logger) {
+	res := resource{service, logger}
+
+	r.Use(authHandler, orgHandler)
+
+	r.Get("webhooks", auth.Require(auth.ScopeWebhooksRead), res.query)
+	r.Get("webhooks/<id>", auth.Require(auth.ScopeWebhooksRead), res.get)
+	r.Get("webhooks/<id>/deliveries", auth.Require(auth.ScopeWebhooksRead), res.queryDeliveries)
+	r.Post("webhooks", auth.Require(auth.ScopeWebhooksWrite), res.create)
+	r.Put("webhooks/<id>", auth.Require(auth.ScopeWebhooksWrite), res.update)
+	r.Delete("webhooks/<id>", auth.Require(auth.ScopeWebhooksWrite), res.delete)
+}
+
+type resource struct {
+	service Service
+	logger  log.Logger
+}
+
+func (r resource) get(c *routing.Context) error {
+	webhook, err := r.service.Get(c.Request.Context(), currentMembership(c).OrgID, c.Param("id"))
+	if err != nil {
+		return err
+	}
+	return c.Write(webhook)
+}
+
+func (r resource) query(c *routing.Context) error {
+	ctx := c.Request.Context()
+	orgID := currentMembership(c).OrgID
+	count, err := r.service.Count(ctx, orgID)
+	if err != nil {
+		return err
+	}
+	pages := pagination.NewFromRequest(c.Request, count)
+	webhooks, err := r.service.Query(ctx, orgID, pages.Offset(), pages.Limit())
+	if err != nil {
+		return err
+	}
+	pages.Items = webhooks
+	return c.Write(pages)
+}
+
+func (r resource) queryDeliveries(c *routing.Context) error {
+	ctx := c.Request.Context()
+	orgID := currentMembership(c).OrgID
+	count, err := r.service.CountDeliveries(ctx, orgID, c.Param("id"))
+	if err != nil {
+		return err
+	}
+	pages := pagination.NewFromRequest(c.Request, count)
+	deliveries, err := r.service.QueryDeliveries(ctx, orgID, c.Param("id"), pages.Offset(), pages.Limit())
+	if err != nil {
+		return err
+	}
+	pages.Items = deliveries
+	return c.Write(pages)
+}
+
+func (r resource) create(c *routing.Context) error {
+	var input WebhookRequest
+	if err := c.Read(&input); err != nil {
+		r.logger.With(c.Request.Context()).Info(err)
+		return errors.BadRequest("")
+	}
+	membership := currentMembership(c)
+	webhook, err := r.service.Create(c.Request.Context(), membership.OrgID, membership.UserID, input)
+	if err != nil {
+		return err
+	}
+	return c.WriteWithStatus(webhook, http.StatusCreated)
+}
+
+func (r resource) update(c *routing.Context) error {
+	var input WebhookRequest
+	if err := c.Read(&input); err != nil {
+		r.logger.With(c.Request.Context()).Info(err)
+		return errors.BadRequest("")
+	}
+	membership := currentMembership(c)
+	webhook, err := r.service.Update(c.Request.Context(), membership.OrgID, membership.UserID, c.Param("id"), input)
+	if err != nil {
+		return err
+	}
+	return c.Write(webhook)
+}
+
+func (r resource) delete(c *routing.Context) error {
+	membership := currentMembership(c)
+	webhook, err := r.service.Delete(c.Request.Context(), membership.OrgID, membership.UserID, c.Param("id"))
+	if err != nil {
+		return err
+	}
+	return c.Write(webhook)
+}
+
+// currentMembership returns the membership of the current user in the active organization.
+func currentMembership(c *routing.Context) entity.Membership {
+	membership, _ := auth.CurrentMembership(c.Request.Context())
+	return membership
+}
@@ -0,0 +1,46 @@
+package webhook
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/garaekz/gonvelope/internal/auth"
+	"github.com/garaekz/gonvelope/internal/entity"
+	"github.com/garaekz/gonvelope/internal/org"
+	"github.com/garaekz/gonvelope/internal/test"
+	"github.com/garaekz/gonvelope/pkg/log"
+)
+
+func TestAPI(t *testing.T) {
+	logger, _ := log.NewForTest()
+	router := test.MockRouter(logger)
+	repo := &mockRepository{
+		webhooks: []entity.Webhook{
+			{ID: "w1", OrgID: "100", URL: "https://example.com/hooks", Secret: "whsec_1", Events: []string{entity.WebhookMessageOpened}, Active: true},
+			{ID: "w2", OrgID: "org1", URL: "https://example.com/other", Events: []string{entity.WebhookMessageOpened}, Active: true},
+		},
+		deliveries: []entity.WebhookDelivery{{ID: "d1", OrgID: "100", WebhookID: "w1", Event: entity.WebhookMessageOpened, Status: entity.DeliverySucceeded}},
+	}
+	RegisterHandlers(router.Group("/"), NewService(repo, mockTransactional, &mockRecorder{}, logger), auth.MockAuthHandler, org.MockHandler, logger)
+	header := auth.MockAuthHeader()
+
+	tests := []test.APITestCase{
+		{Name: "get all", Method: "GET", URL: "/webhooks", Header: header, WantStatus: http.StatusOK, WantResponse: `*"total_count":1*`},
+		{Name: "get", Method: "GET", URL: "/webhooks/w1", Header: header, WantStatus: http.StatusOK, WantResponse: `*"url":"https://example.com/hooks"*`},
+		{Name: "get other org", Method: "GET", URL: "/webhooks/w2", Header: header, WantStatus: http.StatusNotFound},
+		{Name: "deliveries", Method: "GET", URL: "/webhooks/w1/deliveries", Header: header, WantStatus: http.StatusOK, WantResponse: `*"id":"d1"*`},
+		{Name: "deliveries other org", Method: "GET", URL: "/webhooks/w2/deliveries", Header: header, WantStatus: http.StatusNotFound},
+		{Name: "create", Method: "POST", URL: "/webhooks", Body: `{"url":"https://example.com/new","events":["message.clicked"]}`, Header: header, WantStatus: http.StatusCreated, WantResponse: `*"secret":"whsec_*`},
+		{Name: "create invalid", Method: "POST", URL: "/webhooks", Body: `{"url":"example","events":["message.clicked"]}`, Header: header, WantStatus: http.StatusBadRequest},
+		{Name: "create input error", Method: "POST", URL: "/webhooks", Body: `"url"}`, Header: header, WantStatus: http.StatusBadRequest},
+		{Name: "update", Method: "PUT", URL: "/webhooks/w1", Body: `{"url":"https://example.com/v2","events":["message.opened"],"active":false}`, Header: header, WantStatus: http.StatusOK, WantResponse: `*"active":false*`},
+		{Name: "delete", Method: "DELETE", URL: "/webhooks/w1", Header: header, WantStatus: http.StatusOK},
+		{Name: "delete verify", Method: "GET", URL: "/webhooks/w1", Header: header, WantStatus: http.StatusNotFound},
+		{Name: "viewer read", Method: "GET", URL: "/webhooks", Header: org.MockHeader(entity.RoleViewer), WantStatus: http.StatusForbidden},
+		{Name: "editor write", Method: "POST", URL: "/webhooks", Body: `{"url":"https://example.com/new","events":["message.clicked"]}`, Header: org.MockHeader(entity.RoleEditor), WantStatus: http.StatusForbidden},
+		{Name: "unauthorized", Method: "GET", URL: "/webhooks", WantStatus: http.StatusUnauthorized},
+	}
+	for _, tc := range tests {
+		test.Endpoint(t, router, tc)
+	}
+}
@@ -0,0 +1,220 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/garaekz/gonvelope/internal/entity"
+	"github.com/garaekz/gonvelope/pkg/log"
+)
+
+const (
+	// pollInterval is how often the dispatcher looks for deliveries to send.
+	pollInterval = 5 * time.Second
+	// batchSize is the maximum number of deliveries claimed at once.
+	batchSize = 50
+	// leaseDuration is how long a claimed delivery is reserved for the dispatcher that claimed it.
+	leaseDuration = 5 * time.Minute
+	// maxAttempts is the number of failed attempts after which a delivery is given up on.
+	maxAttempts = 8
+	// retryDelay is the delay before the first retry of a failed delivery. It doubles on every attempt.
+	retryDelay = time.Minute
+	// requestTimeout is how long an endpoint has to respond to a delivery.
+	requestTimeout = 10 * time.Second
+)
+
+// Headers of the requests sent to webhooks.
+const (
+	// EventHeader holds the name of the event.
+	EventHeader = "Gonvelope-Event"
+	// DeliveryHeader holds the ID of the delivery, which stays the same across retries.
+	DeliveryHeader = "Gonvelope-Delivery"
+	// SignatureHeader holds the signature of the request body, as returned by Sign.
+	SignatureHeader = "Gonvelope-Signature"
+)
+
+// Body is the JSON body of the requests sent to webhooks.
+type Body struct {
+	ID        string      `json:"id"`
+	Event     string      `json:"event"`
+	CreatedAt time.Time   `json:"created_at"`
+	Data      entity.JSON `json:"data"`
+}
+
+// Sign returns the signature of a request body sent at the given Unix time, in the form "t=<timestamp>,v1=<signature>"
+// where the signature is the hex-encoded HMAC-SHA256 of the timestamp, a dot and the body, keyed with the webhook secret.
+// Endpoints should compute the same signature and reject requests whose timestamp is too old.
+func Sign(secret string, timestamp int64, body []byte) string {
+	t := strconv.FormatInt(timestamp, 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(t + "."))
+	mac.Write(body)
+	return "t=" + t + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Dispatcher sends the pending deliveries to their webhooks, retrying with an exponential backoff
+// until the endpoint responds with a 2xx status code.
+type Dispatcher struct {
+	repo   Repository
+	client *http.Client
+	logger log.Logger
+}
+
+// NewDispatcher creates a new dispatcher sending deliveries with the HTTP client, or with newClient if nil.
+func NewDispatcher(repo Repository, client *http.Client, logger log.Logger) *Dispatcher {
+	if client == nil {
+		client = newClient()
+	}
+	return &Dispatcher{repo, client, logger}
+}
+
+// newClient creates the HTTP client deliveries are sent with. Webhook URLs are chosen by the organizations,
+// so the client refuses to connect to the addresses of internal services once the host name is resolved,
+// and does not follow redirects, which could lead there as well.
+func newClient() *http.Client {
+	dialer := &net.Dialer{Timeout: requestTimeout, Control: checkAddress}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// a proxy would be dialed instead of the endpoint, escaping the check
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{
+		Timeout:   requestTimeout,
+		Transport: transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// checkAddress rejects connections to private, loopback, link-local, multicast and unspecified addresses.
+func checkAddress(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() {
+		return fmt.Errorf("refusing to connect to the non-public address %s", host)
+	}
+	return nil
+}
+
+// Run sends the pending deliveries every pollInterval until the context is cancelled.
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		if _, err := d.Process(ctx, time.Now()); err != nil {
+			d.logger.With(ctx).Errorf("failed to process webhook deliveries: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Process sends the deliveries due at the given time and returns how many of them were claimed.
+func (d *Dispatcher) Process(ctx context.Context, now time.Time) (int, error) {
+	deliveries, err := d.repo.Claim(ctx, now, now.Add(leaseDuration), batchSize)
+	if err != nil {
+		return 0, err
+	}
+	for _, delivery := range deliveries {
+		d.deliver(ctx, delivery, now)
+	}
+	return len(deliveries), nil
+}
+
+// deliver sends a claimed delivery to its webhook and saves the outcome.
+func (d *Dispatcher) deliver(ctx context.Context, delivery entity.WebhookDelivery, now time.Time) {
+	webhook, err := d.repo.Get(ctx, delivery.OrgID, delivery.WebhookID)
+	if err == sql.ErrNoRows || err == nil && !webhook.Active {
+		d.fail(ctx, delivery, now, fmt.Errorf("the webhook is no longer active"))
+		return
+	}
+	if err != nil {
+		d.retry(ctx, delivery, now, err)
+		return
+	}
+
+	body, err := json.Marshal(Body{ID: delivery.ID, Event: delivery.Event, CreatedAt: delivery.CreatedAt, Data: delivery.Payload})
+	if err != nil {
+		d.fail(ctx, delivery, now, err)
+		return
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		d.fail(ctx, delivery, now, err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Gonvelope-Webhooks/1.0")
+	req.Header.Set(EventHeader, delivery.Event)
+	req.Header.Set(DeliveryHeader, delivery.ID)
+	req.Header.Set(SignatureHeader, Sign(webhook.Secret, now.Unix(), body))
+
+	res, err := d.client.Do(req)
+	if err != nil {
+		d.retry(ctx, delivery, now, err)
+		return
+	}
+	// the response is only kept as a hint of what went wrong
+	message, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+	_ = res.Body.Close()
+	delivery.ResponseStatus = res.StatusCode
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		d.retry(ctx, delivery, now, fmt.Errorf("%s: %s", res.Status, bytes.TrimSpace(message)))
+		return
+	}
+
+	delivery.Status = entity.DeliverySucceeded
+	delivery.Attempts++
+	delivery.LastError = ""
+	delivery.DeliveredAt = &now
+	d.save(ctx, delivery, now)
+}
+
+// retry puts the delivery back in the queue after a failed attempt, or gives up on it after maxAttempts.
+func (d *Dispatcher) retry(ctx context.Context, delivery entity.WebhookDelivery, now time.Time, cause error) {
+	delivery.Attempts++
+	if delivery.Attempts >= maxAttempts {
+		d.fail(ctx, delivery, now, cause)
+		return
+	}
+	d.logger.With(ctx, "delivery_id", delivery.ID).Infof("failed to deliver webhook event, attempt %d: %v", delivery.Attempts, cause)
+	delivery.Status = entity.DeliveryPending
+	delivery.NextAttemptAt = now.Add(retryDelay << (delivery.Attempts - 1))
+	delivery.LastError = cause.Error()
+	d.save(ctx, delivery, now)
+}
+
+// fail gives up on the delivery.
+func (d *Dispatcher) fail(ctx context.Context, delivery entity.WebhookDelivery, now time.Time, cause error) {
+	d.logger.With(ctx, "delivery_id", delivery.ID).Errorf("failed to deliver webhook event: %v", cause)
+	delivery.Status = entity.DeliveryFailed
+	delivery.LastError = cause.Error()
+	d.save(ctx, delivery, now)
+}
+
+// save persists the state of the delivery.
+func (d *Dispatcher) save(ctx context.Context, delivery entity.WebhookDelivery, now time.Time) {
+	delivery.UpdatedAt = now
+	if err := d.repo.UpdateDelivery(ctx, delivery); err != nil {
+		d.logger.With(ctx, "delivery_id", delivery.ID).Errorf("failed to save webhook delivery: %v", err)
+	}
+}
@@ -0,0 +1,138 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/garaekz/gonvelope/internal/entity"
+	"github.com/garaekz/gonvelope/pkg/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSign(t *testing.T) {
+	// computed with: printf '1700000000.{}' | openssl dgst -sha256 -hmac whsec_test
+	assert.Equal(t, "t=1700000000,v1=35495024f4ef3f94e5a93e22221544c4b75e9a42300cd965ab81cb85cd994e91", Sign("whsec_test", 1700000000, []byte("{}")))
+}
+
+func TestDispatcher_Process(t *testing.T) {
+	logger, _ := log.NewForTest()
+	now := time.Now()
+
+	var requests []*http.Request
+	var bodies [][]byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests = append(requests, r)
+		bodies = append(bodies, body)
+		if r.URL.Path == "/broken" {
+			http.Error(w, "boom", http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	repo := &mockRepository{
+		webhooks: []entity.Webhook{
+			{ID: "w1", OrgID: "org1", URL: server.URL + "/ok", Secret: "whsec_1", Active: true},
+			{ID: "w2", OrgID: "org1", URL: server.URL + "/broken", Secret: "whsec_2", Active: true},
+			{ID: "w3", OrgID: "org1", URL: server.URL + "/paused", Secret: "whsec_3", Active: false},
+		},
+		deliveries: []entity.WebhookDelivery{
+			{ID: "d1", OrgID: "org1", WebhookID: "w1", Event: entity.WebhookMessageOpened, Payload: entity.JSON(`{"message_id":"m1"}`), Status: entity.DeliveryPending, NextAttemptAt: now, CreatedAt: now},
+			{ID: "d2", OrgID: "org1", WebhookID: "w2", Event: entity.WebhookMessageOpened, Status: entity.DeliveryPending, NextAttemptAt: now},
+			{ID: "d3", OrgID: "org1", WebhookID: "w3", Event: entity.WebhookMessageOpened, Status: entity.DeliveryPending, NextAttemptAt: now},
+			{ID: "d4", OrgID: "org1", WebhookID: "w1", Event: entity.WebhookMessageOpened, Status: entity.DeliveryPending, NextAttemptAt: now.Add(time.Hour)},
+		},
+	}
+	d := NewDispatcher(repo, server.Client(), logger)
+
+	count, err := d.Process(context.Background(), now)
+	require.Nil(t, err)
+	assert.Equal(t, 3, count)
+	require.Len(t, requests, 2)
+
+	// the endpoint can verify the signature with its secret
+	assert.Equal(t, entity.WebhookMessageOpened, requests[0].Header.Get(EventHeader))
+	assert.Equal(t, "d1", requests[0].Header.Get(DeliveryHeader))
+	assert.Equal(t, Sign("whsec_1", now.Unix(), bodies[0]), requests[0].Header.Get(SignatureHeader))
+	var body Body
+	require.Nil(t, json.Unmarshal(bodies[0], &body))
+	assert.Equal(t, "d1", body.ID)
+	assert.JSONEq(t, `{"message_id":"m1"}`, string(body.Data))
+
+	deliveries := map[string]entity.WebhookDelivery{}
+	for _, delivery := range repo.deliveries {
+		deliveries[delivery.ID] = delivery
+	}
+	assert.Equal(t, entity.DeliverySucceeded, deliveries["d1"].Status)
+	assert.Equal(t, 200, deliveries["d1"].ResponseStatus)
+	assert.NotNil(t, deliveries["d1"].DeliveredAt)
+
+	assert.Equal(t, entity.DeliveryPending, deliveries["d2"].Status)
+	assert.Equal(t, 1, deliveries["d2"].Attempts)
+	assert.Equal(t, 500, deliveries["d2"].ResponseStatus)
+	assert.Equal(t, now.Add(retryDelay), deliveries["d2"].NextAttemptAt)
+	assert.Equal(t, "500 Internal Server Error: boom", deliveries["d2"].LastError)
+
+	assert.Equal(t, entity.DeliveryFailed, deliveries["d3"].Status)
+	assert.Equal(t, entity.DeliveryPending, deliveries["d4"].Status)
+}
+
+func Test_newClient(t *testing.T) {
+	redirected := false
+	internal := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		redirected = true
+	}))
+	defer internal.Close()
+
+	// the test server listens on a loopback address, which webhooks must not reach
+	_, err := newClient().Get(internal.URL)
+	assert.NotNil(t, err)
+
+	// redirects are not followed
+	server := httptest.NewServer(http.RedirectHandler(internal.URL, http.StatusFound))
+	defer server.Close()
+	client := server.Client()
+	client.CheckRedirect = newClient().CheckRedirect
+	res, err := client.Get(server.URL)
+	require.Nil(t, err)
+	_ = res.Body.Close()
+	assert.Equal(t, http.StatusFound, res.StatusCode)
+	assert.False(t, redirected)
+}
+
+func Test_checkAddress(t *testing.T) {
+	for _, address := range []string{"127.0.0.1:80", "10.1.2.3:443", "192.168.0.1:80", "169.254.169.254:80", "[::1]:80", "[fe80::1]:80", "[fd00::1]:80", "0.0.0.0:80"} {
+		assert.NotNil(t, checkAddress("tcp", address, nil), address)
+	}
+	for _, address := range []string{"93.184.216.34:443", "[2606:2800:220:1::1]:443"} {
+		assert.Nil(t, checkAddress("tcp", address, nil), address)
+	}
+}
+
+func TestDispatcher_retry(t *testing.T) {
+	logger, _ := log.NewForTest()
+	now := time.Now()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	repo := &mockRepository{
+		webhooks:   []entity.Webhook{{ID: "w1", OrgID: "org1", URL: server.URL, Active: true}},
+		deliveries: []entity.WebhookDelivery{{ID: "d1", OrgID: "org1", WebhookID: "w1", Status: entity.DeliveryPending, NextAttemptAt: now}},
+	}
+	d := NewDispatcher(repo, server.Client(), logger)
+	for i := 0; i < maxAttempts; i++ {
+		_, err := d.Process(context.Background(), repo.deliveries[0].NextAttemptAt)
+		require.Nil(t, err)
+	}
+	assert.Equal(t, entity.DeliveryFailed, repo.deliveries[0].Status)
+	assert.Equal(t, maxAttempts, repo.deliveries[0].Attempts)
+	assert.Equal(t, http.StatusServiceUnavailable, repo.deliveries[0].ResponseStatus)
+}
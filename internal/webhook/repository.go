@@ -0,0 +1,154 @@
+package webhook
+
+import (
+	"context"
+	"time"
+
+	"github.com/garaekz/gonvelope/internal/entity"
+	"github.com/garaekz/gonvelope/pkg/dbcontext"
+	"github.com/garaekz/gonvelope/pkg/log"
+	dbx "github.com/go-ozzo/ozzo-dbx"
+)
+
+// Repository encapsulates the logic to access webhooks and their deliveries from the data source.
+type Repository interface {
+	// Get returns the webhook with the specified ID owned by the organization.
+	Get(ctx context.Context, orgID, id string) (entity.Webhook, error)
+	// Count returns the number of webhooks of the organization.
+	Count(ctx context.Context, orgID string) (int, error)
+	// Query returns the webhooks of the organization with the given offset and limit, oldest first.
+	Query(ctx context.Context, orgID string, offset, limit int) ([]entity.Webhook, error)
+	// QuerySubscribed returns the active webhooks of the organization subscribed to the event.
+	QuerySubscribed(ctx context.Context, orgID, event string) ([]entity.Webhook, error)
+	// Create saves a new webhook in the storage.
+	Create(ctx context.Context, webhook entity.Webhook) error
+	// Update saves the changes to a webhook in the storage.
+	Update(ctx context.Context, webhook entity.Webhook) error
+	// Delete removes the webhook with the specified ID owned by the organization, along with its deliveries.
+	Delete(ctx context.Context, orgID, id string) error
+	// CountDeliveries returns the number of deliveries of the webhook.
+	CountDeliveries(ctx context.Context, webhookID string) (int, error)
+	// QueryDeliveries returns the deliveries of the webhook with the given offset and limit, newest first.
+	QueryDeliveries(ctx context.Context, webhookID string, offset, limit int) ([]entity.WebhookDelivery, error)
+	// CreateDelivery saves a new delivery in the storage.
+	CreateDelivery(ctx context.Context, delivery entity.WebhookDelivery) error
+	// UpdateDelivery saves the state of the delivery.
+	UpdateDelivery(ctx context.Context, delivery entity.WebhookDelivery) error
+	// Claim hands the given number of deliveries due at the given time over to a dispatcher until leaseUntil.
+	// Deliveries whose lease has expired are claimed again.
+	Claim(ctx context.Context, now, leaseUntil time.Time, limit int) ([]entity.WebhookDelivery, error)
+}
+
+// repository persists webhooks in database
+type repository struct {
+	db     *dbcontext.DB
+	logger log.Logger
+}
+
+// NewRepository creates a new webhook repository
+func NewRepository(db *dbcontext.DB, logger log.Logger) Repository {
+	return repository{db, logger}
+}
+
+// Get returns the webhook with the specified ID owned by the organization.
+func (r repository) Get(ctx context.Context, orgID, id string) (entity.Webhook, error) {
+	var webhook entity.Webhook
+	err := r.db.With(ctx).Select().From(webhook.TableName()).
+		Where(dbx.HashExp{"id": id, "org_id": orgID}).One(&webhook)
+	return webhook, err
+}
+
+// Count returns the number of webhooks of the organization.
+func (r repository) Count(ctx context.Context, orgID string) (int, error) {
+	var count int
+	err := r.db.With(ctx).Select("COUNT(*)").From(entity.Webhook{}.TableName()).
+		Where(dbx.HashExp{"org_id": orgID}).Row(&count)
+	return count, err
+}
+
+// Query returns the webhooks of the organization with the given offset and limit, oldest first.
+func (r repository) Query(ctx context.Context, orgID string, offset, limit int) ([]entity.Webhook, error) {
+	webhooks := []entity.Webhook{}
+	err := r.db.With(ctx).Select().From(entity.Webhook{}.TableName()).
+		Where(dbx.HashExp{"org_id": orgID}).
+		OrderBy("created_at", "id").
+		Offset(int64(offset)).
+		Limit(int64(limit)).
+		All(&webhooks)
+	return webhooks, err
+}
+
+// QuerySubscribed returns the active webhooks of the organization subscribed to the event.
+func (r repository) QuerySubscribed(ctx context.Context, orgID, event string) ([]entity.Webhook, error) {
+	var webhooks []entity.Webhook
+	err := r.db.With(ctx).Select().From(entity.Webhook{}.TableName()).
+		Where(dbx.HashExp{"org_id": orgID, "active": true}).
+		AndWhere(dbx.NewExp("{:event} = ANY(events)", dbx.Params{"event": event})).
+		All(&webhooks)
+	return webhooks, err
+}
+
+// Create saves a new webhook in the storage.
+func (r repository) Create(ctx context.Context, webhook entity.Webhook) error {
+	return r.db.With(ctx).Model(&webhook).Insert()
+}
+
+// Update saves the changes to a webhook in the storage.
+func (r repository) Update(ctx context.Context, webhook entity.Webhook) error {
+	return r.db.With(ctx).Model(&webhook).Update("URL", "Events", "Active", "UpdatedAt")
+}
+
+// Delete removes the webhook with the specified ID owned by the organization, along with its deliveries.
+func (r repository) Delete(ctx context.Context, orgID, id string) error {
+	_, err := r.db.With(ctx).Delete(entity.Webhook{}.TableName(), dbx.HashExp{"id": id, "org_id": orgID}).Execute()
+	return err
+}
+
+// CountDeliveries returns the number of deliveries of the webhook.
+func (r repository) CountDeliveries(ctx context.Context, webhookID string) (int, error) {
+	var count int
+	err := r.db.With(ctx).Select("COUNT(*)").From(entity.WebhookDelivery{}.TableName()).
+		Where(dbx.HashExp{"webhook_id": webhookID}).Row(&count)
+	return count, err
+}
+
+// QueryDeliveries returns the deliveries of the webhook with the given offset and limit, newest first.
+func (r repository) QueryDeliveries(ctx context.Context, webhookID string, offset, limit int) ([]entity.WebhookDelivery, error) {
+	deliveries := []entity.WebhookDelivery{}
+	err := r.db.With(ctx).Select().From(entity.WebhookDelivery{}.TableName()).
+		Where(dbx.HashExp{"webhook_id": webhookID}).
+		OrderBy("created_at DESC", "id DESC").
+		Offset(int64(offset)).
+		Limit(int64(limit)).
+		All(&deliveries)
+	return deliveries, err
+}
+
+// CreateDelivery saves a new delivery in the storage.
+func (r repository) CreateDelivery(ctx context.Context, delivery entity.WebhookDelivery) error {
+	return r.db.With(ctx).Model(&delivery).Exclude("DeliveredAt").Insert()
+}
+
+// UpdateDelivery saves the state of the delivery.
+func (r repository) UpdateDelivery(ctx context.Context, delivery entity.WebhookDelivery) error {
+	return r.db.With(ctx).Model(&delivery).
+		Update("Status", "Attempts", "NextAttemptAt", "LastError", "ResponseStatus", "DeliveredAt", "UpdatedAt")
+}
+
+// Claim hands the given number of deliveries due at the given time over to a dispatcher until leaseUntil.
+// Deliveries whose lease has expired are claimed again.
+func (r repository) Claim(ctx context.Context, now, leaseUntil time.Time, limit int) ([]entity.WebhookDelivery, error) {
+	var deliveries []entity.WebhookDelivery
+	err := r.db.With(ctx).NewQuery(`
+		UPDATE webhook_deliveries SET status = 'sending', next_attempt_at = {:lease}, updated_at = {:now}
+		WHERE id IN (
+			SELECT id FROM webhook_deliveries
+			WHERE status IN ('pending', 'sending') AND next_attempt_at <= {:now}
+			ORDER BY next_attempt_at
+			LIMIT {:limit}
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING *`).
+		Bind(dbx.Params{"now": now, "lease": leaseUntil, "limit": limit}).All(&deliveries)
+	return deliveries, err
+}
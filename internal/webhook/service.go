@@ -0,0 +1,247 @@
+// Package webhook notifies the endpoints of organizations of the events they subscribe to.
+// Events are saved as deliveries, which a dispatcher sends in the background and retries until
+// the endpoint acknowledges them.
+package webhook
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/url"
+	"time"
+
+	"github.com/garaekz/gonvelope/internal/audit"
+	"github.com/garaekz/gonvelope/internal/entity"
+	"github.com/garaekz/gonvelope/pkg/dbcontext"
+	"github.com/garaekz/gonvelope/pkg/log"
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/go-ozzo/ozzo-validation/v4/is"
+)
+
+// secretPrefix is the prefix of every webhook signing secret.
+const secretPrefix = "whsec_"
+
+// events lists the events webhooks can subscribe to, as expected by validation.In.
+var events = func() []interface{} {
+	e := make([]interface{}, len(entity.WebhookEvents))
+	for i, event := range entity.WebhookEvents {
+		e[i] = event
+	}
+	return e
+}()
+
+// Service encapsulates usecase logic for webhooks.
+type Service interface {
+	Get(ctx context.Context, orgID, id string) (entity.Webhook, error)
+	Query(ctx context.Context, orgID string, offset, limit int) ([]entity.Webhook, error)
+	Count(ctx context.Context, orgID string) (int, error)
+	Create(ctx context.Context, orgID, userID string, input WebhookRequest) (CreatedWebhook, error)
+	Update(ctx context.Context, orgID, userID, id string, input WebhookRequest) (entity.Webhook, error)
+	Delete(ctx context.Context, orgID, userID, id string) (entity.Webhook, error)
+	// QueryDeliveries returns the deliveries of a webhook of the organization, newest first.
+	QueryDeliveries(ctx context.Context, orgID, id string, offset, limit int) ([]entity.WebhookDelivery, error)
+	CountDeliveries(ctx context.Context, orgID, id string) (int, error)
+	// Emit queues the delivery of an event of the organization to the webhooks subscribed to it.
+	Emit(ctx context.Context, orgID, event string, data interface{}) error
+}
+
+// CreatedWebhook represents a newly created webhook. The signing secret is only ever returned once.
+type CreatedWebhook struct {
+	entity.Webhook
+	Secret string `json:"secret"`
+}
+
+// WebhookRequest represents a webhook creation or update request.
+type WebhookRequest struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+	// Active pauses the deliveries when false. It defaults to true on creation and is left unchanged on update.
+	Active *bool `json:"active"`
+}
+
+// Validate validates the WebhookRequest fields.
+func (m WebhookRequest) Validate() error {
+	return validation.ValidateStruct(&m,
+		validation.Field(&m.URL, validation.Required, validation.Length(0, 2048), is.URL, validation.By(validateScheme)),
+		validation.Field(&m.Events, validation.Required, validation.Each(validation.In(events...))),
+	)
+}
+
+// validateScheme checks that the URL is an HTTP or HTTPS URL.
+func validateScheme(value interface{}) error {
+	u, err := url.Parse(value.(string))
+	if err != nil || u.Scheme != "http" && u.Scheme != "https" {
+		return validation.NewError("validation_url_scheme", "must be an http or https URL")
+	}
+	return nil
+}
+
+type service struct {
+	repo          Repository
+	transactional dbcontext.TransactionFunc
+	auditor       audit.Recorder
+	logger        log.Logger
+}
+
+// NewService creates a new webhook service.
+func NewService(repo Repository, transactional dbcontext.TransactionFunc, auditor audit.Recorder, logger log.Logger) Service {
+	return service{repo, transactional, auditor, logger}
+}
+
+// Get returns the webhook with the specified ID owned by the organization.
+func (s service) Get(ctx context.Context, orgID, id string) (entity.Webhook, error) {
+	return s.repo.Get(ctx, orgID, id)
+}
+
+// Query returns the webhooks of the organization with the specified offset and limit.
+func (s service) Query(ctx context.Context, orgID string, offset, limit int) ([]entity.Webhook, error) {
+	return s.repo.Query(ctx, orgID, offset, limit)
+}
+
+// Count returns the number of webhooks of the organization.
+func (s service) Count(ctx context.Context, orgID string) (int, error) {
+	return s.repo.Count(ctx, orgID)
+}
+
+// Create creates a new webhook of the organization on behalf of the user, along with its signing secret.
+func (s service) Create(ctx context.Context, orgID, userID string, req WebhookRequest) (CreatedWebhook, error) {
+	if err := req.Validate(); err != nil {
+		return CreatedWebhook{}, err
+	}
+	secret, err := generateSecret()
+	if err != nil {
+		return CreatedWebhook{}, err
+	}
+	now := time.Now()
+	webhook := entity.Webhook{
+		ID:        entity.GenerateID(),
+		OrgID:     orgID,
+		UserID:    userID,
+		URL:       req.URL,
+		Secret:    secret,
+		Events:    req.Events,
+		Active:    req.Active == nil || *req.Active,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	err = s.transactional(ctx, func(ctx context.Context) error {
+		if err := s.repo.Create(ctx, webhook); err != nil {
+			return err
+		}
+		return s.record(ctx, audit.ActionWebhookCreated, userID, webhook, nil, webhook)
+	})
+	if err != nil {
+		return CreatedWebhook{}, err
+	}
+	return CreatedWebhook{Webhook: webhook, Secret: secret}, nil
+}
+
+// Update changes the URL, the events and, if given, the state of the webhook on behalf of the user.
+func (s service) Update(ctx context.Context, orgID, userID, id string, req WebhookRequest) (entity.Webhook, error) {
+	if err := req.Validate(); err != nil {
+		return entity.Webhook{}, err
+	}
+	webhook, err := s.repo.Get(ctx, orgID, id)
+	if err != nil {
+		return webhook, err
+	}
+	before := webhook
+	webhook.URL = req.URL
+	webhook.Events = req.Events
+	if req.Active != nil {
+		webhook.Active = *req.Active
+	}
+	webhook.UpdatedAt = time.Now()
+	err = s.transactional(ctx, func(ctx context.Context) error {
+		if err := s.repo.Update(ctx, webhook); err != nil {
+			return err
+		}
+		return s.record(ctx, audit.ActionWebhookUpdated, userID, webhook, before, webhook)
+	})
+	return webhook, err
+}
+
+// Delete deletes the webhook along with its deliveries on behalf of the user.
+func (s service) Delete(ctx context.Context, orgID, userID, id string) (entity.Webhook, error) {
+	webhook, err := s.repo.Get(ctx, orgID, id)
+	if err != nil {
+		return webhook, err
+	}
+	err = s.transactional(ctx, func(ctx context.Context) error {
+		if err := s.repo.Delete(ctx, orgID, id); err != nil {
+			return err
+		}
+		return s.record(ctx, audit.ActionWebhookDeleted, userID, webhook, webhook, nil)
+	})
+	return webhook, err
+}
+
+// QueryDeliveries returns the deliveries of a webhook of the organization with the specified offset and limit.
+func (s service) QueryDeliveries(ctx context.Context, orgID, id string, offset, limit int) ([]entity.WebhookDelivery, error) {
+	if _, err := s.repo.Get(ctx, orgID, id); err != nil {
+		return nil, err
+	}
+	return s.repo.QueryDeliveries(ctx, id, offset, limit)
+}
+
+// CountDeliveries returns the number of deliveries of a webhook of the organization.
+func (s service) CountDeliveries(ctx context.Context, orgID, id string) (int, error) {
+	if _, err := s.repo.Get(ctx, orgID, id); err != nil {
+		return 0, err
+	}
+	return s.repo.CountDeliveries(ctx, id)
+}
+
+// Emit queues the delivery of an event of the organization to the active webhooks subscribed to it.
+// When the context carries a transaction, the deliveries are saved in it so that they are only sent
+// if the change that caused the event is kept.
+func (s service) Emit(ctx context.Context, orgID, event string, data interface{}) error {
+	webhooks, err := s.repo.QuerySubscribed(ctx, orgID, event)
+	if err != nil || len(webhooks) == 0 {
+		return err
+	}
+	payload, err := entity.NewJSON(data)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, webhook := range webhooks {
+		err := s.repo.CreateDelivery(ctx, entity.WebhookDelivery{
+			ID:            entity.GenerateID(),
+			OrgID:         orgID,
+			WebhookID:     webhook.ID,
+			Event:         event,
+			Payload:       payload,
+			Status:        entity.DeliveryPending,
+			NextAttemptAt: now,
+			CreatedAt:     now,
+			UpdatedAt:     now,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// record records the change made by the user to the webhook in the audit log.
+// The before and after states are nil when the webhook is created and deleted respectively.
+func (s service) record(ctx context.Context, action, userID string, webhook entity.Webhook, before, after interface{}) error {
+	return s.auditor.Record(ctx, audit.Event{
+		Action:     action,
+		OrgID:      webhook.OrgID,
+		ActorID:    userID,
+		TargetType: "webhook",
+		TargetID:   webhook.ID,
+		Diff:       audit.Diff(before, after),
+	})
+}
+
+// generateSecret returns a new random signing secret.
+func generateSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return secretPrefix + hex.EncodeToString(b), nil
+}
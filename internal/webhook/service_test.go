@@ -0,0 +1,244 @@
+package webhook
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/garaekz/gonvelope/internal/audit"
+	"github.com/garaekz/gonvelope/internal/entity"
+	"github.com/garaekz/gonvelope/pkg/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebhookRequest_Validate(t *testing.T) {
+	tests := []struct {
+		name      string
+		model     WebhookRequest
+		wantError bool
+	}{
+		{"success", WebhookRequest{URL: "https://example.com/hooks", Events: []string{entity.WebhookMessageOpened}}, false},
+		{"missing url", WebhookRequest{Events: []string{entity.WebhookMessageOpened}}, true},
+		{"invalid scheme", WebhookRequest{URL: "ftp://example.com/hooks", Events: []string{entity.WebhookMessageOpened}}, true},
+		{"missing events", WebhookRequest{URL: "https://example.com/hooks"}, true},
+		{"unknown event", WebhookRequest{URL: "https://example.com/hooks", Events: []string{"message.eaten"}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.wantError, tt.model.Validate() != nil)
+		})
+	}
+}
+
+func Test_service_CRUD(t *testing.T) {
+	logger, _ := log.NewForTest()
+	auditor := &mockRecorder{}
+	s := NewService(&mockRepository{}, mockTransactional, auditor, logger)
+	ctx := context.Background()
+
+	created, err := s.Create(ctx, "org1", "100", WebhookRequest{URL: "https://example.com/hooks", Events: []string{entity.WebhookMessageOpened}})
+	require.Nil(t, err)
+	assert.True(t, created.Active)
+	assert.True(t, strings.HasPrefix(created.Secret, secretPrefix))
+	_, err = s.Create(ctx, "org1", "100", WebhookRequest{URL: "https://example.com/hooks"})
+	assert.NotNil(t, err)
+
+	inactive := false
+	webhook, err := s.Update(ctx, "org1", "100", created.ID, WebhookRequest{URL: "https://example.com/v2", Events: []string{entity.WebhookMessageClicked}, Active: &inactive})
+	require.Nil(t, err)
+	assert.Equal(t, "https://example.com/v2", webhook.URL)
+	assert.False(t, webhook.Active)
+	webhook, err = s.Update(ctx, "org1", "100", created.ID, WebhookRequest{URL: "https://example.com/v2", Events: []string{entity.WebhookMessageClicked}})
+	require.Nil(t, err)
+	assert.False(t, webhook.Active)
+	_, err = s.Update(ctx, "org2", "100", created.ID, WebhookRequest{URL: "https://example.com/v2", Events: []string{entity.WebhookMessageClicked}})
+	assert.Equal(t, sql.ErrNoRows, err)
+
+	count, _ := s.Count(ctx, "org1")
+	assert.Equal(t, 1, count)
+	webhooks, _ := s.Query(ctx, "org1", 0, 10)
+	assert.Len(t, webhooks, 1)
+
+	_, err = s.Delete(ctx, "org1", "100", created.ID)
+	require.Nil(t, err)
+	_, err = s.Get(ctx, "org1", created.ID)
+	assert.Equal(t, sql.ErrNoRows, err)
+
+	if assert.Len(t, auditor.events, 4) {
+		assert.Equal(t, audit.ActionWebhookCreated, auditor.events[0].Action)
+		assert.Equal(t, audit.ActionWebhookDeleted, auditor.events[3].Action)
+		// the secret is never recorded
+		assert.NotContains(t, auditor.events[0].Diff, "secret")
+	}
+}
+
+func Test_service_Emit(t *testing.T) {
+	logger, _ := log.NewForTest()
+	repo := &mockRepository{webhooks: []entity.Webhook{
+		{ID: "w1", OrgID: "org1", Events: []string{entity.WebhookMessageOpened, entity.WebhookMessageClicked}, Active: true},
+		{ID: "w2", OrgID: "org1", Events: []string{entity.WebhookMessageClicked}, Active: true},
+		{ID: "w3", OrgID: "org1", Events: []string{entity.WebhookMessageOpened}, Active: false},
+		{ID: "w4", OrgID: "org2", Events: []string{entity.WebhookMessageOpened}, Active: true},
+	}}
+	s := NewService(repo, mockTransactional, &mockRecorder{}, logger)
+	ctx := context.Background()
+
+	err := s.Emit(ctx, "org1", entity.WebhookMessageOpened, map[string]interface{}{"message_id": "m1"})
+	require.Nil(t, err)
+	if assert.Len(t, repo.deliveries, 1) {
+		assert.Equal(t, "w1", repo.deliveries[0].WebhookID)
+		assert.Equal(t, entity.DeliveryPending, repo.deliveries[0].Status)
+		assert.JSONEq(t, `{"message_id":"m1"}`, string(repo.deliveries[0].Payload))
+	}
+
+	require.Nil(t, s.Emit(ctx, "org1", entity.WebhookMessageClicked, nil))
+	assert.Len(t, repo.deliveries, 3)
+	count, err := s.CountDeliveries(ctx, "org1", "w1")
+	require.Nil(t, err)
+	assert.Equal(t, 2, count)
+	_, err = s.QueryDeliveries(ctx, "org2", "w1", 0, 10)
+	assert.Equal(t, sql.ErrNoRows, err)
+}
+
+func mockTransactional(ctx context.Context, f func(ctx context.Context) error) error {
+	return f(ctx)
+}
+
+type mockRecorder struct {
+	events []audit.Event
+}
+
+func (m *mockRecorder) Record(_ context.Context, event audit.Event) error {
+	m.events = append(m.events, event)
+	return nil
+}
+
+// mockRepository keeps webhooks and deliveries in memory.
+type mockRepository struct {
+	webhooks   []entity.Webhook
+	deliveries []entity.WebhookDelivery
+}
+
+func (m *mockRepository) Get(_ context.Context, orgID, id string) (entity.Webhook, error) {
+	for _, webhook := range m.webhooks {
+		if webhook.ID == id && webhook.OrgID == orgID {
+			return webhook, nil
+		}
+	}
+	return entity.Webhook{}, sql.ErrNoRows
+}
+
+func (m *mockRepository) Count(ctx context.Context, orgID string) (int, error) {
+	webhooks, err := m.Query(ctx, orgID, 0, len(m.webhooks))
+	return len(webhooks), err
+}
+
+func (m *mockRepository) Query(_ context.Context, orgID string, offset, limit int) ([]entity.Webhook, error) {
+	webhooks := []entity.Webhook{}
+	for _, webhook := range m.webhooks {
+		if webhook.OrgID == orgID {
+			webhooks = append(webhooks, webhook)
+		}
+	}
+	if offset >= len(webhooks) {
+		return []entity.Webhook{}, nil
+	}
+	webhooks = webhooks[offset:]
+	if limit < len(webhooks) {
+		webhooks = webhooks[:limit]
+	}
+	return webhooks, nil
+}
+
+func (m *mockRepository) QuerySubscribed(_ context.Context, orgID, event string) ([]entity.Webhook, error) {
+	var webhooks []entity.Webhook
+	for _, webhook := range m.webhooks {
+		if webhook.OrgID != orgID || !webhook.Active {
+			continue
+		}
+		for _, e := range webhook.Events {
+			if e == event {
+				webhooks = append(webhooks, webhook)
+			}
+		}
+	}
+	return webhooks, nil
+}
+
+func (m *mockRepository) Create(_ context.Context, webhook entity.Webhook) error {
+	m.webhooks = append(m.webhooks, webhook)
+	return nil
+}
+
+func (m *mockRepository) Update(_ context.Context, webhook entity.Webhook) error {
+	for i, item := range m.webhooks {
+		if item.ID == webhook.ID {
+			m.webhooks[i] = webhook
+		}
+	}
+	return nil
+}
+
+func (m *mockRepository) Delete(_ context.Context, orgID, id string) error {
+	for i, webhook := range m.webhooks {
+		if webhook.ID == id && webhook.OrgID == orgID {
+			m.webhooks = append(m.webhooks[:i], m.webhooks[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func (m *mockRepository) CountDeliveries(ctx context.Context, webhookID string) (int, error) {
+	deliveries, err := m.QueryDeliveries(ctx, webhookID, 0, len(m.deliveries))
+	return len(deliveries), err
+}
+
+func (m *mockRepository) QueryDeliveries(_ context.Context, webhookID string, offset, limit int) ([]entity.WebhookDelivery, error) {
+	deliveries := []entity.WebhookDelivery{}
+	for i := len(m.deliveries) - 1; i >= 0; i-- {
+		if m.deliveries[i].WebhookID == webhookID {
+			deliveries = append(deliveries, m.deliveries[i])
+		}
+	}
+	if offset >= len(deliveries) {
+		return []entity.WebhookDelivery{}, nil
+	}
+	deliveries = deliveries[offset:]
+	if limit < len(deliveries) {
+		deliveries = deliveries[:limit]
+	}
+	return deliveries, nil
+}
+
+func (m *mockRepository) CreateDelivery(_ context.Context, delivery entity.WebhookDelivery) error {
+	m.deliveries = append(m.deliveries, delivery)
+	return nil
+}
+
+func (m *mockRepository) UpdateDelivery(_ context.Context, delivery entity.WebhookDelivery) error {
+	for i, item := range m.deliveries {
+		if item.ID == delivery.ID {
+			m.deliveries[i] = delivery
+		}
+	}
+	return nil
+}
+
+func (m *mockRepository) Claim(_ context.Context, now, leaseUntil time.Time, limit int) ([]entity.WebhookDelivery, error) {
+	var claimed []entity.WebhookDelivery
+	for i, delivery := range m.deliveries {
+		if len(claimed) == limit {
+			break
+		}
+		if (delivery.Status == entity.DeliveryPending || delivery.Status == entity.DeliverySending) && !delivery.NextAttemptAt.After(now) {
+			m.deliveries[i].Status = entity.DeliverySending
+			m.deliveries[i].NextAttemptAt = leaseUntil
+			claimed = append(claimed, m.deliveries[i])
+		}
+	}
+	return claimed, nil
+}
@@ -0,0 +1,16 @@
+ALTER TABLE users ALTER COLUMN active SET DEFAULT FALSE;
+ALTER TABLE users ADD COLUMN email_verified_at TIMESTAMP;
+UPDATE users SET email_verified_at = created_at WHERE active;
+
+CREATE TABLE user_tokens (
+    id VARCHAR PRIMARY KEY,
+    user_id VARCHAR NOT NULL,
+    purpose VARCHAR NOT NULL,
+    token_hash VARCHAR NOT NULL UNIQUE,
+    expires_at TIMESTAMP NOT NULL,
+    used_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX user_tokens_user_purpose_idx ON user_tokens (user_id, purpose, created_at);
@@ -0,0 +1,14 @@
+ALTER TABLE users ADD COLUMN totp_secret VARCHAR NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN totp_enabled_at TIMESTAMP;
+ALTER TABLE users ADD COLUMN totp_last_step BIGINT NOT NULL DEFAULT 0;
+
+CREATE TABLE user_recovery_codes (
+    id VARCHAR PRIMARY KEY,
+    user_id VARCHAR NOT NULL,
+    code_hash VARCHAR NOT NULL,
+    used_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX user_recovery_codes_user_idx ON user_recovery_codes (user_id);
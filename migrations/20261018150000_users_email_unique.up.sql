@@ -0,0 +1,22 @@
+-- The unique index cannot be built while users differing only by the case of their email exist.
+-- Such users must be merged or removed by hand first; they can be listed with:
+--
+--   SELECT LOWER(email), array_agg(id ORDER BY created_at) FROM users GROUP BY LOWER(email) HAVING COUNT(*) > 1;
+--
+-- The migration stops with that list rather than with a bare unique violation when any is found.
+DO $$
+DECLARE
+    duplicates TEXT;
+BEGIN
+    SELECT string_agg(email || ' (' || ids || ')', ', ') INTO duplicates
+    FROM (
+        SELECT LOWER(email) AS email, string_agg(id, ', ' ORDER BY created_at) AS ids
+        FROM users GROUP BY LOWER(email) HAVING COUNT(*) > 1
+    ) AS duplicate;
+    IF duplicates IS NOT NULL THEN
+        RAISE EXCEPTION 'users with case-variant duplicate emails must be merged before emails can be made unique: %', duplicates;
+    END IF;
+END $$;
+
+-- emails are compared case-insensitively, so the index is built on the lowercased address
+CREATE UNIQUE INDEX users_email_lower_idx ON users (LOWER(email));
@@ -0,0 +1,14 @@
+-- databases migrated before the baseline created the templates table get it here
+CREATE TABLE IF NOT EXISTS templates (
+    id VARCHAR PRIMARY KEY,
+    name VARCHAR NOT NULL,
+    subject VARCHAR NOT NULL,
+    body TEXT NOT NULL,
+    to_email VARCHAR NOT NULL,
+    from_email VARCHAR NOT NULL,
+    from_name VARCHAR NOT NULL,
+    user_id VARCHAR NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id)
+);
@@ -0,0 +1,6 @@
+ALTER TABLE api_keys DROP COLUMN org_id;
+ALTER TABLE templates DROP COLUMN org_id;
+ALTER TABLE user_provider_accounts DROP COLUMN org_id;
+DROP TABLE invitations;
+DROP TABLE memberships;
+DROP TABLE organizations;
@@ -0,0 +1,55 @@
+CREATE TABLE organizations (
+    id VARCHAR PRIMARY KEY,
+    name VARCHAR NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE memberships (
+    org_id VARCHAR NOT NULL,
+    user_id VARCHAR NOT NULL,
+    role VARCHAR NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (org_id, user_id),
+    FOREIGN KEY (org_id) REFERENCES organizations(id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX memberships_user_idx ON memberships (user_id);
+
+CREATE TABLE invitations (
+    id VARCHAR PRIMARY KEY,
+    org_id VARCHAR NOT NULL,
+    email VARCHAR NOT NULL,
+    role VARCHAR NOT NULL,
+    token_hash VARCHAR NOT NULL UNIQUE,
+    invited_by VARCHAR NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    accepted_at TIMESTAMP,
+    declined_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (org_id) REFERENCES organizations(id) ON DELETE CASCADE,
+    FOREIGN KEY (invited_by) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX invitations_org_idx ON invitations (org_id);
+
+-- every existing user gets a personal organization sharing the user ID
+INSERT INTO organizations (id, name) SELECT id, name FROM users;
+INSERT INTO memberships (org_id, user_id, role) SELECT id, id, 'owner' FROM users;
+
+-- resources owned by a user move to the personal organization of that user
+ALTER TABLE user_provider_accounts ADD COLUMN org_id VARCHAR REFERENCES organizations(id) ON DELETE CASCADE;
+UPDATE user_provider_accounts SET org_id = user_id;
+ALTER TABLE user_provider_accounts ALTER COLUMN org_id SET NOT NULL;
+CREATE INDEX user_provider_accounts_org_idx ON user_provider_accounts (org_id);
+
+ALTER TABLE templates ADD COLUMN org_id VARCHAR REFERENCES organizations(id) ON DELETE CASCADE;
+UPDATE templates SET org_id = user_id;
+ALTER TABLE templates ALTER COLUMN org_id SET NOT NULL;
+CREATE INDEX templates_org_idx ON templates (org_id);
+
+ALTER TABLE api_keys ADD COLUMN org_id VARCHAR REFERENCES organizations(id) ON DELETE CASCADE;
+UPDATE api_keys SET org_id = user_id;
+ALTER TABLE api_keys ALTER COLUMN org_id SET NOT NULL;
+CREATE INDEX api_keys_org_idx ON api_keys (org_id, user_id);
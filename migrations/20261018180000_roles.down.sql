@@ -0,0 +1,5 @@
+ALTER TABLE invitations DROP CONSTRAINT invitations_role_check;
+ALTER TABLE memberships DROP CONSTRAINT memberships_role_check;
+
+UPDATE invitations SET role = 'member' WHERE role IN ('editor', 'sender', 'viewer');
+UPDATE memberships SET role = 'member' WHERE role IN ('editor', 'sender', 'viewer');
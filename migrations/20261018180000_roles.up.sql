@@ -0,0 +1,8 @@
+-- the member role is split into editor, sender and viewer; existing members keep editing rights
+UPDATE memberships SET role = 'editor' WHERE role = 'member';
+UPDATE invitations SET role = 'editor' WHERE role = 'member';
+
+ALTER TABLE memberships ADD CONSTRAINT memberships_role_check
+    CHECK (role IN ('owner', 'admin', 'editor', 'sender', 'viewer'));
+ALTER TABLE invitations ADD CONSTRAINT invitations_role_check
+    CHECK (role IN ('admin', 'editor', 'sender', 'viewer'));
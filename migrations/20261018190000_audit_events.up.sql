@@ -0,0 +1,29 @@
+-- org_id has no foreign key so that the history survives the deletion of the organization
+CREATE TABLE audit_events (
+    id VARCHAR PRIMARY KEY,
+    org_id VARCHAR NOT NULL DEFAULT '',
+    actor_id VARCHAR NOT NULL DEFAULT '',
+    action VARCHAR NOT NULL,
+    target_type VARCHAR NOT NULL DEFAULT '',
+    target_id VARCHAR NOT NULL DEFAULT '',
+    ip VARCHAR NOT NULL DEFAULT '',
+    user_agent VARCHAR NOT NULL DEFAULT '',
+    request_id VARCHAR NOT NULL DEFAULT '',
+    diff JSONB,
+    data JSONB,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX audit_events_org_idx ON audit_events (org_id, created_at DESC);
+CREATE INDEX audit_events_actor_idx ON audit_events (actor_id, created_at DESC);
+
+-- the audit log is append-only
+CREATE FUNCTION audit_events_append_only() RETURNS TRIGGER AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER audit_events_append_only
+    BEFORE UPDATE OR DELETE ON audit_events
+    FOR EACH ROW EXECUTE PROCEDURE audit_events_append_only();
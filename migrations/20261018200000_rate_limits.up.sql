@@ -0,0 +1,9 @@
+-- token buckets shared by every instance when the postgres rate limiting backend is used
+CREATE TABLE rate_limit_buckets (
+    key VARCHAR PRIMARY KEY,
+    tokens DOUBLE PRECISION NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    full_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX rate_limit_buckets_full_at_idx ON rate_limit_buckets (full_at);
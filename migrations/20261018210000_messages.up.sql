@@ -0,0 +1,25 @@
+CREATE TABLE messages (
+    id VARCHAR PRIMARY KEY,
+    org_id VARCHAR NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    user_id VARCHAR NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    account_id VARCHAR NOT NULL REFERENCES user_provider_accounts(id) ON DELETE CASCADE,
+    message_id VARCHAR NOT NULL UNIQUE,
+    from_email VARCHAR NOT NULL,
+    from_name VARCHAR NOT NULL DEFAULT '',
+    to_emails TEXT[] NOT NULL,
+    subject VARCHAR NOT NULL,
+    text_body TEXT NOT NULL DEFAULT '',
+    html_body TEXT NOT NULL DEFAULT '',
+    status VARCHAR NOT NULL DEFAULT 'queued' CHECK (status IN ('queued', 'sending', 'sent', 'failed')),
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_error TEXT NOT NULL DEFAULT '',
+    provider_message_id VARCHAR NOT NULL DEFAULT '',
+    sent_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX messages_org_idx ON messages (org_id, created_at DESC);
+-- the worker polls the messages that are due
+CREATE INDEX messages_due_idx ON messages (next_attempt_at) WHERE status IN ('queued', 'sending');
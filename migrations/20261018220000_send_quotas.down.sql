@@ -0,0 +1,2 @@
+DROP TABLE provider_sends;
+ALTER TABLE user_provider_accounts DROP COLUMN paused_until;
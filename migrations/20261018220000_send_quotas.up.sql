@@ -0,0 +1,11 @@
+ALTER TABLE user_provider_accounts ADD COLUMN paused_until TIMESTAMP;
+
+-- every send through a provider account, kept for the length of the longest quota window
+CREATE TABLE provider_sends (
+    id VARCHAR PRIMARY KEY,
+    account_id VARCHAR NOT NULL REFERENCES user_provider_accounts(id) ON DELETE CASCADE,
+    sent_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX provider_sends_account_idx ON provider_sends (account_id, sent_at);
+CREATE INDEX provider_sends_sent_at_idx ON provider_sends (sent_at);
@@ -0,0 +1,8 @@
+CREATE TABLE suppressions (
+    org_id VARCHAR NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    email VARCHAR NOT NULL CHECK (email = LOWER(email)),
+    reason VARCHAR NOT NULL CHECK (reason IN ('unsubscribed', 'bounced', 'complained', 'manual')),
+    source VARCHAR NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (org_id, email)
+);
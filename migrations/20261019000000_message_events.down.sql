@@ -0,0 +1,8 @@
+DROP TABLE IF EXISTS message_events;
+
+ALTER TABLE user_provider_accounts DROP COLUMN IF EXISTS polled_at;
+ALTER TABLE user_provider_accounts DROP COLUMN IF EXISTS scopes;
+
+UPDATE messages SET status = 'sent' WHERE status = 'bounced';
+ALTER TABLE messages DROP CONSTRAINT messages_status_check;
+ALTER TABLE messages ADD CONSTRAINT messages_status_check CHECK (status IN ('queued', 'sending', 'sent', 'failed'));
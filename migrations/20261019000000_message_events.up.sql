@@ -0,0 +1,20 @@
+ALTER TABLE messages DROP CONSTRAINT messages_status_check;
+ALTER TABLE messages ADD CONSTRAINT messages_status_check CHECK (status IN ('queued', 'sending', 'sent', 'failed', 'bounced'));
+
+ALTER TABLE user_provider_accounts ADD COLUMN scopes TEXT[] NOT NULL DEFAULT '{}';
+ALTER TABLE user_provider_accounts ADD COLUMN polled_at TIMESTAMP;
+
+CREATE TABLE message_events (
+    id VARCHAR PRIMARY KEY,
+    org_id VARCHAR NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    message_id VARCHAR NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+    type VARCHAR NOT NULL,
+    recipient VARCHAR NOT NULL DEFAULT '',
+    source_id VARCHAR NOT NULL DEFAULT '',
+    data JSONB,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX message_events_message_idx ON message_events (message_id, created_at);
+-- mailboxes are polled with some overlap, so the same mailbox message must not be recorded twice
+CREATE UNIQUE INDEX message_events_source_idx ON message_events (message_id, type, recipient, source_id) WHERE source_id <> '';
@@ -0,0 +1,16 @@
+CREATE TABLE batches (
+    id VARCHAR PRIMARY KEY,
+    org_id VARCHAR NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    user_id VARCHAR NOT NULL,
+    template_id VARCHAR NOT NULL,
+    account_id VARCHAR NOT NULL,
+    total INTEGER NOT NULL,
+    accepted INTEGER NOT NULL,
+    rejected INTEGER NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX batches_org_idx ON batches (org_id, created_at);
+
+ALTER TABLE messages ADD COLUMN batch_id VARCHAR NOT NULL DEFAULT '';
+CREATE INDEX messages_batch_idx ON messages (batch_id) WHERE batch_id <> '';
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS batch_import_errors;
+DROP TABLE IF EXISTS batch_imports;
@@ -0,0 +1,25 @@
+CREATE TABLE batch_imports (
+    id VARCHAR PRIMARY KEY,
+    org_id VARCHAR NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    user_id VARCHAR NOT NULL,
+    batch_id VARCHAR NOT NULL DEFAULT '',
+    template_id VARCHAR NOT NULL,
+    account_id VARCHAR NOT NULL,
+    format VARCHAR NOT NULL CHECK (format IN ('csv', 'jsonl')),
+    dry_run BOOLEAN NOT NULL DEFAULT FALSE,
+    status VARCHAR NOT NULL CHECK (status IN ('processing', 'completed', 'failed')),
+    lines INTEGER NOT NULL DEFAULT 0,
+    accepted INTEGER NOT NULL DEFAULT 0,
+    rejected INTEGER NOT NULL DEFAULT 0,
+    error VARCHAR NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE batch_import_errors (
+    import_id VARCHAR NOT NULL REFERENCES batch_imports(id) ON DELETE CASCADE,
+    line INTEGER NOT NULL,
+    email VARCHAR NOT NULL DEFAULT '',
+    error VARCHAR NOT NULL,
+    PRIMARY KEY (import_id, line)
+);
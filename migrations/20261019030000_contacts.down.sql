@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS contact_list_members;
+DROP TABLE IF EXISTS contact_lists;
+DROP TABLE IF EXISTS contacts;
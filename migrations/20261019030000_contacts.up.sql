@@ -0,0 +1,32 @@
+CREATE TABLE contacts (
+    id VARCHAR PRIMARY KEY,
+    org_id VARCHAR NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    email VARCHAR NOT NULL,
+    name VARCHAR NOT NULL DEFAULT '',
+    attributes JSONB NOT NULL DEFAULT '{}',
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (org_id, email)
+);
+
+CREATE INDEX contacts_attributes_idx ON contacts USING GIN (attributes);
+
+CREATE TABLE contact_lists (
+    id VARCHAR PRIMARY KEY,
+    org_id VARCHAR NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    name VARCHAR NOT NULL,
+    description VARCHAR NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX contact_lists_org_idx ON contact_lists (org_id);
+
+CREATE TABLE contact_list_members (
+    list_id VARCHAR NOT NULL REFERENCES contact_lists(id) ON DELETE CASCADE,
+    contact_id VARCHAR NOT NULL REFERENCES contacts(id) ON DELETE CASCADE,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (list_id, contact_id)
+);
+
+CREATE INDEX contact_list_members_contact_idx ON contact_list_members (contact_id);
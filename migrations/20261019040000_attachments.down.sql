@@ -0,0 +1,2 @@
+ALTER TABLE messages DROP COLUMN IF EXISTS attachment_ids;
+DROP TABLE IF EXISTS attachments;
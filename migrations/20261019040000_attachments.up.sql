@@ -0,0 +1,15 @@
+CREATE TABLE attachments (
+    id VARCHAR PRIMARY KEY,
+    org_id VARCHAR NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    user_id VARCHAR NOT NULL,
+    filename VARCHAR NOT NULL,
+    content_type VARCHAR NOT NULL,
+    size BIGINT NOT NULL,
+    checksum VARCHAR NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX attachments_created_idx ON attachments (created_at);
+
+ALTER TABLE messages ADD COLUMN attachment_ids VARCHAR[];
+CREATE INDEX messages_attachments_idx ON messages USING GIN (attachment_ids);
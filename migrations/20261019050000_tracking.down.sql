@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS webhook_deliveries;
+DROP TABLE IF EXISTS webhooks;
+ALTER TABLE messages DROP COLUMN IF EXISTS tracking;
+ALTER TABLE templates DROP COLUMN IF EXISTS tracking;
@@ -0,0 +1,35 @@
+ALTER TABLE templates ADD COLUMN tracking BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE messages ADD COLUMN tracking BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE TABLE webhooks (
+    id VARCHAR PRIMARY KEY,
+    org_id VARCHAR NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    user_id VARCHAR NOT NULL,
+    url VARCHAR NOT NULL,
+    secret VARCHAR NOT NULL,
+    events VARCHAR[] NOT NULL,
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX webhooks_org_idx ON webhooks (org_id);
+
+CREATE TABLE webhook_deliveries (
+    id VARCHAR PRIMARY KEY,
+    org_id VARCHAR NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    webhook_id VARCHAR NOT NULL REFERENCES webhooks(id) ON DELETE CASCADE,
+    event VARCHAR NOT NULL,
+    payload JSONB,
+    status VARCHAR NOT NULL CHECK (status IN ('pending', 'sending', 'succeeded', 'failed')),
+    attempts INT NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMP NOT NULL,
+    last_error TEXT NOT NULL DEFAULT '',
+    response_status INT NOT NULL DEFAULT 0,
+    delivered_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX webhook_deliveries_due_idx ON webhook_deliveries (next_attempt_at) WHERE status IN ('pending', 'sending');
+CREATE INDEX webhook_deliveries_webhook_idx ON webhook_deliveries (webhook_id, created_at);
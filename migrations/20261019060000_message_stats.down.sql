@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS message_events_uncounted_idx;
+ALTER TABLE message_events DROP COLUMN IF EXISTS counted;
+DROP TABLE IF EXISTS message_stats;
+ALTER TABLE messages DROP COLUMN IF EXISTS template_id;
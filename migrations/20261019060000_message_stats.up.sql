@@ -0,0 +1,27 @@
+ALTER TABLE messages ADD COLUMN template_id VARCHAR NOT NULL DEFAULT '';
+UPDATE messages SET template_id = batches.template_id FROM batches WHERE messages.batch_id = batches.id;
+
+CREATE TABLE message_stats (
+    org_id VARCHAR NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    period TIMESTAMP NOT NULL,
+    template_id VARCHAR NOT NULL,
+    account_id VARCHAR NOT NULL,
+    sent INTEGER NOT NULL DEFAULT 0,
+    failed INTEGER NOT NULL DEFAULT 0,
+    bounced INTEGER NOT NULL DEFAULT 0,
+    opened INTEGER NOT NULL DEFAULT 0,
+    clicked INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (org_id, period, template_id, account_id)
+);
+
+-- the messages delivered so far are rolled up here, their events are rolled up by the worker
+INSERT INTO message_stats (org_id, period, template_id, account_id, sent, failed)
+SELECT org_id, date_trunc('hour', COALESCE(sent_at, updated_at)), template_id, account_id,
+    COUNT(*) FILTER (WHERE status IN ('sent', 'bounced')), COUNT(*) FILTER (WHERE status = 'failed')
+FROM messages
+WHERE status IN ('sent', 'bounced', 'failed')
+GROUP BY 1, 2, 3, 4;
+
+ALTER TABLE message_events ADD COLUMN counted BOOLEAN NOT NULL DEFAULT FALSE;
+-- the worker polls the events that are not rolled up yet
+CREATE INDEX message_events_uncounted_idx ON message_events (created_at) WHERE NOT counted;
@@ -0,0 +1,29 @@
+CREATE TABLE inbound_messages (
+    id VARCHAR PRIMARY KEY,
+    org_id VARCHAR NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    account_id VARCHAR NOT NULL DEFAULT '',
+    source VARCHAR NOT NULL CHECK (source IN ('mailbox', 'api')),
+    source_id VARCHAR NOT NULL DEFAULT '',
+    outbound_id VARCHAR NOT NULL DEFAULT '',
+    message_id VARCHAR NOT NULL DEFAULT '',
+    in_reply_to VARCHAR NOT NULL DEFAULT '',
+    message_references TEXT[] NOT NULL DEFAULT '{}',
+    from_email VARCHAR NOT NULL DEFAULT '',
+    from_name VARCHAR NOT NULL DEFAULT '',
+    reply_to_emails TEXT[] NOT NULL DEFAULT '{}',
+    to_emails TEXT[] NOT NULL DEFAULT '{}',
+    cc_emails TEXT[] NOT NULL DEFAULT '{}',
+    subject VARCHAR NOT NULL DEFAULT '',
+    text_body TEXT NOT NULL DEFAULT '',
+    html_body TEXT NOT NULL DEFAULT '',
+    headers JSONB,
+    attachments JSONB,
+    size BIGINT NOT NULL,
+    sent_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX inbound_messages_org_idx ON inbound_messages (org_id, created_at DESC);
+CREATE INDEX inbound_messages_outbound_idx ON inbound_messages (outbound_id) WHERE outbound_id <> '';
+-- mailboxes are polled with some overlap, so the same mailbox message must not be ingested twice
+CREATE UNIQUE INDEX inbound_messages_source_idx ON inbound_messages (account_id, source_id) WHERE source_id <> '';
@@ -0,0 +1,13 @@
+CREATE TABLE dkim_keys (
+    id VARCHAR PRIMARY KEY,
+    org_id VARCHAR NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    user_id VARCHAR NOT NULL,
+    domain VARCHAR NOT NULL,
+    selector VARCHAR NOT NULL,
+    algorithm VARCHAR NOT NULL CHECK (algorithm IN ('rsa-sha256', 'ed25519-sha256')),
+    private_key TEXT NOT NULL,
+    imported BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE UNIQUE INDEX dkim_keys_domain_idx ON dkim_keys (org_id, domain);
@@ -0,0 +1,2 @@
+ALTER TABLE user_provider_accounts DROP COLUMN IF EXISTS email;
+DROP TABLE IF EXISTS sender_domains;
@@ -0,0 +1,17 @@
+CREATE TABLE sender_domains (
+    id VARCHAR PRIMARY KEY,
+    org_id VARCHAR NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    user_id VARCHAR NOT NULL,
+    domain VARCHAR NOT NULL,
+    token VARCHAR NOT NULL,
+    verified_at TIMESTAMP,
+    spf VARCHAR NOT NULL DEFAULT 'unknown',
+    dkim VARCHAR NOT NULL DEFAULT 'unknown',
+    dmarc VARCHAR NOT NULL DEFAULT 'unknown',
+    checked_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE UNIQUE INDEX sender_domains_domain_idx ON sender_domains (org_id, domain);
+
+ALTER TABLE user_provider_accounts ADD COLUMN email VARCHAR NOT NULL DEFAULT '';
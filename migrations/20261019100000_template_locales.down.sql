@@ -0,0 +1,3 @@
+ALTER TABLE contacts DROP COLUMN IF EXISTS locale;
+
+ALTER TABLE templates DROP COLUMN IF EXISTS locales;
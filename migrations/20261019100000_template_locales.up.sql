@@ -0,0 +1,3 @@
+ALTER TABLE templates ADD COLUMN locales JSONB NOT NULL DEFAULT '{}';
+
+ALTER TABLE contacts ADD COLUMN locale VARCHAR NOT NULL DEFAULT '';
@@ -0,0 +1,35 @@
+// Package blob stores binary objects, such as the files attached to messages, under slash-separated keys.
+package blob
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ErrNotFound is returned when no object is stored under a key.
+var ErrNotFound = errors.New("blob not found")
+
+// Store keeps objects by key.
+type Store interface {
+	// Put stores the content read from r under the key, replacing any object stored under it.
+	// Nothing is stored if r returns an error.
+	Put(ctx context.Context, key string, r io.Reader) error
+	// Get returns the content of the object stored under the key, or ErrNotFound.
+	// The caller must close the returned reader.
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete removes the object stored under the key. Deleting a missing object is not an error.
+	Delete(ctx context.Context, key string) error
+}
+
+// checkKey returns an error if the key has empty, "." or ".." segments, so that it cannot escape the store.
+func checkKey(key string) error {
+	for _, segment := range strings.Split(key, "/") {
+		if segment == "" || segment == "." || segment == ".." || strings.ContainsRune(segment, '\\') {
+			return fmt.Errorf("invalid blob key %q", key)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package blob
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// FileSystem is a Store keeping objects as files below a root directory.
+type FileSystem struct {
+	root string
+}
+
+// NewFileSystem creates a new store keeping objects below the root directory, which is created if needed.
+func NewFileSystem(root string) (*FileSystem, error) {
+	if err := os.MkdirAll(root, 0o750); err != nil {
+		return nil, err
+	}
+	return &FileSystem{root}, nil
+}
+
+// Put writes the content to a temporary file which is renamed once complete,
+// so that readers never see a partial object.
+func (s *FileSystem) Put(_ context.Context, key string, r io.Reader) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return err
+	}
+	file, err := os.CreateTemp(filepath.Dir(path), ".put-*")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(file.Name()) }()
+	if _, err := io.Copy(file, r); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), path)
+}
+
+// Get opens the file of the object.
+func (s *FileSystem) Get(_ context.Context, key string) (io.ReadCloser, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	return file, err
+}
+
+// Delete removes the file of the object.
+func (s *FileSystem) Delete(_ context.Context, key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// path returns the path of the file of the object stored under the key.
+func (s *FileSystem) path(key string) (string, error) {
+	if err := checkKey(key); err != nil {
+		return "", err
+	}
+	return filepath.Join(s.root, filepath.FromSlash(key)), nil
+}
@@ -0,0 +1,127 @@
+// Package clientip determines the IP address of the client of an HTTP request. The X-Forwarded-For and
+// X-Real-IP headers can be set by anyone, so they are only honoured when the request comes from a trusted
+// proxy; otherwise the address of the peer the request was received from is used.
+package clientip
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	routing "github.com/garaekz/ozzo-routing"
+)
+
+type contextKey int
+
+const ipKey contextKey = iota
+
+// Resolver determines the client IP address of requests that may have been forwarded by trusted proxies.
+type Resolver struct {
+	trusted []*net.IPNet
+}
+
+// NewResolver creates a resolver trusting the forwarding headers set by the given proxies, each given
+// as an IP address or a CIDR range such as 10.0.0.0/8. Without proxies, forwarding headers are ignored.
+func NewResolver(proxies []string) (*Resolver, error) {
+	r := &Resolver{}
+	for _, proxy := range proxies {
+		network, err := ParseProxy(proxy)
+		if err != nil {
+			return nil, err
+		}
+		r.trusted = append(r.trusted, network)
+	}
+	return r, nil
+}
+
+// ParseProxy parses a trusted proxy given as an IP address or a CIDR range.
+func ParseProxy(proxy string) (*net.IPNet, error) {
+	proxy = strings.TrimSpace(proxy)
+	if !strings.Contains(proxy, "/") {
+		ip := net.ParseIP(proxy)
+		if ip == nil {
+			return nil, fmt.Errorf("clientip: invalid proxy address %q", proxy)
+		}
+		bits := 8 * net.IPv4len
+		if ip.To4() == nil {
+			bits = 8 * net.IPv6len
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+	_, network, err := net.ParseCIDR(proxy)
+	if err != nil {
+		return nil, fmt.Errorf("clientip: invalid proxy range %q", proxy)
+	}
+	return network, nil
+}
+
+// IP returns the client IP address of the request. When the request comes from a trusted proxy, the
+// X-Forwarded-For header is read from right to left and its first address that is not a trusted proxy
+// is returned, falling back to the X-Real-IP header.
+func (r *Resolver) IP(req *http.Request) string {
+	remote := RemoteIP(req)
+	if !r.isTrusted(remote) {
+		return remote
+	}
+	if forwarded := req.Header.Values("X-Forwarded-For"); len(forwarded) > 0 {
+		hops := strings.Split(strings.Join(forwarded, ","), ",")
+		ip := remote
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := strings.TrimSpace(hops[i])
+			if net.ParseIP(hop) == nil {
+				break
+			}
+			ip = hop
+			if !r.isTrusted(hop) {
+				break
+			}
+		}
+		return ip
+	}
+	if real := strings.TrimSpace(req.Header.Get("X-Real-IP")); net.ParseIP(real) != nil {
+		return real
+	}
+	return remote
+}
+
+// isTrusted returns whether the IP address belongs to a trusted proxy.
+func (r *Resolver) isTrusted(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, network := range r.trusted {
+		if network.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// Handler returns a middleware that stores the client IP address of the request in its context.
+func Handler(r *Resolver) routing.Handler {
+	return func(c *routing.Context) error {
+		c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), ipKey, r.IP(c.Request)))
+		return nil
+	}
+}
+
+// Get returns the client IP address stored in the request context by Handler, or the address of the
+// peer the request was received from if there is none.
+func Get(req *http.Request) string {
+	if ip, ok := req.Context().Value(ipKey).(string); ok {
+		return ip
+	}
+	return RemoteIP(req)
+}
+
+// RemoteIP returns the IP address of the peer the request was received from.
+func RemoteIP(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,70 @@
+package clientip
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	routing "github.com/garaekz/ozzo-routing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolver_IP(t *testing.T) {
+	r, err := NewResolver([]string{"10.0.0.0/8", "192.168.1.1"})
+	require.Nil(t, err)
+	tests := []struct {
+		name      string
+		remote    string
+		forwarded string
+		realIP    string
+		want      string
+	}{
+		{"direct", "203.0.113.7:5000", "", "", "203.0.113.7"},
+		{"spoofed headers from an untrusted peer", "203.0.113.7:5000", "10.1.1.1", "198.51.100.1", "203.0.113.7"},
+		{"trusted proxy", "10.0.0.2:5000", "198.51.100.1", "", "198.51.100.1"},
+		{"chain of trusted proxies", "192.168.1.1:5000", "198.51.100.9, 198.51.100.1, 10.0.0.3", "", "198.51.100.1"},
+		{"only trusted hops", "10.0.0.2:5000", "10.0.0.3", "", "10.0.0.3"},
+		{"invalid hop", "10.0.0.2:5000", "bogus, 10.0.0.3", "", "10.0.0.3"},
+		{"real ip from a trusted proxy", "10.0.0.2:5000", "", "198.51.100.1", "198.51.100.1"},
+		{"trusted proxy without headers", "10.0.0.2:5000", "", "", "10.0.0.2"},
+		{"ipv6 peer", "[2001:db8::1]:5000", "198.51.100.1", "", "2001:db8::1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest("GET", "/", nil)
+			req.RemoteAddr = tt.remote
+			if tt.forwarded != "" {
+				req.Header.Set("X-Forwarded-For", tt.forwarded)
+			}
+			if tt.realIP != "" {
+				req.Header.Set("X-Real-IP", tt.realIP)
+			}
+			assert.Equal(t, tt.want, r.IP(req))
+		})
+	}
+}
+
+func TestNewResolver(t *testing.T) {
+	_, err := NewResolver([]string{"10.0.0.0/33"})
+	assert.NotNil(t, err)
+	_, err = NewResolver([]string{"proxy"})
+	assert.NotNil(t, err)
+	r, err := NewResolver([]string{"::1"})
+	require.Nil(t, err)
+	assert.True(t, r.isTrusted("::1"))
+	assert.False(t, r.isTrusted("127.0.0.1"))
+}
+
+func TestHandler(t *testing.T) {
+	r, _ := NewResolver([]string{"10.0.0.1"})
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "10.0.0.1:5000"
+	req.Header.Set("X-Forwarded-For", "198.51.100.1")
+	// without the middleware, only the peer address is used
+	assert.Equal(t, "10.0.0.1", Get(req))
+
+	c := routing.NewContext(httptest.NewRecorder(), req)
+	assert.Nil(t, Handler(r)(c))
+	assert.Equal(t, "198.51.100.1", Get(c.Request))
+}
@@ -0,0 +1,248 @@
+// Package dkim signs email messages with DomainKeys Identified Mail signatures (RFC 6376), using the relaxed
+// canonicalization of both the header and the body. RSA keys sign with rsa-sha256 and Ed25519 keys with
+// ed25519-sha256 (RFC 8463).
+package dkim
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Signature algorithms.
+const (
+	AlgorithmRSA     = "rsa-sha256"
+	AlgorithmEd25519 = "ed25519-sha256"
+)
+
+// lineLength is the length at which the DKIM-Signature header is folded.
+const lineLength = 76
+
+// DefaultHeaders are the headers signed by default. Headers missing from a message are not signed.
+var DefaultHeaders = []string{
+	"From", "Reply-To", "Subject", "Date", "To", "Cc", "Message-ID", "In-Reply-To", "References",
+	"MIME-Version", "Content-Type", "Content-Transfer-Encoding", "List-Unsubscribe", "List-Unsubscribe-Post",
+}
+
+// ErrNoHeader is returned when the message to sign has no header.
+var ErrNoHeader = errors.New("message has no header")
+
+// Signer signs messages on behalf of a domain.
+type Signer struct {
+	domain    string
+	selector  string
+	key       crypto.Signer
+	algorithm string
+	headers   []string
+	now       func() time.Time
+}
+
+// NewSigner creates a signer signing for the domain with the key published under the selector. The given
+// headers are signed when present, and the From header always is.
+func NewSigner(domain, selector string, key crypto.Signer, headers []string) (*Signer, error) {
+	algorithm, err := KeyAlgorithm(key)
+	if err != nil {
+		return nil, err
+	}
+	if domain == "" || selector == "" {
+		return nil, errors.New("dkim: the domain and the selector are required")
+	}
+	signed := []string{"from"}
+	for _, name := range headers {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name != "" && !contains(signed, name) {
+			signed = append(signed, name)
+		}
+	}
+	return &Signer{strings.ToLower(domain), selector, key, algorithm, signed, time.Now}, nil
+}
+
+// Domain returns the domain the signer signs for.
+func (s *Signer) Domain() string {
+	return s.domain
+}
+
+// Sign returns the message with a DKIM-Signature header prepended. Bare line feeds are turned
+// into CRLF line endings, which the returned message uses throughout.
+func (s *Signer) Sign(raw []byte) ([]byte, error) {
+	raw = normalize(raw)
+	end := bytes.Index(raw, []byte("\r\n\r\n"))
+	if end < 0 {
+		return nil, ErrNoHeader
+	}
+	fields := splitHeader(raw[:end+2])
+	body := sha256.Sum256(canonicalBody(raw[end+4:]))
+
+	h := sha256.New()
+	var names []string
+	for _, name := range s.headers {
+		// repeated headers are signed from the bottom up
+		for i := len(fields) - 1; i >= 0; i-- {
+			if fieldName(fields[i]) == name {
+				names = append(names, name)
+				h.Write([]byte(canonicalHeader(fields[i])))
+			}
+		}
+	}
+	header := fmt.Sprintf("DKIM-Signature: v=1; a=%s; c=relaxed/relaxed; d=%s; s=%s;\r\n t=%d; h=%s;\r\n bh=%s;\r\n b=",
+		s.algorithm, s.domain, s.selector, s.now().Unix(), fold(strings.Join(names, ":"), ":"),
+		base64.StdEncoding.EncodeToString(body[:]))
+	h.Write([]byte(strings.TrimSuffix(canonicalHeader(header), "\r\n")))
+
+	signature, err := s.sign(h.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	buf.WriteString(header)
+	buf.WriteString(fold(base64.StdEncoding.EncodeToString(signature), ""))
+	buf.WriteString("\r\n")
+	buf.Write(raw)
+	return buf.Bytes(), nil
+}
+
+// sign signs the hash of the header, which Ed25519 signs as it is.
+func (s *Signer) sign(digest []byte) ([]byte, error) {
+	if s.algorithm == AlgorithmEd25519 {
+		return s.key.Sign(rand.Reader, digest, crypto.Hash(0))
+	}
+	return s.key.Sign(rand.Reader, digest, crypto.SHA256)
+}
+
+// KeyAlgorithm returns the signature algorithm of a private key.
+func KeyAlgorithm(key crypto.Signer) (string, error) {
+	switch key.(type) {
+	case *rsa.PrivateKey:
+		return AlgorithmRSA, nil
+	case ed25519.PrivateKey:
+		return AlgorithmEd25519, nil
+	}
+	return "", fmt.Errorf("dkim: unsupported key type %T", key)
+}
+
+// normalize turns the bare line feeds of a message into CRLF line endings.
+func normalize(raw []byte) []byte {
+	if !bytes.Contains(raw, []byte("\n")) || bytes.Count(raw, []byte("\n")) == bytes.Count(raw, []byte("\r\n")) {
+		return raw
+	}
+	normalized := bytes.ReplaceAll(raw, []byte("\r\n"), []byte("\n"))
+	return bytes.ReplaceAll(normalized, []byte("\n"), []byte("\r\n"))
+}
+
+// splitHeader splits a header block ending with CRLF into its fields, continuation lines included.
+func splitHeader(header []byte) []string {
+	var fields []string
+	for _, line := range strings.SplitAfter(string(header), "\r\n") {
+		if line == "" {
+			continue
+		}
+		if (line[0] == ' ' || line[0] == '\t') && len(fields) > 0 {
+			fields[len(fields)-1] += line
+		} else {
+			fields = append(fields, line)
+		}
+	}
+	return fields
+}
+
+// fieldName returns the lowercase name of a header field.
+func fieldName(field string) string {
+	name, _, _ := strings.Cut(field, ":")
+	return strings.ToLower(strings.TrimRight(name, " \t"))
+}
+
+// canonicalHeader returns a header field in the relaxed canonical form: a lowercase name followed by the
+// unfolded value, its whitespace runs reduced to a single space and trimmed.
+func canonicalHeader(field string) string {
+	_, value, _ := strings.Cut(field, ":")
+	value = strings.ReplaceAll(value, "\r\n", "")
+	return fieldName(field) + ":" + strings.TrimLeft(compress(value), " ") + "\r\n"
+}
+
+// canonicalBody returns a body in the relaxed canonical form: whitespace runs reduced to a single space,
+// trailing whitespace and trailing empty lines removed.
+func canonicalBody(body []byte) []byte {
+	var buf bytes.Buffer
+	blank := 0
+	lines := strings.Split(string(body), "\r\n")
+	if lines[len(lines)-1] == "" {
+		// the line break ending the body does not start a line
+		lines = lines[:len(lines)-1]
+	}
+	for _, line := range lines {
+		line = compress(line)
+		if line == "" {
+			blank++
+			continue
+		}
+		// runs of empty lines are only written once a line follows them
+		buf.WriteString(strings.Repeat("\r\n", blank))
+		blank = 0
+		buf.WriteString(line + "\r\n")
+	}
+	return buf.Bytes()
+}
+
+// compress reduces the whitespace runs of a line to a single space and removes its trailing whitespace.
+func compress(line string) string {
+	var buf strings.Builder
+	space := false
+	for i := 0; i < len(line); i++ {
+		if line[i] == ' ' || line[i] == '\t' {
+			space = true
+			continue
+		}
+		if space {
+			buf.WriteByte(' ')
+			space = false
+		}
+		buf.WriteByte(line[i])
+	}
+	return buf.String()
+}
+
+// fold breaks a tag value into lines of at most lineLength characters. When sep is given, the value is only
+// broken after it; otherwise it is broken anywhere, which is allowed for base64 values.
+func fold(value, sep string) string {
+	var buf strings.Builder
+	line := 0
+	for len(value) > 0 {
+		n := lineLength - line
+		if n >= len(value) {
+			buf.WriteString(value)
+			break
+		}
+		if sep != "" {
+			if i := strings.LastIndex(value[:n], sep); i >= 0 {
+				n = i + len(sep)
+			} else if i := strings.Index(value, sep); i >= 0 {
+				n = i + len(sep)
+			} else {
+				buf.WriteString(value)
+				break
+			}
+		}
+		buf.WriteString(value[:n] + "\r\n ")
+		value = value[n:]
+		line = 1
+	}
+	return buf.String()
+}
+
+// contains returns whether the list holds the value.
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
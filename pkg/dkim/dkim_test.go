@@ -0,0 +1,172 @@
+package dkim
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"flag"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// update rewrites the golden files with the messages signed by the current code.
+var update = flag.Bool("update", false, "update the golden files")
+
+func loadKey(t *testing.T, file string) crypto.Signer {
+	data, err := os.ReadFile(file)
+	require.Nil(t, err)
+	key, err := ParsePrivateKey(data)
+	require.Nil(t, err)
+	return key
+}
+
+func TestSigner_Sign(t *testing.T) {
+	tests := []struct {
+		name    string
+		message string
+		key     string
+		headers []string
+	}{
+		{"rfc8463 rsa", "rfc8463", "rsa", DefaultHeaders},
+		{"rfc8463 ed25519", "rfc8463", "ed25519", DefaultHeaders},
+		{"multipart rsa", "multipart", "rsa", DefaultHeaders},
+		{"multipart ed25519", "multipart", "ed25519", DefaultHeaders},
+		{"custom headers", "multipart", "rsa", []string{"Subject", "X-Mailer", "received", "X-Missing"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key := loadKey(t, "testdata/"+tt.key+".pem")
+			raw, err := os.ReadFile("testdata/" + tt.message + ".eml")
+			require.Nil(t, err)
+			s, err := NewSigner("Example.com", "s2026", key, tt.headers)
+			require.Nil(t, err)
+			s.now = func() time.Time { return time.Unix(1760814300, 0) }
+
+			signed, err := s.Sign(raw)
+			require.Nil(t, err)
+			golden := "testdata/" + strings.ReplaceAll(tt.name, " ", "_") + ".golden"
+			if *update {
+				require.Nil(t, os.WriteFile(golden, signed, 0o644))
+			}
+			want, err := os.ReadFile(golden)
+			require.Nil(t, err)
+			assert.Equal(t, string(want), string(signed))
+
+			record, err := TXTRecord(key)
+			require.Nil(t, err)
+			records := map[string]string{RecordName("example.com", "s2026"): record}
+			assert.Nil(t, verify(string(signed), records))
+			// the signature breaks once the signed content changes
+			tampered := strings.Replace(string(signed), "From: ", "From: Mallory ", 1)
+			assert.NotNil(t, verify(tampered, records))
+			for _, line := range strings.Split(string(signed), "\r\n") {
+				assert.LessOrEqual(t, len(line), 998)
+			}
+		})
+	}
+}
+
+func TestSigner_Sign_headers(t *testing.T) {
+	s, err := NewSigner("example.com", "s1", loadKey(t, "testdata/ed25519.pem"), []string{"Subject", " received ", "subject"})
+	require.Nil(t, err)
+	raw, err := os.ReadFile("testdata/multipart.eml")
+	require.Nil(t, err)
+	signed, err := s.Sign(raw)
+	require.Nil(t, err)
+	// From is always signed, and both Subject and Received headers are signed from the bottom up
+	assert.Contains(t, strings.ReplaceAll(string(signed), "\r\n ", ""), "h=from:subject:subject:received;")
+
+	_, err = s.Sign([]byte("From: a@example.com"))
+	assert.Equal(t, ErrNoHeader, err)
+	signed, err = s.Sign([]byte("From: a@example.com\r\n\r\n"))
+	require.Nil(t, err)
+	record, _ := TXTRecord(s.key)
+	assert.Nil(t, verify(string(signed), map[string]string{"s1._domainkey.example.com": record}))
+}
+
+func TestNewSigner(t *testing.T) {
+	key := loadKey(t, "testdata/rsa.pem")
+	_, err := NewSigner("", "s1", key, nil)
+	assert.NotNil(t, err)
+	_, err = NewSigner("example.com", "", key, nil)
+	assert.NotNil(t, err)
+	s, err := NewSigner("Example.COM", "s1", key, nil)
+	require.Nil(t, err)
+	assert.Equal(t, "example.com", s.Domain())
+	assert.Equal(t, AlgorithmRSA, s.algorithm)
+}
+
+func TestGenerateKey(t *testing.T) {
+	for _, algorithm := range []string{AlgorithmRSA, AlgorithmEd25519} {
+		key, err := GenerateKey(algorithm)
+		require.Nil(t, err)
+		got, err := KeyAlgorithm(key)
+		require.Nil(t, err)
+		assert.Equal(t, algorithm, got)
+
+		data, err := MarshalPrivateKey(key)
+		require.Nil(t, err)
+		parsed, err := ParsePrivateKey(data)
+		require.Nil(t, err)
+		assert.True(t, key.Public().(interface{ Equal(crypto.PublicKey) bool }).Equal(parsed.Public()))
+	}
+	_, err := GenerateKey("dsa-sha1")
+	assert.NotNil(t, err)
+}
+
+func TestParsePrivateKey(t *testing.T) {
+	key := loadKey(t, "testdata/rsa.pem")
+	assert.IsType(t, &rsa.PrivateKey{}, key)
+	assert.IsType(t, ed25519.PrivateKey{}, loadKey(t, "testdata/ed25519.pem"))
+
+	small, err := rsa.GenerateKey(rand.Reader, 512)
+	require.Nil(t, err)
+	data, err := MarshalPrivateKey(small)
+	require.Nil(t, err)
+	_, err = ParsePrivateKey(data)
+	assert.Equal(t, ErrInvalidKey, err)
+	_, err = ParsePrivateKey([]byte("not a key"))
+	assert.Equal(t, ErrInvalidKey, err)
+	_, err = ParsePrivateKey([]byte("-----BEGIN CERTIFICATE-----\nAAAA\n-----END CERTIFICATE-----\n"))
+	assert.Equal(t, ErrInvalidKey, err)
+}
+
+func TestTXTRecord(t *testing.T) {
+	record, err := TXTRecord(loadKey(t, "testdata/ed25519.pem"))
+	require.Nil(t, err)
+	// the public key of RFC 8463, appendix A
+	assert.Equal(t, "v=DKIM1; k=ed25519; p=11qYAYKxCrfVS/7TyWQHOg7hcvPapiMlrwIaaPcHURo=", record)
+	record, err = TXTRecord(loadKey(t, "testdata/rsa.pem"))
+	require.Nil(t, err)
+	assert.True(t, strings.HasPrefix(record, "v=DKIM1; k=rsa; p=MIIBIjAN"))
+	assert.Equal(t, "s1._domainkey.example.com", RecordName("example.com", "s1"))
+}
+
+func Test_canonicalBody(t *testing.T) {
+	tests := []struct {
+		body string
+		want string
+	}{
+		{"", ""},
+		{"\r\n\r\n", ""},
+		{"Hi", "Hi\r\n"},
+		{"  a \t b  \r\n\r\n c\r\n\r\n\r\n", " a b\r\n\r\n c\r\n"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, string(canonicalBody([]byte(tt.body))))
+	}
+
+	// the body hash of the example of RFC 8463, appendix A
+	raw, err := os.ReadFile("testdata/rfc8463.eml")
+	require.Nil(t, err)
+	sum := sha256.Sum256(canonicalBody(raw[strings.Index(string(raw), "\r\n\r\n")+4:]))
+	assert.Equal(t, "2jUSOH9NhtVGCQWNr9BrIAPreKQjO6Sn7XIkfJVOzv8=", base64.StdEncoding.EncodeToString(sum[:]))
+}
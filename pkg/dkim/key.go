@@ -0,0 +1,96 @@
+package dkim
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+)
+
+const (
+	// rsaBits is the size of the RSA keys generated.
+	rsaBits = 2048
+	// minRSABits is the size under which RSA keys are rejected, as required by RFC 8301.
+	minRSABits = 1024
+)
+
+// ErrInvalidKey is returned when a private key cannot be parsed or is not fit for signing.
+var ErrInvalidKey = errors.New("dkim: invalid private key")
+
+// GenerateKey generates a private key for the given signature algorithm.
+func GenerateKey(algorithm string) (crypto.Signer, error) {
+	switch algorithm {
+	case AlgorithmRSA:
+		return rsa.GenerateKey(rand.Reader, rsaBits)
+	case AlgorithmEd25519:
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		return key, err
+	}
+	return nil, fmt.Errorf("dkim: unsupported algorithm %q", algorithm)
+}
+
+// ParsePrivateKey parses a PEM encoded RSA or Ed25519 private key, in either the PKCS #1 or the PKCS #8 form.
+func ParsePrivateKey(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, ErrInvalidKey
+	}
+	var key interface{}
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, ErrInvalidKey
+	}
+	if err != nil {
+		return nil, ErrInvalidKey
+	}
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		if key.N.BitLen() < minRSABits {
+			return nil, ErrInvalidKey
+		}
+		return key, nil
+	case ed25519.PrivateKey:
+		return key, nil
+	}
+	return nil, ErrInvalidKey
+}
+
+// MarshalPrivateKey returns the PEM encoded PKCS #8 form of a private key.
+func MarshalPrivateKey(key crypto.Signer) ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+// TXTRecord returns the value of the DNS TXT record publishing the public key of a private key.
+// RSA keys are published in the SubjectPublicKeyInfo form, and Ed25519 keys as they are (RFC 8463).
+func TXTRecord(key crypto.Signer) (string, error) {
+	switch public := key.Public().(type) {
+	case *rsa.PublicKey:
+		der, err := x509.MarshalPKIXPublicKey(public)
+		if err != nil {
+			return "", err
+		}
+		return "v=DKIM1; k=rsa; p=" + base64.StdEncoding.EncodeToString(der), nil
+	case ed25519.PublicKey:
+		return "v=DKIM1; k=ed25519; p=" + base64.StdEncoding.EncodeToString(public), nil
+	}
+	return "", fmt.Errorf("dkim: unsupported key type %T", key)
+}
+
+// RecordName returns the name of the DNS TXT record publishing the key of the selector for the domain.
+func RecordName(domain, selector string) string {
+	return selector + "._domainkey." + domain
+}
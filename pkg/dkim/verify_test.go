@@ -0,0 +1,142 @@
+package dkim
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// verify checks the first DKIM-Signature of a message the way a receiving server would, looking up the
+// public keys in records, which maps record names to TXT values. It is written from RFC 6376 and RFC 8463
+// independently of the signer, so that both would have to get the canonicalization wrong in the same way
+// for a test to pass.
+func verify(message string, records map[string]string) error {
+	end := strings.Index(message, "\r\n\r\n")
+	if end < 0 {
+		return errors.New("no header")
+	}
+	fields := headerFieldPattern.FindAllString(message[:end+2], -1)
+	var signature string
+	for i, field := range fields {
+		if strings.HasPrefix(strings.ToLower(field), "dkim-signature:") {
+			signature = field
+			fields = append(fields[:i:i], fields[i+1:]...)
+			break
+		}
+	}
+	if signature == "" {
+		return errors.New("no signature")
+	}
+	tags := map[string]string{}
+	for _, part := range strings.Split(signature[strings.Index(signature, ":")+1:], ";") {
+		name, value, ok := strings.Cut(part, "=")
+		if ok {
+			tags[strings.TrimSpace(name)] = regexp.MustCompile(`[ \t\r\n]+`).ReplaceAllString(value, "")
+		}
+	}
+	if tags["v"] != "1" || tags["c"] != "relaxed/relaxed" {
+		return fmt.Errorf("unexpected tags v=%s c=%s", tags["v"], tags["c"])
+	}
+
+	// body hash
+	body := message[end+4:]
+	body = regexp.MustCompile(`[ \t]+\r\n`).ReplaceAllString(body, "\r\n")
+	body = regexp.MustCompile(`[ \t]+`).ReplaceAllString(body, " ")
+	body = regexp.MustCompile(`[ \t]+$`).ReplaceAllString(body, "")
+	body = regexp.MustCompile(`(\r\n)*$`).ReplaceAllString(body, "")
+	if body != "" {
+		body += "\r\n"
+	}
+	bh := sha256.Sum256([]byte(body))
+	if base64.StdEncoding.EncodeToString(bh[:]) != tags["bh"] {
+		return errors.New("body hash mismatch")
+	}
+
+	// header hash, each listed header matching the last unused instance of it
+	h := sha256.New()
+	used := map[int]bool{}
+	for _, name := range strings.Split(tags["h"], ":") {
+		for i := len(fields) - 1; i >= 0; i-- {
+			if !used[i] && strings.EqualFold(strings.TrimRight(fields[i][:strings.Index(fields[i], ":")], " \t"), name) {
+				used[i] = true
+				h.Write([]byte(relaxHeader(fields[i]) + "\r\n"))
+				break
+			}
+		}
+	}
+	unsigned := regexp.MustCompile(`([;:][ \t\r\n]*b[ \t\r\n]*=)[^;]*`).ReplaceAllString(signature, "$1")
+	h.Write([]byte(relaxHeader(unsigned)))
+	digest := h.Sum(nil)
+
+	record, ok := records[tags["s"]+"._domainkey."+tags["d"]]
+	if !ok {
+		return errors.New("no key record")
+	}
+	var key string
+	for _, part := range strings.Split(record, ";") {
+		if name, value, _ := strings.Cut(strings.TrimSpace(part), "="); name == "p" {
+			key = value
+		}
+	}
+	der, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return err
+	}
+	sig, err := base64.StdEncoding.DecodeString(tags["b"])
+	if err != nil {
+		return err
+	}
+	switch tags["a"] {
+	case "rsa-sha256":
+		public, err := x509.ParsePKIXPublicKey(der)
+		if err != nil {
+			return err
+		}
+		return rsa.VerifyPKCS1v15(public.(*rsa.PublicKey), crypto.SHA256, digest, sig)
+	case "ed25519-sha256":
+		if !ed25519.Verify(ed25519.PublicKey(der), digest, sig) {
+			return errors.New("ed25519 signature mismatch")
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported algorithm %s", tags["a"])
+}
+
+// headerFieldPattern matches a header field along with its continuation lines.
+var headerFieldPattern = regexp.MustCompile(`[^ \t\r\n][^\r\n]*\r\n(?:[ \t][^\r\n]*\r\n)*`)
+
+// relaxHeader returns a header field in the relaxed canonical form, without the final line break.
+func relaxHeader(field string) string {
+	i := strings.Index(field, ":")
+	name := strings.ToLower(strings.TrimRight(field[:i], " \t"))
+	value := regexp.MustCompile(`\r\n`).ReplaceAllString(field[i+1:], "")
+	value = regexp.MustCompile(`[ \t]+`).ReplaceAllString(value, " ")
+	return name + ":" + strings.TrimSpace(value)
+}
+
+func Test_verify(t *testing.T) {
+	// the ed25519-sha256 example of RFC 8463, appendix A
+	signed, err := os.ReadFile("testdata/rfc8463.signed.eml")
+	require.Nil(t, err)
+	records := map[string]string{"brisbane._domainkey.football.example.com": "v=DKIM1; k=ed25519; p=11qYAYKxCrfVS/7TyWQHOg7hcvPapiMlrwIaaPcHURo="}
+	assert.Nil(t, verify(string(signed), records))
+
+	tampered := strings.Replace(string(signed), "We lost", "We won", 1)
+	assert.EqualError(t, verify(tampered, records), "body hash mismatch")
+	tampered = strings.Replace(string(signed), "Is dinner ready?", "Is lunch ready?", 1)
+	assert.EqualError(t, verify(tampered, records), "ed25519 signature mismatch")
+	// whitespace changes within lines survive the relaxed canonicalization
+	assert.Nil(t, verify(strings.Replace(string(signed), "Subject: Is dinner", "Subject:  Is \t dinner", 1), records))
+}
@@ -0,0 +1,60 @@
+// Package locale handles the BCP 47 language tags naming locales, such as es or es-MX, and the fallback
+// from a locale to the less specific ones it belongs to.
+package locale
+
+import (
+	"regexp"
+	"strings"
+)
+
+// tagPattern matches a language subtag followed by optional script, region and variant subtags.
+var tagPattern = regexp.MustCompile(`^[a-z]{2,3}(-[A-Za-z0-9]{2,8})*$`)
+
+// Normalize returns the canonical form of a tag: underscores become hyphens, the language is lowercased,
+// two-letter regions are uppercased and four-letter scripts titlecased, as in es-MX and zh-Hant-TW.
+func Normalize(tag string) string {
+	subtags := strings.Split(strings.ReplaceAll(strings.TrimSpace(tag), "_", "-"), "-")
+	for i, subtag := range subtags {
+		switch {
+		case i == 0:
+			subtags[i] = strings.ToLower(subtag)
+		case len(subtag) == 2:
+			subtags[i] = strings.ToUpper(subtag)
+		case len(subtag) == 4:
+			subtags[i] = strings.ToUpper(subtag[:1]) + strings.ToLower(subtag[1:])
+		default:
+			subtags[i] = strings.ToLower(subtag)
+		}
+	}
+	return strings.Join(subtags, "-")
+}
+
+// Valid returns whether the tag, once normalized, is well-formed.
+func Valid(tag string) bool {
+	return tagPattern.MatchString(Normalize(tag))
+}
+
+// Fallbacks returns the locales to try for the tag, from the most to the least specific, by removing its
+// subtags one at a time: es-MX falls back to es. It returns nil for an empty tag.
+func Fallbacks(tag string) []string {
+	tag = Normalize(tag)
+	if tag == "" {
+		return nil
+	}
+	fallbacks := []string{tag}
+	for i := strings.LastIndex(tag, "-"); i > 0; i = strings.LastIndex(tag, "-") {
+		tag = tag[:i]
+		fallbacks = append(fallbacks, tag)
+	}
+	return fallbacks
+}
+
+// Resolve returns the first of the fallbacks of the tag that is available, or an empty string if none is.
+func Resolve(tag string, available func(locale string) bool) string {
+	for _, locale := range Fallbacks(tag) {
+		if available(locale) {
+			return locale
+		}
+	}
+	return ""
+}
@@ -0,0 +1,51 @@
+package locale
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		tag, want string
+	}{
+		{"es", "es"},
+		{"ES", "es"},
+		{"es-mx", "es-MX"},
+		{" pt_br ", "pt-BR"},
+		{"zh-hant-tw", "zh-Hant-TW"},
+		{"es-419", "es-419"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, Normalize(tt.tag), tt.tag)
+	}
+}
+
+func TestValid(t *testing.T) {
+	for _, tag := range []string{"en", "es-MX", "pt_br", "zh-Hant-TW", "es-419", "de-CH-1996"} {
+		assert.True(t, Valid(tag), tag)
+	}
+	for _, tag := range []string{"", "e", "english", "es-", "es--MX", "es-M", "es MX", "1a"} {
+		assert.False(t, Valid(tag), tag)
+	}
+}
+
+func TestFallbacks(t *testing.T) {
+	assert.Equal(t, []string{"es-MX", "es"}, Fallbacks("es_mx"))
+	assert.Equal(t, []string{"zh-Hant-TW", "zh-Hant", "zh"}, Fallbacks("zh-Hant-TW"))
+	assert.Equal(t, []string{"pt"}, Fallbacks("pt"))
+	assert.Nil(t, Fallbacks(""))
+}
+
+func TestResolve(t *testing.T) {
+	variants := map[string]bool{"es": true, "pt-BR": true}
+	available := func(locale string) bool { return variants[locale] }
+	assert.Equal(t, "es", Resolve("es-MX", available))
+	assert.Equal(t, "pt-BR", Resolve("pt-br", available))
+	// less specific locales do not fall back to more specific ones
+	assert.Equal(t, "", Resolve("pt", available))
+	assert.Equal(t, "", Resolve("en-US", available))
+	assert.Equal(t, "", Resolve("", available))
+}
@@ -93,6 +93,13 @@ func WithRequest(ctx context.Context, req *http.Request) context.Context {
 	return ctx
 }
 
+// RequestID returns the request ID recorded in the context by WithRequest.
+// An empty string is returned if the context does not belong to a request.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
 // getCorrelationID extracts the correlation ID from the HTTP request
 func getCorrelationID(req *http.Request) string {
 	return req.Header.Get("X-Correlation-ID")
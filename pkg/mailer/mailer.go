@@ -0,0 +1,119 @@
+// Package mailer provides the outgoing mail transports used by the application to send system emails.
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strconv"
+	"sync"
+
+	"github.com/garaekz/gonvelope/pkg/dkim"
+	"github.com/garaekz/gonvelope/pkg/log"
+)
+
+// Message represents an email message to be sent.
+type Message struct {
+	From     string
+	FromName string
+	To       []string
+	Subject  string
+	Text     string
+	HTML     string
+	Headers  map[string]string
+	// Unsubscribe is the URL the recipient can POST to in order to unsubscribe. When set, the
+	// List-Unsubscribe and List-Unsubscribe-Post headers of RFC 8058 are added to the message.
+	Unsubscribe string
+	// Attachments are the files attached to the message.
+	Attachments []Attachment
+	// DKIM signs the message once it is built, when set.
+	DKIM *dkim.Signer
+}
+
+// Attachment represents a file attached to an email message.
+type Attachment struct {
+	Filename    string
+	ContentType string
+	Data        []byte
+}
+
+// Mailer sends email messages.
+type Mailer interface {
+	// Send delivers the given message.
+	Send(ctx context.Context, msg Message) error
+}
+
+// Sandbox is a Mailer that does not deliver messages. Instead, it logs them and keeps
+// them in memory so that they can be inspected. It is meant for local development and tests.
+type Sandbox struct {
+	from     string
+	fromName string
+	logger   log.Logger
+	mu       sync.Mutex
+	messages []Message
+}
+
+// NewSandbox creates a new sandbox mailer.
+func NewSandbox(from, fromName string, logger log.Logger) *Sandbox {
+	return &Sandbox{from: from, fromName: fromName, logger: logger}
+}
+
+// Send records the message and logs it instead of delivering it.
+func (s *Sandbox) Send(ctx context.Context, msg Message) error {
+	if msg.From == "" {
+		msg.From, msg.FromName = s.from, s.fromName
+	}
+	s.mu.Lock()
+	s.messages = append(s.messages, msg)
+	s.mu.Unlock()
+	s.logger.With(ctx, "to", msg.To, "subject", msg.Subject).Infof("sandbox mail:\n%s", msg.Text)
+	return nil
+}
+
+// Messages returns the messages sent through the sandbox so far.
+func (s *Sandbox) Messages() []Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Message(nil), s.messages...)
+}
+
+// SMTP is a Mailer that delivers messages through an SMTP relay.
+type SMTP struct {
+	host     string
+	port     int
+	username string
+	password string
+	from     string
+	fromName string
+	signer   *dkim.Signer
+}
+
+// NewSMTP creates a new SMTP mailer. Messages are DKIM-signed with signer unless it is nil
+// or they come with a signer of their own.
+func NewSMTP(host string, port int, username, password, from, fromName string, signer *dkim.Signer) *SMTP {
+	return &SMTP{host, port, username, password, from, fromName, signer}
+}
+
+// Send builds the MIME representation of the message and delivers it through the SMTP relay.
+func (s *SMTP) Send(_ context.Context, msg Message) error {
+	if msg.From == "" {
+		msg.From, msg.FromName = s.from, s.fromName
+	}
+	if msg.DKIM == nil {
+		msg.DKIM = s.signer
+	}
+	body, err := Build(msg)
+	if err != nil {
+		return err
+	}
+	var auth smtp.Auth
+	if s.username != "" {
+		auth = smtp.PlainAuth("", s.username, s.password, s.host)
+	}
+	addr := net.JoinHostPort(s.host, strconv.Itoa(s.port))
+	if err := smtp.SendMail(addr, auth, msg.From, msg.To, body); err != nil {
+		return fmt.Errorf("smtp send: %w", err)
+	}
+	return nil
+}
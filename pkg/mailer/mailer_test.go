@@ -0,0 +1,133 @@
+package mailer
+
+import (
+	"context"
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"strings"
+	"testing"
+
+	"github.com/garaekz/gonvelope/pkg/dkim"
+	"github.com/garaekz/gonvelope/pkg/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSandbox_Send(t *testing.T) {
+	logger, entries := log.NewForTest()
+	s := NewSandbox("noreply@example.com", "Gonvelope", logger)
+	err := s.Send(context.Background(), Message{To: []string{"user@example.com"}, Subject: "hello", Text: "body"})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, entries.Len())
+	if assert.Len(t, s.Messages(), 1) {
+		assert.Equal(t, "noreply@example.com", s.Messages()[0].From)
+		assert.Equal(t, "Gonvelope", s.Messages()[0].FromName)
+	}
+}
+
+func TestBuild(t *testing.T) {
+	_, err := Build(Message{From: "a@example.com"})
+	assert.Equal(t, ErrNoRecipients, err)
+
+	raw, err := Build(Message{
+		From:    "a@example.com",
+		To:      []string{"b@example.com"},
+		Subject: "Héllo",
+		Text:    "plain",
+		HTML:    "<p>html</p>",
+		Headers: map[string]string{"X-Test": "1"},
+	})
+	require.Nil(t, err)
+	m, err := mail.ReadMessage(strings.NewReader(string(raw)))
+	require.Nil(t, err)
+	assert.Equal(t, "<a@example.com>", m.Header.Get("From"))
+	assert.Equal(t, "1", m.Header.Get("X-Test"))
+	assert.NotEmpty(t, m.Header.Get("Message-ID"))
+	assert.Contains(t, m.Header.Get("Content-Type"), "multipart/alternative")
+	subject, _ := new(mime.WordDecoder).DecodeHeader(m.Header.Get("Subject"))
+	assert.Equal(t, "Héllo", subject)
+}
+
+func TestBuild_unsubscribe(t *testing.T) {
+	raw, err := Build(Message{From: "a@example.com", To: []string{"b@example.com"}, Subject: "Hi", Text: "plain"})
+	require.Nil(t, err)
+	m, err := mail.ReadMessage(strings.NewReader(string(raw)))
+	require.Nil(t, err)
+	assert.Empty(t, m.Header.Get("List-Unsubscribe"))
+
+	raw, err = Build(Message{
+		From:        "a@example.com",
+		To:          []string{"b@example.com"},
+		Subject:     "Hi",
+		Text:        "plain",
+		Unsubscribe: "https://example.com/unsubscribe/token",
+	})
+	require.Nil(t, err)
+	m, err = mail.ReadMessage(strings.NewReader(string(raw)))
+	require.Nil(t, err)
+	assert.Equal(t, "<https://example.com/unsubscribe/token>", m.Header.Get("List-Unsubscribe"))
+	assert.Equal(t, "List-Unsubscribe=One-Click", m.Header.Get("List-Unsubscribe-Post"))
+}
+
+func TestBuild_attachments(t *testing.T) {
+	data := []byte(strings.Repeat("0123456789", 20))
+	raw, err := Build(Message{
+		From:        "from@example.com",
+		To:          []string{"to@example.com"},
+		Subject:     "Invoice",
+		Text:        "See attached",
+		HTML:        "<p>See attached</p>",
+		Attachments: []Attachment{{Filename: "factura año.pdf", ContentType: "application/pdf", Data: data}},
+	})
+	require.Nil(t, err)
+
+	msg, err := mail.ReadMessage(strings.NewReader(string(raw)))
+	require.Nil(t, err)
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	require.Nil(t, err)
+	assert.Equal(t, "multipart/mixed", mediaType)
+
+	reader := multipart.NewReader(msg.Body, params["boundary"])
+	body, err := reader.NextPart()
+	require.Nil(t, err)
+	assert.True(t, strings.HasPrefix(body.Header.Get("Content-Type"), "multipart/alternative"))
+	part, err := reader.NextPart()
+	require.Nil(t, err)
+	assert.Equal(t, "factura año.pdf", part.FileName())
+	assert.True(t, strings.HasPrefix(part.Header.Get("Content-Type"), "application/pdf"))
+	content, err := io.ReadAll(base64.NewDecoder(base64.StdEncoding, part))
+	require.Nil(t, err)
+	assert.Equal(t, data, content)
+	_, err = reader.NextPart()
+	assert.Equal(t, io.EOF, err)
+
+	for _, line := range strings.Split(string(raw), "\r\n") {
+		assert.LessOrEqual(t, len(line), 998)
+	}
+}
+
+func TestBuild_dkim(t *testing.T) {
+	key, err := dkim.GenerateKey(dkim.AlgorithmEd25519)
+	require.Nil(t, err)
+	signer, err := dkim.NewSigner("example.com", "s1", key, dkim.DefaultHeaders)
+	require.Nil(t, err)
+	raw, err := Build(Message{
+		From:        "from@example.com",
+		To:          []string{"to@example.com"},
+		Subject:     "Invoice",
+		Text:        "See attached",
+		Attachments: []Attachment{{Filename: "invoice.pdf", ContentType: "application/pdf", Data: []byte("%PDF-1.7")}},
+		DKIM:        signer,
+	})
+	require.Nil(t, err)
+
+	msg, err := mail.ReadMessage(strings.NewReader(string(raw)))
+	require.Nil(t, err)
+	signature := msg.Header.Get("DKIM-Signature")
+	assert.Contains(t, signature, "a=ed25519-sha256; c=relaxed/relaxed; d=example.com; s=s1;")
+	assert.Contains(t, strings.ReplaceAll(signature, " ", ""), "h=from:subject:date:to:message-id:mime-version:content-type;")
+	assert.Equal(t, "<from@example.com>", msg.Header.Get("From"))
+}
@@ -0,0 +1,158 @@
+package mailer
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net/mail"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ErrNoRecipients is returned when a message has no recipients.
+var ErrNoRecipients = errors.New("message has no recipients")
+
+// Build returns the RFC 5322 representation of the message. When both a text and an HTML
+// body are present, a multipart/alternative message is generated. Messages with attachments
+// are wrapped in a multipart/mixed message. The message is DKIM-signed when it has a signer.
+func Build(msg Message) ([]byte, error) {
+	if len(msg.To) == 0 {
+		return nil, ErrNoRecipients
+	}
+
+	var buf bytes.Buffer
+	from := mail.Address{Name: msg.FromName, Address: msg.From}
+	writeHeader(&buf, "From", from.String())
+	to := make([]string, len(msg.To))
+	for i, addr := range msg.To {
+		to[i] = (&mail.Address{Address: addr}).String()
+	}
+	writeHeader(&buf, "To", strings.Join(to, ", "))
+	writeHeader(&buf, "Subject", mime.QEncoding.Encode("utf-8", msg.Subject))
+	writeHeader(&buf, "Date", time.Now().Format(time.RFC1123Z))
+	if _, ok := msg.Headers["Message-ID"]; !ok {
+		writeHeader(&buf, "Message-ID", NewMessageID(msg.From))
+	}
+	writeHeader(&buf, "MIME-Version", "1.0")
+	if msg.Unsubscribe != "" {
+		writeHeader(&buf, "List-Unsubscribe", "<"+msg.Unsubscribe+">")
+		writeHeader(&buf, "List-Unsubscribe-Post", "List-Unsubscribe=One-Click")
+	}
+
+	keys := make([]string, 0, len(msg.Headers))
+	for k := range msg.Headers {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		writeHeader(&buf, k, msg.Headers[k])
+	}
+
+	if len(msg.Attachments) == 0 {
+		writeContent(&buf, msg)
+	} else {
+		writeMixed(&buf, msg)
+	}
+	if msg.DKIM != nil {
+		return msg.DKIM.Sign(buf.Bytes())
+	}
+	return buf.Bytes(), nil
+}
+
+// writeMixed writes the bodies of the message followed by its attachments as a multipart/mixed body.
+func writeMixed(buf *bytes.Buffer, msg Message) {
+	boundary := randomHex(16)
+	writeHeader(buf, "Content-Type", fmt.Sprintf("multipart/mixed; boundary=%q", boundary))
+	buf.WriteString("\r\n")
+	fmt.Fprintf(buf, "--%s\r\n", boundary)
+	writeContent(buf, msg)
+	buf.WriteString("\r\n")
+	for _, attachment := range msg.Attachments {
+		fmt.Fprintf(buf, "--%s\r\n", boundary)
+		writeAttachment(buf, attachment)
+		buf.WriteString("\r\n")
+	}
+	fmt.Fprintf(buf, "--%s--\r\n", boundary)
+}
+
+// writeContent writes the text and HTML bodies of the message. When both are present,
+// a multipart/alternative body is written.
+func writeContent(buf *bytes.Buffer, msg Message) {
+	switch {
+	case msg.Text != "" && msg.HTML != "":
+		boundary := randomHex(16)
+		writeHeader(buf, "Content-Type", fmt.Sprintf("multipart/alternative; boundary=%q", boundary))
+		buf.WriteString("\r\n")
+		writePart(buf, boundary, "text/plain", msg.Text)
+		writePart(buf, boundary, "text/html", msg.HTML)
+		fmt.Fprintf(buf, "--%s--\r\n", boundary)
+	case msg.HTML != "":
+		writeBody(buf, "text/html", msg.HTML)
+	default:
+		writeBody(buf, "text/plain", msg.Text)
+	}
+}
+
+// writeAttachment writes the headers of the attachment followed by its base64 encoded content,
+// wrapped at 76 characters as required by RFC 2045.
+func writeAttachment(buf *bytes.Buffer, attachment Attachment) {
+	contentType := mime.FormatMediaType(attachment.ContentType, map[string]string{"name": attachment.Filename})
+	if contentType == "" {
+		contentType = mime.FormatMediaType("application/octet-stream", map[string]string{"name": attachment.Filename})
+	}
+	writeHeader(buf, "Content-Type", contentType)
+	writeHeader(buf, "Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": attachment.Filename}))
+	writeHeader(buf, "Content-Transfer-Encoding", "base64")
+	buf.WriteString("\r\n")
+	encoded := base64.StdEncoding.EncodeToString(attachment.Data)
+	for len(encoded) > 76 {
+		buf.WriteString(encoded[:76] + "\r\n")
+		encoded = encoded[76:]
+	}
+	buf.WriteString(encoded + "\r\n")
+}
+
+// writeHeader writes a single header line.
+func writeHeader(buf *bytes.Buffer, key, value string) {
+	fmt.Fprintf(buf, "%s: %s\r\n", key, value)
+}
+
+// writePart writes a single part of a multipart body.
+func writePart(buf *bytes.Buffer, boundary, contentType, body string) {
+	fmt.Fprintf(buf, "--%s\r\n", boundary)
+	writeBody(buf, contentType, body)
+	buf.WriteString("\r\n")
+}
+
+// writeBody writes the content headers followed by the quoted-printable encoded body.
+func writeBody(buf *bytes.Buffer, contentType, body string) {
+	writeHeader(buf, "Content-Type", contentType+"; charset=utf-8")
+	writeHeader(buf, "Content-Transfer-Encoding", "quoted-printable")
+	buf.WriteString("\r\n")
+	w := quotedprintable.NewWriter(buf)
+	_, _ = w.Write([]byte(body))
+	_ = w.Close()
+	buf.WriteString("\r\n")
+}
+
+// NewMessageID generates a unique Message-ID using the domain of the sender address.
+func NewMessageID(from string) string {
+	domain := "localhost"
+	if i := strings.LastIndex(from, "@"); i >= 0 {
+		domain = from[i+1:]
+	}
+	return fmt.Sprintf("<%d.%s@%s>", time.Now().UnixNano(), randomHex(8), domain)
+}
+
+// randomHex returns a random hex string of n bytes.
+func randomHex(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
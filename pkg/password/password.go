@@ -0,0 +1,83 @@
+// Package password validates passwords against a password policy.
+package password
+
+import (
+	_ "embed"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// DefaultMinLength is the minimum password length applied when a policy does not specify one.
+const DefaultMinLength = 8
+
+var (
+	//go:embed common.txt
+	commonList string
+	common     = parseList(commonList)
+)
+
+// Policy represents the requirements passwords must meet.
+type Policy struct {
+	// MinLength is the minimum number of characters of a password.
+	MinLength int
+	// RejectCommon rejects passwords found in the bundled list of common passwords.
+	RejectCommon bool
+}
+
+// Check returns an error describing why the password does not meet the policy, if it doesn't.
+// The email of the account is used to reject passwords that contain it.
+func (p Policy) Check(password, email string) error {
+	minLength := p.MinLength
+	if minLength <= 0 {
+		minLength = DefaultMinLength
+	}
+	if utf8.RuneCountInString(password) < minLength {
+		return fmt.Errorf("must be at least %d characters long", minLength)
+	}
+	lower := strings.ToLower(password)
+	if p.RejectCommon && IsCommon(lower) {
+		return errors.New("is too common, please choose a less predictable password")
+	}
+	if containsEmail(lower, strings.ToLower(strings.TrimSpace(email))) {
+		return errors.New("must not contain your email address")
+	}
+	return nil
+}
+
+// IsCommon returns whether the password appears in the bundled list of common passwords.
+// The comparison is case-insensitive.
+func IsCommon(password string) bool {
+	_, ok := common[strings.ToLower(password)]
+	return ok
+}
+
+// containsEmail returns whether the password contains the email or its local part.
+// Local parts shorter than three characters are ignored as they would match too many passwords.
+func containsEmail(password, email string) bool {
+	if email == "" {
+		return false
+	}
+	if strings.Contains(password, email) {
+		return true
+	}
+	local := email
+	if i := strings.LastIndex(email, "@"); i >= 0 {
+		local = email[:i]
+	}
+	return len(local) >= 3 && strings.Contains(password, local)
+}
+
+// parseList parses a list of passwords, one per line, ignoring blank lines and comments.
+func parseList(list string) map[string]struct{} {
+	m := map[string]struct{}{}
+	for _, line := range strings.Split(list, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		m[strings.ToLower(line)] = struct{}{}
+	}
+	return m
+}
@@ -0,0 +1,45 @@
+package password
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPolicy_Check(t *testing.T) {
+	policy := Policy{MinLength: 10, RejectCommon: true}
+	tests := []struct {
+		name     string
+		password string
+		email    string
+		wantErr  bool
+	}{
+		{"valid", "correct horse battery", "john@example.com", false},
+		{"too short", "Xk9#mQ2", "john@example.com", true},
+		{"counts characters not bytes", "ñññññññññññ", "john@example.com", false},
+		{"common", "Password1234", "john@example.com", true},
+		{"contains email", "xx-John@Example.com-xx", "john@example.com", true},
+		{"contains local part", "johnny-be-good-42", "john@example.com", true},
+		{"short local part ignored", "joyful evening sky", "jo@example.com", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := policy.Check(tt.password, tt.email)
+			assert.Equal(t, tt.wantErr, err != nil, err)
+		})
+	}
+}
+
+func TestPolicy_Check_defaults(t *testing.T) {
+	assert.NotNil(t, Policy{}.Check("short", ""))
+	// common passwords are only rejected when enabled
+	assert.Nil(t, Policy{}.Check("password123", ""))
+	assert.NotNil(t, Policy{RejectCommon: true}.Check("password123", ""))
+}
+
+func TestIsCommon(t *testing.T) {
+	assert.True(t, IsCommon("123456"))
+	assert.True(t, IsCommon("QWERTY"))
+	assert.False(t, IsCommon("# Most common passwords"))
+	assert.False(t, IsCommon("a perfectly unusual passphrase"))
+}
@@ -0,0 +1,65 @@
+package totp
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// rfcSecret is the base32 encoding of the SHA1 seed used by the RFC 6238 test vectors.
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func Test_codeAt(t *testing.T) {
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	}
+	for _, tc := range tests {
+		code, err := codeAt(rfcSecret, tc.unix/Period, 8)
+		assert.Nil(t, err)
+		assert.Equal(t, tc.want, code, tc.unix)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	secret, err := GenerateSecret()
+	require.Nil(t, err)
+	now := time.Now()
+
+	code, err := Code(secret, now)
+	require.Nil(t, err)
+	step, ok := Validate(secret, code, now, 1)
+	assert.True(t, ok)
+	assert.Equal(t, Step(now), step)
+
+	previous, _ := Code(secret, now.Add(-Period*time.Second))
+	_, ok = Validate(secret, previous, now, 1)
+	assert.True(t, ok)
+	_, ok = Validate(secret, previous, now, 0)
+	assert.False(t, ok)
+
+	_, ok = Validate(secret, "12345", now, 1)
+	assert.False(t, ok)
+	_, ok = Validate("not base32!", "123456", now, 1)
+	assert.False(t, ok)
+}
+
+func TestURI(t *testing.T) {
+	u, err := url.Parse(URI("Gonvelope", "user@example.com", "ABC"))
+	require.Nil(t, err)
+	assert.Equal(t, "otpauth", u.Scheme)
+	assert.Equal(t, "totp", u.Host)
+	assert.Equal(t, "/Gonvelope:user@example.com", u.Path)
+	assert.Equal(t, "ABC", u.Query().Get("secret"))
+	assert.Equal(t, "Gonvelope", u.Query().Get("issuer"))
+}